@@ -22,6 +22,7 @@ import (
 type batch struct {
 	data    [][]byte
 	meta    [][]byte
+	ids     []uint64
 	file    *os.File
 	encoder *gob.Encoder
 }
@@ -36,6 +37,7 @@ func newBatch(maxLeaves int) *batch {
 func (b *batch) append(f *fossil) {
 	b.data = append(b.data, f.Data)
 	b.meta = append(b.meta, f.Meta)
+	b.ids = append(b.ids, f.id)
 }
 
 func (b *batch) open(path string) (err error) {
@@ -54,3 +56,22 @@ func (b *batch) close() (err error) {
 	}
 	return
 }
+
+// archive writes the fossils of the batch to a new file.
+func (b *batch) archive(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_EXCL|os.O_CREATE, FilePerm)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	enc := gob.NewEncoder(file)
+	for i := range b.data {
+		f := fossil{Data: b.data[i], Meta: b.meta[i]}
+		if err := f.write(enc); err != nil {
+			return err
+		}
+	}
+
+	return file.Sync()
+}
@@ -15,6 +15,12 @@
 // Package batchfossilizer implements a fossilizer that fossilize batches of
 // data using a Merkle tree. The evidence will contain the Merkle root, the
 // Merkle path, and a timestamp.
+//
+// With a write-ahead log, every fossil gets a result: fossils whose evidence
+// couldn't be produced are added to the next batch, and evidence produced
+// before a crash is delivered on restart. Results carry the ID of their
+// fossil, and a result is only delivered again, with the same ID, if the
+// process stopped between delivering it and recording it as delivered.
 package batchfossilizer
 
 import (
@@ -25,6 +31,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 
@@ -33,6 +40,7 @@ import (
 	"github.com/stratumn/go-indigocore/cs"
 	"github.com/stratumn/go-indigocore/fossilizer"
 	"github.com/stratumn/go-indigocore/monitoring"
+	"github.com/stratumn/go-indigocore/store"
 	"github.com/stratumn/go-indigocore/types"
 	"github.com/stratumn/merkle"
 
@@ -108,6 +116,21 @@ type Config struct {
 
 	// Whether to fsync after saving a hash to disk.
 	FSync bool
+
+	// An optional key-value store used as a write-ahead log of fossils.
+	// When set, it replaces the pending hashes files and guarantees that
+	// evidence produced before a crash is delivered on restart.
+	// A fossil interrupted while its evidence was being produced is
+	// anchored again, and a result interrupted while being delivered is
+	// delivered again with the same ID, so consumers should deduplicate
+	// results by their ID.
+	WAL store.KeyValueStore
+
+	// Whether to send DidBatch events with the data and meta of every
+	// leaf of a batch. They are meant for a trusted consumer, such as a
+	// server tracking the status of fossils, so they are disabled by
+	// default.
+	BatchEvents bool
 }
 
 // GetInterval returns the configuration's interval or the default value.
@@ -147,6 +170,7 @@ type Info struct {
 // Adapter must be implemented by a fossilizer that uses batches.
 type Adapter interface {
 	fossilizer.Adapter
+	fossilizer.BatchReader
 
 	// Start starts filling batches with incoming fossils.
 	Start(ctx context.Context) error
@@ -174,6 +198,11 @@ type Fossilizer struct {
 	transformer          Transformer
 	pending              *batch
 	stopping             bool
+	wal                  *wal
+	recovered            []*batch
+	undelivered          []*FossilRecord
+	retryMutex           sync.Mutex
+	retry                []*fossil
 }
 
 // Transformer is the type of a function to transform results.
@@ -199,6 +228,13 @@ func New(config *Config) (*Fossilizer, error) {
 		if err := a.ensurePath(); err != nil {
 			return nil, err
 		}
+	}
+
+	if a.config.WAL != nil {
+		if err := a.recoverWAL(context.Background()); err != nil {
+			return nil, err
+		}
+	} else if a.config.Path != "" {
 		if err := a.recover(); err != nil {
 			return nil, err
 		}
@@ -240,6 +276,8 @@ func (a *Fossilizer) Start(ctx context.Context) error {
 		timer    = time.NewTimer(interval)
 	)
 
+	a.resume(ctx)
+
 	for {
 		select {
 		case c := <-a.startedChan:
@@ -255,6 +293,7 @@ func (a *Fossilizer) Start(ctx context.Context) error {
 		case <-timer.C:
 			timer.Stop()
 			timer.Reset(interval)
+			a.requeue()
 			if len(a.pending.data) > 0 {
 				a.sendBatch()
 				log.WithField("interval", interval).Info("Requested new batch because the timer interval was reached")
@@ -290,8 +329,72 @@ func (a *Fossilizer) SetTransformer(t Transformer) {
 	}
 }
 
+// GetBatch implements github.com/stratumn/go-indigocore/fossilizer.BatchReader.GetBatch.
+// Batches are only available when they are archived.
+func (a *Fossilizer) GetBatch(ctx context.Context, root *types.Bytes32) (*fossilizer.Batch, error) {
+	if !a.config.Archive || a.config.Path == "" {
+		return nil, nil
+	}
+
+	file, err := os.Open(filepath.Join(a.config.Path, root.String()))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	b := &fossilizer.Batch{Root: root}
+	dec := gob.NewDecoder(file)
+
+	for {
+		f, err := newFossilFromDecoder(dec)
+		if err == io.EOF {
+			return b, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		b.Data = append(b.Data, f.Data)
+		b.Meta = append(b.Meta, f.Meta)
+	}
+}
+
+// FossilRecords returns the fossils of the write-ahead log whose evidence
+// was not delivered yet.
+func (a *Fossilizer) FossilRecords(ctx context.Context) ([]*FossilRecord, error) {
+	if a.wal == nil {
+		return nil, ErrNoWAL
+	}
+	return a.wal.records(ctx)
+}
+
+// StuckFossils returns the fossils of the write-ahead log whose state
+// hasn't changed for longer than the given duration.
+func (a *Fossilizer) StuckFossils(ctx context.Context, age time.Duration) ([]*FossilRecord, error) {
+	records, err := a.FossilRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var stuck []*FossilRecord
+	limit := time.Now().UTC().Add(-age)
+	for _, r := range records {
+		if r.UpdatedAt.Before(limit) {
+			stuck = append(stuck, r)
+		}
+	}
+
+	return stuck, nil
+}
+
 func (a *Fossilizer) fossilize(f *fossil) error {
-	if a.config.Path != "" {
+	if a.wal != nil {
+		if err := a.wal.append(context.Background(), f); err != nil {
+			return err
+		}
+	} else if a.config.Path != "" {
 		if a.pending.file == nil {
 			if err := a.pending.open(a.pendingPath()); err != nil {
 				return err
@@ -320,6 +423,19 @@ func (a *Fossilizer) fossilize(f *fossil) error {
 	return nil
 }
 
+// requeue adds fossils whose evidence couldn't be produced to the pending
+// batch.
+func (a *Fossilizer) requeue() {
+	a.retryMutex.Lock()
+	defer a.retryMutex.Unlock()
+
+	maxLeaves := a.config.GetMaxLeaves()
+	for len(a.retry) > 0 && len(a.pending.data) < maxLeaves {
+		a.pending.append(a.retry[0])
+		a.retry = a.retry[1:]
+	}
+}
+
 func (a *Fossilizer) sendBatch() {
 	b := a.pending
 	a.pending = newBatch(a.config.GetMaxLeaves())
@@ -354,9 +470,41 @@ func (a *Fossilizer) batch(b *batch) {
 		root := tree.Root()
 		log.WithField("root", root).Info("Created tree with Merkle root")
 
-		a.sendEvidence(ctx, tree, b.meta)
+		if a.wal != nil {
+			if err := a.wal.setBatched(ctx, b.ids, types.NewBytes32FromBytes(root)); err != nil {
+				log.WithField("error", err).Warn("Failed to save batch in write-ahead log")
+				span.SetStatus(trace.Status{Code: monitoring.Unknown, Message: err.Error()})
+			}
+		}
+
+		if a.config.BatchEvents {
+			a.emit(&fossilizer.Event{
+				EventType: fossilizer.DidBatch,
+				Data: &fossilizer.Batch{
+					Root: types.NewBytes32FromBytes(root),
+					Data: b.data,
+					Meta: b.meta,
+				},
+			})
+		}
+
+		a.sendEvidence(ctx, tree, b)
 		log.WithField("root", root).Info("Sent evidence for batch with Merkle root")
 
+		if b.file == nil && a.config.Archive && a.config.Path != "" {
+			archivePath := filepath.Join(a.config.Path, hex.EncodeToString(root))
+			if err := b.archive(archivePath); err == nil {
+				log.WithField("file", filepath.Base(archivePath)).Info("Archived batch")
+				span.Annotate(nil, "Archived batch")
+			} else {
+				log.WithFields(log.Fields{
+					"file":  filepath.Base(archivePath),
+					"error": err,
+				}).Warn("Failed to archive batch")
+				span.SetStatus(trace.Status{Code: monitoring.Unknown, Message: err.Error()})
+			}
+		}
+
 		if b.file != nil {
 			path := b.file.Name()
 
@@ -400,10 +548,17 @@ func (a *Fossilizer) batch(b *batch) {
 	}()
 }
 
-func (a *Fossilizer) sendEvidence(ctx context.Context, tree *merkle.StaticTree, meta [][]byte) {
+func (a *Fossilizer) sendEvidence(ctx context.Context, tree *merkle.StaticTree, b *batch) {
 	ctx, span := trace.StartSpan(ctx, "batchfossilizer/sendEvidence")
 	defer span.End()
 
+	var failed []int
+	defer func() {
+		if len(failed) > 0 && a.wal != nil {
+			a.retryFossils(ctx, b, failed)
+		}
+	}()
+
 	for i := 0; i < tree.LeavesLen(); i++ {
 		var (
 			err  error
@@ -411,7 +566,7 @@ func (a *Fossilizer) sendEvidence(ctx context.Context, tree *merkle.StaticTree,
 			root = tree.Root()
 			leaf = tree.Leaf(i)
 			d    = leaf[:]
-			m    = meta[i]
+			m    = b.meta[i]
 			r    *fossilizer.Result
 		)
 
@@ -428,24 +583,69 @@ func (a *Fossilizer) sendEvidence(ctx context.Context, tree *merkle.StaticTree,
 		if r, err = a.transformer(&evidence, d, m); err != nil {
 			log.WithField("error", err).Error("Failed to transform evidence")
 			span.SetStatus(trace.Status{Code: monitoring.InvalidArgument, Message: err.Error()})
-		} else {
-			event := &fossilizer.Event{
-				EventType: fossilizer.DidFossilizeLink,
-				Data:      r,
+			failed = append(failed, i)
+			continue
+		}
+
+		if a.wal != nil {
+			r.ID = fossilID(b.ids[i])
+			if err := a.wal.setAnchored(ctx, b.ids[i], r); err != nil {
+				log.WithField("error", err).Warn("Failed to save evidence in write-ahead log")
+				span.SetStatus(trace.Status{Code: monitoring.Unknown, Message: err.Error()})
 			}
+		}
 
-			a.fossilizerEventMutex.RLock()
-			defer a.fossilizerEventMutex.RUnlock()
+		a.deliver(ctx, r)
 
-			for _, c := range a.fossilizerEventChans {
-				c <- event
+		if a.wal != nil {
+			if err := a.wal.setDelivered(ctx, b.ids[i]); err != nil {
+				log.WithField("error", err).Warn("Failed to mark evidence as delivered in write-ahead log")
+				span.SetStatus(trace.Status{Code: monitoring.Unknown, Message: err.Error()})
 			}
-
-			stats.Record(ctx, fossilizedLinksCount.M(1))
 		}
 	}
 }
 
+// retryFossils makes the fossils of a batch whose evidence couldn't be
+// produced pending again so that they are added to the next batch.
+func (a *Fossilizer) retryFossils(ctx context.Context, b *batch, failed []int) {
+	ids := make([]uint64, len(failed))
+	fossils := make([]*fossil, len(failed))
+	for j, i := range failed {
+		ids[j] = b.ids[i]
+		fossils[j] = &fossil{Data: b.data[i], Meta: b.meta[i], id: b.ids[i]}
+	}
+
+	if err := a.wal.setRetry(ctx, ids); err != nil {
+		log.WithField("error", err).Warn("Failed to save fossils to retry in write-ahead log")
+		return
+	}
+
+	a.retryMutex.Lock()
+	a.retry = append(a.retry, fossils...)
+	a.retryMutex.Unlock()
+
+	log.WithField("fossils", len(fossils)).Info("Fossils will be added to the next batch")
+}
+
+func (a *Fossilizer) deliver(ctx context.Context, r *fossilizer.Result) {
+	a.emit(&fossilizer.Event{
+		EventType: fossilizer.DidFossilizeLink,
+		Data:      r,
+	})
+
+	stats.Record(ctx, fossilizedLinksCount.M(1))
+}
+
+func (a *Fossilizer) emit(event *fossilizer.Event) {
+	a.fossilizerEventMutex.RLock()
+	defer a.fossilizerEventMutex.RUnlock()
+
+	for _, c := range a.fossilizerEventChans {
+		c <- event
+	}
+}
+
 func (a *Fossilizer) stop(err error) error {
 	a.stopping = true
 	if a.config.StopBatch {
@@ -486,23 +686,9 @@ func (a *Fossilizer) recover() error {
 	}
 
 	for _, path := range matches {
-		file, err := os.OpenFile(path, os.O_RDONLY|os.O_EXCL, FilePerm)
-		if err != nil {
+		if err := a.recoverFile(path); err != nil {
 			return err
 		}
-		defer file.Close()
-
-		dec := gob.NewDecoder(file)
-
-		for {
-			f, err := newFossilFromDecoder(dec)
-			if err == io.EOF {
-				break
-			}
-			if err = a.fossilize(f); err != nil {
-				return err
-			}
-		}
 
 		a.waitGroup.Wait()
 
@@ -516,6 +702,108 @@ func (a *Fossilizer) recover() error {
 	return nil
 }
 
+func (a *Fossilizer) recoverFile(path string) error {
+	file, err := os.OpenFile(path, os.O_RDONLY|os.O_EXCL, FilePerm)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	dec := gob.NewDecoder(file)
+
+	for {
+		f, err := newFossilFromDecoder(dec)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = a.fossilize(f); err != nil {
+			return err
+		}
+	}
+}
+
+// recoverWAL loads the fossils of the write-ahead log that were not
+// delivered. Fossils that were not anchored are batched again while
+// evidence that was already produced is delivered as is.
+// Fossils in the batched state may already have been anchored if the
+// process stopped before the evidence was saved, and fossils in the
+// anchored state may already have been delivered if it stopped before they
+// were recorded as delivered.
+func (a *Fossilizer) recoverWAL(ctx context.Context) error {
+	w, err := openWAL(ctx, a.config.WAL)
+	if err != nil {
+		return err
+	}
+	a.wal = w
+
+	records, err := w.records(ctx)
+	if err != nil {
+		return err
+	}
+
+	maxLeaves := a.config.GetMaxLeaves()
+	for _, r := range records {
+		switch r.State {
+		case FossilPending, FossilBatched:
+			a.pending.append(&fossil{Data: r.Data, Meta: r.Meta, id: r.ID})
+			if len(a.pending.data) >= maxLeaves {
+				a.recovered = append(a.recovered, a.pending)
+				a.pending = newBatch(maxLeaves)
+			}
+		case FossilAnchored:
+			a.undelivered = append(a.undelivered, r)
+		}
+	}
+
+	if len(records) > 0 {
+		log.WithFields(log.Fields{
+			"fossils":     len(records),
+			"undelivered": len(a.undelivered),
+		}).Info("Recovered fossils from write-ahead log")
+	}
+
+	return nil
+}
+
+// resume delivers and batches what was recovered from the write-ahead log.
+func (a *Fossilizer) resume(ctx context.Context) {
+	for _, b := range a.recovered {
+		a.batch(b)
+	}
+	a.recovered = nil
+
+	if len(a.undelivered) == 0 {
+		return
+	}
+
+	undelivered := a.undelivered
+	a.undelivered = nil
+	a.waitGroup.Add(1)
+
+	go func() {
+		defer a.waitGroup.Done()
+		for _, r := range undelivered {
+			if r.Result.ID == "" {
+				r.Result.ID = fossilID(r.ID)
+			}
+			a.deliver(ctx, r.Result)
+			if err := a.wal.setDelivered(ctx, r.ID); err != nil {
+				log.WithField("error", err).Warn("Failed to mark evidence as delivered in write-ahead log")
+			}
+		}
+		log.WithField("fossils", len(undelivered)).Info("Delivered evidence recovered from write-ahead log")
+	}()
+}
+
+// fossilID returns the ID given to the results of a fossil of the
+// write-ahead log.
+func fossilID(id uint64) string {
+	return strconv.FormatUint(id, 10)
+}
+
 func (a *Fossilizer) pendingPath() string {
 	filename := fmt.Sprintf("%d.%s", time.Now().UTC().UnixNano(), PendingExt)
 	return filepath.Join(a.config.Path, filename)
@@ -27,7 +27,9 @@ import (
 	"github.com/pkg/errors"
 	"github.com/stratumn/go-indigocore/batchfossilizer/evidences"
 	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/dummystore"
 	"github.com/stratumn/go-indigocore/fossilizer"
+	"github.com/stratumn/go-indigocore/types"
 )
 
 func TestGetInfo(t *testing.T) {
@@ -177,6 +179,65 @@ func TestFossilize_Archive(t *testing.T) {
 	if _, err := os.Stat(archive); err != nil {
 		t.Errorf("os.Stat(): err: %s", err)
 	}
+
+	root, _ := types.NewBytes32FromString("d71f8983ad4ee170f8129f1ebcdd7440be7798d8e1c80420bf11f1eced610dba")
+	b, err := a.GetBatch(context.Background(), root)
+	if err != nil {
+		t.Fatalf("a.GetBatch(): err: %s", err)
+	}
+	if b == nil {
+		t.Fatal("a.GetBatch(): batch = nil")
+	}
+	for i, test := range tests {
+		if got, want := string(b.Data[i]), string(test.data); got != want {
+			t.Errorf("b.Data[%d] = %x want %x", i, got, want)
+		}
+		if got, want := string(b.Meta[i]), string(test.meta); got != want {
+			t.Errorf("b.Meta[%d] = %q want %q", i, got, want)
+		}
+	}
+
+	if b, err = a.GetBatch(context.Background(), &types.Bytes32{}); err != nil || b != nil {
+		t.Errorf("a.GetBatch(): batch = %v, err = %v want nil, nil", b, err)
+	}
+}
+
+func TestFossilize_BatchEvents(t *testing.T) {
+	t.Parallel()
+	for _, batchEvents := range []bool{false, true} {
+		a, err := New(&Config{Interval: interval, BatchEvents: batchEvents})
+		if err != nil {
+			t.Fatalf("New(): err: %s", err)
+		}
+		ec := make(chan *fossilizer.Event, 2)
+		a.AddFossilizerEventChan(ec)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go a.Start(ctx)
+		<-a.Started()
+
+		data := atos(sha256.Sum256([]byte("a")))
+		if err := a.Fossilize(ctx, data, []byte("test a")); err != nil {
+			t.Fatalf("a.Fossilize(): err: %s", err)
+		}
+
+		e := <-ec
+		if batchEvents {
+			if e.EventType != fossilizer.DidBatch {
+				t.Fatalf("e.EventType = %q want %q", e.EventType, fossilizer.DidBatch)
+			}
+			b := e.Data.(*fossilizer.Batch)
+			if len(b.Data) != 1 || string(b.Data[0]) != string(data) {
+				t.Errorf("b.Data = %x want [%x]", b.Data, data)
+			}
+			e = <-ec
+		}
+		if e.EventType != fossilizer.DidFossilizeLink {
+			t.Errorf("e.EventType = %q want %q", e.EventType, fossilizer.DidFossilizeLink)
+		}
+
+		cancel()
+	}
 }
 
 func TestNew_recover(t *testing.T) {
@@ -235,6 +296,322 @@ func TestNew_recover(t *testing.T) {
 	testFossilizeMultiple(t, a, tests, true, false)
 }
 
+func TestFossilize_WAL(t *testing.T) {
+	t.Parallel()
+	kv := dummystore.New(&dummystore.Config{})
+	a, err := New(&Config{Interval: interval, WAL: kv})
+	if err != nil {
+		t.Fatalf("New(): err: %s", err)
+	}
+	tests := []fossilizeTest{
+		{atos(sha256.Sum256([]byte("a"))), []byte("test a"), pathABCDE0, 0, false},
+		{atos(sha256.Sum256([]byte("b"))), []byte("test b"), pathABCDE1, 0, false},
+		{atos(sha256.Sum256([]byte("c"))), []byte("test c"), pathABCDE2, 0, false},
+		{atos(sha256.Sum256([]byte("d"))), []byte("test d"), pathABCDE3, 0, false},
+		{atos(sha256.Sum256([]byte("e"))), []byte("test e"), pathABCDE4, 0, false},
+	}
+	testFossilizeMultiple(t, a, tests, true, true)
+
+	records, err := a.FossilRecords(context.Background())
+	if err != nil {
+		t.Fatalf("a.FossilRecords(): err: %s", err)
+	}
+	if got := len(records); got != 0 {
+		t.Errorf("len(a.FossilRecords()) = %d want 0", got)
+	}
+	if a.wal.first != 5 || a.wal.next != 5 {
+		t.Errorf("wal sequence = (%d, %d) want (5, 5)", a.wal.first, a.wal.next)
+	}
+}
+
+func TestNew_recoverWAL(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	kv := dummystore.New(&dummystore.Config{})
+
+	a, err := New(&Config{Interval: interval, WAL: kv})
+	if err != nil {
+		t.Fatalf("New(): err: %s", err)
+	}
+
+	// Simulate fossils received before a crash.
+	for _, s := range []string{"a", "b", "c", "d", "e"} {
+		if err := a.fossilize(&fossil{Data: atos(sha256.Sum256([]byte(s))), Meta: []byte("test " + s)}); err != nil {
+			t.Fatalf("a.fossilize(): err: %s", err)
+		}
+	}
+
+	records, err := a.FossilRecords(ctx)
+	if err != nil {
+		t.Fatalf("a.FossilRecords(): err: %s", err)
+	}
+	if got := len(records); got != 5 {
+		t.Fatalf("len(a.FossilRecords()) = %d want 5", got)
+	}
+	for _, r := range records {
+		if r.State != FossilPending {
+			t.Errorf("r.State = %q want %q", r.State, FossilPending)
+		}
+	}
+
+	stuck, err := a.StuckFossils(ctx, time.Hour)
+	if err != nil {
+		t.Fatalf("a.StuckFossils(): err: %s", err)
+	}
+	if got := len(stuck); got != 0 {
+		t.Errorf("len(a.StuckFossils()) = %d want 0", got)
+	}
+
+	a, err = New(&Config{Interval: interval, WAL: kv})
+	if err != nil {
+		t.Fatalf("New(): err: %s", err)
+	}
+	tests := []fossilizeTest{
+		{atos(sha256.Sum256([]byte("a"))), []byte("test a"), pathABCDE0, 0, false},
+		{atos(sha256.Sum256([]byte("b"))), []byte("test b"), pathABCDE1, 0, false},
+		{atos(sha256.Sum256([]byte("c"))), []byte("test c"), pathABCDE2, 0, false},
+		{atos(sha256.Sum256([]byte("d"))), []byte("test d"), pathABCDE3, 0, false},
+		{atos(sha256.Sum256([]byte("e"))), []byte("test e"), pathABCDE4, 0, false},
+	}
+	results := testFossilizeMultiple(t, a, tests, true, false)
+
+	for _, r := range results {
+		if r.ID == "" {
+			t.Errorf("r.ID is empty")
+		}
+	}
+	if records, _ = a.FossilRecords(ctx); len(records) != 0 {
+		t.Errorf("len(a.FossilRecords()) = %d want 0", len(records))
+	}
+}
+
+func TestNew_recoverWALAnchored(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	kv := dummystore.New(&dummystore.Config{})
+
+	w, err := openWAL(ctx, kv)
+	if err != nil {
+		t.Fatalf("openWAL(): err: %s", err)
+	}
+
+	// Simulate evidence produced but not delivered before a crash.
+	f := &fossil{Data: atos(sha256.Sum256([]byte("a"))), Meta: []byte("test a")}
+	if err := w.append(ctx, f); err != nil {
+		t.Fatalf("w.append(): err: %s", err)
+	}
+	root := types.NewBytes32FromBytes(f.Data)
+	if err := w.setBatched(ctx, []uint64{f.id}, root); err != nil {
+		t.Fatalf("w.setBatched(): err: %s", err)
+	}
+	result := &fossilizer.Result{
+		Evidence: cs.Evidence{
+			Backend:  Name,
+			Provider: Name,
+			Proof:    &evidences.BatchProof{Timestamp: 42, Root: root, Path: pathA0},
+		},
+		Data: f.Data,
+		Meta: f.Meta,
+	}
+	if err := w.setAnchored(ctx, f.id, result); err != nil {
+		t.Fatalf("w.setAnchored(): err: %s", err)
+	}
+
+	transformerCalled := false
+	a, err := New(&Config{Interval: interval, WAL: kv})
+	if err != nil {
+		t.Fatalf("New(): err: %s", err)
+	}
+	a.SetTransformer(func(evidence *cs.Evidence, data, meta []byte) (*fossilizer.Result, error) {
+		transformerCalled = true
+		return nil, errors.New("evidence should not be produced again")
+	})
+
+	tests := []fossilizeTest{
+		{f.Data, f.Meta, pathA0, 0, false},
+	}
+	results := testFossilizeMultiple(t, a, tests, true, false)
+
+	if transformerCalled {
+		t.Errorf("a.transform() was called")
+	}
+	if got := results[0].Evidence.Proof.Time(); got != 42 {
+		t.Errorf("Proof.Time() = %d want 42", got)
+	}
+	if got, want := results[0].ID, fossilID(f.id); got != want {
+		t.Errorf("results[0].ID = %q want %q", got, want)
+	}
+	if records, _ := a.FossilRecords(ctx); len(records) != 0 {
+		t.Errorf("len(a.FossilRecords()) = %d want 0", len(records))
+	}
+}
+
+func TestNew_recoverWALBatched(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	kv := dummystore.New(&dummystore.Config{})
+
+	a, err := New(&Config{Interval: interval, WAL: kv})
+	if err != nil {
+		t.Fatalf("New(): err: %s", err)
+	}
+	a.SetTransformer(func(evidence *cs.Evidence, data, meta []byte) (*fossilizer.Result, error) {
+		return nil, errors.New("transformer failed")
+	})
+
+	startCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() { done <- a.Start(startCtx) }()
+	<-a.Started()
+
+	f := &fossil{Data: atos(sha256.Sum256([]byte("a"))), Meta: []byte("test a")}
+	if err := a.Fossilize(ctx, f.Data, f.Meta); err != nil {
+		t.Fatalf("a.Fossilize(): err: %s", err)
+	}
+
+	// The evidence was not produced so the fossil is added to the next
+	// batches until the fossilizer stops.
+	var records []*FossilRecord
+	for i := 0; i < 100; i++ {
+		if records, err = a.FossilRecords(ctx); err != nil {
+			t.Fatalf("a.FossilRecords(): err: %s", err)
+		}
+		if len(records) == 1 && records[0].Attempts > 1 {
+			break
+		}
+		time.Sleep(interval)
+	}
+	if len(records) != 1 || records[0].Attempts < 2 {
+		t.Fatalf("a.FossilRecords() = %v want one fossil retried twice", records)
+	}
+
+	cancel()
+	<-done
+
+	a, err = New(&Config{Interval: interval, WAL: kv})
+	if err != nil {
+		t.Fatalf("New(): err: %s", err)
+	}
+	tests := []fossilizeTest{
+		{f.Data, f.Meta, pathA0, 0, false},
+	}
+	results := testFossilizeMultiple(t, a, tests, true, false)
+
+	if got, want := results[0].ID, fossilID(records[0].ID); got != want {
+		t.Errorf("results[0].ID = %q want %q", got, want)
+	}
+	if records, _ := a.FossilRecords(ctx); len(records) != 0 {
+		t.Errorf("len(a.FossilRecords()) = %d want 0", len(records))
+	}
+}
+
+func TestNew_recoverWALDelivered(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	kv := dummystore.New(&dummystore.Config{})
+
+	w, err := openWAL(ctx, kv)
+	if err != nil {
+		t.Fatalf("openWAL(): err: %s", err)
+	}
+
+	// Simulate a delivered fossil followed by an undelivered one.
+	var fossils []*fossil
+	for _, s := range []string{"a", "b"} {
+		f := &fossil{Data: atos(sha256.Sum256([]byte(s))), Meta: []byte("test " + s)}
+		if err := w.append(ctx, f); err != nil {
+			t.Fatalf("w.append(): err: %s", err)
+		}
+		root := types.NewBytes32FromBytes(f.Data)
+		if err := w.setBatched(ctx, []uint64{f.id}, root); err != nil {
+			t.Fatalf("w.setBatched(): err: %s", err)
+		}
+		result := &fossilizer.Result{
+			Evidence: cs.Evidence{
+				Backend:  Name,
+				Provider: Name,
+				Proof:    &evidences.BatchProof{Timestamp: 42, Root: root, Path: pathA0},
+			},
+			Data: f.Data,
+			Meta: f.Meta,
+			ID:   fossilID(f.id),
+		}
+		if err := w.setAnchored(ctx, f.id, result); err != nil {
+			t.Fatalf("w.setAnchored(): err: %s", err)
+		}
+		fossils = append(fossils, f)
+	}
+	if err := w.setDelivered(ctx, fossils[0].id); err != nil {
+		t.Fatalf("w.setDelivered(): err: %s", err)
+	}
+
+	a, err := New(&Config{Interval: interval, WAL: kv})
+	if err != nil {
+		t.Fatalf("New(): err: %s", err)
+	}
+	records, err := a.FossilRecords(ctx)
+	if err != nil {
+		t.Fatalf("a.FossilRecords(): err: %s", err)
+	}
+	if len(records) != 1 || records[0].ID != fossils[1].id {
+		t.Fatalf("a.FossilRecords() = %v want fossil %d", records, fossils[1].id)
+	}
+
+	tests := []fossilizeTest{
+		{fossils[1].Data, fossils[1].Meta, pathA0, 0, false},
+	}
+	results := testFossilizeMultiple(t, a, tests, true, false)
+
+	if got, want := results[0].ID, fossilID(fossils[1].id); got != want {
+		t.Errorf("results[0].ID = %q want %q", got, want)
+	}
+	if records, _ := a.FossilRecords(ctx); len(records) != 0 {
+		t.Errorf("len(a.FossilRecords()) = %d want 0", len(records))
+	}
+}
+
+func TestOpenWAL_sequence(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	kv := dummystore.New(&dummystore.Config{})
+
+	w, err := openWAL(ctx, kv)
+	if err != nil {
+		t.Fatalf("openWAL(): err: %s", err)
+	}
+	var ids []uint64
+	for _, s := range []string{"a", "b", "c"} {
+		f := &fossil{Data: atos(sha256.Sum256([]byte(s))), Meta: []byte("test " + s)}
+		if err := w.append(ctx, f); err != nil {
+			t.Fatalf("w.append(): err: %s", err)
+		}
+		ids = append(ids, f.id)
+	}
+	for _, id := range ids[:2] {
+		if err := w.setDelivered(ctx, id); err != nil {
+			t.Fatalf("w.setDelivered(): err: %s", err)
+		}
+	}
+
+	if w, err = openWAL(ctx, kv); err != nil {
+		t.Fatalf("openWAL(): err: %s", err)
+	}
+	if w.first != 2 || w.next != 3 {
+		t.Errorf("wal sequence = (%d, %d) want (2, 3)", w.first, w.next)
+	}
+}
+
+func TestFossilRecords_NoWAL(t *testing.T) {
+	t.Parallel()
+	a, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("New(): err: %s", err)
+	}
+	if _, err := a.FossilRecords(context.Background()); err != ErrNoWAL {
+		t.Errorf("a.FossilRecords(): err = %v want %v", err, ErrNoWAL)
+	}
+}
+
 func TestSetTransformer(t *testing.T) {
 	t.Parallel()
 	a, err := New(&Config{Interval: interval})
@@ -21,6 +21,9 @@ import (
 type fossil struct {
 	Data []byte
 	Meta []byte
+
+	// id is the fossil's sequence number in the write-ahead log.
+	id uint64
 }
 
 func newFossilFromDecoder(dec *gob.Decoder) (f *fossil, err error) {
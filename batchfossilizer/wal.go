@@ -0,0 +1,305 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batchfossilizer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stratumn/go-indigocore/fossilizer"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/types"
+)
+
+// FossilState is the state of a fossil in the write-ahead log.
+type FossilState string
+
+const (
+	// FossilPending means the fossil is waiting to be batched.
+	FossilPending FossilState = "pending"
+
+	// FossilBatched means the fossil is part of a batch whose evidence is
+	// being produced. If the evidence can't be produced, the fossil is
+	// pending again and added to the next batch.
+	FossilBatched FossilState = "batched"
+
+	// FossilAnchored means the evidence was produced but was not delivered
+	// to the event channels yet. It is delivered again on recovery, even if
+	// it was already delivered.
+	FossilAnchored FossilState = "anchored"
+
+	// FossilDelivered means the evidence was sent to the event channels.
+	FossilDelivered FossilState = "delivered"
+)
+
+var (
+	// ErrNoWAL is returned when querying the write-ahead log of a
+	// fossilizer that doesn't have one.
+	ErrNoWAL = errors.New("the fossilizer has no write-ahead log")
+
+	walFirstKey     = []byte("batchfossilizer:wal:first")
+	walRecordPrefix = "batchfossilizer:wal:fossil:"
+)
+
+// FossilRecord is an entry of the write-ahead log.
+// Its ID is set on the results delivered for the fossil so that consumers
+// can deduplicate results delivered more than once.
+type FossilRecord struct {
+	ID        uint64             `json:"id"`
+	Data      []byte             `json:"data"`
+	Meta      []byte             `json:"meta"`
+	State     FossilState        `json:"state"`
+	Attempts  int                `json:"attempts,omitempty"`
+	Root      *types.Bytes32     `json:"root,omitempty"`
+	Result    *fossilizer.Result `json:"result,omitempty"`
+	UpdatedAt time.Time          `json:"updatedAt"`
+}
+
+// wal is a write-ahead log of fossils backed by a key-value store.
+// Records are indexed by a sequence number: first is the oldest record that
+// was not delivered and next is the number given to the next record.
+// Delivered records are deleted once every record before them is delivered.
+//
+// Every state change is a single write, so a crash never leaves the log
+// half updated: next isn't saved but found on open after the last record, and
+// first is saved before the records it skips are deleted.
+//
+// States are saved after the step they describe, so a crash between a step
+// and its record replays the step for the same fossil ID.
+type wal struct {
+	kv    store.KeyValueStore
+	mutex sync.Mutex
+	first uint64
+	next  uint64
+}
+
+func openWAL(ctx context.Context, kv store.KeyValueStore) (*wal, error) {
+	w := &wal{kv: kv}
+
+	var err error
+	if w.first, err = w.getSeq(ctx, walFirstKey); err != nil {
+		return nil, err
+	}
+
+	// Records are only deleted from the head of the log, so the records
+	// that were not delivered are contiguous.
+	for w.next = w.first; ; w.next++ {
+		r, err := w.get(ctx, w.next)
+		if err != nil {
+			return nil, err
+		}
+		if r == nil {
+			break
+		}
+	}
+
+	// Delivered records may remain before first after a crash.
+	for id := w.first; id > 0; id-- {
+		r, err := w.get(ctx, id-1)
+		if err != nil {
+			return nil, err
+		}
+		if r == nil {
+			break
+		}
+		if _, err := w.kv.DeleteValue(ctx, walRecordKey(id-1)); err != nil {
+			return nil, err
+		}
+	}
+
+	return w, nil
+}
+
+// append adds a pending fossil to the log and sets its ID.
+func (w *wal) append(ctx context.Context, f *fossil) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	r := &FossilRecord{
+		ID:    w.next,
+		Data:  f.Data,
+		Meta:  f.Meta,
+		State: FossilPending,
+	}
+	if err := w.put(ctx, r); err != nil {
+		return err
+	}
+
+	f.id = w.next
+	w.next++
+
+	return nil
+}
+
+// setBatched marks fossils as part of the batch with the given Merkle root.
+func (w *wal) setBatched(ctx context.Context, ids []uint64, root *types.Bytes32) error {
+	for _, id := range ids {
+		err := w.update(ctx, id, func(r *FossilRecord) {
+			r.State = FossilBatched
+			r.Root = root
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setRetry makes fossils whose evidence couldn't be produced pending again.
+func (w *wal) setRetry(ctx context.Context, ids []uint64) error {
+	for _, id := range ids {
+		err := w.update(ctx, id, func(r *FossilRecord) {
+			r.State = FossilPending
+			r.Root = nil
+			r.Attempts++
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setAnchored saves the evidence of a fossil before it is delivered.
+func (w *wal) setAnchored(ctx context.Context, id uint64, result *fossilizer.Result) error {
+	return w.update(ctx, id, func(r *FossilRecord) {
+		r.State = FossilAnchored
+		r.Result = result
+	})
+}
+
+// setDelivered marks a fossil as delivered and removes delivered records
+// from the head of the log.
+func (w *wal) setDelivered(ctx context.Context, id uint64) error {
+	err := w.update(ctx, id, func(r *FossilRecord) {
+		r.State = FossilDelivered
+	})
+	if err != nil {
+		return err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	first := w.first
+	for ; first < w.next; first++ {
+		r, err := w.get(ctx, first)
+		if err != nil {
+			return err
+		}
+		if r != nil && r.State != FossilDelivered {
+			break
+		}
+	}
+	if first == w.first {
+		return nil
+	}
+
+	if err := w.setSeq(ctx, walFirstKey, first); err != nil {
+		return err
+	}
+	prev := w.first
+	w.first = first
+
+	for id := prev; id < first; id++ {
+		if _, err := w.kv.DeleteValue(ctx, walRecordKey(id)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// records returns all the records that were not delivered.
+func (w *wal) records(ctx context.Context) ([]*FossilRecord, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	var records []*FossilRecord
+	for id := w.first; id < w.next; id++ {
+		r, err := w.get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil && r.State != FossilDelivered {
+			records = append(records, r)
+		}
+	}
+
+	return records, nil
+}
+
+func (w *wal) update(ctx context.Context, id uint64, fn func(*FossilRecord)) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	r, err := w.get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if r == nil {
+		return errors.Errorf("fossil %d not found in write-ahead log", id)
+	}
+
+	fn(r)
+
+	return w.put(ctx, r)
+}
+
+func (w *wal) get(ctx context.Context, id uint64) (*FossilRecord, error) {
+	value, err := w.kv.GetValue(ctx, walRecordKey(id))
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	var r FossilRecord
+	if err := json.Unmarshal(value, &r); err != nil {
+		return nil, errors.Wrapf(err, "could not decode fossil %d", id)
+	}
+
+	return &r, nil
+}
+
+func (w *wal) put(ctx context.Context, r *FossilRecord) error {
+	r.UpdatedAt = time.Now().UTC()
+
+	value, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	return w.kv.SetValue(ctx, walRecordKey(r.ID), value)
+}
+
+func (w *wal) getSeq(ctx context.Context, key []byte) (uint64, error) {
+	value, err := w.kv.GetValue(ctx, key)
+	if err != nil || value == nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(value), 10, 64)
+}
+
+func (w *wal) setSeq(ctx context.Context, key []byte, seq uint64) error {
+	return w.kv.SetValue(ctx, key, []byte(strconv.FormatUint(seq, 10)))
+}
+
+func walRecordKey(id uint64) []byte {
+	return []byte(fmt.Sprintf("%s%020d", walRecordPrefix, id))
+}
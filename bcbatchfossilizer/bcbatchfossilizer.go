@@ -14,11 +14,19 @@
 
 // Package bcbatchfossilizer implements a fossilizer that fossilize batches of
 // hashes on a blockchain.
+//
+// Batches can also be anchored on several blockchains at once. In that case
+// the evidence is a MultiBcBatchProof holding the transaction of each
+// backend, and a policy decides how many backends must succeed for a link to
+// be fossilized.
 package bcbatchfossilizer
 
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/stratumn/go-indigocore/batchfossilizer"
@@ -36,11 +44,35 @@ const (
 
 	// Description is the description set in the fossilizer's information.
 	Description = "Indigo's Blockchain Batch Fossilizer"
+
+	// PolicyAll requires every backend to anchor a batch.
+	PolicyAll = "all"
+
+	// PolicyAny requires at least one backend to anchor a batch.
+	PolicyAny = "any"
+
+	// DefaultAnchorTimeout is the default time to wait for backends to
+	// anchor a batch when using several backends.
+	DefaultAnchorTimeout = 5 * time.Minute
 )
 
 // Config contains configuration options for the fossilizer.
 type Config struct {
+	// The backend used to anchor batches.
 	HashTimestamper blockchain.HashTimestamper
+
+	// Optional backends used to anchor each batch simultaneously.
+	// HashTimestamper is ignored if set.
+	HashTimestampers []blockchain.HashTimestamper
+
+	// The policy deciding when a batch anchored on several backends is
+	// fossilized. It is either PolicyAll, PolicyAny or the minimum number
+	// of backends. Defaults to PolicyAll.
+	Policy string
+
+	// The time to wait for backends to anchor a batch. Backends that did
+	// not answer in time are considered failed.
+	AnchorTimeout time.Duration
 }
 
 // Info is the info returned by GetInfo.
@@ -59,6 +91,9 @@ type Fossilizer struct {
 	config            *Config
 	lastRoot          *types.Bytes32
 	lastTransactionID types.TransactionID
+	lastAnchors       []evidences.Anchor
+	threshold         int
+	backends          []string
 }
 
 // New creates an instance of a Fossilizer.
@@ -67,14 +102,21 @@ func New(config *Config, batchConfig *batchfossilizer.Config) (*Fossilizer, erro
 		return nil, fmt.Errorf("MaxSimBatches is %d want less than 2", batchConfig.MaxSimBatches)
 	}
 
+	threshold, err := parsePolicy(config.Policy, len(config.HashTimestampers))
+	if err != nil {
+		return nil, err
+	}
+
 	b, err := batchfossilizer.New(batchConfig)
 	if err != nil {
 		return nil, err
 	}
 
 	f := Fossilizer{
-		Adapter: b,
-		config:  config,
+		Adapter:   b,
+		config:    config,
+		threshold: threshold,
+		backends:  backendIDs(config.HashTimestampers),
 	}
 
 	f.SetTransformer(f.transform)
@@ -94,18 +136,34 @@ func (a *Fossilizer) GetInfo(ctx context.Context) (interface{}, error) {
 		return nil, fmt.Errorf("Unexpected batchfossilizer info %#v", batchInfo)
 	}
 
-	timestamperInfo := a.config.HashTimestamper.GetInfo()
+	var descriptions, networks []string
+	for _, ts := range a.hashTimestampers() {
+		timestamperInfo := ts.GetInfo()
+		descriptions = append(descriptions, timestamperInfo.Description)
+		networks = append(networks, timestamperInfo.Network.String())
+	}
 
 	return &Info{
 		Name:        Name,
-		Description: fmt.Sprintf("%s with %s", Description, timestamperInfo.Description),
+		Description: fmt.Sprintf("%s with %s", Description, strings.Join(descriptions, ", ")),
 		Version:     info.Version,
 		Commit:      info.Commit,
-		Blockchain:  timestamperInfo.Network.String(),
+		Blockchain:  strings.Join(networks, ", "),
 	}, nil
 }
 
+func (a *Fossilizer) hashTimestampers() []blockchain.HashTimestamper {
+	if len(a.config.HashTimestampers) > 0 {
+		return a.config.HashTimestampers
+	}
+	return []blockchain.HashTimestamper{a.config.HashTimestamper}
+}
+
 func (a *Fossilizer) transform(evidence *cs.Evidence, data, meta []byte) (*fossilizer.Result, error) {
+	if len(a.config.HashTimestampers) > 0 {
+		return a.transformMulti(evidence, data, meta)
+	}
+
 	var (
 		root = evidence.Proof.(*batchevidences.BatchProof).Root
 		txid types.TransactionID
@@ -141,3 +199,145 @@ func (a *Fossilizer) transform(evidence *cs.Evidence, data, meta []byte) (*fossi
 
 	return &r, nil
 }
+
+func (a *Fossilizer) transformMulti(evidence *cs.Evidence, data, meta []byte) (*fossilizer.Result, error) {
+	batch := evidence.Proof.(*batchevidences.BatchProof)
+
+	if a.lastRoot == nil || *batch.Root != *a.lastRoot {
+		a.lastAnchors = a.anchor(batch.Root)
+		a.lastRoot = batch.Root
+	}
+
+	proof := &evidences.MultiBcBatchProof{
+		Batch:     *batch,
+		Threshold: a.threshold,
+		Anchors:   a.lastAnchors,
+	}
+	if !proof.Satisfied() {
+		return nil, fmt.Errorf("root %s anchored on %d of %d backends, policy %q not satisfied",
+			batch.Root, proof.AnchoredCount(), len(proof.Anchors), a.config.Policy)
+	}
+
+	var providers []string
+	for _, anchor := range proof.Anchors {
+		providers = append(providers, anchor.Provider)
+	}
+
+	evidence.Provider = strings.Join(providers, ",")
+	evidence.Backend = evidences.MultiBcBatchFossilizerName
+	evidence.Proof = proof
+
+	r := fossilizer.Result{
+		Evidence: *evidence,
+		Data:     data,
+		Meta:     meta,
+	}
+
+	return &r, nil
+}
+
+// anchor timestamps a root on all the backends concurrently so that a
+// failing or slow backend does not prevent the others from anchoring it.
+func (a *Fossilizer) anchor(root *types.Bytes32) []evidences.Anchor {
+	type anchorResult struct {
+		index int
+		txid  types.TransactionID
+		err   error
+	}
+
+	timestampers := a.config.HashTimestampers
+	anchors := make([]evidences.Anchor, len(timestampers))
+	results := make(chan anchorResult, len(timestampers))
+
+	for i, ts := range timestampers {
+		anchors[i].Provider = ts.GetInfo().Network.String()
+		anchors[i].Backend = a.backends[i]
+		go func(i int, ts blockchain.HashTimestamper) {
+			txid, err := ts.TimestampHash(root)
+			results <- anchorResult{index: i, txid: txid, err: err}
+		}(i, ts)
+	}
+
+	timeout := a.config.AnchorTimeout
+	if timeout <= 0 {
+		timeout = DefaultAnchorTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	done := make([]bool, len(timestampers))
+	for range timestampers {
+		select {
+		case res := <-results:
+			done[res.index] = true
+			if res.err != nil {
+				anchors[res.index].Error = res.err.Error()
+				log.WithFields(log.Fields{
+					"provider": anchors[res.index].Provider,
+					"root":     root,
+					"error":    res.err,
+				}).Warn("Failed to broadcast transaction")
+				continue
+			}
+			anchors[res.index].TransactionID = res.txid
+			log.WithFields(log.Fields{
+				"provider": anchors[res.index].Provider,
+				"txid":     res.txid,
+				"root":     root,
+			}).Info("Broadcasted transaction")
+		case <-timer.C:
+			for i := range anchors {
+				if !done[i] {
+					anchors[i].Error = "timeout"
+					log.WithFields(log.Fields{
+						"provider": anchors[i].Provider,
+						"root":     root,
+					}).Warn("Timed out broadcasting transaction")
+				}
+			}
+			return anchors
+		}
+	}
+
+	return anchors
+}
+
+// backendIDs returns an identifier for each timestamper, made of its
+// description and network. Timestampers sharing both are told apart by their
+// position so that each of them counts as a distinct backend.
+func backendIDs(timestampers []blockchain.HashTimestamper) []string {
+	ids := make([]string, len(timestampers))
+	count := make(map[string]int)
+	for i, ts := range timestampers {
+		info := ts.GetInfo()
+		ids[i] = fmt.Sprintf("%s@%s", info.Description, info.Network)
+		count[ids[i]]++
+	}
+	for i := range ids {
+		if count[ids[i]] > 1 {
+			ids[i] = fmt.Sprintf("%s#%d", ids[i], i)
+		}
+	}
+	return ids
+}
+
+// parsePolicy returns the number of backends required by a policy, zero
+// meaning all of them.
+func parsePolicy(policy string, backends int) (int, error) {
+	switch policy {
+	case "", PolicyAll:
+		return 0, nil
+	case PolicyAny:
+		return 1, nil
+	}
+
+	threshold, err := strconv.Atoi(policy)
+	if err != nil || threshold < 1 {
+		return 0, fmt.Errorf("invalid policy %q", policy)
+	}
+	if threshold > backends {
+		return 0, fmt.Errorf("policy requires %d backends but only %d are configured", threshold, backends)
+	}
+
+	return threshold, nil
+}
@@ -18,14 +18,72 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/stratumn/go-indigocore/batchfossilizer"
+	batchevidences "github.com/stratumn/go-indigocore/batchfossilizer/evidences"
 	"github.com/stratumn/go-indigocore/bcbatchfossilizer/evidences"
+	"github.com/stratumn/go-indigocore/blockchain"
 	"github.com/stratumn/go-indigocore/blockchain/dummytimestamper"
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/fossilizer"
+	"github.com/stratumn/go-indigocore/types"
 )
 
+type failingNetwork struct{}
+
+func (failingNetwork) String() string { return "failing" }
+
+// failingTimestamper is a timestamper that always fails, or blocks until
+// its channel is closed if it has one.
+type failingTimestamper struct {
+	block chan struct{}
+}
+
+func (f failingTimestamper) GetInfo() *blockchain.Info {
+	return &blockchain.Info{Network: failingNetwork{}, Description: "Failing Timestamper"}
+}
+
+func (f failingTimestamper) TimestampHash(*types.Bytes32) (types.TransactionID, error) {
+	if f.block != nil {
+		<-f.block
+	}
+	return nil, errors.New("unavailable")
+}
+
+func newMultiFossilizer(t *testing.T, policy string, timestampers ...blockchain.HashTimestamper) *Fossilizer {
+	a, err := New(&Config{
+		HashTimestampers: timestampers,
+		Policy:           policy,
+		AnchorTimeout:    100 * time.Millisecond,
+	}, &batchfossilizer.Config{})
+	if err != nil {
+		t.Fatalf("New(): err: %s", err)
+	}
+	return a
+}
+
+// transformBatch transforms the evidence of the first leaf of a batch,
+// whose link hash is returned by testLinkHash.
+func transformBatch(a *Fossilizer) (*fossilizer.Result, error) {
+	root := pathABCDE0[len(pathABCDE0)-1].Parent
+	return a.transform(&cs.Evidence{
+		Backend:  batchfossilizer.Name,
+		Provider: batchfossilizer.Name,
+		Proof: &batchevidences.BatchProof{
+			Timestamp: time.Now().Unix(),
+			Root:      types.NewBytes32FromBytes(root),
+			Path:      pathABCDE0,
+		},
+	}, []byte("data"), []byte("meta"))
+}
+
+func testLinkHash() *types.Bytes32 {
+	return types.NewBytes32FromBytes(pathABCDE0[0].Left)
+}
+
 func TestGetInfo(t *testing.T) {
 	a, err := New(&Config{
 		HashTimestamper: dummytimestamper.Timestamper{},
@@ -113,3 +171,188 @@ func TestBcBatchProof(t *testing.T) {
 		}
 	})
 }
+
+func TestGetInfo_multi(t *testing.T) {
+	a := newMultiFossilizer(t, PolicyAny, dummytimestamper.Timestamper{}, failingTimestamper{})
+	got, err := a.GetInfo(context.Background())
+	if err != nil {
+		t.Fatalf("a.GetInfo(): err: %s", err)
+	}
+	info := got.(*Info)
+	if got, want := info.Blockchain, "dummytimestamper, failing"; got != want {
+		t.Errorf("a.GetInfo(): Blockchain = %s want %s", got, want)
+	}
+}
+
+func TestNew_invalidPolicy(t *testing.T) {
+	timestampers := []blockchain.HashTimestamper{dummytimestamper.Timestamper{}}
+	for _, policy := range []string{"zou", "0", "2"} {
+		_, err := New(&Config{
+			HashTimestampers: timestampers,
+			Policy:           policy,
+		}, &batchfossilizer.Config{})
+		if err == nil {
+			t.Errorf("New(): policy %q: err = nil want error", policy)
+		}
+	}
+}
+
+func TestTransform_multiAny(t *testing.T) {
+	a := newMultiFossilizer(t, PolicyAny, dummytimestamper.Timestamper{}, failingTimestamper{})
+
+	r, err := transformBatch(a)
+	if err != nil {
+		t.Fatalf("a.transform(): err: %s", err)
+	}
+	if got, want := r.Evidence.Backend, evidences.MultiBcBatchFossilizerName; got != want {
+		t.Errorf("Backend = %q want %q", got, want)
+	}
+
+	proof := r.Evidence.Proof.(*evidences.MultiBcBatchProof)
+	if got, want := proof.AnchoredCount(), 1; got != want {
+		t.Errorf("proof.AnchoredCount() = %d want %d", got, want)
+	}
+	if got, want := proof.Anchors[1].Error, "unavailable"; got != want {
+		t.Errorf("proof.Anchors[1].Error = %q want %q", got, want)
+	}
+	if !proof.Verify(testLinkHash()) {
+		t.Error("proof.Verify() = false want true")
+	}
+
+	split := proof.Evidences()
+	if got, want := len(split), 1; got != want {
+		t.Fatalf("len(proof.Evidences()) = %d want %d", got, want)
+	}
+	if got, want := split[0].Provider, "dummytimestamper"; got != want {
+		t.Errorf("Provider = %q want %q", got, want)
+	}
+
+	js, err := json.Marshal(r.Evidence)
+	if err != nil {
+		t.Fatalf("json.Marshal(): err: %s", err)
+	}
+	var e cs.Evidence
+	if err := json.Unmarshal(js, &e); err != nil {
+		t.Fatalf("json.Unmarshal(): err: %s", err)
+	}
+	if got, want := e.Proof.(*evidences.MultiBcBatchProof).AnchoredCount(), 1; got != want {
+		t.Errorf("AnchoredCount() = %d want %d", got, want)
+	}
+}
+
+func TestTransform_multiAll(t *testing.T) {
+	a := newMultiFossilizer(t, PolicyAll, dummytimestamper.Timestamper{}, failingTimestamper{})
+
+	if _, err := transformBatch(a); err == nil {
+		t.Error("a.transform(): err = nil want error")
+	}
+}
+
+func TestTransform_multiTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	a := newMultiFossilizer(t, "1", failingTimestamper{block: block}, dummytimestamper.Timestamper{})
+
+	r, err := transformBatch(a)
+	if err != nil {
+		t.Fatalf("a.transform(): err: %s", err)
+	}
+	proof := r.Evidence.Proof.(*evidences.MultiBcBatchProof)
+	if got, want := proof.Anchors[0].Error, "timeout"; got != want {
+		t.Errorf("proof.Anchors[0].Error = %q want %q", got, want)
+	}
+	if !proof.Anchors[1].Anchored() {
+		t.Error("proof.Anchors[1].Anchored() = false want true")
+	}
+}
+
+func TestTransform_multiSameNetwork(t *testing.T) {
+	a := newMultiFossilizer(t, PolicyAll, dummytimestamper.Timestamper{}, dummytimestamper.Timestamper{})
+
+	r, err := transformBatch(a)
+	if err != nil {
+		t.Fatalf("a.transform(): err: %s", err)
+	}
+	proof := r.Evidence.Proof.(*evidences.MultiBcBatchProof)
+	if proof.Anchors[0].Backend == proof.Anchors[1].Backend {
+		t.Errorf("proof.Anchors[].Backend = %q for both backends", proof.Anchors[0].Backend)
+	}
+}
+
+func TestMultiBcBatchProof_Verify(t *testing.T) {
+	a := newMultiFossilizer(t, PolicyAny, dummytimestamper.Timestamper{}, failingTimestamper{})
+	r, err := transformBatch(a)
+	if err != nil {
+		t.Fatalf("a.transform(): err: %s", err)
+	}
+	proof := r.Evidence.Proof.(*evidences.MultiBcBatchProof)
+	lh := testLinkHash()
+
+	t.Run("link hash", func(t *testing.T) {
+		if proof.Verify(types.NewBytes32FromBytes(pathABCDE0[0].Right)) {
+			t.Error("proof.Verify(other) = true want false")
+		}
+		if proof.Verify(nil) {
+			t.Error("proof.Verify(nil) = true want false")
+		}
+	})
+
+	t.Run("verifier threshold", func(t *testing.T) {
+		if !(&evidences.Verifier{Threshold: 1}).Verify(proof, lh) {
+			t.Error("Verify() with threshold 1 = false want true")
+		}
+		if (&evidences.Verifier{Threshold: 2}).Verify(proof, lh) {
+			t.Error("Verify() with threshold 2 = true want false")
+		}
+	})
+
+	t.Run("declared threshold is ignored", func(t *testing.T) {
+		forged := *proof
+		forged.Threshold = 0
+		forged.Anchors = []evidences.Anchor{{Provider: "failing", Error: "unavailable"}}
+		if forged.Verify(lh) {
+			t.Error("forged.Verify() = true want false")
+		}
+	})
+
+	t.Run("duplicate backends", func(t *testing.T) {
+		forged := *proof
+		forged.Anchors = []evidences.Anchor{
+			{Provider: "zou", Backend: "a", TransactionID: types.TransactionID{1}},
+			{Provider: "zou", Backend: "a", TransactionID: types.TransactionID{2}},
+		}
+		if (&evidences.Verifier{Threshold: 2}).Verify(&forged, lh) {
+			t.Error("Verify() = true want false")
+		}
+	})
+
+	t.Run("duplicate transactions", func(t *testing.T) {
+		forged := *proof
+		forged.Anchors = []evidences.Anchor{
+			{Provider: "zou", Backend: "a", TransactionID: types.TransactionID{1}},
+			{Provider: "zou", Backend: "b", TransactionID: types.TransactionID{1}},
+		}
+		if (&evidences.Verifier{Threshold: 2}).Verify(&forged, lh) {
+			t.Error("Verify() = true want false")
+		}
+	})
+
+	t.Run("backends on the same network", func(t *testing.T) {
+		forged := *proof
+		forged.Anchors = []evidences.Anchor{
+			{Provider: "zou", Backend: "a", TransactionID: types.TransactionID{1}},
+			{Provider: "zou", Backend: "b", TransactionID: types.TransactionID{2}},
+		}
+		if !(&evidences.Verifier{Threshold: 2}).Verify(&forged, lh) {
+			t.Error("Verify() = false want true")
+		}
+	})
+
+	t.Run("invalid path", func(t *testing.T) {
+		forged := *proof
+		forged.Batch.Root = types.NewBytes32FromBytes(pathABCDE0[0].Parent)
+		if forged.Verify(lh) {
+			t.Error("forged.Verify() = true want false")
+		}
+	})
+}
@@ -21,6 +21,8 @@ import (
 
 	"github.com/stratumn/go-indigocore/batchfossilizer"
 	"github.com/stratumn/go-indigocore/blockchain"
+	"github.com/stratumn/go-indigocore/leveldbstore"
+	"github.com/stratumn/go-indigocore/store"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -32,6 +34,8 @@ var (
 	archive         bool
 	exitBatch       bool
 	fsync           bool
+	walPath         string
+	batchEvents     bool
 	key             string
 	fee             int64
 	bcyAPIKey       string
@@ -47,24 +51,39 @@ func RegisterFlags() {
 	flag.BoolVar(&archive, "archive", batchfossilizer.DefaultArchive, "whether to archive completed batches (requires path)")
 	flag.BoolVar(&exitBatch, "exitbatch", batchfossilizer.DefaultStopBatch, "whether to do a batch on exit")
 	flag.BoolVar(&fsync, "fsync", batchfossilizer.DefaultFSync, "whether to fsync after saving a pending hash (requires path)")
+	flag.StringVar(&walPath, "walpath", "", "an optional path to a LevelDB write-ahead log of pending hashes")
+	flag.BoolVar(&batchEvents, "batchevents", false, "whether to send batch events, for instance to track the status of fossils")
 }
 
 // RunWithFlags should be called after RegisterFlags and flag.Parse to initialize
 // a bcbatchfossilizer using flag values.
+// Anchoring on several blockchains is configured with Config.HashTimestampers
+// when creating the fossilizer with New.
 func RunWithFlags(ctx context.Context, version, commit string, hashTS blockchain.HashTimestamper) *Fossilizer {
 	log.Infof("%s v%s@%s", Description, version, commit[:7])
 
+	var wal store.KeyValueStore
+	if walPath != "" {
+		kv, err := leveldbstore.New(&leveldbstore.Config{Path: walPath})
+		if err != nil {
+			log.WithField("error", err).Fatal("Failed to open write-ahead log")
+		}
+		wal = kv
+	}
+
 	a, err := New(&Config{
 		HashTimestamper: hashTS,
 	}, &batchfossilizer.Config{
-		Version:   version,
-		Commit:    commit,
-		Interval:  interval,
-		MaxLeaves: maxLeaves,
-		Path:      path,
-		Archive:   archive,
-		StopBatch: exitBatch,
-		FSync:     fsync,
+		Version:     version,
+		Commit:      commit,
+		Interval:    interval,
+		MaxLeaves:   maxLeaves,
+		Path:        path,
+		Archive:     archive,
+		StopBatch:   exitBatch,
+		FSync:       fsync,
+		WAL:         wal,
+		BatchEvents: batchEvents,
 	})
 	if err != nil {
 		log.WithField("error", err).Fatal("Failed to create blockchain batch fossilizer")
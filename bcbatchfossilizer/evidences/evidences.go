@@ -16,6 +16,8 @@
 package evidences
 
 import (
+	"bytes"
+	"encoding/hex"
 	"encoding/json"
 
 	batchevidences "github.com/stratumn/go-indigocore/batchfossilizer/evidences"
@@ -57,6 +59,176 @@ func (p *BcBatchProof) Verify(linkHash interface{}) bool {
 	return true
 }
 
+var (
+	// MultiBcBatchFossilizerName is the name used as the MultiBcBatchProof
+	// backend.
+	MultiBcBatchFossilizerName = "multibcbatch"
+)
+
+// Anchor is the result of timestamping a batch root on one backend.
+// Backend identifies the timestamper that produced the anchor. It differs
+// between the backends of a fossilizer even when they use the same network,
+// which is given by Provider.
+type Anchor struct {
+	Provider      string              `json:"provider"`
+	Backend       string              `json:"backend,omitempty"`
+	TransactionID types.TransactionID `json:"txid,omitempty"`
+	Error         string              `json:"error,omitempty"`
+}
+
+// Anchored returns true if the batch root was timestamped on the backend.
+func (a *Anchor) Anchored() bool {
+	return a.Error == "" && len(a.TransactionID) > 0
+}
+
+// MultiBcBatchProof implements the Proof interface for a batch anchored on
+// several blockchains. Threshold is the number of anchors the fossilizer
+// required when producing the proof, zero meaning all of them. It is
+// informative only: verifiers choose their own threshold (see Verifier).
+type MultiBcBatchProof struct {
+	Batch     batchevidences.BatchProof `json:"batch"`
+	Threshold int                       `json:"threshold"`
+	Anchors   []Anchor                  `json:"anchors"`
+}
+
+// Time returns the timestamp of the batch
+func (p *MultiBcBatchProof) Time() uint64 {
+	return uint64(p.Batch.Timestamp)
+}
+
+// FullProof returns a JSON formatted proof
+func (p *MultiBcBatchProof) FullProof() []byte {
+	bytes, err := json.MarshalIndent(p, "", "   ")
+	if err != nil {
+		return nil
+	}
+	return bytes
+}
+
+// Verify returns true if the batch path leads from the given link hash to
+// the batch root and the root was anchored on at least as many providers as
+// required by DefaultVerifier.
+func (p *MultiBcBatchProof) Verify(linkHash interface{}) bool {
+	return DefaultVerifier.Verify(p, linkHash)
+}
+
+// Satisfied returns true if enough backends anchored the batch root
+// according to the threshold of the proof. It is used by the fossilizer and
+// must not be used to verify proofs received from third parties.
+func (p *MultiBcBatchProof) Satisfied() bool {
+	threshold := p.Threshold
+	if threshold <= 0 {
+		threshold = len(p.Anchors)
+	}
+	return len(p.Anchors) > 0 && p.AnchoredCount() >= threshold
+}
+
+// AnchoredCount returns the number of backends that anchored the batch root.
+func (p *MultiBcBatchProof) AnchoredCount() int {
+	n := 0
+	for i := range p.Anchors {
+		if p.Anchors[i].Anchored() {
+			n++
+		}
+	}
+	return n
+}
+
+// DefaultVerifier is the verifier used by MultiBcBatchProof.Verify.
+var DefaultVerifier = &Verifier{Threshold: 1}
+
+// Verifier verifies multiple blockchain batch proofs.
+type Verifier struct {
+	// The minimum number of distinct providers that must have anchored the
+	// batch root. Values lower than one require one provider.
+	Threshold int
+}
+
+// Verify returns true if the batch path of the proof leads from the given
+// link hash to the batch root and the root was anchored by at least
+// Threshold distinct backends. Anchors without a backend, produced by older
+// fossilizers, are identified by their provider. A transaction listed
+// several times is only counted once. The threshold declared in the proof
+// is ignored.
+// Transactions themselves must be checked against their blockchain.
+func (v *Verifier) Verify(p *MultiBcBatchProof, linkHash interface{}) bool {
+	lh, ok := linkHash.(*types.Bytes32)
+	if !ok || !commitsTo(&p.Batch, lh) {
+		return false
+	}
+
+	threshold := v.Threshold
+	if threshold < 1 {
+		threshold = 1
+	}
+
+	backends := make(map[string]struct{})
+	transactions := make(map[string]struct{})
+	for i := range p.Anchors {
+		a := &p.Anchors[i]
+		if !a.Anchored() {
+			continue
+		}
+		tx := a.Provider + ":" + hex.EncodeToString(a.TransactionID)
+		if _, ok := transactions[tx]; ok {
+			continue
+		}
+		transactions[tx] = struct{}{}
+
+		backend := a.Backend
+		if backend == "" {
+			backend = a.Provider
+		}
+		backends[backend] = struct{}{}
+	}
+
+	return len(backends) >= threshold
+}
+
+// commitsTo returns true if the batch path is valid and leads from the link
+// hash to the batch root.
+func commitsTo(batch *batchevidences.BatchProof, linkHash *types.Bytes32) bool {
+	if batch.Root == nil {
+		return false
+	}
+	if len(batch.Path) == 0 {
+		return batch.Root.EqualsBytes(linkHash[:])
+	}
+	if err := batch.Path.Validate(); err != nil {
+		return false
+	}
+
+	cur := linkHash[:]
+	for _, node := range batch.Path {
+		if !bytes.Equal(cur, node.Left) && !bytes.Equal(cur, node.Right) {
+			return false
+		}
+		cur = node.Parent
+	}
+
+	return batch.Root.EqualsBytes(cur)
+}
+
+// Evidences splits the proof into one evidence per backend that anchored
+// the batch root.
+func (p *MultiBcBatchProof) Evidences() cs.Evidences {
+	var evidences cs.Evidences
+	for _, a := range p.Anchors {
+		if !a.Anchored() {
+			continue
+		}
+		evidences = append(evidences, &cs.Evidence{
+			Backend:  BcBatchFossilizerName,
+			Provider: a.Provider,
+			Proof: &BcBatchProof{
+				Batch:         p.Batch,
+				TransactionID: a.TransactionID,
+			},
+		})
+	}
+	return evidences
+}
+
 func init() {
 	cs.DeserializeMethods[BcBatchFossilizerName] = func(rawProof json.RawMessage) (cs.Proof, error) {
 		p := BcBatchProof{}
@@ -65,4 +237,11 @@ func init() {
 		}
 		return &p, nil
 	}
+	cs.DeserializeMethods[MultiBcBatchFossilizerName] = func(rawProof json.RawMessage) (cs.Proof, error) {
+		p := MultiBcBatchProof{}
+		if err := json.Unmarshal(rawProof, &p); err != nil {
+			return nil, err
+		}
+		return &p, nil
+	}
 }
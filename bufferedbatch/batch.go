@@ -123,6 +123,10 @@ func (b *Batch) GetMapIDs(ctx context.Context, filter *store.MapFilter) (_ []str
 }
 
 // Write implements github.com/stratumn/go-indigocore/store.Batch.Write.
+// All the links are hashed before any of them is written, so an invalid
+// link doesn't leave a partial batch in the store. But since links are then
+// written one by one, stores that need to be atomic even when a write fails
+// should implement their own Write.
 func (b *Batch) Write(ctx context.Context) (err error) {
 	ctx, span := trace.StartSpan(ctx, "bufferedbatch/Write")
 	defer monitoring.SetSpanStatusAndEnd(span, err)
@@ -130,12 +134,20 @@ func (b *Batch) Write(ctx context.Context) (err error) {
 	stats.Record(ctx, linksPerBatch.M(int64(len(b.Links))))
 
 	for _, link := range b.Links {
-		_, err = b.originalStore.CreateLink(ctx, link)
-		if err != nil {
+		if _, err = link.Hash(); err != nil {
 			break
 		}
 	}
 
+	if err == nil {
+		for _, link := range b.Links {
+			_, err = b.originalStore.CreateLink(ctx, link)
+			if err != nil {
+				break
+			}
+		}
+	}
+
 	if err == nil {
 		ctx, _ = tag.New(ctx, tag.Upsert(writeStatus, "success"))
 	} else {
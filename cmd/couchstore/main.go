@@ -22,6 +22,7 @@ import (
 
 	log "github.com/sirupsen/logrus"
 	"github.com/stratumn/go-indigocore/couchstore"
+	"github.com/stratumn/go-indigocore/encryptedstore"
 	_ "github.com/stratumn/go-indigocore/fossilizer/evidences"
 	"github.com/stratumn/go-indigocore/monitoring"
 	"github.com/stratumn/go-indigocore/store/storehttp"
@@ -36,6 +37,7 @@ var (
 
 func init() {
 	storehttp.RegisterFlags()
+	encryptedstore.RegisterFlags()
 	monitoring.RegisterFlags()
 }
 
@@ -70,5 +72,5 @@ func main() {
 		log.Fatal(storeErr)
 	}
 
-	storehttp.RunWithFlags(monitoring.NewStoreAdapter(a, "couchstore"))
+	storehttp.RunWithFlags(monitoring.NewStoreAdapter(encryptedstore.WrapWithFlags(a), "couchstore"))
 }
@@ -0,0 +1,70 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The command dummysolo starts a storehttp server with a solo sequencer
+// writing to a dummystore.
+package main
+
+import (
+	"context"
+	"flag"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stratumn/go-indigocore/dummystore"
+	_ "github.com/stratumn/go-indigocore/fossilizer/evidences"
+	"github.com/stratumn/go-indigocore/monitoring"
+	"github.com/stratumn/go-indigocore/store/storehttp"
+	"github.com/stratumn/go-indigocore/tmpop/solo"
+	"github.com/stratumn/go-indigocore/validator"
+)
+
+var (
+	version = "x.x.x"
+	commit  = "00000000000000000000000000000000"
+)
+
+func init() {
+	storehttp.RegisterFlags()
+	monitoring.RegisterFlags()
+	validator.RegisterFlags()
+	solo.RegisterFlags()
+}
+
+func main() {
+	flag.Parse()
+	log.Infof("%s v%s@%s", solo.Description, version, commit[:7])
+
+	config, err := solo.ConfigurationFromFlags()
+	if err != nil {
+		log.Fatal(err)
+	}
+	config.Version = version
+	config.Commit = commit
+	config.Validation = validator.ConfigurationFromFlags()
+
+	ctx := context.Background()
+	a := dummystore.New(&dummystore.Config{Version: version, Commit: commit})
+	s, err := solo.New(ctx, a, a, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	go func() {
+		if err := s.Run(ctx); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	storehttp.RunWithFlags(monitoring.NewStoreAdapter(s, "solo"))
+}
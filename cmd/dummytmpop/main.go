@@ -35,6 +35,7 @@ func init() {
 	tendermint.RegisterFlags()
 	monitoring.RegisterFlags()
 	validator.RegisterFlags()
+	tmpop.RegisterFlags()
 }
 
 func main() {
@@ -42,10 +43,12 @@ func main() {
 
 	a := dummystore.New(&dummystore.Config{Version: version, Commit: commit})
 	tmpopConfig := &tmpop.Config{
-		Commit:     commit,
-		Version:    version,
-		Validation: validator.ConfigurationFromFlags(),
-		Monitoring: monitoring.ConfigurationFromFlags(),
+		Commit:          commit,
+		Version:         version,
+		Validation:      validator.ConfigurationFromFlags(),
+		Monitoring:      monitoring.ConfigurationFromFlags(),
+		Snapshot:        tmpop.SnapshotConfigurationFromFlags(),
+		EventsRetention: tmpop.EventsRetentionFromFlags(),
 	}
 	tmpop.Run(
 		monitoring.NewStoreAdapter(a, "dummystore"),
@@ -22,6 +22,7 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/stratumn/go-indigocore/elasticsearchstore"
+	"github.com/stratumn/go-indigocore/encryptedstore"
 	_ "github.com/stratumn/go-indigocore/fossilizer/evidences"
 	"github.com/stratumn/go-indigocore/monitoring"
 	"github.com/stratumn/go-indigocore/store/storehttp"
@@ -35,6 +36,7 @@ var (
 func init() {
 	storehttp.RegisterFlags()
 	elasticsearchstore.RegisterFlags()
+	encryptedstore.RegisterFlags()
 	monitoring.RegisterFlags()
 }
 
@@ -44,7 +46,7 @@ func main() {
 	log.Infof("%s v%s@%s", elasticsearchstore.Description, version, commit[:7])
 
 	a := monitoring.NewStoreAdapter(
-		elasticsearchstore.InitializeWithFlags(version, commit),
+		encryptedstore.WrapWithFlags(elasticsearchstore.InitializeWithFlags(version, commit)),
 		"elasticsearchstore",
 	)
 	storehttp.RunWithFlags(a)
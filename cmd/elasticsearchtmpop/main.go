@@ -36,6 +36,7 @@ func init() {
 	elasticsearchstore.RegisterFlags()
 	monitoring.RegisterFlags()
 	validator.RegisterFlags()
+	tmpop.RegisterFlags()
 }
 
 func main() {
@@ -43,10 +44,12 @@ func main() {
 
 	a := elasticsearchstore.InitializeWithFlags(version, commit)
 	tmpopConfig := &tmpop.Config{
-		Commit:     commit,
-		Version:    version,
-		Validation: validator.ConfigurationFromFlags(),
-		Monitoring: monitoring.ConfigurationFromFlags(),
+		Commit:          commit,
+		Version:         version,
+		Validation:      validator.ConfigurationFromFlags(),
+		Monitoring:      monitoring.ConfigurationFromFlags(),
+		Snapshot:        tmpop.SnapshotConfigurationFromFlags(),
+		EventsRetention: tmpop.EventsRetentionFromFlags(),
 	}
 	tmpop.Run(
 		monitoring.NewStoreAdapter(a, "elasticsearchstore"),
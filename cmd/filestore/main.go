@@ -16,6 +16,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 
 	log "github.com/sirupsen/logrus"
@@ -26,9 +27,10 @@ import (
 )
 
 var (
-	path    = flag.String("path", filestore.DefaultPath, "Path to directory where files are stored")
-	version = "x.x.x"
-	commit  = "00000000000000000000000000000000"
+	path         = flag.String("path", filestore.DefaultPath, "Path to directory where files are stored")
+	rebuildIndex = flag.Bool("rebuild_index", false, "Rebuild the index from the files and exit")
+	version      = "x.x.x"
+	commit       = "00000000000000000000000000000000"
 )
 
 func init() {
@@ -47,5 +49,12 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if *rebuildIndex {
+		if err := a.RebuildIndex(context.Background()); err != nil {
+			log.Fatal(err)
+		}
+		log.Info("Index rebuilt")
+		return
+	}
 	storehttp.RunWithFlags(monitoring.NewStoreAdapter(a, "filestore"))
 }
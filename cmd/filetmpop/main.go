@@ -37,6 +37,7 @@ func init() {
 	tendermint.RegisterFlags()
 	monitoring.RegisterFlags()
 	validator.RegisterFlags()
+	tmpop.RegisterFlags()
 }
 
 func main() {
@@ -48,10 +49,12 @@ func main() {
 	}
 
 	tmpopConfig := &tmpop.Config{
-		Commit:     commit,
-		Version:    version,
-		Validation: validator.ConfigurationFromFlags(),
-		Monitoring: monitoring.ConfigurationFromFlags(),
+		Commit:          commit,
+		Version:         version,
+		Validation:      validator.ConfigurationFromFlags(),
+		Monitoring:      monitoring.ConfigurationFromFlags(),
+		Snapshot:        tmpop.SnapshotConfigurationFromFlags(),
+		EventsRetention: tmpop.EventsRetentionFromFlags(),
 	}
 	tmpop.Run(
 		monitoring.NewStoreAdapter(a, "filestore"),
@@ -20,9 +20,12 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/stratumn/go-indigocore/encryptedstore"
 	_ "github.com/stratumn/go-indigocore/fossilizer/evidences"
 	"github.com/stratumn/go-indigocore/monitoring"
 	"github.com/stratumn/go-indigocore/postgresstore"
+	"github.com/stratumn/go-indigocore/replicastore"
+	"github.com/stratumn/go-indigocore/store"
 	"github.com/stratumn/go-indigocore/store/storehttp"
 )
 
@@ -34,6 +37,7 @@ var (
 func init() {
 	storehttp.RegisterFlags()
 	postgresstore.RegisterFlags()
+	encryptedstore.RegisterFlags()
 	monitoring.RegisterFlags()
 }
 
@@ -42,9 +46,30 @@ func main() {
 
 	log.Infof("%s v%s@%s", postgresstore.Description, version, commit[:7])
 
-	a := monitoring.NewStoreAdapter(
-		postgresstore.InitializeWithFlags(version, commit),
-		"postgresstore",
-	)
-	storehttp.RunWithFlags(a)
+	var s store.Adapter = postgresstore.InitializeWithFlags(version, commit)
+	var configure []func(*storehttp.Config)
+
+	if replicas := postgresstore.InitializeReplicasWithFlags(version, commit); len(replicas) > 0 {
+		config := &replicastore.Config{Primary: s}
+		for _, r := range replicas {
+			config.Replicas = append(config.Replicas, r)
+		}
+		rs, err := replicastore.New(config)
+		if err != nil {
+			log.WithField("error", err).Fatal("Failed to create replica store")
+		}
+		defer rs.Close()
+		log.Infof("Reading from %d replicas", len(replicas))
+		s = rs
+
+		if postgresstore.ReadYourWritesFromFlags() {
+			sessions := replicastore.NewSessions(replicastore.DefaultSessionTTL)
+			configure = append(configure, func(c *storehttp.Config) {
+				c.RequestContext = sessions.RequestContext
+			})
+		}
+	}
+
+	a := monitoring.NewStoreAdapter(encryptedstore.WrapWithFlags(s), "postgresstore")
+	storehttp.RunWithFlags(a, configure...)
 }
@@ -23,13 +23,19 @@ import (
 	_ "github.com/stratumn/go-indigocore/fossilizer/evidences"
 	"github.com/stratumn/go-indigocore/monitoring"
 	"github.com/stratumn/go-indigocore/store/storehttp"
+	"github.com/stratumn/go-indigocore/tmpop/evidences"
 	"github.com/stratumn/go-indigocore/tmstore"
 	"github.com/tendermint/tendermint/rpc/client"
+	tmtypes "github.com/tendermint/tendermint/types"
 )
 
 var (
 	endpoint          = flag.String("endpoint", tmstore.DefaultEndpoint, "Endpoint used to communicate with Tendermint Core")
 	tmWsRetryInterval = flag.Duration("tm_ws_retry_interval", tmstore.DefaultWsRetryInterval, "Interval between tendermint websocket connection tries")
+	verifyProofs      = flag.Bool("verify_proofs", false, "Verify the inclusion proofs of segments returned by TMPoP")
+	requireProofs     = flag.Bool("require_proofs", false, "Reject segments returned by TMPoP without a verified inclusion proof")
+	consensusEvidence = flag.Bool("consensus_evidence", false, "Add evidence through TMPoP transactions replicated by all the nodes")
+	genesis           = flag.String("genesis", "", "Tendermint genesis file whose validators are trusted to verify inclusion proofs")
 	version           = "x.x.x"
 	commit            = "00000000000000000000000000000000"
 )
@@ -43,15 +49,38 @@ func main() {
 	flag.Parse()
 	log.Infof("%s v%s@%s", tmstore.Description, version, commit[:7])
 
+	config := &tmstore.Config{
+		Version:           version,
+		Commit:            commit,
+		VerifyProofs:      *verifyProofs,
+		RequireProofs:     *requireProofs,
+		ConsensusEvidence: *consensusEvidence,
+	}
+	if *genesis != "" {
+		config.Verifier = newVerifier(*genesis)
+	} else if *verifyProofs || *requireProofs {
+		log.Fatal("Verifying inclusion proofs requires a genesis file")
+	}
+
 	tmClient := client.NewHTTP(*endpoint, "/websocket")
-	a := tmstore.New(
-		&tmstore.Config{
-			Version: version,
-			Commit:  commit,
-		},
-		tmClient)
+	a := tmstore.New(config, tmClient)
 
 	go a.RetryStartWebsocket(context.Background(), *tmWsRetryInterval)
 
 	storehttp.RunWithFlags(monitoring.NewStoreAdapter(a, "tmstore"))
 }
+
+// newVerifier creates a verifier trusting the validators of a genesis file.
+func newVerifier(path string) *evidences.Verifier {
+	doc, err := tmtypes.GenesisDocFromFile(path)
+	if err != nil {
+		log.WithField("error", err).Fatal("Failed to load genesis file")
+	}
+
+	validators := make([]*tmtypes.Validator, len(doc.Validators))
+	for i, v := range doc.Validators {
+		validators[i] = tmtypes.NewValidator(v.PubKey, v.Power)
+	}
+
+	return evidences.NewVerifier(doc.ChainID, 1, tmtypes.NewValidatorSet(validators))
+}
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -33,6 +34,7 @@ const (
 	statusError           = 400
 	statusDBExists        = 412
 	statusDocumentMissing = 404
+	statusConflict        = 409
 	statusDBMissing       = 404
 
 	dbLink      = "pop_link"
@@ -134,6 +136,109 @@ func (c *CouchStore) deleteDatabase(name string) error {
 	return nil
 }
 
+// BulkResult is the result of saving one document of a bulk request.
+type BulkResult struct {
+	ID       string `json:"id"`
+	Revision string `json:"rev,omitempty"`
+	Ok       bool   `json:"ok,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// createLinks saves links in a single bulk request.
+// CouchDB doesn't have transactions, so if one of the links can't be saved,
+// the documents that were saved by the request are deleted.
+func (c *CouchStore) createLinks(links []*cs.Link) error {
+	var (
+		docs    []*Document
+		linkIDs = map[string]struct{}{}
+		mapIDs  = map[string]struct{}{}
+	)
+
+	for _, link := range links {
+		linkHash, err := link.Hash()
+		if err != nil {
+			return err
+		}
+		linkHashStr := linkHash.String()
+
+		if _, exists := linkIDs[linkHashStr]; exists {
+			continue
+		}
+
+		currentLinkDoc, err := c.getDocument(dbLink, linkHashStr)
+		if err != nil {
+			return err
+		}
+		if currentLinkDoc != nil {
+			return errors.Errorf("Link is immutable, %s already exists", linkHashStr)
+		}
+
+		linkIDs[linkHashStr] = struct{}{}
+		docs = append(docs, &Document{
+			ObjectType: objectTypeLink,
+			Link:       link,
+			ID:         linkHashStr,
+		})
+
+		if _, exists := mapIDs[link.Meta.MapID]; !exists {
+			mapIDs[link.Meta.MapID] = struct{}{}
+			docs = append(docs, &Document{
+				ObjectType: objectTypeMap,
+				ID:         link.Meta.MapID,
+				Process:    link.Meta.Process,
+			})
+		}
+	}
+
+	if len(docs) == 0 {
+		return nil
+	}
+
+	results, err := c.bulkSaveDocuments(dbLink, docs)
+	if err != nil {
+		return err
+	}
+
+	// Map documents already exist if the map was created before, which
+	// isn't an error.
+	i := 0
+	for _, res := range results {
+		if _, isLink := linkIDs[res.ID]; !isLink {
+			continue
+		}
+		if c.testHookWriteLink != nil {
+			if err = c.testHookWriteLink(i); err != nil {
+				break
+			}
+		}
+		i++
+		if !res.Ok {
+			err = errors.Errorf("could not save link %s: %s %s", res.ID, res.Error, res.Reason)
+			break
+		}
+	}
+	if err == nil {
+		return nil
+	}
+
+	var failed []string
+	for _, res := range results {
+		if !res.Ok {
+			continue
+		}
+		if _, deleteErr := c.deleteDocument(dbLink, res.ID); deleteErr != nil {
+			failed = append(failed, fmt.Sprintf("%s (%s)", res.ID, deleteErr))
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.Errorf("%s, and documents could not be deleted: %s", err, strings.Join(failed, ", "))
+	}
+
+	return err
+}
+
 func (c *CouchStore) createLink(link *cs.Link) (*types.Bytes32, error) {
 	linkHash, err := link.Hash()
 	if err != nil {
@@ -231,6 +336,35 @@ func (c *CouchStore) saveDocuments(dbName string, docs []*Document) error {
 	return err
 }
 
+// bulkSaveDocuments saves documents and returns the result of each of them.
+func (c *CouchStore) bulkSaveDocuments(dbName string, docs []*Document) ([]*BulkResult, error) {
+	bulkDocuments := BulkDocuments{
+		Documents: docs,
+	}
+
+	path := fmt.Sprintf("/%v/_bulk_docs", dbName)
+
+	docsBytes, err := json.Marshal(bulkDocuments)
+	if err != nil {
+		return nil, err
+	}
+
+	body, couchResponseStatus, err := c.post(path, docsBytes)
+	if err != nil {
+		return nil, err
+	}
+	if couchResponseStatus.Ok == false {
+		return nil, couchResponseStatus.error()
+	}
+
+	var results []*BulkResult
+	if err := json.Unmarshal(body, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
 func (c *CouchStore) getDocument(dbName string, key string) (*Document, error) {
 	doc := &Document{}
 	path := fmt.Sprintf("/%v/%v", dbName, key)
@@ -0,0 +1,57 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package couchstore
+
+import (
+	"context"
+
+	"github.com/stratumn/go-indigocore/bufferedbatch"
+	"github.com/stratumn/go-indigocore/monitoring"
+	"github.com/stratumn/go-indigocore/store"
+	"go.opencensus.io/trace"
+)
+
+// Batch is the type that implements github.com/stratumn/go-indigocore/store.Batch.
+type Batch struct {
+	*bufferedbatch.Batch
+
+	originalCouchStore *CouchStore
+}
+
+// NewBatch creates a new Batch
+func NewBatch(ctx context.Context, c *CouchStore) *Batch {
+	return &Batch{
+		Batch:              bufferedbatch.NewBatch(ctx, c),
+		originalCouchStore: c,
+	}
+}
+
+// Write implements github.com/stratumn/go-indigocore/store.Batch.Write.
+// The links of the batch are saved in a single bulk request, and are
+// deleted if one of them can't be saved.
+func (b *Batch) Write(ctx context.Context) (err error) {
+	ctx, span := trace.StartSpan(ctx, "couchstore/batch/Write")
+	defer monitoring.SetSpanStatusAndEnd(span, err)
+
+	if err = b.originalCouchStore.createLinks(b.Links); err != nil {
+		return
+	}
+
+	if len(b.Links) > 0 {
+		b.originalCouchStore.notifyEvent(store.NewSavedLinks(b.Links...))
+	}
+
+	return
+}
@@ -21,7 +21,6 @@ import (
 	"fmt"
 	"sort"
 
-	"github.com/stratumn/go-indigocore/bufferedbatch"
 	"github.com/stratumn/go-indigocore/cs"
 	"github.com/stratumn/go-indigocore/store"
 	"github.com/stratumn/go-indigocore/types"
@@ -39,6 +38,10 @@ const (
 type CouchStore struct {
 	config     *Config
 	eventChans []chan *store.Event
+
+	// testHookWriteLink is called by tests when checking that the i-th link
+	// of a batch was saved, to make the write fail.
+	testHookWriteLink func(i int) error
 }
 
 // CouchNotReadyError is returned when couchdb is not ready.
@@ -263,6 +266,30 @@ func (c *CouchStore) SetValue(ctx context.Context, key, value []byte) error {
 	return c.saveDocument(dbValue, hexKey, newValueDoc)
 }
 
+// CreateValue implements github.com/stratumn/go-indigocore/store.KeyValueCreator.CreateValue.
+// The document is saved without a revision, so CouchDB refuses it if the
+// key already exists.
+func (c *CouchStore) CreateValue(ctx context.Context, key, value []byte) (bool, error) {
+	docBytes, err := json.Marshal(Document{Value: value})
+	if err != nil {
+		return false, err
+	}
+
+	path := fmt.Sprintf("/%v/%v", dbValue, hex.EncodeToString(key))
+	_, couchResponseStatus, err := c.put(path, docBytes)
+	if err != nil {
+		return false, err
+	}
+	if couchResponseStatus.StatusCode == statusConflict {
+		return false, nil
+	}
+	if !couchResponseStatus.Ok {
+		return false, couchResponseStatus.error()
+	}
+
+	return true, nil
+}
+
 // GetValue implements github.com/stratumn/go-indigocore/store.Adapter.GetValue.
 func (c *CouchStore) GetValue(ctx context.Context, key []byte) ([]byte, error) {
 	hexKey := hex.EncodeToString(key)
@@ -297,5 +324,5 @@ func (c *CouchStore) DeleteValue(ctx context.Context, key []byte) ([]byte, error
 
 // NewBatch implements github.com/stratumn/go-indigocore/store.Adapter.NewBatch.
 func (c *CouchStore) NewBatch(ctx context.Context) (store.Batch, error) {
-	return bufferedbatch.NewBatch(ctx, c), nil
+	return NewBatch(ctx, c), nil
 }
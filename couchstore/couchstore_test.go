@@ -95,6 +95,7 @@ func TestCouchStore(t *testing.T) {
 		NewKeyValueStore:  newTestCouchStoreKeyValue,
 		Free:              freeTestCouchStoreAdapter,
 		FreeKeyValueStore: freeTestCouchStoreKeyValue,
+		FailWrites:        failTestCouchStoreWrites,
 	}
 
 	factory.RunStoreTests(t)
@@ -145,6 +146,10 @@ func freeTestCouchStoreKeyValue(a store.KeyValueStore) {
 	freeTestCouchStore(a.(*CouchStore))
 }
 
+func failTestCouchStoreWrites(a store.Adapter, n int) {
+	a.(*CouchStore).testHookWriteLink = storetestcases.FailingWrite(n)
+}
+
 func freeTestCouchStoreTMPop(a store.Adapter, _ store.KeyValueStore) {
 	freeTestCouchStoreAdapter(a)
 }
@@ -0,0 +1,239 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cs
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	cj "github.com/gibson042/canonicaljson-go"
+	"github.com/pkg/errors"
+)
+
+// CommitmentPrefix prefixes the commitments that replace redactable state
+// fields.
+const CommitmentPrefix = "redacted:sha256:"
+
+// saltSize is the size in bytes of the salt of a commitment.
+const saltSize = 32
+
+var (
+	// ErrStateFieldNotFound is returned when a path doesn't match a state
+	// field.
+	ErrStateFieldNotFound = errors.New("state field not found")
+
+	// ErrStateFieldCommitted is returned when committing a state field
+	// that is already a commitment.
+	ErrStateFieldCommitted = errors.New("state field is already a commitment")
+
+	// ErrCommitmentMismatch is returned when an opening doesn't match the
+	// commitment of a link.
+	ErrCommitmentMismatch = errors.New("opening does not match state commitment")
+)
+
+// StateOpening contains the clear-text value of a state field that was
+// replaced by a commitment, and the salt needed to check it against the
+// commitment.
+//
+// The link only contains the commitment, so its hash and its signatures
+// don't depend on the clear-text value. Stores keep openings apart from
+// links and can purge them to redact the value for good.
+type StateOpening struct {
+	// Path is the dot-separated path of the field in the state,
+	// for instance "customer.email".
+	Path string `json:"path"`
+
+	// Salt is the hex encoded random salt of the commitment.
+	Salt string `json:"salt"`
+
+	// Value is the clear-text value of the field.
+	Value interface{} `json:"value"`
+}
+
+// Commitment computes the commitment of the opening.
+func (o *StateOpening) Commitment() (string, error) {
+	salt, err := hex.DecodeString(o.Salt)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	value, err := cj.Marshal(o.Value)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	h := sha256.New()
+	h.Write(salt)
+	h.Write(value)
+
+	return CommitmentPrefix + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Verify checks that the opening matches the commitment of the link.
+func (o *StateOpening) Verify(l *Link) error {
+	parent, key, err := l.stateField(o.Path)
+	if err != nil {
+		return err
+	}
+
+	commitment, err := o.Commitment()
+	if err != nil {
+		return err
+	}
+
+	if parent[key] != commitment {
+		return errors.Wrap(ErrCommitmentMismatch, o.Path)
+	}
+
+	return nil
+}
+
+// IsCommitment returns whether a state value is a commitment.
+func IsCommitment(value interface{}) bool {
+	s, ok := value.(string)
+	return ok && strings.HasPrefix(s, CommitmentPrefix)
+}
+
+// CommitState replaces state fields by salted commitments and returns the
+// openings of the commitments.
+//
+// It must be called when the link is created, before it is hashed or
+// signed. Signatures made before committing stay valid only if their
+// payload doesn't include the committed fields.
+func (l *Link) CommitState(paths ...string) ([]*StateOpening, error) {
+	type field struct {
+		parent map[string]interface{}
+		key    string
+	}
+
+	fields := make([]field, len(paths))
+	seen := make(map[string]struct{}, len(paths))
+	for i, path := range paths {
+		if _, ok := seen[path]; ok {
+			return nil, errors.Wrap(ErrStateFieldCommitted, path)
+		}
+		seen[path] = struct{}{}
+
+		parent, key, err := l.stateField(path)
+		if err != nil {
+			return nil, err
+		}
+		if IsCommitment(parent[key]) {
+			return nil, errors.Wrap(ErrStateFieldCommitted, path)
+		}
+		fields[i] = field{parent, key}
+	}
+
+	openings := make([]*StateOpening, len(paths))
+	commitments := make([]string, len(paths))
+	for i, path := range paths {
+		// Values are normalized the way they will be once the opening is
+		// decoded from JSON, so that the commitment can be checked later.
+		value, err := normalizeValue(fields[i].parent[fields[i].key])
+		if err != nil {
+			return nil, err
+		}
+
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		openings[i] = &StateOpening{
+			Path:  path,
+			Salt:  hex.EncodeToString(salt),
+			Value: value,
+		}
+		if commitments[i], err = openings[i].Commitment(); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, f := range fields {
+		f.parent[f.key] = commitments[i]
+	}
+
+	return openings, nil
+}
+
+// RevealState returns a copy of the link where the commitments matching
+// the given openings are replaced by their clear-text values.
+//
+// The returned link is meant to be displayed: it doesn't hash to the link
+// hash and its signatures may not verify.
+func (l *Link) RevealState(openings []*StateOpening) (*Link, error) {
+	js, err := json.Marshal(l)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var revealed Link
+	if err := json.Unmarshal(js, &revealed); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for _, o := range openings {
+		if err := o.Verify(l); err != nil {
+			return nil, err
+		}
+
+		parent, key, err := revealed.stateField(o.Path)
+		if err != nil {
+			return nil, err
+		}
+		parent[key] = o.Value
+	}
+
+	return &revealed, nil
+}
+
+// stateField returns the map containing the state field at the given path
+// and the key of the field in that map.
+func (l *Link) stateField(path string) (map[string]interface{}, string, error) {
+	keys := strings.Split(path, ".")
+	parent := l.State
+
+	for i, key := range keys {
+		value, ok := parent[key]
+		if !ok {
+			return nil, "", errors.Wrap(ErrStateFieldNotFound, path)
+		}
+		if i == len(keys)-1 {
+			return parent, key, nil
+		}
+		if parent, ok = value.(map[string]interface{}); !ok {
+			return nil, "", errors.Wrap(ErrStateFieldNotFound, path)
+		}
+	}
+
+	return nil, "", errors.Wrap(ErrStateFieldNotFound, path)
+}
+
+func normalizeValue(value interface{}) (interface{}, error) {
+	js, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var normalized interface{}
+	if err := json.Unmarshal(js, &normalized); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return normalized, nil
+}
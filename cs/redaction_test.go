@@ -0,0 +1,167 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cs_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stratumn/go-crypto/keys"
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/cs/cstesting"
+)
+
+func redactableLink() *cs.Link {
+	link := cstesting.RandomLink()
+	link.State = map[string]interface{}{
+		"public": "hello",
+		"customer": map[string]interface{}{
+			"name":  "Alice",
+			"email": "alice@example.com",
+			"age":   42,
+		},
+	}
+	return link
+}
+
+func TestCommitState(t *testing.T) {
+	link := redactableLink()
+
+	openings, err := link.CommitState("customer.name", "customer.age")
+	require.NoError(t, err)
+	require.Len(t, openings, 2)
+
+	customer := link.State["customer"].(map[string]interface{})
+	assert.True(t, cs.IsCommitment(customer["name"]))
+	assert.True(t, cs.IsCommitment(customer["age"]))
+	assert.Equal(t, "alice@example.com", customer["email"])
+	assert.Equal(t, "hello", link.State["public"])
+
+	assert.Equal(t, "Alice", openings[0].Value)
+	assert.Equal(t, float64(42), openings[1].Value)
+	assert.NotEqual(t, openings[0].Salt, openings[1].Salt)
+
+	for _, o := range openings {
+		assert.NoError(t, o.Verify(link))
+	}
+}
+
+func TestCommitState_errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+		err   error
+	}{
+		{"missing field", []string{"customer.phone"}, cs.ErrStateFieldNotFound},
+		{"not a map", []string{"public.foo"}, cs.ErrStateFieldNotFound},
+		{"duplicate path", []string{"public", "public"}, cs.ErrStateFieldCommitted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link := redactableLink()
+			lh, err := link.Hash()
+			require.NoError(t, err)
+
+			_, err = link.CommitState(tt.paths...)
+			assert.Equal(t, tt.err, errors.Cause(err))
+
+			got, err := link.Hash()
+			require.NoError(t, err)
+			assert.Equal(t, lh, got, "link should not be modified")
+		})
+	}
+
+	t.Run("already committed", func(t *testing.T) {
+		link := redactableLink()
+		_, err := link.CommitState("public")
+		require.NoError(t, err)
+
+		_, err = link.CommitState("public")
+		assert.Equal(t, cs.ErrStateFieldCommitted, errors.Cause(err))
+	})
+}
+
+func TestStateOpening_Verify(t *testing.T) {
+	link := redactableLink()
+	openings, err := link.CommitState("customer.email")
+	require.NoError(t, err)
+
+	t.Run("survives JSON encoding", func(t *testing.T) {
+		js, err := json.Marshal(openings[0])
+		require.NoError(t, err)
+
+		var o cs.StateOpening
+		require.NoError(t, json.Unmarshal(js, &o))
+		assert.NoError(t, o.Verify(link))
+	})
+
+	t.Run("wrong value", func(t *testing.T) {
+		o := *openings[0]
+		o.Value = "eve@example.com"
+		assert.Equal(t, cs.ErrCommitmentMismatch, errors.Cause(o.Verify(link)))
+	})
+
+	t.Run("wrong salt", func(t *testing.T) {
+		o := *openings[0]
+		o.Salt = "00"
+		assert.Equal(t, cs.ErrCommitmentMismatch, errors.Cause(o.Verify(link)))
+	})
+}
+
+func TestRevealState(t *testing.T) {
+	link := redactableLink()
+	openings, err := link.CommitState("customer.email")
+	require.NoError(t, err)
+	lh, err := link.Hash()
+	require.NoError(t, err)
+
+	revealed, err := link.RevealState(openings)
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", revealed.State["customer"].(map[string]interface{})["email"])
+
+	got, err := link.Hash()
+	require.NoError(t, err)
+	assert.Equal(t, lh, got, "original link should not be modified")
+
+	redacted, err := link.RevealState(nil)
+	require.NoError(t, err)
+	assert.True(t, cs.IsCommitment(redacted.State["customer"].(map[string]interface{})["email"]))
+}
+
+func TestCommitState_signatures(t *testing.T) {
+	_, privPEM, err := keys.GenerateKey(keys.ED25519)
+	require.NoError(t, err)
+	link := redactableLink()
+
+	metaSig, err := cs.NewSignature("meta", privPEM, link)
+	require.NoError(t, err)
+	stateSig, err := cs.NewSignature("state", privPEM, link)
+	require.NoError(t, err)
+
+	_, err = link.CommitState("customer.email")
+	require.NoError(t, err)
+
+	committedSig, err := cs.NewSignature("[state,meta]", privPEM, link)
+	require.NoError(t, err)
+
+	assert.NoError(t, metaSig.Verify(link), "payload without redacted fields")
+	assert.NoError(t, committedSig.Verify(link), "payload signed after commitment")
+	assert.Error(t, stateSig.Verify(link), "payload including redacted fields signed before commitment")
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dummystore
+
+import (
+	"context"
+
+	"github.com/stratumn/go-indigocore/bufferedbatch"
+	"github.com/stratumn/go-indigocore/monitoring"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/types"
+	"go.opencensus.io/trace"
+)
+
+// Batch is the type that implements github.com/stratumn/go-indigocore/store.Batch.
+type Batch struct {
+	*bufferedbatch.Batch
+
+	originalDummyStore *DummyStore
+}
+
+// NewBatch creates a new Batch
+func NewBatch(ctx context.Context, a *DummyStore) *Batch {
+	return &Batch{
+		Batch:              bufferedbatch.NewBatch(ctx, a),
+		originalDummyStore: a,
+	}
+}
+
+// Write implements github.com/stratumn/go-indigocore/store.Batch.Write.
+// The links of the batch are written atomically: the hashes of all the
+// links are computed before any link is saved.
+func (b *Batch) Write(ctx context.Context) (err error) {
+	ctx, span := trace.StartSpan(ctx, "dummystore/batch/Write")
+	defer monitoring.SetSpanStatusAndEnd(span, err)
+
+	a := b.originalDummyStore
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	linkHashes := make([]*types.Bytes32, len(b.Links))
+	for i, link := range b.Links {
+		if linkHashes[i], err = link.Hash(); err != nil {
+			return
+		}
+	}
+
+	for i, link := range b.Links {
+		a.saveLink(linkHashes[i], link)
+	}
+
+	if len(b.Links) > 0 {
+		linksEvent := store.NewSavedLinks(b.Links...)
+		for _, c := range a.eventChans {
+			c <- linksEvent
+		}
+	}
+
+	return
+}
@@ -24,7 +24,6 @@ import (
 	"sort"
 	"sync"
 
-	"github.com/stratumn/go-indigocore/bufferedbatch"
 	"github.com/stratumn/go-indigocore/cs"
 	"github.com/stratumn/go-indigocore/store"
 	"github.com/stratumn/go-indigocore/types"
@@ -116,6 +115,18 @@ func (a *DummyStore) createLink(link *cs.Link) (*types.Bytes32, error) {
 		return nil, err
 	}
 
+	a.saveLink(linkHash, link)
+
+	linkEvent := store.NewSavedLinks(link)
+
+	for _, c := range a.eventChans {
+		c <- linkEvent
+	}
+
+	return linkHash, nil
+}
+
+func (a *DummyStore) saveLink(linkHash *types.Bytes32, link *cs.Link) {
 	linkHashStr := linkHash.String()
 	a.links[linkHashStr] = link
 
@@ -126,14 +137,6 @@ func (a *DummyStore) createLink(link *cs.Link) (*types.Bytes32, error) {
 	}
 
 	a.maps[mapID][linkHashStr] = struct{}{}
-
-	linkEvent := store.NewSavedLinks(link)
-
-	for _, c := range a.eventChans {
-		c <- linkEvent
-	}
-
-	return linkHash, nil
 }
 
 // AddEvidence implements github.com/stratumn/go-indigocore/store.EvidenceWriter.AddEvidence.
@@ -228,6 +231,33 @@ func (a *DummyStore) FindSegments(ctx context.Context, filter *store.SegmentFilt
 	return a.findHashesSegments(linkHashes, filter)
 }
 
+// Search implements github.com/stratumn/go-indigocore/store.Searcher.Search.
+func (a *DummyStore) Search(ctx context.Context, query *store.SearchQuery) (cs.SegmentSlice, error) {
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	var segments cs.SegmentSlice
+
+	for linkHash := range a.links {
+		segment, err := a.getSegment(linkHash)
+		if err != nil {
+			return nil, err
+		}
+
+		if query.Match(segment) {
+			segments = append(segments, segment)
+		}
+	}
+
+	sort.Sort(segments)
+
+	return query.Pagination.PaginateSegments(segments), nil
+}
+
 // GetMapIDs implements github.com/stratumn/go-indigocore/store.Adapter.GetMapIDs.
 func (a *DummyStore) GetMapIDs(ctx context.Context, filter *store.MapFilter) ([]string, error) {
 	a.mutex.RLock()
@@ -281,6 +311,18 @@ func (a *DummyStore) setValue(key, value []byte) error {
 	return nil
 }
 
+// CreateValue implements github.com/stratumn/go-indigocore/store.KeyValueCreator.CreateValue.
+func (a *DummyStore) CreateValue(ctx context.Context, key, value []byte) (bool, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if _, exists := a.values[createKey(key)]; exists {
+		return false, nil
+	}
+
+	return true, a.setValue(key, value)
+}
+
 // DeleteValue implements github.com/stratumn/go-indigocore/store.KeyValueStore.DeleteValue.
 func (a *DummyStore) DeleteValue(ctx context.Context, key []byte) ([]byte, error) {
 	a.mutex.Lock()
@@ -306,7 +348,7 @@ func (a *DummyStore) deleteValue(key []byte) ([]byte, error) {
 
 // NewBatch implements github.com/stratumn/go-indigocore/store.Adapter.NewBatch.
 func (a *DummyStore) NewBatch(ctx context.Context) (store.Batch, error) {
-	return bufferedbatch.NewBatch(ctx, a), nil
+	return NewBatch(ctx, a), nil
 }
 
 /********** Utilities **********/
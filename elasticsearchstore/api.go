@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"sort"
+	"strings"
 
 	"github.com/olivere/elastic"
 	"github.com/stratumn/go-indigocore/cs"
@@ -148,10 +149,7 @@ type linkDoc struct {
 }
 
 // SearchQuery contains pagination and query string information.
-type SearchQuery struct {
-	store.SegmentFilter
-	Query string
-}
+type SearchQuery = store.SearchQuery
 
 func (es *ESStore) createIndex(indexName, mapping string) error {
 	ctx := context.TODO()
@@ -273,6 +271,81 @@ func (es *ESStore) createLink(link *cs.Link) (*types.Bytes32, error) {
 	return linkHash, es.indexDocument(linksIndex, linkHashStr, linkDoc)
 }
 
+// createLinks saves the links of a batch.
+// Elasticsearch doesn't have transactions, so if one of the links can't be
+// saved, the links that were saved are deleted.
+func (es *ESStore) createLinks(links []*cs.Link) error {
+	var (
+		linkHashes []string
+		docs       []*linkDoc
+		seen       = map[string]struct{}{}
+	)
+
+	for _, link := range links {
+		linkHash, err := link.Hash()
+		if err != nil {
+			return err
+		}
+		linkHashStr := linkHash.String()
+
+		if _, exists := seen[linkHashStr]; exists {
+			continue
+		}
+		seen[linkHashStr] = struct{}{}
+
+		has, err := es.hasDocument(linksIndex, linkHashStr)
+		if err != nil {
+			return err
+		}
+		if has {
+			return fmt.Errorf("link is immutable, %s already exists", linkHashStr)
+		}
+
+		linkDoc, err := fromLink(link)
+		if err != nil {
+			return err
+		}
+
+		linkHashes = append(linkHashes, linkHashStr)
+		docs = append(docs, linkDoc)
+	}
+
+	for i, linkHashStr := range linkHashes {
+		if err := es.writeLink(i, linkHashStr, docs[i]); err != nil {
+			return es.deleteLinks(linkHashes[:i], err)
+		}
+	}
+
+	return nil
+}
+
+func (es *ESStore) writeLink(i int, linkHash string, doc *linkDoc) error {
+	if es.testHookWriteLink != nil {
+		if err := es.testHookWriteLink(i); err != nil {
+			return err
+		}
+	}
+
+	return es.indexDocument(linksIndex, linkHash, doc)
+}
+
+// deleteLinks deletes the links saved by a failed batch and returns the
+// error that made it fail, along with the links that couldn't be deleted.
+func (es *ESStore) deleteLinks(linkHashes []string, cause error) error {
+	var failed []string
+	for _, linkHash := range linkHashes {
+		if err := es.deleteDocument(linksIndex, linkHash); err != nil {
+			failed = append(failed, fmt.Sprintf("%s (%s)", linkHash, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%s, and links could not be deleted: %s", cause, strings.Join(failed, ", "))
+	}
+
+	return cause
+}
+
 func (es *ESStore) hasDocument(indexName, id string) (bool, error) {
 	ctx := context.TODO()
 	return es.client.Exists().Index(indexName).Type(docType).Id(id).Do(ctx)
@@ -371,6 +444,22 @@ func (es *ESStore) setValue(key string, value []byte) error {
 	return es.indexDocument(valuesIndex, key, v)
 }
 
+// createValue indexes the value only if no document has the key.
+func (es *ESStore) createValue(key string, value []byte) (bool, error) {
+	ctx := context.TODO()
+	v := Value{
+		Value: value,
+	}
+	_, err := es.client.Index().Index(valuesIndex).Type(docType).Id(key).OpType("create").BodyJson(v).Do(ctx)
+	if elastic.IsConflict(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (es *ESStore) deleteValue(key string) ([]byte, error) {
 	value, err := es.getValue(key)
 	if err != nil {
@@ -545,12 +634,32 @@ func (es *ESStore) simpleSearchQuery(query *SearchQuery) (cs.SegmentSlice, error
 		// add filter queries.
 		Filter(makeFilterQueries(&query.SegmentFilter)...).
 		// add simple search query.
-		Must(elastic.NewSimpleQueryStringQuery(query.Query))
+		Must(makeSimpleQueryString(query))
 
 	// run search.
 	return es.genericSearch(&query.SegmentFilter, q)
 }
 
+// makeSimpleQueryString restricts the query string to the requested fields,
+// or to all the searchable fields if none is given.
+// The state is searched through the extracted state tokens.
+func makeSimpleQueryString(query *SearchQuery) *elastic.SimpleQueryStringQuery {
+	fields := query.Fields
+	if len(fields) == 0 {
+		fields = store.SearchFields
+	}
+
+	q := elastic.NewSimpleQueryStringQuery(query.Query)
+	for _, field := range fields {
+		if field == store.SearchFieldState {
+			field = "stateTokens"
+		}
+		q = q.Field(field)
+	}
+
+	return q
+}
+
 func (es *ESStore) multiMatchQuery(query *SearchQuery) (cs.SegmentSlice, error) {
 	// fields to search through: all meta + stateTokens.
 	fields := []string{
@@ -20,7 +20,6 @@ import (
 
 	"github.com/olivere/elastic"
 	log "github.com/sirupsen/logrus"
-	"github.com/stratumn/go-indigocore/bufferedbatch"
 	"github.com/stratumn/go-indigocore/cs"
 	"github.com/stratumn/go-indigocore/store"
 	"github.com/stratumn/go-indigocore/types"
@@ -68,6 +67,10 @@ type ESStore struct {
 	config     *Config
 	eventChans []chan *store.Event
 	client     *elastic.Client
+
+	// testHookWriteLink is called by tests before the i-th link of a batch
+	// is saved, to make the write fail.
+	testHookWriteLink func(i int) error
 }
 
 type errorLogger struct{}
@@ -153,7 +156,7 @@ func (es *ESStore) AddStoreEventChannel(eventChan chan *store.Event) {
 
 // NewBatch implements github.com/stratumn/go-indigocore/store.Adapter.NewBatch.
 func (es *ESStore) NewBatch(ctx context.Context) (store.Batch, error) {
-	return bufferedbatch.NewBatch(ctx, es), nil
+	return NewBatch(ctx, es), nil
 }
 
 /********** Store writer implementation **********/
@@ -226,6 +229,12 @@ func (es *ESStore) GetValue(ctx context.Context, key []byte) ([]byte, error) {
 	return es.getValue(hexKey)
 }
 
+// CreateValue implements github.com/stratumn/go-indigocore/store.KeyValueCreator.CreateValue.
+func (es *ESStore) CreateValue(ctx context.Context, key, value []byte) (bool, error) {
+	hexKey := hex.EncodeToString(key)
+	return es.createValue(hexKey, value)
+}
+
 // DeleteValue implements github.com/stratumn/go-indigocore/store.Adapter.DeleteValue.
 func (es *ESStore) DeleteValue(ctx context.Context, key []byte) ([]byte, error) {
 	hexKey := hex.EncodeToString(key)
@@ -235,6 +244,15 @@ func (es *ESStore) DeleteValue(ctx context.Context, key []byte) ([]byte, error)
 
 /********** Search feature **********/
 
+// Search implements github.com/stratumn/go-indigocore/store.Searcher.Search.
+func (es *ESStore) Search(ctx context.Context, query *store.SearchQuery) (cs.SegmentSlice, error) {
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+
+	return es.simpleSearchQuery(query)
+}
+
 // SimpleSearchQuery searches through the store for segments matching query criteria
 // using ES simple query string feature
 func (es *ESStore) SimpleSearchQuery(query *SearchQuery) (cs.SegmentSlice, error) {
@@ -102,6 +102,7 @@ func TestElasticSearchStore(t *testing.T) {
 		NewKeyValueStore:  newTestElasticSearchStoreKeyValue,
 		Free:              freeTestElasticSearchStoreAdapter,
 		FreeKeyValueStore: freeTestElasticSearchStoreKeyValue,
+		FailWrites:        failTestElasticSearchStoreWrites,
 	}
 
 	factory.RunStoreTests(t)
@@ -285,6 +286,10 @@ func newTestElasticSearchStoreAdapter() (store.Adapter, error) {
 	return newTestElasticSearchStore()
 }
 
+func failTestElasticSearchStoreWrites(a store.Adapter, n int) {
+	a.(*ESStore).testHookWriteLink = storetestcases.FailingWrite(n)
+}
+
 func newTestElasticSearchStoreKeyValue() (store.KeyValueStore, error) {
 	return newTestElasticSearchStore()
 }
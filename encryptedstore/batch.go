@@ -0,0 +1,70 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryptedstore
+
+import (
+	"context"
+
+	"github.com/stratumn/go-indigocore/bufferedbatch"
+	"github.com/stratumn/go-indigocore/monitoring"
+
+	"go.opencensus.io/trace"
+)
+
+// Batch is the type that implements github.com/stratumn/go-indigocore/store.Batch.
+type Batch struct {
+	*bufferedbatch.Batch
+
+	originalEncryptedStore *Store
+}
+
+// NewBatch creates a new Batch
+func NewBatch(ctx context.Context, s *Store) *Batch {
+	return &Batch{
+		Batch:                  bufferedbatch.NewBatch(ctx, s),
+		originalEncryptedStore: s,
+	}
+}
+
+// Write implements github.com/stratumn/go-indigocore/store.Batch.Write.
+// All the links are encrypted before being written in a batch of the
+// underlying store. Links that are already stored are skipped.
+func (b *Batch) Write(ctx context.Context) (err error) {
+	ctx, span := trace.StartSpan(ctx, "encryptedstore/batch/Write")
+	defer monitoring.SetSpanStatusAndEnd(span, err)
+
+	s := b.originalEncryptedStore
+	encrypted, _, err := s.encryptLinks(ctx, b.Links)
+	if err != nil {
+		return
+	}
+
+	batch, err := s.a.NewBatch(ctx)
+	if err != nil {
+		return
+	}
+
+	for _, link := range encrypted {
+		if link == nil {
+			continue
+		}
+		if _, err = batch.CreateLink(ctx, link); err != nil {
+			return
+		}
+	}
+
+	err = batch.Write(ctx)
+	return
+}
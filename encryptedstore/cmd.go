@@ -0,0 +1,78 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryptedstore
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/stratumn/go-indigocore/store"
+)
+
+var (
+	keysPath        string
+	encryptedFields string
+)
+
+// RegisterFlags registers the flags used by WrapWithFlags.
+func RegisterFlags() {
+	flag.StringVar(&keysPath, "encryption_keys", os.Getenv("ENCRYPTION_KEYS"), "Path of the JSON file containing the encryption keys, enables encryption at rest")
+	flag.StringVar(&encryptedFields, "encrypted_fields", strings.Join([]string{FieldState, FieldMetaData}, ","), "Comma-separated link fields to encrypt")
+}
+
+// WrapWithFlags should be called after RegisterFlags and flag.Parse to
+// decorate a store adapter using flag values. It returns the adapter
+// unchanged if no key file is given.
+//
+// The key file is read again when the process receives SIGHUP, so that keys
+// can be rotated without restarting.
+func WrapWithFlags(a store.Adapter) store.Adapter {
+	if keysPath == "" {
+		return a
+	}
+
+	provider, err := NewFileKeyProvider(keysPath)
+	if err != nil {
+		log.WithField("error", err).Fatal("Failed to load encryption keys")
+	}
+
+	s, err := New(a, &Config{
+		KeyProvider: provider,
+		Fields:      strings.Split(encryptedFields, ","),
+	})
+	if err != nil {
+		log.WithField("error", err).Fatal("Failed to create encrypted store")
+	}
+
+	go func() {
+		sigc := make(chan os.Signal, 1)
+		signal.Notify(sigc, syscall.SIGHUP)
+		for range sigc {
+			if err := provider.Reload(); err != nil {
+				log.WithField("error", err).Error("Failed to reload encryption keys")
+				continue
+			}
+			log.Info("Reloaded encryption keys")
+		}
+	}()
+
+	log.WithField("fields", encryptedFields).Info("Encrypting links at rest")
+	return s
+}
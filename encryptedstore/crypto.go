@@ -0,0 +1,323 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryptedstore
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/types"
+)
+
+// Fields that can be encrypted.
+const (
+	FieldState    = "state"
+	FieldMetaData = "meta.data"
+)
+
+// Algorithm is the encryption algorithm.
+const Algorithm = "AES-256-GCM"
+
+// envelopeKey is the key of the encrypted value in an encrypted field.
+const envelopeKey = "$encrypted"
+
+// fieldStateOpenings binds the ciphertext of state openings to their use.
+const fieldStateOpenings = "stateopenings"
+
+var (
+	// ErrInvalidField is returned when configuring a field that can't be
+	// encrypted.
+	ErrInvalidField = errors.New("field can't be encrypted")
+
+	// ErrInvalidEnvelope is returned when an encrypted field can't be
+	// decoded.
+	ErrInvalidEnvelope = errors.New("invalid encrypted field")
+)
+
+// envelope is the encrypted value of a field.
+type envelope struct {
+	Algorithm  string `json:"alg"`
+	KeyID      string `json:"keyId"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// additionalData binds a ciphertext to the plaintext hash of its link, its
+// process and its field so that it can't be moved to another link or field.
+func additionalData(linkHash *types.Bytes32, process, field string) []byte {
+	return []byte(linkHash.String() + "\x00" + process + "\x00" + field)
+}
+
+// deriveNonce derives the nonce of a field from the key and the plaintext
+// hash of its link. Encrypting the same link twice with the same key gives
+// the same stored link, and since the plaintext hash covers the value, a
+// nonce is never reused for different values.
+func deriveNonce(key *Key, linkHash *types.Bytes32, field string, size int) []byte {
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write([]byte("nonce\x00" + linkHash.String() + "\x00" + field))
+	return mac.Sum(nil)[:size]
+}
+
+func newGCM(key *Key) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key.Secret)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	return gcm, errors.WithStack(err)
+}
+
+func encryptField(key *Key, linkHash *types.Bytes32, process, field string, value map[string]interface{}) (map[string]interface{}, error) {
+	plaintext, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := deriveNonce(key, linkHash, field, gcm.NonceSize())
+	env, err := toMap(&envelope{
+		Algorithm:  Algorithm,
+		KeyID:      key.ID,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, additionalData(linkHash, process, field)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{envelopeKey: env}, nil
+}
+
+// encryptStateOpenings encrypts the state openings of a link with the
+// current key of its process. Unlike link fields, state openings aren't
+// part of the link hash, so a random nonce is used.
+func (s *Store) encryptStateOpenings(ctx context.Context, linkHash *types.Bytes32, process string, openings []*cs.StateOpening) ([]byte, error) {
+	plaintext, err := json.Marshal(openings)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	key, err := s.keys.CurrentKey(ctx, process)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	js, err := json.Marshal(&envelope{
+		Algorithm:  Algorithm,
+		KeyID:      key.ID,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, additionalData(linkHash, process, fieldStateOpenings)),
+	})
+	return js, errors.WithStack(err)
+}
+
+func (s *Store) decryptStateOpenings(ctx context.Context, linkHash *types.Bytes32, process string, value []byte) ([]*cs.StateOpening, error) {
+	var env envelope
+	if err := json.Unmarshal(value, &env); err != nil {
+		return nil, errors.Wrap(ErrInvalidEnvelope, err.Error())
+	}
+
+	plaintext, err := s.open(ctx, process, &env, additionalData(linkHash, process, fieldStateOpenings))
+	if err != nil {
+		return nil, err
+	}
+
+	openings := []*cs.StateOpening{}
+	if err := json.Unmarshal(plaintext, &openings); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return openings, nil
+}
+
+// isEncrypted tells whether a field was encrypted.
+func isEncrypted(value map[string]interface{}) bool {
+	_, ok := value[envelopeKey]
+	return ok
+}
+
+func (s *Store) decryptField(ctx context.Context, linkHash *types.Bytes32, process, field string, value map[string]interface{}) (map[string]interface{}, error) {
+	encrypted, ok := value[envelopeKey]
+	if !ok {
+		return value, nil
+	}
+
+	js, err := json.Marshal(encrypted)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var env envelope
+	if err := json.Unmarshal(js, &env); err != nil {
+		return nil, errors.Wrap(ErrInvalidEnvelope, err.Error())
+	}
+
+	plaintext, err := s.open(ctx, process, &env, additionalData(linkHash, process, field))
+	if err != nil {
+		return nil, err
+	}
+
+	var decrypted map[string]interface{}
+	if err := json.Unmarshal(plaintext, &decrypted); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return decrypted, nil
+}
+
+// open decrypts an envelope with the key of the process it was encrypted
+// with.
+func (s *Store) open(ctx context.Context, process string, env *envelope, ad []byte) ([]byte, error) {
+	if env.Algorithm != Algorithm {
+		return nil, errors.Wrapf(ErrInvalidEnvelope, "unsupported algorithm %q", env.Algorithm)
+	}
+
+	key, err := s.keys.GetKey(ctx, process, env.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(env.Nonce) != gcm.NonceSize() {
+		return nil, errors.Wrap(ErrInvalidEnvelope, "invalid nonce")
+	}
+
+	plaintext, err := gcm.Open(nil, env.Nonce, env.Ciphertext, ad)
+	return plaintext, errors.WithStack(err)
+}
+
+// encryptLink returns a copy of the link with encrypted fields.
+func (s *Store) encryptLink(ctx context.Context, link *cs.Link) (*cs.Link, error) {
+	linkHash, err := link.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := s.keys.CurrentKey(ctx, link.Meta.Process)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := *link
+	if s.encryptState && link.State != nil {
+		if encrypted.State, err = encryptField(key, linkHash, link.Meta.Process, FieldState, link.State); err != nil {
+			return nil, err
+		}
+	}
+	if s.encryptMetaData && link.Meta.Data != nil {
+		if encrypted.Meta.Data, err = encryptField(key, linkHash, link.Meta.Process, FieldMetaData, link.Meta.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	return &encrypted, nil
+}
+
+// decryptLink returns a copy of the link with decrypted fields.
+// Fields that aren't encrypted are left untouched, so links saved before
+// encryption was enabled can still be read.
+func (s *Store) decryptLink(ctx context.Context, link *cs.Link) (*cs.Link, error) {
+	decrypted := *link
+	if !isEncrypted(link.State) && !isEncrypted(link.Meta.Data) {
+		return &decrypted, nil
+	}
+
+	storedHash, err := link.HashString()
+	if err != nil {
+		return nil, err
+	}
+	plain, err := s.plainLinkHash(ctx, storedHash)
+	if err != nil {
+		return nil, err
+	}
+	linkHash, err := types.NewBytes32FromString(plain)
+	if err != nil {
+		return nil, err
+	}
+
+	if decrypted.State, err = s.decryptField(ctx, linkHash, link.Meta.Process, FieldState, link.State); err != nil {
+		return nil, err
+	}
+	if decrypted.Meta.Data, err = s.decryptField(ctx, linkHash, link.Meta.Process, FieldMetaData, link.Meta.Data); err != nil {
+		return nil, err
+	}
+
+	// The clear-text fields must be those of the plaintext link too.
+	decryptedHash, err := decrypted.HashString()
+	if err != nil {
+		return nil, err
+	}
+	if decryptedHash != plain {
+		return nil, errors.Wrap(ErrInvalidEnvelope, "link hash mismatch")
+	}
+
+	return &decrypted, nil
+}
+
+// decryptSegment returns a copy of the segment with a decrypted link and
+// the link hash of the decrypted link.
+func (s *Store) decryptSegment(ctx context.Context, segment *cs.Segment) (*cs.Segment, error) {
+	link, err := s.decryptLink(ctx, &segment.Link)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted := &cs.Segment{Link: *link, Meta: segment.Meta}
+	if decrypted.Meta.LinkHash, err = link.HashString(); err != nil {
+		return nil, err
+	}
+
+	return decrypted, nil
+}
+
+func toMap(v interface{}) (map[string]interface{}, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(js, &m); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,544 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package encryptedstore implements a store.Adapter decorator that encrypts
+// the state and meta data of links at rest.
+//
+// Links are encrypted with AES-256-GCM using keys of their process given by
+// a KeyProvider. Meta data used to query segments, such as the process,
+// the map ID and the tags, stays in clear text.
+//
+// Link hashes are computed on the plaintext links, so that link hashes and
+// evidences don't depend on the encryption. The underlying store saves
+// encrypted links under a different hash: the decorator maps plaintext
+// hashes to stored hashes in the key-value store of the underlying store.
+// Writing a link that is already stored is a no-op, and nonces are derived
+// from the plaintext link hash, so writing a link twice never stores two
+// copies of it.
+//
+// Ciphertexts are bound to the plaintext link hash, the process and the
+// field they were encrypted for, so they can't be moved to another link.
+//
+// State openings are encrypted with the key of the process of their link
+// and saved in the key-value store, so the decorator implements
+// store.StateOpeningStore.
+//
+// Encrypted fields can't be searched, so the decorator doesn't implement
+// store.Searcher.
+package encryptedstore
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/types"
+)
+
+const (
+	// Name is the name set in the store's information.
+	Name = "encrypted"
+
+	// Description is the description set in the store's information.
+	Description = "Indigo's Encrypted Store"
+
+	// plainHashPrefix prefixes the keys mapping plaintext link hashes to
+	// stored link hashes.
+	plainHashPrefix = "encryptedstore:plain:"
+
+	// storedHashPrefix prefixes the keys mapping stored link hashes to
+	// plaintext link hashes.
+	storedHashPrefix = "encryptedstore:stored:"
+
+	// stateOpeningsPrefix prefixes the keys of the encrypted state
+	// openings of links.
+	stateOpeningsPrefix = "encryptedstore:stateopenings:"
+)
+
+// stateOpeningsPurged is the value recorded in place of the state openings
+// of a link when they are purged.
+var stateOpeningsPurged = []byte("purged")
+
+var (
+	// ErrNoKeyProvider is returned when no key provider is configured.
+	ErrNoKeyProvider = errors.New("a key provider is required")
+
+	// ErrNoKeyValueStore is returned when neither the configuration nor
+	// the underlying store provides a key-value store.
+	ErrNoKeyValueStore = errors.New("a key-value store is required to map link hashes")
+)
+
+// Config contains configuration options for the store.
+type Config struct {
+	// KeyProvider provides the encryption keys.
+	KeyProvider KeyProvider
+
+	// Fields are the link fields to encrypt, among FieldState and
+	// FieldMetaData. Defaults to both.
+	Fields []string
+
+	// KeyValueStore stores the mapping between plaintext and stored link
+	// hashes. Defaults to the underlying store.
+	KeyValueStore store.KeyValueStore
+}
+
+// Info is the info returned by GetInfo.
+type Info struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Algorithm   string      `json:"algorithm"`
+	Fields      []string    `json:"fields"`
+	Adapter     interface{} `json:"adapter"`
+}
+
+// Store is the type that implements github.com/stratumn/go-indigocore/store.Adapter.
+type Store struct {
+	a      store.Adapter
+	kv     store.KeyValueStore
+	keys   KeyProvider
+	fields []string
+
+	encryptState    bool
+	encryptMetaData bool
+
+	eventsOnce  sync.Once
+	eventsMutex sync.RWMutex
+	eventChans  []chan *store.Event
+}
+
+// New decorates an existing store adapter.
+func New(a store.Adapter, config *Config) (*Store, error) {
+	if config.KeyProvider == nil {
+		return nil, ErrNoKeyProvider
+	}
+
+	s := &Store{
+		a:      a,
+		kv:     config.KeyValueStore,
+		keys:   config.KeyProvider,
+		fields: config.Fields,
+	}
+
+	if s.kv == nil {
+		kv, ok := a.(store.KeyValueStore)
+		if !ok {
+			return nil, ErrNoKeyValueStore
+		}
+		s.kv = kv
+	}
+
+	if len(s.fields) == 0 {
+		s.fields = []string{FieldState, FieldMetaData}
+	}
+	for _, field := range s.fields {
+		switch field {
+		case FieldState:
+			s.encryptState = true
+		case FieldMetaData:
+			s.encryptMetaData = true
+		default:
+			return nil, errors.Wrap(ErrInvalidField, field)
+		}
+	}
+
+	return s, nil
+}
+
+// storedLinkHash returns the hash under which the underlying store saved a
+// link. Links that weren't written through the decorator are saved under
+// their plaintext hash.
+func (s *Store) storedLinkHash(ctx context.Context, linkHash *types.Bytes32) (*types.Bytes32, error) {
+	value, err := s.kv.GetValue(ctx, []byte(plainHashPrefix+linkHash.String()))
+	if err != nil || value == nil {
+		return linkHash, err
+	}
+	return types.NewBytes32FromString(string(value))
+}
+
+// plainLinkHash returns the plaintext hash of a link saved by the
+// underlying store.
+func (s *Store) plainLinkHash(ctx context.Context, linkHash string) (string, error) {
+	value, err := s.kv.GetValue(ctx, []byte(storedHashPrefix+linkHash))
+	if err != nil || value == nil {
+		return linkHash, err
+	}
+	return string(value), nil
+}
+
+// isStored tells whether a link was already written through the decorator.
+// The mapping of a link is saved before the link, so the stored link must
+// exist too.
+func (s *Store) isStored(ctx context.Context, linkHash *types.Bytes32) (bool, error) {
+	value, err := s.kv.GetValue(ctx, []byte(plainHashPrefix+linkHash.String()))
+	if err != nil || value == nil {
+		return false, err
+	}
+
+	storedHash, err := types.NewBytes32FromString(string(value))
+	if err != nil {
+		return false, err
+	}
+
+	segment, err := s.a.GetSegment(ctx, storedHash)
+	return segment != nil, err
+}
+
+// encryptLinks encrypts links and saves the mapping between their
+// plaintext and stored hashes. It returns the encrypted links and the
+// plaintext hashes. The encrypted link is nil for links that are already
+// stored.
+func (s *Store) encryptLinks(ctx context.Context, links []*cs.Link) ([]*cs.Link, []*types.Bytes32, error) {
+	encrypted := make([]*cs.Link, len(links))
+	plainHashes := make([]*types.Bytes32, len(links))
+	storedHashes := make([]*types.Bytes32, len(links))
+
+	for i, link := range links {
+		var err error
+		if plainHashes[i], err = link.Hash(); err != nil {
+			return nil, nil, err
+		}
+
+		stored, err := s.isStored(ctx, plainHashes[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		if stored {
+			continue
+		}
+
+		if encrypted[i], err = s.encryptLink(ctx, link); err != nil {
+			return nil, nil, err
+		}
+		if storedHashes[i], err = encrypted[i].Hash(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// The mapping is saved before the links so that it is available as
+	// soon as the underlying store notifies that links were saved.
+	for i := range links {
+		if encrypted[i] == nil {
+			continue
+		}
+
+		plain, stored := plainHashes[i].String(), storedHashes[i].String()
+		if err := s.kv.SetValue(ctx, []byte(plainHashPrefix+plain), []byte(stored)); err != nil {
+			return nil, nil, err
+		}
+		if err := s.kv.SetValue(ctx, []byte(storedHashPrefix+stored), []byte(plain)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return encrypted, plainHashes, nil
+}
+
+func (s *Store) decryptSegments(ctx context.Context, segments cs.SegmentSlice) (cs.SegmentSlice, error) {
+	if segments == nil {
+		return nil, nil
+	}
+
+	decrypted := make(cs.SegmentSlice, len(segments))
+	for i, segment := range segments {
+		var err error
+		if decrypted[i], err = s.decryptSegment(ctx, segment); err != nil {
+			return nil, err
+		}
+	}
+
+	return decrypted, nil
+}
+
+/********** Store adapter implementation **********/
+
+// GetInfo implements github.com/stratumn/go-indigocore/store.Adapter.GetInfo.
+func (s *Store) GetInfo(ctx context.Context) (interface{}, error) {
+	info, err := s.a.GetInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Info{
+		Name:        Name,
+		Description: Description,
+		Algorithm:   Algorithm,
+		Fields:      s.fields,
+		Adapter:     info,
+	}, nil
+}
+
+// AddStoreEventChannel implements
+// github.com/stratumn/go-indigocore/store.Adapter.AddStoreEventChannel.
+// Events contain decrypted links and plaintext link hashes.
+func (s *Store) AddStoreEventChannel(eventChan chan *store.Event) {
+	s.eventsMutex.Lock()
+	s.eventChans = append(s.eventChans, eventChan)
+	s.eventsMutex.Unlock()
+
+	s.eventsOnce.Do(s.startEvents)
+}
+
+// NewBatch implements github.com/stratumn/go-indigocore/store.Adapter.NewBatch.
+func (s *Store) NewBatch(ctx context.Context) (store.Batch, error) {
+	return NewBatch(ctx, s), nil
+}
+
+/********** Store writer implementation **********/
+
+// CreateLink implements github.com/stratumn/go-indigocore/store.LinkWriter.CreateLink.
+// It returns the hash of the plaintext link.
+func (s *Store) CreateLink(ctx context.Context, link *cs.Link) (*types.Bytes32, error) {
+	encrypted, plainHashes, err := s.encryptLinks(ctx, []*cs.Link{link})
+	if err != nil {
+		return nil, err
+	}
+
+	if encrypted[0] != nil {
+		if _, err := s.a.CreateLink(ctx, encrypted[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	return plainHashes[0], nil
+}
+
+// CreateLinks implements github.com/stratumn/go-indigocore/store.LinksWriter.CreateLinks.
+// Results contain the hashes of the plaintext links. Links that are already
+// stored are reported as committed.
+func (s *Store) CreateLinks(ctx context.Context, links []*cs.Link, mode store.WriteMode) ([]*store.LinkResult, error) {
+	encrypted, plainHashes, err := s.encryptLinks(ctx, links)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*store.LinkResult, len(links))
+	var toWrite []*cs.Link
+	var indices []int
+	for i, link := range encrypted {
+		if link == nil {
+			results[i] = &store.LinkResult{LinkHash: plainHashes[i], Status: store.LinkCommitted}
+			continue
+		}
+		toWrite = append(toWrite, link)
+		indices = append(indices, i)
+	}
+	if len(toWrite) == 0 {
+		return results, nil
+	}
+
+	written, err := store.CreateLinks(ctx, s.a, toWrite, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	for j, res := range written {
+		i := indices[j]
+		if res != nil && res.LinkHash != nil {
+			res.LinkHash = plainHashes[i]
+		}
+		results[i] = res
+	}
+
+	return results, nil
+}
+
+// AddEvidence implements github.com/stratumn/go-indigocore/store.EvidenceWriter.AddEvidence.
+func (s *Store) AddEvidence(ctx context.Context, linkHash *types.Bytes32, evidence *cs.Evidence) error {
+	storedHash, err := s.storedLinkHash(ctx, linkHash)
+	if err != nil {
+		return err
+	}
+
+	return s.a.AddEvidence(ctx, storedHash, evidence)
+}
+
+/********** Store reader implementation **********/
+
+// GetSegment implements github.com/stratumn/go-indigocore/store.SegmentReader.GetSegment.
+func (s *Store) GetSegment(ctx context.Context, linkHash *types.Bytes32) (*cs.Segment, error) {
+	storedHash, err := s.storedLinkHash(ctx, linkHash)
+	if err != nil {
+		return nil, err
+	}
+
+	segment, err := s.a.GetSegment(ctx, storedHash)
+	if err != nil || segment == nil {
+		return segment, err
+	}
+
+	return s.decryptSegment(ctx, segment)
+}
+
+// FindSegments implements github.com/stratumn/go-indigocore/store.SegmentReader.FindSegments.
+func (s *Store) FindSegments(ctx context.Context, filter *store.SegmentFilter) (cs.SegmentSlice, error) {
+	f := *filter
+	if len(filter.LinkHashes) > 0 {
+		f.LinkHashes = make([]string, len(filter.LinkHashes))
+		for i, lh := range filter.LinkHashes {
+			linkHash, err := types.NewBytes32FromString(lh)
+			if err != nil {
+				return nil, err
+			}
+			storedHash, err := s.storedLinkHash(ctx, linkHash)
+			if err != nil {
+				return nil, err
+			}
+			f.LinkHashes[i] = storedHash.String()
+		}
+	}
+
+	segments, err := s.a.FindSegments(ctx, &f)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.decryptSegments(ctx, segments)
+}
+
+// GetMapIDs implements github.com/stratumn/go-indigocore/store.SegmentReader.GetMapIDs.
+func (s *Store) GetMapIDs(ctx context.Context, filter *store.MapFilter) ([]string, error) {
+	return s.a.GetMapIDs(ctx, filter)
+}
+
+// GetEvidences implements github.com/stratumn/go-indigocore/store.EvidenceReader.GetEvidences.
+func (s *Store) GetEvidences(ctx context.Context, linkHash *types.Bytes32) (*cs.Evidences, error) {
+	storedHash, err := s.storedLinkHash(ctx, linkHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.a.GetEvidences(ctx, storedHash)
+}
+
+// GetLinkHeight implements github.com/stratumn/go-indigocore/store.LinkHeightReader.GetLinkHeight.
+func (s *Store) GetLinkHeight(ctx context.Context, linkHash *types.Bytes32) (int64, error) {
+	storedHash, err := s.storedLinkHash(ctx, linkHash)
+	if err != nil {
+		return 0, err
+	}
+
+	return store.GetLinkHeight(ctx, s.a, storedHash)
+}
+
+/********** github.com/stratumn/go-indigocore/store.StateOpeningStore implementation **********/
+
+// SaveStateOpenings implements
+// github.com/stratumn/go-indigocore/store.StateOpeningStore.SaveStateOpenings.
+// The state openings are checked against the plaintext link, encrypted with
+// the current key of its process and saved in the key-value store.
+func (s *Store) SaveStateOpenings(ctx context.Context, linkHash *types.Bytes32, openings []*cs.StateOpening) error {
+	if len(openings) == 0 {
+		return store.ErrNoStateOpenings
+	}
+
+	segment, err := s.GetSegment(ctx, linkHash)
+	if err != nil {
+		return err
+	}
+	if segment == nil {
+		return store.ErrLinkNotFound
+	}
+
+	for _, o := range openings {
+		if o == nil {
+			return store.ErrNoStateOpenings
+		}
+		if err := o.Verify(&segment.Link); err != nil {
+			return err
+		}
+	}
+
+	value, err := s.encryptStateOpenings(ctx, linkHash, segment.Link.Meta.Process, openings)
+	if err != nil {
+		return err
+	}
+
+	key := []byte(stateOpeningsPrefix + linkHash.String())
+	created, err := store.CreateValue(ctx, s.kv, key, value)
+	if err == store.ErrCreateValueUnavailable {
+		return store.ErrStateOpeningsUnavailable
+	}
+	if err != nil || created {
+		return err
+	}
+
+	prev, err := s.kv.GetValue(ctx, key)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(prev, stateOpeningsPurged) {
+		return store.ErrStateOpeningsPurged
+	}
+	return store.ErrStateOpeningsExist
+}
+
+// GetStateOpenings implements
+// github.com/stratumn/go-indigocore/store.StateOpeningStore.GetStateOpenings.
+func (s *Store) GetStateOpenings(ctx context.Context, linkHash *types.Bytes32) ([]*cs.StateOpening, error) {
+	value, err := s.kv.GetValue(ctx, []byte(stateOpeningsPrefix+linkHash.String()))
+	if err != nil {
+		return nil, err
+	}
+
+	openings := []*cs.StateOpening{}
+	if value == nil || bytes.Equal(value, stateOpeningsPurged) {
+		return openings, nil
+	}
+
+	storedHash, err := s.storedLinkHash(ctx, linkHash)
+	if err != nil {
+		return nil, err
+	}
+	segment, err := s.a.GetSegment(ctx, storedHash)
+	if err != nil {
+		return nil, err
+	}
+	if segment == nil {
+		return nil, store.ErrLinkNotFound
+	}
+
+	return s.decryptStateOpenings(ctx, linkHash, segment.Link.Meta.Process, value)
+}
+
+// PurgeStateOpenings implements
+// github.com/stratumn/go-indigocore/store.StateOpeningStore.PurgeStateOpenings.
+func (s *Store) PurgeStateOpenings(ctx context.Context, linkHash *types.Bytes32) error {
+	return s.kv.SetValue(ctx, []byte(stateOpeningsPrefix+linkHash.String()), stateOpeningsPurged)
+}
+
+/********** github.com/stratumn/go-indigocore/store.KeyValueStore implementation **********/
+
+// GetValue implements github.com/stratumn/go-indigocore/store.KeyValueStore.GetValue.
+func (s *Store) GetValue(ctx context.Context, key []byte) ([]byte, error) {
+	return s.kv.GetValue(ctx, key)
+}
+
+// SetValue implements github.com/stratumn/go-indigocore/store.KeyValueStore.SetValue.
+func (s *Store) SetValue(ctx context.Context, key, value []byte) error {
+	return s.kv.SetValue(ctx, key, value)
+}
+
+// CreateValue implements github.com/stratumn/go-indigocore/store.KeyValueCreator.CreateValue.
+func (s *Store) CreateValue(ctx context.Context, key, value []byte) (bool, error) {
+	return store.CreateValue(ctx, s.kv, key, value)
+}
+
+// DeleteValue implements github.com/stratumn/go-indigocore/store.KeyValueStore.DeleteValue.
+func (s *Store) DeleteValue(ctx context.Context, key []byte) ([]byte, error) {
+	return s.kv.DeleteValue(ctx, key)
+}
@@ -0,0 +1,385 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryptedstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/cs/cstesting"
+	"github.com/stratumn/go-indigocore/dummystore"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/store/storetestcases"
+	"github.com/stratumn/go-indigocore/store/storetesting"
+	"github.com/stratumn/go-indigocore/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newStore(t *testing.T) (*Store, *dummystore.DummyStore, *FileKeyProvider, func()) {
+	path, free := tempKeyFile(t)
+
+	p, err := NewFileKeyProvider(path)
+	require.NoError(t, err)
+	_, err = p.Rotate("")
+	require.NoError(t, err)
+
+	a := dummystore.New(&dummystore.Config{})
+	s, err := New(a, &Config{KeyProvider: p})
+	require.NoError(t, err)
+
+	return s, a, p, free
+}
+
+func TestEncryptedStore(t *testing.T) {
+	var frees []func()
+	defer func() {
+		for _, free := range frees {
+			free()
+		}
+	}()
+
+	factory := storetestcases.Factory{
+		New: func() (store.Adapter, error) {
+			s, _, _, free := newStore(t)
+			frees = append(frees, free)
+			return s, nil
+		},
+		NewKeyValueStore: func() (store.KeyValueStore, error) {
+			s, _, _, free := newStore(t)
+			frees = append(frees, free)
+			return s, nil
+		},
+	}
+
+	factory.RunStoreTests(t)
+	factory.RunKeyValueStoreTests(t)
+}
+
+func TestNew_errors(t *testing.T) {
+	a := dummystore.New(&dummystore.Config{})
+	p := &FileKeyProvider{}
+
+	_, err := New(a, &Config{})
+	assert.Equal(t, ErrNoKeyProvider, err)
+
+	_, err = New(&storetesting.MockAdapter{}, &Config{KeyProvider: p})
+	assert.Equal(t, ErrNoKeyValueStore, err)
+
+	_, err = New(a, &Config{KeyProvider: p, Fields: []string{"meta.tags"}})
+	assert.Error(t, err)
+}
+
+func TestCreateLink_encryptsAtRest(t *testing.T) {
+	ctx := context.Background()
+	s, a, _, free := newStore(t)
+	defer free()
+
+	link := cstesting.RandomLink()
+	want, err := link.Hash()
+	require.NoError(t, err)
+
+	lh, err := s.CreateLink(ctx, link)
+	require.NoError(t, err)
+	assert.Equal(t, want, lh, "link hash should be computed on the plaintext link")
+
+	stored, err := a.FindSegments(ctx, &store.SegmentFilter{
+		Pagination: store.Pagination{Limit: store.DefaultLimit},
+		MapIDs:     []string{link.Meta.MapID},
+	})
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+
+	assert.Contains(t, stored[0].Link.State, envelopeKey)
+	assert.Contains(t, stored[0].Link.Meta.Data, envelopeKey)
+	assert.Equal(t, link.Meta.Process, stored[0].Link.Meta.Process)
+	assert.Equal(t, link.Meta.Tags, stored[0].Link.Meta.Tags)
+	assert.NotEqual(t, lh.String(), stored[0].GetLinkHashString())
+
+	got, err := s.GetSegment(ctx, lh)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, link, &got.Link)
+	assert.Equal(t, lh.String(), got.GetLinkHashString())
+	assert.NoError(t, got.Validate(ctx, nil))
+}
+
+func TestCreateLink_fields(t *testing.T) {
+	ctx := context.Background()
+	s, a, p, free := newStore(t)
+	defer free()
+
+	s, err := New(a, &Config{KeyProvider: p, Fields: []string{FieldState}})
+	require.NoError(t, err)
+
+	link := cstesting.RandomLink()
+	_, err = s.CreateLink(ctx, link)
+	require.NoError(t, err)
+
+	stored, err := a.FindSegments(ctx, &store.SegmentFilter{
+		Pagination: store.Pagination{Limit: store.DefaultLimit},
+		MapIDs:     []string{link.Meta.MapID},
+	})
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	assert.Contains(t, stored[0].Link.State, envelopeKey)
+	assert.Equal(t, link.Meta.Data, stored[0].Link.Meta.Data)
+}
+
+func TestKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	s, _, p, free := newStore(t)
+	defer free()
+
+	before := cstesting.RandomLink()
+	beforeHash, err := s.CreateLink(ctx, before)
+	require.NoError(t, err)
+
+	_, err = p.Rotate(before.Meta.Process)
+	require.NoError(t, err)
+
+	after := cstesting.RandomLink()
+	after.Meta.Process = before.Meta.Process
+	afterHash, err := s.CreateLink(ctx, after)
+	require.NoError(t, err)
+
+	for lh, want := range map[*types.Bytes32]*cs.Link{beforeHash: before, afterHash: after} {
+		got, err := s.GetSegment(ctx, lh)
+		require.NoError(t, err)
+		require.NotNil(t, got)
+		assert.Equal(t, want, &got.Link)
+	}
+}
+
+func TestDecrypt_tampered(t *testing.T) {
+	ctx := context.Background()
+	s, a, _, free := newStore(t)
+	defer free()
+
+	link := cstesting.RandomLink()
+	encrypted, err := s.encryptLink(ctx, link)
+	require.NoError(t, err)
+
+	// Moving the encrypted state to another process must fail.
+	encrypted.Meta.Process = "other"
+	_, err = a.CreateLink(ctx, encrypted)
+	require.NoError(t, err)
+
+	lh, err := encrypted.Hash()
+	require.NoError(t, err)
+	_, err = s.GetSegment(ctx, lh)
+	assert.Error(t, err)
+}
+
+func TestDecrypt_movedCiphertext(t *testing.T) {
+	ctx := context.Background()
+	s, a, _, free := newStore(t)
+	defer free()
+
+	link := cstesting.RandomLink()
+	_, err := s.CreateLink(ctx, link)
+	require.NoError(t, err)
+	encrypted, err := s.encryptLink(ctx, link)
+	require.NoError(t, err)
+
+	// Moving the encrypted state to another link of the process must fail.
+	other := cstesting.RandomLink()
+	other.Meta.Process = link.Meta.Process
+	otherHash, err := s.CreateLink(ctx, other)
+	require.NoError(t, err)
+	storedHash, err := s.storedLinkHash(ctx, otherHash)
+	require.NoError(t, err)
+	stored, err := a.GetSegment(ctx, storedHash)
+	require.NoError(t, err)
+
+	moved := stored.Link
+	moved.State = encrypted.State
+	movedHash, err := moved.HashString()
+	require.NoError(t, err)
+	require.NoError(t, s.kv.SetValue(ctx, []byte(storedHashPrefix+movedHash), []byte(otherHash.String())))
+
+	_, err = s.decryptLink(ctx, &moved)
+	assert.Error(t, err)
+}
+
+func TestCreateLink_duplicate(t *testing.T) {
+	ctx := context.Background()
+	s, a, p, free := newStore(t)
+	defer free()
+
+	link := cstesting.RandomLink()
+	want, err := s.CreateLink(ctx, link)
+	require.NoError(t, err)
+
+	_, err = p.Rotate(link.Meta.Process)
+	require.NoError(t, err)
+
+	lh, err := s.CreateLink(ctx, link)
+	require.NoError(t, err)
+	assert.Equal(t, want, lh)
+
+	results, err := s.CreateLinks(ctx, []*cs.Link{link}, store.WriteModeCommit)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, want, results[0].LinkHash)
+	assert.Equal(t, store.LinkCommitted, results[0].Status)
+
+	stored, err := a.FindSegments(ctx, &store.SegmentFilter{
+		Pagination: store.Pagination{Limit: store.DefaultLimit},
+		MapIDs:     []string{link.Meta.MapID},
+	})
+	require.NoError(t, err)
+	assert.Len(t, stored, 1, "the link should be stored once")
+}
+
+func TestEncryptLink_deterministic(t *testing.T) {
+	ctx := context.Background()
+	s, _, _, free := newStore(t)
+	defer free()
+
+	link := cstesting.RandomLink()
+	first, err := s.encryptLink(ctx, link)
+	require.NoError(t, err)
+	second, err := s.encryptLink(ctx, link)
+	require.NoError(t, err)
+
+	firstHash, err := first.Hash()
+	require.NoError(t, err)
+	secondHash, err := second.Hash()
+	require.NoError(t, err)
+	assert.Equal(t, firstHash, secondHash)
+}
+
+func TestAddEvidence(t *testing.T) {
+	ctx := context.Background()
+	s, a, _, free := newStore(t)
+	defer free()
+
+	events := make(chan *store.Event, 10)
+	s.AddStoreEventChannel(events)
+
+	link := cstesting.RandomLink()
+	lh, err := s.CreateLink(ctx, link)
+	require.NoError(t, err)
+
+	evidence := cstesting.RandomEvidence()
+	require.NoError(t, s.AddEvidence(ctx, lh, evidence))
+
+	evidences, err := s.GetEvidences(ctx, lh)
+	require.NoError(t, err)
+	require.Len(t, *evidences, 1)
+
+	segment, err := s.GetSegment(ctx, lh)
+	require.NoError(t, err)
+	assert.Len(t, segment.Meta.Evidences, 1)
+
+	storedHash, err := s.storedLinkHash(ctx, lh)
+	require.NoError(t, err)
+	evidences, err = a.GetEvidences(ctx, storedHash)
+	require.NoError(t, err)
+	assert.Len(t, *evidences, 1, "evidence should be saved with the stored link")
+
+	for _, want := range []store.EventType{store.SavedLinks, store.SavedEvidences} {
+		select {
+		case event := <-events:
+			require.Equal(t, want, event.EventType)
+			if want == store.SavedLinks {
+				assert.Equal(t, []*cs.Link{link}, event.Data)
+			} else {
+				assert.Contains(t, event.Data, lh.String())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no %s event", want)
+		}
+	}
+}
+
+func TestFindSegments_linkHashes(t *testing.T) {
+	ctx := context.Background()
+	s, _, _, free := newStore(t)
+	defer free()
+
+	lh, err := s.CreateLink(ctx, cstesting.RandomLink())
+	require.NoError(t, err)
+	_, err = s.CreateLink(ctx, cstesting.RandomLink())
+	require.NoError(t, err)
+
+	segments, err := s.FindSegments(ctx, &store.SegmentFilter{
+		Pagination: store.Pagination{Limit: store.DefaultLimit},
+		LinkHashes: []string{lh.String()},
+	})
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+	assert.Equal(t, lh.String(), segments[0].GetLinkHashString())
+}
+
+func TestStateOpenings(t *testing.T) {
+	ctx := context.Background()
+	s, a, _, free := newStore(t)
+	defer free()
+
+	link := cstesting.NewLinkBuilder().
+		WithState(map[string]interface{}{"name": "Alice"}).
+		Build()
+	openings, err := link.CommitState("name")
+	require.NoError(t, err)
+	lh, err := s.CreateLink(ctx, link)
+	require.NoError(t, err)
+
+	got, err := s.GetStateOpenings(ctx, lh)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	require.NoError(t, store.SaveStateOpenings(ctx, s, lh, openings))
+
+	got, err = s.GetStateOpenings(ctx, lh)
+	require.NoError(t, err)
+	assert.Equal(t, openings, got)
+
+	raw, err := a.GetValue(ctx, []byte(stateOpeningsPrefix+lh.String()))
+	require.NoError(t, err)
+	require.NotNil(t, raw)
+	assert.NotContains(t, string(raw), "Alice", "state openings should be encrypted at rest")
+
+	err = s.SaveStateOpenings(ctx, lh, openings)
+	assert.Equal(t, store.ErrStateOpeningsExist, err)
+
+	require.NoError(t, s.PurgeStateOpenings(ctx, lh))
+	got, err = s.GetStateOpenings(ctx, lh)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	err = s.SaveStateOpenings(ctx, lh, openings)
+	assert.Equal(t, store.ErrStateOpeningsPurged, err)
+}
+
+func TestSaveStateOpenings_errors(t *testing.T) {
+	ctx := context.Background()
+	s, _, _, free := newStore(t)
+	defer free()
+
+	link := cstesting.NewLinkBuilder().
+		WithState(map[string]interface{}{"name": "Alice"}).
+		Build()
+	openings, err := link.CommitState("name")
+	require.NoError(t, err)
+	lh, err := link.Hash()
+	require.NoError(t, err)
+
+	assert.Equal(t, store.ErrNoStateOpenings, s.SaveStateOpenings(ctx, lh, nil))
+	assert.Equal(t, store.ErrLinkNotFound, s.SaveStateOpenings(ctx, lh, openings))
+}
@@ -0,0 +1,121 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryptedstore
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/store"
+)
+
+// eventChanSize is the size of the channel receiving events from the
+// underlying store.
+const eventChanSize = 256
+
+// startEvents subscribes to the events of the underlying store.
+//
+// Events are queued before being translated so that the underlying store
+// never waits on the decorator, which reads the underlying key-value store
+// to translate evidence events.
+func (s *Store) startEvents() {
+	in := make(chan *store.Event, eventChanSize)
+	s.a.AddStoreEventChannel(in)
+
+	var (
+		mutex sync.Mutex
+		cond  = sync.NewCond(&mutex)
+		queue = list.New()
+	)
+
+	go func() {
+		for event := range in {
+			mutex.Lock()
+			queue.PushBack(event)
+			mutex.Unlock()
+			cond.Signal()
+		}
+	}()
+
+	go func() {
+		for {
+			mutex.Lock()
+			for queue.Len() == 0 {
+				cond.Wait()
+			}
+			event := queue.Remove(queue.Front()).(*store.Event)
+			mutex.Unlock()
+
+			s.forwardEvent(s.translateEvent(context.Background(), event))
+		}
+	}()
+}
+
+// translateEvent decrypts the links of an event and replaces stored link
+// hashes by plaintext link hashes.
+func (s *Store) translateEvent(ctx context.Context, event *store.Event) *store.Event {
+	switch event.EventType {
+	case store.SavedLinks:
+		links, ok := event.Data.([]*cs.Link)
+		if !ok {
+			return event
+		}
+
+		decrypted := make([]*cs.Link, 0, len(links))
+		for _, link := range links {
+			d, err := s.decryptLink(ctx, link)
+			if err != nil {
+				log.WithField("error", err).Warn("Failed to decrypt saved link")
+				continue
+			}
+			decrypted = append(decrypted, d)
+		}
+
+		return store.NewSavedLinks(decrypted...)
+
+	case store.SavedEvidences:
+		evidences, ok := event.Data.(map[string]*cs.Evidence)
+		if !ok {
+			return event
+		}
+
+		translated := make(map[string]*cs.Evidence, len(evidences))
+		for linkHash, evidence := range evidences {
+			plain, err := s.plainLinkHash(ctx, linkHash)
+			if err != nil {
+				log.WithField("error", err).Warn("Failed to map stored link hash")
+				plain = linkHash
+			}
+			translated[plain] = evidence
+		}
+
+		return &store.Event{EventType: store.SavedEvidences, Data: translated}
+	}
+
+	return event
+}
+
+func (s *Store) forwardEvent(event *store.Event) {
+	s.eventsMutex.RLock()
+	defer s.eventsMutex.RUnlock()
+
+	for _, c := range s.eventChans {
+		c <- event
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryptedstore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// KeySize is the size in bytes of an encryption key.
+const KeySize = 32
+
+var (
+	// ErrKeyNotFound is returned when a key provider doesn't have the key
+	// of a process.
+	ErrKeyNotFound = errors.New("encryption key not found")
+
+	// ErrInvalidKey is returned when a key doesn't have the right size.
+	ErrInvalidKey = errors.New("encryption key must be 32 bytes long")
+)
+
+// Key is an encryption key.
+type Key struct {
+	// ID identifies the key. It is saved alongside encrypted data so that
+	// the right key can be used to decrypt it after a key rotation.
+	ID string
+
+	// Secret is the AES-256 key.
+	Secret []byte
+}
+
+// GenerateKey creates a random key.
+func GenerateKey() (*Key, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	secret := make([]byte, KeySize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &Key{ID: hex.EncodeToString(id), Secret: secret}, nil
+}
+
+// KeyProvider provides the keys used to encrypt the links of a process.
+//
+// Keys are rotated by changing the current key of a process. Previous keys
+// must remain available to decrypt the links they encrypted.
+type KeyProvider interface {
+	// CurrentKey returns the key used to encrypt new links of a process.
+	CurrentKey(ctx context.Context, process string) (*Key, error)
+
+	// GetKey returns a key of a process given its ID.
+	GetKey(ctx context.Context, process, id string) (*Key, error)
+}
+
+// keyRing contains the keys of a process.
+type keyRing struct {
+	Current string            `json:"current"`
+	Keys    map[string][]byte `json:"keys"`
+}
+
+// keyFile is the content of the file of a FileKeyProvider.
+type keyFile struct {
+	Default   *keyRing            `json:"default"`
+	Processes map[string]*keyRing `json:"processes"`
+}
+
+// FileKeyProvider is a KeyProvider that reads keys from a JSON file:
+//
+//	{
+//		"default": {"current": "id2", "keys": {"id1": "base64", "id2": "base64"}},
+//		"processes": {
+//			"process": {"current": "id3", "keys": {"id3": "base64"}}
+//		}
+//	}
+//
+// Processes that don't have keys use the default keys.
+type FileKeyProvider struct {
+	path string
+
+	mutex sync.RWMutex
+	file  keyFile
+}
+
+// NewFileKeyProvider creates a key provider reading the given file.
+// A missing file is treated as an empty one.
+func NewFileKeyProvider(path string) (*FileKeyProvider, error) {
+	p := &FileKeyProvider{path: path}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reload reads the file again, for instance after keys were rotated by
+// another process.
+func (p *FileKeyProvider) Reload() error {
+	var file keyFile
+
+	data, err := ioutil.ReadFile(p.path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &file); err != nil {
+			return errors.Wrap(err, p.path)
+		}
+	}
+
+	rings := []*keyRing{file.Default}
+	for _, ring := range file.Processes {
+		rings = append(rings, ring)
+	}
+	for _, ring := range rings {
+		if ring == nil {
+			continue
+		}
+		for id, secret := range ring.Keys {
+			if len(secret) != KeySize {
+				return errors.Wrap(ErrInvalidKey, id)
+			}
+		}
+	}
+
+	p.mutex.Lock()
+	p.file = file
+	p.mutex.Unlock()
+
+	return nil
+}
+
+// Rotate generates a new key for a process, makes it the current one and
+// saves the file. The empty process rotates the default key.
+// Previous keys are kept to decrypt existing links.
+func (p *FileKeyProvider) Rotate(process string) (*Key, error) {
+	key, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	ring := p.file.Default
+	if process != "" {
+		ring = p.file.Processes[process]
+	}
+	if ring == nil {
+		ring = &keyRing{}
+		if process == "" {
+			p.file.Default = ring
+		} else {
+			if p.file.Processes == nil {
+				p.file.Processes = make(map[string]*keyRing)
+			}
+			p.file.Processes[process] = ring
+		}
+	}
+	if ring.Keys == nil {
+		ring.Keys = make(map[string][]byte)
+	}
+
+	ring.Keys[key.ID] = key.Secret
+	ring.Current = key.ID
+
+	if err := p.save(); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// save atomically writes the file. It must be called with the mutex locked.
+func (p *FileKeyProvider) save() error {
+	data, err := json.MarshalIndent(p.file, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(p.path), ".keys-")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.WithStack(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(os.Rename(tmp.Name(), p.path))
+}
+
+// ring returns the keys of a process. It must be called with the mutex
+// locked.
+func (p *FileKeyProvider) ring(process string) *keyRing {
+	if ring, ok := p.file.Processes[process]; ok {
+		return ring
+	}
+	return p.file.Default
+}
+
+// CurrentKey implements
+// github.com/stratumn/go-indigocore/encryptedstore.KeyProvider.CurrentKey.
+func (p *FileKeyProvider) CurrentKey(ctx context.Context, process string) (*Key, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	ring := p.ring(process)
+	if ring == nil || ring.Keys[ring.Current] == nil {
+		return nil, errors.Wrap(ErrKeyNotFound, process)
+	}
+
+	return &Key{ID: ring.Current, Secret: ring.Keys[ring.Current]}, nil
+}
+
+// GetKey implements
+// github.com/stratumn/go-indigocore/encryptedstore.KeyProvider.GetKey.
+// Default keys are also looked up, since a process may have used them
+// before getting its own keys.
+func (p *FileKeyProvider) GetKey(ctx context.Context, process, id string) (*Key, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	for _, ring := range []*keyRing{p.ring(process), p.file.Default} {
+		if ring == nil {
+			continue
+		}
+		if secret, ok := ring.Keys[id]; ok {
+			return &Key{ID: id, Secret: secret}, nil
+		}
+	}
+
+	return nil, errors.Wrapf(ErrKeyNotFound, "%s/%s", process, id)
+}
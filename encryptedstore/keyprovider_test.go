@@ -0,0 +1,116 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryptedstore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tempKeyFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "encryptedstore")
+	require.NoError(t, err)
+	return filepath.Join(dir, "keys.json"), func() { os.RemoveAll(dir) }
+}
+
+func TestFileKeyProvider_empty(t *testing.T) {
+	path, free := tempKeyFile(t)
+	defer free()
+
+	p, err := NewFileKeyProvider(path)
+	require.NoError(t, err)
+
+	_, err = p.CurrentKey(context.Background(), "process")
+	assert.Equal(t, ErrKeyNotFound, errors.Cause(err))
+}
+
+func TestFileKeyProvider_Rotate(t *testing.T) {
+	ctx := context.Background()
+	path, free := tempKeyFile(t)
+	defer free()
+
+	p, err := NewFileKeyProvider(path)
+	require.NoError(t, err)
+
+	def1, err := p.Rotate("")
+	require.NoError(t, err)
+
+	key, err := p.CurrentKey(ctx, "process")
+	require.NoError(t, err)
+	assert.Equal(t, def1, key, "processes without keys should use the default key")
+
+	proc1, err := p.Rotate("process")
+	require.NoError(t, err)
+	proc2, err := p.Rotate("process")
+	require.NoError(t, err)
+
+	key, err = p.CurrentKey(ctx, "process")
+	require.NoError(t, err)
+	assert.Equal(t, proc2, key)
+
+	for _, want := range []*Key{def1, proc1, proc2} {
+		key, err := p.GetKey(ctx, "process", want.ID)
+		require.NoError(t, err)
+		assert.Equal(t, want, key, "previous keys should remain available")
+	}
+
+	_, err = p.GetKey(ctx, "other", proc1.ID)
+	assert.Equal(t, ErrKeyNotFound, errors.Cause(err))
+
+	reloaded, err := NewFileKeyProvider(path)
+	require.NoError(t, err)
+	key, err = reloaded.CurrentKey(ctx, "process")
+	require.NoError(t, err)
+	assert.Equal(t, proc2, key, "keys should be saved")
+}
+
+func TestFileKeyProvider_Reload(t *testing.T) {
+	ctx := context.Background()
+	path, free := tempKeyFile(t)
+	defer free()
+
+	p, err := NewFileKeyProvider(path)
+	require.NoError(t, err)
+	other, err := NewFileKeyProvider(path)
+	require.NoError(t, err)
+
+	key, err := other.Rotate("")
+	require.NoError(t, err)
+
+	_, err = p.CurrentKey(ctx, "process")
+	assert.Error(t, err)
+
+	require.NoError(t, p.Reload())
+	got, err := p.CurrentKey(ctx, "process")
+	require.NoError(t, err)
+	assert.Equal(t, key, got)
+}
+
+func TestFileKeyProvider_invalidKey(t *testing.T) {
+	path, free := tempKeyFile(t)
+	defer free()
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"default":{"current":"k","keys":{"k":"c2hvcnQ="}}}`), 0600))
+
+	_, err := NewFileKeyProvider(path)
+	assert.Equal(t, ErrInvalidKey, errors.Cause(err))
+}
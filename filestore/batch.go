@@ -37,7 +37,9 @@ func NewBatch(ctx context.Context, a *FileStore) *Batch {
 	}
 }
 
-// Write implements github.com/stratumn/go-indigocore/store.Batch.Write
+// Write implements github.com/stratumn/go-indigocore/store.Batch.Write.
+// The links of the batch are written atomically: either all of them are
+// saved, or none is.
 func (b *Batch) Write(ctx context.Context) (err error) {
 	ctx, span := trace.StartSpan(ctx, "filestore/batch/Write")
 	defer monitoring.SetSpanStatusAndEnd(span, err)
@@ -45,11 +47,6 @@ func (b *Batch) Write(ctx context.Context) (err error) {
 	b.originalFileStore.mutex.Lock()
 	defer b.originalFileStore.mutex.Unlock()
 
-	for _, link := range b.Links {
-		if _, err := b.originalFileStore.createLink(link); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	_, err = b.originalFileStore.writeLinks(ctx, b.Links)
+	return
 }
@@ -16,23 +16,22 @@
 // system.
 //
 // The segments are stored as JSON files named after the link hashes.
-// It's a convenient store to use during the development of an agent.
-// However, because it doesn't use an index, it's very slow, and shouldn't be
-// used for production.
+// An index of the links by process, map ID, previous link hash and tag is
+// kept in a LevelDB database in the index sub-directory.
+// It's a convenient store to use during the development of an agent, but it
+// shouldn't be used for production.
 package filestore
 
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
-	"path/filepath"
 	"regexp"
-	"sort"
 	"sync"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/stratumn/go-indigocore/cs"
 	"github.com/stratumn/go-indigocore/leveldbstore"
 	"github.com/stratumn/go-indigocore/monitoring"
@@ -57,6 +56,11 @@ type FileStore struct {
 	eventChans []chan *store.Event
 	mutex      sync.RWMutex // simple global mutex
 	kvDB       store.KeyValueStore
+	indexDB    *leveldbstore.LevelDBStore
+
+	// testHookWriteLink is called by tests before the i-th link of a batch
+	// is saved, to make the write fail.
+	testHookWriteLink func(i int) error
 }
 
 // Config contains configuration options for the store.
@@ -89,12 +93,34 @@ func New(config *Config) (*FileStore, error) {
 		return nil, err
 	}
 
-	return &FileStore{
-		config,
-		nil,
-		sync.RWMutex{},
-		monitoring.NewKeyValueStoreAdapter(db, "leveldbstore"),
-	}, nil
+	// The index has its own database so that it doesn't share the
+	// namespace of the public key-value store.
+	indexDB, err := leveldbstore.New(&leveldbstore.Config{
+		Path: path.Join(config.Path, indexDir),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	a := &FileStore{
+		config:  config,
+		kvDB:    monitoring.NewKeyValueStoreAdapter(db, "leveldbstore"),
+		indexDB: indexDB,
+	}
+
+	ctx := context.Background()
+	outdated, err := a.indexOutdated(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if outdated {
+		log.WithField("path", config.Path).Info("Rebuilding filestore index")
+		if err := a.rebuildIndex(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
 }
 
 /********** Store adapter implementation **********/
@@ -126,37 +152,149 @@ func (a *FileStore) CreateLink(ctx context.Context, link *cs.Link) (*types.Bytes
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
 
-	return a.createLink(link)
+	return a.createLink(ctx, link)
 }
 
-func (a *FileStore) createLink(link *cs.Link) (*types.Bytes32, error) {
-	linkHash, err := link.Hash()
+func (a *FileStore) createLink(ctx context.Context, link *cs.Link) (*types.Bytes32, error) {
+	linkHashes, err := a.writeLinks(ctx, []*cs.Link{link})
 	if err != nil {
 		return nil, err
 	}
 
-	if err = a.initDir(); err != nil {
+	return linkHashes[0], nil
+}
+
+// pendingLink is a link written to a temporary file.
+type pendingLink struct {
+	linkHash *types.Bytes32
+	link     *cs.Link
+	tmpPath  string
+	existed  bool
+}
+
+// writeLinks writes links atomically. The links are first written to
+// temporary files which are then renamed. If anything fails, the links
+// that were added are removed from the directory and from the index.
+// If the store stops before the links are indexed, the index is rebuilt
+// the next time it is opened.
+// The mutex should be locked by the caller.
+func (a *FileStore) writeLinks(ctx context.Context, links []*cs.Link) ([]*types.Bytes32, error) {
+	if err := a.initDir(); err != nil {
 		return nil, err
 	}
 
-	js, err := json.MarshalIndent(link, "", "  ")
+	pendings := make([]*pendingLink, 0, len(links))
+	defer func() {
+		for _, p := range pendings {
+			os.Remove(p.tmpPath)
+		}
+	}()
+
+	for _, link := range links {
+		p, err := a.writeTmpLink(link)
+		if p != nil {
+			pendings = append(pendings, p)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The index is marked dirty until the links are indexed so that it is
+	// rebuilt when the store is opened if it stopped in between.
+	if err := a.setIndexDirty(ctx); err != nil {
+		return nil, err
+	}
+
+	var added []*pendingLink
+	rollback := func() {
+		var failed bool
+		for _, p := range added {
+			if !p.existed {
+				if err := os.Remove(a.getLinkPath(p.linkHash)); err != nil && !os.IsNotExist(err) {
+					failed = true
+				}
+				if err := a.unindexLink(ctx, p.linkHash, p.link); err != nil {
+					failed = true
+				}
+			}
+		}
+		if !failed {
+			a.clearIndexDirty(ctx)
+		}
+	}
+
+	for i, p := range pendings {
+		linkPath := a.getLinkPath(p.linkHash)
+		if _, err := os.Stat(linkPath); err == nil {
+			p.existed = true
+		}
+
+		if a.testHookWriteLink != nil {
+			if err := a.testHookWriteLink(i); err != nil {
+				rollback()
+				return nil, err
+			}
+		}
+
+		if err := os.Rename(p.tmpPath, linkPath); err != nil {
+			rollback()
+			return nil, err
+		}
+		added = append(added, p)
+	}
+
+	linkHashes := make([]*types.Bytes32, len(pendings))
+	for i, p := range pendings {
+		if err := a.indexLink(ctx, p.linkHash, p.link); err != nil {
+			rollback()
+			return nil, err
+		}
+		linkHashes[i] = p.linkHash
+	}
+
+	if err := a.clearIndexDirty(ctx); err != nil {
+		log.WithField("error", err).Warn("Could not clear the filestore index dirty marker")
+	}
+
+	if len(links) > 0 {
+		linksEvent := store.NewSavedLinks(links...)
+
+		for _, c := range a.eventChans {
+			c <- linksEvent
+		}
+	}
+
+	return linkHashes, nil
+}
+
+func (a *FileStore) writeTmpLink(link *cs.Link) (*pendingLink, error) {
+	linkHash, err := link.Hash()
 	if err != nil {
 		return nil, err
 	}
 
-	linkPath := a.getLinkPath(linkHash)
+	js, err := json.MarshalIndent(link, "", "  ")
+	if err != nil {
+		return nil, err
+	}
 
-	if err := ioutil.WriteFile(linkPath, js, 0644); err != nil {
+	f, err := ioutil.TempFile(a.config.Path, ".tmp-")
+	if err != nil {
 		return nil, err
 	}
 
-	linkEvent := store.NewSavedLinks(link)
+	p := &pendingLink{linkHash: linkHash, link: link, tmpPath: f.Name()}
 
-	for _, c := range a.eventChans {
-		c <- linkEvent
+	_, err = f.Write(js)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(p.tmpPath, 0644)
 	}
 
-	return linkHash, nil
+	return p, err
 }
 
 // AddEvidence implements github.com/stratumn/go-indigocore/store.EvidenceWriter.AddEvidence.
@@ -222,36 +360,56 @@ func (a *FileStore) getSegment(ctx context.Context, linkHash *types.Bytes32) (_
 
 // FindSegments implements github.com/stratumn/go-indigocore/store.SegmentReader.FindSegments.
 func (a *FileStore) FindSegments(ctx context.Context, filter *store.SegmentFilter) (cs.SegmentSlice, error) {
-	var segments cs.SegmentSlice
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	linkHashes, err := a.findLinkHashes(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.getSegments(ctx, filter.Pagination.PaginateStrings(linkHashes))
+}
+
+// Search implements github.com/stratumn/go-indigocore/store.Searcher.Search.
+func (a *FileStore) Search(ctx context.Context, query *store.SearchQuery) (cs.SegmentSlice, error) {
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
 
-	a.forEach(ctx, func(segment *cs.Segment) error {
-		if filter.Match(segment) {
+	linkHashes, err := a.findLinkHashes(ctx, &query.SegmentFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, err := a.getSegments(ctx, linkHashes)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := cs.SegmentSlice{}
+	for _, segment := range candidates {
+		if query.Match(segment) {
 			segments = append(segments, segment)
 		}
-		return nil
-	})
-
-	sort.Sort(segments)
+	}
 
-	return filter.Pagination.PaginateSegments(segments), nil
+	return query.Pagination.PaginateSegments(segments), nil
 }
 
 // GetMapIDs implements github.com/stratumn/go-indigocore/store.SegmentReader.GetMapIDs.
 func (a *FileStore) GetMapIDs(ctx context.Context, filter *store.MapFilter) ([]string, error) {
-	set := map[string]struct{}{}
-	a.forEach(ctx, func(segment *cs.Segment) error {
-		if filter.Match(segment) {
-			set[segment.Link.Meta.MapID] = struct{}{}
-		}
-		return nil
-	})
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
 
-	var mapIDs []string
-	for mapID := range set {
-		mapIDs = append(mapIDs, mapID)
+	mapIDs, err := a.findMapIDs(ctx, filter.Process)
+	if err != nil {
+		return nil, err
 	}
 
-	sort.Strings(mapIDs)
 	return filter.Pagination.PaginateStrings(mapIDs), nil
 }
 
@@ -308,6 +466,11 @@ func (a *FileStore) GetValue(ctx context.Context, key []byte) ([]byte, error) {
 	return a.kvDB.GetValue(ctx, key)
 }
 
+// CreateValue implements github.com/stratumn/go-indigocore/store.KeyValueCreator.CreateValue.
+func (a *FileStore) CreateValue(ctx context.Context, key []byte, value []byte) (bool, error) {
+	return store.CreateValue(ctx, a.kvDB, key, value)
+}
+
 // DeleteValue implements github.com/stratumn/go-indigocore/store.KeyValueStore.DeleteValue.
 func (a *FileStore) DeleteValue(ctx context.Context, key []byte) ([]byte, error) {
 	return a.kvDB.DeleteValue(ctx, key)
@@ -329,40 +492,3 @@ func (a *FileStore) getLinkPath(linkHash *types.Bytes32) string {
 }
 
 var linkFileRegex = regexp.MustCompile("(.*)\\.json$")
-
-func (a *FileStore) forEach(ctx context.Context, fn func(*cs.Segment) error) error {
-	a.mutex.RLock()
-	defer a.mutex.RUnlock()
-
-	files, err := ioutil.ReadDir(a.config.Path)
-	if os.IsNotExist(err) {
-		return nil
-	}
-	if err != nil {
-		return err
-	}
-
-	for _, file := range files {
-		name := file.Name()
-		if linkFileRegex.MatchString(name) {
-			linkHashStr := name[:len(name)-5]
-			linkHash, err := types.NewBytes32FromString(linkHashStr)
-			if err != nil {
-				return err
-			}
-
-			segment, err := a.getSegment(ctx, linkHash)
-			if err != nil {
-				return err
-			}
-			if segment == nil {
-				return fmt.Errorf("could not find segment %q", filepath.Base(name))
-			}
-			if err = fn(segment); err != nil {
-				return err
-			}
-		}
-	}
-
-	return nil
-}
@@ -15,10 +15,18 @@
 package filestore
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/stratumn/go-indigocore/cs/cstesting"
+	"github.com/stratumn/go-indigocore/store"
 	"github.com/stratumn/go-indigocore/store/storetestcases"
 	"github.com/stratumn/go-indigocore/tmpop/tmpoptestcases"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestFilestore(t *testing.T) {
@@ -27,6 +35,7 @@ func TestFilestore(t *testing.T) {
 		NewKeyValueStore:  createKeyValueStore,
 		Free:              freeAdapter,
 		FreeKeyValueStore: freeKeyValueStore,
+		FailWrites:        failWrites,
 	}
 
 	factory.RunStoreTests(t)
@@ -39,3 +48,171 @@ func TestFileTMPop(t *testing.T) {
 		Free: freeAdapterTMPop,
 	}.RunTests(t)
 }
+
+func TestFilestore_RebuildIndex(t *testing.T) {
+	ctx := context.Background()
+	a, err := createFileStore()
+	require.NoError(t, err, "createFileStore()")
+	defer freeFileStore(a)
+
+	link := cstesting.NewLinkBuilder().WithProcess("p").WithMapID("m").WithTags("t").Build()
+	linkHash, err := a.CreateLink(ctx, link)
+	require.NoError(t, err, "a.CreateLink()")
+
+	filter := &store.SegmentFilter{
+		Pagination: store.Pagination{Limit: store.DefaultLimit},
+		Process:    "p",
+		MapIDs:     []string{"m"},
+		Tags:       []string{"t"},
+	}
+
+	_, err = a.indexDB.DeleteValue(ctx, []byte(linksIndex+linkHash.String()))
+	require.NoError(t, err, "a.indexDB.DeleteValue()")
+	_, err = a.indexDB.DeleteValue(ctx, indexKey(processIndex, "p", linkHash.String()))
+	require.NoError(t, err, "a.indexDB.DeleteValue()")
+
+	segments, err := a.FindSegments(ctx, filter)
+	require.NoError(t, err, "a.FindSegments()")
+	assert.Empty(t, segments, "segments before rebuild")
+
+	require.NoError(t, a.RebuildIndex(ctx), "a.RebuildIndex()")
+
+	segments, err = a.FindSegments(ctx, filter)
+	require.NoError(t, err, "a.FindSegments()")
+	require.Len(t, segments, 1, "segments after rebuild")
+	assert.Equal(t, linkHash.String(), segments[0].GetLinkHashString())
+}
+
+func TestFilestore_RebuildIndexKeepsValues(t *testing.T) {
+	ctx := context.Background()
+	a, err := createFileStore()
+	require.NoError(t, err, "createFileStore()")
+	defer freeFileStore(a)
+
+	key := []byte(linksIndex + "user")
+	require.NoError(t, a.SetValue(ctx, key, []byte("value")), "a.SetValue()")
+
+	_, err = a.CreateLink(ctx, cstesting.RandomLink())
+	require.NoError(t, err, "a.CreateLink()")
+	require.NoError(t, a.RebuildIndex(ctx), "a.RebuildIndex()")
+
+	value, err := a.GetValue(ctx, key)
+	require.NoError(t, err, "a.GetValue()")
+	assert.Equal(t, []byte("value"), value)
+
+	segments, err := a.FindSegments(ctx, &store.SegmentFilter{Pagination: store.Pagination{Limit: store.DefaultLimit}})
+	require.NoError(t, err, "a.FindSegments()")
+	assert.Len(t, segments, 1, "segments")
+}
+
+func TestFilestore_NewRebuildsIndex(t *testing.T) {
+	ctx := context.Background()
+	a, err := createFileStore()
+	require.NoError(t, err, "createFileStore()")
+	defer freeFileStore(a)
+
+	link := cstesting.NewLinkBuilder().WithProcess("p").WithMapID("m").Build()
+	linkHash, err := a.CreateLink(ctx, link)
+	require.NoError(t, err, "a.CreateLink()")
+
+	// Copy the segment files to a directory without an index.
+	path, err := ioutil.TempDir("", "filestore")
+	require.NoError(t, err, "ioutil.TempDir()")
+	defer os.RemoveAll(path)
+
+	name := linkHash.String() + ".json"
+	js, err := ioutil.ReadFile(filepath.Join(a.config.Path, name))
+	require.NoError(t, err, "ioutil.ReadFile()")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(path, name), js, 0644), "ioutil.WriteFile()")
+
+	b, err := New(&Config{Path: path})
+	require.NoError(t, err, "New()")
+
+	mapIDs, err := b.GetMapIDs(ctx, &store.MapFilter{
+		Pagination: store.Pagination{Limit: store.DefaultLimit},
+		Process:    "p",
+	})
+	require.NoError(t, err, "b.GetMapIDs()")
+	assert.Equal(t, []string{"m"}, mapIDs)
+
+	segments, err := b.FindSegments(ctx, &store.SegmentFilter{
+		Pagination: store.Pagination{Limit: store.DefaultLimit},
+		MapIDs:     []string{"m"},
+	})
+	require.NoError(t, err, "b.FindSegments()")
+	assert.Len(t, segments, 1, "segments")
+}
+
+func TestFilestore_BatchRollback(t *testing.T) {
+	ctx := context.Background()
+	a, err := createFileStore()
+	require.NoError(t, err, "createFileStore()")
+	defer freeFileStore(a)
+
+	b, err := a.NewBatch(ctx)
+	require.NoError(t, err, "a.NewBatch()")
+
+	link1 := cstesting.NewLinkBuilder().WithProcess("p").WithMapID("m1").Build()
+	linkHash1, err := b.CreateLink(ctx, link1)
+	require.NoError(t, err, "b.CreateLink()")
+
+	link2 := cstesting.NewLinkBuilder().WithProcess("p").WithMapID("m2").Build()
+	linkHash2, err := b.CreateLink(ctx, link2)
+	require.NoError(t, err, "b.CreateLink()")
+
+	// A directory in place of the second link makes its write fail.
+	require.NoError(t, os.MkdirAll(filepath.Join(a.config.Path, linkHash2.String()+".json", "x"), 0755))
+
+	assert.Error(t, b.Write(ctx), "b.Write()")
+
+	s, err := a.GetSegment(ctx, linkHash1)
+	require.NoError(t, err, "a.GetSegment()")
+	assert.Nil(t, s, "first link should have been rolled back")
+
+	segments, err := a.FindSegments(ctx, &store.SegmentFilter{
+		Pagination: store.Pagination{Limit: store.DefaultLimit},
+		Process:    "p",
+	})
+	require.NoError(t, err, "a.FindSegments()")
+	assert.Empty(t, segments, "segments")
+
+	mapIDs, err := a.GetMapIDs(ctx, &store.MapFilter{Pagination: store.Pagination{Limit: store.DefaultLimit}})
+	require.NoError(t, err, "a.GetMapIDs()")
+	assert.Empty(t, mapIDs, "mapIDs")
+}
+
+func TestFilestore_IndexDirty(t *testing.T) {
+	ctx := context.Background()
+	a, err := createFileStore()
+	require.NoError(t, err, "createFileStore()")
+	defer freeFileStore(a)
+
+	_, err = a.CreateLink(ctx, cstesting.RandomLink())
+	require.NoError(t, err, "a.CreateLink()")
+
+	outdated, err := a.indexOutdated(ctx)
+	require.NoError(t, err, "a.indexOutdated()")
+	assert.False(t, outdated, "outdated after write")
+
+	failWrites(a, 1)
+	_, err = a.CreateLink(ctx, cstesting.RandomLink())
+	require.Error(t, err, "a.CreateLink()")
+
+	outdated, err = a.indexOutdated(ctx)
+	require.NoError(t, err, "a.indexOutdated()")
+	assert.False(t, outdated, "outdated after rollback")
+
+	// A store that stopped between renaming and indexing links leaves the
+	// marker set.
+	require.NoError(t, a.setIndexDirty(ctx), "a.setIndexDirty()")
+
+	outdated, err = a.indexOutdated(ctx)
+	require.NoError(t, err, "a.indexOutdated()")
+	assert.True(t, outdated, "outdated with dirty marker")
+
+	require.NoError(t, a.RebuildIndex(ctx), "a.RebuildIndex()")
+
+	outdated, err = a.indexOutdated(ctx)
+	require.NoError(t, err, "a.indexOutdated()")
+	assert.False(t, outdated, "outdated after rebuild")
+}
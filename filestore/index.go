@@ -0,0 +1,415 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filestore
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/types"
+)
+
+// The index is made of key-value pairs stored in a LevelDB database of its
+// own, in the indexDir sub-directory. Secondary index keys are the indexed value followed by the link
+// hash, so that all the links having a value can be found by iterating
+// over a prefix. The value of an index entry is the priority of the link,
+// so that links can be sorted and paginated without reading their files.
+// The dirty key is set while link files are being written and indexed.
+const (
+	indexDir          = "index"
+	indexPrefix       = "index:"
+	indexVersionKey   = "index:version"
+	indexVersion      = "1"
+	indexDirtyKey     = "index:dirty"
+	indexSeparator    = "\x00"
+	linksIndex        = "index:link:"
+	processIndex      = "index:process:"
+	mapIDIndex        = "index:mapid:"
+	prevLinkHashIndex = "index:prevlinkhash:"
+	tagIndex          = "index:tag:"
+
+	// mapsIndex keys are a map ID followed by a process.
+	mapsIndex = "index:map:"
+)
+
+// RebuildIndex deletes the index and rebuilds it from the segment files.
+// It can be used to repair the index or on directories written by a
+// version of the store that didn't have an index.
+func (a *FileStore) RebuildIndex(ctx context.Context) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.rebuildIndex(ctx)
+}
+
+func (a *FileStore) rebuildIndex(ctx context.Context) error {
+	var keys [][]byte
+	err := a.indexDB.IteratePrefix(ctx, []byte(indexPrefix), func(key, _ []byte) error {
+		keys = append(keys, append([]byte(nil), key...))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	batch := a.indexDB.NewValueBatch()
+	for _, key := range keys {
+		batch.DeleteValue(key)
+	}
+	if err := batch.Write(ctx); err != nil {
+		return err
+	}
+
+	err = a.forEachLink(func(linkHash *types.Bytes32, link *cs.Link) error {
+		return a.indexLink(ctx, linkHash, link)
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.indexDB.SetValue(ctx, []byte(indexVersionKey), []byte(indexVersion))
+}
+
+// indexOutdated tells whether the index is missing, was built by an older
+// version of the store or may be missing links because the store stopped
+// while writing them.
+func (a *FileStore) indexOutdated(ctx context.Context) (bool, error) {
+	version, err := a.indexDB.GetValue(ctx, []byte(indexVersionKey))
+	if err != nil {
+		return false, err
+	}
+	if string(version) != indexVersion {
+		return true, nil
+	}
+
+	dirty, err := a.indexDB.GetValue(ctx, []byte(indexDirtyKey))
+	if err != nil {
+		return false, err
+	}
+
+	return dirty != nil, nil
+}
+
+// setIndexDirty marks the index as possibly missing links until
+// clearIndexDirty is called. It must be called before link files are
+// renamed so that the index is rebuilt if the store stops before they are
+// indexed.
+func (a *FileStore) setIndexDirty(ctx context.Context) error {
+	return a.indexDB.SetValue(ctx, []byte(indexDirtyKey), []byte{})
+}
+
+func (a *FileStore) clearIndexDirty(ctx context.Context) error {
+	_, err := a.indexDB.DeleteValue(ctx, []byte(indexDirtyKey))
+	return err
+}
+
+// indexLink adds the index entries of a link in a single LevelDB batch.
+func (a *FileStore) indexLink(ctx context.Context, linkHash *types.Bytes32, link *cs.Link) error {
+	var (
+		h            = linkHash.String()
+		priority     = encodePriority(link.Meta.Priority)
+		prevLinkHash = ""
+	)
+
+	if p := link.Meta.GetPrevLinkHash(); p != nil {
+		prevLinkHash = p.String()
+	}
+
+	keys := [][]byte{
+		[]byte(linksIndex + h),
+		indexKey(processIndex, link.Meta.Process, h),
+		indexKey(mapIDIndex, link.Meta.MapID, h),
+		indexKey(prevLinkHashIndex, prevLinkHash, h),
+	}
+	for _, tag := range link.Meta.Tags {
+		keys = append(keys, indexKey(tagIndex, tag, h))
+	}
+
+	batch := a.indexDB.NewValueBatch()
+	for _, key := range keys {
+		batch.SetValue(key, priority)
+	}
+	batch.SetValue(indexKey(mapsIndex, link.Meta.MapID, link.Meta.Process), []byte{})
+
+	return batch.Write(ctx)
+}
+
+// unindexLink removes the index entries of a link.
+// The map entry is only removed if no other link of the process is in the
+// map.
+func (a *FileStore) unindexLink(ctx context.Context, linkHash *types.Bytes32, link *cs.Link) error {
+	h := linkHash.String()
+	prevLinkHash := ""
+	if p := link.Meta.GetPrevLinkHash(); p != nil {
+		prevLinkHash = p.String()
+	}
+
+	keys := [][]byte{
+		[]byte(linksIndex + h),
+		indexKey(processIndex, link.Meta.Process, h),
+		indexKey(mapIDIndex, link.Meta.MapID, h),
+		indexKey(prevLinkHashIndex, prevLinkHash, h),
+	}
+	for _, tag := range link.Meta.Tags {
+		keys = append(keys, indexKey(tagIndex, tag, h))
+	}
+
+	batch := a.indexDB.NewValueBatch()
+	for _, key := range keys {
+		batch.DeleteValue(key)
+	}
+	if err := batch.Write(ctx); err != nil {
+		return err
+	}
+
+	linkHashes, err := a.findLinkHashes(ctx, &store.SegmentFilter{
+		Process: link.Meta.Process,
+		MapIDs:  []string{link.Meta.MapID},
+	})
+	if err != nil || len(linkHashes) > 0 {
+		return err
+	}
+
+	_, err = a.indexDB.DeleteValue(ctx, indexKey(mapsIndex, link.Meta.MapID, link.Meta.Process))
+	return err
+}
+
+// findLinkHashes uses the index to find the hashes of the links matching a
+// filter, sorted like a cs.SegmentSlice. Pagination isn't applied.
+func (a *FileStore) findLinkHashes(ctx context.Context, filter *store.SegmentFilter) ([]string, error) {
+	var candidates map[string]float64
+
+	restrict := func(set map[string]float64) {
+		if candidates == nil {
+			candidates = set
+			return
+		}
+		for h := range candidates {
+			if _, ok := set[h]; !ok {
+				delete(candidates, h)
+			}
+		}
+	}
+
+	if filter.Process != "" {
+		set, err := a.lookup(ctx, processIndex, filter.Process)
+		if err != nil {
+			return nil, err
+		}
+		restrict(set)
+	}
+
+	if len(filter.MapIDs) > 0 {
+		union := map[string]float64{}
+		for _, mapID := range filter.MapIDs {
+			set, err := a.lookup(ctx, mapIDIndex, mapID)
+			if err != nil {
+				return nil, err
+			}
+			for h, p := range set {
+				union[h] = p
+			}
+		}
+		restrict(union)
+	}
+
+	if filter.PrevLinkHash != nil {
+		prevLinkHash := *filter.PrevLinkHash
+		if prevLinkHash != "" {
+			p, err := types.NewBytes32FromString(prevLinkHash)
+			if err != nil {
+				return nil, nil
+			}
+			prevLinkHash = p.String()
+		}
+
+		set, err := a.lookup(ctx, prevLinkHashIndex, prevLinkHash)
+		if err != nil {
+			return nil, err
+		}
+		restrict(set)
+	}
+
+	for _, tag := range filter.Tags {
+		set, err := a.lookup(ctx, tagIndex, tag)
+		if err != nil {
+			return nil, err
+		}
+		restrict(set)
+	}
+
+	if len(filter.LinkHashes) > 0 {
+		set := map[string]float64{}
+		for _, h := range filter.LinkHashes {
+			priority, err := a.indexDB.GetValue(ctx, []byte(linksIndex+h))
+			if err != nil {
+				return nil, err
+			}
+			if priority != nil {
+				set[h] = decodePriority(priority)
+			}
+		}
+		restrict(set)
+	}
+
+	if candidates == nil {
+		var err error
+		if candidates, err = a.lookupPrefix(ctx, linksIndex); err != nil {
+			return nil, err
+		}
+	}
+
+	linkHashes := make([]string, 0, len(candidates))
+	for h := range candidates {
+		linkHashes = append(linkHashes, h)
+	}
+
+	sort.Slice(linkHashes, func(i, j int) bool {
+		p1, p2 := candidates[linkHashes[i]], candidates[linkHashes[j]]
+		if p1 != p2 {
+			return p1 > p2
+		}
+		return linkHashes[i] < linkHashes[j]
+	})
+
+	return linkHashes, nil
+}
+
+// lookup returns the hashes and priorities of the links having a value in
+// an index.
+func (a *FileStore) lookup(ctx context.Context, index, value string) (map[string]float64, error) {
+	return a.lookupPrefix(ctx, index+value+indexSeparator)
+}
+
+func (a *FileStore) lookupPrefix(ctx context.Context, prefix string) (map[string]float64, error) {
+	set := map[string]float64{}
+	err := a.indexDB.IteratePrefix(ctx, []byte(prefix), func(key, value []byte) error {
+		set[string(key[len(prefix):])] = decodePriority(value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return set, nil
+}
+
+// findMapIDs uses the index to find the sorted map IDs of a process, or of
+// all processes if process is empty.
+func (a *FileStore) findMapIDs(ctx context.Context, process string) ([]string, error) {
+	mapIDs := []string{}
+	err := a.indexDB.IteratePrefix(ctx, []byte(mapsIndex), func(key, _ []byte) error {
+		parts := strings.SplitN(string(key[len(mapsIndex):]), indexSeparator, 2)
+		if len(parts) != 2 || process != "" && parts[1] != process {
+			return nil
+		}
+		if l := len(mapIDs); l == 0 || mapIDs[l-1] != parts[0] {
+			mapIDs = append(mapIDs, parts[0])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return mapIDs, nil
+}
+
+// getSegments loads the segments of the given link hashes.
+func (a *FileStore) getSegments(ctx context.Context, linkHashes []string) (cs.SegmentSlice, error) {
+	segments := make(cs.SegmentSlice, 0, len(linkHashes))
+	for _, h := range linkHashes {
+		linkHash, err := types.NewBytes32FromString(h)
+		if err != nil {
+			return nil, err
+		}
+
+		segment, err := a.getSegment(ctx, linkHash)
+		if err != nil {
+			return nil, err
+		}
+		if segment == nil {
+			return nil, fmt.Errorf("could not find segment %q, the index should be rebuilt", h)
+		}
+
+		segments = append(segments, segment)
+	}
+
+	return segments, nil
+}
+
+// forEachLink calls fn on every link file of the directory.
+// The mutex should be locked by the caller.
+func (a *FileStore) forEachLink(fn func(*types.Bytes32, *cs.Link) error) error {
+	files, err := ioutil.ReadDir(a.config.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if !linkFileRegex.MatchString(name) {
+			continue
+		}
+
+		linkHash, err := types.NewBytes32FromString(name[:len(name)-5])
+		if err != nil {
+			return err
+		}
+
+		link, err := a.getLink(linkHash)
+		if err != nil {
+			return err
+		}
+		if link == nil {
+			return fmt.Errorf("could not find link %q", name)
+		}
+
+		if err = fn(linkHash, link); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func indexKey(index, value, suffix string) []byte {
+	return []byte(index + value + indexSeparator + suffix)
+}
+
+func encodePriority(priority float64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, math.Float64bits(priority))
+	return b
+}
+
+func decodePriority(b []byte) float64 {
+	if len(b) != 8 {
+		return 0
+	}
+
+	return math.Float64frombits(binary.BigEndian.Uint64(b))
+}
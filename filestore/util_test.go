@@ -19,6 +19,7 @@ import (
 	"os"
 
 	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/store/storetestcases"
 )
 
 func createFileStore() (*FileStore, error) {
@@ -37,6 +38,10 @@ func createAdapter() (store.Adapter, error) {
 	return createFileStore()
 }
 
+func failWrites(s store.Adapter, n int) {
+	s.(*FileStore).testHookWriteLink = storetestcases.FailingWrite(n)
+}
+
 func createKeyValueStore() (store.KeyValueStore, error) {
 	return createFileStore()
 }
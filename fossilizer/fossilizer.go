@@ -19,6 +19,7 @@ import (
 	"context"
 
 	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/types"
 )
 
 // Adapter must be implemented by a fossilier.
@@ -34,6 +35,13 @@ type Adapter interface {
 	Fossilize(ctx context.Context, data []byte, meta []byte) error
 }
 
+// BatchReader is the interface of fossilizers that keep the batches they
+// fossilized. Some fossilizers will implement this interface, but not all.
+type BatchReader interface {
+	// Get a batch by Merkle root. Returns nil if no match is found.
+	GetBatch(ctx context.Context, root *types.Bytes32) (*Batch, error)
+}
+
 // Result is the type sent to the result channels.
 type Result struct {
 	// Evidence created by the fossilizer.
@@ -44,6 +52,23 @@ type Result struct {
 
 	// The meta data that was given to Adapter.Fossilize.
 	Meta []byte
+
+	// An optional identifier of the fossil. Fossilizers that may deliver
+	// the same result more than once set it so consumers can deduplicate
+	// results.
+	ID string `json:",omitempty"`
+}
+
+// Batch is the type sent with DidBatch events.
+type Batch struct {
+	// Merkle root of the batch.
+	Root *types.Bytes32
+
+	// The data that is being fossilized, in leaf order.
+	Data [][]byte
+
+	// The meta data that was given to Adapter.Fossilize for each data.
+	Meta [][]byte
 }
 
 // EventType lets you know the kind of event received.
@@ -53,6 +78,11 @@ type EventType string
 const (
 	// DidFossilizeLink means that the link was fossilized
 	DidFossilizeLink EventType = "DidFossilizeLink"
+
+	// DidBatch means that data was added to a batch which is being
+	// fossilized. Its data contains the leaves of the whole batch, so it
+	// should not be forwarded to untrusted clients.
+	DidBatch EventType = "DidBatch"
 )
 
 // Event is the object fossilizers send to notify of important events.
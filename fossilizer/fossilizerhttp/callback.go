@@ -0,0 +1,708 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fossilizerhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/stratumn/go-indigocore/fossilizer"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultCallbackMaxRetries is the default maximum number of attempts
+	// to deliver a result to a callback URL.
+	DefaultCallbackMaxRetries = 5
+
+	// DefaultCallbackBackoff is the default delay before the first retry.
+	// The delay doubles after each attempt.
+	DefaultCallbackBackoff = time.Second
+
+	// DefaultCallbackTimeout is the default timeout of a callback request.
+	DefaultCallbackTimeout = 10 * time.Second
+
+	// SignatureHeader is the header containing the HMAC-SHA256 signature of
+	// the body of callback requests, if a secret is configured.
+	SignatureHeader = "X-Indigo-Signature"
+)
+
+const (
+	callbackKeyPrefix = "fossilizerhttp:callback:"
+	deliveryKeyPrefix = "fossilizerhttp:delivery:"
+	deliveriesKey     = "fossilizerhttp:deliveries"
+	deadLettersKey    = "fossilizerhttp:deadletters"
+)
+
+var errCallbacksStopped = errors.New("callbacks are stopped")
+
+// privateNetworks are the networks, in addition to loopback, link-local and
+// unspecified addresses, that callback URLs cannot reach unless they are
+// allowed by the configuration.
+var privateNetworks = []*net.IPNet{
+	mustParseCIDR("10.0.0.0/8"),
+	mustParseCIDR("172.16.0.0/12"),
+	mustParseCIDR("192.168.0.0/16"),
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("fc00::/7"),
+}
+
+// forbiddenAddressError is returned when a callback host resolves to an
+// address callbacks are not allowed to reach.
+type forbiddenAddressError struct {
+	host string
+	ip   net.IP
+}
+
+func (e *forbiddenAddressError) Error() string {
+	return fmt.Sprintf("callback host %q resolves to forbidden address %s", e.host, e.ip)
+}
+
+// DeadLetter is a result that could not be delivered to a callback URL.
+type DeadLetter struct {
+	ID          string          `json:"id"`
+	CallbackURL string          `json:"callbackUrl"`
+	Body        json.RawMessage `json:"body"`
+	Attempts    int             `json:"attempts"`
+	Error       string          `json:"error"`
+	FailedAt    time.Time       `json:"failedAt"`
+}
+
+// delivery is a result being delivered to callback URLs.
+// It is saved until every URL received the result or has a dead letter, so
+// deliveries interrupted by a crash or by stop are resumed on start.
+type delivery struct {
+	Body json.RawMessage `json:"body"`
+	URLs []string        `json:"urls"`
+}
+
+// callbacks delivers results to the callback URLs given with fossils.
+type callbacks struct {
+	kv         store.KeyValueStore
+	client     *http.Client
+	allowed    []*net.IPNet
+	secret     []byte
+	maxRetries int
+	backoff    time.Duration
+	mutex      sync.Mutex
+	waitGroup  sync.WaitGroup
+	ctx        context.Context
+	cancel     context.CancelFunc
+	stopped    bool
+}
+
+func newCallbacks(kv store.KeyValueStore, config *Config) *callbacks {
+	c := &callbacks{
+		kv:         kv,
+		allowed:    config.CallbackAllowedNetworks,
+		secret:     config.CallbackSecret,
+		maxRetries: DefaultCallbackMaxRetries,
+		backoff:    DefaultCallbackBackoff,
+	}
+	// The transport checks the addresses it dials, so redirects and hosts
+	// resolving to a different address after validation cannot reach
+	// internal services either.
+	c.client = &http.Client{
+		Timeout: DefaultCallbackTimeout,
+		Transport: &http.Transport{
+			DialContext:         c.dialContext,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+	if config.CallbackTimeout > 0 {
+		c.client.Timeout = config.CallbackTimeout
+	}
+	if config.CallbackMaxRetries > 0 {
+		c.maxRetries = config.CallbackMaxRetries
+	}
+	if config.CallbackBackoff > 0 {
+		c.backoff = config.CallbackBackoff
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c
+}
+
+// add registers a callback URL for the given data. It returns false if the
+// URL was already registered.
+func (c *callbacks) add(ctx context.Context, data []byte, callbackURL string) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	urls, err := c.get(ctx, data)
+	if err != nil {
+		return false, err
+	}
+	if containsURL(urls, callbackURL) {
+		return false, nil
+	}
+
+	return true, c.set(ctx, data, append(urls, callbackURL))
+}
+
+// remove unregisters a callback URL of the given data.
+func (c *callbacks) remove(ctx context.Context, data []byte, callbackURL string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	urls, err := c.get(ctx, data)
+	if err != nil {
+		return err
+	}
+	for i, u := range urls {
+		if u == callbackURL {
+			return c.set(ctx, data, append(urls[:i], urls[i+1:]...))
+		}
+	}
+
+	return nil
+}
+
+// notify delivers a result to the callback URLs of its data in the
+// background. The callback URLs are kept until the result is delivered or
+// added to the dead letters.
+//
+// If a result of the same data is still being delivered, for instance
+// because the fossilizer delivered it twice, the new callback URLs are added
+// to that delivery and receive its result.
+func (c *callbacks) notify(ctx context.Context, r *fossilizer.Result) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.stopped {
+		return errCallbacksStopped
+	}
+
+	urls, err := c.get(ctx, r.Data)
+	if err != nil || len(urls) == 0 {
+		return err
+	}
+
+	d, err := c.getDelivery(ctx, r.Data)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		body, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		d = &delivery{Body: body}
+	}
+
+	var added []string
+	for _, u := range urls {
+		if !containsURL(d.URLs, u) {
+			added = append(added, u)
+		}
+	}
+	d.URLs = append(d.URLs, added...)
+
+	if err := c.saveDelivery(ctx, r.Data, d); err != nil {
+		return err
+	}
+	if _, err := c.kv.DeleteValue(ctx, callbackKey(r.Data)); err != nil {
+		return err
+	}
+
+	c.start(r.Data, d.Body, added)
+
+	return nil
+}
+
+// resume delivers the results whose delivery was interrupted.
+func (c *callbacks) resume(ctx context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.stopped {
+		return errCallbacksStopped
+	}
+
+	pending, err := c.deliveries(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, h := range pending {
+		data, err := hex.DecodeString(h)
+		if err != nil {
+			return err
+		}
+		d, err := c.getDelivery(ctx, data)
+		if err != nil {
+			return err
+		}
+		if d != nil {
+			c.start(data, d.Body, d.URLs)
+		}
+	}
+
+	if len(pending) > 0 {
+		log.WithField("deliveries", len(pending)).Info("Resumed callback deliveries")
+	}
+
+	return nil
+}
+
+// start delivers a result to callback URLs in the background.
+// It must be called with the mutex locked and before stop.
+func (c *callbacks) start(data, body []byte, urls []string) {
+	for _, u := range urls {
+		c.waitGroup.Add(1)
+		go func(callbackURL string) {
+			defer c.waitGroup.Done()
+			if !c.deliver(callbackURL, body) {
+				// Stopped, the URL stays in the saved delivery.
+				return
+			}
+			if err := c.complete(context.Background(), data, callbackURL); err != nil {
+				log.WithField("error", err).Error("Failed to save callback delivery")
+			}
+		}(u)
+	}
+}
+
+// complete removes a callback URL from a delivery once the result was
+// delivered or added to the dead letters.
+func (c *callbacks) complete(ctx context.Context, data []byte, callbackURL string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	d, err := c.getDelivery(ctx, data)
+	if err != nil || d == nil {
+		return err
+	}
+
+	var urls []string
+	for _, u := range d.URLs {
+		if u != callbackURL {
+			urls = append(urls, u)
+		}
+	}
+	if len(urls) > 0 {
+		d.URLs = urls
+		return c.saveDelivery(ctx, data, d)
+	}
+
+	if _, err := c.kv.DeleteValue(ctx, deliveryKey(data)); err != nil {
+		return err
+	}
+
+	return c.updateDeliveries(ctx, func(pending []string) []string {
+		h := hex.EncodeToString(data)
+		for i, p := range pending {
+			if p == h {
+				return append(pending[:i], pending[i+1:]...)
+			}
+		}
+		return pending
+	})
+}
+
+// deliver posts the body to the callback URL, retrying with an exponential
+// backoff. Bodies that cannot be delivered are added to the dead letters.
+// It returns false if it was interrupted by stop before it was done.
+func (c *callbacks) deliver(callbackURL string, body []byte) bool {
+	attempts := 0
+	var lastErr error
+	err := utils.Retry(func(attempt int) (retry bool, err error) {
+		if attempt > 1 {
+			select {
+			case <-time.After(c.backoff << uint(attempt-2)):
+			case <-c.ctx.Done():
+				return false, c.ctx.Err()
+			}
+		}
+		attempts = attempt
+		retry, lastErr = c.post(c.ctx, callbackURL, body)
+		return retry, lastErr
+	}, c.maxRetries)
+
+	if err == nil {
+		return true
+	}
+	if c.ctx.Err() != nil {
+		return false
+	}
+	if utils.IsMaxRetries(err) {
+		err = lastErr
+	}
+
+	log.WithFields(log.Fields{
+		"callbackUrl": callbackURL,
+		"attempts":    attempts,
+		"error":       err,
+	}).Warn("Failed to deliver result to callback URL")
+
+	letter := &DeadLetter{
+		ID:          uuid.NewV4().String(),
+		CallbackURL: callbackURL,
+		Body:        body,
+		Attempts:    attempts,
+		Error:       err.Error(),
+		FailedAt:    time.Now().UTC(),
+	}
+	if err := c.addDeadLetter(context.Background(), letter); err != nil {
+		log.WithField("error", err).Error("Failed to save dead letter")
+	}
+
+	return true
+}
+
+// post sends the body to the callback URL once. It returns whether the
+// request should be retried.
+func (c *callbacks) post(ctx context.Context, callbackURL string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if len(c.secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(c.secret, body))
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		if e, ok := err.(*url.Error); ok {
+			if _, ok := e.Err.(*forbiddenAddressError); ok {
+				return false, err
+			}
+		}
+		return ctx.Err() == nil, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+
+	err = fmt.Errorf("callback returned status %d", res.StatusCode)
+	retry := res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+
+	return retry, err
+}
+
+// replay delivers a dead letter once and removes it on success.
+func (c *callbacks) replay(ctx context.Context, id string) (*DeadLetter, error) {
+	letter, err := c.getDeadLetter(ctx, id)
+	if err != nil || letter == nil {
+		return nil, err
+	}
+
+	if _, err := c.post(ctx, letter.CallbackURL, letter.Body); err != nil {
+		letter.Attempts++
+		letter.Error = err.Error()
+		letter.FailedAt = time.Now().UTC()
+		return letter, c.updateDeadLetters(ctx, func(letters []*DeadLetter) []*DeadLetter {
+			for i, l := range letters {
+				if l.ID == id {
+					letters[i] = letter
+				}
+			}
+			return letters
+		})
+	}
+
+	return nil, c.updateDeadLetters(ctx, func(letters []*DeadLetter) []*DeadLetter {
+		for i, l := range letters {
+			if l.ID == id {
+				return append(letters[:i], letters[i+1:]...)
+			}
+		}
+		return letters
+	})
+}
+
+// stop cancels pending deliveries. They stay saved and are resumed when the
+// server starts again. Results notified after stop are not delivered.
+func (c *callbacks) stop() {
+	c.mutex.Lock()
+	c.stopped = true
+	c.mutex.Unlock()
+
+	c.cancel()
+	c.waitGroup.Wait()
+}
+
+func (c *callbacks) get(ctx context.Context, data []byte) ([]string, error) {
+	value, err := c.kv.GetValue(ctx, callbackKey(data))
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	var urls []string
+	if err := json.Unmarshal(value, &urls); err != nil {
+		return nil, err
+	}
+
+	return urls, nil
+}
+
+func (c *callbacks) set(ctx context.Context, data []byte, urls []string) error {
+	if len(urls) == 0 {
+		_, err := c.kv.DeleteValue(ctx, callbackKey(data))
+		return err
+	}
+
+	value, err := json.Marshal(urls)
+	if err != nil {
+		return err
+	}
+
+	return c.kv.SetValue(ctx, callbackKey(data), value)
+}
+
+func (c *callbacks) getDelivery(ctx context.Context, data []byte) (*delivery, error) {
+	value, err := c.kv.GetValue(ctx, deliveryKey(data))
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	var d delivery
+	if err := json.Unmarshal(value, &d); err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+// saveDelivery saves a delivery and adds it to the deliveries to resume.
+// It must be called with the mutex locked.
+func (c *callbacks) saveDelivery(ctx context.Context, data []byte, d *delivery) error {
+	value, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	if err := c.kv.SetValue(ctx, deliveryKey(data), value); err != nil {
+		return err
+	}
+
+	return c.updateDeliveries(ctx, func(pending []string) []string {
+		h := hex.EncodeToString(data)
+		for _, p := range pending {
+			if p == h {
+				return pending
+			}
+		}
+		return append(pending, h)
+	})
+}
+
+func (c *callbacks) deliveries(ctx context.Context) ([]string, error) {
+	value, err := c.kv.GetValue(ctx, []byte(deliveriesKey))
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	var pending []string
+	if err := json.Unmarshal(value, &pending); err != nil {
+		return nil, err
+	}
+
+	return pending, nil
+}
+
+// updateDeliveries must be called with the mutex locked.
+func (c *callbacks) updateDeliveries(ctx context.Context, fn func([]string) []string) error {
+	pending, err := c.deliveries(ctx)
+	if err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(fn(pending))
+	if err != nil {
+		return err
+	}
+
+	return c.kv.SetValue(ctx, []byte(deliveriesKey), value)
+}
+
+func (c *callbacks) deadLetters(ctx context.Context) ([]*DeadLetter, error) {
+	value, err := c.kv.GetValue(ctx, []byte(deadLettersKey))
+	if err != nil || value == nil {
+		return []*DeadLetter{}, err
+	}
+
+	var letters []*DeadLetter
+	if err := json.Unmarshal(value, &letters); err != nil {
+		return nil, err
+	}
+
+	return letters, nil
+}
+
+func (c *callbacks) getDeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	letters, err := c.deadLetters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range letters {
+		if l.ID == id {
+			return l, nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *callbacks) addDeadLetter(ctx context.Context, letter *DeadLetter) error {
+	return c.updateDeadLetters(ctx, func(letters []*DeadLetter) []*DeadLetter {
+		return append(letters, letter)
+	})
+}
+
+func (c *callbacks) updateDeadLetters(ctx context.Context, fn func([]*DeadLetter) []*DeadLetter) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	letters, err := c.deadLetters(ctx)
+	if err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(fn(letters))
+	if err != nil {
+		return err
+	}
+
+	return c.kv.SetValue(ctx, []byte(deadLettersKey), value)
+}
+
+// Sign returns the value of the signature header of a callback body.
+// Receivers should compute it with the shared secret and compare it to the
+// received header using hmac.Equal.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// validate checks that a callback URL is an HTTP URL whose host resolves to
+// addresses callbacks are allowed to reach.
+func (c *callbacks) validate(ctx context.Context, callbackURL string) error {
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Hostname() == "" {
+		return fmt.Errorf("invalid callback URL %q", callbackURL)
+	}
+	_, err = c.resolve(ctx, u.Hostname())
+	return err
+}
+
+// resolve returns the addresses of a host, or an error if one of them is not
+// allowed.
+func (c *callbacks) resolve(ctx context.Context, host string) ([]net.IPAddr, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if !c.isAllowed(addr.IP) {
+			return nil, &forbiddenAddressError{host: host, ip: addr.IP}
+		}
+	}
+	return addrs, nil
+}
+
+// isAllowed returns whether callbacks can reach an address. Loopback,
+// link-local, unspecified and private addresses must be in an allowed
+// network.
+func (c *callbacks) isAllowed(ip net.IP) bool {
+	for _, n := range c.allowed {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialContext dials an allowed address of the host. The resolved address is
+// dialed directly so it cannot change between the check and the connection.
+func (c *callbacks) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := c.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+	for _, addr := range addrs {
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(addr.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("no address found for callback host %q", host)
+	}
+	return nil, err
+}
+
+func containsURL(urls []string, callbackURL string) bool {
+	for _, u := range urls {
+		if u == callbackURL {
+			return true
+		}
+	}
+	return false
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func callbackKey(data []byte) []byte {
+	return []byte(callbackKeyPrefix + hex.EncodeToString(data))
+}
+
+func deliveryKey(data []byte) []byte {
+	return []byte(deliveryKeyPrefix + hex.EncodeToString(data))
+}
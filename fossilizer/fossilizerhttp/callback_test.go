@@ -0,0 +1,433 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fossilizerhttp
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/dummyfossilizer/evidences"
+	"github.com/stratumn/go-indigocore/dummystore"
+	"github.com/stratumn/go-indigocore/fossilizer"
+	"github.com/stratumn/go-indigocore/fossilizer/fossilizertesting"
+	"github.com/stratumn/go-indigocore/jsonhttp"
+	"github.com/stratumn/go-indigocore/jsonws"
+	"github.com/stratumn/go-indigocore/testutil"
+)
+
+var testSecret = []byte("secret")
+
+// callbackReceiver records the requests made to a callback URL.
+type callbackReceiver struct {
+	mutex    sync.Mutex
+	status   int
+	bodies   [][]byte
+	sigs     []string
+	received chan struct{}
+}
+
+func newCallbackReceiver(status int) *callbackReceiver {
+	return &callbackReceiver{status: status, received: make(chan struct{}, 16)}
+}
+
+func (c *callbackReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	c.mutex.Lock()
+	c.bodies = append(c.bodies, body)
+	c.sigs = append(c.sigs, r.Header.Get(SignatureHeader))
+	status := c.status
+	c.mutex.Unlock()
+
+	w.WriteHeader(status)
+	c.received <- struct{}{}
+}
+
+func (c *callbackReceiver) setStatus(status int) {
+	c.mutex.Lock()
+	c.status = status
+	c.mutex.Unlock()
+}
+
+func (c *callbackReceiver) wait(t *testing.T, n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case <-c.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("callback not received after %d requests", i)
+		}
+	}
+}
+
+func createCallbackServer(maxRetries int) *Server {
+	return New(&fossilizertesting.MockAdapter{}, &Config{
+		MinDataLen:         2,
+		MaxDataLen:         16,
+		Store:              dummystore.New(&dummystore.Config{}),
+		CallbackSecret:     testSecret,
+		CallbackMaxRetries: maxRetries,
+		CallbackBackoff:    time.Millisecond,
+		// The test receivers listen on loopback addresses.
+		CallbackAllowedNetworks: []*net.IPNet{
+			mustParseCIDR("127.0.0.0/8"),
+			mustParseCIDR("::1/128"),
+		},
+	}, &jsonhttp.Config{}, &jsonws.BasicConfig{}, &jsonws.BufferedConnConfig{
+		Size:         256,
+		WriteTimeout: 10 * time.Second,
+		PongTimeout:  70 * time.Second,
+		PingInterval: time.Minute,
+		MaxMsgSize:   1024,
+	})
+}
+
+func postFossilWithCallback(s *Server, data, callbackURL string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/fossils", nil)
+	req.Form = url.Values{}
+	req.Form.Set("data", data)
+	req.Form.Set("process", "zou")
+	req.Form.Set("callbackUrl", callbackURL)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+
+	return w
+}
+
+func saveTestResult(s *Server) {
+	s.saveEvent(&fossilizer.Event{
+		EventType: fossilizer.DidFossilizeLink,
+		Data: &fossilizer.Result{
+			Evidence: cs.Evidence{
+				Backend:  evidences.Name,
+				Provider: evidences.Name,
+				Proof:    &evidences.DummyProof{Timestamp: 42},
+			},
+			Data: []byte{0x42, 0x42},
+			Meta: []byte("zou"),
+		},
+	})
+}
+
+func getDeadLetters(t *testing.T, s *Server) []*DeadLetter {
+	var letters []*DeadLetter
+	w, err := testutil.RequestJSON(s.ServeHTTP, "GET", "/callbacks/deadletters", nil, &letters)
+	if err != nil {
+		t.Fatalf("testutil.RequestJSON(): err: %s", err)
+	}
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("w.StatusCode = %d want %d", got, want)
+	}
+	return letters
+}
+
+func TestCallback(t *testing.T) {
+	receiver := newCallbackReceiver(http.StatusOK)
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+
+	s := createCallbackServer(3)
+	if got, want := postFossilWithCallback(s, "4242", ts.URL).Code, http.StatusOK; got != want {
+		t.Fatalf("w.StatusCode = %d want %d", got, want)
+	}
+
+	saveTestResult(s)
+	receiver.wait(t, 1)
+	s.callbacks.stop()
+
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	if got, want := len(receiver.bodies), 1; got != want {
+		t.Fatalf("len(receiver.bodies) = %d want %d", got, want)
+	}
+	if got, want := receiver.sigs[0], Sign(testSecret, receiver.bodies[0]); !hmac.Equal([]byte(got), []byte(want)) {
+		t.Errorf("signature = %q want %q", got, want)
+	}
+
+	var r fossilizer.Result
+	if err := json.Unmarshal(receiver.bodies[0], &r); err != nil {
+		t.Fatalf("json.Unmarshal(): err: %s", err)
+	}
+	if got, want := r.Evidence.Proof.Time(), uint64(42); got != want {
+		t.Errorf("r.Evidence.Proof.Time() = %d want %d", got, want)
+	}
+
+	if got := getDeadLetters(t, s); len(got) != 0 {
+		t.Errorf("dead letters = %v want none", got)
+	}
+	assertNoDelivery(t, s)
+}
+
+func TestCallback_retry(t *testing.T) {
+	receiver := newCallbackReceiver(http.StatusServiceUnavailable)
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+
+	s := createCallbackServer(3)
+	postFossilWithCallback(s, "4242", ts.URL)
+	saveTestResult(s)
+	receiver.wait(t, 3)
+	s.callbacks.stop()
+
+	letters := getDeadLetters(t, s)
+	if got, want := len(letters), 1; got != want {
+		t.Fatalf("len(letters) = %d want %d", got, want)
+	}
+	if got, want := letters[0].Attempts, 3; got != want {
+		t.Errorf("letters[0].Attempts = %d want %d", got, want)
+	}
+	if got, want := letters[0].CallbackURL, ts.URL; got != want {
+		t.Errorf("letters[0].CallbackURL = %q want %q", got, want)
+	}
+	assertNoDelivery(t, s)
+
+	receiver.setStatus(http.StatusOK)
+	w, err := testutil.RequestJSON(s.ServeHTTP, "POST", "/callbacks/deadletters/"+letters[0].ID+"/replay", nil, nil)
+	if err != nil {
+		t.Fatalf("testutil.RequestJSON(): err: %s", err)
+	}
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("w.StatusCode = %d want %d", got, want)
+	}
+	if got := getDeadLetters(t, s); len(got) != 0 {
+		t.Errorf("dead letters = %v want none", got)
+	}
+}
+
+func TestCallback_clientError(t *testing.T) {
+	receiver := newCallbackReceiver(http.StatusBadRequest)
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+
+	s := createCallbackServer(3)
+	postFossilWithCallback(s, "4242", ts.URL)
+	saveTestResult(s)
+	receiver.wait(t, 1)
+	s.callbacks.stop()
+
+	letters := getDeadLetters(t, s)
+	if got, want := len(letters), 1; got != want {
+		t.Fatalf("len(letters) = %d want %d", got, want)
+	}
+	if got, want := letters[0].Attempts, 1; got != want {
+		t.Errorf("letters[0].Attempts = %d want %d", got, want)
+	}
+
+	w, err := testutil.RequestJSON(s.ServeHTTP, "POST", "/callbacks/deadletters/"+letters[0].ID+"/replay", nil, nil)
+	if err != nil {
+		t.Fatalf("testutil.RequestJSON(): err: %s", err)
+	}
+	if got, want := w.Code, http.StatusBadGateway; got != want {
+		t.Errorf("w.StatusCode = %d want %d", got, want)
+	}
+	if got, want := getDeadLetters(t, s)[0].Attempts, 2; got != want {
+		t.Errorf("Attempts = %d want %d", got, want)
+	}
+}
+
+func TestCallback_pendingUntilDelivered(t *testing.T) {
+	receiver := newCallbackReceiver(http.StatusServiceUnavailable)
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+
+	s := createCallbackServer(100)
+	postFossilWithCallback(s, "4242", ts.URL)
+	saveTestResult(s)
+	receiver.wait(t, 1)
+
+	// The delivery is saved while the callback URL keeps failing.
+	d, err := s.callbacks.getDelivery(context.Background(), []byte{0x42, 0x42})
+	if err != nil {
+		t.Fatalf("s.callbacks.getDelivery(): err: %s", err)
+	}
+	if d == nil || len(d.URLs) != 1 || d.URLs[0] != ts.URL {
+		t.Fatalf("s.callbacks.getDelivery() = %v want delivery to %s", d, ts.URL)
+	}
+
+	// Stopping keeps the delivery so it is resumed on start.
+	s.callbacks.stop()
+	if got, want := len(getDeadLetters(t, s)), 0; got != want {
+		t.Errorf("len(dead letters) = %d want %d", got, want)
+	}
+	pending, err := s.callbacks.deliveries(context.Background())
+	if err != nil {
+		t.Fatalf("s.callbacks.deliveries(): err: %s", err)
+	}
+	if got, want := len(pending), 1; got != want {
+		t.Errorf("len(deliveries) = %d want %d", got, want)
+	}
+}
+
+func TestCallback_mergeDelivery(t *testing.T) {
+	failing := newCallbackReceiver(http.StatusServiceUnavailable)
+	ts1 := httptest.NewServer(failing)
+	defer ts1.Close()
+	receiver := newCallbackReceiver(http.StatusOK)
+	ts2 := httptest.NewServer(receiver)
+	defer ts2.Close()
+
+	s := createCallbackServer(100)
+	defer s.callbacks.stop()
+	postFossilWithCallback(s, "4242", ts1.URL)
+	saveTestResult(s)
+	failing.wait(t, 1)
+
+	// A new request registers its callback URL while the first delivery
+	// is still retrying.
+	postFossilWithCallback(s, "4242", ts2.URL)
+	saveTestResult(s)
+	receiver.wait(t, 1)
+
+	receiver.mutex.Lock()
+	var r fossilizer.Result
+	err := json.Unmarshal(receiver.bodies[0], &r)
+	receiver.mutex.Unlock()
+	if err != nil {
+		t.Fatalf("json.Unmarshal(): err: %s", err)
+	}
+	if got, want := r.Data, []byte{0x42, 0x42}; string(got) != string(want) {
+		t.Errorf("r.Data = %x want %x", got, want)
+	}
+}
+
+func TestCallback_resume(t *testing.T) {
+	receiver := newCallbackReceiver(http.StatusOK)
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+
+	// Simulate a delivery interrupted by a crash.
+	s := createCallbackServer(3)
+	ctx := context.Background()
+	s.callbacks.mutex.Lock()
+	err := s.callbacks.saveDelivery(ctx, []byte{0x42, 0x42}, &delivery{
+		Body: json.RawMessage(`{"Data":"QkI="}`),
+		URLs: []string{ts.URL},
+	})
+	s.callbacks.mutex.Unlock()
+	if err != nil {
+		t.Fatalf("s.callbacks.saveDelivery(): err: %s", err)
+	}
+
+	restarted := New(&fossilizertesting.MockAdapter{}, s.config, &jsonhttp.Config{}, &jsonws.BasicConfig{}, &jsonws.BufferedConnConfig{})
+	if err := restarted.callbacks.resume(ctx); err != nil {
+		t.Fatalf("restarted.callbacks.resume(): err: %s", err)
+	}
+	receiver.wait(t, 1)
+	restarted.callbacks.stop()
+
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	if got, want := string(receiver.bodies[0]), `{"Data":"QkI="}`; got != want {
+		t.Errorf("body = %s want %s", got, want)
+	}
+	assertNoDelivery(t, restarted)
+}
+
+func TestCallback_notifyAfterStop(t *testing.T) {
+	s := createCallbackServer(3)
+	postFossilWithCallback(s, "4242", "http://localhost")
+	s.callbacks.stop()
+
+	err := s.callbacks.notify(context.Background(), &fossilizer.Result{Data: []byte{0x42, 0x42}})
+	if got, want := err, errCallbacksStopped; got != want {
+		t.Errorf("s.callbacks.notify(): err = %v want %v", got, want)
+	}
+}
+
+func assertNoDelivery(t *testing.T, s *Server) {
+	pending, err := s.callbacks.deliveries(context.Background())
+	if err != nil {
+		t.Fatalf("s.callbacks.deliveries(): err: %s", err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("deliveries = %v want none", pending)
+	}
+}
+
+func TestCallback_invalidURL(t *testing.T) {
+	s := createCallbackServer(3)
+
+	if got, want := postFossilWithCallback(s, "4242", "ftp://zou").Code, http.StatusBadRequest; got != want {
+		t.Errorf("w.StatusCode = %d want %d", got, want)
+	}
+}
+
+func TestCallback_forbiddenAddress(t *testing.T) {
+	s := createCallbackServer(3)
+	s.callbacks.allowed = nil
+
+	for _, u := range []string{
+		"http://127.0.0.1:8080",
+		"http://[::1]/callback",
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.0.0.1",
+		"http://192.168.1.1",
+		"http://0.0.0.0",
+	} {
+		if got, want := postFossilWithCallback(s, "4242", u).Code, http.StatusBadRequest; got != want {
+			t.Errorf("%s: w.StatusCode = %d want %d", u, got, want)
+		}
+	}
+}
+
+func TestCallback_forbiddenDial(t *testing.T) {
+	receiver := newCallbackReceiver(http.StatusOK)
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+
+	// The address is checked again when the request is sent, for instance
+	// after a redirect or if the host now resolves to another address.
+	s := createCallbackServer(3)
+	s.callbacks.allowed = nil
+
+	retry, err := s.callbacks.post(context.Background(), ts.URL, []byte("{}"))
+	if err == nil {
+		t.Fatal("s.callbacks.post(): err = nil want error")
+	}
+	if retry {
+		t.Error("s.callbacks.post(): retry = true want false")
+	}
+}
+
+func TestCallback_noStore(t *testing.T) {
+	s, _ := createServer()
+
+	if got, want := postFossilWithCallback(s, "4242", "http://localhost").Code, http.StatusBadRequest; got != want {
+		t.Errorf("w.StatusCode = %d want %d", got, want)
+	}
+}
+
+func TestReplayDeadLetter_notFound(t *testing.T) {
+	s := createCallbackServer(3)
+
+	w, err := testutil.RequestJSON(s.ServeHTTP, "POST", "/callbacks/deadletters/zou/replay", nil, nil)
+	if err != nil {
+		t.Fatalf("testutil.RequestJSON(): err: %s", err)
+	}
+	if got, want := w.Code, http.StatusNotFound; got != want {
+		t.Errorf("w.StatusCode = %d want %d", got, want)
+	}
+}
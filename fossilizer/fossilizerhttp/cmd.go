@@ -17,8 +17,10 @@ package fossilizerhttp
 import (
 	"context"
 	"flag"
+	"net"
 	"net/http"
 	"runtime"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -26,6 +28,7 @@ import (
 	"github.com/stratumn/go-indigocore/fossilizer"
 	"github.com/stratumn/go-indigocore/jsonhttp"
 	"github.com/stratumn/go-indigocore/jsonws"
+	"github.com/stratumn/go-indigocore/leveldbstore"
 	"github.com/stratumn/go-indigocore/monitoring"
 )
 
@@ -47,6 +50,12 @@ var (
 	writeTimeout            time.Duration
 	maxHeaderBytes          int
 	shutdownTimeout         time.Duration
+	fossilsPath             string
+	callbackSecret          string
+	callbackMaxRetries      int
+	callbackBackoff         time.Duration
+	callbackTimeout         time.Duration
+	callbackAllowedNetworks string
 )
 
 // Run launches a fossilizerhttp server.
@@ -101,6 +110,12 @@ func RegisterFlags() {
 	flag.DurationVar(&wsPongTimeout, "ws_pong_timeout", jsonws.DefaultWebSocketPongTimeout, "Timeout for a web socket expected pong")
 	flag.DurationVar(&wsPingInterval, "ws_ping_interval", jsonws.DefaultWebSocketPingInterval, "Interval between web socket pings")
 	flag.Int64Var(&wsMaxMsgSize, "max_msg_size", jsonws.DefaultWebSocketMaxMsgSize, "Maximum size of a received web socket message")
+	flag.StringVar(&fossilsPath, "fossils_path", "", "Optional path to a LevelDB database where the status of fossils is saved")
+	flag.StringVar(&callbackSecret, "callback_secret", "", "Optional secret used to sign callback requests")
+	flag.IntVar(&callbackMaxRetries, "callback_max_retries", DefaultCallbackMaxRetries, "Maximum number of attempts to deliver a result to a callback URL")
+	flag.DurationVar(&callbackBackoff, "callback_backoff", DefaultCallbackBackoff, "Delay before retrying a callback, doubled after each attempt")
+	flag.DurationVar(&callbackTimeout, "callback_timeout", DefaultCallbackTimeout, "Timeout of callback requests")
+	flag.StringVar(&callbackAllowedNetworks, "callback_allowed_networks", "", "Comma separated CIDR networks callback URLs may reach even if they are loopback, link-local or private")
 }
 
 // RunWithFlags should be called after RegisterFlags and flag.Parse to launch
@@ -110,6 +125,28 @@ func RunWithFlags(ctx context.Context, a fossilizer.Adapter) {
 		MinDataLen:              minDataLen,
 		MaxDataLen:              maxDataLen,
 		FossilizerEventChanSize: fossilizerEventChanSize,
+		CallbackMaxRetries:      callbackMaxRetries,
+		CallbackBackoff:         callbackBackoff,
+		CallbackTimeout:         callbackTimeout,
+	}
+	if callbackSecret != "" {
+		config.CallbackSecret = []byte(callbackSecret)
+	}
+	if callbackAllowedNetworks != "" {
+		for _, cidr := range strings.Split(callbackAllowedNetworks, ",") {
+			_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+			if err != nil {
+				log.WithField("error", err).Fatal("Invalid callback allowed network")
+			}
+			config.CallbackAllowedNetworks = append(config.CallbackAllowedNetworks, network)
+		}
+	}
+	if fossilsPath != "" {
+		kv, err := leveldbstore.New(&leveldbstore.Config{Path: fossilsPath})
+		if err != nil {
+			log.WithField("error", err).Fatal("Failed to open fossils database")
+		}
+		config.Store = kv
 	}
 	monitoringConfig := monitoring.ConfigurationFromFlags()
 	httpConfig := &jsonhttp.Config{
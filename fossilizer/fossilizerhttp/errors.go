@@ -15,6 +15,8 @@
 package fossilizerhttp
 
 import (
+	"net/http"
+
 	"github.com/stratumn/go-indigocore/jsonhttp"
 )
 
@@ -38,3 +40,17 @@ func newErrProcess(msg string) jsonhttp.ErrHTTP {
 	}
 	return jsonhttp.NewErrBadRequest(msg)
 }
+
+func newErrCallbackURL(msg string) jsonhttp.ErrHTTP {
+	if msg == "" {
+		msg = "invalid callback URL"
+	}
+	return jsonhttp.NewErrBadRequest(msg)
+}
+
+func newErrCallbackFailed(msg string) jsonhttp.ErrHTTP {
+	if msg == "" {
+		msg = "callback failed"
+	}
+	return jsonhttp.NewErrHTTP(msg, http.StatusBadGateway)
+}
@@ -23,21 +23,55 @@
 //		Requests data to be fossilized.
 //		Form.data should be a hex encoded buffer.
 //		Form.callbackUrl should be a URL to be called when the evidence
-//		is ready.
+//		is ready. It cannot resolve to a loopback, link-local or private
+//		address unless its network is allowed by the configuration.
+//		Renders the status of the request, which contains its ID, if a
+//		store is configured.
+//
+//	GET /fossils/:id
+//		Renders the status of the fossil request with the given ID and
+//		its evidence once available.
+//		Requires a store in the configuration. Fossils are only reported
+//		as batched if the fossilizer sends batch events.
+//
+//	GET /batches/:root
+//		Renders the leaves of the batch with the given Merkle root.
+//		Requires a store in the configuration or a fossilizer that
+//		archives its batches.
+//
+//	GET /callbacks/deadletters
+//		Renders the results that could not be delivered to their
+//		callback URL.
+//		Requires a store in the configuration.
+//
+//	POST /callbacks/deadletters/:id/replay
+//		Tries to deliver a dead letter again and removes it on success.
+//		Requires a store in the configuration.
+//
+// Results are delivered to callback URLs as a JSON encoded
+// fossilizer.Result. If a callback secret is configured, the body is signed
+// with HMAC-SHA256 and the signature is sent in the X-Indigo-Signature
+// header (see Sign).
 package fossilizerhttp
 
 import (
 	"context"
 	"encoding/hex"
+	"net"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/satori/go.uuid"
 	"github.com/stratumn/go-indigocore/fossilizer"
 	"github.com/stratumn/go-indigocore/jsonhttp"
 	"github.com/stratumn/go-indigocore/jsonws"
 	"github.com/stratumn/go-indigocore/monitoring"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/types"
 
+	log "github.com/sirupsen/logrus"
 	"go.opencensus.io/trace"
 )
 
@@ -65,6 +99,28 @@ type Config struct {
 
 	// The size of the EventChan channel.
 	FossilizerEventChanSize int
+
+	// An optional key-value store where the status of fossils is saved.
+	// It is required to use callback URLs.
+	Store store.KeyValueStore
+
+	// An optional secret used to sign the body of callback requests.
+	CallbackSecret []byte
+
+	// The maximum number of attempts to deliver a result to a callback URL.
+	CallbackMaxRetries int
+
+	// The delay before retrying to deliver a result. It doubles after each
+	// attempt.
+	CallbackBackoff time.Duration
+
+	// The timeout of callback requests.
+	CallbackTimeout time.Duration
+
+	// Networks callback URLs may reach even though they are loopback,
+	// link-local or private networks. Callback URLs resolving to such
+	// addresses are refused by default.
+	CallbackAllowedNetworks []*net.IPNet
 }
 
 // Info is the info returned by the root route.
@@ -79,6 +135,8 @@ type Server struct {
 	config              *Config
 	ws                  *jsonws.Basic
 	fossilizerEventChan chan *fossilizer.Event
+	statuses            *statusStore
+	callbacks           *callbacks
 }
 
 // New create an instance of a server.
@@ -97,8 +155,17 @@ func New(
 		fossilizerEventChan: make(chan *fossilizer.Event, config.FossilizerEventChanSize),
 	}
 
+	if config.Store != nil {
+		s.statuses = &statusStore{kv: config.Store}
+		s.callbacks = newCallbacks(config.Store, config)
+	}
+
 	s.Get("/", s.root)
 	s.Post("/fossils", s.fossilize)
+	s.Get("/fossils/:id", s.getFossil)
+	s.Get("/batches/:root", s.getBatch)
+	s.Get("/callbacks/deadletters", s.getDeadLetters)
+	s.Post("/callbacks/deadletters/:id/replay", s.replayDeadLetter)
 	s.GetRaw("/websocket", s.getWebSocket)
 
 	return &s
@@ -128,6 +195,9 @@ func (s *Server) ListenAndServe() (err error) {
 func (s *Server) Shutdown(ctx context.Context) error {
 	s.ws.Stop()
 	close(s.fossilizerEventChan)
+	if s.callbacks != nil {
+		s.callbacks.stop()
+	}
 	return s.Server.Shutdown(ctx)
 }
 
@@ -136,6 +206,12 @@ func (s *Server) Shutdown(ctx context.Context) error {
 func (s *Server) Start() {
 	s.adapter.AddFossilizerEventChan(s.fossilizerEventChan)
 
+	if s.callbacks != nil {
+		if err := s.callbacks.resume(context.Background()); err != nil {
+			log.WithField("error", err).Warn("Failed to resume callback deliveries")
+		}
+	}
+
 	wg := sync.WaitGroup{}
 	wg.Add(2)
 
@@ -153,8 +229,16 @@ func (s *Server) Start() {
 }
 
 // Forward events to websocket
+// Batch events are only used to save the status of fossils: they contain
+// the data of every client of the batch.
 func (s *Server) handleEvents() {
 	for event := range s.fossilizerEventChan {
+		if s.statuses != nil {
+			s.saveEvent(event)
+		}
+		if event.EventType == fossilizer.DidBatch {
+			continue
+		}
 		s.ws.Broadcast(&jsonws.Message{
 			Type: string(event.EventType),
 			Data: event.Data,
@@ -162,6 +246,33 @@ func (s *Server) handleEvents() {
 	}
 }
 
+// Save the status of fossils from events
+func (s *Server) saveEvent(event *fossilizer.Event) {
+	ctx := context.Background()
+
+	var err error
+	switch event.EventType {
+	case fossilizer.DidBatch:
+		if b, ok := event.Data.(*fossilizer.Batch); ok {
+			err = s.statuses.setBatch(ctx, b)
+		}
+	case fossilizer.DidFossilizeLink:
+		if r, ok := event.Data.(*fossilizer.Result); ok {
+			err = s.statuses.setResult(ctx, r)
+			if e := s.callbacks.notify(ctx, r); e != nil {
+				log.WithField("error", e).Warn("Failed to notify callback URLs")
+			}
+		}
+	}
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"event": event.EventType,
+			"error": err,
+		}).Warn("Failed to save fossil status")
+	}
+}
+
 func (s *Server) root(w http.ResponseWriter, r *http.Request, _ httprouter.Params) (interface{}, error) {
 	ctx, span := trace.StartSpan(r.Context(), "fossilizerhttp/root")
 	defer span.End()
@@ -180,47 +291,185 @@ func (s *Server) fossilize(w http.ResponseWriter, r *http.Request, p httprouter.
 	ctx, span := trace.StartSpan(r.Context(), "fossilizerhttp/fossilize")
 	defer monitoring.SetSpanStatusAndEnd(span, err)
 
-	data, process, err := s.parseFossilizeValues(r)
+	data, process, callbackURL, err := s.parseFossilizeValues(r)
 	if err != nil {
 		return nil, err
 	}
 
+	var status *FossilStatus
+	if s.statuses != nil {
+		if status, err = s.statuses.setPending(ctx, uuid.NewV4().String(), data); err != nil {
+			return nil, err
+		}
+	}
+
+	added := false
+	if callbackURL != "" {
+		if added, err = s.callbacks.add(ctx, data, callbackURL); err != nil {
+			s.undoFossilize(ctx, status, data, "")
+			return nil, err
+		}
+	}
+
 	if err := s.adapter.Fossilize(ctx, data, []byte(process)); err != nil {
+		if !added {
+			callbackURL = ""
+		}
+		s.undoFossilize(ctx, status, data, callbackURL)
 		return nil, err
 	}
 
+	if status != nil {
+		return status, nil
+	}
+
 	return "ok", nil
 }
 
-func (s *Server) parseFossilizeValues(r *http.Request) ([]byte, string, error) {
+// undoFossilize deletes the status and the callback URL registered for a
+// fossil request that failed.
+func (s *Server) undoFossilize(ctx context.Context, status *FossilStatus, data []byte, callbackURL string) {
+	if status != nil {
+		if err := s.statuses.deletePending(ctx, status.ID, data); err != nil {
+			log.WithField("error", err).Warn("Failed to delete fossil status")
+		}
+	}
+	if callbackURL != "" {
+		if err := s.callbacks.remove(ctx, data, callbackURL); err != nil {
+			log.WithField("error", err).Warn("Failed to remove callback URL")
+		}
+	}
+}
+
+func (s *Server) getFossil(w http.ResponseWriter, r *http.Request, p httprouter.Params) (_ interface{}, err error) {
+	ctx, span := trace.StartSpan(r.Context(), "fossilizerhttp/getFossil")
+	defer monitoring.SetSpanStatusAndEnd(span, err)
+
+	if s.statuses == nil {
+		return nil, jsonhttp.NewErrNotFound("")
+	}
+
+	status, err := s.statuses.getFossil(ctx, p.ByName("id"))
+	if err != nil {
+		return nil, err
+	}
+	if status == nil {
+		return nil, jsonhttp.NewErrNotFound("")
+	}
+
+	return status, nil
+}
+
+func (s *Server) getBatch(w http.ResponseWriter, r *http.Request, p httprouter.Params) (_ interface{}, err error) {
+	ctx, span := trace.StartSpan(r.Context(), "fossilizerhttp/getBatch")
+	defer monitoring.SetSpanStatusAndEnd(span, err)
+
+	root, err := types.NewBytes32FromString(p.ByName("root"))
+	if err != nil {
+		return nil, newErrData("invalid root")
+	}
+
+	if s.statuses != nil {
+		info, err := s.statuses.getBatch(ctx, root)
+		if err != nil {
+			return nil, err
+		}
+		if info != nil {
+			return info, nil
+		}
+	}
+
+	if reader, ok := s.adapter.(fossilizer.BatchReader); ok {
+		b, err := reader.GetBatch(ctx, root)
+		if err != nil {
+			return nil, err
+		}
+		if b != nil {
+			return newBatchInfo(b), nil
+		}
+	}
+
+	return nil, jsonhttp.NewErrNotFound("")
+}
+
+func (s *Server) getDeadLetters(w http.ResponseWriter, r *http.Request, p httprouter.Params) (_ interface{}, err error) {
+	ctx, span := trace.StartSpan(r.Context(), "fossilizerhttp/getDeadLetters")
+	defer monitoring.SetSpanStatusAndEnd(span, err)
+
+	if s.callbacks == nil {
+		return nil, jsonhttp.NewErrNotFound("")
+	}
+
+	return s.callbacks.deadLetters(ctx)
+}
+
+func (s *Server) replayDeadLetter(w http.ResponseWriter, r *http.Request, p httprouter.Params) (_ interface{}, err error) {
+	ctx, span := trace.StartSpan(r.Context(), "fossilizerhttp/replayDeadLetter")
+	defer monitoring.SetSpanStatusAndEnd(span, err)
+
+	if s.callbacks == nil {
+		return nil, jsonhttp.NewErrNotFound("")
+	}
+
+	id := p.ByName("id")
+	letter, err := s.callbacks.getDeadLetter(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if letter == nil {
+		return nil, jsonhttp.NewErrNotFound("")
+	}
+
+	failed, err := s.callbacks.replay(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if failed != nil {
+		return nil, newErrCallbackFailed(failed.Error)
+	}
+
+	return "ok", nil
+}
+
+func (s *Server) parseFossilizeValues(r *http.Request) ([]byte, string, string, error) {
 	if err := r.ParseForm(); err != nil {
-		return nil, "", err
+		return nil, "", "", err
 	}
 
 	datastr := r.Form.Get("data")
 	if datastr == "" {
-		return nil, "", newErrData("")
+		return nil, "", "", newErrData("")
 	}
 
 	l := len(datastr)
 	if l < s.config.MinDataLen {
-		return nil, "", newErrDataLen("")
+		return nil, "", "", newErrDataLen("")
 	}
 	if s.config.MaxDataLen > 0 && l > s.config.MaxDataLen {
-		return nil, "", newErrDataLen("")
+		return nil, "", "", newErrDataLen("")
 	}
 
 	data, err := hex.DecodeString(datastr)
 	if err != nil {
-		return nil, "", jsonhttp.NewErrHTTP(err.Error(), http.StatusBadRequest)
+		return nil, "", "", jsonhttp.NewErrHTTP(err.Error(), http.StatusBadRequest)
 	}
 
 	process := r.Form.Get("process")
 	if process == "" {
-		return nil, "", newErrProcess("")
+		return nil, "", "", newErrProcess("")
+	}
+
+	callbackURL := r.Form.Get("callbackUrl")
+	if callbackURL != "" {
+		if s.callbacks == nil {
+			return nil, "", "", newErrCallbackURL("callback URLs require a store")
+		}
+		if err := s.callbacks.validate(r.Context(), callbackURL); err != nil {
+			return nil, "", "", newErrCallbackURL(err.Error())
+		}
 	}
 
-	return data, process, nil
+	return data, process, callbackURL, nil
 }
 
 func (s *Server) getWebSocket(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
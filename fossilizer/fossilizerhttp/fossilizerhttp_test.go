@@ -273,6 +273,11 @@ func TestGetSocket(t *testing.T) {
 		case <-time.After(time.Second):
 			t.Fatalf("connection ready timeout")
 		}
+		// Batch events must not be broadcasted.
+		eventChan <- &fossilizer.Event{
+			EventType: fossilizer.DidBatch,
+			Data:      &fossilizer.Batch{Data: [][]byte{{1, 2}}},
+		}
 		eventChan <- event
 	case <-time.After(time.Second):
 		t.Fatalf("save channel not added")
@@ -0,0 +1,268 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fossilizerhttp
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/fossilizer"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/types"
+
+	// Register evidence types to decode saved evidences.
+	_ "github.com/stratumn/go-indigocore/fossilizer/evidences"
+)
+
+// FossilState is the state of a fossil.
+type FossilState string
+
+const (
+	// FossilPending means the fossilizer received the data.
+	FossilPending FossilState = "pending"
+
+	// FossilBatched means the data was added to a batch which is being
+	// fossilized.
+	FossilBatched FossilState = "batched"
+
+	// FossilAnchored means the evidence is available.
+	FossilAnchored FossilState = "anchored"
+)
+
+const (
+	fossilKeyPrefix   = "fossilizerhttp:fossil:"
+	requestsKeyPrefix = "fossilizerhttp:requests:"
+	batchKeyPrefix    = "fossilizerhttp:batch:"
+)
+
+// FossilStatus is the status of a fossil request returned by
+// GET /fossils/:id.
+type FossilStatus struct {
+	ID       string         `json:"id"`
+	Data     string         `json:"data"`
+	State    FossilState    `json:"state"`
+	Root     *types.Bytes32 `json:"root,omitempty"`
+	Evidence *cs.Evidence   `json:"evidence,omitempty"`
+}
+
+// BatchInfo is the batch returned by GET /batches/:root.
+type BatchInfo struct {
+	Root   *types.Bytes32 `json:"root"`
+	Leaves []string       `json:"leaves"`
+}
+
+// statusStore saves the status of fossil requests in a key-value store.
+// Requests are indexed by their data until it is anchored, so that clients
+// fossilizing the same data each get their own status.
+type statusStore struct {
+	kv    store.KeyValueStore
+	mutex sync.Mutex
+}
+
+func newBatchInfo(b *fossilizer.Batch) *BatchInfo {
+	info := &BatchInfo{Root: b.Root, Leaves: make([]string, len(b.Data))}
+	for i, d := range b.Data {
+		info.Leaves[i] = hex.EncodeToString(d)
+	}
+	return info
+}
+
+func (s *statusStore) setPending(ctx context.Context, id string, data []byte) (*FossilStatus, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status := &FossilStatus{ID: id, Data: hex.EncodeToString(data), State: FossilPending}
+	if err := s.saveFossil(ctx, status); err != nil {
+		return nil, err
+	}
+
+	ids, err := s.getRequests(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, s.setRequests(ctx, data, append(ids, id))
+}
+
+func (s *statusStore) deletePending(ctx context.Context, id string, data []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, err := s.kv.DeleteValue(ctx, fossilKey(id)); err != nil {
+		return err
+	}
+
+	ids, err := s.getRequests(ctx, data)
+	if err != nil {
+		return err
+	}
+	for i, requestID := range ids {
+		if requestID == id {
+			return s.setRequests(ctx, data, append(ids[:i], ids[i+1:]...))
+		}
+	}
+
+	return nil
+}
+
+func (s *statusStore) setBatch(ctx context.Context, b *fossilizer.Batch) error {
+	value, err := json.Marshal(newBatchInfo(b))
+	if err != nil {
+		return err
+	}
+	if err := s.kv.SetValue(ctx, batchKey(b.Root), value); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, data := range b.Data {
+		err := s.updateRequests(ctx, data, func(status *FossilStatus) {
+			status.State = FossilBatched
+			status.Root = b.Root
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setResult anchors the requests of the data of a result. The requests are
+// then removed from the index, so a result delivered twice changes nothing.
+func (s *statusStore) setResult(ctx context.Context, r *fossilizer.Result) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := s.updateRequests(ctx, r.Data, func(status *FossilStatus) {
+		evidence := r.Evidence
+		status.State = FossilAnchored
+		status.Evidence = &evidence
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.kv.DeleteValue(ctx, requestsKey(r.Data))
+	return err
+}
+
+// updateRequests updates the status of the requests of some data.
+// It must be called with the mutex locked.
+func (s *statusStore) updateRequests(ctx context.Context, data []byte, fn func(*FossilStatus)) error {
+	ids, err := s.getRequests(ctx, data)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		status, err := s.getFossil(ctx, id)
+		if err != nil {
+			return err
+		}
+		if status == nil {
+			continue
+		}
+
+		fn(status)
+		if err := s.saveFossil(ctx, status); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *statusStore) getFossil(ctx context.Context, id string) (*FossilStatus, error) {
+	value, err := s.kv.GetValue(ctx, fossilKey(id))
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	var status FossilStatus
+	if err := json.Unmarshal(value, &status); err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+func (s *statusStore) saveFossil(ctx context.Context, status *FossilStatus) error {
+	value, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	return s.kv.SetValue(ctx, fossilKey(status.ID), value)
+}
+
+func (s *statusStore) getRequests(ctx context.Context, data []byte) ([]string, error) {
+	value, err := s.kv.GetValue(ctx, requestsKey(data))
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	var ids []string
+	if err := json.Unmarshal(value, &ids); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func (s *statusStore) setRequests(ctx context.Context, data []byte, ids []string) error {
+	if len(ids) == 0 {
+		_, err := s.kv.DeleteValue(ctx, requestsKey(data))
+		return err
+	}
+
+	value, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+
+	return s.kv.SetValue(ctx, requestsKey(data), value)
+}
+
+func (s *statusStore) getBatch(ctx context.Context, root *types.Bytes32) (*BatchInfo, error) {
+	value, err := s.kv.GetValue(ctx, batchKey(root))
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	var info BatchInfo
+	if err := json.Unmarshal(value, &info); err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+func fossilKey(id string) []byte {
+	return []byte(fossilKeyPrefix + id)
+}
+
+func requestsKey(data []byte) []byte {
+	return []byte(requestsKeyPrefix + hex.EncodeToString(data))
+}
+
+func batchKey(root *types.Bytes32) []byte {
+	return []byte(batchKeyPrefix + root.String())
+}
@@ -0,0 +1,333 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fossilizerhttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/dummyfossilizer/evidences"
+	"github.com/stratumn/go-indigocore/dummystore"
+	"github.com/stratumn/go-indigocore/fossilizer"
+	"github.com/stratumn/go-indigocore/fossilizer/fossilizertesting"
+	"github.com/stratumn/go-indigocore/jsonhttp"
+	"github.com/stratumn/go-indigocore/jsonws"
+	"github.com/stratumn/go-indigocore/testutil"
+	"github.com/stratumn/go-indigocore/types"
+)
+
+type batchReaderAdapter struct {
+	*fossilizertesting.MockAdapter
+	batch *fossilizer.Batch
+}
+
+func (a *batchReaderAdapter) GetBatch(_ context.Context, root *types.Bytes32) (*fossilizer.Batch, error) {
+	if a.batch != nil && *a.batch.Root == *root {
+		return a.batch, nil
+	}
+	return nil, nil
+}
+
+func createStatusServer(a fossilizer.Adapter) *Server {
+	return New(a, &Config{
+		MinDataLen: 2,
+		MaxDataLen: 16,
+		Store:      dummystore.New(&dummystore.Config{}),
+	}, &jsonhttp.Config{}, &jsonws.BasicConfig{}, &jsonws.BufferedConnConfig{
+		Size:         256,
+		WriteTimeout: 10 * time.Second,
+		PongTimeout:  70 * time.Second,
+		PingInterval: time.Minute,
+		MaxMsgSize:   1024,
+	})
+}
+
+func postFossil(s *Server, data string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/fossils", nil)
+	req.Form = url.Values{}
+	req.Form.Set("data", data)
+	req.Form.Set("process", "zou")
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+
+	return w
+}
+
+func postFossilID(t *testing.T, s *Server, data string) string {
+	w := postFossil(s, data)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("w.StatusCode = %d want %d", got, want)
+	}
+
+	var status FossilStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("json.Unmarshal(): err: %s", err)
+	}
+	if status.ID == "" {
+		t.Fatal("status.ID is empty")
+	}
+
+	return status.ID
+}
+
+func TestGetFossil(t *testing.T) {
+	a := &fossilizertesting.MockAdapter{}
+	s := createStatusServer(a)
+	root := testutil.RandomHash()
+	id := postFossilID(t, s, "4242")
+
+	var status FossilStatus
+	w, err := testutil.RequestJSON(s.ServeHTTP, "GET", "/fossils/"+id, nil, &status)
+	if err != nil {
+		t.Fatalf("testutil.RequestJSON(): err: %s", err)
+	}
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("w.StatusCode = %d want %d", got, want)
+	}
+	if got, want := status.State, FossilPending; got != want {
+		t.Errorf("status.State = %q want %q", got, want)
+	}
+
+	s.saveEvent(&fossilizer.Event{
+		EventType: fossilizer.DidBatch,
+		Data: &fossilizer.Batch{
+			Root: root,
+			Data: [][]byte{{0x42, 0x42}, {0x43, 0x43}},
+			Meta: [][]byte{[]byte("zou"), []byte("zou")},
+		},
+	})
+
+	status = FossilStatus{}
+	if _, err := testutil.RequestJSON(s.ServeHTTP, "GET", "/fossils/"+id, nil, &status); err != nil {
+		t.Fatalf("testutil.RequestJSON(): err: %s", err)
+	}
+	if got, want := status.State, FossilBatched; got != want {
+		t.Errorf("status.State = %q want %q", got, want)
+	}
+	if status.Root == nil || *status.Root != *root {
+		t.Errorf("status.Root = %v want %v", status.Root, root)
+	}
+
+	s.saveEvent(&fossilizer.Event{
+		EventType: fossilizer.DidFossilizeLink,
+		Data: &fossilizer.Result{
+			Evidence: cs.Evidence{
+				Backend:  evidences.Name,
+				Provider: evidences.Name,
+				Proof:    &evidences.DummyProof{Timestamp: 42},
+			},
+			Data: []byte{0x42, 0x42},
+			Meta: []byte("zou"),
+		},
+	})
+
+	status = FossilStatus{}
+	if _, err := testutil.RequestJSON(s.ServeHTTP, "GET", "/fossils/"+id, nil, &status); err != nil {
+		t.Fatalf("testutil.RequestJSON(): err: %s", err)
+	}
+	if got, want := status.State, FossilAnchored; got != want {
+		t.Errorf("status.State = %q want %q", got, want)
+	}
+	if status.Evidence == nil {
+		t.Fatal("status.Evidence = nil")
+	}
+	if got, want := status.Evidence.Proof.Time(), uint64(42); got != want {
+		t.Errorf("status.Evidence.Proof.Time() = %d want %d", got, want)
+	}
+}
+
+func TestGetFossil_sameData(t *testing.T) {
+	s := createStatusServer(&fossilizertesting.MockAdapter{})
+
+	first := postFossilID(t, s, "4242")
+	second := postFossilID(t, s, "4242")
+	if first == second {
+		t.Fatalf("both requests have ID %q", first)
+	}
+
+	result := &fossilizer.Result{
+		Evidence: cs.Evidence{
+			Backend:  evidences.Name,
+			Provider: evidences.Name,
+			Proof:    &evidences.DummyProof{Timestamp: 42},
+		},
+		Data: []byte{0x42, 0x42},
+		Meta: []byte("zou"),
+	}
+	s.saveEvent(&fossilizer.Event{EventType: fossilizer.DidFossilizeLink, Data: result})
+
+	for _, id := range []string{first, second} {
+		var status FossilStatus
+		if _, err := testutil.RequestJSON(s.ServeHTTP, "GET", "/fossils/"+id, nil, &status); err != nil {
+			t.Fatalf("testutil.RequestJSON(): err: %s", err)
+		}
+		if got, want := status.ID, id; got != want {
+			t.Errorf("status.ID = %q want %q", got, want)
+		}
+		if got, want := status.State, FossilAnchored; got != want {
+			t.Errorf("status.State = %q want %q", got, want)
+		}
+	}
+
+	// A request after the data was anchored has its own status.
+	third := postFossilID(t, s, "4242")
+	var status FossilStatus
+	if _, err := testutil.RequestJSON(s.ServeHTTP, "GET", "/fossils/"+third, nil, &status); err != nil {
+		t.Fatalf("testutil.RequestJSON(): err: %s", err)
+	}
+	if got, want := status.State, FossilPending; got != want {
+		t.Errorf("status.State = %q want %q", got, want)
+	}
+}
+
+func TestGetFossil_notFound(t *testing.T) {
+	s := createStatusServer(&fossilizertesting.MockAdapter{})
+
+	w, err := testutil.RequestJSON(s.ServeHTTP, "GET", "/fossils/4242", nil, nil)
+	if err != nil {
+		t.Fatalf("testutil.RequestJSON(): err: %s", err)
+	}
+	if got, want := w.Code, http.StatusNotFound; got != want {
+		t.Errorf("w.StatusCode = %d want %d", got, want)
+	}
+}
+
+func TestGetFossil_noStore(t *testing.T) {
+	s, _ := createServer()
+
+	w, err := testutil.RequestJSON(s.ServeHTTP, "GET", "/fossils/4242", nil, nil)
+	if err != nil {
+		t.Fatalf("testutil.RequestJSON(): err: %s", err)
+	}
+	if got, want := w.Code, http.StatusNotFound; got != want {
+		t.Errorf("w.StatusCode = %d want %d", got, want)
+	}
+}
+
+func TestGetFossil_fossilizeErr(t *testing.T) {
+	a := &fossilizertesting.MockAdapter{}
+	a.MockFossilize.Fn = func([]byte, []byte) error { return errors.New("error") }
+	s := createStatusServer(a)
+
+	req := httptest.NewRequest("POST", "/fossils", nil)
+	req.Form = url.Values{}
+	req.Form.Set("data", "4242")
+	req.Form.Set("callbackUrl", "http://example.com/callback")
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if got, want := w.Code, http.StatusInternalServerError; got != want {
+		t.Fatalf("w.StatusCode = %d want %d", got, want)
+	}
+
+	ctx := context.Background()
+	ids, err := s.statuses.getRequests(ctx, []byte{0x42, 0x42})
+	if err != nil {
+		t.Fatalf("s.statuses.getRequests(): err: %s", err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("s.statuses.getRequests() = %v want none", ids)
+	}
+	urls, err := s.callbacks.get(ctx, []byte{0x42, 0x42})
+	if err != nil {
+		t.Fatalf("s.callbacks.get(): err: %s", err)
+	}
+	if len(urls) != 0 {
+		t.Errorf("s.callbacks.get() = %v want none", urls)
+	}
+}
+
+func TestGetBatch(t *testing.T) {
+	s := createStatusServer(&fossilizertesting.MockAdapter{})
+	root := testutil.RandomHash()
+
+	s.saveEvent(&fossilizer.Event{
+		EventType: fossilizer.DidBatch,
+		Data: &fossilizer.Batch{
+			Root: root,
+			Data: [][]byte{{0x42, 0x42}, {0x43, 0x43}},
+			Meta: [][]byte{[]byte("zou"), []byte("zou")},
+		},
+	})
+
+	var info BatchInfo
+	w, err := testutil.RequestJSON(s.ServeHTTP, "GET", "/batches/"+root.String(), nil, &info)
+	if err != nil {
+		t.Fatalf("testutil.RequestJSON(): err: %s", err)
+	}
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("w.StatusCode = %d want %d", got, want)
+	}
+	if got, want := len(info.Leaves), 2; got != want {
+		t.Fatalf("len(info.Leaves) = %d want %d", got, want)
+	}
+	if got, want := info.Leaves[1], "4343"; got != want {
+		t.Errorf("info.Leaves[1] = %q want %q", got, want)
+	}
+}
+
+func TestGetBatch_batchReader(t *testing.T) {
+	root := testutil.RandomHash()
+	a := &batchReaderAdapter{
+		MockAdapter: &fossilizertesting.MockAdapter{},
+		batch: &fossilizer.Batch{
+			Root: root,
+			Data: [][]byte{{0x42, 0x42}},
+			Meta: [][]byte{[]byte("zou")},
+		},
+	}
+	s, _ := createServer()
+	s.adapter = a
+
+	var info BatchInfo
+	w, err := testutil.RequestJSON(s.ServeHTTP, "GET", "/batches/"+root.String(), nil, &info)
+	if err != nil {
+		t.Fatalf("testutil.RequestJSON(): err: %s", err)
+	}
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("w.StatusCode = %d want %d", got, want)
+	}
+	if got, want := info.Leaves, []string{"4242"}; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("info.Leaves = %v want %v", got, want)
+	}
+
+	w, err = testutil.RequestJSON(s.ServeHTTP, "GET", "/batches/"+testutil.RandomHash().String(), nil, nil)
+	if err != nil {
+		t.Fatalf("testutil.RequestJSON(): err: %s", err)
+	}
+	if got, want := w.Code, http.StatusNotFound; got != want {
+		t.Errorf("w.StatusCode = %d want %d", got, want)
+	}
+}
+
+func TestGetBatch_invalidRoot(t *testing.T) {
+	s, _ := createServer()
+
+	w, err := testutil.RequestJSON(s.ServeHTTP, "GET", "/batches/zou", nil, nil)
+	if err != nil {
+		t.Fatalf("testutil.RequestJSON(): err: %s", err)
+	}
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Errorf("w.StatusCode = %d want %d", got, want)
+	}
+}
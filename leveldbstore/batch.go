@@ -0,0 +1,49 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leveldbstore
+
+import (
+	"context"
+
+	"github.com/stratumn/go-indigocore/bufferedbatch"
+	"github.com/stratumn/go-indigocore/monitoring"
+	"go.opencensus.io/trace"
+)
+
+// Batch is the type that implements github.com/stratumn/go-indigocore/store.Batch.
+type Batch struct {
+	*bufferedbatch.Batch
+
+	originalLevelDBStore *LevelDBStore
+}
+
+// NewBatch creates a new Batch
+func NewBatch(ctx context.Context, a *LevelDBStore) *Batch {
+	return &Batch{
+		Batch:                bufferedbatch.NewBatch(ctx, a),
+		originalLevelDBStore: a,
+	}
+}
+
+// Write implements github.com/stratumn/go-indigocore/store.Batch.Write.
+// The links of the batch are written atomically: either all of them are
+// saved, or none is.
+func (b *Batch) Write(ctx context.Context) (err error) {
+	ctx, span := trace.StartSpan(ctx, "leveldbstore/batch/Write")
+	defer monitoring.SetSpanStatusAndEnd(span, err)
+
+	_, err = b.originalLevelDBStore.writeLinks(b.Links)
+	return
+}
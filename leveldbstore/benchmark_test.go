@@ -0,0 +1,32 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leveldbstore
+
+import (
+	"testing"
+
+	"github.com/stratumn/go-indigocore/store/storetestcases"
+)
+
+func BenchmarkLevelDBStore(b *testing.B) {
+	factory := storetestcases.Factory{
+		New:              createAdapter,
+		Free:             freeAdapter,
+		NewKeyValueStore: createKeyValueStore,
+	}
+
+	factory.RunStoreBenchmarks(b)
+	factory.RunKeyValueStoreBenchmarks(b)
+}
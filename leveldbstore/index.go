@@ -0,0 +1,259 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leveldbstore
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/types"
+	"github.com/tendermint/tmlibs/db"
+)
+
+// Keys used by the store adapter. They all start with adapterPrefix.
+// Values set through the KeyValueStore interface are saved under
+// valuePrefix so that callers can't overwrite adapter data.
+//
+// Index keys end with a sort key made of the priority of the link,
+// encoded so that higher priorities come first, followed by the link hash.
+// Iterating over an index prefix hence returns links in the same order as
+// cs.SegmentSlice.
+const (
+	adapterPrefix = "leveldbstore:"
+	valuePrefix   = "leveldbstore:value:"
+
+	// versionKey is set once the values of databases written before they
+	// were namespaced have been moved under valuePrefix.
+	versionKey = "leveldbstore:version"
+	version    = "1"
+
+	linkPrefix      = "leveldbstore:link:"
+	evidencesPrefix = "leveldbstore:evidences:"
+
+	linksIndex        = "leveldbstore:index:links:"
+	processIndex      = "leveldbstore:index:process:"
+	mapIDIndex        = "leveldbstore:index:mapid:"
+	prevLinkHashIndex = "leveldbstore:index:prevlinkhash:"
+	tagIndex          = "leveldbstore:index:tag:"
+
+	// mapsIndex keys are a map ID followed by a process.
+	mapsIndex = "leveldbstore:index:maps:"
+
+	indexSeparator = "\x00"
+	sortKeyLen     = 8 + types.Bytes32Size
+)
+
+func linkKey(linkHash *types.Bytes32) []byte {
+	return []byte(linkPrefix + linkHash.String())
+}
+
+func valueKey(key []byte) []byte {
+	return append([]byte(valuePrefix), key...)
+}
+
+func evidencesKey(linkHash *types.Bytes32) []byte {
+	return []byte(evidencesPrefix + linkHash.String())
+}
+
+// indexKeys returns the index entries of a link.
+func indexKeys(linkHash *types.Bytes32, link *cs.Link) [][]byte {
+	var (
+		s            = string(sortKey(link.Meta.Priority, linkHash))
+		prevLinkHash = ""
+	)
+
+	if p := link.Meta.GetPrevLinkHash(); p != nil {
+		prevLinkHash = p.String()
+	}
+
+	keys := [][]byte{
+		[]byte(linksIndex + s),
+		[]byte(processIndex + link.Meta.Process + indexSeparator + s),
+		[]byte(mapIDIndex + link.Meta.MapID + indexSeparator + s),
+		[]byte(prevLinkHashIndex + prevLinkHash + indexSeparator + s),
+		[]byte(mapsIndex + link.Meta.MapID + indexSeparator + link.Meta.Process),
+	}
+	for _, tag := range link.Meta.Tags {
+		keys = append(keys, []byte(tagIndex+tag+indexSeparator+s))
+	}
+
+	return keys
+}
+
+// sortKey encodes the priority and hash of a link so that keys are sorted
+// by decreasing priority then increasing link hash.
+func sortKey(priority float64, linkHash *types.Bytes32) []byte {
+	bits := math.Float64bits(priority)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+
+	key := make([]byte, 8, sortKeyLen)
+	binary.BigEndian.PutUint64(key, ^bits)
+
+	return append(key, linkHash[:]...)
+}
+
+func sortKeyLinkHash(key string) *types.Bytes32 {
+	var linkHash types.Bytes32
+	copy(linkHash[:], key[len(key)-types.Bytes32Size:])
+	return &linkHash
+}
+
+// findLinkHashes uses the indexes to find the hashes of the links matching
+// a filter, sorted like a cs.SegmentSlice and paginated.
+func (a *LevelDBStore) findLinkHashes(filter *store.SegmentFilter) ([]*types.Bytes32, error) {
+	var candidates map[string]struct{}
+
+	restrict := func(set map[string]struct{}) {
+		if candidates == nil {
+			candidates = set
+			return
+		}
+		for k := range candidates {
+			if _, ok := set[k]; !ok {
+				delete(candidates, k)
+			}
+		}
+	}
+
+	if filter.Process != "" {
+		restrict(a.lookup(processIndex + filter.Process + indexSeparator))
+	}
+
+	if len(filter.MapIDs) > 0 {
+		union := map[string]struct{}{}
+		for _, mapID := range filter.MapIDs {
+			for k := range a.lookup(mapIDIndex + mapID + indexSeparator) {
+				union[k] = struct{}{}
+			}
+		}
+		restrict(union)
+	}
+
+	if filter.PrevLinkHash != nil {
+		prevLinkHash := *filter.PrevLinkHash
+		if prevLinkHash != "" {
+			p, err := types.NewBytes32FromString(prevLinkHash)
+			if err != nil {
+				return nil, nil
+			}
+			prevLinkHash = p.String()
+		}
+		restrict(a.lookup(prevLinkHashIndex + prevLinkHash + indexSeparator))
+	}
+
+	for _, tag := range filter.Tags {
+		restrict(a.lookup(tagIndex + tag + indexSeparator))
+	}
+
+	if len(filter.LinkHashes) > 0 {
+		set := map[string]struct{}{}
+		for _, h := range filter.LinkHashes {
+			linkHash, err := types.NewBytes32FromString(h)
+			if err != nil {
+				continue
+			}
+			js := a.kvDB.Get(linkKey(linkHash))
+			if js == nil {
+				continue
+			}
+			var link cs.Link
+			if err := json.Unmarshal(js, &link); err != nil {
+				return nil, err
+			}
+			set[string(sortKey(link.Meta.Priority, linkHash))] = struct{}{}
+		}
+		restrict(set)
+	}
+
+	var keys []string
+	if candidates == nil {
+		keys = a.scan(linksIndex, filter.Pagination)
+	} else {
+		for k := range candidates {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		keys = filter.Pagination.PaginateStrings(keys)
+	}
+
+	linkHashes := make([]*types.Bytes32, len(keys))
+	for i, k := range keys {
+		linkHashes[i] = sortKeyLinkHash(k)
+	}
+
+	return linkHashes, nil
+}
+
+// lookup returns the sort keys of all the entries under an index prefix.
+func (a *LevelDBStore) lookup(prefix string) map[string]struct{} {
+	set := map[string]struct{}{}
+
+	it := db.IteratePrefix(a.kvDB, []byte(prefix))
+	defer it.Close()
+
+	for ; it.Valid(); it.Next() {
+		set[string(it.Key()[len(prefix):])] = struct{}{}
+	}
+
+	return set
+}
+
+// scan returns a page of the sort keys under an index prefix, which are
+// already sorted.
+func (a *LevelDBStore) scan(prefix string, pagination store.Pagination) []string {
+	keys := []string{}
+
+	it := db.IteratePrefix(a.kvDB, []byte(prefix))
+	defer it.Close()
+
+	for i := 0; it.Valid() && len(keys) < pagination.Limit; it.Next() {
+		if i >= pagination.Offset {
+			keys = append(keys, string(it.Key()[len(prefix):]))
+		}
+		i++
+	}
+
+	return keys
+}
+
+// findMapIDs uses the index to find the sorted map IDs of a process, or of
+// all processes if process is empty.
+func (a *LevelDBStore) findMapIDs(process string) []string {
+	mapIDs := []string{}
+
+	it := db.IteratePrefix(a.kvDB, []byte(mapsIndex))
+	defer it.Close()
+
+	for ; it.Valid(); it.Next() {
+		parts := strings.SplitN(string(it.Key()[len(mapsIndex):]), indexSeparator, 2)
+		if len(parts) != 2 || process != "" && parts[1] != process {
+			continue
+		}
+		if l := len(mapIDs); l == 0 || mapIDs[l-1] != parts[0] {
+			mapIDs = append(mapIDs, parts[0])
+		}
+	}
+
+	return mapIDs
+}
@@ -0,0 +1,36 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leveldbstore
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stratumn/go-indigocore/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortKey(t *testing.T) {
+	linkHash := testutil.RandomHash()
+	priorities := []float64{1e10, 3, 1.5, 0, -0.5, -2, -1e10}
+
+	for i := 1; i < len(priorities); i++ {
+		k1 := sortKey(priorities[i-1], linkHash)
+		k2 := sortKey(priorities[i], linkHash)
+		assert.True(t, bytes.Compare(k1, k2) < 0, "priority %v should come before %v", priorities[i-1], priorities[i])
+	}
+
+	assert.Equal(t, *linkHash, *sortKeyLinkHash(string(sortKey(42, linkHash))))
+}
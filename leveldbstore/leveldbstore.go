@@ -12,28 +12,64 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Package leveldbstore implements a simple key-value local store.
-// It's efficient and can be used by stores to save key-value pairs.
+// Package leveldbstore implements an embedded store that keeps links,
+// evidences, key-value pairs and indexes in a single LevelDB database.
+// It's efficient and doesn't depend on an external service.
+// It can also be used by other stores to save key-value pairs.
 package leveldbstore
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"sync"
 
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/types"
 	"github.com/tendermint/tmlibs/db"
 )
 
-// LevelDBStore implements github.com/stratumn/go-indigocore/store.KeyValueStore.
+const (
+	// Name is the name set in the store's information.
+	Name = "leveldb"
+
+	// Description is the description set in the store's information.
+	Description = "Indigo's LevelDB Store"
+
+	// DefaultPath is the path where the database will be saved by default.
+	DefaultPath = "/var/stratumn/leveldbstore"
+)
+
+// LevelDBStore implements github.com/stratumn/go-indigocore/store.Adapter
+// and github.com/stratumn/go-indigocore/store.KeyValueStore.
 type LevelDBStore struct {
-	config *Config
-	kvDB   db.DB
+	config     *Config
+	kvDB       db.DB
+	eventChans []chan *store.Event
+	mutex      sync.Mutex // serializes writes
 }
 
 // Config contains configuration options for the store.
 type Config struct {
+	// A version string that will be set in the store's information.
+	Version string
+
+	// A git commit hash that will be set in the store's information.
+	Commit string
+
 	// Path where key-value pairs will be saved.
 	Path string
 }
 
+// Info is the info returned by GetInfo.
+type Info struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Version     string `json:"version"`
+	Commit      string `json:"commit"`
+}
+
 // New creates an instance of a LevelDBStore.
 func New(config *Config) (*LevelDBStore, error) {
 	db, err := db.NewGoLevelDB("keyvalue-store", config.Path)
@@ -41,28 +77,287 @@ func New(config *Config) (*LevelDBStore, error) {
 		return nil, err
 	}
 
-	return &LevelDBStore{config, db}, nil
+	a := &LevelDBStore{config: config, kvDB: db}
+	a.migrateValues()
+
+	return a, nil
+}
+
+// migrateValues moves the values of a database written before they were
+// namespaced under valuePrefix. Keys already starting with adapterPrefix
+// are left untouched.
+func (a *LevelDBStore) migrateValues() {
+	if a.kvDB.Get([]byte(versionKey)) != nil {
+		return
+	}
+
+	batch := a.kvDB.NewBatch()
+	it := db.IteratePrefix(a.kvDB, nil)
+	for ; it.Valid(); it.Next() {
+		key := append([]byte(nil), it.Key()...)
+		if bytes.HasPrefix(key, []byte(adapterPrefix)) {
+			continue
+		}
+		batch.Set(valueKey(key), append([]byte(nil), it.Value()...))
+		batch.Delete(key)
+	}
+	it.Close()
+
+	batch.Set([]byte(versionKey), []byte(version))
+	batch.Write()
+}
+
+/********** Store adapter implementation **********/
+
+// GetInfo implements github.com/stratumn/go-indigocore/store.Adapter.GetInfo.
+func (a *LevelDBStore) GetInfo(ctx context.Context) (interface{}, error) {
+	return &Info{
+		Name:        Name,
+		Description: Description,
+		Version:     a.config.Version,
+		Commit:      a.config.Commit,
+	}, nil
+}
+
+// AddStoreEventChannel implements github.com/stratumn/go-indigocore/store.Adapter.AddStoreEventChannel.
+func (a *LevelDBStore) AddStoreEventChannel(eventChan chan *store.Event) {
+	a.eventChans = append(a.eventChans, eventChan)
+}
+
+// NewBatch implements github.com/stratumn/go-indigocore/store.Adapter.NewBatch.
+func (a *LevelDBStore) NewBatch(ctx context.Context) (store.Batch, error) {
+	return NewBatch(ctx, a), nil
+}
+
+/********** Store writer implementation **********/
+
+// CreateLink implements github.com/stratumn/go-indigocore/store.LinkWriter.CreateLink.
+func (a *LevelDBStore) CreateLink(ctx context.Context, link *cs.Link) (*types.Bytes32, error) {
+	linkHashes, err := a.writeLinks([]*cs.Link{link})
+	if err != nil {
+		return nil, err
+	}
+
+	return linkHashes[0], nil
+}
+
+// writeLinks atomically saves links and their index entries.
+func (a *LevelDBStore) writeLinks(links []*cs.Link) ([]*types.Bytes32, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	batch := a.kvDB.NewBatch()
+	linkHashes := make([]*types.Bytes32, len(links))
+
+	for i, link := range links {
+		linkHash, err := link.Hash()
+		if err != nil {
+			return nil, err
+		}
+
+		js, err := json.Marshal(link)
+		if err != nil {
+			return nil, err
+		}
+
+		batch.Set(linkKey(linkHash), js)
+		for _, key := range indexKeys(linkHash, link) {
+			batch.Set(key, []byte{})
+		}
+
+		linkHashes[i] = linkHash
+	}
+
+	batch.Write()
+
+	if len(links) == 0 {
+		return linkHashes, nil
+	}
+
+	linksEvent := store.NewSavedLinks(links...)
+	for _, c := range a.eventChans {
+		c <- linksEvent
+	}
+
+	return linkHashes, nil
+}
+
+// AddEvidence implements github.com/stratumn/go-indigocore/store.EvidenceWriter.AddEvidence.
+func (a *LevelDBStore) AddEvidence(ctx context.Context, linkHash *types.Bytes32, evidence *cs.Evidence) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	currentEvidences, err := a.GetEvidences(ctx, linkHash)
+	if err != nil {
+		return err
+	}
+
+	if err = currentEvidences.AddEvidence(*evidence); err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(currentEvidences)
+	if err != nil {
+		return err
+	}
+
+	a.kvDB.Set(evidencesKey(linkHash), value)
+
+	evidenceEvent := store.NewSavedEvidences()
+	evidenceEvent.AddSavedEvidence(linkHash, evidence)
+
+	for _, c := range a.eventChans {
+		c <- evidenceEvent
+	}
+
+	return nil
+}
+
+/********** Store reader implementation **********/
+
+// GetSegment implements github.com/stratumn/go-indigocore/store.SegmentReader.GetSegment.
+func (a *LevelDBStore) GetSegment(ctx context.Context, linkHash *types.Bytes32) (*cs.Segment, error) {
+	js := a.kvDB.Get(linkKey(linkHash))
+	if js == nil {
+		return nil, nil
+	}
+
+	var link cs.Link
+	if err := json.Unmarshal(js, &link); err != nil {
+		return nil, err
+	}
+
+	evidences, err := a.GetEvidences(ctx, linkHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cs.Segment{
+		Link: link,
+		Meta: cs.SegmentMeta{
+			Evidences: *evidences,
+			LinkHash:  linkHash.String(),
+		},
+	}, nil
+}
+
+// FindSegments implements github.com/stratumn/go-indigocore/store.SegmentReader.FindSegments.
+func (a *LevelDBStore) FindSegments(ctx context.Context, filter *store.SegmentFilter) (cs.SegmentSlice, error) {
+	linkHashes, err := a.findLinkHashes(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make(cs.SegmentSlice, 0, len(linkHashes))
+	for _, linkHash := range linkHashes {
+		segment, err := a.GetSegment(ctx, linkHash)
+		if err != nil {
+			return nil, err
+		}
+		if segment != nil {
+			segments = append(segments, segment)
+		}
+	}
+
+	return segments, nil
+}
+
+// GetMapIDs implements github.com/stratumn/go-indigocore/store.SegmentReader.GetMapIDs.
+func (a *LevelDBStore) GetMapIDs(ctx context.Context, filter *store.MapFilter) ([]string, error) {
+	return filter.Pagination.PaginateStrings(a.findMapIDs(filter.Process)), nil
+}
+
+// GetEvidences implements github.com/stratumn/go-indigocore/store.EvidenceReader.GetEvidences.
+func (a *LevelDBStore) GetEvidences(ctx context.Context, linkHash *types.Bytes32) (*cs.Evidences, error) {
+	evidences := cs.Evidences{}
+	if data := a.kvDB.Get(evidencesKey(linkHash)); len(data) > 0 {
+		if err := json.Unmarshal(data, &evidences); err != nil {
+			return nil, err
+		}
+	}
+
+	return &evidences, nil
 }
 
+/********** github.com/stratumn/go-indigocore/store.KeyValueStore implementation **********/
+
 // SetValue implements github.com/stratumn/go-indigocore/store.KeyValueStore.SetValue.
 func (a *LevelDBStore) SetValue(ctx context.Context, key []byte, value []byte) error {
-	a.kvDB.Set(key, value)
+	a.kvDB.Set(valueKey(key), value)
 	return nil
 }
 
 // GetValue implements github.com/stratumn/go-indigocore/store.KeyValueStore.GetValue.
 func (a *LevelDBStore) GetValue(ctx context.Context, key []byte) ([]byte, error) {
-	return a.kvDB.Get(key), nil
+	return a.kvDB.Get(valueKey(key)), nil
+}
+
+// CreateValue implements github.com/stratumn/go-indigocore/store.KeyValueCreator.CreateValue.
+func (a *LevelDBStore) CreateValue(ctx context.Context, key []byte, value []byte) (bool, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	k := valueKey(key)
+	if a.kvDB.Get(k) != nil {
+		return false, nil
+	}
+
+	a.kvDB.Set(k, value)
+	return true, nil
 }
 
 // DeleteValue implements github.com/stratumn/go-indigocore/store.KeyValueStore.DeleteValue.
 func (a *LevelDBStore) DeleteValue(ctx context.Context, key []byte) ([]byte, error) {
-	v := a.kvDB.Get(key)
+	k := valueKey(key)
+	v := a.kvDB.Get(k)
 
 	if v != nil {
-		a.kvDB.Delete(key)
+		a.kvDB.Delete(k)
 		return v, nil
 	}
 
 	return nil, nil
 }
+
+// IteratePrefix calls fn on the key-value pairs whose key starts with the
+// given prefix, in key order. It stops at the first error returned by fn.
+// The key and value must not be modified or retained by fn.
+func (a *LevelDBStore) IteratePrefix(ctx context.Context, prefix []byte, fn func(key, value []byte) error) error {
+	it := db.IteratePrefix(a.kvDB, valueKey(prefix))
+	defer it.Close()
+
+	for ; it.Valid(); it.Next() {
+		if err := fn(it.Key()[len(valuePrefix):], it.Value()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ValueBatch buffers changes to key-value pairs so that they are written
+// atomically.
+type ValueBatch struct {
+	batch db.Batch
+}
+
+// NewValueBatch creates a batch of key-value changes.
+func (a *LevelDBStore) NewValueBatch() *ValueBatch {
+	return &ValueBatch{batch: a.kvDB.NewBatch()}
+}
+
+// SetValue adds the value of a key to the batch.
+func (b *ValueBatch) SetValue(key []byte, value []byte) {
+	b.batch.Set(valueKey(key), value)
+}
+
+// DeleteValue adds the deletion of a key to the batch.
+func (b *ValueBatch) DeleteValue(key []byte) {
+	b.batch.Delete(valueKey(key))
+}
+
+// Write writes all the changes of the batch in a single LevelDB batch.
+func (b *ValueBatch) Write(ctx context.Context) error {
+	b.batch.Write()
+	return nil
+}
@@ -15,32 +15,115 @@
 package leveldbstore
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"testing"
 
+	"github.com/stratumn/go-indigocore/cs/cstesting"
 	"github.com/stratumn/go-indigocore/store"
 	"github.com/stratumn/go-indigocore/store/storetestcases"
+	"github.com/stratumn/go-indigocore/tmpop/tmpoptestcases"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestLevelDBStore(t *testing.T) {
 	factory := storetestcases.Factory{
-		NewKeyValueStore: func() (store.KeyValueStore, error) {
-			path, err := ioutil.TempDir("", "leveldbstore")
-			if err != nil {
-				return nil, err
-			}
-			db, err := New(&Config{Path: path})
-			if err != nil {
-				return nil, err
-			}
-			return db, nil
-		},
-		FreeKeyValueStore: func(s store.KeyValueStore) {
-			a := s.(*LevelDBStore)
-			defer os.RemoveAll(a.config.Path)
-		},
+		New:               createAdapter,
+		Free:              freeAdapter,
+		NewKeyValueStore:  createKeyValueStore,
+		FreeKeyValueStore: freeKeyValueStore,
 	}
 
+	factory.RunStoreTests(t)
 	factory.RunKeyValueStoreTests(t)
 }
+
+func TestLevelDBTMPop(t *testing.T) {
+	tmpoptestcases.Factory{
+		New:  createAdapterTMPop,
+		Free: freeAdapterTMPop,
+	}.RunTests(t)
+}
+
+func createLevelDBStore() (*LevelDBStore, error) {
+	path, err := ioutil.TempDir("", "leveldbstore")
+	if err != nil {
+		return nil, err
+	}
+	return New(&Config{Path: path})
+}
+
+func createAdapter() (store.Adapter, error) {
+	return createLevelDBStore()
+}
+
+func createKeyValueStore() (store.KeyValueStore, error) {
+	return createLevelDBStore()
+}
+
+func createAdapterTMPop() (store.Adapter, store.KeyValueStore, error) {
+	a, err := createLevelDBStore()
+	return a, a, err
+}
+
+func freeLevelDBStore(a *LevelDBStore) {
+	os.RemoveAll(a.config.Path)
+}
+
+func freeAdapter(s store.Adapter) {
+	freeLevelDBStore(s.(*LevelDBStore))
+}
+
+func freeKeyValueStore(s store.KeyValueStore) {
+	freeLevelDBStore(s.(*LevelDBStore))
+}
+
+func freeAdapterTMPop(a store.Adapter, _ store.KeyValueStore) {
+	freeAdapter(a)
+}
+
+func TestLevelDBStore_ValuesNamespace(t *testing.T) {
+	ctx := context.Background()
+	a, err := createLevelDBStore()
+	require.NoError(t, err, "createLevelDBStore()")
+	defer freeLevelDBStore(a)
+
+	link := cstesting.RandomLink()
+	linkHash, err := a.CreateLink(ctx, link)
+	require.NoError(t, err, "a.CreateLink()")
+
+	// Values can't overwrite adapter data.
+	require.NoError(t, a.SetValue(ctx, linkKey(linkHash), []byte("value")), "a.SetValue()")
+
+	got, err := a.GetSegment(ctx, linkHash)
+	require.NoError(t, err, "a.GetSegment()")
+	require.NotNil(t, got, "a.GetSegment()")
+	assert.EqualValues(t, *link, got.Link, "got.Link")
+}
+
+func TestLevelDBStore_MigrateValues(t *testing.T) {
+	ctx := context.Background()
+	a, err := createLevelDBStore()
+	require.NoError(t, err, "createLevelDBStore()")
+	defer freeLevelDBStore(a)
+
+	linkHash, err := a.CreateLink(ctx, cstesting.RandomLink())
+	require.NoError(t, err, "a.CreateLink()")
+
+	// Simulate a database written before values were namespaced.
+	a.kvDB.Delete([]byte(versionKey))
+	a.kvDB.Set([]byte("key"), []byte("value"))
+
+	a.migrateValues()
+
+	value, err := a.GetValue(ctx, []byte("key"))
+	require.NoError(t, err, "a.GetValue()")
+	assert.Equal(t, []byte("value"), value)
+	assert.Nil(t, a.kvDB.Get([]byte("key")), "old key")
+
+	got, err := a.GetSegment(ctx, linkHash)
+	require.NoError(t, err, "a.GetSegment()")
+	assert.NotNil(t, got, "a.GetSegment()")
+}
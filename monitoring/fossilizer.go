@@ -19,6 +19,7 @@ import (
 	"fmt"
 
 	"github.com/stratumn/go-indigocore/fossilizer"
+	"github.com/stratumn/go-indigocore/types"
 
 	"go.opencensus.io/trace"
 )
@@ -60,3 +61,18 @@ func (a *FossilizerAdapter) Fossilize(ctx context.Context, data []byte, meta []b
 	err = a.f.Fossilize(ctx, data, meta)
 	return
 }
+
+// GetBatch instruments the call and delegates to the underlying fossilizer
+// if it implements github.com/stratumn/go-indigocore/fossilizer.BatchReader.
+func (a *FossilizerAdapter) GetBatch(ctx context.Context, root *types.Bytes32) (b *fossilizer.Batch, err error) {
+	reader, ok := a.f.(fossilizer.BatchReader)
+	if !ok {
+		return nil, nil
+	}
+
+	ctx, span := trace.StartSpan(ctx, fmt.Sprintf("%s/GetBatch", a.name))
+	defer SetSpanStatusAndEnd(span, err)
+
+	b, err = reader.GetBatch(ctx, root)
+	return
+}
@@ -87,6 +87,29 @@ func (a *StoreAdapter) CreateLink(ctx context.Context, link *cs.Link) (lh *types
 	return
 }
 
+// CreateLinks instruments the call and delegates to the underlying store.
+// It implements github.com/stratumn/go-indigocore/store.LinksWriter even if
+// the underlying store doesn't, in which case links are created one by one.
+func (a *StoreAdapter) CreateLinks(ctx context.Context, links []*cs.Link, mode store.WriteMode) (res []*store.LinkResult, err error) {
+	ctx, span := trace.StartSpan(ctx, fmt.Sprintf("%s/CreateLinks", a.name))
+	span.AddAttributes(trace.Int64Attribute("LinksCount", int64(len(links))))
+	defer SetSpanStatusAndEnd(span, err)
+
+	res, err = store.CreateLinks(ctx, a.s, links, mode)
+	return
+}
+
+// GetLinkHeight instruments the call and delegates to the underlying store.
+// It returns store.ErrLinkHeightUnavailable if the underlying store doesn't
+// implement github.com/stratumn/go-indigocore/store.LinkHeightReader.
+func (a *StoreAdapter) GetLinkHeight(ctx context.Context, linkHash *types.Bytes32) (h int64, err error) {
+	ctx, span := trace.StartSpan(ctx, fmt.Sprintf("%s/GetLinkHeight", a.name))
+	defer SetSpanStatusAndEnd(span, err)
+
+	h, err = store.GetLinkHeight(ctx, a.s, linkHash)
+	return
+}
+
 // GetSegment instruments the call and delegates to the underlying store.
 func (a *StoreAdapter) GetSegment(ctx context.Context, linkHash *types.Bytes32) (s *cs.Segment, err error) {
 	ctx, span := trace.StartSpan(ctx, fmt.Sprintf("%s/GetSegment", a.name))
@@ -105,6 +128,56 @@ func (a *StoreAdapter) FindSegments(ctx context.Context, filter *store.SegmentFi
 	return
 }
 
+// IsSearchable implements
+// github.com/stratumn/go-indigocore/store.SearchChecker.IsSearchable.
+func (a *StoreAdapter) IsSearchable() bool {
+	return store.IsSearchable(a.s)
+}
+
+// Search instruments the call and delegates to the underlying store.
+// It returns store.ErrSearchUnavailable if the underlying store doesn't
+// implement github.com/stratumn/go-indigocore/store.Searcher.
+func (a *StoreAdapter) Search(ctx context.Context, query *store.SearchQuery) (ss cs.SegmentSlice, err error) {
+	ctx, span := trace.StartSpan(ctx, fmt.Sprintf("%s/Search", a.name))
+	defer SetSpanStatusAndEnd(span, err)
+
+	ss, err = store.Search(ctx, a.s, query)
+	return
+}
+
+// SaveStateOpenings instruments the call and delegates to the underlying store.
+// It returns store.ErrStateOpeningsUnavailable if the underlying store
+// can't save state openings.
+func (a *StoreAdapter) SaveStateOpenings(ctx context.Context, linkHash *types.Bytes32, openings []*cs.StateOpening) (err error) {
+	ctx, span := trace.StartSpan(ctx, fmt.Sprintf("%s/SaveStateOpenings", a.name))
+	defer SetSpanStatusAndEnd(span, err)
+
+	err = store.SaveStateOpenings(ctx, a.s, linkHash, openings)
+	return
+}
+
+// GetStateOpenings instruments the call and delegates to the underlying store.
+// It returns store.ErrStateOpeningsUnavailable if the underlying store
+// can't save state openings.
+func (a *StoreAdapter) GetStateOpenings(ctx context.Context, linkHash *types.Bytes32) (o []*cs.StateOpening, err error) {
+	ctx, span := trace.StartSpan(ctx, fmt.Sprintf("%s/GetStateOpenings", a.name))
+	defer SetSpanStatusAndEnd(span, err)
+
+	o, err = store.GetStateOpenings(ctx, a.s, linkHash)
+	return
+}
+
+// PurgeStateOpenings instruments the call and delegates to the underlying store.
+// It returns store.ErrStateOpeningsUnavailable if the underlying store
+// can't save state openings.
+func (a *StoreAdapter) PurgeStateOpenings(ctx context.Context, linkHash *types.Bytes32) (err error) {
+	ctx, span := trace.StartSpan(ctx, fmt.Sprintf("%s/PurgeStateOpenings", a.name))
+	defer SetSpanStatusAndEnd(span, err)
+
+	err = store.PurgeStateOpenings(ctx, a.s, linkHash)
+	return
+}
+
 // GetMapIDs instruments the call and delegates to the underlying store.
 func (a *StoreAdapter) GetMapIDs(ctx context.Context, filter *store.MapFilter) (mids []string, err error) {
 	ctx, span := trace.StartSpan(ctx, fmt.Sprintf("%s/GetMapIDs", a.name))
@@ -144,6 +217,17 @@ func (a *KeyValueStoreAdapter) SetValue(ctx context.Context, key []byte, value [
 	return
 }
 
+// CreateValue instruments the call and delegates to the underlying store.
+// It returns store.ErrCreateValueUnavailable if the underlying store doesn't
+// implement github.com/stratumn/go-indigocore/store.KeyValueCreator.
+func (a *KeyValueStoreAdapter) CreateValue(ctx context.Context, key []byte, value []byte) (created bool, err error) {
+	ctx, span := trace.StartSpan(ctx, fmt.Sprintf("%s/CreateValue", a.name))
+	defer SetSpanStatusAndEnd(span, err)
+
+	created, err = store.CreateValue(ctx, a.s, key, value)
+	return
+}
+
 // DeleteValue instruments the call and delegates to the underlying store.
 func (a *KeyValueStoreAdapter) DeleteValue(ctx context.Context, key []byte) (v []byte, err error) {
 	ctx, span := trace.StartSpan(ctx, fmt.Sprintf("%s/DeleteValue", a.name))
@@ -0,0 +1,110 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ots
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// AttestationTagSize is the size of an attestation tag.
+const AttestationTagSize = 8
+
+// AttestationTag identifies the kind of an attestation.
+type AttestationTag [AttestationTagSize]byte
+
+// String returns a hex encoded string.
+func (t AttestationTag) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+var (
+	// BitcoinBlockHeaderTag is the tag of a bitcoin block header attestation.
+	BitcoinBlockHeaderTag = AttestationTag{0x05, 0x88, 0x96, 0x0d, 0x73, 0xd7, 0x19, 0x01}
+
+	// PendingTag is the tag of a pending attestation.
+	PendingTag = AttestationTag{0x83, 0xdf, 0xe3, 0x0d, 0x2e, 0xf9, 0x0c, 0x8e}
+)
+
+// Attestation is a claim that a message existed at some point in time.
+// Attestations of unknown kinds are kept as is so they can be written back.
+type Attestation struct {
+	Tag     AttestationTag
+	Payload []byte
+}
+
+// NewBitcoinBlockHeaderAttestation creates an attestation that the message
+// is the merkle root of the bitcoin block at the given height.
+func NewBitcoinBlockHeaderAttestation(height uint64) *Attestation {
+	var buf bytes.Buffer
+	writeVaruint(&buf, height)
+	return &Attestation{Tag: BitcoinBlockHeaderTag, Payload: buf.Bytes()}
+}
+
+// NewPendingAttestation creates an attestation that can be upgraded later
+// by asking a calendar server.
+func NewPendingAttestation(uri string) *Attestation {
+	var buf bytes.Buffer
+	writeVarbytes(&buf, []byte(uri))
+	return &Attestation{Tag: PendingTag, Payload: buf.Bytes()}
+}
+
+// BitcoinHeight returns the block height of a bitcoin block header
+// attestation.
+func (a *Attestation) BitcoinHeight() (uint64, error) {
+	if a.Tag != BitcoinBlockHeaderTag {
+		return 0, errors.Errorf("not a bitcoin attestation: %s", a.Tag)
+	}
+	return readVaruint(bytes.NewReader(a.Payload))
+}
+
+// PendingURI returns the calendar URI of a pending attestation.
+func (a *Attestation) PendingURI() (string, error) {
+	if a.Tag != PendingTag {
+		return "", errors.Errorf("not a pending attestation: %s", a.Tag)
+	}
+	uri, err := readVarbytes(bytes.NewReader(a.Payload), 0, 1000)
+	return string(uri), err
+}
+
+func (a *Attestation) less(other *Attestation) bool {
+	if c := bytes.Compare(a.Tag[:], other.Tag[:]); c != 0 {
+		return c < 0
+	}
+	return bytes.Compare(a.Payload, other.Payload) < 0
+}
+
+func (a *Attestation) serialize(w io.Writer) error {
+	if _, err := w.Write(a.Tag[:]); err != nil {
+		return err
+	}
+	return writeVarbytes(w, a.Payload)
+}
+
+func deserializeAttestation(r io.Reader) (*Attestation, error) {
+	var a Attestation
+	if _, err := io.ReadFull(r, a.Tag[:]); err != nil {
+		return nil, errors.Wrap(err, "could not read attestation tag")
+	}
+	payload, err := readVarbytes(r, 0, MaxPayloadLength)
+	if err != nil {
+		return nil, err
+	}
+	a.Payload = payload
+	return &a, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ots
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+// ErrMsgNotInTx is returned when a bitcoin transaction doesn't contain the
+// message it should commit to.
+var ErrMsgNotInTx = errors.New("message not found in bitcoin transaction")
+
+// BitcoinAnchor contains what is needed to go from a message committed in a
+// bitcoin transaction to the merkle root of the block that contains it.
+type BitcoinAnchor struct {
+	// RawTx is the serialized transaction that contains the message,
+	// usually in an OP_RETURN output.
+	RawTx []byte
+
+	// TxIndex is the position of the transaction in the block.
+	TxIndex int
+
+	// MerkleBranch are the hashes of the siblings of the transaction in
+	// the block's merkle tree, from the bottom up, in internal byte order.
+	MerkleBranch [][]byte
+
+	// BlockHeight is the height of the block.
+	BlockHeight uint64
+}
+
+// Attach adds the operations going from the timestamp's message to the
+// block's merkle root and the matching bitcoin attestation.
+// It returns the transaction ID in internal byte order.
+func (a *BitcoinAnchor) Attach(t *Timestamp) ([]byte, error) {
+	i := bytes.Index(a.RawTx, t.Msg)
+	if i < 0 || len(t.Msg) == 0 {
+		return nil, ErrMsgNotInTx
+	}
+
+	var ops []Op
+	if prefix := a.RawTx[:i]; len(prefix) > 0 {
+		ops = append(ops, OpPrepend(prefix))
+	}
+	if suffix := a.RawTx[i+len(t.Msg):]; len(suffix) > 0 {
+		ops = append(ops, OpAppend(suffix))
+	}
+	ops = append(ops, OpSHA256(), OpSHA256())
+
+	cur, err := addOps(t, ops)
+	if err != nil {
+		return nil, err
+	}
+	txid := cur.Msg
+
+	index := a.TxIndex
+	for _, sibling := range a.MerkleBranch {
+		op := OpAppend(sibling)
+		if index&1 == 1 {
+			op = OpPrepend(sibling)
+		}
+		if cur, err = addOps(cur, []Op{op, OpSHA256(), OpSHA256()}); err != nil {
+			return nil, err
+		}
+		index >>= 1
+	}
+
+	cur.Attest(NewBitcoinBlockHeaderAttestation(a.BlockHeight))
+
+	return txid, nil
+}
+
+func addOps(t *Timestamp, ops []Op) (*Timestamp, error) {
+	var err error
+	for _, op := range ops {
+		if t, err = t.Add(op); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,190 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package evidences defines the OpenTimestamps proof type and converts
+// existing proofs to OpenTimestamps detached timestamp files.
+package evidences
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	bcevidences "github.com/stratumn/go-indigocore/bcbatchfossilizer/evidences"
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/ots"
+	"github.com/stratumn/go-indigocore/types"
+)
+
+var (
+	// OpenTimestampsName is the name used as the OpenTimestampsProof backend.
+	OpenTimestampsName = "ots"
+)
+
+var (
+	// ErrInvalidPath is returned when a merkle path doesn't lead from the
+	// link hash to the merkle root.
+	ErrInvalidPath = errors.New("merkle path doesn't match the link hash")
+
+	// ErrTxIDMismatch is returned when the transaction of a bitcoin anchor
+	// is not the one referenced by the proof.
+	ErrTxIDMismatch = errors.New("bitcoin transaction ID doesn't match the proof")
+)
+
+// OpenTimestampsProof implements the cs.Proof interface.
+// It wraps a serialized OpenTimestamps detached timestamp file so it can be
+// verified with the standard OpenTimestamps tooling.
+type OpenTimestampsProof struct {
+	Timestamp int64  `json:"timestamp"`
+	File      []byte `json:"file"`
+}
+
+// NewOpenTimestampsProof creates a proof from a detached timestamp file.
+func NewOpenTimestampsProof(file *ots.DetachedTimestampFile, timestamp int64) (*OpenTimestampsProof, error) {
+	data, err := file.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return &OpenTimestampsProof{Timestamp: timestamp, File: data}, nil
+}
+
+// DetachedTimestampFile deserializes the wrapped detached timestamp file.
+func (p *OpenTimestampsProof) DetachedTimestampFile() (*ots.DetachedTimestampFile, error) {
+	var f ots.DetachedTimestampFile
+	if err := f.UnmarshalBinary(p.File); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// Time returns the timestamp of the proof.
+func (p *OpenTimestampsProof) Time() uint64 {
+	return uint64(p.Timestamp)
+}
+
+// FullProof returns the JSON formatted proof.
+func (p *OpenTimestampsProof) FullProof() []byte {
+	bytes, err := json.MarshalIndent(p, "", "   ")
+	if err != nil {
+		return nil
+	}
+	return bytes
+}
+
+// Verify returns true if the file timestamps the given link hash and
+// reaches at least one bitcoin attestation. A file that only has pending
+// calendar attestations is not final and doesn't verify.
+// Attestations themselves must be checked against their blockchain, for
+// instance with `ots verify`.
+func (p *OpenTimestampsProof) Verify(linkHash interface{}) bool {
+	lh, ok := linkHash.(*types.Bytes32)
+	if !ok {
+		return false
+	}
+
+	f, err := p.DetachedTimestampFile()
+	if err != nil {
+		return false
+	}
+
+	if f.HashOp.Tag != ots.TagSHA256 || !lh.EqualsBytes(f.Digest()) {
+		return false
+	}
+
+	return isFinal(f)
+}
+
+// IsFinal returns true if the file reaches at least one bitcoin attestation.
+// Files that only have pending calendar attestations must be upgraded, for
+// instance with `ots upgrade`, before they can be verified.
+func (p *OpenTimestampsProof) IsFinal() bool {
+	f, err := p.DetachedTimestampFile()
+	if err != nil {
+		return false
+	}
+	return isFinal(f)
+}
+
+func isFinal(f *ots.DetachedTimestampFile) bool {
+	for _, a := range f.Timestamp.AllAttestations() {
+		if a.Attestation.Tag == ots.BitcoinBlockHeaderTag {
+			return true
+		}
+	}
+	return false
+}
+
+// FromBcBatchProof converts a bitcoin batch proof to a detached timestamp
+// file. The anchor gives the transaction and its position in the block,
+// which are not part of the batch proof.
+func FromBcBatchProof(linkHash *types.Bytes32, proof *bcevidences.BcBatchProof, anchor *ots.BitcoinAnchor) (*ots.DetachedTimestampFile, error) {
+	f := ots.NewDetachedTimestampFile(linkHash[:])
+	cur := f.Timestamp
+
+	for _, node := range proof.Batch.Path {
+		var op ots.Op
+		switch {
+		case bytes.Equal(cur.Msg, node.Left):
+			op = ots.OpAppend(node.Right)
+		case bytes.Equal(cur.Msg, node.Right):
+			op = ots.OpPrepend(node.Left)
+		default:
+			return nil, ErrInvalidPath
+		}
+
+		if len(op.Arg) > 0 {
+			var err error
+			if cur, err = cur.Add(op); err != nil {
+				return nil, err
+			}
+			if cur, err = cur.Add(ots.OpSHA256()); err != nil {
+				return nil, err
+			}
+		}
+
+		if !bytes.Equal(cur.Msg, node.Parent) {
+			return nil, ErrInvalidPath
+		}
+	}
+
+	if proof.Batch.Root == nil || !proof.Batch.Root.EqualsBytes(cur.Msg) {
+		return nil, ErrInvalidPath
+	}
+
+	txid, err := anchor.Attach(cur)
+	if err != nil {
+		return nil, err
+	}
+
+	// Transaction IDs are displayed in reversed byte order.
+	reversed := make([]byte, len(txid))
+	for i, b := range txid {
+		reversed[len(txid)-i-1] = b
+	}
+	if !bytes.Equal(reversed, proof.TransactionID) {
+		return nil, ErrTxIDMismatch
+	}
+
+	return f, nil
+}
+
+func init() {
+	cs.DeserializeMethods[OpenTimestampsName] = func(rawProof json.RawMessage) (cs.Proof, error) {
+		p := OpenTimestampsProof{}
+		if err := json.Unmarshal(rawProof, &p); err != nil {
+			return nil, err
+		}
+		return &p, nil
+	}
+}
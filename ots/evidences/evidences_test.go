@@ -0,0 +1,158 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evidences_test
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	bcevidences "github.com/stratumn/go-indigocore/bcbatchfossilizer/evidences"
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/ots"
+	"github.com/stratumn/go-indigocore/ots/evidences"
+	"github.com/stratumn/go-indigocore/types"
+	mktypes "github.com/stratumn/merkle/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	rawTx     = "0100000001000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f0000000000ffffffff0200000000000000001976a9146465666768696a6b6c6d6e6f707172737475767788ac0000000000000000226a207075152d03a5cd92104887b476862778ec0c87be5c2fa1c0a90f87c49fad6eff00000000"
+	txID      = "25e46fbb928ac971d45304035955da77471074ef30a0a8f7b7d660af258e5d1d"
+	linkHash  = "2e7d2c03a9507ae265ecf5b5356885a53393a2029d241394997265a1a25aefc6"
+	batchRoot = "7075152d03a5cd92104887b476862778ec0c87be5c2fa1c0a90f87c49fad6eff"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+func testBcBatchProof(t *testing.T) *bcevidences.BcBatchProof {
+	var path mktypes.Path
+	data, err := ioutil.ReadFile("../../bcbatchfossilizer/testdata/path-abc-2.json")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &path))
+
+	root, err := types.NewBytes32FromString(batchRoot)
+	require.NoError(t, err)
+
+	p := &bcevidences.BcBatchProof{TransactionID: mustDecodeHex(t, txID)}
+	p.Batch.Timestamp = 1500000000
+	p.Batch.Root = root
+	p.Batch.Path = path
+
+	return p
+}
+
+func testAnchor(t *testing.T) *ots.BitcoinAnchor {
+	return &ots.BitcoinAnchor{
+		RawTx:   mustDecodeHex(t, rawTx),
+		TxIndex: 2,
+		MerkleBranch: [][]byte{
+			mustDecodeHex(t, "1111111111111111111111111111111111111111111111111111111111111111"),
+			mustDecodeHex(t, "2222222222222222222222222222222222222222222222222222222222222222"),
+		},
+		BlockHeight: 500000,
+	}
+}
+
+func TestFromBcBatchProof(t *testing.T) {
+	lh, _ := types.NewBytes32FromString(linkHash)
+	want, err := ioutil.ReadFile("../testdata/bcbatch.ots")
+	require.NoError(t, err)
+
+	f, err := evidences.FromBcBatchProof(lh, testBcBatchProof(t), testAnchor(t))
+	require.NoError(t, err, "evidences.FromBcBatchProof()")
+
+	got, err := f.MarshalBinary()
+	require.NoError(t, err, "f.MarshalBinary()")
+	assert.Equal(t, want, got)
+
+	attestations := f.Timestamp.AllAttestations()
+	require.Len(t, attestations, 1)
+	assert.Equal(t, "0380db189fe4a6e626848065907b2e2dd015fa0901fdc07ace16e59457a67e59", hex.EncodeToString(attestations[0].Msg))
+}
+
+func TestFromBcBatchProof_Errors(t *testing.T) {
+	lh, _ := types.NewBytes32FromString(linkHash)
+
+	t.Run("invalid path", func(t *testing.T) {
+		_, err := evidences.FromBcBatchProof(&types.Bytes32{}, testBcBatchProof(t), testAnchor(t))
+		assert.EqualError(t, err, evidences.ErrInvalidPath.Error())
+	})
+
+	t.Run("missing root in transaction", func(t *testing.T) {
+		anchor := testAnchor(t)
+		anchor.RawTx = anchor.RawTx[:40]
+		_, err := evidences.FromBcBatchProof(lh, testBcBatchProof(t), anchor)
+		assert.EqualError(t, err, ots.ErrMsgNotInTx.Error())
+	})
+
+	t.Run("transaction ID mismatch", func(t *testing.T) {
+		p := testBcBatchProof(t)
+		p.TransactionID = make([]byte, 32)
+		_, err := evidences.FromBcBatchProof(lh, p, testAnchor(t))
+		assert.EqualError(t, err, evidences.ErrTxIDMismatch.Error())
+	})
+}
+
+func TestOpenTimestampsProof(t *testing.T) {
+	lh, _ := types.NewBytes32FromString(linkHash)
+	f, err := ots.ReadFile("../testdata/bcbatch.ots")
+	require.NoError(t, err, "ots.ReadFile()")
+
+	p, err := evidences.NewOpenTimestampsProof(f, 1500000000)
+	require.NoError(t, err, "evidences.NewOpenTimestampsProof()")
+
+	assert.EqualValues(t, 1500000000, p.Time())
+	assert.True(t, p.Verify(lh))
+	assert.False(t, p.Verify(&types.Bytes32{}))
+	assert.False(t, p.Verify(linkHash))
+
+	e := cs.Evidence{Backend: evidences.OpenTimestampsName, Provider: "bitcoin:main", Proof: p}
+	js, err := json.Marshal(&e)
+	require.NoError(t, err)
+
+	var got cs.Evidence
+	require.NoError(t, json.Unmarshal(js, &got))
+	assert.Equal(t, p, got.Proof)
+	assert.True(t, got.Proof.Verify(lh))
+}
+
+func TestOpenTimestampsProof_pending(t *testing.T) {
+	lh, _ := types.NewBytes32FromString(linkHash)
+
+	f := ots.NewDetachedTimestampFile(lh[:])
+	sha, err := f.Timestamp.Add(ots.OpSHA256())
+	require.NoError(t, err)
+	sha.Attest(ots.NewPendingAttestation("https://alice.btc.calendar.opentimestamps.org"))
+
+	p, err := evidences.NewOpenTimestampsProof(f, 1500000000)
+	require.NoError(t, err, "evidences.NewOpenTimestampsProof()")
+
+	assert.False(t, p.IsFinal())
+	assert.False(t, p.Verify(lh), "pending attestations only")
+
+	sha.Attest(ots.NewBitcoinBlockHeaderAttestation(500000))
+	p, err = evidences.NewOpenTimestampsProof(f, 1500000000)
+	require.NoError(t, err, "evidences.NewOpenTimestampsProof()")
+
+	assert.True(t, p.IsFinal())
+	assert.True(t, p.Verify(lh))
+}
@@ -0,0 +1,159 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ots
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ripemd160"
+)
+
+// Operation tags.
+const (
+	TagSHA1      byte = 0x02
+	TagRIPEMD160 byte = 0x03
+	TagSHA256    byte = 0x08
+	TagAppend    byte = 0xf0
+	TagPrepend   byte = 0xf1
+	TagReverse   byte = 0xf2
+	TagHexlify   byte = 0xf3
+)
+
+// Op is an operation that transforms a message into a commitment.
+// Arg is only used by the append and prepend operations.
+type Op struct {
+	Tag byte
+	Arg []byte
+}
+
+// OpSHA1 returns an operation hashing the message with SHA1.
+func OpSHA1() Op { return Op{Tag: TagSHA1} }
+
+// OpRIPEMD160 returns an operation hashing the message with RIPEMD160.
+func OpRIPEMD160() Op { return Op{Tag: TagRIPEMD160} }
+
+// OpSHA256 returns an operation hashing the message with SHA256.
+func OpSHA256() Op { return Op{Tag: TagSHA256} }
+
+// OpAppend returns an operation appending arg to the message.
+func OpAppend(arg []byte) Op { return Op{Tag: TagAppend, Arg: arg} }
+
+// OpPrepend returns an operation prepending arg to the message.
+func OpPrepend(arg []byte) Op { return Op{Tag: TagPrepend, Arg: arg} }
+
+// OpReverse returns an operation reversing the message.
+func OpReverse() Op { return Op{Tag: TagReverse} }
+
+// OpHexlify returns an operation hex encoding the message.
+func OpHexlify() Op { return Op{Tag: TagHexlify} }
+
+// Apply applies the operation to a message.
+func (op Op) Apply(msg []byte) ([]byte, error) {
+	if len(msg) > MaxMsgLength {
+		return nil, ErrTooLong
+	}
+
+	var res []byte
+
+	switch op.Tag {
+	case TagSHA1:
+		h := sha1.Sum(msg)
+		res = h[:]
+	case TagRIPEMD160:
+		h := ripemd160.New()
+		h.Write(msg)
+		res = h.Sum(nil)
+	case TagSHA256:
+		h := sha256.Sum256(msg)
+		res = h[:]
+	case TagAppend:
+		res = append(append(make([]byte, 0, len(msg)+len(op.Arg)), msg...), op.Arg...)
+	case TagPrepend:
+		res = append(append(make([]byte, 0, len(msg)+len(op.Arg)), op.Arg...), msg...)
+	case TagReverse:
+		res = make([]byte, len(msg))
+		for i, b := range msg {
+			res[len(msg)-i-1] = b
+		}
+	case TagHexlify:
+		res = []byte(hex.EncodeToString(msg))
+	default:
+		return nil, ErrUnknownOp
+	}
+
+	if len(res) > MaxMsgLength {
+		return nil, ErrTooLong
+	}
+
+	return res, nil
+}
+
+// Equals returns true if both operations are identical.
+func (op Op) Equals(other Op) bool {
+	return op.Tag == other.Tag && bytes.Equal(op.Arg, other.Arg)
+}
+
+// less orders operations the same way the reference implementation does,
+// which keeps serialization deterministic.
+func (op Op) less(other Op) bool {
+	if op.Tag != other.Tag {
+		return op.Tag < other.Tag
+	}
+	return bytes.Compare(op.Arg, other.Arg) < 0
+}
+
+func (op Op) hasArg() bool {
+	return op.Tag == TagAppend || op.Tag == TagPrepend
+}
+
+// digestSize returns the digest size of a hash operation, or zero.
+func (op Op) digestSize() int {
+	switch op.Tag {
+	case TagSHA1, TagRIPEMD160:
+		return 20
+	case TagSHA256:
+		return 32
+	}
+	return 0
+}
+
+func (op Op) serialize(w io.Writer) error {
+	if _, err := w.Write([]byte{op.Tag}); err != nil {
+		return err
+	}
+	if op.hasArg() {
+		return writeVarbytes(w, op.Arg)
+	}
+	return nil
+}
+
+func deserializeOp(r io.Reader, tag byte) (Op, error) {
+	switch tag {
+	case TagSHA1, TagRIPEMD160, TagSHA256, TagReverse, TagHexlify:
+		return Op{Tag: tag}, nil
+	case TagAppend, TagPrepend:
+		arg, err := readVarbytes(r, 1, MaxMsgLength)
+		if err != nil {
+			return Op{}, err
+		}
+		return Op{Tag: tag, Arg: arg}, nil
+	}
+	return Op{}, errors.Wrapf(ErrUnknownOp, "tag %#x", tag)
+}
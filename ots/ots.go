@@ -0,0 +1,192 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ots implements the OpenTimestamps proof format.
+// It can read and write detached timestamp files (.ots) that can be
+// verified by the standard OpenTimestamps tooling.
+package ots
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// MajorVersion is the version of the detached timestamp file format.
+	MajorVersion = 1
+
+	// MaxMsgLength is the maximum length of a message or operation result.
+	MaxMsgLength = 4096
+
+	// MaxPayloadLength is the maximum length of an attestation payload.
+	MaxPayloadLength = 8192
+
+	// MaxRecursion is the maximum depth of a timestamp tree.
+	MaxRecursion = 256
+)
+
+// HeaderMagic is the magic prefix of every detached timestamp file.
+var HeaderMagic = []byte("\x00OpenTimestamps\x00\x00Proof\x00\xbf\x89\xe2\xe8\x84\xe8\x92\x94")
+
+var (
+	// ErrBadMagic is returned when a file doesn't start with HeaderMagic.
+	ErrBadMagic = errors.New("not an OpenTimestamps proof file")
+
+	// ErrUnsupportedVersion is returned when a file uses an unknown major
+	// version.
+	ErrUnsupportedVersion = errors.New("unsupported OpenTimestamps major version")
+
+	// ErrUnknownOp is returned when an unknown operation tag is read.
+	ErrUnknownOp = errors.New("unknown OpenTimestamps operation")
+
+	// ErrNotCryptOp is returned when the file hash operation is not a
+	// cryptographic hash function.
+	ErrNotCryptOp = errors.New("file hash operation must be a hash function")
+
+	// ErrTooLong is returned when a message or an argument exceeds the
+	// maximum allowed length.
+	ErrTooLong = errors.New("value exceeds maximum length")
+
+	// ErrRecursionLimit is returned when a timestamp is nested too deeply.
+	ErrRecursionLimit = errors.New("timestamp recursion limit reached")
+
+	// ErrEmptyTimestamp is returned when serializing a timestamp that has
+	// neither operations nor attestations.
+	ErrEmptyTimestamp = errors.New("timestamp has no operations and no attestations")
+
+	// ErrTrailingData is returned when bytes remain after a file.
+	ErrTrailingData = errors.New("trailing data after timestamp")
+)
+
+// DetachedTimestampFile is a timestamp of a file's digest.
+type DetachedTimestampFile struct {
+	// HashOp is the hash function used to compute the file digest.
+	HashOp Op
+
+	// Timestamp's message is the file digest.
+	Timestamp *Timestamp
+}
+
+// NewDetachedTimestampFile creates a detached timestamp for a SHA256 digest.
+func NewDetachedTimestampFile(digest []byte) *DetachedTimestampFile {
+	return &DetachedTimestampFile{
+		HashOp:    OpSHA256(),
+		Timestamp: NewTimestamp(digest),
+	}
+}
+
+// Digest returns the digest of the timestamped file.
+func (f *DetachedTimestampFile) Digest() []byte {
+	return f.Timestamp.Msg
+}
+
+// Serialize writes the detached timestamp file.
+func (f *DetachedTimestampFile) Serialize(w io.Writer) error {
+	if _, err := w.Write(HeaderMagic); err != nil {
+		return err
+	}
+	if err := writeVaruint(w, MajorVersion); err != nil {
+		return err
+	}
+	if err := f.HashOp.serialize(w); err != nil {
+		return err
+	}
+	if _, err := w.Write(f.Timestamp.Msg); err != nil {
+		return err
+	}
+	return f.Timestamp.serialize(w)
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (f *DetachedTimestampFile) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := f.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (f *DetachedTimestampFile) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	file, err := DeserializeDetachedTimestampFile(r)
+	if err != nil {
+		return err
+	}
+	if r.Len() > 0 {
+		return ErrTrailingData
+	}
+	*f = *file
+	return nil
+}
+
+// DeserializeDetachedTimestampFile reads a detached timestamp file.
+func DeserializeDetachedTimestampFile(r io.Reader) (*DetachedTimestampFile, error) {
+	magic := make([]byte, len(HeaderMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, errors.Wrap(err, "could not read header")
+	}
+	if !bytes.Equal(magic, HeaderMagic) {
+		return nil, ErrBadMagic
+	}
+
+	version, err := readVaruint(r)
+	if err != nil {
+		return nil, err
+	}
+	if version != MajorVersion {
+		return nil, ErrUnsupportedVersion
+	}
+
+	tag, err := readByte(r)
+	if err != nil {
+		return nil, err
+	}
+	op, err := deserializeOp(r, tag)
+	if err != nil {
+		return nil, err
+	}
+	size := op.digestSize()
+	if size == 0 {
+		return nil, ErrNotCryptOp
+	}
+
+	digest := make([]byte, size)
+	if _, err := io.ReadFull(r, digest); err != nil {
+		return nil, errors.Wrap(err, "could not read file digest")
+	}
+
+	timestamp, err := deserializeTimestamp(r, digest, MaxRecursion)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DetachedTimestampFile{HashOp: op, Timestamp: timestamp}, nil
+}
+
+// ReadFile reads a detached timestamp file from disk.
+func ReadFile(path string) (*DetachedTimestampFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f DetachedTimestampFile
+	if err := f.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
@@ -0,0 +1,192 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ots_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stratumn/go-indigocore/ots"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// specFile is a detached timestamp of "Hello World!\n" written byte by byte
+// following the OpenTimestamps serialization format, independently of this
+// package's encoder.
+var specFile = strings.Join([]string{
+	// Header magic, major version 1, SHA256 file hash operation and digest.
+	"004f70656e54696d657374616d7073000050726f6f6600bf89e2e884e89294",
+	"01",
+	"08",
+	"03ba204e50d126e4674c005e04d82e84c21366780af1f43bd54a37816b6ab340",
+	// Prepend 32 bytes, then SHA256.
+	"f120", "57cfa5c46716df9bd9e83595bce439c58108d8fcc1678f30d4c6731c3f1fa6c7",
+	"08",
+	// Fork: pending attestation whose payload is the varbytes calendar URI.
+	"ff", "00", "83dfe30d2ef90c8e", "2e", "2d",
+	"68747470733a2f2f616c6963652e6274632e63616c656e6461722e6f70656e74696d657374616d70732e6f7267",
+	// Append 16 bytes, then SHA256.
+	"f010", "6fec8b85a16f1d7b5d5e8d4bd3a9a3d9",
+	"08",
+	// Bitcoin attestation whose payload is the varuint block height 358391.
+	"00", "0588960d73d71901", "03", "f7ef15",
+}, "")
+
+func TestDetachedTimestampFile_Spec(t *testing.T) {
+	data, err := hex.DecodeString(specFile)
+	require.NoError(t, err)
+
+	var f ots.DetachedTimestampFile
+	require.NoError(t, f.UnmarshalBinary(data), "f.UnmarshalBinary()")
+
+	assert.Equal(t, ots.TagSHA256, f.HashOp.Tag)
+	assert.Equal(t, "03ba204e50d126e4674c005e04d82e84c21366780af1f43bd54a37816b6ab340", hex.EncodeToString(f.Digest()))
+
+	attestations := f.Timestamp.AllAttestations()
+	require.Len(t, attestations, 2)
+	for _, a := range attestations {
+		switch a.Attestation.Tag {
+		case ots.PendingTag:
+			uri, err := a.Attestation.PendingURI()
+			require.NoError(t, err)
+			assert.Equal(t, "https://alice.btc.calendar.opentimestamps.org", uri)
+			assert.Equal(t, "e10c7dc58d350ab41efcb56bc318a33dc90a2e795230b63729257eab9517238f", hex.EncodeToString(a.Msg))
+		case ots.BitcoinBlockHeaderTag:
+			height, err := a.Attestation.BitcoinHeight()
+			require.NoError(t, err)
+			assert.EqualValues(t, 358391, height)
+			assert.Equal(t, "6ef26f60dae380478168693139c54e6b7d11eb37fd1b9a492b71ea9f452277ac", hex.EncodeToString(a.Msg))
+		default:
+			t.Errorf("unexpected attestation %s", a.Attestation.Tag)
+		}
+	}
+
+	got, err := f.MarshalBinary()
+	require.NoError(t, err, "f.MarshalBinary()")
+	assert.Equal(t, data, got)
+}
+
+func TestDetachedTimestampFile_RoundTrip(t *testing.T) {
+	for _, name := range []string{"bcbatch.ots", "fork.ots"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := ioutil.ReadFile("testdata/" + name)
+			require.NoError(t, err)
+
+			f, err := ots.ReadFile("testdata/" + name)
+			require.NoError(t, err, "ots.ReadFile()")
+
+			got, err := f.MarshalBinary()
+			require.NoError(t, err, "f.MarshalBinary()")
+			assert.Equal(t, data, got)
+		})
+	}
+}
+
+func TestDetachedTimestampFile_Fork(t *testing.T) {
+	f, err := ots.ReadFile("testdata/fork.ots")
+	require.NoError(t, err, "ots.ReadFile()")
+
+	assert.Equal(t, "d46632093b193f0ddc4c28f5ca13fb2b326a3d9a69d70a577020547bc613cbbc", hex.EncodeToString(f.Digest()))
+	require.Len(t, f.Timestamp.Branches, 2)
+
+	attestations := f.Timestamp.AllAttestations()
+	require.Len(t, attestations, 3)
+
+	var uris []string
+	var heights []uint64
+	for _, a := range attestations {
+		switch a.Attestation.Tag {
+		case ots.PendingTag:
+			uri, err := a.Attestation.PendingURI()
+			require.NoError(t, err)
+			uris = append(uris, uri)
+		case ots.BitcoinBlockHeaderTag:
+			height, err := a.Attestation.BitcoinHeight()
+			require.NoError(t, err)
+			heights = append(heights, height)
+		}
+	}
+
+	assert.Equal(t, []uint64{358391}, heights)
+	assert.ElementsMatch(t, []string{
+		"https://alice.btc.calendar.opentimestamps.org",
+		"https://bob.btc.calendar.opentimestamps.org",
+	}, uris)
+}
+
+func TestDetachedTimestampFile_Build(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/fork.ots")
+	require.NoError(t, err)
+	digest, _ := hex.DecodeString("d46632093b193f0ddc4c28f5ca13fb2b326a3d9a69d70a577020547bc613cbbc")
+
+	f := ots.NewDetachedTimestampFile(digest)
+
+	// Insert branches in reverse order to check serialization is canonical.
+	prepended, err := f.Timestamp.Add(ots.OpPrepend([]byte{0x01, 0x02}))
+	require.NoError(t, err)
+	bob, err := prepended.Add(ots.OpSHA256())
+	require.NoError(t, err)
+	bob.Attest(ots.NewPendingAttestation("https://bob.btc.calendar.opentimestamps.org"))
+	bob.Attest(ots.NewBitcoinBlockHeaderAttestation(358391))
+
+	appended, err := f.Timestamp.Add(ots.OpAppend([]byte{0xaa, 0xbb, 0xcc, 0xdd}))
+	require.NoError(t, err)
+	alice, err := appended.Add(ots.OpSHA256())
+	require.NoError(t, err)
+	alice.Attest(ots.NewPendingAttestation("https://alice.btc.calendar.opentimestamps.org"))
+
+	got, err := f.MarshalBinary()
+	require.NoError(t, err, "f.MarshalBinary()")
+	assert.Equal(t, data, got)
+}
+
+func TestDetachedTimestampFile_Errors(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/fork.ots")
+	require.NoError(t, err)
+
+	t.Run("bad magic", func(t *testing.T) {
+		bad := append([]byte{}, data...)
+		bad[1] = 'o'
+		var f ots.DetachedTimestampFile
+		assert.EqualError(t, f.UnmarshalBinary(bad), ots.ErrBadMagic.Error())
+	})
+
+	t.Run("bad version", func(t *testing.T) {
+		bad := append([]byte{}, data...)
+		bad[len(ots.HeaderMagic)] = 2
+		var f ots.DetachedTimestampFile
+		assert.EqualError(t, f.UnmarshalBinary(bad), ots.ErrUnsupportedVersion.Error())
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		var f ots.DetachedTimestampFile
+		assert.Error(t, f.UnmarshalBinary(data[:len(data)-4]))
+	})
+
+	t.Run("trailing data", func(t *testing.T) {
+		var f ots.DetachedTimestampFile
+		assert.EqualError(t, f.UnmarshalBinary(append(data, 0)), ots.ErrTrailingData.Error())
+	})
+
+	t.Run("empty timestamp", func(t *testing.T) {
+		f := ots.NewDetachedTimestampFile(bytes.Repeat([]byte{1}, 32))
+		_, err := f.MarshalBinary()
+		assert.EqualError(t, err, ots.ErrEmptyTimestamp.Error())
+	})
+}
@@ -0,0 +1,198 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ots
+
+import (
+	"bytes"
+	"io"
+	"sort"
+)
+
+const (
+	tagAttestation byte = 0x00
+	tagFork        byte = 0xff
+)
+
+// Timestamp proves that a message existed at some point in time.
+// Each branch applies an operation to the message, yielding the message of
+// a child timestamp, until attestations are reached.
+type Timestamp struct {
+	Msg          []byte
+	Attestations []*Attestation
+	Branches     []*Branch
+}
+
+// Branch links a timestamp to the result of an operation on its message.
+type Branch struct {
+	Op        Op
+	Timestamp *Timestamp
+}
+
+// NewTimestamp creates a timestamp for a message.
+func NewTimestamp(msg []byte) *Timestamp {
+	return &Timestamp{Msg: msg}
+}
+
+// Add applies an operation to the message and returns the resulting
+// timestamp. If the operation already exists, the existing timestamp is
+// returned.
+func (t *Timestamp) Add(op Op) (*Timestamp, error) {
+	for _, b := range t.Branches {
+		if b.Op.Equals(op) {
+			return b.Timestamp, nil
+		}
+	}
+
+	msg, err := op.Apply(t.Msg)
+	if err != nil {
+		return nil, err
+	}
+
+	child := NewTimestamp(msg)
+	t.Branches = append(t.Branches, &Branch{Op: op, Timestamp: child})
+
+	return child, nil
+}
+
+// Attest adds an attestation to the timestamp.
+func (t *Timestamp) Attest(a *Attestation) {
+	for _, existing := range t.Attestations {
+		if existing.Tag == a.Tag && bytes.Equal(existing.Payload, a.Payload) {
+			return
+		}
+	}
+	t.Attestations = append(t.Attestations, a)
+}
+
+// AllAttestations returns all the attestations of the timestamp tree along
+// with the message they attest.
+func (t *Timestamp) AllAttestations() []*AttestedMsg {
+	var res []*AttestedMsg
+	for _, a := range t.Attestations {
+		res = append(res, &AttestedMsg{Msg: t.Msg, Attestation: a})
+	}
+	for _, b := range t.Branches {
+		res = append(res, b.Timestamp.AllAttestations()...)
+	}
+	return res
+}
+
+// AttestedMsg is a message and an attestation about it.
+type AttestedMsg struct {
+	Msg         []byte
+	Attestation *Attestation
+}
+
+func (t *Timestamp) serialize(w io.Writer) error {
+	if len(t.Attestations) == 0 && len(t.Branches) == 0 {
+		return ErrEmptyTimestamp
+	}
+
+	attestations := append([]*Attestation(nil), t.Attestations...)
+	sort.Slice(attestations, func(i, j int) bool { return attestations[i].less(attestations[j]) })
+
+	branches := append([]*Branch(nil), t.Branches...)
+	sort.Slice(branches, func(i, j int) bool { return branches[i].Op.less(branches[j].Op) })
+
+	// Every item but the last one is prefixed with a fork marker.
+	for i, a := range attestations {
+		if i < len(attestations)-1 || len(branches) > 0 {
+			if _, err := w.Write([]byte{tagFork}); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write([]byte{tagAttestation}); err != nil {
+			return err
+		}
+		if err := a.serialize(w); err != nil {
+			return err
+		}
+	}
+
+	for i, b := range branches {
+		if i < len(branches)-1 {
+			if _, err := w.Write([]byte{tagFork}); err != nil {
+				return err
+			}
+		}
+		if err := b.Op.serialize(w); err != nil {
+			return err
+		}
+		if err := b.Timestamp.serialize(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func deserializeTimestamp(r io.Reader, msg []byte, limit int) (*Timestamp, error) {
+	if limit <= 0 {
+		return nil, ErrRecursionLimit
+	}
+
+	t := NewTimestamp(msg)
+
+	tag, err := readByte(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for tag == tagFork {
+		current, err := readByte(r)
+		if err != nil {
+			return nil, err
+		}
+		if err := t.deserializeItem(r, current, limit); err != nil {
+			return nil, err
+		}
+		if tag, err = readByte(r); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := t.deserializeItem(r, tag, limit); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *Timestamp) deserializeItem(r io.Reader, tag byte, limit int) error {
+	if tag == tagAttestation {
+		a, err := deserializeAttestation(r)
+		if err != nil {
+			return err
+		}
+		t.Attestations = append(t.Attestations, a)
+		return nil
+	}
+
+	op, err := deserializeOp(r, tag)
+	if err != nil {
+		return err
+	}
+	res, err := op.Apply(t.Msg)
+	if err != nil {
+		return err
+	}
+	child, err := deserializeTimestamp(r, res, limit-1)
+	if err != nil {
+		return err
+	}
+	t.Branches = append(t.Branches, &Branch{Op: op, Timestamp: child})
+
+	return nil
+}
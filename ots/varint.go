@@ -0,0 +1,87 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ots
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+func writeVaruint(w io.Writer, v uint64) error {
+	var buf []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if v == 0 {
+			break
+		}
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+func writeVarbytes(w io.Writer, b []byte) error {
+	if err := writeVaruint(w, uint64(len(b))); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func readByte(r io.Reader) (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, errors.Wrap(err, "unexpected end of timestamp")
+	}
+	return b[0], nil
+}
+
+func readVaruint(r io.Reader) (uint64, error) {
+	var v uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b, err := readByte(r)
+		if err != nil {
+			return 0, err
+		}
+		v |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, errors.New("varuint overflows 64 bits")
+}
+
+func readVarbytes(r io.Reader, min, max int) ([]byte, error) {
+	l, err := readVaruint(r)
+	if err != nil {
+		return nil, err
+	}
+	if l > uint64(max) {
+		return nil, ErrTooLong
+	}
+	if l < uint64(min) {
+		return nil, errors.Errorf("varbytes length %d is below minimum %d", l, min)
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, errors.Wrap(err, "unexpected end of timestamp")
+	}
+	return b, nil
+}
@@ -18,7 +18,9 @@ import (
 	"context"
 	"database/sql"
 
+	"github.com/stratumn/go-indigocore/cs"
 	"github.com/stratumn/go-indigocore/monitoring"
+	"github.com/stratumn/go-indigocore/types"
 	"go.opencensus.io/trace"
 )
 
@@ -27,6 +29,7 @@ type Batch struct {
 	*reader
 	*writer
 	done bool
+	err  error // first error that happened in the batch
 	tx   *sql.Tx
 }
 
@@ -44,11 +47,32 @@ func NewBatch(tx *sql.Tx) (*Batch, error) {
 	}, nil
 }
 
+// CreateLink implements github.com/stratumn/go-indigocore/store.LinkWriter.CreateLink.
+// If the link can't be created, the transaction will be rolled back when
+// the batch is written.
+func (b *Batch) CreateLink(ctx context.Context, link *cs.Link) (*types.Bytes32, error) {
+	linkHash, err := b.writer.CreateLink(ctx, link)
+	if err != nil && b.err == nil {
+		b.err = err
+	}
+
+	return linkHash, err
+}
+
 // Write implements github.com/stratumn/go-indigocore/store.Batch.Write.
+// The transaction is rolled back if one of the links couldn't be created,
+// so that either all the links are saved, or none is.
 func (b *Batch) Write(ctx context.Context) (err error) {
 	ctx, span := trace.StartSpan(ctx, "postgresstore/batch/Write")
 	defer monitoring.SetSpanStatusAndEnd(span, err)
 
 	b.done = true
+	if b.err != nil {
+		if err = b.tx.Rollback(); err != nil {
+			return
+		}
+		return b.err
+	}
+
 	return b.tx.Commit()
 }
@@ -18,6 +18,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
@@ -33,9 +34,13 @@ const (
 )
 
 var (
-	create bool
-	drop   bool
-	url    string
+	create         bool
+	drop           bool
+	migrate        bool
+	migrateTo      int
+	url            string
+	replicaURLs    string
+	readYourWrites bool
 )
 
 // Initialize initializes a postgres store adapter
@@ -84,20 +89,82 @@ func Initialize(config *Config, create, drop bool) *Store {
 	if err != nil {
 		log.WithField("max", connectAttempts).Fatal("Unable to connect to PostgreSQL")
 	}
+
+	if version, err := a.SchemaVersion(); err != nil {
+		log.WithField("error", err).Warn("Failed to get PostgreSQL schema version")
+	} else if latest := LatestSchemaVersion(); version < latest {
+		log.WithFields(log.Fields{
+			"version": version,
+			"latest":  latest,
+		}).Warn("PostgreSQL schema is out of date, run with -migrate to update it")
+	}
+
 	return a
 }
 
+// RunMigrations applies the schema migrations up to the given version, or up
+// to the latest version if it is zero, then exits.
+func RunMigrations(config *Config, to int) {
+	a, err := New(config)
+	if err != nil {
+		log.WithField("error", err).Fatal("Failed to create PostgreSQL store")
+	}
+
+	versions, err := a.Migrate(to)
+	if err != nil {
+		log.WithField("error", err).Fatal("Failed to migrate PostgreSQL schema")
+	}
+	for _, v := range versions {
+		log.WithField("version", v).Info("Applied schema migration")
+	}
+
+	version, err := a.SchemaVersion()
+	if err != nil {
+		log.WithField("error", err).Fatal("Failed to get PostgreSQL schema version")
+	}
+	log.WithField("version", version).Info("Migrated schema")
+	os.Exit(0)
+}
+
 // RegisterFlags registers the flags used by InitializeWithFlags.
 func RegisterFlags() {
 	flag.BoolVar(&create, "create", false, "create tables and indexes then exit")
 	flag.BoolVar(&drop, "drop", false, "drop tables and indexes then exit")
+	flag.BoolVar(&migrate, "migrate", false, "apply pending schema migrations then exit")
+	flag.IntVar(&migrateTo, "migrate-to", 0, "apply schema migrations up to the given version then exit")
 	flag.StringVar(&url, "url", utils.OrStrings(os.Getenv("POSTGRESSTORE_URL"), DefaultURL), "URL of the PostgreSQL database")
+	flag.StringVar(&replicaURLs, "replica_urls", os.Getenv("POSTGRESSTORE_REPLICA_URLS"), "comma-separated URLs of PostgreSQL read replicas")
+	flag.BoolVar(&readYourWrites, "read_your_writes", true, "read from the primary what a client has written when using read replicas, in requests sharing an X-Indigo-Session header")
 }
 
 // InitializeWithFlags should be called after RegisterFlags and flag.Parse to initialize
 // a postgres adapter using flag values.
 func InitializeWithFlags(version, commit string) *Store {
 	config := &Config{URL: url, Version: version, Commit: commit}
+	if migrate || migrateTo > 0 {
+		RunMigrations(config, migrateTo)
+	}
 	return Initialize(config, create, drop)
+}
+
+// InitializeReplicasWithFlags should be called after RegisterFlags and
+// flag.Parse to initialize postgres adapters for the read replicas given by
+// flag values.
+func InitializeReplicasWithFlags(version, commit string) []*Store {
+	var replicas []*Store
+	for _, u := range strings.Split(replicaURLs, ",") {
+		if u = strings.TrimSpace(u); u == "" {
+			continue
+		}
+		config := &Config{URL: u, Version: version, Commit: commit}
+		replicas = append(replicas, Initialize(config, false, false))
+	}
+	return replicas
+}
 
+// ReadYourWritesFromFlags should be called after RegisterFlags and
+// flag.Parse. It tells whether the reads of a client should see its writes
+// when using read replicas.
+func ReadYourWritesFromFlags() bool {
+	return readYourWrites
 }
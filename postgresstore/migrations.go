@@ -0,0 +1,480 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgresstore
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+const (
+	sqlCreateSchemaMigrations = `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version integer PRIMARY KEY,
+			description text NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)
+	`
+	sqlGetSchemaVersions = `
+		SELECT version FROM schema_migrations
+		ORDER BY version
+	`
+	sqlAddSchemaMigration = `
+		INSERT INTO schema_migrations (
+			version,
+			description
+		)
+		VALUES ($1, $2)
+		ON CONFLICT (version)
+		DO NOTHING
+	`
+	sqlLockMigrations   = `SELECT pg_advisory_lock($1)`
+	sqlUnlockMigrations = `SELECT pg_advisory_unlock($1)`
+)
+
+// migrationsLockID is the key of the advisory lock held while migrating.
+const migrationsLockID int64 = 0x696e6469676f // "indigo"
+
+var (
+	// ErrInvalidMigrations is returned when migration versions are not
+	// positive and strictly increasing.
+	ErrInvalidMigrations = errors.New("migration versions must be positive and strictly increasing")
+
+	// ErrUnknownVersion is returned when migrating to a version that does
+	// not exist.
+	ErrUnknownVersion = errors.New("unknown schema version")
+
+	// ErrDowngrade is returned when migrating to a version older than the
+	// current schema version.
+	ErrDowngrade = errors.New("schema downgrades are not supported")
+)
+
+// Migration is a versioned change to the database schema.
+type Migration struct {
+	// Version is the schema version after the migration is applied.
+	Version int
+
+	// Description is a short description stored with the version.
+	Description string
+
+	// Statements are the SQL statements applied in a single transaction.
+	// They should be idempotent because databases created before migrations
+	// were introduced may already contain some of the changes.
+	Statements []string
+
+	// NoTransaction runs the statements one by one outside of a
+	// transaction, which some statements such as CREATE INDEX CONCURRENTLY
+	// require. The version is recorded once all the statements succeed, so
+	// the statements of a failed or concurrent migration may run again.
+	NoTransaction bool
+}
+
+// migrations are the migrations of the store's schema. New migrations must
+// be appended with the next version; released migrations must never change,
+// so their statements are written inline rather than shared with the code.
+// Indexes on existing tables should be created concurrently in migrations
+// without a transaction so that writes aren't blocked during the build.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create links, evidences and values tables",
+		Statements: []string{
+			`
+				CREATE TABLE IF NOT EXISTS links (
+					id BIGSERIAL PRIMARY KEY,
+					link_hash bytea NOT NULL,
+					priority double precision NOT NULL,
+					map_id text NOT NULL,
+					prev_link_hash bytea DEFAULT NULL,
+					tags text[] DEFAULT NULL,
+					data jsonb NOT NULL,
+					process text NOT NULL,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+				)
+			`,
+			`
+				CREATE UNIQUE INDEX IF NOT EXISTS links_link_hash_idx
+				ON links (link_hash)
+			`,
+			`
+				CREATE INDEX IF NOT EXISTS links_priority_created_at_idx
+				ON links (priority DESC, created_at DESC)
+			`,
+			`
+				CREATE INDEX IF NOT EXISTS links_map_id_idx
+				ON links (map_id)
+			`,
+			`
+				CREATE INDEX IF NOT EXISTS links_map_id_priority_created_at_idx
+				ON links (map_id, priority DESC, created_at DESC)
+			`,
+			`
+				CREATE INDEX IF NOT EXISTS links_prev_link_hash_priority_created_at_idx
+				ON links (prev_link_hash, priority DESC, created_at DESC)
+			`,
+			`
+				CREATE INDEX IF NOT EXISTS links_tags_idx
+				ON links USING gin(tags)
+			`,
+			`
+				CREATE TABLE IF NOT EXISTS evidences (
+					id BIGSERIAL PRIMARY KEY,
+					link_hash bytea NOT NULL,
+					provider text NOT NULL,
+					data jsonb NOT NULL,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+				)
+			`,
+			`
+				CREATE UNIQUE INDEX IF NOT EXISTS evidences_link_hash_provider_idx
+				ON evidences (link_hash, provider)
+			`,
+			`
+				CREATE INDEX IF NOT EXISTS evidences_link_hash_idx
+				ON evidences (link_hash)
+			`,
+			`
+				CREATE TABLE IF NOT EXISTS values (
+					id BIGSERIAL PRIMARY KEY,
+					key bytea NOT NULL,
+					value bytea NOT NULL,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+				)
+			`,
+			`
+				CREATE UNIQUE INDEX IF NOT EXISTS values_key_idx
+				ON values (key)
+			`,
+		},
+	},
+	{
+		Version:       2,
+		Description:   "index links by process",
+		NoTransaction: true,
+		Statements: []string{
+			// A failed concurrent build leaves an invalid index that
+			// IF NOT EXISTS would keep, so it is dropped first. An
+			// invalid index is also what a build in progress looks
+			// like, so this relies on the advisory lock taken by
+			// Store.Migrate to not drop the index of a concurrent run.
+			`
+				DO $$
+				BEGIN
+					IF EXISTS (
+						SELECT 1 FROM pg_index i
+						JOIN pg_class c ON c.oid = i.indexrelid
+						WHERE c.relname = 'links_process_priority_created_at_idx'
+						AND NOT i.indisvalid
+					) THEN
+						DROP INDEX links_process_priority_created_at_idx;
+					END IF;
+				END
+				$$
+			`,
+			`
+				CREATE INDEX CONCURRENTLY IF NOT EXISTS links_process_priority_created_at_idx
+				ON links (process, priority DESC, created_at DESC)
+			`,
+		},
+	},
+}
+
+// LatestSchemaVersion returns the version of the store's latest migration.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SQLDB is the subset of *sql.DB used to run migrations.
+// Use WrapDB to get one from a *sql.DB.
+type SQLDB interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (SQLRows, error)
+	Begin() (SQLTx, error)
+}
+
+// SQLRows is the subset of *sql.Rows used to run migrations.
+type SQLRows interface {
+	Next() bool
+	Scan(dest ...interface{}) error
+	Err() error
+	Close() error
+}
+
+// SQLTx is the subset of *sql.Tx used to run migrations.
+type SQLTx interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Commit() error
+	Rollback() error
+}
+
+type sqlDB struct {
+	*sql.DB
+}
+
+// WrapDB returns an SQLDB backed by a *sql.DB.
+func WrapDB(db *sql.DB) SQLDB {
+	return sqlDB{db}
+}
+
+// Query implements SQLDB.Query.
+func (db sqlDB) Query(query string, args ...interface{}) (SQLRows, error) {
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// Begin implements SQLDB.Begin.
+func (db sqlDB) Begin() (SQLTx, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// sqlConn is an SQLDB backed by a single connection.
+type sqlConn struct {
+	*sql.Conn
+}
+
+// Exec implements SQLDB.Exec.
+func (c sqlConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.Conn.ExecContext(context.Background(), query, args...)
+}
+
+// Query implements SQLDB.Query.
+func (c sqlConn) Query(query string, args ...interface{}) (SQLRows, error) {
+	rows, err := c.Conn.QueryContext(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// Begin implements SQLDB.Begin.
+func (c sqlConn) Begin() (SQLTx, error) {
+	tx, err := c.Conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// MigrateLocked is like Migrate, but it holds a PostgreSQL advisory lock
+// while migrating, so concurrent runs against the same database are applied
+// one after the other instead of overlapping.
+//
+// The lock is a session lock, so the migrations are run on a dedicated
+// connection of the pool.
+func MigrateLocked(db *sql.DB, migrations []Migration, to int) ([]int, error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, sqlLockMigrations, migrationsLockID); err != nil {
+		return nil, err
+	}
+
+	applied, err := Migrate(sqlConn{conn}, migrations, to)
+
+	// The connection goes back to the pool when closed, so the lock must be
+	// released explicitly.
+	if _, unlockErr := conn.ExecContext(ctx, sqlUnlockMigrations, migrationsLockID); unlockErr != nil && err == nil {
+		err = unlockErr
+	}
+
+	return applied, err
+}
+
+// SchemaVersion returns the latest migration version applied to the
+// database. It returns zero if no migration has been applied.
+func SchemaVersion(db SQLDB) (int, error) {
+	versions, err := appliedVersions(db)
+	if e, ok := errors.Cause(err).(*pq.Error); ok && e.Code == noTableCode {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	version := 0
+	for v := range versions {
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
+}
+
+// Migrate applies the migrations up to the given version that have not
+// been applied to the database yet, and returns their versions. A version
+// of zero migrates to the latest version.
+//
+// Each migration is applied in its own transaction together with its row in
+// the schema_migrations table, so running Migrate again is safe: a migration
+// already recorded is skipped. Migrations without a transaction are recorded
+// after their statements are run, which must be idempotent.
+//
+// Migrate doesn't serialize concurrent runs. Transactional migrations are
+// still applied once, but the statements of a migration without a
+// transaction may interfere with the same statements run concurrently, so
+// use MigrateLocked when several processes may migrate the same database.
+func Migrate(db SQLDB, migrations []Migration, to int) ([]int, error) {
+	if len(migrations) == 0 {
+		return nil, nil
+	}
+
+	prev := 0
+	for _, m := range migrations {
+		if m.Version <= prev {
+			return nil, ErrInvalidMigrations
+		}
+		prev = m.Version
+	}
+
+	if to == 0 {
+		to = prev
+	}
+	known := false
+	for _, m := range migrations {
+		known = known || m.Version == to
+	}
+	if !known {
+		return nil, errors.Wrapf(ErrUnknownVersion, "version %d", to)
+	}
+
+	if _, err := db.Exec(sqlCreateSchemaMigrations); err != nil {
+		return nil, err
+	}
+
+	versions, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+	for v := range versions {
+		if v > to {
+			return nil, errors.Wrapf(ErrDowngrade, "current version %d, target version %d", v, to)
+		}
+	}
+
+	var applied []int
+	for _, m := range migrations {
+		if m.Version > to {
+			break
+		}
+		if versions[m.Version] {
+			continue
+		}
+		ok, err := applyMigration(db, m)
+		if err != nil {
+			return applied, errors.Wrapf(err, "migration %d", m.Version)
+		}
+		if ok {
+			applied = append(applied, m.Version)
+		}
+	}
+
+	return applied, nil
+}
+
+// applyMigration applies a migration in a transaction. It returns false if
+// the migration was recorded concurrently by another transaction.
+func applyMigration(db SQLDB, m Migration) (bool, error) {
+	if m.NoTransaction {
+		return applyMigrationNoTx(db, m)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	// Recording the version first makes a concurrent migration wait for
+	// this transaction, then skip the migration once it is committed.
+	res, err := tx.Exec(sqlAddSchemaMigration, m.Version, m.Description)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if n == 0 {
+		return false, tx.Rollback()
+	}
+
+	for i, query := range m.Statements {
+		if _, err := tx.Exec(query); err != nil {
+			tx.Rollback()
+			return false, errors.Wrapf(err, "statement %d", i+1)
+		}
+	}
+
+	return true, tx.Commit()
+}
+
+// applyMigrationNoTx runs the statements of a migration outside of a
+// transaction, then records the migration. It returns false if the
+// migration was recorded concurrently.
+func applyMigrationNoTx(db SQLDB, m Migration) (bool, error) {
+	for i, query := range m.Statements {
+		if _, err := db.Exec(query); err != nil {
+			return false, errors.Wrapf(err, "statement %d", i+1)
+		}
+	}
+
+	res, err := db.Exec(sqlAddSchemaMigration, m.Version, m.Description)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+func appliedVersions(db SQLDB) (map[int]bool, error) {
+	rows, err := db.Query(sqlGetSchemaVersions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions[version] = true
+	}
+
+	return versions, rows.Err()
+}
@@ -0,0 +1,398 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgresstore
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/lib/pq"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testMigrations = []Migration{
+	{Version: 1, Description: "one", Statements: []string{"CREATE TABLE one", "CREATE INDEX one_idx"}},
+	{Version: 2, Description: "two", Statements: []string{"CREATE TABLE two"}},
+	{Version: 4, Description: "four", Statements: []string{"CREATE TABLE four"}},
+}
+
+// fakeDB is an in-memory SQLDB that records executed statements.
+type fakeDB struct {
+	noTable  bool
+	versions map[int]bool
+	executed []string
+
+	// failOn makes the given statement fail.
+	failOn string
+
+	// concurrent contains versions recorded by another transaction.
+	concurrent map[int]bool
+
+	// transactions is the number of transactions started.
+	transactions int
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{
+		noTable:    true,
+		versions:   make(map[int]bool),
+		concurrent: make(map[int]bool),
+	}
+}
+
+type fakeResult int64
+
+func (r fakeResult) LastInsertId() (int64, error) { return 0, nil }
+func (r fakeResult) RowsAffected() (int64, error) { return int64(r), nil }
+
+func (db *fakeDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	if query == sqlCreateSchemaMigrations {
+		db.noTable = false
+		return fakeResult(0), nil
+	}
+	if query == sqlAddSchemaMigration {
+		v := args[0].(int)
+		if db.versions[v] || db.concurrent[v] {
+			return fakeResult(0), nil
+		}
+		db.versions[v] = true
+		return fakeResult(1), nil
+	}
+	if query == db.failOn {
+		return nil, errors.New("failed")
+	}
+	db.executed = append(db.executed, query)
+	return fakeResult(0), nil
+}
+
+func (db *fakeDB) Query(query string, args ...interface{}) (SQLRows, error) {
+	if db.noTable {
+		return nil, &pq.Error{Code: noTableCode}
+	}
+	rows := &fakeRows{}
+	for v := range db.versions {
+		rows.versions = append(rows.versions, v)
+	}
+	sort.Ints(rows.versions)
+	return rows, nil
+}
+
+func (db *fakeDB) Begin() (SQLTx, error) {
+	db.transactions++
+	return &fakeTx{db: db}, nil
+}
+
+type fakeRows struct {
+	versions []int
+	current  int
+}
+
+func (r *fakeRows) Next() bool {
+	r.current++
+	return r.current <= len(r.versions)
+}
+
+func (r *fakeRows) Scan(dest ...interface{}) error {
+	*dest[0].(*int) = r.versions[r.current-1]
+	return nil
+}
+
+func (r *fakeRows) Err() error   { return nil }
+func (r *fakeRows) Close() error { return nil }
+
+type fakeTx struct {
+	db       *fakeDB
+	version  int
+	executed []string
+}
+
+func (tx *fakeTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	if query == sqlAddSchemaMigration {
+		v := args[0].(int)
+		if tx.db.versions[v] || tx.db.concurrent[v] {
+			return fakeResult(0), nil
+		}
+		tx.version = v
+		return fakeResult(1), nil
+	}
+	if query == tx.db.failOn {
+		return nil, errors.New("failed")
+	}
+	tx.executed = append(tx.executed, query)
+	return fakeResult(0), nil
+}
+
+func (tx *fakeTx) Commit() error {
+	tx.db.versions[tx.version] = true
+	tx.db.executed = append(tx.db.executed, tx.executed...)
+	return nil
+}
+
+func (tx *fakeTx) Rollback() error {
+	return nil
+}
+
+func TestMigrations(t *testing.T) {
+	prev := 0
+	for _, m := range migrations {
+		assert.Equal(t, prev+1, m.Version, "migration versions should be sequential")
+		assert.NotEmpty(t, m.Description)
+		assert.NotEmpty(t, m.Statements)
+		prev = m.Version
+	}
+	assert.Equal(t, prev, LatestSchemaVersion())
+}
+
+func TestMigrate(t *testing.T) {
+	db := newFakeDB()
+
+	version, err := SchemaVersion(db)
+	require.NoError(t, err)
+	assert.Equal(t, 0, version)
+
+	applied, err := Migrate(db, testMigrations, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 4}, applied)
+	assert.Equal(t, []string{"CREATE TABLE one", "CREATE INDEX one_idx", "CREATE TABLE two", "CREATE TABLE four"}, db.executed)
+
+	version, err = SchemaVersion(db)
+	require.NoError(t, err)
+	assert.Equal(t, 4, version)
+}
+
+func TestMigrate_idempotent(t *testing.T) {
+	db := newFakeDB()
+
+	_, err := Migrate(db, testMigrations, 0)
+	require.NoError(t, err)
+	db.executed = nil
+
+	applied, err := Migrate(db, testMigrations, 0)
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+	assert.Empty(t, db.executed)
+}
+
+func TestMigrate_to(t *testing.T) {
+	db := newFakeDB()
+
+	applied, err := Migrate(db, testMigrations, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, applied)
+
+	version, err := SchemaVersion(db)
+	require.NoError(t, err)
+	assert.Equal(t, 2, version)
+
+	applied, err = Migrate(db, testMigrations, 4)
+	require.NoError(t, err)
+	assert.Equal(t, []int{4}, applied)
+}
+
+func TestMigrate_errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		migrations []Migration
+		versions   []int
+		to         int
+		err        error
+	}{{
+		name:       "unordered",
+		migrations: []Migration{{Version: 2}, {Version: 1}},
+		err:        ErrInvalidMigrations,
+	}, {
+		name:       "duplicate",
+		migrations: []Migration{{Version: 1}, {Version: 1}},
+		err:        ErrInvalidMigrations,
+	}, {
+		name:       "zero",
+		migrations: []Migration{{Version: 0}},
+		err:        ErrInvalidMigrations,
+	}, {
+		name:       "unknown version",
+		migrations: testMigrations,
+		to:         3,
+		err:        ErrUnknownVersion,
+	}, {
+		name:       "downgrade",
+		migrations: testMigrations,
+		versions:   []int{1, 2, 4},
+		to:         2,
+		err:        ErrDowngrade,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newFakeDB()
+			db.noTable = false
+			for _, v := range tt.versions {
+				db.versions[v] = true
+			}
+
+			_, err := Migrate(db, tt.migrations, tt.to)
+			assert.Equal(t, tt.err, pkgerrors.Cause(err))
+			assert.Empty(t, db.executed)
+		})
+	}
+}
+
+func TestMigrate_failure(t *testing.T) {
+	db := newFakeDB()
+	db.failOn = "CREATE TABLE two"
+
+	applied, err := Migrate(db, testMigrations, 0)
+	assert.EqualError(t, err, "migration 2: statement 1: failed")
+	assert.Equal(t, []int{1}, applied)
+
+	version, err := SchemaVersion(db)
+	require.NoError(t, err)
+	assert.Equal(t, 1, version, "failed migration should be rolled back")
+
+	db.failOn = ""
+	applied, err = Migrate(db, testMigrations, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 4}, applied)
+}
+
+func TestMigrate_concurrent(t *testing.T) {
+	db := newFakeDB()
+	db.concurrent[2] = true
+
+	applied, err := Migrate(db, testMigrations, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 4}, applied)
+	assert.NotContains(t, db.executed, "CREATE TABLE two", "migration applied concurrently should be skipped")
+}
+
+func TestMigrate_noTransaction(t *testing.T) {
+	noTxMigrations := []Migration{
+		{Version: 1, Description: "one", Statements: []string{"CREATE TABLE one"}},
+		{Version: 2, Description: "two", Statements: []string{"DROP INDEX two_idx", "CREATE INDEX CONCURRENTLY two_idx"}, NoTransaction: true},
+	}
+
+	t.Run("applies statements outside of a transaction", func(t *testing.T) {
+		db := newFakeDB()
+
+		applied, err := Migrate(db, noTxMigrations, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2}, applied)
+		assert.Equal(t, []string{"CREATE TABLE one", "DROP INDEX two_idx", "CREATE INDEX CONCURRENTLY two_idx"}, db.executed)
+		assert.Equal(t, 1, db.transactions)
+
+		version, err := SchemaVersion(db)
+		require.NoError(t, err)
+		assert.Equal(t, 2, version)
+	})
+
+	t.Run("records the version once statements succeed", func(t *testing.T) {
+		db := newFakeDB()
+		db.failOn = "CREATE INDEX CONCURRENTLY two_idx"
+
+		applied, err := Migrate(db, noTxMigrations, 0)
+		assert.EqualError(t, err, "migration 2: statement 2: failed")
+		assert.Equal(t, []int{1}, applied)
+
+		version, err := SchemaVersion(db)
+		require.NoError(t, err)
+		assert.Equal(t, 1, version)
+
+		db.failOn = ""
+		applied, err = Migrate(db, noTxMigrations, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []int{2}, applied)
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		db := newFakeDB()
+		db.concurrent[2] = true
+
+		applied, err := Migrate(db, noTxMigrations, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []int{1}, applied)
+	})
+}
+
+func TestStore_Migrate(t *testing.T) {
+	a, err := createStore()
+	require.NoError(t, err)
+	defer freeStore(a)
+
+	version, err := a.SchemaVersion()
+	require.NoError(t, err)
+	assert.Equal(t, LatestSchemaVersion(), version)
+
+	applied, err := a.Migrate(0)
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+}
+
+func TestStore_Migrate_existingTables(t *testing.T) {
+	a, err := New(&Config{URL: "postgres://postgres@localhost/sdk_test?sslmode=disable"})
+	require.NoError(t, err)
+
+	// Tables created before migrations were introduced.
+	for _, query := range migrations[0].Statements {
+		_, err := a.db.Exec(query)
+		require.NoError(t, err)
+	}
+	require.NoError(t, a.Prepare())
+	defer freeStore(a)
+
+	version, err := a.SchemaVersion()
+	require.NoError(t, err)
+	assert.Equal(t, 0, version)
+
+	applied, err := a.Migrate(0)
+	require.NoError(t, err)
+	assert.Len(t, applied, len(migrations))
+}
+
+func TestStore_Migrate_concurrent(t *testing.T) {
+	a, err := New(&Config{URL: "postgres://postgres@localhost/sdk_test?sslmode=disable"})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	results := make([][]int, 4)
+	errs := make([]error, len(results))
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = a.Migrate(0)
+		}(i)
+	}
+	wg.Wait()
+
+	require.NoError(t, a.Prepare())
+	defer freeStore(a)
+
+	var applied []int
+	for i := range results {
+		require.NoError(t, errs[i])
+		applied = append(applied, results[i]...)
+	}
+	sort.Ints(applied)
+
+	var want []int
+	for _, m := range migrations {
+		want = append(want, m.Version)
+	}
+	assert.Equal(t, want, applied, "each migration should be applied once")
+}
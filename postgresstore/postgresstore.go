@@ -155,19 +155,29 @@ func (a *Store) AddEvidence(ctx context.Context, linkHash *types.Bytes32, eviden
 	return nil
 }
 
-// Create creates the database tables and indexes.
+// Create creates the database tables and indexes by applying all the
+// schema migrations.
 func (a *Store) Create() error {
-	for _, query := range sqlCreate {
-		if _, err := a.db.Exec(query); err != nil {
-			return err
-		}
-	}
-	return nil
+	_, err := a.Migrate(0)
+	return err
+}
+
+// Migrate applies the schema migrations up to the given version, or up to
+// the latest version if it is zero, and returns the versions it applied.
+// Concurrent calls, even from several processes, are applied one after the
+// other.
+func (a *Store) Migrate(to int) ([]int, error) {
+	return MigrateLocked(a.db, migrations, to)
+}
+
+// SchemaVersion returns the current schema version of the database.
+func (a *Store) SchemaVersion() (int, error) {
+	return SchemaVersion(WrapDB(a.db))
 }
 
 // Prepare prepares the database stmts.
 // It should be called once before interacting with segments.
-// It assumes the tables have been created using Create().
+// It assumes the tables have been created using Create() or Migrate().
 func (a *Store) Prepare() error {
 	stmts, err := newStmts(a.db)
 	if err != nil {
@@ -164,6 +164,15 @@ const (
 		DO UPDATE SET
 			value = $2
 	`
+	sqlCreateValue = `
+		INSERT INTO values (
+			key,
+			value
+		)
+		VALUES ($1, $2)
+		ON CONFLICT (key)
+		DO NOTHING
+	`
 	sqlGetValue = `
 		SELECT value FROM values
 		WHERE key = $1
@@ -189,86 +198,16 @@ const (
 	`
 )
 
-var sqlCreate = []string{
-	`
-		CREATE TABLE links (
-			id BIGSERIAL PRIMARY KEY,
-			link_hash bytea NOT NULL,
-			priority double precision NOT NULL,
-			map_id text NOT NULL,
-			prev_link_hash bytea DEFAULT NULL,
-			tags text[] DEFAULT NULL,
-			data jsonb NOT NULL,
-			process text NOT NULL,
-			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
-		)
-	`,
-	`
-		CREATE UNIQUE INDEX links_link_hash_idx
-		ON links (link_hash)
-	`,
-	`
-		CREATE INDEX links_priority_created_at_idx
-		ON links (priority DESC, created_at DESC)
-	`,
-	`
-		CREATE INDEX links_map_id_idx
-		ON links (map_id)
-	`,
-	`
-		CREATE INDEX links_map_id_priority_created_at_idx
-		ON links (map_id, priority DESC, created_at DESC)
-	`,
-	`
-		CREATE INDEX links_prev_link_hash_priority_created_at_idx
-		ON links (prev_link_hash, priority DESC, created_at DESC)
-	`,
-	`
-		CREATE INDEX links_tags_idx
-		ON links USING gin(tags)
-	`,
-	`
-		CREATE TABLE evidences (
-			id BIGSERIAL PRIMARY KEY,
-			link_hash bytea NOT NULL,
-			provider text NOT NULL,
-			data jsonb NOT NULL,
-			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
-	)
-	`,
-	`
-		CREATE UNIQUE INDEX evidences_link_hash_provider_idx
-		ON evidences (link_hash, provider)
-	`,
-	`
-		CREATE INDEX evidences_link_hash_idx
-		ON evidences (link_hash)
-	`,
-	`
-		CREATE TABLE values (
-			id BIGSERIAL PRIMARY KEY,
-			key bytea NOT NULL,
-			value bytea NOT NULL,
-			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
-		)
-	`,
-	`
-		CREATE UNIQUE INDEX values_key_idx
-		ON values (key)
-	`,
-}
-
 var sqlDrop = []string{
 	"DROP TABLE links, evidences, values",
+	"DROP TABLE IF EXISTS schema_migrations",
 }
 
 type writeStmts struct {
 	CreateLink  *sql.Stmt
 	DeleteLink  *sql.Stmt
 	SaveValue   *sql.Stmt
+	CreateValue *sql.Stmt
 	DeleteValue *sql.Stmt
 	AddEvidence *sql.Stmt
 }
@@ -337,6 +276,7 @@ func newStmts(db *sql.DB) (*stmts, error) {
 	s.CreateLink = prepare(sqlCreateLink)
 	s.DeleteLink = prepare(sqlDeleteLink)
 	s.SaveValue = prepare(sqlSaveValue)
+	s.CreateValue = prepare(sqlCreateValue)
 	s.DeleteValue = prepare(sqlDeleteValue)
 	s.AddEvidence = prepare(sqlAddEvidence)
 
@@ -368,6 +308,7 @@ func newBatchStmts(tx *sql.Tx) (*batchStmts, error) {
 	s.CreateLink = prepare(sqlCreateLink)
 	s.DeleteLink = prepare(sqlDeleteLink)
 	s.SaveValue = prepare(sqlSaveValue)
+	s.CreateValue = prepare(sqlCreateValue)
 	s.DeleteValue = prepare(sqlDeleteValue)
 
 	if err != nil {
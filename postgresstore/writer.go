@@ -33,6 +33,21 @@ func (a *writer) SetValue(ctx context.Context, key []byte, value []byte) error {
 	return err
 }
 
+// CreateValue implements github.com/stratumn/go-indigocore/store.KeyValueCreator.CreateValue.
+func (a *writer) CreateValue(ctx context.Context, key []byte, value []byte) (bool, error) {
+	res, err := a.stmts.CreateValue.Exec(key, value)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
 // DeleteValue implements github.com/stratumn/go-indigocore/store.KeyValueStore.DeleteValue.
 func (a *writer) DeleteValue(ctx context.Context, key []byte) ([]byte, error) {
 	var data []byte
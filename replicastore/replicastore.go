@@ -0,0 +1,448 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package replicastore implements a store adapter that sends writes to a
+// primary store and spreads reads across read replicas.
+//
+// Replicas are expected to be kept in sync with the primary by other means,
+// for instance database replication. Reads are balanced between healthy
+// replicas in a round-robin fashion. A replica that fails a read because of
+// a transport or server error is marked unhealthy and the read is retried
+// on the next replica, then on the primary. Client errors such as bad
+// requests are returned as is. Replicas are periodically health-checked and put back in the
+// pool once they recover.
+//
+// Because replicas may lag behind the primary, a context can be decorated
+// with WithReadYourWrites so that reads made with it see the writes
+// previously made with it. Sessions keeps such contexts across HTTP
+// requests sharing a token.
+package replicastore
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/types"
+)
+
+const (
+	// DefaultHealthCheckInterval is the default interval between two
+	// health checks of the replicas.
+	DefaultHealthCheckInterval = 10 * time.Second
+
+	// DefaultHealthCheckTimeout is the default timeout of a health check.
+	DefaultHealthCheckTimeout = 5 * time.Second
+)
+
+var (
+	// ErrNoPrimary is returned when no primary store is configured.
+	ErrNoPrimary = errors.New("a primary store is required")
+
+	// ErrNoKeyValueStore is returned by key-value operations when the
+	// primary store doesn't implement store.KeyValueStore.
+	ErrNoKeyValueStore = errors.New("primary store is not a key-value store")
+)
+
+// HealthCheckFunc checks whether an adapter is able to serve reads.
+type HealthCheckFunc func(ctx context.Context, a store.Adapter) error
+
+// Config contains configuration options for the store.
+type Config struct {
+	// The store receiving writes.
+	Primary store.Adapter
+
+	// The stores serving reads.
+	Replicas []store.Adapter
+
+	// The interval between two health checks of the replicas.
+	// Defaults to DefaultHealthCheckInterval.
+	HealthCheckInterval time.Duration
+
+	// The function used to check the health of a replica.
+	// Defaults to calling GetInfo.
+	HealthCheck HealthCheckFunc
+}
+
+// Store is the type that implements github.com/stratumn/go-indigocore/store.Adapter.
+type Store struct {
+	primary     store.Adapter
+	replicas    []*replica
+	next        uint32
+	healthCheck HealthCheckFunc
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type replica struct {
+	index   int
+	adapter store.Adapter
+	healthy int32
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+// setHealthy updates the health of the replica and returns whether it
+// changed.
+func (r *replica) setHealthy(healthy bool) bool {
+	var v int32
+	if healthy {
+		v = 1
+	}
+	return atomic.SwapInt32(&r.healthy, v) != v
+}
+
+// New creates a store that routes calls to the configured stores and starts
+// health-checking the replicas.
+func New(config *Config) (*Store, error) {
+	if config.Primary == nil {
+		return nil, ErrNoPrimary
+	}
+
+	s := &Store{
+		primary:     config.Primary,
+		healthCheck: config.HealthCheck,
+		done:        make(chan struct{}),
+	}
+	if s.healthCheck == nil {
+		s.healthCheck = getInfoHealthCheck
+	}
+	for i, a := range config.Replicas {
+		s.replicas = append(s.replicas, &replica{index: i, adapter: a, healthy: 1})
+	}
+
+	interval := config.HealthCheckInterval
+	if interval <= 0 {
+		interval = DefaultHealthCheckInterval
+	}
+	if len(s.replicas) > 0 {
+		go s.runHealthChecks(interval)
+	}
+
+	return s, nil
+}
+
+func getInfoHealthCheck(ctx context.Context, a store.Adapter) error {
+	_, err := a.GetInfo(ctx)
+	return err
+}
+
+// Close stops health-checking the replicas.
+// It doesn't close the underlying stores.
+func (s *Store) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+func (s *Store) runHealthChecks(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), DefaultHealthCheckTimeout)
+			s.CheckHealth(ctx)
+			cancel()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// CheckHealth checks the health of all the replicas and updates the pool of
+// replicas serving reads accordingly.
+func (s *Store) CheckHealth(ctx context.Context) {
+	for _, r := range s.replicas {
+		err := s.healthCheck(ctx, r.adapter)
+		if r.setHealthy(err == nil) {
+			if err != nil {
+				log.WithFields(log.Fields{
+					"replica": r.index,
+					"error":   err,
+				}).Warn("Replica failed health check")
+			} else {
+				log.WithField("replica", r.index).Info("Replica is healthy again")
+			}
+		}
+	}
+}
+
+// HealthyReplicas returns the number of replicas currently serving reads.
+func (s *Store) HealthyReplicas() int {
+	n := 0
+	for _, r := range s.replicas {
+		if r.isHealthy() {
+			n++
+		}
+	}
+	return n
+}
+
+// read calls fn on the healthy replicas in turn until one succeeds, falling
+// back to the primary.
+func (s *Store) read(ctx context.Context, fn func(store.Adapter) error) error {
+	if n := len(s.replicas); n > 0 {
+		start := int(atomic.AddUint32(&s.next, 1) % uint32(n))
+		for i := 0; i < n; i++ {
+			r := s.replicas[(start+i)%n]
+			if !r.isHealthy() {
+				continue
+			}
+
+			err := fn(r.adapter)
+			if err == nil || ctx.Err() != nil || !isReplicaFailure(err) {
+				return err
+			}
+
+			if r.setHealthy(false) {
+				log.WithFields(log.Fields{
+					"replica": r.index,
+					"error":   err,
+				}).Warn("Replica failed, failing over")
+			}
+		}
+	}
+
+	return fn(s.primary)
+}
+
+// isReplicaFailure tells whether an error comes from the replica or its
+// transport rather than from the request. Errors carrying a client error
+// HTTP status, such as bad requests, would be returned by any store so they
+// don't make the replica unhealthy.
+func isReplicaFailure(err error) bool {
+	if e, ok := errors.Cause(err).(interface{ Status() int }); ok {
+		return e.Status() >= http.StatusInternalServerError
+	}
+
+	return true
+}
+
+/********** Store adapter implementation **********/
+
+// GetInfo implements github.com/stratumn/go-indigocore/store.Adapter.GetInfo.
+// It returns the information of the primary store.
+func (s *Store) GetInfo(ctx context.Context) (interface{}, error) {
+	return s.primary.GetInfo(ctx)
+}
+
+// AddStoreEventChannel implements
+// github.com/stratumn/go-indigocore/store.Adapter.AddStoreEventChannel.
+// Events are emitted by the primary store.
+func (s *Store) AddStoreEventChannel(eventChan chan *store.Event) {
+	s.primary.AddStoreEventChannel(eventChan)
+}
+
+// NewBatch implements github.com/stratumn/go-indigocore/store.Adapter.NewBatch.
+// Batches are written to and read from the primary store.
+func (s *Store) NewBatch(ctx context.Context) (store.Batch, error) {
+	b, err := s.primary.NewBatch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if sess := getSession(ctx); sess != nil {
+		return &Batch{Batch: b, session: sess}, nil
+	}
+
+	return b, nil
+}
+
+/********** Store writer implementation **********/
+
+// CreateLink implements github.com/stratumn/go-indigocore/store.LinkWriter.CreateLink.
+func (s *Store) CreateLink(ctx context.Context, link *cs.Link) (*types.Bytes32, error) {
+	lh, err := s.primary.CreateLink(ctx, link)
+	if err == nil {
+		getSession(ctx).addLinkHashes(lh)
+	}
+	return lh, err
+}
+
+// CreateLinks implements github.com/stratumn/go-indigocore/store.LinksWriter.CreateLinks.
+func (s *Store) CreateLinks(ctx context.Context, links []*cs.Link, mode store.WriteMode) ([]*store.LinkResult, error) {
+	res, err := store.CreateLinks(ctx, s.primary, links, mode)
+	if sess := getSession(ctx); sess != nil {
+		for _, r := range res {
+			if r != nil && r.LinkHash != nil && r.Error == "" {
+				sess.addLinkHashes(r.LinkHash)
+			}
+		}
+	}
+	return res, err
+}
+
+// AddEvidence implements github.com/stratumn/go-indigocore/store.EvidenceWriter.AddEvidence.
+func (s *Store) AddEvidence(ctx context.Context, linkHash *types.Bytes32, evidence *cs.Evidence) error {
+	err := s.primary.AddEvidence(ctx, linkHash, evidence)
+	if err == nil {
+		getSession(ctx).addLinkHashes(linkHash)
+	}
+	return err
+}
+
+/********** Store reader implementation **********/
+
+// GetSegment implements github.com/stratumn/go-indigocore/store.SegmentReader.GetSegment.
+func (s *Store) GetSegment(ctx context.Context, linkHash *types.Bytes32) (*cs.Segment, error) {
+	if getSession(ctx).hasLinkHash(linkHash) {
+		return s.primary.GetSegment(ctx, linkHash)
+	}
+
+	var seg *cs.Segment
+	err := s.read(ctx, func(a store.Adapter) (err error) {
+		seg, err = a.GetSegment(ctx, linkHash)
+		return
+	})
+	return seg, err
+}
+
+// FindSegments implements github.com/stratumn/go-indigocore/store.SegmentReader.FindSegments.
+func (s *Store) FindSegments(ctx context.Context, filter *store.SegmentFilter) (cs.SegmentSlice, error) {
+	if getSession(ctx).hasWritten() {
+		return s.primary.FindSegments(ctx, filter)
+	}
+
+	var segs cs.SegmentSlice
+	err := s.read(ctx, func(a store.Adapter) (err error) {
+		segs, err = a.FindSegments(ctx, filter)
+		return
+	})
+	return segs, err
+}
+
+// GetMapIDs implements github.com/stratumn/go-indigocore/store.SegmentReader.GetMapIDs.
+func (s *Store) GetMapIDs(ctx context.Context, filter *store.MapFilter) ([]string, error) {
+	if getSession(ctx).hasWritten() {
+		return s.primary.GetMapIDs(ctx, filter)
+	}
+
+	var mapIDs []string
+	err := s.read(ctx, func(a store.Adapter) (err error) {
+		mapIDs, err = a.GetMapIDs(ctx, filter)
+		return
+	})
+	return mapIDs, err
+}
+
+// GetEvidences implements github.com/stratumn/go-indigocore/store.EvidenceReader.GetEvidences.
+func (s *Store) GetEvidences(ctx context.Context, linkHash *types.Bytes32) (*cs.Evidences, error) {
+	if getSession(ctx).hasLinkHash(linkHash) {
+		return s.primary.GetEvidences(ctx, linkHash)
+	}
+
+	var evidences *cs.Evidences
+	err := s.read(ctx, func(a store.Adapter) (err error) {
+		evidences, err = a.GetEvidences(ctx, linkHash)
+		return
+	})
+	return evidences, err
+}
+
+// GetLinkHeight implements github.com/stratumn/go-indigocore/store.LinkHeightReader.GetLinkHeight.
+// Heights are read from the primary store.
+func (s *Store) GetLinkHeight(ctx context.Context, linkHash *types.Bytes32) (int64, error) {
+	return store.GetLinkHeight(ctx, s.primary, linkHash)
+}
+
+// IsSearchable implements
+// github.com/stratumn/go-indigocore/store.SearchChecker.IsSearchable.
+func (s *Store) IsSearchable() bool {
+	return store.IsSearchable(s.primary)
+}
+
+// Search implements github.com/stratumn/go-indigocore/store.Searcher.Search.
+// Searches are made on the primary store.
+func (s *Store) Search(ctx context.Context, query *store.SearchQuery) (cs.SegmentSlice, error) {
+	return store.Search(ctx, s.primary, query)
+}
+
+// SaveStateOpenings implements
+// github.com/stratumn/go-indigocore/store.StateOpeningStore.SaveStateOpenings.
+// State openings are stored in the primary store.
+func (s *Store) SaveStateOpenings(ctx context.Context, linkHash *types.Bytes32, openings []*cs.StateOpening) error {
+	return store.SaveStateOpenings(ctx, s.primary, linkHash, openings)
+}
+
+// GetStateOpenings implements
+// github.com/stratumn/go-indigocore/store.StateOpeningStore.GetStateOpenings.
+// State openings are read from the primary store so that purged values are
+// never served by a lagging replica.
+func (s *Store) GetStateOpenings(ctx context.Context, linkHash *types.Bytes32) ([]*cs.StateOpening, error) {
+	return store.GetStateOpenings(ctx, s.primary, linkHash)
+}
+
+// PurgeStateOpenings implements
+// github.com/stratumn/go-indigocore/store.StateOpeningStore.PurgeStateOpenings.
+func (s *Store) PurgeStateOpenings(ctx context.Context, linkHash *types.Bytes32) error {
+	return store.PurgeStateOpenings(ctx, s.primary, linkHash)
+}
+
+/********** github.com/stratumn/go-indigocore/store.KeyValueStore implementation **********/
+
+// keyValueStore returns the primary store: key-value pairs are never read
+// from replicas.
+func (s *Store) keyValueStore() (store.KeyValueStore, error) {
+	kv, ok := s.primary.(store.KeyValueStore)
+	if !ok {
+		return nil, ErrNoKeyValueStore
+	}
+	return kv, nil
+}
+
+// GetValue implements github.com/stratumn/go-indigocore/store.KeyValueStore.GetValue.
+func (s *Store) GetValue(ctx context.Context, key []byte) ([]byte, error) {
+	kv, err := s.keyValueStore()
+	if err != nil {
+		return nil, err
+	}
+	return kv.GetValue(ctx, key)
+}
+
+// SetValue implements github.com/stratumn/go-indigocore/store.KeyValueStore.SetValue.
+func (s *Store) SetValue(ctx context.Context, key, value []byte) error {
+	kv, err := s.keyValueStore()
+	if err != nil {
+		return err
+	}
+	return kv.SetValue(ctx, key, value)
+}
+
+// CreateValue implements github.com/stratumn/go-indigocore/store.KeyValueCreator.CreateValue.
+func (s *Store) CreateValue(ctx context.Context, key, value []byte) (bool, error) {
+	kv, err := s.keyValueStore()
+	if err != nil {
+		return false, err
+	}
+	return store.CreateValue(ctx, kv, key, value)
+}
+
+// DeleteValue implements github.com/stratumn/go-indigocore/store.KeyValueStore.DeleteValue.
+func (s *Store) DeleteValue(ctx context.Context, key []byte) ([]byte, error) {
+	kv, err := s.keyValueStore()
+	if err != nil {
+		return nil, err
+	}
+	return kv.DeleteValue(ctx, key)
+}
@@ -0,0 +1,398 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replicastore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/cs/cstesting"
+	"github.com/stratumn/go-indigocore/dummystore"
+	"github.com/stratumn/go-indigocore/jsonhttp"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/store/storetestcases"
+	"github.com/stratumn/go-indigocore/store/storetesting"
+	"github.com/stratumn/go-indigocore/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noCatchUp is a lag long enough for a replica to never catch up during a test.
+const noCatchUp = time.Hour
+
+// replicate copies the links and evidences saved in the primary to the
+// replica after the given lag, simulating asynchronous replication.
+func replicate(primary store.Adapter, replica store.Adapter, lag time.Duration) {
+	events := make(chan *store.Event, 100)
+	primary.AddStoreEventChannel(events)
+
+	go func() {
+		ctx := context.Background()
+		for event := range events {
+			time.Sleep(lag)
+			switch event.EventType {
+			case store.SavedLinks:
+				for _, link := range event.Data.([]*cs.Link) {
+					replica.CreateLink(ctx, link)
+				}
+			case store.SavedEvidences:
+				for lh, e := range event.Data.(map[string]*cs.Evidence) {
+					linkHash, _ := types.NewBytes32FromString(lh)
+					replica.AddEvidence(ctx, linkHash, e)
+				}
+			}
+		}
+	}()
+}
+
+func newLaggingStore(t *testing.T, lag time.Duration, replicasCount int) (*Store, store.Adapter, []store.Adapter) {
+	primary := dummystore.New(&dummystore.Config{})
+	var replicas []store.Adapter
+	for i := 0; i < replicasCount; i++ {
+		replica := dummystore.New(&dummystore.Config{})
+		replicate(primary, replica, lag)
+		replicas = append(replicas, replica)
+	}
+
+	s, err := New(&Config{
+		Primary:             primary,
+		Replicas:            replicas,
+		HealthCheckInterval: time.Hour,
+	})
+	require.NoError(t, err)
+	return s, primary, replicas
+}
+
+// waitFor polls the condition until it is true or the test times out.
+func waitFor(t *testing.T, cond func() bool) {
+	timeout := time.After(time.Second)
+	for !cond() {
+		select {
+		case <-timeout:
+			t.Fatal("condition not met in time")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestReplicaStore(t *testing.T) {
+	storetestcases.Factory{
+		New: func() (store.Adapter, error) {
+			primary := dummystore.New(&dummystore.Config{})
+			return New(&Config{
+				Primary:  primary,
+				Replicas: []store.Adapter{primary, primary},
+			})
+		},
+		Free: func(a store.Adapter) {
+			a.(*Store).Close()
+		},
+	}.RunStoreTests(t)
+}
+
+func TestNew_noPrimary(t *testing.T) {
+	_, err := New(&Config{})
+	assert.EqualError(t, err, ErrNoPrimary.Error())
+}
+
+func TestWrite_primary(t *testing.T) {
+	ctx := context.Background()
+	s, primary, replicas := newLaggingStore(t, noCatchUp, 1)
+	defer s.Close()
+
+	lh, err := s.CreateLink(ctx, cstesting.RandomLink())
+	require.NoError(t, err)
+
+	got, err := primary.GetSegment(ctx, lh)
+	require.NoError(t, err)
+	assert.NotNil(t, got)
+
+	got, err = replicas[0].GetSegment(ctx, lh)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func TestRead_lag(t *testing.T) {
+	ctx := context.Background()
+	s, _, replicas := newLaggingStore(t, 10*time.Millisecond, 2)
+	defer s.Close()
+
+	link := cstesting.RandomLink()
+	lh, err := s.CreateLink(ctx, link)
+	require.NoError(t, err)
+
+	got, err := s.GetSegment(ctx, lh)
+	require.NoError(t, err)
+	assert.Nil(t, got, "replicas should not have caught up yet")
+
+	for _, r := range replicas {
+		waitFor(t, func() bool {
+			got, err := r.GetSegment(ctx, lh)
+			return err == nil && got != nil
+		})
+	}
+
+	got, err = s.GetSegment(ctx, lh)
+	require.NoError(t, err)
+	assert.NotNil(t, got)
+
+	segs, err := s.FindSegments(ctx, &store.SegmentFilter{
+		Pagination: store.Pagination{Limit: store.DefaultLimit},
+		MapIDs:     []string{link.Meta.MapID},
+	})
+	require.NoError(t, err)
+	assert.Len(t, segs, 1)
+}
+
+func TestReadYourWrites(t *testing.T) {
+	ctx := WithReadYourWrites(context.Background())
+	s, _, _ := newLaggingStore(t, noCatchUp, 2)
+	defer s.Close()
+
+	link := cstesting.RandomLink()
+	lh, err := s.CreateLink(ctx, link)
+	require.NoError(t, err)
+
+	got, err := s.GetSegment(ctx, lh)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, link, &got.Link)
+
+	require.NoError(t, s.AddEvidence(ctx, lh, &cs.Evidence{Provider: "test"}))
+	evidences, err := s.GetEvidences(ctx, lh)
+	require.NoError(t, err)
+	assert.Len(t, *evidences, 1)
+
+	segs, err := s.FindSegments(ctx, &store.SegmentFilter{
+		Pagination: store.Pagination{Limit: store.DefaultLimit},
+		MapIDs:     []string{link.Meta.MapID},
+	})
+	require.NoError(t, err)
+	assert.Len(t, segs, 1)
+
+	mapIDs, err := s.GetMapIDs(ctx, &store.MapFilter{
+		Pagination: store.Pagination{Limit: store.DefaultLimit},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{link.Meta.MapID}, mapIDs)
+
+	got, err = s.GetSegment(context.Background(), lh)
+	require.NoError(t, err)
+	assert.Nil(t, got, "other contexts should read from replicas")
+}
+
+func TestReadYourWrites_batch(t *testing.T) {
+	ctx := WithReadYourWrites(context.Background())
+	s, _, _ := newLaggingStore(t, noCatchUp, 1)
+	defer s.Close()
+
+	b, err := s.NewBatch(ctx)
+	require.NoError(t, err)
+
+	lh, err := b.CreateLink(ctx, cstesting.RandomLink())
+	require.NoError(t, err)
+
+	got, err := s.GetSegment(ctx, lh)
+	require.NoError(t, err)
+	assert.Nil(t, got, "batch should not be written yet")
+
+	require.NoError(t, b.Write(ctx))
+
+	got, err = s.GetSegment(ctx, lh)
+	require.NoError(t, err)
+	assert.NotNil(t, got)
+}
+
+func TestRead_roundRobin(t *testing.T) {
+	ctx := context.Background()
+	r1 := &storetesting.MockAdapter{}
+	r2 := &storetesting.MockAdapter{}
+	s, err := New(&Config{
+		Primary:             &storetesting.MockAdapter{},
+		Replicas:            []store.Adapter{r1, r2},
+		HealthCheckInterval: time.Hour,
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	for i := 0; i < 4; i++ {
+		_, err := s.GetSegment(ctx, cstesting.RandomSegment().GetLinkHash())
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, 2, r1.MockGetSegment.CalledCount)
+	assert.Equal(t, 2, r2.MockGetSegment.CalledCount)
+}
+
+func TestRead_failover(t *testing.T) {
+	ctx := context.Background()
+	s, primary, _ := newLaggingStore(t, 0, 0)
+	defer s.Close()
+
+	lh, err := primary.CreateLink(ctx, cstesting.RandomLink())
+	require.NoError(t, err)
+
+	failing := &storetesting.MockAdapter{}
+	failing.MockGetSegment.Fn = func(*types.Bytes32) (*cs.Segment, error) {
+		return nil, errors.New("connection refused")
+	}
+	s.replicas = []*replica{
+		{index: 0, adapter: failing, healthy: 1},
+		{index: 1, adapter: failing, healthy: 1},
+	}
+
+	for i := 0; i < 2; i++ {
+		got, err := s.GetSegment(ctx, lh)
+		require.NoError(t, err)
+		assert.NotNil(t, got, "read should fail over to the primary")
+	}
+
+	assert.Equal(t, 2, failing.MockGetSegment.CalledCount, "failed replicas should leave the pool")
+	assert.Equal(t, 0, s.HealthyReplicas())
+}
+
+func TestRead_clientError(t *testing.T) {
+	ctx := context.Background()
+	primary := &storetesting.MockAdapter{}
+	replica := &storetesting.MockAdapter{}
+	replica.MockFindSegments.Fn = func(*store.SegmentFilter) (cs.SegmentSlice, error) {
+		return nil, jsonhttp.NewErrBadRequest("invalid filter")
+	}
+	s, err := New(&Config{
+		Primary:             primary,
+		Replicas:            []store.Adapter{replica},
+		HealthCheckInterval: time.Hour,
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = s.FindSegments(ctx, &store.SegmentFilter{})
+	assert.Equal(t, jsonhttp.NewErrBadRequest("invalid filter"), err)
+	assert.Equal(t, 0, primary.MockFindSegments.CalledCount, "client errors should not fail over")
+	assert.Equal(t, 1, s.HealthyReplicas())
+}
+
+func TestCheckHealth(t *testing.T) {
+	ctx := context.Background()
+	var healthErr error
+	r1 := &storetesting.MockAdapter{}
+	r1.MockGetInfo.Fn = func() (interface{}, error) { return nil, healthErr }
+	r2 := &storetesting.MockAdapter{}
+	s, err := New(&Config{
+		Primary:             &storetesting.MockAdapter{},
+		Replicas:            []store.Adapter{r1, r2},
+		HealthCheckInterval: time.Hour,
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	healthErr = errors.New("down")
+	s.CheckHealth(ctx)
+	assert.Equal(t, 1, s.HealthyReplicas())
+
+	for i := 0; i < 2; i++ {
+		_, err := s.GetMapIDs(ctx, &store.MapFilter{})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 0, r1.MockGetMapIDs.CalledCount)
+	assert.Equal(t, 2, r2.MockGetMapIDs.CalledCount)
+
+	healthErr = nil
+	s.CheckHealth(ctx)
+	assert.Equal(t, 2, s.HealthyReplicas())
+
+	for i := 0; i < 2; i++ {
+		_, err := s.GetMapIDs(ctx, &store.MapFilter{})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 1, r1.MockGetMapIDs.CalledCount)
+}
+
+func TestCheckHealth_periodic(t *testing.T) {
+	r := &storetesting.MockAdapter{}
+	checked := make(chan struct{}, 1)
+	s, err := New(&Config{
+		Primary:             &storetesting.MockAdapter{},
+		Replicas:            []store.Adapter{r},
+		HealthCheckInterval: time.Millisecond,
+		HealthCheck: func(context.Context, store.Adapter) error {
+			select {
+			case checked <- struct{}{}:
+			default:
+			}
+			return errors.New("down")
+		},
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	select {
+	case <-checked:
+	case <-time.After(time.Second):
+		t.Fatal("replica was not health-checked")
+	}
+
+	waitFor(t, func() bool { return s.HealthyReplicas() == 0 })
+}
+
+func TestSessions(t *testing.T) {
+	sessions := NewSessions(DefaultSessionTTL)
+	s, _, _ := newLaggingStore(t, noCatchUp, 1)
+	defer s.Close()
+
+	newRequest := func(token string) *http.Request {
+		r := httptest.NewRequest("GET", "/", nil)
+		if token != "" {
+			r.Header.Set(SessionHeader, token)
+		}
+		return r
+	}
+
+	lh, err := s.CreateLink(sessions.RequestContext(newRequest("alice")), cstesting.RandomLink())
+	require.NoError(t, err)
+
+	got, err := s.GetSegment(sessions.RequestContext(newRequest("alice")), lh)
+	require.NoError(t, err)
+	assert.NotNil(t, got, "later requests of the session should read from the primary")
+
+	got, err = s.GetSegment(sessions.RequestContext(newRequest("bob")), lh)
+	require.NoError(t, err)
+	assert.Nil(t, got, "other sessions should read from replicas")
+
+	got, err = s.GetSegment(sessions.RequestContext(newRequest("")), lh)
+	require.NoError(t, err)
+	assert.Nil(t, got, "requests without a session should read from replicas")
+}
+
+func TestSessions_expire(t *testing.T) {
+	sessions := NewSessions(time.Millisecond)
+	s, _, _ := newLaggingStore(t, noCatchUp, 1)
+	defer s.Close()
+
+	lh, err := s.CreateLink(sessions.WithSession(context.Background(), "alice"), cstesting.RandomLink())
+	require.NoError(t, err)
+
+	time.Sleep(10 * time.Millisecond)
+
+	got, err := s.GetSegment(sessions.WithSession(context.Background(), "alice"), lh)
+	require.NoError(t, err)
+	assert.Nil(t, got, "expired sessions should be forgotten")
+	assert.Len(t, sessions.sessions, 1, "expired sessions should be removed")
+}
@@ -0,0 +1,188 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replicastore
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/types"
+)
+
+type sessionKey struct{}
+
+// session records the writes made with a context so that reads made with
+// the same context can be sent to the primary store.
+type session struct {
+	mutex      sync.RWMutex
+	linkHashes map[string]struct{}
+
+	// lastUsed is protected by the mutex of Sessions.
+	lastUsed time.Time
+}
+
+// WithReadYourWrites returns a context in which reads see the writes
+// previously made with it, even if the replicas lag behind the primary.
+//
+// Segments written or updated with the context are read from the primary
+// store. Once a write has been made with the context, FindSegments and
+// GetMapIDs are also served by the primary store.
+func WithReadYourWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey{}, newSession())
+}
+
+func newSession() *session {
+	return &session{linkHashes: make(map[string]struct{})}
+}
+
+// SessionHeader is the HTTP header in which clients send the token of
+// their read-your-writes session.
+const SessionHeader = "X-Indigo-Session"
+
+// DefaultSessionTTL is the default duration after which an unused session
+// is forgotten.
+const DefaultSessionTTL = 10 * time.Minute
+
+// Sessions keeps read-your-writes sessions across requests. Sessions are
+// identified by a token chosen by the client and expire once they haven't
+// been used for a while.
+type Sessions struct {
+	ttl time.Duration
+
+	mutex     sync.Mutex
+	sessions  map[string]*session
+	lastSweep time.Time
+}
+
+// NewSessions creates a set of sessions that expire after ttl without use.
+func NewSessions(ttl time.Duration) *Sessions {
+	return &Sessions{
+		ttl:       ttl,
+		sessions:  make(map[string]*session),
+		lastSweep: time.Now(),
+	}
+}
+
+// WithSession returns a context in which reads see the writes previously
+// made in the session identified by token, like WithReadYourWrites.
+func (s *Sessions) WithSession(ctx context.Context, token string) context.Context {
+	now := time.Now()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if now.Sub(s.lastSweep) > s.ttl {
+		for t, sess := range s.sessions {
+			if now.Sub(sess.lastUsed) > s.ttl {
+				delete(s.sessions, t)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	sess, ok := s.sessions[token]
+	if !ok || now.Sub(sess.lastUsed) > s.ttl {
+		sess = newSession()
+		s.sessions[token] = sess
+	}
+	sess.lastUsed = now
+
+	return context.WithValue(ctx, sessionKey{}, sess)
+}
+
+// RequestContext returns the context of an HTTP request in the session
+// whose token is given by the SessionHeader. Requests without a token
+// aren't part of a session.
+func (s *Sessions) RequestContext(r *http.Request) context.Context {
+	token := r.Header.Get(SessionHeader)
+	if token == "" {
+		return r.Context()
+	}
+
+	return s.WithSession(r.Context(), token)
+}
+
+func getSession(ctx context.Context) *session {
+	sess, _ := ctx.Value(sessionKey{}).(*session)
+	return sess
+}
+
+func (sess *session) addLinkHashes(linkHashes ...*types.Bytes32) {
+	if sess == nil {
+		return
+	}
+
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+
+	for _, lh := range linkHashes {
+		sess.linkHashes[lh.String()] = struct{}{}
+	}
+}
+
+func (sess *session) hasLinkHash(linkHash *types.Bytes32) bool {
+	if sess == nil {
+		return false
+	}
+
+	sess.mutex.RLock()
+	defer sess.mutex.RUnlock()
+
+	_, ok := sess.linkHashes[linkHash.String()]
+	return ok
+}
+
+func (sess *session) hasWritten() bool {
+	if sess == nil {
+		return false
+	}
+
+	sess.mutex.RLock()
+	defer sess.mutex.RUnlock()
+
+	return len(sess.linkHashes) > 0
+}
+
+// Batch wraps a batch of the primary store to record its links in the
+// session once it is written.
+type Batch struct {
+	store.Batch
+
+	session    *session
+	linkHashes []*types.Bytes32
+}
+
+// CreateLink implements github.com/stratumn/go-indigocore/store.LinkWriter.CreateLink.
+func (b *Batch) CreateLink(ctx context.Context, link *cs.Link) (*types.Bytes32, error) {
+	lh, err := b.Batch.CreateLink(ctx, link)
+	if err == nil {
+		b.linkHashes = append(b.linkHashes, lh)
+	}
+	return lh, err
+}
+
+// Write implements github.com/stratumn/go-indigocore/store.Batch.Write.
+func (b *Batch) Write(ctx context.Context) error {
+	if err := b.Batch.Write(ctx); err != nil {
+		return err
+	}
+
+	b.session.addLinkHashes(b.linkHashes...)
+	return nil
+}
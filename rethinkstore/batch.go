@@ -0,0 +1,148 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rethinkstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/stratumn/go-indigocore/bufferedbatch"
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/monitoring"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/types"
+	"go.opencensus.io/trace"
+)
+
+// Batch is the type that implements github.com/stratumn/go-indigocore/store.Batch.
+type Batch struct {
+	*bufferedbatch.Batch
+
+	originalStore *Store
+}
+
+// NewBatch creates a new Batch
+func NewBatch(ctx context.Context, a *Store) *Batch {
+	return &Batch{
+		Batch:         bufferedbatch.NewBatch(ctx, a),
+		originalStore: a,
+	}
+}
+
+// CreateLink implements github.com/stratumn/go-indigocore/store.LinkWriter.CreateLink.
+func (b *Batch) CreateLink(ctx context.Context, link *cs.Link) (*types.Bytes32, error) {
+	formatLink(link)
+	return b.Batch.CreateLink(ctx, link)
+}
+
+// Write implements github.com/stratumn/go-indigocore/store.Batch.Write.
+// The links added by the batch are deleted if one of them can't be saved.
+func (b *Batch) Write(ctx context.Context) (err error) {
+	ctx, span := trace.StartSpan(ctx, "rethinkstore/batch/Write")
+	defer monitoring.SetSpanStatusAndEnd(span, err)
+
+	a := b.originalStore
+	if err = a.createLinks(b.Links); err != nil {
+		return
+	}
+
+	if len(b.Links) > 0 {
+		linksEvent := store.NewSavedLinks(b.Links...)
+		for _, c := range a.eventChans {
+			c <- linksEvent
+		}
+	}
+
+	return
+}
+
+// createLinks saves the links of a batch.
+// RethinkDB doesn't have transactions, so if one of the links can't be
+// saved, the links that didn't exist before are deleted.
+func (a *Store) createLinks(links []*cs.Link) error {
+	var (
+		wrappers []*linkWrapper
+		seen     = map[string]struct{}{}
+	)
+
+	for _, link := range links {
+		w, err := newLinkWrapper(link)
+		if err != nil {
+			return err
+		}
+
+		if _, exists := seen[string(w.ID)]; exists {
+			continue
+		}
+		seen[string(w.ID)] = struct{}{}
+		wrappers = append(wrappers, w)
+	}
+
+	var added [][]byte
+	for i, w := range wrappers {
+		existed, err := a.linkExists(w.ID)
+		if err != nil {
+			return a.deleteLinks(added, err)
+		}
+
+		if err := a.writeLink(i, w); err != nil {
+			return a.deleteLinks(added, err)
+		}
+
+		if !existed {
+			added = append(added, w.ID)
+		}
+	}
+
+	return nil
+}
+
+func (a *Store) linkExists(id []byte) (bool, error) {
+	cur, err := a.links.Get(id).Run(a.session)
+	if err != nil {
+		return false, err
+	}
+	defer cur.Close()
+
+	return !cur.IsNil(), nil
+}
+
+func (a *Store) writeLink(i int, w *linkWrapper) error {
+	if a.testHookWriteLink != nil {
+		if err := a.testHookWriteLink(i); err != nil {
+			return err
+		}
+	}
+
+	return a.links.Get(w.ID).Replace(w).Exec(a.session)
+}
+
+// deleteLinks deletes the links added by a failed batch and returns the
+// error that made it fail, along with the links that couldn't be deleted.
+func (a *Store) deleteLinks(ids [][]byte, cause error) error {
+	var failed []string
+	for _, id := range ids {
+		if err := a.links.Get(id).Delete().Exec(a.session); err != nil {
+			failed = append(failed, fmt.Sprintf("%x (%s)", id, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%s, and links could not be deleted: %s", cause, strings.Join(failed, ", "))
+	}
+
+	return cause
+}
@@ -19,13 +19,11 @@ package rethinkstore
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
-	"github.com/stratumn/go-indigocore/bufferedbatch"
 	"github.com/stratumn/go-indigocore/cs"
 	"github.com/stratumn/go-indigocore/store"
 	"github.com/stratumn/go-indigocore/types"
@@ -94,6 +92,10 @@ type Store struct {
 	links      rethink.Term
 	evidences  rethink.Term
 	values     rethink.Term
+
+	// testHookWriteLink is called by tests before the i-th link of a batch
+	// is saved, to make the write fail.
+	testHookWriteLink func(i int) error
 }
 
 type linkWrapper struct {
@@ -188,16 +190,33 @@ func formatLink(link *cs.Link) {
 
 // CreateLink implements github.com/stratumn/go-indigocore/store.LinkWriter.CreateLink.
 func (a *Store) CreateLink(ctx context.Context, link *cs.Link) (*types.Bytes32, error) {
-	prevLinkHash := link.Meta.GetPrevLinkHash()
-
 	formatLink(link)
 
+	w, err := newLinkWrapper(link)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.links.Get(w.ID).Replace(w).Exec(a.session); err != nil {
+		return nil, err
+	}
+
+	linkEvent := store.NewSavedLinks(link)
+
+	for _, c := range a.eventChans {
+		c <- linkEvent
+	}
+
+	return types.NewBytes32FromBytes(w.ID), nil
+}
+
+func newLinkWrapper(link *cs.Link) (*linkWrapper, error) {
 	linkHash, err := link.Hash()
 	if err != nil {
 		return nil, err
 	}
 
-	w := linkWrapper{
+	w := &linkWrapper{
 		ID:        linkHash[:],
 		Content:   link,
 		Priority:  link.Meta.Priority,
@@ -207,21 +226,11 @@ func (a *Store) CreateLink(ctx context.Context, link *cs.Link) (*types.Bytes32,
 		Process:   link.Meta.Process,
 	}
 
-	if prevLinkHash != nil {
+	if prevLinkHash := link.Meta.GetPrevLinkHash(); prevLinkHash != nil {
 		w.PrevLinkHash = prevLinkHash[:]
 	}
 
-	if err := a.links.Get(linkHash).Replace(&w).Exec(a.session); err != nil {
-		return nil, err
-	}
-
-	linkEvent := store.NewSavedLinks(link)
-
-	for _, c := range a.eventChans {
-		c <- linkEvent
-	}
-
-	return linkHash, nil
+	return w, nil
 }
 
 // GetSegment implements github.com/stratumn/go-indigocore/store.SegmentReader.GetSegment.
@@ -475,6 +484,24 @@ func (a *Store) SetValue(ctx context.Context, key, value []byte) error {
 	return a.values.Get(key).Replace(&v).Exec(a.session)
 }
 
+// CreateValue implements github.com/stratumn/go-indigocore/store.KeyValueCreator.CreateValue.
+func (a *Store) CreateValue(ctx context.Context, key, value []byte) (bool, error) {
+	v := &valueWrapper{
+		ID:    key,
+		Value: value,
+	}
+
+	res, err := a.values.Insert(v, rethink.InsertOpts{Conflict: "error"}).RunWrite(a.session)
+	if res.Inserted == 1 {
+		return true, nil
+	}
+	if res.Errors > 0 && strings.HasPrefix(res.FirstError, "Duplicate primary key") {
+		return false, nil
+	}
+
+	return false, err
+}
+
 // DeleteValue implements github.com/stratumn/go-indigocore/store.KeyValueStore.DeleteValue.
 func (a *Store) DeleteValue(ctx context.Context, key []byte) ([]byte, error) {
 	res, err := a.values.
@@ -500,23 +527,9 @@ func (a *Store) DeleteValue(ctx context.Context, key []byte) ([]byte, error) {
 	return w.Value, nil
 }
 
-type rethinkBufferedBatch struct {
-	*bufferedbatch.Batch
-}
-
-// CreateLink implements github.com/stratumn/go-indigocore/store.LinkWriter.CreateLink.
-func (b *rethinkBufferedBatch) CreateLink(ctx context.Context, link *cs.Link) (*types.Bytes32, error) {
-	formatLink(link)
-	return b.Batch.CreateLink(ctx, link)
-}
-
 // NewBatch implements github.com/stratumn/go-indigocore/store.Adapter.NewBatch.
 func (a *Store) NewBatch(ctx context.Context) (store.Batch, error) {
-	bbBatch := bufferedbatch.NewBatch(ctx, a)
-	if bbBatch == nil {
-		return nil, errors.New("cannot create underlying batch")
-	}
-	return &rethinkBufferedBatch{bbBatch}, nil
+	return NewBatch(ctx, a), nil
 }
 
 // Create creates the database tables and indexes.
@@ -54,6 +54,7 @@ func TestStore(t *testing.T) {
 		NewKeyValueStore:  createKeyValueStore,
 		Free:              freeAdapter,
 		FreeKeyValueStore: freeKeyValueStore,
+		FailWrites:        failWrites,
 	}
 
 	factory.RunStoreTests(t)
@@ -100,6 +101,10 @@ func freeKeyValueStore(a store.KeyValueStore) {
 	freeStore(a.(*Store))
 }
 
+func failWrites(a store.Adapter, n int) {
+	a.(*Store).testHookWriteLink = storetestcases.FailingWrite(n)
+}
+
 func createAdapterTMPop() (store.Adapter, store.KeyValueStore, error) {
 	a, err := createStore()
 	return a, a, err
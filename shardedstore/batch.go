@@ -0,0 +1,100 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shardedstore
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/stratumn/go-indigocore/bufferedbatch"
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/monitoring"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/types"
+
+	"go.opencensus.io/trace"
+)
+
+// ErrCrossShardBatch is returned when a batch would contain links stored
+// in different adapters, since they couldn't be written atomically.
+var ErrCrossShardBatch = errors.New("batch links must be stored in a single shard")
+
+// Batch is the type that implements github.com/stratumn/go-indigocore/store.Batch.
+// All the links of a batch must be stored in the same adapter.
+type Batch struct {
+	*bufferedbatch.Batch
+
+	originalShardedStore *Store
+	adapter              store.Adapter
+}
+
+// NewBatch creates a new Batch
+func NewBatch(ctx context.Context, s *Store) *Batch {
+	return &Batch{
+		Batch:                bufferedbatch.NewBatch(ctx, s),
+		originalShardedStore: s,
+	}
+}
+
+// CreateLink implements github.com/stratumn/go-indigocore/store.LinkWriter.CreateLink.
+// It returns ErrCrossShardBatch if the link isn't stored in the same
+// adapter as the links already in the batch.
+func (b *Batch) CreateLink(ctx context.Context, link *cs.Link) (*types.Bytes32, error) {
+	a := b.originalShardedStore.shard(link.Meta.Process)
+	if b.adapter != nil && b.adapter != a {
+		return nil, ErrCrossShardBatch
+	}
+
+	linkHash, err := b.Batch.CreateLink(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+
+	b.adapter = a
+	return linkHash, nil
+}
+
+// Write implements github.com/stratumn/go-indigocore/store.Batch.Write.
+// The links are written atomically in a batch of their adapter.
+func (b *Batch) Write(ctx context.Context) (err error) {
+	ctx, span := trace.StartSpan(ctx, "shardedstore/batch/Write")
+	defer monitoring.SetSpanStatusAndEnd(span, err)
+
+	if len(b.Links) == 0 {
+		return
+	}
+
+	batch, err := b.adapter.NewBatch(ctx)
+	if err != nil {
+		return
+	}
+
+	for _, link := range b.Links {
+		if _, err = batch.CreateLink(ctx, link); err != nil {
+			return
+		}
+	}
+
+	if err = batch.Write(ctx); err != nil {
+		return
+	}
+
+	for _, link := range b.Links {
+		linkHash, _ := link.Hash()
+		b.originalShardedStore.locations.add(linkHash, b.adapter)
+	}
+
+	return
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shardedstore
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/types"
+)
+
+// locations is a fixed-size least-recently-used cache of the adapters
+// storing links. Links never move between adapters, so entries don't need
+// to be invalidated.
+type locations struct {
+	mutex sync.Mutex
+	size  int
+	ll    *list.List
+	items map[types.Bytes32]*list.Element
+}
+
+type location struct {
+	linkHash types.Bytes32
+	adapter  store.Adapter
+}
+
+func newLocations(size int) *locations {
+	return &locations{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[types.Bytes32]*list.Element),
+	}
+}
+
+// get returns the adapter storing a link and marks it as recently used.
+func (l *locations) get(linkHash *types.Bytes32) (store.Adapter, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if e, ok := l.items[*linkHash]; ok {
+		l.ll.MoveToFront(e)
+		return e.Value.(*location).adapter, true
+	}
+	return nil, false
+}
+
+// add records the adapter storing a link, evicting the least recently used
+// entry if the cache is full.
+func (l *locations) add(linkHash *types.Bytes32, a store.Adapter) {
+	if linkHash == nil {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if e, ok := l.items[*linkHash]; ok {
+		l.ll.MoveToFront(e)
+		return
+	}
+
+	l.items[*linkHash] = l.ll.PushFront(&location{linkHash: *linkHash, adapter: a})
+
+	if l.ll.Len() > l.size {
+		oldest := l.ll.Back()
+		l.ll.Remove(oldest)
+		delete(l.items, oldest.Value.(*location).linkHash)
+	}
+}
@@ -0,0 +1,532 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package shardedstore implements a store adapter that partitions links
+// between several underlying adapters according to their process.
+//
+// Each link is written to the adapter configured for its process, or to the
+// default adapter if its process isn't configured. Reads that target a
+// single process are sent to its adapter. Other reads fan out to all the
+// adapters and their results are merged. The adapters storing links are
+// cached, so that reads and evidences of a known link go to its adapter.
+//
+// Evidences are stored alongside their segment. Key-value pairs are stored
+// in the default adapter.
+//
+// Batches are written atomically by a single adapter, so a batch can't mix
+// links of processes stored in different adapters.
+package shardedstore
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/types"
+)
+
+const (
+	// Name is the name set in the store's information.
+	Name = "sharded"
+
+	// Description is the description set in the store's information.
+	Description = "Indigo's Sharded Store"
+
+	// DefaultLocationsCacheSize is the default number of link hashes whose
+	// adapter is cached.
+	DefaultLocationsCacheSize = 10000
+)
+
+var (
+	// ErrNoDefault is returned when no default adapter is configured.
+	ErrNoDefault = errors.New("a default store is required")
+
+	// ErrNoKeyValueStore is returned by key-value operations when the
+	// default adapter doesn't implement store.KeyValueStore.
+	ErrNoKeyValueStore = errors.New("default store is not a key-value store")
+)
+
+// Config contains configuration options for the store.
+type Config struct {
+	// Shards maps process names to the adapter storing their links.
+	// Several processes can share the same adapter.
+	Shards map[string]store.Adapter
+
+	// Default is the adapter storing the links of processes that don't
+	// have a shard, and key-value pairs.
+	Default store.Adapter
+
+	// LocationsCacheSize is the maximum number of link hashes whose
+	// adapter is cached. Defaults to DefaultLocationsCacheSize.
+	LocationsCacheSize int
+}
+
+// Info is the info returned by GetInfo.
+type Info struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Default     interface{}            `json:"default"`
+	Shards      map[string]interface{} `json:"shards"`
+}
+
+// Store is the type that implements github.com/stratumn/go-indigocore/store.Adapter.
+type Store struct {
+	shards    map[string]store.Adapter
+	def       store.Adapter
+	adapters  []store.Adapter
+	locations *locations
+}
+
+// New creates a store that routes links to the configured adapters.
+func New(config *Config) (*Store, error) {
+	if config.Default == nil {
+		return nil, ErrNoDefault
+	}
+
+	locationsSize := config.LocationsCacheSize
+	if locationsSize <= 0 {
+		locationsSize = DefaultLocationsCacheSize
+	}
+
+	s := &Store{
+		shards:    make(map[string]store.Adapter, len(config.Shards)),
+		def:       config.Default,
+		adapters:  []store.Adapter{config.Default},
+		locations: newLocations(locationsSize),
+	}
+
+	processes := make([]string, 0, len(config.Shards))
+	for process := range config.Shards {
+		processes = append(processes, process)
+	}
+	sort.Strings(processes)
+
+	for _, process := range processes {
+		a := config.Shards[process]
+		s.shards[process] = a
+		if !s.hasAdapter(a) {
+			s.adapters = append(s.adapters, a)
+		}
+	}
+
+	return s, nil
+}
+
+func (s *Store) hasAdapter(a store.Adapter) bool {
+	for _, b := range s.adapters {
+		if a == b {
+			return true
+		}
+	}
+	return false
+}
+
+// shard returns the adapter storing the links of a process.
+func (s *Store) shard(process string) store.Adapter {
+	if a, ok := s.shards[process]; ok {
+		return a
+	}
+	return s.def
+}
+
+// fanOut calls fn concurrently on every adapter and returns the first error.
+func (s *Store) fanOut(fn func(i int, a store.Adapter) error) error {
+	errs := make([]error, len(s.adapters))
+
+	var wg sync.WaitGroup
+	for i, a := range s.adapters {
+		wg.Add(1)
+		go func(i int, a store.Adapter) {
+			defer wg.Done()
+			errs[i] = fn(i, a)
+		}(i, a)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// locate returns the adapter storing a segment, or the default adapter if
+// no adapter has it. It only fans out if the location of the segment isn't
+// cached.
+func (s *Store) locate(ctx context.Context, linkHash *types.Bytes32) (store.Adapter, error) {
+	if a, ok := s.locations.get(linkHash); ok {
+		return a, nil
+	}
+
+	a, _, err := s.find(ctx, linkHash)
+	return a, err
+}
+
+// find fans out to all the adapters to get a segment and caches the
+// adapter storing it. It returns the default adapter if no adapter has it.
+func (s *Store) find(ctx context.Context, linkHash *types.Bytes32) (store.Adapter, *cs.Segment, error) {
+	segments := make([]*cs.Segment, len(s.adapters))
+	err := s.fanOut(func(i int, a store.Adapter) (err error) {
+		segments[i], err = a.GetSegment(ctx, linkHash)
+		return
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i, segment := range segments {
+		if segment != nil {
+			s.locations.add(linkHash, s.adapters[i])
+			return s.adapters[i], segment, nil
+		}
+	}
+
+	return s.def, nil, nil
+}
+
+// GetInfo implements github.com/stratumn/go-indigocore/store.Adapter.GetInfo.
+func (s *Store) GetInfo(ctx context.Context) (interface{}, error) {
+	info := &Info{
+		Name:        Name,
+		Description: Description,
+		Shards:      make(map[string]interface{}, len(s.shards)),
+	}
+
+	var err error
+	if info.Default, err = s.def.GetInfo(ctx); err != nil {
+		return nil, err
+	}
+
+	for process, a := range s.shards {
+		if info.Shards[process], err = a.GetInfo(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return info, nil
+}
+
+// AddStoreEventChannel implements
+// github.com/stratumn/go-indigocore/store.Adapter.AddStoreEventChannel.
+// The channel receives the events of all the adapters.
+func (s *Store) AddStoreEventChannel(eventChan chan *store.Event) {
+	for _, a := range s.adapters {
+		a.AddStoreEventChannel(eventChan)
+	}
+}
+
+// NewBatch implements github.com/stratumn/go-indigocore/store.Adapter.NewBatch.
+func (s *Store) NewBatch(ctx context.Context) (store.Batch, error) {
+	return NewBatch(ctx, s), nil
+}
+
+/********** Store writer implementation **********/
+
+// CreateLink implements github.com/stratumn/go-indigocore/store.LinkWriter.CreateLink.
+func (s *Store) CreateLink(ctx context.Context, link *cs.Link) (*types.Bytes32, error) {
+	a := s.shard(link.Meta.Process)
+	linkHash, err := a.CreateLink(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+
+	s.locations.add(linkHash, a)
+	return linkHash, nil
+}
+
+// CreateLinks implements github.com/stratumn/go-indigocore/store.LinksWriter.CreateLinks.
+// Links are grouped by adapter and results are returned in the order of
+// the input links.
+func (s *Store) CreateLinks(ctx context.Context, links []*cs.Link, mode store.WriteMode) ([]*store.LinkResult, error) {
+	groups := make([][]int, len(s.adapters))
+	for i, link := range links {
+		a := s.shard(link.Meta.Process)
+		for j, b := range s.adapters {
+			if a == b {
+				groups[j] = append(groups[j], i)
+				break
+			}
+		}
+	}
+
+	res := make([]*store.LinkResult, len(links))
+	err := s.fanOut(func(j int, a store.Adapter) error {
+		if len(groups[j]) == 0 {
+			return nil
+		}
+
+		group := make([]*cs.Link, len(groups[j]))
+		for k, i := range groups[j] {
+			group[k] = links[i]
+		}
+
+		groupRes, err := store.CreateLinks(ctx, a, group, mode)
+		if err != nil {
+			return err
+		}
+
+		for k, i := range groups[j] {
+			res[i] = groupRes[k]
+			if r := groupRes[k]; r != nil && r.LinkHash != nil && r.Error == "" {
+				s.locations.add(r.LinkHash, a)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// AddEvidence implements github.com/stratumn/go-indigocore/store.EvidenceWriter.AddEvidence.
+// The evidence is added to the adapter storing the segment.
+func (s *Store) AddEvidence(ctx context.Context, linkHash *types.Bytes32, evidence *cs.Evidence) error {
+	a, err := s.locate(ctx, linkHash)
+	if err != nil {
+		return err
+	}
+
+	return a.AddEvidence(ctx, linkHash, evidence)
+}
+
+/********** Store reader implementation **********/
+
+// GetSegment implements github.com/stratumn/go-indigocore/store.SegmentReader.GetSegment.
+func (s *Store) GetSegment(ctx context.Context, linkHash *types.Bytes32) (*cs.Segment, error) {
+	if a, ok := s.locations.get(linkHash); ok {
+		return a.GetSegment(ctx, linkHash)
+	}
+
+	_, segment, err := s.find(ctx, linkHash)
+	return segment, err
+}
+
+// FindSegments implements github.com/stratumn/go-indigocore/store.SegmentReader.FindSegments.
+func (s *Store) FindSegments(ctx context.Context, filter *store.SegmentFilter) (cs.SegmentSlice, error) {
+	if filter.Process != "" {
+		return s.shard(filter.Process).FindSegments(ctx, filter)
+	}
+
+	results := make([]cs.SegmentSlice, len(s.adapters))
+	err := s.fanOut(func(i int, a store.Adapter) (err error) {
+		results[i], err = findSegmentPages(filter.Pagination, func(p store.Pagination) (cs.SegmentSlice, error) {
+			f := *filter
+			f.Pagination = p
+			return a.FindSegments(ctx, &f)
+		})
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeSegments(filter.Pagination, results), nil
+}
+
+// GetMapIDs implements github.com/stratumn/go-indigocore/store.SegmentReader.GetMapIDs.
+func (s *Store) GetMapIDs(ctx context.Context, filter *store.MapFilter) ([]string, error) {
+	if filter.Process != "" {
+		return s.shard(filter.Process).GetMapIDs(ctx, filter)
+	}
+
+	results := make([][]string, len(s.adapters))
+	err := s.fanOut(func(i int, a store.Adapter) (err error) {
+		for _, p := range fanOutPages(filter.Pagination) {
+			f := *filter
+			f.Pagination = p
+
+			mapIDs, err := a.GetMapIDs(ctx, &f)
+			if err != nil {
+				return err
+			}
+
+			results[i] = append(results[i], mapIDs...)
+			if len(mapIDs) < p.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{})
+	mapIDs := []string{}
+	for _, result := range results {
+		for _, mapID := range result {
+			if _, ok := seen[mapID]; !ok {
+				seen[mapID] = struct{}{}
+				mapIDs = append(mapIDs, mapID)
+			}
+		}
+	}
+
+	sort.Strings(mapIDs)
+	return filter.Pagination.PaginateStrings(mapIDs), nil
+}
+
+// GetEvidences implements github.com/stratumn/go-indigocore/store.EvidenceReader.GetEvidences.
+func (s *Store) GetEvidences(ctx context.Context, linkHash *types.Bytes32) (*cs.Evidences, error) {
+	a, err := s.locate(ctx, linkHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.GetEvidences(ctx, linkHash)
+}
+
+// GetLinkHeight implements github.com/stratumn/go-indigocore/store.LinkHeightReader.GetLinkHeight.
+func (s *Store) GetLinkHeight(ctx context.Context, linkHash *types.Bytes32) (int64, error) {
+	a, err := s.locate(ctx, linkHash)
+	if err != nil {
+		return 0, err
+	}
+
+	return store.GetLinkHeight(ctx, a, linkHash)
+}
+
+// IsSearchable implements
+// github.com/stratumn/go-indigocore/store.SearchChecker.IsSearchable.
+// All the shards must be searchable.
+func (s *Store) IsSearchable() bool {
+	for _, a := range s.adapters {
+		if !store.IsSearchable(a) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Search implements github.com/stratumn/go-indigocore/store.Searcher.Search.
+func (s *Store) Search(ctx context.Context, query *store.SearchQuery) (cs.SegmentSlice, error) {
+	if query.Process != "" {
+		return store.Search(ctx, s.shard(query.Process), query)
+	}
+
+	results := make([]cs.SegmentSlice, len(s.adapters))
+	err := s.fanOut(func(i int, a store.Adapter) (err error) {
+		results[i], err = findSegmentPages(query.Pagination, func(p store.Pagination) (cs.SegmentSlice, error) {
+			q := *query
+			q.Pagination = p
+			return store.Search(ctx, a, &q)
+		})
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeSegments(query.Pagination, results), nil
+}
+
+// fanOutPages returns the pages to request from each adapter so that the
+// merged results contain the requested page. The first Offset+Limit results
+// are needed, and they are requested in pages of at most store.MaxLimit
+// since adapters may reject larger limits.
+func fanOutPages(p store.Pagination) []store.Pagination {
+	var pages []store.Pagination
+	for offset, total := 0, p.Offset+p.Limit; offset < total; offset += store.MaxLimit {
+		limit := total - offset
+		if limit > store.MaxLimit {
+			limit = store.MaxLimit
+		}
+		pages = append(pages, store.Pagination{Offset: offset, Limit: limit})
+	}
+	return pages
+}
+
+// findSegmentPages calls find with the pages returned by fanOutPages until
+// a page isn't full, and returns all the segments found.
+func findSegmentPages(p store.Pagination, find func(store.Pagination) (cs.SegmentSlice, error)) (cs.SegmentSlice, error) {
+	var segments cs.SegmentSlice
+	for _, page := range fanOutPages(p) {
+		found, err := find(page)
+		if err != nil {
+			return nil, err
+		}
+
+		segments = append(segments, found...)
+		if len(found) < page.Limit {
+			break
+		}
+	}
+	return segments, nil
+}
+
+// mergeSegments sorts the segments returned by the adapters and returns the
+// requested page.
+func mergeSegments(p store.Pagination, results []cs.SegmentSlice) cs.SegmentSlice {
+	var segments cs.SegmentSlice
+	for _, result := range results {
+		segments = append(segments, result...)
+	}
+
+	sort.Sort(segments)
+	return p.PaginateSegments(segments)
+}
+
+/********** github.com/stratumn/go-indigocore/store.KeyValueStore implementation **********/
+
+func (s *Store) keyValueStore() (store.KeyValueStore, error) {
+	kv, ok := s.def.(store.KeyValueStore)
+	if !ok {
+		return nil, ErrNoKeyValueStore
+	}
+	return kv, nil
+}
+
+// GetValue implements github.com/stratumn/go-indigocore/store.KeyValueStore.GetValue.
+func (s *Store) GetValue(ctx context.Context, key []byte) ([]byte, error) {
+	kv, err := s.keyValueStore()
+	if err != nil {
+		return nil, err
+	}
+	return kv.GetValue(ctx, key)
+}
+
+// SetValue implements github.com/stratumn/go-indigocore/store.KeyValueStore.SetValue.
+func (s *Store) SetValue(ctx context.Context, key, value []byte) error {
+	kv, err := s.keyValueStore()
+	if err != nil {
+		return err
+	}
+	return kv.SetValue(ctx, key, value)
+}
+
+// CreateValue implements github.com/stratumn/go-indigocore/store.KeyValueCreator.CreateValue.
+func (s *Store) CreateValue(ctx context.Context, key, value []byte) (bool, error) {
+	kv, err := s.keyValueStore()
+	if err != nil {
+		return false, err
+	}
+	return store.CreateValue(ctx, kv, key, value)
+}
+
+// DeleteValue implements github.com/stratumn/go-indigocore/store.KeyValueStore.DeleteValue.
+func (s *Store) DeleteValue(ctx context.Context, key []byte) ([]byte, error) {
+	kv, err := s.keyValueStore()
+	if err != nil {
+		return nil, err
+	}
+	return kv.DeleteValue(ctx, key)
+}
@@ -0,0 +1,406 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shardedstore
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/cs/cstesting"
+	"github.com/stratumn/go-indigocore/dummystore"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/store/storetestcases"
+	"github.com/stratumn/go-indigocore/store/storetesting"
+	"github.com/stratumn/go-indigocore/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newStore(t *testing.T) (*Store, *dummystore.DummyStore, *dummystore.DummyStore) {
+	def := dummystore.New(&dummystore.Config{})
+	hot := dummystore.New(&dummystore.Config{})
+	s, err := New(&Config{
+		Shards: map[string]store.Adapter{
+			"hot":     hot,
+			"hotter":  hot,
+			"default": def,
+		},
+		Default: def,
+	})
+	require.NoError(t, err)
+	return s, def, hot
+}
+
+func createLink(t *testing.T, a store.Adapter, process string, priority float64) *cs.Link {
+	link := cstesting.RandomLink()
+	link.Meta.Process = process
+	link.Meta.Priority = priority
+	_, err := a.CreateLink(context.Background(), link)
+	require.NoError(t, err)
+	return link
+}
+
+func TestShardedStore(t *testing.T) {
+	factory := storetestcases.Factory{
+		New: func() (store.Adapter, error) {
+			return New(&Config{
+				Shards: map[string]store.Adapter{
+					"Foo": dummystore.New(&dummystore.Config{}),
+					"Bar": dummystore.New(&dummystore.Config{}),
+				},
+				Default: dummystore.New(&dummystore.Config{}),
+			})
+		},
+		NewKeyValueStore: func() (store.KeyValueStore, error) {
+			return New(&Config{Default: dummystore.New(&dummystore.Config{})})
+		},
+	}
+
+	factory.RunStoreTests(t)
+	factory.RunKeyValueStoreTests(t)
+}
+
+func TestNew_noDefault(t *testing.T) {
+	_, err := New(&Config{})
+	assert.EqualError(t, err, ErrNoDefault.Error())
+}
+
+func TestNew_adapters(t *testing.T) {
+	s, _, _ := newStore(t)
+	assert.Len(t, s.adapters, 2, "shared adapters should be queried once")
+}
+
+func TestCreateLink_routing(t *testing.T) {
+	ctx := context.Background()
+	s, def, hot := newStore(t)
+
+	hotLink := createLink(t, s, "hotter", 0)
+	coldLink := createLink(t, s, "cold", 0)
+
+	for _, test := range []struct {
+		link    *cs.Link
+		in, out store.Adapter
+	}{
+		{hotLink, hot, def},
+		{coldLink, def, hot},
+	} {
+		lh, _ := test.link.Hash()
+
+		got, err := test.in.GetSegment(ctx, lh)
+		require.NoError(t, err)
+		assert.NotNil(t, got)
+
+		got, err = test.out.GetSegment(ctx, lh)
+		require.NoError(t, err)
+		assert.Nil(t, got)
+
+		got, err = s.GetSegment(ctx, lh)
+		require.NoError(t, err)
+		assert.NotNil(t, got)
+	}
+}
+
+func TestCreateLinks_routing(t *testing.T) {
+	ctx := context.Background()
+	s, def, hot := newStore(t)
+
+	links := []*cs.Link{cstesting.RandomLink(), cstesting.RandomLink(), cstesting.RandomLink()}
+	links[1].Meta.Process = "hot"
+
+	res, err := s.CreateLinks(ctx, links, store.WriteModeCommit)
+	require.NoError(t, err)
+	require.Len(t, res, len(links))
+
+	for i, link := range links {
+		lh, _ := link.Hash()
+		assert.Equal(t, lh, res[i].LinkHash)
+	}
+
+	got, err := hot.GetSegment(ctx, res[1].LinkHash)
+	require.NoError(t, err)
+	assert.NotNil(t, got)
+
+	got, err = def.GetSegment(ctx, res[2].LinkHash)
+	require.NoError(t, err)
+	assert.NotNil(t, got)
+}
+
+func TestBatch_routing(t *testing.T) {
+	ctx := context.Background()
+	s, def, hot := newStore(t)
+
+	b, err := s.NewBatch(ctx)
+	require.NoError(t, err)
+
+	hotLink := cstesting.RandomLink()
+	hotLink.Meta.Process = "hot"
+	hotHash, err := b.CreateLink(ctx, hotLink)
+	require.NoError(t, err)
+	hotterLink := cstesting.RandomLink()
+	hotterLink.Meta.Process = "hotter"
+	hotterHash, err := b.CreateLink(ctx, hotterLink)
+	require.NoError(t, err)
+
+	require.NoError(t, b.Write(ctx))
+
+	for _, lh := range []*types.Bytes32{hotHash, hotterHash} {
+		got, err := hot.GetSegment(ctx, lh)
+		require.NoError(t, err)
+		assert.NotNil(t, got)
+
+		got, err = def.GetSegment(ctx, lh)
+		require.NoError(t, err)
+		assert.Nil(t, got)
+	}
+}
+
+func TestBatch_crossShard(t *testing.T) {
+	ctx := context.Background()
+	s, def, _ := newStore(t)
+
+	b, err := s.NewBatch(ctx)
+	require.NoError(t, err)
+
+	coldHash, err := b.CreateLink(ctx, cstesting.RandomLink())
+	require.NoError(t, err)
+	hotLink := cstesting.RandomLink()
+	hotLink.Meta.Process = "hot"
+	_, err = b.CreateLink(ctx, hotLink)
+	assert.EqualError(t, err, ErrCrossShardBatch.Error())
+
+	require.NoError(t, b.Write(ctx))
+
+	got, err := def.GetSegment(ctx, coldHash)
+	require.NoError(t, err)
+	assert.NotNil(t, got)
+}
+
+func TestBatch_writeError(t *testing.T) {
+	ctx := context.Background()
+	hot := &storetesting.MockAdapter{}
+	hot.MockNewBatch.Fn = func() store.Batch {
+		b := &storetesting.MockBatch{}
+		b.MockWrite.Fn = func() error { return errors.New("unavailable") }
+		return b
+	}
+	s, err := New(&Config{
+		Shards:  map[string]store.Adapter{"hot": hot},
+		Default: dummystore.New(&dummystore.Config{}),
+	})
+	require.NoError(t, err)
+
+	b, err := s.NewBatch(ctx)
+	require.NoError(t, err)
+
+	hotLink := cstesting.RandomLink()
+	hotLink.Meta.Process = "hot"
+	hotHash, err := b.CreateLink(ctx, hotLink)
+	require.NoError(t, err)
+
+	assert.EqualError(t, b.Write(ctx), "unavailable")
+
+	_, ok := s.locations.get(hotHash)
+	assert.False(t, ok, "links of failed batches should not be located")
+}
+
+func TestAddEvidence_routing(t *testing.T) {
+	ctx := context.Background()
+	s, def, hot := newStore(t)
+
+	link := createLink(t, s, "hot", 0)
+	lh, _ := link.Hash()
+
+	require.NoError(t, s.AddEvidence(ctx, lh, cstesting.RandomEvidence()))
+
+	evidences, err := hot.GetEvidences(ctx, lh)
+	require.NoError(t, err)
+	assert.Len(t, *evidences, 1)
+
+	evidences, err = def.GetEvidences(ctx, lh)
+	require.NoError(t, err)
+	assert.Nil(t, evidences)
+
+	evidences, err = s.GetEvidences(ctx, lh)
+	require.NoError(t, err)
+	assert.Len(t, *evidences, 1)
+}
+
+func TestLocations(t *testing.T) {
+	ctx := context.Background()
+	def := dummystore.New(&dummystore.Config{})
+	hot := &storetesting.MockAdapter{}
+	s, err := New(&Config{
+		Shards:  map[string]store.Adapter{"hot": hot},
+		Default: def,
+	})
+	require.NoError(t, err)
+
+	link := createLink(t, s, "cold", 0)
+	lh, _ := link.Hash()
+
+	got, err := s.GetSegment(ctx, lh)
+	require.NoError(t, err)
+	assert.NotNil(t, got)
+	require.NoError(t, s.AddEvidence(ctx, lh, cstesting.RandomEvidence()))
+	_, err = s.GetEvidences(ctx, lh)
+	require.NoError(t, err)
+	assert.Equal(t, 0, hot.MockGetSegment.CalledCount, "written links should not fan out")
+
+	otherHash, err := def.CreateLink(ctx, cstesting.RandomLink())
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		got, err = s.GetSegment(ctx, otherHash)
+		require.NoError(t, err)
+		assert.NotNil(t, got)
+	}
+	assert.Equal(t, 1, hot.MockGetSegment.CalledCount, "found links should not fan out again")
+}
+
+func TestFindSegments_process(t *testing.T) {
+	ctx := context.Background()
+	s, _, _ := newStore(t)
+
+	createLink(t, s, "hot", 0.5)
+	createLink(t, s, "hotter", 0.5)
+	createLink(t, s, "cold", 0.5)
+
+	segments, err := s.FindSegments(ctx, &store.SegmentFilter{
+		Pagination: store.Pagination{Limit: store.DefaultLimit},
+		Process:    "hot",
+	})
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+	assert.Equal(t, "hot", segments[0].Link.Meta.Process)
+}
+
+func TestFindSegments_fanOut(t *testing.T) {
+	ctx := context.Background()
+	s, _, _ := newStore(t)
+
+	var all cs.SegmentSlice
+	for i := 0; i < 10; i++ {
+		process := "cold"
+		if i%3 == 0 {
+			process = "hot"
+		}
+		all = append(all, createLink(t, s, process, float64(i)).Segmentify())
+	}
+	sort.Sort(all)
+
+	for _, p := range []store.Pagination{
+		{Offset: 0, Limit: 20},
+		{Offset: 0, Limit: 4},
+		{Offset: 3, Limit: 4},
+		{Offset: 8, Limit: 4},
+		{Offset: 12, Limit: 4},
+	} {
+		segments, err := s.FindSegments(ctx, &store.SegmentFilter{Pagination: p})
+		require.NoError(t, err)
+
+		want := p.PaginateSegments(all)
+		require.Len(t, segments, len(want), "%+v", p)
+		for i := range want {
+			assert.Equal(t, want[i].GetLinkHashString(), segments[i].GetLinkHashString(), "%+v", p)
+		}
+	}
+}
+
+func TestFindSegments_fanOutMaxLimit(t *testing.T) {
+	ctx := context.Background()
+	def := &storetesting.MockAdapter{}
+	def.MockFindSegments.Fn = func(filter *store.SegmentFilter) (cs.SegmentSlice, error) {
+		require.True(t, filter.Limit <= store.MaxLimit, "filter.Limit")
+		segments := make(cs.SegmentSlice, filter.Limit)
+		for i := range segments {
+			segments[i] = cstesting.RandomLink().Segmentify()
+		}
+		return segments, nil
+	}
+	s, err := New(&Config{Default: def})
+	require.NoError(t, err)
+
+	p := store.Pagination{Offset: store.MaxLimit + 50, Limit: 20}
+	segments, err := s.FindSegments(ctx, &store.SegmentFilter{Pagination: p})
+	require.NoError(t, err)
+	assert.Len(t, segments, 20)
+	assert.Equal(t, 2, def.MockFindSegments.CalledCount)
+}
+
+func TestGetMapIDs_fanOut(t *testing.T) {
+	ctx := context.Background()
+	s, _, _ := newStore(t)
+
+	var mapIDs []string
+	for i := 0; i < 6; i++ {
+		process := "cold"
+		if i%2 == 0 {
+			process = "hot"
+		}
+		mapIDs = append(mapIDs, createLink(t, s, process, 0).Meta.MapID)
+	}
+
+	// A map spanning both shards should be returned once.
+	shared := cstesting.RandomLink()
+	shared.Meta.Process = "cold"
+	shared.Meta.MapID = mapIDs[0]
+	_, err := s.CreateLink(ctx, shared)
+	require.NoError(t, err)
+	sort.Strings(mapIDs)
+
+	got, err := s.GetMapIDs(ctx, &store.MapFilter{
+		Pagination: store.Pagination{Limit: store.DefaultLimit},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, mapIDs, got)
+
+	got, err = s.GetMapIDs(ctx, &store.MapFilter{
+		Pagination: store.Pagination{Offset: 2, Limit: 3},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, mapIDs[2:5], got)
+
+	got, err = s.GetMapIDs(ctx, &store.MapFilter{
+		Pagination: store.Pagination{Limit: store.DefaultLimit},
+		Process:    "hot",
+	})
+	require.NoError(t, err)
+	assert.Len(t, got, 3)
+}
+
+func TestKeyValue_default(t *testing.T) {
+	ctx := context.Background()
+	s, def, hot := newStore(t)
+
+	require.NoError(t, s.SetValue(ctx, []byte("key"), []byte("value")))
+
+	v, err := def.GetValue(ctx, []byte("key"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), v)
+
+	v, err = hot.GetValue(ctx, []byte("key"))
+	require.NoError(t, err)
+	assert.Nil(t, v)
+}
+
+func TestKeyValue_unavailable(t *testing.T) {
+	s, err := New(&Config{Default: &storetesting.MockAdapter{}})
+	require.NoError(t, err)
+
+	_, err = s.GetValue(context.Background(), []byte("key"))
+	assert.EqualError(t, err, ErrNoKeyValueStore.Error())
+}
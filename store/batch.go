@@ -0,0 +1,122 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/types"
+)
+
+// WriteMode tells when a batch write returns.
+// Stores that don't include links in blocks always write links
+// synchronously and ignore it.
+type WriteMode string
+
+const (
+	// WriteModeCommit returns once the links are committed.
+	WriteModeCommit WriteMode = "commit"
+
+	// WriteModeSync returns once the links are validated but before they
+	// are committed.
+	WriteModeSync WriteMode = "sync"
+
+	// WriteModeAsync returns as soon as the links are submitted, before
+	// they are validated.
+	WriteModeAsync WriteMode = "async"
+)
+
+// Statuses of a link written in a batch.
+const (
+	// LinkCommitted means the link is stored.
+	LinkCommitted = "committed"
+
+	// LinkPending means the link is submitted but not committed yet.
+	LinkPending = "pending"
+
+	// LinkRejected means the link was not stored.
+	LinkRejected = "rejected"
+)
+
+// ErrLinkHeightUnavailable is returned by GetLinkHeight when the store
+// doesn't include links in blocks.
+var ErrLinkHeightUnavailable = errors.New("store does not include links in blocks")
+
+// LinkResult is the result of writing one link of a batch.
+type LinkResult struct {
+	LinkHash *types.Bytes32 `json:"linkHash,omitempty"`
+	Status   string         `json:"status"`
+	Height   int64          `json:"height,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// LinksWriter is the interface for stores that can write many links more
+// efficiently than one by one.
+// Some stores will implement this interface, but not all.
+type LinksWriter interface {
+	// Create several links. Each link is validated independently and
+	// invalid links don't prevent the others from being written.
+	// Returns one result per link, in the same order.
+	CreateLinks(ctx context.Context, links []*cs.Link, mode WriteMode) ([]*LinkResult, error)
+}
+
+// LinkHeightReader is the interface for stores that include links in
+// blocks.
+// Some stores will implement this interface, but not all.
+type LinkHeightReader interface {
+	// Get the height of the block including a link.
+	// Returns zero if the link isn't included in a block yet.
+	GetLinkHeight(ctx context.Context, linkHash *types.Bytes32) (int64, error)
+}
+
+// CreateLinks writes several links to a store.
+// It uses the store's CreateLinks if it implements LinksWriter,
+// otherwise links are validated and created one by one.
+func CreateLinks(ctx context.Context, a Adapter, links []*cs.Link, mode WriteMode) ([]*LinkResult, error) {
+	if w, ok := a.(LinksWriter); ok {
+		return w.CreateLinks(ctx, links, mode)
+	}
+
+	results := make([]*LinkResult, len(links))
+	for i, link := range links {
+		if err := link.Validate(ctx, a.GetSegment); err != nil {
+			results[i] = &LinkResult{Status: LinkRejected, Error: err.Error()}
+			continue
+		}
+
+		linkHash, err := a.CreateLink(ctx, link)
+		if err != nil {
+			results[i] = &LinkResult{Status: LinkRejected, Error: err.Error()}
+			continue
+		}
+
+		results[i] = &LinkResult{LinkHash: linkHash, Status: LinkCommitted}
+	}
+
+	return results, nil
+}
+
+// GetLinkHeight returns the height of the block including a link if the
+// store implements LinkHeightReader, or ErrLinkHeightUnavailable.
+func GetLinkHeight(ctx context.Context, a Adapter, linkHash *types.Bytes32) (int64, error) {
+	r, ok := a.(LinkHeightReader)
+	if !ok {
+		return 0, ErrLinkHeightUnavailable
+	}
+
+	return r.GetLinkHeight(ctx, linkHash)
+}
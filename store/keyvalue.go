@@ -0,0 +1,45 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrCreateValueUnavailable is returned by CreateValue when a store can't
+// create values atomically.
+var ErrCreateValueUnavailable = errors.New("store does not support creating values")
+
+// KeyValueCreator is the interface for key-value stores that can atomically
+// set the value of a key only if it doesn't exist.
+// Some stores will implement this interface, but not all.
+type KeyValueCreator interface {
+	// Set the value of a key if it doesn't have one. Returns false if the
+	// key already exists, in which case its value is left untouched.
+	CreateValue(ctx context.Context, key []byte, value []byte) (bool, error)
+}
+
+// CreateValue sets the value of a key if it doesn't have one, using the
+// store's KeyValueCreator implementation. It returns
+// ErrCreateValueUnavailable if the store doesn't implement KeyValueCreator.
+func CreateValue(ctx context.Context, kv KeyValueStore, key []byte, value []byte) (bool, error) {
+	c, ok := kv.(KeyValueCreator)
+	if !ok {
+		return false, ErrCreateValueUnavailable
+	}
+
+	return c.CreateValue(ctx, key, value)
+}
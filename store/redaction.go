@@ -0,0 +1,184 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/types"
+)
+
+// stateOpeningsPrefix prefixes the keys of state openings saved in a
+// key-value store.
+const stateOpeningsPrefix = "stateopenings:"
+
+// stateOpeningsPurged is the value recorded in a key-value store in place
+// of the state openings of a link when they are purged.
+var stateOpeningsPurged = []byte("purged")
+
+var (
+	// ErrStateOpeningsUnavailable is returned when a store can't save
+	// state openings.
+	ErrStateOpeningsUnavailable = errors.New("store does not support state openings")
+
+	// ErrNoStateOpenings is returned when saving an empty list of state
+	// openings.
+	ErrNoStateOpenings = errors.New("no state openings")
+
+	// ErrLinkNotFound is returned when saving state openings of a link
+	// that doesn't exist.
+	ErrLinkNotFound = errors.New("link not found")
+
+	// ErrStateOpeningsExist is returned when saving state openings of a
+	// link that already has some.
+	ErrStateOpeningsExist = errors.New("state openings already exist")
+
+	// ErrStateOpeningsPurged is returned when saving state openings of a
+	// link whose state openings were purged.
+	ErrStateOpeningsPurged = errors.New("state openings were purged")
+)
+
+// StateOpeningStore is the interface for stores that keep the clear-text
+// values of the committed state fields of links apart from the links.
+// Some stores will implement this interface, but not all.
+//
+// See cs.Link.CommitState.
+type StateOpeningStore interface {
+	// Save the state openings of a link. Returns ErrStateOpeningsExist if
+	// the link already has state openings and ErrStateOpeningsPurged if
+	// they were purged: saved values can't be replaced.
+	SaveStateOpenings(ctx context.Context, linkHash *types.Bytes32, openings []*cs.StateOpening) error
+
+	// Get the state openings of a link. Returns an empty slice if they
+	// were purged or never saved.
+	GetStateOpenings(ctx context.Context, linkHash *types.Bytes32) ([]*cs.StateOpening, error)
+
+	// Purge the state openings of a link. The committed values can't be
+	// recovered afterwards but the link stays verifiable. The purge is
+	// recorded so that the values can't be saved again.
+	PurgeStateOpenings(ctx context.Context, linkHash *types.Bytes32) error
+}
+
+// SaveStateOpenings checks the state openings against the commitments of a
+// link and saves them. State openings can only be saved once per link.
+// It uses the store's StateOpeningStore implementation if it has one, or
+// its KeyValueStore implementation if it also implements KeyValueCreator,
+// so that concurrent saves can't replace each other.
+func SaveStateOpenings(ctx context.Context, a Adapter, linkHash *types.Bytes32, openings []*cs.StateOpening) error {
+	if len(openings) == 0 {
+		return ErrNoStateOpenings
+	}
+	for _, o := range openings {
+		if o == nil {
+			return ErrNoStateOpenings
+		}
+	}
+
+	segment, err := a.GetSegment(ctx, linkHash)
+	if err != nil {
+		return err
+	}
+	if segment == nil {
+		return ErrLinkNotFound
+	}
+
+	for _, o := range openings {
+		if err := o.Verify(&segment.Link); err != nil {
+			return err
+		}
+	}
+
+	if s, ok := a.(StateOpeningStore); ok {
+		return s.SaveStateOpenings(ctx, linkHash, openings)
+	}
+
+	if kv, ok := a.(KeyValueStore); ok {
+		value, err := json.Marshal(openings)
+		if err != nil {
+			return err
+		}
+
+		key := stateOpeningsKey(linkHash)
+		created, err := CreateValue(ctx, kv, key, value)
+		if err == ErrCreateValueUnavailable {
+			return ErrStateOpeningsUnavailable
+		}
+		if err != nil || created {
+			return err
+		}
+
+		prev, err := kv.GetValue(ctx, key)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(prev, stateOpeningsPurged) {
+			return ErrStateOpeningsPurged
+		}
+		return ErrStateOpeningsExist
+	}
+
+	return ErrStateOpeningsUnavailable
+}
+
+// GetStateOpenings returns the state openings of a link.
+// It uses the store's StateOpeningStore implementation if it has one, or
+// its KeyValueStore implementation.
+func GetStateOpenings(ctx context.Context, a Adapter, linkHash *types.Bytes32) ([]*cs.StateOpening, error) {
+	if s, ok := a.(StateOpeningStore); ok {
+		return s.GetStateOpenings(ctx, linkHash)
+	}
+
+	if kv, ok := a.(KeyValueStore); ok {
+		value, err := kv.GetValue(ctx, stateOpeningsKey(linkHash))
+		if err != nil {
+			return nil, err
+		}
+
+		openings := []*cs.StateOpening{}
+		if value == nil || bytes.Equal(value, stateOpeningsPurged) {
+			return openings, nil
+		}
+		if err := json.Unmarshal(value, &openings); err != nil {
+			return nil, err
+		}
+		return openings, nil
+	}
+
+	return nil, ErrStateOpeningsUnavailable
+}
+
+// PurgeStateOpenings deletes the state openings of a link and records the
+// purge, so the link's state openings can't be saved again.
+// It uses the store's StateOpeningStore implementation if it has one, or
+// its KeyValueStore implementation.
+func PurgeStateOpenings(ctx context.Context, a Adapter, linkHash *types.Bytes32) error {
+	if s, ok := a.(StateOpeningStore); ok {
+		return s.PurgeStateOpenings(ctx, linkHash)
+	}
+
+	if kv, ok := a.(KeyValueStore); ok {
+		return kv.SetValue(ctx, stateOpeningsKey(linkHash), stateOpeningsPurged)
+	}
+
+	return ErrStateOpeningsUnavailable
+}
+
+func stateOpeningsKey(linkHash *types.Bytes32) []byte {
+	return []byte(stateOpeningsPrefix + linkHash.String())
+}
@@ -0,0 +1,169 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/cs/cstesting"
+	"github.com/stratumn/go-indigocore/dummystore"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/store/storetesting"
+	"github.com/stratumn/go-indigocore/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createRedactedLink(t *testing.T, a store.Adapter) (*cs.Link, []*cs.StateOpening) {
+	link := cstesting.NewLinkBuilder().
+		WithState(map[string]interface{}{"name": "Alice", "city": "Paris"}).
+		Build()
+	openings, err := link.CommitState("name")
+	require.NoError(t, err)
+
+	_, err = a.CreateLink(context.Background(), link)
+	require.NoError(t, err)
+
+	return link, openings
+}
+
+func TestStateOpenings(t *testing.T) {
+	ctx := context.Background()
+	a := dummystore.New(&dummystore.Config{})
+	link, openings := createRedactedLink(t, a)
+	lh, _ := link.Hash()
+
+	got, err := store.GetStateOpenings(ctx, a, lh)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	require.NoError(t, store.SaveStateOpenings(ctx, a, lh, openings))
+
+	got, err = store.GetStateOpenings(ctx, a, lh)
+	require.NoError(t, err)
+	assert.Equal(t, openings, got)
+
+	revealed, err := link.RevealState(got)
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", revealed.State["name"])
+
+	err = store.SaveStateOpenings(ctx, a, lh, openings)
+	assert.Equal(t, store.ErrStateOpeningsExist, err, "state openings should not be replaced")
+
+	require.NoError(t, store.PurgeStateOpenings(ctx, a, lh))
+
+	got, err = store.GetStateOpenings(ctx, a, lh)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	err = store.SaveStateOpenings(ctx, a, lh, openings)
+	assert.Equal(t, store.ErrStateOpeningsPurged, err, "purged state openings should not be saved again")
+
+	segment, err := a.GetSegment(ctx, lh)
+	require.NoError(t, err)
+	assert.NoError(t, segment.Validate(ctx, nil), "purged link should still be verifiable")
+}
+
+func TestSaveStateOpenings_invalid(t *testing.T) {
+	ctx := context.Background()
+	a := dummystore.New(&dummystore.Config{})
+	link, openings := createRedactedLink(t, a)
+	lh, _ := link.Hash()
+
+	openings[0].Value = "Eve"
+	err := store.SaveStateOpenings(ctx, a, lh, openings)
+	assert.Equal(t, cs.ErrCommitmentMismatch, errors.Cause(err))
+
+	err = store.SaveStateOpenings(ctx, a, cstesting.RandomSegment().GetLinkHash(), openings)
+	assert.Equal(t, store.ErrLinkNotFound, err)
+}
+
+func TestSaveStateOpenings_empty(t *testing.T) {
+	ctx := context.Background()
+	a := dummystore.New(&dummystore.Config{})
+	link, _ := createRedactedLink(t, a)
+	lh, _ := link.Hash()
+
+	assert.Equal(t, store.ErrNoStateOpenings, store.SaveStateOpenings(ctx, a, lh, nil))
+	assert.Equal(t, store.ErrNoStateOpenings, store.SaveStateOpenings(ctx, a, lh, []*cs.StateOpening{nil}))
+
+	got, err := store.GetStateOpenings(ctx, a, lh)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+// keyValueAdapter is a store that implements store.KeyValueStore but not
+// store.KeyValueCreator: its CreateValue method hides the one of the
+// dummy store.
+type keyValueAdapter struct {
+	*dummystore.DummyStore
+}
+
+func (a keyValueAdapter) CreateValue() {}
+
+func TestStateOpenings_unavailable(t *testing.T) {
+	ctx := context.Background()
+	link := cstesting.NewLinkBuilder().
+		WithState(map[string]interface{}{"name": "Alice"}).
+		Build()
+	openings, err := link.CommitState("name")
+	require.NoError(t, err)
+
+	a := &storetesting.MockAdapter{}
+	a.MockGetSegment.Fn = func(*types.Bytes32) (*cs.Segment, error) {
+		return link.Segmentify(), nil
+	}
+	lh, _ := link.Hash()
+
+	assert.Equal(t, store.ErrStateOpeningsUnavailable, store.SaveStateOpenings(ctx, a, lh, openings))
+
+	kv := keyValueAdapter{DummyStore: dummystore.New(&dummystore.Config{})}
+	_, err = kv.CreateLink(ctx, link)
+	require.NoError(t, err)
+	assert.Equal(t, store.ErrStateOpeningsUnavailable, store.SaveStateOpenings(ctx, kv, lh, openings), "key-value stores must create values atomically")
+
+	_, err = store.GetStateOpenings(ctx, a, lh)
+	assert.Equal(t, store.ErrStateOpeningsUnavailable, err)
+
+	assert.Equal(t, store.ErrStateOpeningsUnavailable, store.PurgeStateOpenings(ctx, a, lh))
+}
+
+func TestSaveStateOpenings_concurrent(t *testing.T) {
+	ctx := context.Background()
+	a := dummystore.New(&dummystore.Config{})
+	link, openings := createRedactedLink(t, a)
+	lh, _ := link.Hash()
+
+	const n = 10
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			errs <- store.SaveStateOpenings(ctx, a, lh, openings)
+		}()
+	}
+
+	saved := 0
+	for i := 0; i < n; i++ {
+		if err := <-errs; err == nil {
+			saved++
+		} else {
+			assert.Equal(t, store.ErrStateOpeningsExist, err)
+		}
+	}
+	assert.Equal(t, 1, saved, "state openings should be saved once")
+}
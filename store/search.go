@@ -0,0 +1,229 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode"
+
+	"github.com/stratumn/go-indigocore/cs"
+)
+
+// Fields a search can be restricted to.
+const (
+	SearchFieldMapID        = "meta.mapId"
+	SearchFieldProcess      = "meta.process"
+	SearchFieldAction       = "meta.action"
+	SearchFieldType         = "meta.type"
+	SearchFieldTags         = "meta.tags"
+	SearchFieldPrevLinkHash = "meta.prevLinkHash"
+	SearchFieldState        = "state"
+)
+
+// SearchFields are the fields searched when a query doesn't restrict them.
+var SearchFields = []string{
+	SearchFieldMapID,
+	SearchFieldProcess,
+	SearchFieldAction,
+	SearchFieldType,
+	SearchFieldTags,
+	SearchFieldPrevLinkHash,
+	SearchFieldState,
+}
+
+var (
+	// ErrSearchUnavailable is returned by Search when the store doesn't
+	// support full-text search.
+	ErrSearchUnavailable = errors.New("store does not support search")
+
+	// ErrEmptySearchQuery is returned when the query string is empty.
+	ErrEmptySearchQuery = errors.New("search query is empty")
+
+	// ErrInvalidSearchField is returned when a query is restricted to an
+	// unknown field.
+	ErrInvalidSearchField = errors.New("invalid search field")
+)
+
+// SearchQuery contains a query string, the fields it applies to and the
+// filter and pagination of the results.
+type SearchQuery struct {
+	SegmentFilter
+
+	// Query is a list of terms separated by spaces. A segment matches if
+	// one of the terms matches. A term ending with * matches all the
+	// words it prefixes.
+	Query string `json:"query"`
+
+	// Fields restricts the search to some fields. If empty, all the
+	// SearchFields are searched.
+	Fields []string `json:"fields"`
+}
+
+// Searcher is the interface for stores that support full-text search.
+// Some stores will implement this interface, but not all.
+type Searcher interface {
+	// Search segments matching a query.
+	Search(ctx context.Context, query *SearchQuery) (cs.SegmentSlice, error)
+}
+
+// SearchChecker is implemented by stores that implement Searcher but can
+// only search when the stores they wrap can, like decorators.
+type SearchChecker interface {
+	// IsSearchable returns true if Search is available.
+	IsSearchable() bool
+}
+
+// IsSearchable returns true if the store implements Searcher and, if it
+// implements SearchChecker, reports that search is available.
+func IsSearchable(a Adapter) bool {
+	if _, ok := a.(Searcher); !ok {
+		return false
+	}
+	if c, ok := a.(SearchChecker); ok {
+		return c.IsSearchable()
+	}
+
+	return true
+}
+
+// Search searches segments if the store is searchable, or returns
+// ErrSearchUnavailable.
+func Search(ctx context.Context, a Adapter, query *SearchQuery) (cs.SegmentSlice, error) {
+	if !IsSearchable(a) {
+		return nil, ErrSearchUnavailable
+	}
+	s := a.(Searcher)
+
+	return s.Search(ctx, query)
+}
+
+// Validate checks that the query string isn't empty and that the fields
+// are known.
+func (query SearchQuery) Validate() error {
+	if len(tokenize(query.Query)) == 0 {
+		return ErrEmptySearchQuery
+	}
+
+	for _, field := range query.Fields {
+		valid := false
+		for _, f := range SearchFields {
+			if f == field {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return ErrInvalidSearchField
+		}
+	}
+
+	return nil
+}
+
+// Match checks if segment matches with the query.
+func (query SearchQuery) Match(segment *cs.Segment) bool {
+	if segment == nil {
+		return false
+	}
+
+	return query.MatchLink(&segment.Link)
+}
+
+// MatchLink checks if link matches with the filter and the query string.
+// It is meant for stores that don't have a full-text index and search
+// links one by one.
+func (query SearchQuery) MatchLink(link *cs.Link) bool {
+	if !query.SegmentFilter.MatchLink(link) {
+		return false
+	}
+
+	fields := query.Fields
+	if len(fields) == 0 {
+		fields = SearchFields
+	}
+
+	var words []string
+	for _, field := range fields {
+		words = append(words, linkFieldWords(link, field)...)
+	}
+
+	for _, term := range tokenize(query.Query) {
+		prefix := strings.HasSuffix(term, "*")
+		term = strings.TrimSuffix(term, "*")
+		for _, word := range words {
+			if word == term || prefix && strings.HasPrefix(word, term) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// linkFieldWords returns the lowercase words contained in a field of a
+// link. Only string values of the state are searched.
+func linkFieldWords(link *cs.Link, field string) []string {
+	switch field {
+	case SearchFieldMapID:
+		return tokenize(link.Meta.MapID)
+	case SearchFieldProcess:
+		return tokenize(link.Meta.Process)
+	case SearchFieldAction:
+		return tokenize(link.Meta.Action)
+	case SearchFieldType:
+		return tokenize(link.Meta.Type)
+	case SearchFieldTags:
+		return tokenize(strings.Join(link.Meta.Tags, " "))
+	case SearchFieldPrevLinkHash:
+		return tokenize(link.Meta.PrevLinkHash)
+	case SearchFieldState:
+		var words []string
+		for _, v := range link.State {
+			words = append(words, stateWords(v)...)
+		}
+		return words
+	}
+
+	return nil
+}
+
+func stateWords(value interface{}) []string {
+	var words []string
+
+	switch v := value.(type) {
+	case string:
+		words = tokenize(v)
+	case []interface{}:
+		for _, e := range v {
+			words = append(words, stateWords(e)...)
+		}
+	case map[string]interface{}:
+		for _, e := range v {
+			words = append(words, stateWords(e)...)
+		}
+	}
+
+	return words
+}
+
+// tokenize splits a string into lowercase words. Asterisks are kept so
+// that query terms can be prefixes.
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '*'
+	})
+}
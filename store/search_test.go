@@ -0,0 +1,97 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/cs/cstesting"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/store/storetesting"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchQuery_Match(t *testing.T) {
+	link := cstesting.NewLinkBuilder().
+		WithProcess("TheProcess").
+		WithMapID("map-42").
+		WithTags("Urban", "city").
+		WithState(map[string]interface{}{
+			"name":   "Hector Salazar",
+			"age":    42,
+			"active": true,
+			"pets":   []interface{}{"rex", map[string]interface{}{"kind": "parrot"}},
+		}).
+		Build()
+	segment := link.Segmentify()
+
+	tests := []struct {
+		name  string
+		query store.SearchQuery
+		want  bool
+	}{
+		{"word in state", store.SearchQuery{Query: "salazar"}, true},
+		{"nested word in state", store.SearchQuery{Query: "parrot"}, true},
+		{"case insensitive", store.SearchQuery{Query: "URBAN"}, true},
+		{"prefix", store.SearchQuery{Query: "sala*"}, true},
+		{"partial word", store.SearchQuery{Query: "sala"}, false},
+		{"one of the terms", store.SearchQuery{Query: "nothing rex"}, true},
+		{"non-string state values", store.SearchQuery{Query: "true"}, false},
+		{"map ID word", store.SearchQuery{Query: "42"}, true},
+		{"restricted to field", store.SearchQuery{Query: "salazar", Fields: []string{store.SearchFieldTags}}, false},
+		{"matching field", store.SearchQuery{Query: "city", Fields: []string{store.SearchFieldTags}}, true},
+		{"filtered out", store.SearchQuery{SegmentFilter: store.SegmentFilter{Process: "AProcess"}, Query: "salazar"}, false},
+		{"filter", store.SearchQuery{SegmentFilter: store.SegmentFilter{Process: "TheProcess"}, Query: "salazar"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.query.Match(segment))
+		})
+	}
+
+	assert.False(t, store.SearchQuery{Query: "salazar"}.Match(nil), "Match(nil)")
+}
+
+func TestSearchQuery_Validate(t *testing.T) {
+	assert.NoError(t, store.SearchQuery{Query: "foo", Fields: store.SearchFields}.Validate())
+	assert.Equal(t, store.ErrEmptySearchQuery, store.SearchQuery{Query: " ,"}.Validate())
+	assert.Equal(t, store.ErrInvalidSearchField, store.SearchQuery{Query: "foo", Fields: []string{"meta.data"}}.Validate())
+}
+
+type searchableAdapter struct {
+	*storetesting.MockAdapter
+	searchable bool
+}
+
+func (a *searchableAdapter) Search(context.Context, *store.SearchQuery) (cs.SegmentSlice, error) {
+	return nil, nil
+}
+
+func (a *searchableAdapter) IsSearchable() bool {
+	return a.searchable
+}
+
+func TestIsSearchable(t *testing.T) {
+	assert.False(t, store.IsSearchable(&storetesting.MockAdapter{}), "MockAdapter")
+	assert.True(t, store.IsSearchable(&searchableAdapter{MockAdapter: &storetesting.MockAdapter{}, searchable: true}), "searchable")
+
+	a := &searchableAdapter{MockAdapter: &storetesting.MockAdapter{}}
+	assert.False(t, store.IsSearchable(a), "not searchable")
+	_, err := store.Search(context.Background(), a, &store.SearchQuery{Query: "foo"})
+	assert.Equal(t, store.ErrSearchUnavailable, err, "store.Search()")
+}
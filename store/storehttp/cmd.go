@@ -47,6 +47,7 @@ var (
 	writeTimeout        time.Duration
 	maxHeaderBytes      int
 	shutdownTimeout     time.Duration
+	enableAdmin         bool
 )
 
 // Run launches a storehttp server.
@@ -104,13 +105,19 @@ func RegisterFlags() {
 	flag.DurationVar(&writeTimeout, "write_timeout", jsonhttp.DefaultWriteTimeout, "Write timeout")
 	flag.IntVar(&maxHeaderBytes, "max_header_bytes", jsonhttp.DefaultMaxHeaderBytes, "Maximum header bytes")
 	flag.DurationVar(&shutdownTimeout, "shutdown_timeout", 10*time.Second, "Shutdown timeout")
+	flag.BoolVar(&enableAdmin, "enable_admin", false, "Serve administration routes, such as purging state openings")
 }
 
 // RunWithFlags should be called after RegisterFlags and flag.Parse to launch
-// a storehttp server configured using flag values.
-func RunWithFlags(a store.Adapter) {
+// a storehttp server configured using flag values. The configuration can be
+// further changed by the given functions.
+func RunWithFlags(a store.Adapter, configure ...func(*Config)) {
 	config := &Config{
 		StoreEventsChanSize: storeEventsChanSize,
+		EnableAdmin:         enableAdmin,
+	}
+	for _, fn := range configure {
+		fn(config)
 	}
 	monitoringConfig := monitoring.ConfigurationFromFlags()
 	httpConfig := &jsonhttp.Config{
@@ -16,6 +16,7 @@ package storehttp
 
 import (
 	"fmt"
+	"net/http"
 
 	"github.com/stratumn/go-indigocore/jsonhttp"
 	"github.com/stratumn/go-indigocore/store"
@@ -48,3 +49,33 @@ func newErrLinkHashes(msg string) jsonhttp.ErrHTTP {
 	}
 	return jsonhttp.NewErrBadRequest(msg)
 }
+
+func newErrBatch(msg string) jsonhttp.ErrHTTP {
+	if msg == "" {
+		msg = fmt.Sprintf("links must be a non-empty array of at most %d links", MaxBatchSize)
+	}
+	return jsonhttp.NewErrBadRequest(msg)
+}
+
+func newErrWriteMode(msg string) jsonhttp.ErrHTTP {
+	if msg == "" {
+		msg = fmt.Sprintf("mode must be one of %q, %q or %q", store.WriteModeCommit, store.WriteModeSync, store.WriteModeAsync)
+	}
+	return jsonhttp.NewErrBadRequest(msg)
+}
+
+func newErrLinkHeightUnavailable() jsonhttp.ErrHTTP {
+	return jsonhttp.NewErrHTTP(store.ErrLinkHeightUnavailable.Error(), http.StatusNotImplemented)
+}
+
+func newErrSearchUnavailable() jsonhttp.ErrHTTP {
+	return jsonhttp.NewErrHTTP(store.ErrSearchUnavailable.Error(), http.StatusNotImplemented)
+}
+
+func newErrStateOpeningsUnavailable() jsonhttp.ErrHTTP {
+	return jsonhttp.NewErrHTTP(store.ErrStateOpeningsUnavailable.Error(), http.StatusNotImplemented)
+}
+
+func newErrStateOpeningsConflict(msg string) jsonhttp.ErrHTTP {
+	return jsonhttp.NewErrHTTP(msg, http.StatusConflict)
+}
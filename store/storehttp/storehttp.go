@@ -15,6 +15,7 @@
 // Package storehttp is used to create an HTTP server from a store adapter.
 //
 // It serves the following routes:
+//
 //	GET /
 //		Renders information about the store.
 //
@@ -22,6 +23,15 @@
 //		Saves then renders a link.
 //		Body should be a JSON encoded link.
 //
+//	POST /links/batch
+//		Saves several links and renders the result of each of them.
+//		Body should be a JSON object with a "links" array of links and an
+//		optional "mode" ("commit", "sync" or "async", defaults to "commit").
+//
+//	GET /links/:linkHash/height
+//		Renders the height of the block including a link, for stores
+//		that include links in blocks.
+//
 //	POST /evidences/:linkHash
 //		Adds evidence to a link.
 //		Body should be a JSON encoded evidence.
@@ -29,9 +39,28 @@
 //	GET /segments/:linkHash
 //		Renders a segment.
 //
+//	POST /segments/:linkHash/openings
+//		Saves the clear-text values of the committed state fields of a
+//		link, for stores that support state openings.
+//		Body should be a JSON encoded array of state openings.
+//		State openings can't be replaced once saved, nor saved again
+//		after being purged.
+//
+//	GET /segments/:linkHash/openings
+//		Renders the state openings of a link.
+//
+//	DELETE /segments/:linkHash/openings
+//		Purges the state openings of a link. The link and its hash are
+//		left untouched and the purge is recorded. Only served if
+//		administration routes are enabled.
+//
 //	GET /segments?[offset=offset]&[limit=limit]&[mapIds[]=id1]&[mapIds[]=id2]&[prevLinkHash=prevLinkHash]&[tags[]=tag1]&[tags[]=tag2]
 //		Finds and renders segments.
 //
+//	GET /search?query=query&[fields[]=field1]&[fields[]=field2]&[offset=offset]&[limit=limit]&[process=process]&[mapIds[]=id1]&[tags[]=tag1]
+//		Searches and renders segments, for stores that support
+//		full-text search.
+//
 //	GET /maps?[offset=offset]&[limit=limit]
 //		Finds and renders map IDs.
 //
@@ -48,6 +77,7 @@ import (
 	"sync"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
 	"github.com/stratumn/go-indigocore/cs"
 	"github.com/stratumn/go-indigocore/jsonhttp"
 	"github.com/stratumn/go-indigocore/jsonws"
@@ -64,6 +94,9 @@ const (
 
 	// DefaultAddress is the default address of the server.
 	DefaultAddress = ":5000"
+
+	// MaxBatchSize is the maximum number of links in a batch.
+	MaxBatchSize = 1000
 )
 
 // Server is an HTTP server for stores.
@@ -78,6 +111,13 @@ type Server struct {
 type Config struct {
 	// The size of the store event channel.
 	StoreEventsChanSize int
+
+	// Whether to serve administration routes.
+	EnableAdmin bool
+
+	// An optional function returning the context of each request, for
+	// instance replicastore.Sessions.RequestContext.
+	RequestContext func(*http.Request) context.Context
 }
 
 // Info is the info returned by the root route.
@@ -100,17 +140,43 @@ func New(
 		storeEventsChan: make(chan *store.Event, config.StoreEventsChanSize),
 	}
 
-	s.Get("/", s.root)
-	s.Post("/links", s.createLink)
-	s.Post("/evidences/:linkHash", s.addEvidence)
-	s.Get("/segments/:linkHash", s.getSegment)
-	s.Get("/segments", s.findSegments)
-	s.Get("/maps", s.getMapIDs)
+	withContext := newContextDecorator(config.RequestContext)
+
+	s.Get("/", withContext(s.root))
+	s.Post("/links", withContext(s.createLink))
+	s.Post("/links/batch", withContext(s.createLinks))
+	s.Get("/links/:linkHash/height", withContext(s.getLinkHeight))
+	s.Post("/evidences/:linkHash", withContext(s.addEvidence))
+	s.Get("/segments/:linkHash", withContext(s.getSegment))
+	s.Post("/segments/:linkHash/openings", withContext(s.saveStateOpenings))
+	s.Get("/segments/:linkHash/openings", withContext(s.getStateOpenings))
+	if config.EnableAdmin {
+		s.Delete("/segments/:linkHash/openings", withContext(s.purgeStateOpenings))
+	}
+	s.Get("/segments", withContext(s.findSegments))
+	s.Get("/maps", withContext(s.getMapIDs))
+	if store.IsSearchable(a) {
+		s.Get("/search", withContext(s.search))
+	}
 	s.GetRaw("/websocket", s.getWebSocket)
 
 	return &s
 }
 
+// newContextDecorator returns a function wrapping handles so that they are
+// called with a request whose context was decorated by requestContext.
+func newContextDecorator(requestContext func(*http.Request) context.Context) func(jsonhttp.Handle) jsonhttp.Handle {
+	return func(handle jsonhttp.Handle) jsonhttp.Handle {
+		if requestContext == nil {
+			return handle
+		}
+
+		return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) (interface{}, error) {
+			return handle(w, r.WithContext(requestContext(r)), p)
+		}
+	}
+}
+
 // ListenAndServe starts the server.
 func (s *Server) ListenAndServe() (err error) {
 	wg := sync.WaitGroup{}
@@ -208,6 +274,73 @@ func (s *Server) createLink(w http.ResponseWriter, r *http.Request, _ httprouter
 	return link.Segmentify(), nil
 }
 
+// linksBatch is the body of a batch of links.
+type linksBatch struct {
+	Links []*cs.Link      `json:"links"`
+	Mode  store.WriteMode `json:"mode"`
+}
+
+// linkHeight is the response of the link height route.
+type linkHeight struct {
+	LinkHash *types.Bytes32 `json:"linkHash"`
+	Height   int64          `json:"height"`
+}
+
+func (s *Server) createLinks(w http.ResponseWriter, r *http.Request, _ httprouter.Params) (interface{}, error) {
+	ctx, span := trace.StartSpan(r.Context(), "storehttp/createLinks")
+	defer span.End()
+
+	decoder := json.NewDecoder(r.Body)
+
+	var batch linksBatch
+	if err := decoder.Decode(&batch); err != nil {
+		span.SetStatus(trace.Status{Code: monitoring.InvalidArgument, Message: err.Error()})
+		return nil, jsonhttp.NewErrBadRequest(err.Error())
+	}
+
+	if err := validateLinksBatch(&batch); err != nil {
+		span.SetStatus(trace.Status{Code: monitoring.InvalidArgument, Message: err.Error()})
+		return nil, err
+	}
+
+	span.AddAttributes(trace.Int64Attribute("LinksCount", int64(len(batch.Links))))
+
+	results, err := store.CreateLinks(ctx, s.adapter, batch.Links, batch.Mode)
+	if err != nil {
+		span.SetStatus(trace.Status{Code: monitoring.Unknown, Message: err.Error()})
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (s *Server) getLinkHeight(w http.ResponseWriter, r *http.Request, p httprouter.Params) (interface{}, error) {
+	ctx, span := trace.StartSpan(r.Context(), "storehttp/getLinkHeight")
+	defer span.End()
+
+	linkHash, err := types.NewBytes32FromString(p.ByName("linkHash"))
+	if err != nil {
+		span.SetStatus(trace.Status{Code: monitoring.InvalidArgument, Message: err.Error()})
+		return nil, jsonhttp.NewErrBadRequest(err.Error())
+	}
+
+	height, err := store.GetLinkHeight(ctx, s.adapter, linkHash)
+	if err == store.ErrLinkHeightUnavailable {
+		span.SetStatus(trace.Status{Code: monitoring.Unimplemented, Message: err.Error()})
+		return nil, newErrLinkHeightUnavailable()
+	}
+	if err != nil {
+		span.SetStatus(trace.Status{Code: monitoring.Unknown, Message: err.Error()})
+		return nil, err
+	}
+	if height == 0 {
+		span.SetStatus(trace.Status{Code: monitoring.NotFound})
+		return nil, jsonhttp.NewErrNotFound("link is not included in a block yet")
+	}
+
+	return &linkHeight{LinkHash: linkHash, Height: height}, nil
+}
+
 func (s *Server) addEvidence(w http.ResponseWriter, r *http.Request, p httprouter.Params) (interface{}, error) {
 	ctx, span := trace.StartSpan(r.Context(), "storehttp/addEvidence")
 	defer span.End()
@@ -257,6 +390,92 @@ func (s *Server) getSegment(w http.ResponseWriter, r *http.Request, p httprouter
 	return seg, nil
 }
 
+func (s *Server) saveStateOpenings(w http.ResponseWriter, r *http.Request, p httprouter.Params) (interface{}, error) {
+	ctx, span := trace.StartSpan(r.Context(), "storehttp/saveStateOpenings")
+	defer span.End()
+
+	linkHash, err := types.NewBytes32FromString(p.ByName("linkHash"))
+	if err != nil {
+		span.SetStatus(trace.Status{Code: monitoring.InvalidArgument, Message: err.Error()})
+		return nil, jsonhttp.NewErrBadRequest(err.Error())
+	}
+
+	decoder := json.NewDecoder(r.Body)
+
+	var openings []*cs.StateOpening
+	if err := decoder.Decode(&openings); err != nil {
+		span.SetStatus(trace.Status{Code: monitoring.InvalidArgument, Message: err.Error()})
+		return nil, jsonhttp.NewErrBadRequest(err.Error())
+	}
+
+	err = store.SaveStateOpenings(ctx, s.adapter, linkHash, openings)
+	if err != nil {
+		return nil, stateOpeningsError(span, err)
+	}
+
+	return openings, nil
+}
+
+func (s *Server) getStateOpenings(w http.ResponseWriter, r *http.Request, p httprouter.Params) (interface{}, error) {
+	ctx, span := trace.StartSpan(r.Context(), "storehttp/getStateOpenings")
+	defer span.End()
+
+	linkHash, err := types.NewBytes32FromString(p.ByName("linkHash"))
+	if err != nil {
+		span.SetStatus(trace.Status{Code: monitoring.InvalidArgument, Message: err.Error()})
+		return nil, jsonhttp.NewErrBadRequest(err.Error())
+	}
+
+	openings, err := store.GetStateOpenings(ctx, s.adapter, linkHash)
+	if err != nil {
+		return nil, stateOpeningsError(span, err)
+	}
+
+	return openings, nil
+}
+
+func (s *Server) purgeStateOpenings(w http.ResponseWriter, r *http.Request, p httprouter.Params) (interface{}, error) {
+	ctx, span := trace.StartSpan(r.Context(), "storehttp/purgeStateOpenings")
+	defer span.End()
+
+	linkHash, err := types.NewBytes32FromString(p.ByName("linkHash"))
+	if err != nil {
+		span.SetStatus(trace.Status{Code: monitoring.InvalidArgument, Message: err.Error()})
+		return nil, jsonhttp.NewErrBadRequest(err.Error())
+	}
+
+	if err := store.PurgeStateOpenings(ctx, s.adapter, linkHash); err != nil {
+		return nil, stateOpeningsError(span, err)
+	}
+
+	return nil, nil
+}
+
+// stateOpeningsError sets the span status and converts the errors returned
+// by state openings functions to HTTP errors.
+func stateOpeningsError(span *trace.Span, err error) error {
+	switch errors.Cause(err) {
+	case store.ErrStateOpeningsUnavailable:
+		span.SetStatus(trace.Status{Code: monitoring.Unimplemented, Message: err.Error()})
+		return newErrStateOpeningsUnavailable()
+	case store.ErrLinkNotFound:
+		span.SetStatus(trace.Status{Code: monitoring.NotFound, Message: err.Error()})
+		return jsonhttp.NewErrNotFound(err.Error())
+	case store.ErrStateOpeningsExist:
+		span.SetStatus(trace.Status{Code: monitoring.AlreadyExists, Message: err.Error()})
+		return newErrStateOpeningsConflict(err.Error())
+	case store.ErrStateOpeningsPurged:
+		span.SetStatus(trace.Status{Code: monitoring.FailedPrecondition, Message: err.Error()})
+		return newErrStateOpeningsConflict(err.Error())
+	case cs.ErrCommitmentMismatch, cs.ErrStateFieldNotFound:
+		span.SetStatus(trace.Status{Code: monitoring.InvalidArgument, Message: err.Error()})
+		return jsonhttp.NewErrBadRequest(err.Error())
+	default:
+		span.SetStatus(trace.Status{Code: monitoring.Unknown, Message: err.Error()})
+		return err
+	}
+}
+
 func (s *Server) findSegments(w http.ResponseWriter, r *http.Request, _ httprouter.Params) (interface{}, error) {
 	ctx, span := trace.StartSpan(r.Context(), "storehttp/findSegments")
 	defer span.End()
@@ -276,6 +495,29 @@ func (s *Server) findSegments(w http.ResponseWriter, r *http.Request, _ httprout
 	return slice, nil
 }
 
+func (s *Server) search(w http.ResponseWriter, r *http.Request, _ httprouter.Params) (interface{}, error) {
+	ctx, span := trace.StartSpan(r.Context(), "storehttp/search")
+	defer span.End()
+
+	query, e := parseSearchQuery(r)
+	if e != nil {
+		span.SetStatus(trace.Status{Code: monitoring.InvalidArgument, Message: e.Error()})
+		return nil, jsonhttp.NewErrBadRequest(e.Error())
+	}
+
+	slice, err := store.Search(ctx, s.adapter, query)
+	if err == store.ErrSearchUnavailable {
+		span.SetStatus(trace.Status{Code: monitoring.Unimplemented, Message: err.Error()})
+		return nil, newErrSearchUnavailable()
+	}
+	if err != nil {
+		span.SetStatus(trace.Status{Code: monitoring.Unknown, Message: err.Error()})
+		return nil, err
+	}
+
+	return slice, nil
+}
+
 func (s *Server) getMapIDs(w http.ResponseWriter, r *http.Request, _ httprouter.Params) (interface{}, error) {
 	ctx, span := trace.StartSpan(r.Context(), "storehttp/getMapIDs")
 	defer span.End()
@@ -27,14 +27,17 @@ import (
 
 	"github.com/stratumn/go-indigocore/cs"
 	"github.com/stratumn/go-indigocore/cs/cstesting"
+	"github.com/stratumn/go-indigocore/dummystore"
 	"github.com/stratumn/go-indigocore/jsonhttp"
 	"github.com/stratumn/go-indigocore/jsonws"
 	"github.com/stratumn/go-indigocore/jsonws/jsonwstesting"
+	"github.com/stratumn/go-indigocore/monitoring"
 	"github.com/stratumn/go-indigocore/store"
 	"github.com/stratumn/go-indigocore/store/storetesting"
 	"github.com/stratumn/go-indigocore/testutil"
 	"github.com/stratumn/go-indigocore/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const zeros = "0000000000000000000000000000000000000000000000000000000000000000"
@@ -173,6 +176,77 @@ func TestCreateLink_invalidJSON(t *testing.T) {
 	}
 }
 
+func TestCreateLinks(t *testing.T) {
+	s, a := createServer()
+	a.MockCreateLink.Fn = func(l *cs.Link) (*types.Bytes32, error) { return l.Hash() }
+
+	l1, l2 := cstesting.RandomLink(), cstesting.RandomLink()
+	l2.Meta.Process = ""
+	var results []*store.LinkResult
+	w, err := testutil.RequestJSON(s.ServeHTTP, "POST", "/links/batch", map[string]interface{}{"links": []*cs.Link{l1, l2}}, &results)
+	if err != nil {
+		t.Fatalf("testutil.RequestJSON(): err: %s", err)
+	}
+
+	assert.Equal(t, http.StatusOK, w.Code, "w.Code")
+	assert.Equal(t, 1, a.MockCreateLink.CalledCount, "a.MockCreateLink.CalledCount")
+	assert.Len(t, results, 2, "results")
+
+	lh1, _ := l1.Hash()
+	assert.Equal(t, &store.LinkResult{LinkHash: lh1, Status: store.LinkCommitted}, results[0])
+	assert.Equal(t, &store.LinkResult{Status: store.LinkRejected, Error: "link.meta.process should be a non empty string"}, results[1])
+}
+
+func TestCreateLinks_invalidBatch(t *testing.T) {
+	s, a := createServer()
+
+	tests := []struct {
+		name  string
+		batch interface{}
+		err   string
+	}{{
+		"empty",
+		map[string]interface{}{"links": []*cs.Link{}},
+		newErrBatch("").Error(),
+	}, {
+		"null link",
+		map[string]interface{}{"links": []*cs.Link{nil}},
+		"links cannot be null",
+	}, {
+		"invalid mode",
+		map[string]interface{}{"links": []*cs.Link{cstesting.RandomLink()}, "mode": "later"},
+		newErrWriteMode("").Error(),
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body map[string]interface{}
+			w, err := testutil.RequestJSON(s.ServeHTTP, "POST", "/links/batch", tt.batch, &body)
+			if err != nil {
+				t.Fatalf("testutil.RequestJSON(): err: %s", err)
+			}
+
+			assert.Equal(t, jsonhttp.NewErrBadRequest("").Status(), w.Code, "w.Code")
+			assert.Equal(t, tt.err, body["error"], `body["error"]`)
+		})
+	}
+
+	assert.Equal(t, 0, a.MockCreateLink.CalledCount, "a.MockCreateLink.CalledCount")
+}
+
+func TestGetLinkHeight_unavailable(t *testing.T) {
+	s, _ := createServer()
+
+	var body map[string]interface{}
+	w, err := testutil.RequestJSON(s.ServeHTTP, "GET", "/links/"+zeros+"/height", nil, &body)
+	if err != nil {
+		t.Fatalf("testutil.RequestJSON(): err: %s", err)
+	}
+
+	assert.Equal(t, http.StatusNotImplemented, w.Code, "w.Code")
+	assert.Equal(t, store.ErrLinkHeightUnavailable.Error(), body["error"], `body["error"]`)
+}
+
 func TestAddEvidence(t *testing.T) {
 	s, a := createServer()
 	a.MockAddEvidence.Fn = func(*types.Bytes32, *cs.Evidence) error { return nil }
@@ -535,6 +609,236 @@ func TestFindSegments_invalidLinkHashes(t *testing.T) {
 	assert.Equal(t, 0, a.MockFindSegments.CalledCount)
 }
 
+func TestSearch(t *testing.T) {
+	s, a := createSearchServer()
+	for i := 0; i < 3; i++ {
+		a.segments = append(a.segments, cstesting.RandomSegment())
+	}
+
+	var s2 cs.SegmentSlice
+	w, err := testutil.RequestJSON(s.ServeHTTP, "GET", "/search?query=sala*+daniel&fields%5B%5D=state&fields%5B%5D=meta.tags&process=p&offset=1&limit=2", nil, &s2)
+	if err != nil {
+		t.Fatalf("testutil.RequestJSON(): err: %s", err)
+	}
+
+	assert.Equal(t, http.StatusOK, w.Code, "w.Code")
+	assert.Len(t, s2, 3, "s2")
+
+	q := a.lastCalledWith
+	if assert.NotNil(t, q, "a.lastCalledWith") {
+		assert.Equal(t, "sala* daniel", q.Query, "q.Query")
+		assert.Equal(t, []string{store.SearchFieldState, store.SearchFieldTags}, q.Fields, "q.Fields")
+		assert.Equal(t, "p", q.Process, "q.Process")
+		assert.Equal(t, 1, q.Offset, "q.Offset")
+		assert.Equal(t, 2, q.Limit, "q.Limit")
+	}
+}
+
+func TestSearch_invalidQuery(t *testing.T) {
+	s, a := createSearchServer()
+
+	tests := []struct {
+		name  string
+		url   string
+		error string
+	}{
+		{"empty query", "/search", store.ErrEmptySearchQuery.Error()},
+		{"invalid field", "/search?query=foo&fields%5B%5D=meta.data", store.ErrInvalidSearchField.Error()},
+		{"invalid limit", "/search?query=foo&limit=-1", newErrLimit("").Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body map[string]interface{}
+			w, err := testutil.RequestJSON(s.ServeHTTP, "GET", tt.url, nil, &body)
+			if err != nil {
+				t.Fatalf("testutil.RequestJSON(): err: %s", err)
+			}
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, "w.Code")
+			assert.Equal(t, tt.error, body["error"], `body["error"]`)
+		})
+	}
+
+	assert.Nil(t, a.lastCalledWith, "a.lastCalledWith")
+}
+
+func TestSearch_unavailable(t *testing.T) {
+	t.Run("route is disabled", func(t *testing.T) {
+		s, _ := createServer()
+
+		var body map[string]interface{}
+		w, err := testutil.RequestJSON(s.ServeHTTP, "GET", "/search?query=foo", nil, &body)
+		if err != nil {
+			t.Fatalf("testutil.RequestJSON(): err: %s", err)
+		}
+
+		assert.Equal(t, http.StatusNotFound, w.Code, "w.Code")
+	})
+
+	t.Run("wrapped store", func(t *testing.T) {
+		s := newServer(monitoring.NewStoreAdapter(&storetesting.MockAdapter{}, "mock"))
+
+		var body map[string]interface{}
+		w, err := testutil.RequestJSON(s.ServeHTTP, "GET", "/search?query=foo", nil, &body)
+		if err != nil {
+			t.Fatalf("testutil.RequestJSON(): err: %s", err)
+		}
+
+		assert.Equal(t, http.StatusNotFound, w.Code, "w.Code")
+	})
+
+	t.Run("wrapped searchable store", func(t *testing.T) {
+		a := &searchAdapter{MockAdapter: &storetesting.MockAdapter{}}
+		s := newServer(monitoring.NewStoreAdapter(a, "mock"))
+
+		var body cs.SegmentSlice
+		w, err := testutil.RequestJSON(s.ServeHTTP, "GET", "/search?query=foo", nil, &body)
+		if err != nil {
+			t.Fatalf("testutil.RequestJSON(): err: %s", err)
+		}
+
+		assert.Equal(t, http.StatusOK, w.Code, "w.Code")
+	})
+}
+
+func createRedactedLink(t *testing.T, a store.Adapter) (*types.Bytes32, []*cs.StateOpening) {
+	link := cstesting.NewLinkBuilder().
+		WithState(map[string]interface{}{"name": "Alice"}).
+		Build()
+	openings, err := link.CommitState("name")
+	require.NoError(t, err)
+
+	lh, err := a.CreateLink(context.Background(), link)
+	require.NoError(t, err)
+
+	return lh, openings
+}
+
+func TestStateOpenings(t *testing.T) {
+	a := dummystore.New(&dummystore.Config{})
+	s := newServerWithConfig(a, &Config{EnableAdmin: true})
+	lh, openings := createRedactedLink(t, a)
+	target := "/segments/" + lh.String() + "/openings"
+
+	var saved []*cs.StateOpening
+	w, err := testutil.RequestJSON(s.ServeHTTP, "POST", target, openings, &saved)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, w.Code, "w.Code")
+	assert.Equal(t, openings, saved)
+
+	var got []*cs.StateOpening
+	w, err = testutil.RequestJSON(s.ServeHTTP, "GET", target, nil, &got)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, w.Code, "w.Code")
+	assert.Equal(t, openings, got)
+
+	var body map[string]interface{}
+	w, err = testutil.RequestJSON(s.ServeHTTP, "POST", target, openings, &body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, w.Code, "w.Code")
+	assert.Equal(t, store.ErrStateOpeningsExist.Error(), body["error"], `body["error"]`)
+
+	w, err = testutil.RequestJSON(s.ServeHTTP, "DELETE", target, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, w.Code, "w.Code")
+
+	got = nil
+	w, err = testutil.RequestJSON(s.ServeHTTP, "GET", target, nil, &got)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, w.Code, "w.Code")
+	assert.Empty(t, got)
+
+	body = nil
+	w, err = testutil.RequestJSON(s.ServeHTTP, "POST", target, openings, &body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, w.Code, "w.Code")
+	assert.Equal(t, store.ErrStateOpeningsPurged.Error(), body["error"], `body["error"]`)
+
+	var seg cs.Segment
+	w, err = testutil.RequestJSON(s.ServeHTTP, "GET", "/segments/"+lh.String(), nil, &seg)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, w.Code, "w.Code")
+	assert.True(t, cs.IsCommitment(seg.Link.State["name"]))
+	assert.NoError(t, seg.Validate(context.Background(), nil))
+}
+
+func TestSaveStateOpenings_invalid(t *testing.T) {
+	a := dummystore.New(&dummystore.Config{})
+	s := newServer(a)
+	lh, openings := createRedactedLink(t, a)
+
+	t.Run("commitment mismatch", func(t *testing.T) {
+		o := *openings[0]
+		o.Value = "Eve"
+
+		var body map[string]interface{}
+		w, err := testutil.RequestJSON(s.ServeHTTP, "POST", "/segments/"+lh.String()+"/openings", []*cs.StateOpening{&o}, &body)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, w.Code, "w.Code")
+	})
+
+	t.Run("link not found", func(t *testing.T) {
+		var body map[string]interface{}
+		w, err := testutil.RequestJSON(s.ServeHTTP, "POST", "/segments/"+zeros+"/openings", openings, &body)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, w.Code, "w.Code")
+	})
+}
+
+func TestPurgeStateOpenings_adminDisabled(t *testing.T) {
+	a := dummystore.New(&dummystore.Config{})
+	s := newServer(a)
+	lh, openings := createRedactedLink(t, a)
+	require.NoError(t, store.SaveStateOpenings(context.Background(), a, lh, openings))
+
+	w, err := testutil.RequestJSON(s.ServeHTTP, "DELETE", "/segments/"+lh.String()+"/openings", nil, nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, http.StatusOK, w.Code, "w.Code")
+
+	got, err := store.GetStateOpenings(context.Background(), a, lh)
+	require.NoError(t, err)
+	assert.Equal(t, openings, got)
+}
+
+func TestStateOpenings_unavailable(t *testing.T) {
+	s := newServer(monitoring.NewStoreAdapter(&storetesting.MockAdapter{}, "mock"))
+
+	var body map[string]interface{}
+	w, err := testutil.RequestJSON(s.ServeHTTP, "GET", "/segments/"+zeros+"/openings", nil, &body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotImplemented, w.Code, "w.Code")
+	assert.Equal(t, store.ErrStateOpeningsUnavailable.Error(), body["error"], `body["error"]`)
+}
+
+type requestContextKey struct{}
+
+// contextAdapter is a mock adapter that records the context of GetSegment.
+type contextAdapter struct {
+	*storetesting.MockAdapter
+
+	lastContext context.Context
+}
+
+func (a *contextAdapter) GetSegment(ctx context.Context, linkHash *types.Bytes32) (*cs.Segment, error) {
+	a.lastContext = ctx
+	return a.MockAdapter.GetSegment(ctx, linkHash)
+}
+
+func TestRequestContext(t *testing.T) {
+	a := &contextAdapter{MockAdapter: &storetesting.MockAdapter{}}
+	s := newServerWithConfig(a, &Config{
+		RequestContext: func(r *http.Request) context.Context {
+			return context.WithValue(r.Context(), requestContextKey{}, "decorated")
+		},
+	})
+
+	_, err := testutil.RequestJSON(s.ServeHTTP, "GET", "/segments/"+zeros, nil, nil)
+	require.NoError(t, err)
+	require.NotNil(t, a.lastContext, "a.GetSegment() should be called")
+	assert.Equal(t, "decorated", a.lastContext.Value(requestContextKey{}))
+}
+
 func TestGetMapIDs(t *testing.T) {
 	s, a := createServer()
 	s1 := []string{"one", "two", "three"}
@@ -72,6 +72,26 @@ func parseSegmentFilter(r *http.Request) (*store.SegmentFilter, error) {
 	}, nil
 }
 
+func parseSearchQuery(r *http.Request) (*store.SearchQuery, error) {
+	filter, err := parseSegmentFilter(r)
+	if err != nil {
+		return nil, err
+	}
+
+	q := r.URL.Query()
+	query := &store.SearchQuery{
+		SegmentFilter: *filter,
+		Query:         q.Get("query"),
+		Fields:        append(q["fields[]"], q["fields%5B%5D"]...),
+	}
+
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+
+	return query, nil
+}
+
 func parseMapFilter(r *http.Request) (*store.MapFilter, error) {
 	pagination, err := parsePagination(r)
 	if err != nil {
@@ -115,3 +135,25 @@ func parsePagination(r *http.Request) (*store.Pagination, error) {
 		Limit:  limit,
 	}, nil
 }
+
+func validateLinksBatch(batch *linksBatch) error {
+	if len(batch.Links) == 0 || len(batch.Links) > MaxBatchSize {
+		return newErrBatch("")
+	}
+
+	for _, link := range batch.Links {
+		if link == nil {
+			return newErrBatch("links cannot be null")
+		}
+	}
+
+	switch batch.Mode {
+	case "":
+		batch.Mode = store.WriteModeCommit
+	case store.WriteModeCommit, store.WriteModeSync, store.WriteModeAsync:
+	default:
+		return newErrWriteMode("")
+	}
+
+	return nil
+}
@@ -15,22 +15,49 @@
 package storehttp
 
 import (
+	"context"
 	"time"
 
+	"github.com/stratumn/go-indigocore/cs"
 	"github.com/stratumn/go-indigocore/jsonhttp"
 	"github.com/stratumn/go-indigocore/jsonws"
+	"github.com/stratumn/go-indigocore/store"
 	"github.com/stratumn/go-indigocore/store/storetesting"
 )
 
 func createServer() (*Server, *storetesting.MockAdapter) {
 	a := &storetesting.MockAdapter{}
-	s := New(a, &Config{}, &jsonhttp.Config{}, &jsonws.BasicConfig{}, &jsonws.BufferedConnConfig{
+	return newServer(a), a
+}
+
+// searchAdapter is a mock adapter that implements store.Searcher.
+type searchAdapter struct {
+	*storetesting.MockAdapter
+
+	segments       cs.SegmentSlice
+	lastCalledWith *store.SearchQuery
+}
+
+func (a *searchAdapter) Search(ctx context.Context, query *store.SearchQuery) (cs.SegmentSlice, error) {
+	a.lastCalledWith = query
+	return a.segments, nil
+}
+
+func createSearchServer() (*Server, *searchAdapter) {
+	a := &searchAdapter{MockAdapter: &storetesting.MockAdapter{}}
+	return newServer(a), a
+}
+
+func newServer(a store.Adapter) *Server {
+	return newServerWithConfig(a, &Config{})
+}
+
+func newServerWithConfig(a store.Adapter, config *Config) *Server {
+	return New(a, config, &jsonhttp.Config{}, &jsonws.BasicConfig{}, &jsonws.BufferedConnConfig{
 		Size:         256,
 		WriteTimeout: 10 * time.Second,
 		PongTimeout:  70 * time.Second,
 		PingInterval: time.Minute,
 		MaxMsgSize:   1024,
 	})
-
-	return s, a
 }
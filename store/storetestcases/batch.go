@@ -22,6 +22,7 @@ import (
 	"github.com/stratumn/go-indigocore/cs"
 	"github.com/stratumn/go-indigocore/cs/cstesting"
 	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -121,4 +122,74 @@ func (f Factory) TestBatch(t *testing.T) {
 			assert.True(t, exist, "Missing map: %s", mapID)
 		}
 	})
+
+	t.Run("Write should be all-or-nothing", func(t *testing.T) {
+		ctx = context.Background()
+		b := initBatch(t, a)
+
+		var linkHashes []*types.Bytes32
+		for i := 0; i < 3; i++ {
+			link := cstesting.NewLinkBuilder().WithMapID("mapAtomic").Build()
+			if i == 1 {
+				// A link that can't be serialized makes the write fail
+				// in the middle of the batch.
+				link.State["invalid"] = make(chan int)
+			}
+
+			linkHash, _ := b.CreateLink(ctx, link)
+			if linkHash != nil {
+				linkHashes = append(linkHashes, linkHash)
+			}
+		}
+
+		assert.Error(t, b.Write(ctx), "b.Write()")
+
+		for _, linkHash := range linkHashes {
+			found, err := a.GetSegment(ctx, linkHash)
+			assert.NoError(t, err, "a.GetSegment()")
+			assert.Nil(t, found, "Link should not be found in adapter after a failed Write")
+		}
+
+		segs, err := a.FindSegments(ctx, &store.SegmentFilter{
+			Pagination: store.Pagination{Limit: store.DefaultLimit},
+			MapIDs:     []string{"mapAtomic"},
+		})
+		assert.NoError(t, err, "a.FindSegments()")
+		assert.Empty(t, segs, "No segment should be found after a failed Write")
+	})
+
+	t.Run("Write should roll back when a link can't be saved", func(t *testing.T) {
+		if f.FailWrites == nil {
+			t.Skip("store doesn't support write failures")
+		}
+
+		ctx = context.Background()
+		f.FailWrites(a, 2)
+		defer f.FailWrites(a, 0)
+
+		b := initBatch(t, a)
+
+		var linkHashes []*types.Bytes32
+		for i := 0; i < 3; i++ {
+			link := cstesting.NewLinkBuilder().WithMapID("mapRollback").Build()
+			linkHash, err := b.CreateLink(ctx, link)
+			require.NoError(t, err, "b.CreateLink()")
+			linkHashes = append(linkHashes, linkHash)
+		}
+
+		assert.Error(t, b.Write(ctx), "b.Write()")
+
+		for _, linkHash := range linkHashes {
+			found, err := a.GetSegment(ctx, linkHash)
+			assert.NoError(t, err, "a.GetSegment()")
+			assert.Nil(t, found, "Link should not be found in adapter after a failed Write")
+		}
+
+		segs, err := a.FindSegments(ctx, &store.SegmentFilter{
+			Pagination: store.Pagination{Limit: store.DefaultLimit},
+			MapIDs:     []string{"mapRollback"},
+		})
+		assert.NoError(t, err, "a.FindSegments()")
+		assert.Empty(t, segs, "No segment should be found after a failed Write")
+	})
 }
@@ -23,6 +23,7 @@ import (
 	"sync/atomic"
 	"testing"
 
+	"github.com/stratumn/go-indigocore/store"
 	"github.com/stratumn/go-indigocore/testutil"
 	"github.com/stretchr/testify/assert"
 )
@@ -88,6 +89,28 @@ func (f Factory) TestKeyValueStore(t *testing.T) {
 		assert.NoError(t, err, "a.DeleteValue()")
 		assert.Nil(t, v, "Not found value should be nil")
 	})
+
+	t.Run("CreateValue", func(t *testing.T) {
+		if _, ok := a.(store.KeyValueCreator); !ok {
+			t.Skip("store does not implement store.KeyValueCreator")
+		}
+
+		ctx := context.Background()
+		key := testutil.RandomKey()
+		value := testutil.RandomValue()
+
+		created, err := store.CreateValue(ctx, a, key, value)
+		assert.NoError(t, err, "store.CreateValue()")
+		assert.True(t, created, "store.CreateValue() should create missing keys")
+
+		created, err = store.CreateValue(ctx, a, key, testutil.RandomValue())
+		assert.NoError(t, err, "store.CreateValue()")
+		assert.False(t, created, "store.CreateValue() should not replace values")
+
+		storedValue, err := a.GetValue(ctx, key)
+		assert.NoError(t, err, "a.GetValue()")
+		assert.EqualValues(t, value, storedValue, "a.GetValue()")
+	})
 }
 
 // BenchmarkGetValue benchmarks getting existing values.
@@ -0,0 +1,157 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storetestcases
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/cs/cstesting"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSearch tests what happens when you search segments.
+// It is skipped if the adapter doesn't implement store.Searcher.
+func (f Factory) TestSearch(t *testing.T) {
+	a := f.initAdapter(t)
+	defer f.freeAdapter(a)
+
+	s, ok := a.(store.Searcher)
+	if !ok {
+		t.Skip("adapter doesn't implement store.Searcher")
+	}
+
+	link1 := cstesting.NewLinkBuilder().
+		WithProcess("something crazy").
+		WithTags("one", "two", "three").
+		WithMapID("foo bar").
+		WithState(map[string]interface{}{"nested": map[string]interface{}{
+			"first":  "hector",
+			"last":   "salazar",
+			"common": "stratumn",
+		}}).
+		Build()
+	hash1, _ := link1.HashString()
+	_, err := a.CreateLink(context.Background(), link1)
+	require.NoError(t, err, "a.CreateLink()")
+
+	link2 := cstesting.NewLinkBuilder().
+		WithProcess("fly emirates").
+		WithTags("urban", "paranoia", "city").
+		WithMapID("stupid madness").
+		WithState(map[string]interface{}{"nested": map[string]interface{}{
+			"first":  "james",
+			"last":   "daniel",
+			"common": "stratumn",
+		}}).
+		Build()
+	hash2, _ := link2.HashString()
+	_, err = a.CreateLink(context.Background(), link2)
+	require.NoError(t, err, "a.CreateLink()")
+
+	search := func(t *testing.T, query *store.SearchQuery) cs.SegmentSlice {
+		query.Pagination.Limit = store.DefaultLimit
+		slice, err := s.Search(context.Background(), query)
+		require.NoError(t, err, "s.Search()")
+		return slice
+	}
+
+	linkHashes := func(slice cs.SegmentSlice) []string {
+		var hashes []string
+		for _, s := range slice {
+			hashes = append(hashes, s.GetLinkHashString())
+		}
+		return hashes
+	}
+
+	t.Run("Should find segment based on partial state match", func(t *testing.T) {
+		slice := search(t, &store.SearchQuery{Query: "sala*"})
+		assert.Equal(t, []string{hash1}, linkHashes(slice))
+	})
+
+	t.Run("Should find segment based on process", func(t *testing.T) {
+		slice := search(t, &store.SearchQuery{Query: "emirates"})
+		assert.Equal(t, []string{hash2}, linkHashes(slice))
+	})
+
+	t.Run("Should find segments based on multiple words", func(t *testing.T) {
+		slice := search(t, &store.SearchQuery{Query: "salazar daniel"})
+		assert.ElementsMatch(t, []string{hash1, hash2}, linkHashes(slice))
+	})
+
+	t.Run("Should ignore case", func(t *testing.T) {
+		slice := search(t, &store.SearchQuery{Query: "PARANOIA"})
+		assert.Equal(t, []string{hash2}, linkHashes(slice))
+	})
+
+	t.Run("Should restrict search to fields", func(t *testing.T) {
+		slice := search(t, &store.SearchQuery{
+			Query:  "madness daniel",
+			Fields: []string{store.SearchFieldMapID},
+		})
+		assert.Equal(t, []string{hash2}, linkHashes(slice))
+
+		slice = search(t, &store.SearchQuery{
+			Query:  "emirates",
+			Fields: []string{store.SearchFieldState},
+		})
+		assert.Empty(t, slice)
+	})
+
+	t.Run("Should filter on process", func(t *testing.T) {
+		slice := search(t, &store.SearchQuery{
+			SegmentFilter: store.SegmentFilter{Process: "fly emirates"},
+			Query:         "stratu*",
+		})
+		assert.Equal(t, []string{hash2}, linkHashes(slice))
+	})
+
+	t.Run("Should filter on map IDs", func(t *testing.T) {
+		slice := search(t, &store.SearchQuery{
+			SegmentFilter: store.SegmentFilter{MapIDs: []string{"foo bar"}},
+			Query:         "stratu*",
+		})
+		assert.Equal(t, []string{hash1}, linkHashes(slice))
+
+		slice = search(t, &store.SearchQuery{
+			SegmentFilter: store.SegmentFilter{MapIDs: []string{"foo bar", "stupid madness"}},
+			Query:         "stratu*",
+		})
+		assert.ElementsMatch(t, []string{hash1, hash2}, linkHashes(slice))
+	})
+
+	t.Run("Should paginate results", func(t *testing.T) {
+		slice, err := s.Search(context.Background(), &store.SearchQuery{
+			SegmentFilter: store.SegmentFilter{Pagination: store.Pagination{Offset: 1, Limit: 5}},
+			Query:         "stratumn",
+		})
+		assert.NoError(t, err, "s.Search()")
+		assert.Len(t, slice, 1, "slice")
+	})
+
+	t.Run("Should reject invalid queries", func(t *testing.T) {
+		_, err := s.Search(context.Background(), &store.SearchQuery{Query: " "})
+		assert.EqualError(t, err, store.ErrEmptySearchQuery.Error())
+
+		_, err = s.Search(context.Background(), &store.SearchQuery{
+			Query:  "foo",
+			Fields: []string{"meta.data"},
+		})
+		assert.EqualError(t, err, store.ErrInvalidSearchField.Error())
+	})
+}
@@ -16,6 +16,7 @@
 package storetestcases
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
 	"testing"
@@ -44,6 +45,27 @@ type Factory struct {
 	// FreeKeyValueStore is an optional function to free
 	// a KeyValueStore adapter.
 	FreeKeyValueStore func(adapter store.KeyValueStore)
+
+	// FailWrites is an optional function that makes an adapter fail to
+	// save the n-th link of its batches (starting at 1), or restores it if
+	// n is zero. It is used to check that batches are rolled back.
+	FailWrites func(adapter store.Adapter, n int)
+}
+
+// ErrWriteFailed is the error returned by the functions created by
+// FailingWrite.
+var ErrWriteFailed = errors.New("write failed")
+
+// FailingWrite returns a function that fails for the n-th link of a batch
+// (starting at 1) when given the index of the link being saved, or never
+// fails if n is zero. Stores can use it to implement Factory.FailWrites.
+func FailingWrite(n int) func(i int) error {
+	return func(i int) error {
+		if i == n-1 {
+			return ErrWriteFailed
+		}
+		return nil
+	}
 }
 
 // RunKeyValueStoreTests runs all the tests for the key value store interface.
@@ -57,6 +79,7 @@ func (f Factory) RunStoreTests(t *testing.T) {
 	t.Run("Test store info", f.TestGetInfo)
 	t.Run("Test finding segments", f.TestFindSegments)
 	t.Run("Test getting map IDs", f.TestGetMapIDs)
+	t.Run("Test search", f.TestSearch)
 	t.Run("Test getting segments", f.TestGetSegment)
 	t.Run("Test creating links", f.TestCreateLink)
 	t.Run("Test batch implementation", f.TestBatch)
@@ -0,0 +1,82 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storecache
+
+import (
+	"context"
+	"log"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+const (
+	segmentsCache     = "segments"
+	findSegmentsCache = "find_segments"
+)
+
+var (
+	hitCount  *stats.Int64Measure
+	missCount *stats.Int64Measure
+	cacheName tag.Key
+)
+
+func init() {
+	hitCount = stats.Int64(
+		"stratumn/indigocore/storecache/hit_count",
+		"number of cache hits",
+		stats.UnitNone,
+	)
+
+	missCount = stats.Int64(
+		"stratumn/indigocore/storecache/miss_count",
+		"number of cache misses",
+		stats.UnitNone,
+	)
+
+	var err error
+	if cacheName, err = tag.NewKey("store_cache"); err != nil {
+		log.Fatal(err)
+	}
+
+	if err = view.Register(
+		&view.View{
+			Name:        "stratumn_indigocore_storecache_hit_count",
+			Description: "number of cache hits",
+			Measure:     hitCount,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{cacheName},
+		},
+		&view.View{
+			Name:        "stratumn_indigocore_storecache_miss_count",
+			Description: "number of cache misses",
+			Measure:     missCount,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{cacheName},
+		}); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// recordLookup records a hit or a miss for the given cache.
+func recordLookup(ctx context.Context, cache string, hit bool) {
+	ctx, _ = tag.New(ctx, tag.Upsert(cacheName, cache))
+	if hit {
+		stats.Record(ctx, hitCount.M(1))
+	} else {
+		stats.Record(ctx, missCount.M(1))
+	}
+}
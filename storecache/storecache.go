@@ -0,0 +1,351 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storecache implements a store.Adapter decorator that caches
+// segments in memory.
+//
+// Segments returned by GetSegment are kept in an LRU keyed by link hash.
+// Results of FindSegments can optionally be cached too, in a separate
+// bounded LRU keyed by filter.
+//
+// Cached segments are invalidated when evidences are added through the
+// decorator, and when the underlying store emits SavedEvidences events.
+// FindSegments results are invalidated when links or evidences are saved.
+// Writes that bypass both the decorator and the event channel of the
+// underlying store are not seen by the cache.
+package storecache
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/types"
+)
+
+const (
+	// DefaultSegmentsCacheSize is the default number of segments kept in
+	// the cache.
+	DefaultSegmentsCacheSize = 1000
+
+	// eventChanSize is the size of the buffered channel receiving events
+	// from the underlying store.
+	eventChanSize = 100
+)
+
+// Config contains configuration options for the cache.
+type Config struct {
+	// SegmentsCacheSize is the maximum number of segments kept in the cache.
+	// Defaults to DefaultSegmentsCacheSize.
+	SegmentsCacheSize int
+
+	// FindSegmentsCacheSize is the maximum number of FindSegments results
+	// kept in the cache. Zero disables the FindSegments cache.
+	FindSegmentsCacheSize int
+}
+
+// Adapter is a decorator for the store.Adapter interface.
+// It wraps a real store.Adapter implementation and caches segments.
+type Adapter struct {
+	s store.Adapter
+
+	mutex    sync.Mutex
+	segments *lru
+	finds    *lru
+
+	// Generations are incremented on every invalidation so that results
+	// fetched before an invalidation are not added to the cache after it.
+	segmentsGen uint64
+	findsGen    uint64
+}
+
+// New decorates an existing store adapter.
+func New(s store.Adapter, config *Config) store.Adapter {
+	segmentsSize := config.SegmentsCacheSize
+	if segmentsSize <= 0 {
+		segmentsSize = DefaultSegmentsCacheSize
+	}
+
+	a := &Adapter{
+		s:        s,
+		segments: newLRU(segmentsSize),
+	}
+	if config.FindSegmentsCacheSize > 0 {
+		a.finds = newLRU(config.FindSegmentsCacheSize)
+	}
+
+	eventChan := make(chan *store.Event, eventChanSize)
+	s.AddStoreEventChannel(eventChan)
+	go a.handleEvents(eventChan)
+
+	return a
+}
+
+// handleEvents invalidates cached values when the underlying store notifies
+// that links or evidences were saved.
+func (a *Adapter) handleEvents(eventChan <-chan *store.Event) {
+	for event := range eventChan {
+		switch event.EventType {
+		case store.SavedLinks:
+			a.invalidateFinds()
+		case store.SavedEvidences:
+			evidences, ok := event.Data.(map[string]*cs.Evidence)
+			if !ok {
+				a.invalidateAll()
+				continue
+			}
+			a.mutex.Lock()
+			for linkHash := range evidences {
+				a.segments.remove(linkHash)
+			}
+			a.segmentsGen++
+			a.purgeFinds()
+			a.mutex.Unlock()
+		}
+	}
+}
+
+// invalidateSegment removes a segment from the cache.
+func (a *Adapter) invalidateSegment(linkHash *types.Bytes32) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.segments.remove(linkHash.String())
+	a.segmentsGen++
+	a.purgeFinds()
+}
+
+// invalidateFinds removes all the FindSegments results from the cache.
+func (a *Adapter) invalidateFinds() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.purgeFinds()
+}
+
+// invalidateAll empties the cache.
+func (a *Adapter) invalidateAll() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.segments.purge()
+	a.segmentsGen++
+	a.purgeFinds()
+}
+
+// purgeFinds must be called with the mutex locked.
+func (a *Adapter) purgeFinds() {
+	if a.finds != nil {
+		a.finds.purge()
+	}
+	a.findsGen++
+}
+
+// GetInfo delegates to the underlying store.
+func (a *Adapter) GetInfo(ctx context.Context) (interface{}, error) {
+	return a.s.GetInfo(ctx)
+}
+
+// AddStoreEventChannel delegates to the underlying store.
+func (a *Adapter) AddStoreEventChannel(c chan *store.Event) {
+	a.s.AddStoreEventChannel(c)
+}
+
+// NewBatch delegates to the underlying store.
+// Writing the batch invalidates cached FindSegments results.
+func (a *Adapter) NewBatch(ctx context.Context) (store.Batch, error) {
+	b, err := a.s.NewBatch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Batch{Batch: b, adapter: a}, nil
+}
+
+// AddEvidence delegates to the underlying store and invalidates the cached
+// segment.
+func (a *Adapter) AddEvidence(ctx context.Context, linkHash *types.Bytes32, evidence *cs.Evidence) error {
+	err := a.s.AddEvidence(ctx, linkHash, evidence)
+	a.invalidateSegment(linkHash)
+	return err
+}
+
+// GetEvidences delegates to the underlying store.
+func (a *Adapter) GetEvidences(ctx context.Context, linkHash *types.Bytes32) (*cs.Evidences, error) {
+	return a.s.GetEvidences(ctx, linkHash)
+}
+
+// CreateLink delegates to the underlying store and invalidates cached
+// FindSegments results.
+func (a *Adapter) CreateLink(ctx context.Context, link *cs.Link) (*types.Bytes32, error) {
+	lh, err := a.s.CreateLink(ctx, link)
+	a.invalidateFinds()
+	return lh, err
+}
+
+// CreateLinks delegates to the underlying store and invalidates cached
+// FindSegments results.
+// It implements github.com/stratumn/go-indigocore/store.LinksWriter even if
+// the underlying store doesn't, in which case links are created one by one.
+func (a *Adapter) CreateLinks(ctx context.Context, links []*cs.Link, mode store.WriteMode) ([]*store.LinkResult, error) {
+	res, err := store.CreateLinks(ctx, a.s, links, mode)
+	a.invalidateFinds()
+	return res, err
+}
+
+// GetLinkHeight delegates to the underlying store.
+// It returns store.ErrLinkHeightUnavailable if the underlying store doesn't
+// implement github.com/stratumn/go-indigocore/store.LinkHeightReader.
+func (a *Adapter) GetLinkHeight(ctx context.Context, linkHash *types.Bytes32) (int64, error) {
+	return store.GetLinkHeight(ctx, a.s, linkHash)
+}
+
+// GetSegment returns the cached segment if there is one, otherwise it
+// delegates to the underlying store and caches the result.
+func (a *Adapter) GetSegment(ctx context.Context, linkHash *types.Bytes32) (*cs.Segment, error) {
+	key := linkHash.String()
+
+	a.mutex.Lock()
+	cached, ok := a.segments.get(key)
+	gen := a.segmentsGen
+	a.mutex.Unlock()
+
+	recordLookup(ctx, segmentsCache, ok)
+	if ok {
+		return copySegment(cached.(*cs.Segment)), nil
+	}
+
+	s, err := a.s.GetSegment(ctx, linkHash)
+	if err != nil || s == nil {
+		return s, err
+	}
+
+	a.mutex.Lock()
+	if gen == a.segmentsGen {
+		a.segments.add(key, copySegment(s))
+	}
+	a.mutex.Unlock()
+
+	return s, nil
+}
+
+// FindSegments returns the cached result if there is one, otherwise it
+// delegates to the underlying store and caches the result if the
+// FindSegments cache is enabled.
+func (a *Adapter) FindSegments(ctx context.Context, filter *store.SegmentFilter) (cs.SegmentSlice, error) {
+	if a.finds == nil {
+		return a.s.FindSegments(ctx, filter)
+	}
+
+	js, err := json.Marshal(filter)
+	if err != nil {
+		return a.s.FindSegments(ctx, filter)
+	}
+	key := string(js)
+
+	a.mutex.Lock()
+	cached, ok := a.finds.get(key)
+	gen := a.findsGen
+	a.mutex.Unlock()
+
+	recordLookup(ctx, findSegmentsCache, ok)
+	if ok {
+		return copySegmentSlice(cached.(cs.SegmentSlice)), nil
+	}
+
+	ss, err := a.s.FindSegments(ctx, filter)
+	if err != nil {
+		return ss, err
+	}
+
+	a.mutex.Lock()
+	if gen == a.findsGen {
+		a.finds.add(key, copySegmentSlice(ss))
+	}
+	a.mutex.Unlock()
+
+	return ss, nil
+}
+
+// IsSearchable implements
+// github.com/stratumn/go-indigocore/store.SearchChecker.IsSearchable.
+func (a *Adapter) IsSearchable() bool {
+	return store.IsSearchable(a.s)
+}
+
+// Search delegates to the underlying store.
+// It returns store.ErrSearchUnavailable if the underlying store doesn't
+// implement github.com/stratumn/go-indigocore/store.Searcher.
+func (a *Adapter) Search(ctx context.Context, query *store.SearchQuery) (cs.SegmentSlice, error) {
+	return store.Search(ctx, a.s, query)
+}
+
+// SaveStateOpenings delegates to the underlying store.
+func (a *Adapter) SaveStateOpenings(ctx context.Context, linkHash *types.Bytes32, openings []*cs.StateOpening) error {
+	return store.SaveStateOpenings(ctx, a.s, linkHash, openings)
+}
+
+// GetStateOpenings delegates to the underlying store.
+func (a *Adapter) GetStateOpenings(ctx context.Context, linkHash *types.Bytes32) ([]*cs.StateOpening, error) {
+	return store.GetStateOpenings(ctx, a.s, linkHash)
+}
+
+// PurgeStateOpenings delegates to the underlying store.
+func (a *Adapter) PurgeStateOpenings(ctx context.Context, linkHash *types.Bytes32) error {
+	return store.PurgeStateOpenings(ctx, a.s, linkHash)
+}
+
+// GetMapIDs delegates to the underlying store.
+func (a *Adapter) GetMapIDs(ctx context.Context, filter *store.MapFilter) ([]string, error) {
+	return a.s.GetMapIDs(ctx, filter)
+}
+
+// Batch wraps a batch of the underlying store.
+type Batch struct {
+	store.Batch
+
+	adapter *Adapter
+}
+
+// Write delegates to the underlying batch and invalidates cached
+// FindSegments results.
+func (b *Batch) Write(ctx context.Context) error {
+	err := b.Batch.Write(ctx)
+	b.adapter.invalidateFinds()
+	return err
+}
+
+// copySegment copies a segment so that callers can't modify cached
+// evidences. Links are immutable and are shared.
+func copySegment(s *cs.Segment) *cs.Segment {
+	c := *s
+	if s.Meta.Evidences != nil {
+		c.Meta.Evidences = make(cs.Evidences, len(s.Meta.Evidences))
+		copy(c.Meta.Evidences, s.Meta.Evidences)
+	}
+	return &c
+}
+
+func copySegmentSlice(ss cs.SegmentSlice) cs.SegmentSlice {
+	if ss == nil {
+		return nil
+	}
+	c := make(cs.SegmentSlice, len(ss))
+	for i, s := range ss {
+		c[i] = copySegment(s)
+	}
+	return c
+}
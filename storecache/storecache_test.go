@@ -0,0 +1,245 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storecache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/cs/cstesting"
+	"github.com/stratumn/go-indigocore/dummystore"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/store/storetestcases"
+	"github.com/stratumn/go-indigocore/store/storetesting"
+	"github.com/stratumn/go-indigocore/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreCache(t *testing.T) {
+	storetestcases.Factory{
+		New: func() (store.Adapter, error) {
+			return New(dummystore.New(&dummystore.Config{}), &Config{
+				SegmentsCacheSize:     10,
+				FindSegmentsCacheSize: 10,
+			}), nil
+		},
+	}.RunStoreTests(t)
+}
+
+func newMockAdapter() (*storetesting.MockAdapter, *cs.Segment) {
+	s := cstesting.RandomSegment()
+	a := &storetesting.MockAdapter{}
+	a.MockGetSegment.Fn = func(*types.Bytes32) (*cs.Segment, error) {
+		return s, nil
+	}
+	a.MockFindSegments.Fn = func(*store.SegmentFilter) (cs.SegmentSlice, error) {
+		return cs.SegmentSlice{s}, nil
+	}
+	return a, s
+}
+
+// waitFor polls the condition until it is true or the test times out.
+func waitFor(t *testing.T, cond func() bool) {
+	timeout := time.After(time.Second)
+	for !cond() {
+		select {
+		case <-timeout:
+			t.Fatal("condition not met in time")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestGetSegment(t *testing.T) {
+	ctx := context.Background()
+	a, s := newMockAdapter()
+	c := New(a, &Config{})
+
+	got, err := c.GetSegment(ctx, s.GetLinkHash())
+	require.NoError(t, err)
+	assert.Equal(t, s, got)
+
+	got, err = c.GetSegment(ctx, s.GetLinkHash())
+	require.NoError(t, err)
+	assert.Equal(t, s, got)
+	assert.Equal(t, 1, a.MockGetSegment.CalledCount)
+}
+
+func TestGetSegment_notFound(t *testing.T) {
+	ctx := context.Background()
+	a := &storetesting.MockAdapter{}
+	c := New(a, &Config{})
+
+	lh := cstesting.RandomSegment().GetLinkHash()
+	for i := 0; i < 2; i++ {
+		got, err := c.GetSegment(ctx, lh)
+		require.NoError(t, err)
+		assert.Nil(t, got)
+	}
+	assert.Equal(t, 2, a.MockGetSegment.CalledCount)
+}
+
+func TestGetSegment_eviction(t *testing.T) {
+	ctx := context.Background()
+	a := &storetesting.MockAdapter{}
+	a.MockGetSegment.Fn = func(*types.Bytes32) (*cs.Segment, error) {
+		return cstesting.RandomSegment(), nil
+	}
+	c := New(a, &Config{SegmentsCacheSize: 2})
+
+	lhs := []*types.Bytes32{
+		cstesting.RandomSegment().GetLinkHash(),
+		cstesting.RandomSegment().GetLinkHash(),
+		cstesting.RandomSegment().GetLinkHash(),
+	}
+	for _, lh := range lhs {
+		_, err := c.GetSegment(ctx, lh)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 3, a.MockGetSegment.CalledCount)
+
+	_, err := c.GetSegment(ctx, lhs[0])
+	require.NoError(t, err)
+	assert.Equal(t, 4, a.MockGetSegment.CalledCount, "least recently used segment should be evicted")
+
+	_, err = c.GetSegment(ctx, lhs[2])
+	require.NoError(t, err)
+	assert.Equal(t, 4, a.MockGetSegment.CalledCount)
+}
+
+func TestGetSegment_copy(t *testing.T) {
+	ctx := context.Background()
+	a, s := newMockAdapter()
+	c := New(a, &Config{})
+
+	got, err := c.GetSegment(ctx, s.GetLinkHash())
+	require.NoError(t, err)
+	got.Meta.Evidences = append(got.Meta.Evidences, &cs.Evidence{Provider: "test"})
+
+	got, err = c.GetSegment(ctx, s.GetLinkHash())
+	require.NoError(t, err)
+	assert.Len(t, got.Meta.Evidences, len(s.Meta.Evidences))
+}
+
+func TestAddEvidence_invalidates(t *testing.T) {
+	ctx := context.Background()
+	a, s := newMockAdapter()
+	c := New(a, &Config{})
+
+	_, err := c.GetSegment(ctx, s.GetLinkHash())
+	require.NoError(t, err)
+
+	require.NoError(t, c.AddEvidence(ctx, s.GetLinkHash(), &cs.Evidence{Provider: "test"}))
+	assert.Equal(t, 1, a.MockAddEvidence.CalledCount)
+
+	_, err = c.GetSegment(ctx, s.GetLinkHash())
+	require.NoError(t, err)
+	assert.Equal(t, 2, a.MockGetSegment.CalledCount)
+}
+
+func TestSavedEvidences_invalidates(t *testing.T) {
+	ctx := context.Background()
+	a, s := newMockAdapter()
+	c := New(a, &Config{})
+	cache := c.(*Adapter)
+
+	_, err := c.GetSegment(ctx, s.GetLinkHash())
+	require.NoError(t, err)
+
+	event := store.NewSavedEvidences()
+	event.AddSavedEvidence(s.GetLinkHash(), &cs.Evidence{Provider: "test"})
+	a.MockAddStoreEventChannel.LastCalledWith <- event
+
+	waitFor(t, func() bool {
+		cache.mutex.Lock()
+		defer cache.mutex.Unlock()
+		return cache.segments.len() == 0
+	})
+
+	_, err = c.GetSegment(ctx, s.GetLinkHash())
+	require.NoError(t, err)
+	assert.Equal(t, 2, a.MockGetSegment.CalledCount)
+}
+
+func TestFindSegments_disabled(t *testing.T) {
+	ctx := context.Background()
+	a, _ := newMockAdapter()
+	c := New(a, &Config{})
+
+	for i := 0; i < 2; i++ {
+		_, err := c.FindSegments(ctx, &store.SegmentFilter{})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, a.MockFindSegments.CalledCount)
+}
+
+func TestFindSegments(t *testing.T) {
+	ctx := context.Background()
+	a, s := newMockAdapter()
+	c := New(a, &Config{FindSegmentsCacheSize: 10})
+
+	filter := &store.SegmentFilter{Process: "p"}
+	for i := 0; i < 2; i++ {
+		got, err := c.FindSegments(ctx, filter)
+		require.NoError(t, err)
+		assert.Equal(t, cs.SegmentSlice{s}, got)
+	}
+	assert.Equal(t, 1, a.MockFindSegments.CalledCount)
+
+	_, err := c.FindSegments(ctx, &store.SegmentFilter{Process: "q"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, a.MockFindSegments.CalledCount)
+}
+
+func TestFindSegments_createLinkInvalidates(t *testing.T) {
+	ctx := context.Background()
+	a, _ := newMockAdapter()
+	c := New(a, &Config{FindSegmentsCacheSize: 10})
+
+	_, err := c.FindSegments(ctx, &store.SegmentFilter{})
+	require.NoError(t, err)
+
+	_, err = c.CreateLink(ctx, cstesting.RandomLink())
+	require.NoError(t, err)
+
+	_, err = c.FindSegments(ctx, &store.SegmentFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, a.MockFindSegments.CalledCount)
+}
+
+func TestFindSegments_savedLinksInvalidates(t *testing.T) {
+	ctx := context.Background()
+	a, _ := newMockAdapter()
+	c := New(a, &Config{FindSegmentsCacheSize: 10})
+	cache := c.(*Adapter)
+
+	_, err := c.FindSegments(ctx, &store.SegmentFilter{})
+	require.NoError(t, err)
+
+	a.MockAddStoreEventChannel.LastCalledWith <- store.NewSavedLinks(cstesting.RandomLink())
+
+	waitFor(t, func() bool {
+		cache.mutex.Lock()
+		defer cache.mutex.Unlock()
+		return cache.finds.len() == 0
+	})
+
+	_, err = c.FindSegments(ctx, &store.SegmentFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, a.MockFindSegments.CalledCount)
+}
@@ -17,6 +17,7 @@ package tendermint
 import (
 	log "github.com/sirupsen/logrus"
 	abci "github.com/tendermint/abci/types"
+	"github.com/tendermint/tendermint/blockchain"
 	cfg "github.com/tendermint/tendermint/config"
 	"github.com/tendermint/tendermint/node"
 	"github.com/tendermint/tendermint/proxy"
@@ -49,3 +50,15 @@ func NewNode(config *cfg.Config, app abci.Application) *node.Node {
 	}
 	return ret
 }
+
+// BlockStoreHeight returns the height of the last block saved in the block
+// store of a tendermint node.
+func BlockStoreHeight(config *cfg.Config) (int64, error) {
+	db, err := node.DefaultDBProvider(&node.DBContext{ID: "blockstore", Config: config})
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	return blockchain.LoadBlockStoreStateJSON(db).Height, nil
+}
@@ -0,0 +1,298 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmpop
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/monitoring"
+	"github.com/stratumn/go-indigocore/types"
+	abci "github.com/tendermint/abci/types"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
+)
+
+// DefaultEvidenceBackfillLimit is the default maximum number of blocks for
+// which evidence is generated each time the evidence worker is woken up.
+const DefaultEvidenceBackfillLimit = 100
+
+// tmpopLastEvidenceHeightKey is the database key where the height of the last
+// block for which evidence was generated is saved.
+var tmpopLastEvidenceHeightKey = []byte("tmpop:evidence:lastheight")
+
+// errInvalidBlock is returned when evidence can never be generated for a
+// block, for instance because it isn't properly signed.
+// Such blocks are skipped instead of being retried.
+var errInvalidBlock = errors.New("invalid block")
+
+// blockEvidence is the evidence generated for the links of a block, waiting
+// to be written by Commit.
+type blockEvidence struct {
+	height    int64
+	evidences map[*types.Bytes32]*cs.Evidence
+}
+
+// EvidenceStatus describes the progress of evidence generation.
+type EvidenceStatus struct {
+	// LastHeight is the height of the last block for which evidence was
+	// generated (or that was skipped).
+	LastHeight int64 `json:"lastHeight"`
+
+	// TargetHeight is the height of the last block for which evidence can
+	// currently be generated.
+	TargetHeight int64 `json:"targetHeight"`
+
+	// Behind is the number of blocks waiting for evidence.
+	Behind int64 `json:"behind"`
+
+	// Paused is true if evidence generation is paused.
+	Paused bool `json:"paused"`
+
+	// Running is true while the evidence worker is processing blocks.
+	Running bool `json:"running"`
+}
+
+// getLastEvidenceHeight returns the height of the last block for which
+// evidence was generated.
+func (t *TMPop) getLastEvidenceHeight(ctx context.Context) (int64, error) {
+	value, err := t.kvDB.GetValue(ctx, tmpopLastEvidenceHeightKey)
+	if err != nil || value == nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(string(value), 10, 64)
+}
+
+// saveLastEvidenceHeight saves the height of the last block for which
+// evidence was generated.
+func (t *TMPop) saveLastEvidenceHeight(ctx context.Context, height int64) error {
+	return t.kvDB.SetValue(ctx, tmpopLastEvidenceHeightKey, []byte(strconv.FormatInt(height, 10)))
+}
+
+// initLastEvidenceHeight saves the height of the last block with evidence
+// the first time a store is opened. Chains created before this height was
+// saved already have evidence up to the current height, so back-filling
+// starts from there instead of the genesis block.
+func (t *TMPop) initLastEvidenceHeight(ctx context.Context) error {
+	value, err := t.kvDB.GetValue(ctx, tmpopLastEvidenceHeightKey)
+	if err != nil || value != nil {
+		return err
+	}
+
+	height := t.lastBlock.Height - evidenceDelay
+	if height < 0 {
+		height = 0
+	}
+
+	return t.saveLastEvidenceHeight(ctx, height)
+}
+
+func (t *TMPop) tendermintClient() TendermintClient {
+	t.evidenceMutex.Lock()
+	defer t.evidenceMutex.Unlock()
+	return t.tmClient
+}
+
+// scheduleTendermintEvidence sets the height of the last block for which
+// evidence can be generated and wakes up the evidence worker.
+func (t *TMPop) scheduleTendermintEvidence(header *abci.Header) {
+	// Evidence for block N can only be generated at the beginning of block N+3.
+	// That is because we need signatures for both block N and block N+1
+	// (since the data is always reflected in the next block's AppHash)
+	// so we need block N+1 to be committed.
+	// The signatures for block N+1 will only be included in block N+2 so
+	// we need block N+2 to be committed.
+	targetHeight := header.Height - evidenceDelay
+	if targetHeight <= 0 {
+		return
+	}
+
+	t.evidenceMutex.Lock()
+	defer t.evidenceMutex.Unlock()
+
+	t.evidenceTargetHeight = targetHeight
+	t.evidenceChainID = header.ChainID
+
+	if t.evidencePaused || t.evidenceWake == nil {
+		return
+	}
+
+	t.evidenceRunning = true
+	select {
+	case t.evidenceWake <- struct{}{}:
+	default:
+	}
+}
+
+// runEvidenceWorker generates evidence each time it is woken up. It runs
+// outside of the ABCI connections so that slow Tendermint Core RPCs never
+// stall block production.
+func (t *TMPop) runEvidenceWorker(wake <-chan struct{}) {
+	for range wake {
+		t.addTendermintEvidence(context.Background())
+
+		t.evidenceMutex.Lock()
+		if len(wake) == 0 {
+			t.evidenceRunning = false
+		}
+		t.evidenceMutex.Unlock()
+	}
+}
+
+// addTendermintEvidence computes new evidence and queues it for Commit.
+// Blocks are processed in order from the last block with evidence, so that
+// blocks missed while Tendermint Core was unavailable are back-filled.
+func (t *TMPop) addTendermintEvidence(ctx context.Context) {
+	t.evidenceMutex.Lock()
+	targetHeight := t.evidenceTargetHeight
+	chainID := t.evidenceChainID
+	paused := t.evidencePaused
+	queuedHeight := t.evidenceQueuedHeight
+	t.evidenceMutex.Unlock()
+
+	ctx, span := trace.StartSpan(ctx, "tmpop/addTendermintEvidence")
+	span.AddAttributes(trace.Int64Attribute("TargetHeight", targetHeight))
+	defer span.End()
+
+	lastHeight, err := t.getLastEvidenceHeight(ctx)
+	if err != nil {
+		log.Warnf("Could not get last block with evidence: %s. Evidence will not be generated.", err)
+		span.SetStatus(trace.Status{Code: monitoring.Internal, Message: err.Error()})
+		return
+	}
+	if queuedHeight > lastHeight {
+		lastHeight = queuedHeight
+	}
+
+	if paused {
+		span.Annotate(nil, "Evidence generation is paused")
+		stats.Record(ctx, evidenceBacklog.M(targetHeight-lastHeight))
+		return
+	}
+
+	limit := t.config.EvidenceBackfillLimit
+	if limit <= 0 {
+		limit = DefaultEvidenceBackfillLimit
+	}
+
+	maxHeight := lastHeight + int64(limit)
+	if maxHeight > targetHeight {
+		maxHeight = targetHeight
+	}
+
+	for height := lastHeight + 1; height <= maxHeight; height++ {
+		newEvidences, err := t.buildBlockEvidence(ctx, height, chainID)
+		if err != nil && errors.Cause(err) != errInvalidBlock {
+			log.Warnf("Could not build proofs for block %d: %s. Evidence will be generated when Tendermint Core is available.", height, err)
+			span.SetStatus(trace.Status{Code: monitoring.Unavailable, Message: err.Error()})
+			break
+		}
+
+		status := "generated"
+		if err != nil {
+			status = "skipped"
+			log.Warnf("Could not build proofs for block %d: %s. Evidence will not be generated.", height, err)
+			span.Annotatef(nil, "Evidence for block %d skipped: %s", height, err.Error())
+		}
+
+		// Skipped blocks are queued too so that the last height with
+		// evidence is saved in order. The queue is reset by Commit if it
+		// fails to save that height, in which case this run is dropped.
+		t.evidenceMutex.Lock()
+		if t.evidenceQueuedHeight != queuedHeight {
+			t.evidenceMutex.Unlock()
+			break
+		}
+		t.evidenceQueue = append(t.evidenceQueue, &blockEvidence{height: height, evidences: newEvidences})
+		t.evidenceQueuedHeight = height
+		queuedHeight = height
+		t.evidenceMutex.Unlock()
+
+		statusCtx, _ := tag.New(ctx, tag.Upsert(evidenceStatus, status))
+		stats.Record(statusCtx, evidenceBlockCount.M(1))
+		lastHeight = height
+	}
+
+	stats.Record(ctx, evidenceBacklog.M(targetHeight-lastHeight))
+}
+
+// applyTendermintEvidence writes the evidence queued by the worker to the
+// store. It is called by Commit so that evidence is never written while a
+// block is being committed. If the last height with evidence cannot be
+// saved, the remaining blocks are dropped and generated again by the worker.
+func (t *TMPop) applyTendermintEvidence(ctx context.Context) {
+	t.evidenceMutex.Lock()
+	queue := t.evidenceQueue
+	t.evidenceQueue = nil
+	t.evidenceMutex.Unlock()
+
+	for _, block := range queue {
+		for linkHash, evidence := range block.evidences {
+			if err := t.adapter.AddEvidence(ctx, linkHash, evidence); err != nil {
+				log.Warnf("Evidence could not be added to local store: %v", err)
+			}
+		}
+		if len(block.evidences) > 0 {
+			t.eventsManager.AddSavedEvidences(block.evidences)
+		}
+
+		if err := t.saveLastEvidenceHeight(ctx, block.height); err != nil {
+			log.Warnf("Could not save last block with evidence: %s.", err)
+			t.evidenceMutex.Lock()
+			t.evidenceQueue = nil
+			t.evidenceQueuedHeight = 0
+			t.evidenceMutex.Unlock()
+			return
+		}
+	}
+}
+
+// getEvidenceStatus returns how far behind evidence generation is.
+func (t *TMPop) getEvidenceStatus(ctx context.Context) (*EvidenceStatus, error) {
+	lastHeight, err := t.getLastEvidenceHeight(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	t.evidenceMutex.Lock()
+	targetHeight := t.evidenceTargetHeight
+	paused := t.evidencePaused
+	running := t.evidenceRunning
+	t.evidenceMutex.Unlock()
+
+	// Before the first block since start-up, the target is the one that
+	// will be used at the beginning of the next block.
+	if targetHeight == 0 && t.lastBlock != nil && t.lastBlock.Height+1 > evidenceDelay {
+		targetHeight = t.lastBlock.Height + 1 - evidenceDelay
+	}
+
+	status := &EvidenceStatus{
+		LastHeight:   lastHeight,
+		TargetHeight: targetHeight,
+		Paused:       paused,
+		Running:      running,
+	}
+	if targetHeight > lastHeight {
+		status.Behind = targetHeight - lastHeight
+	}
+
+	return status, nil
+}
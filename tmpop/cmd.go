@@ -18,6 +18,7 @@ import (
 	"context"
 	"runtime"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/stratumn/go-indigocore/store"
 	"github.com/stratumn/go-indigocore/tendermint"
@@ -33,6 +34,16 @@ func Run(a store.Adapter, kv store.KeyValueStore, config *Config) {
 		log.Fatal(err)
 	}
 
+	if config.Snapshot != nil && config.Snapshot.RestorePath != "" {
+		tendermintHeight, err := tendermint.BlockStoreHeight(tendermint.GetConfig())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := restoreSnapshotFromFiles(ctx, a, kv, config.Snapshot, tendermintHeight); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	tmpop, err := New(ctx, a, kv, config)
 	if err != nil {
 		log.Fatal(err)
@@ -52,3 +63,27 @@ func Run(a store.Adapter, kv store.KeyValueStore, config *Config) {
 	tendermintNode.Start()
 	tendermintNode.RunForever()
 }
+
+// restoreSnapshotFromFiles bootstraps the stores from the snapshot and
+// trusted header files given in the configuration.
+func restoreSnapshotFromFiles(ctx context.Context, a store.Adapter, kv store.KeyValueStore, config *SnapshotConfig, tendermintHeight int64) error {
+	snapshot, err := ReadSnapshot(config.RestorePath)
+	if err != nil {
+		return errors.Wrap(err, "cannot read snapshot")
+	}
+
+	if config.TrustedHeaderPath == "" {
+		return ErrMissingTrustedHeader
+	}
+	trusted, err := ReadTrustedHeader(config.TrustedHeaderPath)
+	if err != nil {
+		return errors.Wrap(err, "cannot read trusted header")
+	}
+
+	if err := RestoreSnapshot(ctx, a, kv, snapshot, trusted, tendermintHeight); err != nil {
+		return errors.Wrap(err, "cannot restore snapshot")
+	}
+
+	log.Infof("Restored snapshot at height %d", snapshot.Height)
+	return nil
+}
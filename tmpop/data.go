@@ -106,6 +106,9 @@ func (t *TMPop) saveCommitLinkHashes(ctx context.Context, links []*cs.Link) erro
 		for _, link := range links {
 			linkHash, _ := link.Hash()
 			linkHashes = append(linkHashes, *linkHash)
+			if err := t.saveLinkHeight(ctx, linkHash, t.currentHeader.Height); err != nil {
+				return err
+			}
 		}
 
 		value, err := json.Marshal(linkHashes)
@@ -137,3 +140,54 @@ func (t *TMPop) getCommitLinkHashes(ctx context.Context, height int64) ([]types.
 
 	return linkHashes, nil
 }
+
+func getCommitEvidenceHashesKey(height int64) []byte {
+	key := fmt.Sprintf("tmpop:evidencehashes:%d", height)
+	return []byte(key)
+}
+
+// saveCommitEvidenceHashes saves the hashes of the external evidence added
+// in the current block. They are part of the block's merkle tree so they are
+// needed to generate Tendermint evidence for the links of the block.
+func (t *TMPop) saveCommitEvidenceHashes(ctx context.Context, linkEvidences []*LinkEvidence) error {
+	if len(linkEvidences) > 0 {
+		key := getCommitEvidenceHashesKey(t.currentHeader.Height)
+
+		var evidenceHashes []types.Bytes32
+		for _, e := range linkEvidences {
+			evidenceHash, err := e.Hash()
+			if err != nil {
+				return err
+			}
+			evidenceHashes = append(evidenceHashes, *evidenceHash)
+		}
+
+		value, err := json.Marshal(evidenceHashes)
+		if err != nil {
+			return err
+		}
+
+		if err := t.kvDB.SetValue(ctx, key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getCommitEvidenceHashes gets the hashes of the external evidence added in
+// a block at a specific height.
+func (t *TMPop) getCommitEvidenceHashes(ctx context.Context, height int64) ([]types.Bytes32, error) {
+	key := getCommitEvidenceHashesKey(height)
+	value, err := t.kvDB.GetValue(ctx, key)
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	var evidenceHashes []types.Bytes32
+	if err := json.Unmarshal(value, &evidenceHashes); err != nil {
+		return nil, err
+	}
+
+	return evidenceHashes, nil
+}
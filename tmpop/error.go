@@ -36,6 +36,7 @@ const (
 type ABCIError struct {
 	Code uint32
 	Log  string
+	Data []byte
 }
 
 // IsOK returns true if no error occurred.
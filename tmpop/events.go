@@ -15,6 +15,9 @@
 package tmpop
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"sync"
 
 	"github.com/stratumn/go-indigocore/cs"
@@ -22,12 +25,41 @@ import (
 	"github.com/stratumn/go-indigocore/types"
 )
 
+// MaxEventsBlocks is the maximum number of blocks whose events are returned
+// by a single GetEvents query.
+const MaxEventsBlocks = 100
+
+// DefaultEventsRetention is the default number of blocks whose events are
+// kept.
+const DefaultEventsRetention = 10000
+
+// EventsFilter selects the blocks whose events are returned by GetEvents.
+type EventsFilter struct {
+	// Height of the first block.
+	FromHeight int64 `json:"fromHeight"`
+
+	// Height of the last block. Zero means the last committed block.
+	ToHeight int64 `json:"toHeight"`
+}
+
+// BlockEvents contains the store events produced by a block.
+type BlockEvents struct {
+	Height int64          `json:"height"`
+	Events []*store.Event `json:"events"`
+}
+
 // Tendermint doesn't allow us to fire arbitrary events to notify TMStore.
-// So instead we store pending events here, and TMStore will query them
-// when a new block is produced.
+// So instead we collect the events of the current block here and save them
+// when the block is committed. TMStore then queries the events of the new
+// blocks. Since they are kept per height, any number of clients can read
+// them and replay the blocks they missed.
 type eventsManager struct {
-	pendingEvents []*store.Event
-	lock          sync.Mutex
+	blockEvents []*store.Event
+	lock        sync.Mutex
+
+	// pendingHeight is the height of the last block whose events were
+	// returned by a PendingEvents query.
+	pendingHeight int64
 }
 
 func (e *eventsManager) AddSavedLinks(links []*cs.Link) {
@@ -36,7 +68,7 @@ func (e *eventsManager) AddSavedLinks(links []*cs.Link) {
 
 		e.lock.Lock()
 		defer e.lock.Unlock()
-		e.pendingEvents = append(e.pendingEvents, savedEvent)
+		e.blockEvents = append(e.blockEvents, savedEvent)
 	}
 }
 
@@ -49,17 +81,134 @@ func (e *eventsManager) AddSavedEvidences(evidences map[*types.Bytes32]*cs.Evide
 
 		e.lock.Lock()
 		defer e.lock.Unlock()
-		e.pendingEvents = append(e.pendingEvents, evidenceEvent)
+		e.blockEvents = append(e.blockEvents, evidenceEvent)
 	}
 }
 
-func (e *eventsManager) GetPendingEvents() []*store.Event {
+// FlushBlockEvents returns the events of the current block and resets them.
+func (e *eventsManager) FlushBlockEvents() []*store.Event {
 	e.lock.Lock()
 	defer e.lock.Unlock()
 
-	eventsToDeliver := make([]*store.Event, len(e.pendingEvents))
-	copy(eventsToDeliver, e.pendingEvents)
-	e.pendingEvents = nil
+	events := e.blockEvents
+	e.blockEvents = nil
+
+	return events
+}
+
+func getBlockEventsKey(height int64) []byte {
+	key := fmt.Sprintf("tmpop:events:%d", height)
+	return []byte(key)
+}
+
+// saveBlockEvents saves the events produced by the current block.
+func (t *TMPop) saveBlockEvents(ctx context.Context, events []*store.Event) error {
+	if len(events) > 0 {
+		value, err := json.Marshal(events)
+		if err != nil {
+			return err
+		}
+
+		if err := t.kvDB.SetValue(ctx, getBlockEventsKey(t.currentHeader.Height), value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getBlockEvents gets the events produced by a block at a specific height.
+func (t *TMPop) getBlockEvents(ctx context.Context, height int64) ([]*store.Event, error) {
+	value, err := t.kvDB.GetValue(ctx, getBlockEventsKey(height))
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	var events []*store.Event
+	if err := json.Unmarshal(value, &events); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// eventsRetention returns the number of blocks whose events are kept.
+func (t *TMPop) eventsRetention() int64 {
+	if t.config.EventsRetention > 0 {
+		return t.config.EventsRetention
+	}
+	return DefaultEventsRetention
+}
+
+// pruneBlockEvents deletes the events of the block that just left the
+// retention window.
+func (t *TMPop) pruneBlockEvents(ctx context.Context) error {
+	height := t.currentHeader.Height - t.eventsRetention()
+	if height < 1 {
+		return nil
+	}
+
+	_, err := t.kvDB.DeleteValue(ctx, getBlockEventsKey(height))
+	return err
+}
+
+// getEvents returns the events of the committed blocks selected by the
+// filter, one entry per block even when a block has no events so that
+// clients know up to which height they are in sync.
+// At most MaxEventsBlocks blocks are returned. Blocks whose events were
+// pruned are skipped, so the first block returned can be after the one
+// requested.
+func (t *TMPop) getEvents(ctx context.Context, filter *EventsFilter) ([]*BlockEvents, error) {
+	from := filter.FromHeight
+	if oldest := t.lastBlock.Height - t.eventsRetention() + 1; from < oldest {
+		from = oldest
+	}
+	if from < 1 {
+		from = 1
+	}
+
+	to := filter.ToHeight
+	if to == 0 || to > t.lastBlock.Height {
+		to = t.lastBlock.Height
+	}
+	if to-from >= MaxEventsBlocks {
+		to = from + MaxEventsBlocks - 1
+	}
+
+	blocks := []*BlockEvents{}
+	for h := from; h <= to; h++ {
+		events, err := t.getBlockEvents(ctx, h)
+		if err != nil {
+			return nil, err
+		}
+		if events == nil {
+			events = []*store.Event{}
+		}
+
+		blocks = append(blocks, &BlockEvents{Height: h, Events: events})
+	}
+
+	return blocks, nil
+}
+
+// getPendingEvents returns the events of the blocks committed since the
+// previous call. It backs the deprecated PendingEvents query, whose events
+// are delivered only once whatever the number of clients.
+func (t *TMPop) getPendingEvents(ctx context.Context) ([]*store.Event, error) {
+	t.eventsManager.lock.Lock()
+	defer t.eventsManager.lock.Unlock()
+
+	lastHeight := t.lastBlock.Height
+	events := []*store.Event{}
+	for h := t.eventsManager.pendingHeight + 1; h <= lastHeight; h++ {
+		blockEvents, err := t.getBlockEvents(ctx, h)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, blockEvents...)
+	}
+
+	t.eventsManager.pendingHeight = lastHeight
 
-	return eventsToDeliver
+	return events, nil
 }
@@ -75,13 +75,47 @@ func (p *TendermintProof) FullProof() []byte {
 	return bytes
 }
 
-// Verify returns true if the proof of a given linkHash is correct
+// Verify returns true if the proof of a given linkHash is correct.
+// The votes are checked against the validator sets embedded in the proof,
+// which anyone can forge. Use a Verifier to check them against trusted
+// validators.
 func (p *TendermintProof) Verify(linkHash interface{}) bool {
 	lh, ok := linkHash.(*types.Bytes32)
 	if ok != true {
 		return false
 	}
 
+	if !p.VerifyInclusion(lh) {
+		return false
+	}
+
+	// If validator set doesn't match the header's validatorHash,
+	// someone tampered with the validator set.
+	if !p.validateValidatorSet() {
+		return false
+	}
+
+	// We validate that nodes signed the header.
+	if !p.validateVotes(p.Header, p.HeaderVotes, p.HeaderValidatorSet) {
+		return false
+	}
+
+	// We validate that nodes signed the next header.
+	if !p.validateVotes(p.NextHeader, p.NextHeaderVotes, p.NextHeaderValidatorSet) {
+		return false
+	}
+
+	return true
+}
+
+// VerifyInclusion returns true if the merkle path leads from the link hash
+// to the root and the next header's app hash commits to that root.
+// It does not check who signed the headers.
+func (p *TendermintProof) VerifyInclusion(lh *types.Bytes32) bool {
+	if lh == nil || p.Header == nil || p.NextHeader == nil || p.Root == nil {
+		return false
+	}
+
 	// We first verify that the app hash is correct
 
 	hash := sha256.New()
@@ -126,22 +160,6 @@ func (p *TendermintProof) Verify(linkHash interface{}) bool {
 		}
 	}
 
-	// If validator set doesn't match the header's validatorHash,
-	// someone tampered with the validator set.
-	if !p.validateValidatorSet() {
-		return false
-	}
-
-	// We validate that nodes signed the header.
-	if !p.validateVotes(p.Header, p.HeaderVotes, p.HeaderValidatorSet) {
-		return false
-	}
-
-	// We validate that nodes signed the next header.
-	if !p.validateVotes(p.NextHeader, p.NextHeaderVotes, p.NextHeaderValidatorSet) {
-		return false
-	}
-
 	return true
 }
 
@@ -0,0 +1,229 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evidences
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/stratumn/go-indigocore/types"
+	tmtypes "github.com/tendermint/tendermint/types"
+)
+
+var (
+	// ErrInvalidProof is returned when a proof is not internally consistent.
+	ErrInvalidProof = errors.New("invalid Tendermint proof")
+
+	// ErrChainIDMismatch is returned when a header belongs to another chain.
+	ErrChainIDMismatch = errors.New("header chain ID doesn't match")
+
+	// ErrUntrustedHeight is returned when a header is older than the
+	// oldest trusted validator set.
+	ErrUntrustedHeight = errors.New("no trusted validator set at header height")
+
+	// ErrInsufficientVotingPower is returned when trusted validators with
+	// more than 2/3 of the voting power did not sign a header.
+	ErrInsufficientVotingPower = errors.New("header not signed by more than 2/3 of trusted voting power")
+
+	// ErrInvalidValidatorSet is returned when a validator set doesn't match
+	// the header announcing it.
+	ErrInvalidValidatorSet = errors.New("validator set doesn't match header")
+
+	// ErrUntrustedValidatorSet is returned when a header was produced by
+	// another validator set than the one trusted at its height, usually
+	// because a validator set change was not followed with Update.
+	ErrUntrustedValidatorSet = errors.New("header validators hash doesn't match trusted validator set")
+)
+
+// ValidatorSetChange is a header signed by its validators, used to move the
+// trust of a Verifier to a new validator set.
+type ValidatorSetChange struct {
+	Header       *tmtypes.Header       `json:"header"`
+	Votes        []*TendermintVote     `json:"votes"`
+	ValidatorSet *tmtypes.ValidatorSet `json:"validator_set"`
+}
+
+type checkpoint struct {
+	height     int64
+	validators *tmtypes.ValidatorSet
+}
+
+// Verifier verifies Tendermint proofs the way a light client would.
+// Votes are checked against validator sets it trusts instead of the
+// validator sets embedded in the proofs, which anyone can forge.
+type Verifier struct {
+	chainID     string
+	mutex       sync.RWMutex
+	checkpoints []checkpoint
+}
+
+// NewVerifier creates a verifier trusting the given validator set from the
+// given height, usually the genesis validators or a checkpoint obtained
+// out of band.
+func NewVerifier(chainID string, height int64, validators *tmtypes.ValidatorSet) *Verifier {
+	return &Verifier{
+		chainID:     chainID,
+		checkpoints: []checkpoint{{height: height, validators: validators}},
+	}
+}
+
+// Height returns the height of the latest trusted validator set.
+func (v *Verifier) Height() int64 {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+
+	return v.checkpoints[len(v.checkpoints)-1].height
+}
+
+// Update follows a validator set change. The change is accepted if the
+// header announces the new validator set and is signed by more than 2/3 of
+// the voting power of both the latest trusted set and the new set.
+// Changes of more than 1/3 of the voting power must be followed through
+// intermediate validator sets.
+func (v *Verifier) Update(change *ValidatorSetChange) error {
+	if change == nil || change.Header == nil || change.ValidatorSet == nil {
+		return ErrInvalidValidatorSet
+	}
+	if change.Header.ChainID != v.chainID {
+		return ErrChainIDMismatch
+	}
+	if change.Header.ValidatorsHash == nil ||
+		!bytes.Equal(change.ValidatorSet.Hash(), change.Header.ValidatorsHash.Bytes()) {
+		return ErrInvalidValidatorSet
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	latest := v.checkpoints[len(v.checkpoints)-1]
+	if change.Header.Height <= latest.height {
+		return errors.Errorf("validator set change at height %d is not after trusted height %d", change.Header.Height, latest.height)
+	}
+
+	if err := verifyVotingPower(change.Header, change.Votes, latest.validators); err != nil {
+		return err
+	}
+	if err := verifyVotingPower(change.Header, change.Votes, change.ValidatorSet); err != nil {
+		return err
+	}
+
+	v.checkpoints = append(v.checkpoints, checkpoint{
+		height:     change.Header.Height,
+		validators: change.ValidatorSet,
+	})
+
+	return nil
+}
+
+// Verify checks that a proof is valid for the given link hash and that its
+// headers are signed by trusted validators.
+func (v *Verifier) Verify(proof *TendermintProof, linkHash *types.Bytes32) error {
+	if proof == nil || !proof.VerifyInclusion(linkHash) {
+		return ErrInvalidProof
+	}
+
+	// The next header must follow the header, otherwise votes for an
+	// unrelated header could be used to sign its app hash.
+	if proof.NextHeader.Height != proof.Header.Height+1 ||
+		!bytes.Equal(proof.NextHeader.LastBlockID.Hash.Bytes(), proof.Header.Hash().Bytes()) {
+		return ErrInvalidProof
+	}
+
+	if err := v.verifyHeader(proof.Header, proof.HeaderVotes); err != nil {
+		return err
+	}
+
+	return v.verifyHeader(proof.NextHeader, proof.NextHeaderVotes)
+}
+
+func (v *Verifier) verifyHeader(header *tmtypes.Header, votes []*TendermintVote) error {
+	if header.ChainID != v.chainID {
+		return ErrChainIDMismatch
+	}
+
+	validators := v.validatorsAt(header.Height)
+	if validators == nil {
+		return ErrUntrustedHeight
+	}
+
+	// Votes of the trusted validators are only meaningful if they are the
+	// validators of the block.
+	if header.ValidatorsHash == nil || !bytes.Equal(validators.Hash(), header.ValidatorsHash.Bytes()) {
+		return ErrUntrustedValidatorSet
+	}
+
+	return verifyVotingPower(header, votes, validators)
+}
+
+// validatorsAt returns the latest trusted validator set at the given height.
+func (v *Verifier) validatorsAt(height int64) *tmtypes.ValidatorSet {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+
+	i := sort.Search(len(v.checkpoints), func(i int) bool {
+		return v.checkpoints[i].height > height
+	})
+	if i == 0 {
+		return nil
+	}
+
+	return v.checkpoints[i-1].validators
+}
+
+// verifyVotingPower checks that validators of the set with more than 2/3 of
+// its voting power signed the header. Votes are verified with the public
+// keys of the set, not the ones given with the votes. Votes from unknown
+// validators or with invalid signatures are ignored.
+func verifyVotingPower(header *tmtypes.Header, votes []*TendermintVote, validators *tmtypes.ValidatorSet) error {
+	headerHash := header.Hash().Bytes()
+	signed := make(map[int]bool)
+	votesPower := int64(0)
+
+	for _, vote := range votes {
+		if vote == nil || vote.Vote == nil || vote.Vote.Height != header.Height {
+			continue
+		}
+		if !bytes.Equal(vote.Vote.BlockID.Hash.Bytes(), headerHash) {
+			continue
+		}
+
+		index := -1
+		for i, val := range validators.Validators {
+			if bytes.Equal(val.Address.Bytes(), vote.Vote.ValidatorAddress.Bytes()) {
+				index = i
+				break
+			}
+		}
+		if index < 0 || signed[index] {
+			continue
+		}
+
+		validator := validators.Validators[index]
+		if err := vote.Vote.Verify(header.ChainID, validator.PubKey); err != nil {
+			continue
+		}
+
+		signed[index] = true
+		votesPower += validator.VotingPower
+	}
+
+	if 3*votesPower <= 2*validators.TotalVotingPower() {
+		return ErrInsufficientVotingPower
+	}
+
+	return nil
+}
@@ -0,0 +1,287 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evidences_test
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/stratumn/go-indigocore/testutil"
+	"github.com/stratumn/go-indigocore/tmpop/evidences"
+	"github.com/stratumn/go-indigocore/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	crypto "github.com/tendermint/go-crypto"
+	tmtypes "github.com/tendermint/tendermint/types"
+)
+
+const testChainID = "testchain"
+
+// testValidators is a validator set with the private keys of its
+// validators.
+type testValidators struct {
+	set  *tmtypes.ValidatorSet
+	keys []crypto.PrivKeyEd25519
+}
+
+func newTestValidators(powers ...int64) *testValidators {
+	v := &testValidators{set: &tmtypes.ValidatorSet{}}
+	for _, power := range powers {
+		sk := crypto.GenPrivKeyEd25519()
+		pk := sk.PubKey()
+		v.set.Validators = append(v.set.Validators, &tmtypes.Validator{
+			Address:     pk.Address(),
+			PubKey:      pk,
+			VotingPower: power,
+		})
+		v.keys = append(v.keys, sk)
+	}
+	return v
+}
+
+// union returns a validator set containing the validators of both sets.
+func (v *testValidators) union(other *testValidators) *testValidators {
+	u := &testValidators{set: &tmtypes.ValidatorSet{}}
+	for _, s := range []*testValidators{v, other} {
+		u.set.Validators = append(u.set.Validators, s.set.Validators...)
+		u.keys = append(u.keys, s.keys...)
+	}
+	return u
+}
+
+// sign creates the votes of the validators at the given indexes for a
+// header, or of all validators if no index is given.
+func (v *testValidators) sign(header *tmtypes.Header, indexes ...int) []*evidences.TendermintVote {
+	if len(indexes) == 0 {
+		for i := range v.set.Validators {
+			indexes = append(indexes, i)
+		}
+	}
+
+	var votes []*evidences.TendermintVote
+	for _, i := range indexes {
+		validator := v.set.Validators[i]
+		vote := &evidences.TendermintVote{
+			PubKey: &validator.PubKey,
+			Vote: &tmtypes.Vote{
+				BlockID:          tmtypes.BlockID{Hash: header.Hash()},
+				Height:           header.Height,
+				ValidatorAddress: validator.Address,
+				ValidatorIndex:   i,
+			},
+		}
+		vote.Vote.Signature = v.keys[i].Sign(vote.Vote.SignBytes(header.ChainID))
+		votes = append(votes, vote)
+	}
+
+	return votes
+}
+
+func (v *testValidators) header(height int64) *tmtypes.Header {
+	return &tmtypes.Header{
+		AppHash:        testutil.RandomHash()[:],
+		ChainID:        testChainID,
+		Height:         height,
+		LastBlockID:    tmtypes.BlockID{Hash: testutil.RandomHash()[:]},
+		Time:           time.Unix(height, 0),
+		ValidatorsHash: v.set.Hash(),
+	}
+}
+
+// change creates a validator set change to v signed by the given
+// validators.
+func (v *testValidators) change(height int64, signers *testValidators) *evidences.ValidatorSetChange {
+	header := v.header(height)
+	votes := signers.sign(header)
+	return &evidences.ValidatorSetChange{
+		Header:       header,
+		Votes:        votes,
+		ValidatorSet: v.set,
+	}
+}
+
+// proof creates a valid proof at the given height signed by the
+// validators.
+func (v *testValidators) proof(height int64) (*types.Bytes32, *evidences.TendermintProof) {
+	validationsHash := testutil.RandomHash()
+	linkHash, tree, path := createMerkleTree(3)
+
+	header := v.header(height)
+
+	hash := sha256.New()
+	hash.Write(header.AppHash)
+	hash.Write(validationsHash[:])
+	hash.Write(tree.Root())
+
+	nextHeader := v.header(height + 1)
+	nextHeader.AppHash = hash.Sum(nil)
+	nextHeader.LastBlockID = tmtypes.BlockID{Hash: header.Hash()}
+
+	return linkHash, &evidences.TendermintProof{
+		BlockHeight:            height,
+		Root:                   types.NewBytes32FromBytes(tree.Root()),
+		Path:                   path,
+		ValidationsHash:        validationsHash,
+		Header:                 header,
+		HeaderVotes:            v.sign(header),
+		HeaderValidatorSet:     v.set,
+		NextHeader:             nextHeader,
+		NextHeaderVotes:        v.sign(nextHeader),
+		NextHeaderValidatorSet: v.set,
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	trusted := newTestValidators(30, 10, 20)
+
+	t.Run("Accepts proofs signed by trusted validators", func(t *testing.T) {
+		v := evidences.NewVerifier(testChainID, 1, trusted.set)
+		linkHash, proof := trusted.proof(42)
+		assert.NoError(t, v.Verify(proof, linkHash))
+	})
+
+	t.Run("Rejects proofs signed by forged validators", func(t *testing.T) {
+		v := evidences.NewVerifier(testChainID, 1, trusted.set)
+		forged := newTestValidators(30, 10, 20)
+		linkHash, proof := forged.proof(42)
+		require.True(t, proof.Verify(linkHash), "Forged proof should be self-consistent")
+		assert.Equal(t, evidences.ErrUntrustedValidatorSet, v.Verify(proof, linkHash))
+	})
+
+	t.Run("Rejects forged votes for trusted validators hash", func(t *testing.T) {
+		v := evidences.NewVerifier(testChainID, 1, trusted.set)
+		forged := newTestValidators(30, 10, 20)
+		linkHash, proof := trusted.proof(42)
+		proof.HeaderVotes = forged.sign(proof.Header)
+		assert.Equal(t, evidences.ErrInsufficientVotingPower, v.Verify(proof, linkHash))
+	})
+
+	t.Run("Rejects headers of another validator set", func(t *testing.T) {
+		// The trusted validators sign headers announcing other validators,
+		// but the verifier did not follow that change.
+		v := evidences.NewVerifier(testChainID, 1, trusted.set)
+		other := newTestValidators(10)
+		linkHash, proof := trusted.proof(42)
+		proof.Header.ValidatorsHash = other.set.Hash()
+		proof.HeaderVotes = trusted.sign(proof.Header)
+		proof.NextHeader.LastBlockID = tmtypes.BlockID{Hash: proof.Header.Hash()}
+		proof.NextHeaderVotes = trusted.sign(proof.NextHeader)
+		assert.Equal(t, evidences.ErrUntrustedValidatorSet, v.Verify(proof, linkHash))
+	})
+
+	t.Run("Ignores public keys given with votes", func(t *testing.T) {
+		v := evidences.NewVerifier(testChainID, 1, trusted.set)
+		forged := newTestValidators(30, 10, 20)
+		linkHash, proof := forged.proof(42)
+		for i, vote := range proof.HeaderVotes {
+			vote.Vote.ValidatorAddress = trusted.set.Validators[i].Address
+		}
+		assert.Error(t, v.Verify(proof, linkHash))
+	})
+
+	t.Run("Rejects proofs without 2/3 of trusted voting power", func(t *testing.T) {
+		v := evidences.NewVerifier(testChainID, 1, trusted.set)
+		linkHash, proof := trusted.proof(42)
+		proof.NextHeaderVotes = trusted.sign(proof.NextHeader, 0, 1)
+		assert.Error(t, v.Verify(proof, linkHash))
+	})
+
+	t.Run("Does not count duplicate votes", func(t *testing.T) {
+		v := evidences.NewVerifier(testChainID, 1, trusted.set)
+		linkHash, proof := trusted.proof(42)
+		proof.HeaderVotes = trusted.sign(proof.Header, 1, 1, 2, 2)
+		assert.Equal(t, evidences.ErrInsufficientVotingPower, v.Verify(proof, linkHash))
+	})
+
+	t.Run("Rejects proofs of another chain", func(t *testing.T) {
+		v := evidences.NewVerifier("otherchain", 1, trusted.set)
+		linkHash, proof := trusted.proof(42)
+		assert.Equal(t, evidences.ErrChainIDMismatch, v.Verify(proof, linkHash))
+	})
+
+	t.Run("Rejects proofs before the checkpoint", func(t *testing.T) {
+		v := evidences.NewVerifier(testChainID, 100, trusted.set)
+		linkHash, proof := trusted.proof(42)
+		assert.Equal(t, evidences.ErrUntrustedHeight, v.Verify(proof, linkHash))
+	})
+
+	t.Run("Rejects unrelated next headers", func(t *testing.T) {
+		v := evidences.NewVerifier(testChainID, 1, trusted.set)
+		linkHash, proof := trusted.proof(42)
+		proof.NextHeader.LastBlockID = tmtypes.BlockID{Hash: testutil.RandomHash()[:]}
+		proof.NextHeaderVotes = trusted.sign(proof.NextHeader)
+		assert.Equal(t, evidences.ErrInvalidProof, v.Verify(proof, linkHash))
+	})
+
+	t.Run("Rejects invalid proofs", func(t *testing.T) {
+		v := evidences.NewVerifier(testChainID, 1, trusted.set)
+		linkHash, proof := trusted.proof(42)
+		proof.Root = testutil.RandomHash()
+		assert.Equal(t, evidences.ErrInvalidProof, v.Verify(proof, linkHash))
+	})
+}
+
+func TestVerifier_Update(t *testing.T) {
+	genesis := newTestValidators(30, 10, 20)
+	joining := newTestValidators(10)
+	extended := genesis.union(joining)
+	replaced := newTestValidators(30, 10, 20)
+
+	t.Run("Follows validator set changes", func(t *testing.T) {
+		v := evidences.NewVerifier(testChainID, 1, genesis.set)
+		require.NoError(t, v.Update(extended.change(10, extended)))
+		assert.Equal(t, int64(10), v.Height())
+
+		linkHash, proof := extended.proof(42)
+		assert.NoError(t, v.Verify(proof, linkHash))
+
+		// Proofs before the change are still verified with the genesis
+		// validators.
+		linkHash, proof = genesis.proof(5)
+		assert.NoError(t, v.Verify(proof, linkHash))
+	})
+
+	t.Run("Rejects changes not signed by trusted validators", func(t *testing.T) {
+		v := evidences.NewVerifier(testChainID, 1, genesis.set)
+		assert.Equal(t, evidences.ErrInsufficientVotingPower, v.Update(replaced.change(10, replaced)))
+		assert.Equal(t, int64(1), v.Height())
+
+		linkHash, proof := replaced.proof(42)
+		assert.Error(t, v.Verify(proof, linkHash))
+	})
+
+	t.Run("Follows changes through intermediate sets", func(t *testing.T) {
+		v := evidences.NewVerifier(testChainID, 1, genesis.set)
+		intermediate := genesis.union(replaced)
+		require.NoError(t, v.Update(intermediate.change(10, intermediate)))
+		require.NoError(t, v.Update(replaced.change(20, intermediate)))
+
+		linkHash, proof := replaced.proof(42)
+		assert.NoError(t, v.Verify(proof, linkHash))
+	})
+
+	t.Run("Rejects invalid validator sets", func(t *testing.T) {
+		v := evidences.NewVerifier(testChainID, 1, genesis.set)
+		change := extended.change(10, extended)
+		change.ValidatorSet = replaced.set
+		assert.Equal(t, evidences.ErrInvalidValidatorSet, v.Update(change))
+	})
+
+	t.Run("Rejects old changes", func(t *testing.T) {
+		v := evidences.NewVerifier(testChainID, 10, genesis.set)
+		assert.Error(t, v.Update(extended.change(5, extended)))
+	})
+}
@@ -0,0 +1,53 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmpop
+
+import (
+	"flag"
+)
+
+var (
+	snapshotInterval          int64
+	snapshotDirectory         string
+	snapshotRestorePath       string
+	snapshotTrustedHeaderPath string
+	eventsRetention           int64
+)
+
+// RegisterFlags registers the command-line TMPoP flags.
+func RegisterFlags() {
+	flag.Int64Var(&snapshotInterval, "snapshot_interval", 0, "Number of blocks between two state snapshots (0 to disable snapshots)")
+	flag.StringVar(&snapshotDirectory, "snapshot_dir", "snapshots", "Path to the directory where state snapshots are written")
+	flag.StringVar(&snapshotRestorePath, "snapshot_restore", "", "Path to a state snapshot to bootstrap a new node from")
+	flag.StringVar(&snapshotTrustedHeaderPath, "snapshot_trusted_header", "", "Path to the JSON encoded Tendermint header of the block following the snapshot, obtained from a trusted node")
+	flag.Int64Var(&eventsRetention, "events_retention", DefaultEventsRetention, "Number of blocks whose store events are kept for clients catching up")
+}
+
+// SnapshotConfigurationFromFlags builds snapshot configuration from
+// user-provided command-line flags.
+func SnapshotConfigurationFromFlags() *SnapshotConfig {
+	return &SnapshotConfig{
+		Interval:          snapshotInterval,
+		Directory:         snapshotDirectory,
+		RestorePath:       snapshotRestorePath,
+		TrustedHeaderPath: snapshotTrustedHeaderPath,
+	}
+}
+
+// EventsRetentionFromFlags returns the number of blocks whose events are
+// kept, as given by the command-line flags.
+func EventsRetentionFromFlags() int64 {
+	return eventsRetention
+}
@@ -0,0 +1,610 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmpop
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/monitoring"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/tmpop/evidences"
+	"github.com/stratumn/go-indigocore/types"
+	abci "github.com/tendermint/abci/types"
+
+	"go.opencensus.io/trace"
+)
+
+// evidenceDelay is the number of blocks after which Tendermint evidence is
+// added to the links of a block (see addTendermintEvidence).
+const evidenceDelay = 3
+
+func getAppHashKey(height int64) []byte {
+	key := fmt.Sprintf("tmpop:apphash:%d", height)
+	return []byte(key)
+}
+
+// linkHeightsMigratedKey is set once the links committed before link heights
+// were recorded have been indexed.
+var linkHeightsMigratedKey = []byte("tmpop:linkheight:migrated")
+
+func getLinkHeightKey(linkHash *types.Bytes32) []byte {
+	key := fmt.Sprintf("tmpop:linkheight:%x", linkHash[:])
+	return []byte(key)
+}
+
+// linkEvidencesMigratedKey is set once the evidence committed before link
+// evidence heights were recorded has been indexed.
+var linkEvidencesMigratedKey = []byte("tmpop:linkevidence:migrated")
+
+func getLinkEvidenceKey(linkHash *types.Bytes32, provider string) []byte {
+	key := fmt.Sprintf("tmpop:linkevidence:%x:%s", linkHash[:], provider)
+	return []byte(key)
+}
+
+// saveAppHash saves the app hash computed for the current block.
+func (t *TMPop) saveAppHash(ctx context.Context, appHash *types.Bytes32) error {
+	return t.kvDB.SetValue(ctx, getAppHashKey(t.currentHeader.Height), appHash[:])
+}
+
+// getAppHash gets the app hash computed for a block at a specific height.
+func (t *TMPop) getAppHash(ctx context.Context, height int64) (*types.Bytes32, error) {
+	if height == t.lastBlock.Height {
+		return t.lastBlock.AppHash, nil
+	}
+
+	value, err := t.kvDB.GetValue(ctx, getAppHashKey(height))
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	return types.NewBytes32FromBytes(value), nil
+}
+
+// saveLinkHeight saves the height of the block in which a link was committed.
+func (t *TMPop) saveLinkHeight(ctx context.Context, linkHash *types.Bytes32, height int64) error {
+	return t.kvDB.SetValue(ctx, getLinkHeightKey(linkHash), []byte(strconv.FormatInt(height, 10)))
+}
+
+// getLinkHeight returns the height of the block in which a link was
+// committed, or zero if it was not committed. It only reads the index so it
+// is safe to call from the Query connection.
+func (t *TMPop) getLinkHeight(ctx context.Context, linkHash *types.Bytes32) (int64, error) {
+	value, err := t.kvDB.GetValue(ctx, getLinkHeightKey(linkHash))
+	if err != nil || value == nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(string(value), 10, 64)
+}
+
+// saveLinkEvidenceHeight saves the height of the block in which an evidence
+// transaction added evidence from a provider to a link.
+// Only one evidence per provider can be committed for a link, so these keys
+// are the consensus state that evidence transactions are checked against.
+func saveLinkEvidenceHeight(ctx context.Context, kv store.KeyValueStore, linkHash *types.Bytes32, provider string, height int64) error {
+	return kv.SetValue(ctx, getLinkEvidenceKey(linkHash, provider), []byte(strconv.FormatInt(height, 10)))
+}
+
+// getLinkEvidenceHeight returns the height of the block in which an evidence
+// transaction added evidence from a provider to a link, or zero if there is
+// none.
+func getLinkEvidenceHeight(ctx context.Context, kv store.KeyValueReader, linkHash *types.Bytes32, provider string) (int64, error) {
+	value, err := kv.GetValue(ctx, getLinkEvidenceKey(linkHash, provider))
+	if err != nil || value == nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(string(value), 10, 64)
+}
+
+// migrateLinkHeights indexes the height of the links committed before link
+// heights were recorded. It runs once when the app starts, before any
+// connection is served.
+func (t *TMPop) migrateLinkHeights(ctx context.Context) error {
+	migrated, err := t.kvDB.GetValue(ctx, linkHeightsMigratedKey)
+	if err != nil || migrated != nil {
+		return err
+	}
+
+	for h := int64(1); h <= t.lastBlock.Height; h++ {
+		linkHashes, err := t.getCommitLinkHashes(ctx, h)
+		if err != nil {
+			return err
+		}
+		for i := range linkHashes {
+			if err := t.saveLinkHeight(ctx, &linkHashes[i], h); err != nil {
+				return err
+			}
+		}
+	}
+
+	return t.kvDB.SetValue(ctx, linkHeightsMigratedKey, []byte{1})
+}
+
+// migrateEvidenceHeights indexes the evidence committed before link
+// evidence heights were recorded. Only the hashes of that evidence were
+// saved, so the segments of the store are scanned to find it. Like
+// migrateLinkHeights, it runs once when the app starts.
+func (t *TMPop) migrateEvidenceHeights(ctx context.Context) error {
+	migrated, err := t.kvDB.GetValue(ctx, linkEvidencesMigratedKey)
+	if err != nil || migrated != nil {
+		return err
+	}
+
+	heights := make(map[types.Bytes32]int64)
+	for h := int64(1); h <= t.lastBlock.Height; h++ {
+		evidenceHashes, err := t.getCommitEvidenceHashes(ctx, h)
+		if err != nil {
+			return err
+		}
+		for _, evidenceHash := range evidenceHashes {
+			heights[evidenceHash] = h
+		}
+	}
+
+	for offset := 0; len(heights) > 0; offset += store.MaxLimit {
+		segments, err := t.adapter.FindSegments(ctx, &store.SegmentFilter{
+			Pagination: store.Pagination{Offset: offset, Limit: store.MaxLimit},
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, segment := range segments {
+			for _, e := range segment.Meta.Evidences {
+				linkEvidence := &LinkEvidence{LinkHash: segment.GetLinkHash(), Evidence: e}
+				evidenceHash, err := linkEvidence.Hash()
+				if err != nil {
+					return err
+				}
+				if h, ok := heights[*evidenceHash]; ok {
+					if err := saveLinkEvidenceHeight(ctx, t.kvDB, linkEvidence.LinkHash, e.Provider, h); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		if len(segments) < store.MaxLimit {
+			break
+		}
+	}
+
+	return t.kvDB.SetValue(ctx, linkEvidencesMigratedKey, []byte{1})
+}
+
+// committedAt returns true if a link was committed at or before the given
+// height.
+func (t *TMPop) committedAt(ctx context.Context, linkHash *types.Bytes32, height int64) (bool, error) {
+	linkHeight, err := t.getLinkHeight(ctx, linkHash)
+	if err != nil {
+		return false, err
+	}
+
+	return linkHeight > 0 && linkHeight <= height, nil
+}
+
+// linksCommittedBetween returns the hashes of the links committed in the
+// blocks whose height is in the range (from, to]. It reads the link hashes
+// saved for each block so its cost depends on the number of blocks in the
+// range rather than on the number of segments in the store.
+func (t *TMPop) linksCommittedBetween(ctx context.Context, from, to int64) ([]types.Bytes32, error) {
+	var linkHashes []types.Bytes32
+	for h := from + 1; h <= to; h++ {
+		committed, err := t.getCommitLinkHashes(ctx, h)
+		if err != nil {
+			return nil, err
+		}
+		linkHashes = append(linkHashes, committed...)
+	}
+
+	return linkHashes, nil
+}
+
+// segmentAt removes the evidence that was not yet added to a segment at the
+// given height. Tendermint evidence is added by the app a fixed number of
+// blocks after the link, other evidence is kept only if it was committed by
+// an evidence transaction at or before the height.
+func (t *TMPop) segmentAt(ctx context.Context, segment *cs.Segment, height int64) (*cs.Segment, error) {
+	kept := cs.Evidences{}
+	for _, e := range segment.Meta.Evidences {
+		if p, ok := e.Proof.(*evidences.TendermintProof); ok {
+			if p.BlockHeight+evidenceDelay <= height {
+				kept = append(kept, e)
+			}
+			continue
+		}
+
+		evidenceHeight, err := getLinkEvidenceHeight(ctx, t.kvDB, segment.GetLinkHash(), e.Provider)
+		if err != nil {
+			return nil, err
+		}
+		if evidenceHeight > 0 && evidenceHeight <= height {
+			kept = append(kept, e)
+		}
+	}
+	segment.Meta.Evidences = kept
+
+	return segment, nil
+}
+
+// getSegmentAt returns a segment as it was at the given height, or nil if it
+// did not exist yet.
+func (t *TMPop) getSegmentAt(ctx context.Context, linkHash *types.Bytes32, height int64) (*cs.Segment, error) {
+	committed, err := t.committedAt(ctx, linkHash, height)
+	if err != nil || !committed {
+		return nil, err
+	}
+
+	segment, err := t.adapter.GetSegment(ctx, linkHash)
+	if err != nil || segment == nil {
+		return nil, err
+	}
+
+	return t.segmentAt(ctx, segment, height)
+}
+
+// findSegmentsAt returns the segments matching the filter as they were at
+// the given height.
+//
+// It reads the links committed on the shorter side of the height. For recent
+// heights, the links committed after the height are excluded from the
+// segments of the store. For older heights, the store is only searched for
+// the links committed up to the height.
+func (t *TMPop) findSegmentsAt(ctx context.Context, filter *store.SegmentFilter, height int64) (cs.SegmentSlice, error) {
+	var (
+		segments cs.SegmentSlice
+		err      error
+	)
+	if t.lastBlock.Height-height <= height {
+		segments, err = t.findSegmentsExcluding(ctx, filter, height)
+	} else {
+		segments, err = t.findSegmentsAmong(ctx, filter, height)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range segments {
+		if segments[i], err = t.segmentAt(ctx, segments[i], height); err != nil {
+			return nil, err
+		}
+	}
+
+	return segments, nil
+}
+
+// findSegmentsExcluding returns the segments matching the filter, skipping
+// the links committed after the given height.
+func (t *TMPop) findSegmentsExcluding(ctx context.Context, filter *store.SegmentFilter, height int64) (cs.SegmentSlice, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = store.DefaultLimit
+	}
+
+	later, err := t.linksCommittedBetween(ctx, height, t.lastBlock.Height)
+	if err != nil {
+		return nil, err
+	}
+	excluded := make(map[types.Bytes32]struct{}, len(later))
+	for _, lh := range later {
+		excluded[lh] = struct{}{}
+	}
+
+	// At most len(excluded) segments are skipped before the requested page.
+	page := *filter
+	page.Offset = 0
+	page.Limit = store.MaxLimit
+
+	res := cs.SegmentSlice{}
+	skipped := 0
+	for {
+		segments, err := t.adapter.FindSegments(ctx, &page)
+		if err != nil {
+			return nil, err
+		}
+		if len(segments) == 0 {
+			return res, nil
+		}
+
+		for _, segment := range segments {
+			if _, ok := excluded[*segment.GetLinkHash()]; ok {
+				continue
+			}
+			if skipped < filter.Offset {
+				skipped++
+				continue
+			}
+
+			res = append(res, segment)
+			if len(res) == limit {
+				return res, nil
+			}
+		}
+
+		page.Offset += len(segments)
+	}
+}
+
+// findSegmentsAmong returns the segments matching the filter among the links
+// committed at or before the given height.
+func (t *TMPop) findSegmentsAmong(ctx context.Context, filter *store.SegmentFilter, height int64) (cs.SegmentSlice, error) {
+	committed, err := t.linksCommittedBetween(ctx, 0, height)
+	if err != nil {
+		return nil, err
+	}
+
+	var requested map[string]struct{}
+	if len(filter.LinkHashes) > 0 {
+		requested = make(map[string]struct{}, len(filter.LinkHashes))
+		for _, lh := range filter.LinkHashes {
+			requested[lh] = struct{}{}
+		}
+	}
+
+	linkHashes := make([]string, 0, len(committed))
+	for _, lh := range committed {
+		s := lh.String()
+		if _, ok := requested[s]; requested == nil || ok {
+			linkHashes = append(linkHashes, s)
+		}
+	}
+	if len(linkHashes) == 0 {
+		return cs.SegmentSlice{}, nil
+	}
+
+	page := *filter
+	page.LinkHashes = linkHashes
+	if page.Limit <= 0 {
+		page.Limit = store.DefaultLimit
+	}
+
+	return t.adapter.FindSegments(ctx, &page)
+}
+
+// getMapIDsAt returns the IDs of the maps that had at least one segment at
+// the given height. Like findSegmentsAt, it only reads the links committed on
+// the shorter side of the height.
+func (t *TMPop) getMapIDsAt(ctx context.Context, filter *store.MapFilter, height int64) ([]string, error) {
+	if t.lastBlock.Height-height <= height {
+		return t.getMapIDsExcluding(ctx, filter, height)
+	}
+	return t.getMapIDsAmong(ctx, filter, height)
+}
+
+// getMapIDsExcluding returns the map IDs of the store, skipping the maps
+// whose segments were all committed after the given height.
+func (t *TMPop) getMapIDsExcluding(ctx context.Context, filter *store.MapFilter, height int64) ([]string, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = store.DefaultLimit
+	}
+
+	later, err := t.linksCommittedBetween(ctx, height, t.lastBlock.Height)
+	if err != nil {
+		return nil, err
+	}
+	segments, err := t.findLinks(ctx, later, filter.Process)
+	if err != nil {
+		return nil, err
+	}
+
+	excluded := make(map[string]struct{})
+	for _, segment := range segments {
+		mapID := segment.Link.Meta.MapID
+		if _, ok := excluded[mapID]; ok {
+			continue
+		}
+		before, err := t.findSegmentsExcluding(ctx, &store.SegmentFilter{
+			Pagination: store.Pagination{Limit: 1},
+			MapIDs:     []string{mapID},
+			Process:    filter.Process,
+		}, height)
+		if err != nil {
+			return nil, err
+		}
+		if len(before) == 0 {
+			excluded[mapID] = struct{}{}
+		}
+	}
+
+	page := *filter
+	page.Offset = 0
+	page.Limit = store.MaxLimit
+
+	res := []string{}
+	skipped := 0
+	for {
+		mapIDs, err := t.adapter.GetMapIDs(ctx, &page)
+		if err != nil {
+			return nil, err
+		}
+		if len(mapIDs) == 0 {
+			return res, nil
+		}
+
+		for _, mapID := range mapIDs {
+			if _, ok := excluded[mapID]; ok {
+				continue
+			}
+			if skipped < filter.Offset {
+				skipped++
+				continue
+			}
+
+			res = append(res, mapID)
+			if len(res) == limit {
+				return res, nil
+			}
+		}
+
+		page.Offset += len(mapIDs)
+	}
+}
+
+// getMapIDsAmong returns the sorted map IDs of the links committed at or
+// before the given height.
+func (t *TMPop) getMapIDsAmong(ctx context.Context, filter *store.MapFilter, height int64) ([]string, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = store.DefaultLimit
+	}
+
+	committed, err := t.linksCommittedBetween(ctx, 0, height)
+	if err != nil {
+		return nil, err
+	}
+	segments, err := t.findLinks(ctx, committed, filter.Process)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{})
+	mapIDs := []string{}
+	for _, segment := range segments {
+		mapID := segment.Link.Meta.MapID
+		if _, ok := seen[mapID]; !ok {
+			seen[mapID] = struct{}{}
+			mapIDs = append(mapIDs, mapID)
+		}
+	}
+	sort.Strings(mapIDs)
+
+	pagination := store.Pagination{Offset: filter.Offset, Limit: limit}
+	return pagination.PaginateStrings(mapIDs), nil
+}
+
+// findLinks returns the segments of the given links in a process.
+func (t *TMPop) findLinks(ctx context.Context, linkHashes []types.Bytes32, process string) (cs.SegmentSlice, error) {
+	var segments cs.SegmentSlice
+	for len(linkHashes) > 0 {
+		n := len(linkHashes)
+		if n > store.MaxLimit {
+			n = store.MaxLimit
+		}
+
+		filter := &store.SegmentFilter{
+			Pagination: store.Pagination{Limit: n},
+			Process:    process,
+		}
+		for _, lh := range linkHashes[:n] {
+			filter.LinkHashes = append(filter.LinkHashes, lh.String())
+		}
+
+		found, err := t.adapter.FindSegments(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, found...)
+		linkHashes = linkHashes[n:]
+	}
+
+	return segments, nil
+}
+
+// queryAt answers a query on the state of the store at a past height.
+// The app hash of that height is returned hex encoded in the Info field of
+// the response so that the result can be checked against the block headers.
+//
+// App hashes are only saved since historical queries were introduced, so
+// queries at heights committed before a node was upgraded fail with "app
+// hash of block <height> is unknown". The first height that can be queried
+// is the first block committed by the upgraded node.
+func (t *TMPop) queryAt(ctx context.Context, reqQuery abci.RequestQuery) (resQuery abci.ResponseQuery) {
+	ctx, span := trace.StartSpan(ctx, "tmpop/queryAt")
+	span.AddAttributes(trace.Int64Attribute("Height", reqQuery.Height))
+	defer span.End()
+
+	height := reqQuery.Height
+	if height < 0 || height > t.lastBlock.Height {
+		resQuery.Code = CodeTypeInternalError
+		resQuery.Log = fmt.Sprintf("height %d is not committed yet (last block is %d)", height, t.lastBlock.Height)
+		span.SetStatus(trace.Status{Code: monitoring.InvalidArgument, Message: resQuery.Log})
+		return
+	}
+
+	appHash, err := t.getAppHash(ctx, height)
+	if err == nil && appHash == nil {
+		err = fmt.Errorf("app hash of block %d is unknown", height)
+	}
+	if err != nil {
+		resQuery.Code = CodeTypeInternalError
+		resQuery.Log = err.Error()
+		span.SetStatus(trace.Status{Code: monitoring.Unavailable, Message: resQuery.Log})
+		return
+	}
+
+	resQuery.Height = height
+	resQuery.Info = appHash.String()
+
+	var result interface{}
+
+	switch reqQuery.Path {
+	case GetSegment:
+		linkHash := &types.Bytes32{}
+		if err = linkHash.UnmarshalJSON(reqQuery.Data); err != nil {
+			break
+		}
+
+		result, err = t.getSegmentAt(ctx, linkHash, height)
+
+	case FindSegments:
+		filter := &store.SegmentFilter{}
+		if err = json.Unmarshal(reqQuery.Data, filter); err != nil {
+			break
+		}
+
+		result, err = t.findSegmentsAt(ctx, filter, height)
+
+	case GetMapIDs:
+		filter := &store.MapFilter{}
+		if err = json.Unmarshal(reqQuery.Data, filter); err != nil {
+			break
+		}
+
+		result, err = t.getMapIDsAt(ctx, filter, height)
+
+	default:
+		resQuery.Code = CodeTypeNotImplemented
+		resQuery.Log = fmt.Sprintf("Query path %v does not support heights", reqQuery.Path)
+		span.SetStatus(trace.Status{Code: monitoring.Unimplemented, Message: resQuery.Log})
+		return
+	}
+
+	if err != nil {
+		resQuery.Code = CodeTypeInternalError
+		resQuery.Log = err.Error()
+		span.SetStatus(trace.Status{Code: monitoring.Internal, Message: resQuery.Log})
+		return
+	}
+
+	if result != nil {
+		resBytes, err := json.Marshal(result)
+		if err != nil {
+			resQuery.Code = CodeTypeInternalError
+			resQuery.Log = err.Error()
+			span.SetStatus(trace.Status{Code: monitoring.Internal, Message: resQuery.Log})
+		}
+
+		resQuery.Value = resBytes
+	}
+
+	return
+}
@@ -24,6 +24,7 @@ import (
 	"go.opencensus.io/stats"
 	"go.opencensus.io/stats/view"
 	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
 )
 
 const (
@@ -37,6 +38,12 @@ var (
 	txCount    *stats.Int64Measure
 	txPerBlock *stats.Int64Measure
 	txStatus   tag.Key
+
+	evidenceBlockCount *stats.Int64Measure
+	evidenceBacklog    *stats.Int64Measure
+	evidenceStatus     tag.Key
+
+	views []*view.View
 )
 
 func init() {
@@ -58,12 +65,28 @@ func init() {
 		stats.UnitNone,
 	)
 
+	evidenceBlockCount = stats.Int64(
+		"stratumn/indigocore/tmpop/evidence_block_count",
+		"number of blocks processed to generate evidence",
+		stats.UnitNone,
+	)
+
+	evidenceBacklog = stats.Int64(
+		"stratumn/indigocore/tmpop/evidence_backlog",
+		"number of blocks waiting for evidence",
+		stats.UnitNone,
+	)
+
 	var err error
 	if txStatus, err = tag.NewKey("tx_status"); err != nil {
 		log.Fatal(err)
 	}
 
-	if err = view.Register(
+	if evidenceStatus, err = tag.NewKey("evidence_status"); err != nil {
+		log.Fatal(err)
+	}
+
+	views = []*view.View{
 		&view.View{
 			Name:        "stratumn_indigocore_tmpop_block_count",
 			Description: "number of blocks created",
@@ -82,11 +105,44 @@ func init() {
 			Description: "number of transactions per block",
 			Measure:     txPerBlock,
 			Aggregation: view.Distribution(1, 5, 10, 50, 100),
-		}); err != nil {
+		},
+		&view.View{
+			Name:        "stratumn_indigocore_tmpop_evidence_block_count",
+			Description: "number of blocks processed to generate evidence",
+			Measure:     evidenceBlockCount,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{evidenceStatus},
+		},
+		&view.View{
+			Name:        "stratumn_indigocore_tmpop_evidence_backlog",
+			Description: "number of blocks waiting for evidence",
+			Measure:     evidenceBacklog,
+			Aggregation: view.Distribution(1, 10, 100, 1000, 10000),
+		},
+	}
+
+	if err = view.Register(views...); err != nil {
 		log.Fatal(err)
 	}
 }
 
+// enableMetrics starts or stops recording TMPoP's metrics and traces.
+func enableMetrics(enabled bool, config *monitoring.Config) error {
+	if !enabled {
+		view.Unregister(views...)
+		trace.ApplyConfig(trace.Config{DefaultSampler: trace.NeverSample()})
+		return nil
+	}
+
+	samplingRatio := 1.0
+	if config != nil {
+		samplingRatio = config.TraceSamplingRatio
+	}
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.ProbabilitySampler(samplingRatio)})
+
+	return view.Register(views...)
+}
+
 // exposeMetrics configures metrics and traces exporters and
 // exposes them to collectors.
 func exposeMetrics(config *monitoring.Config) {
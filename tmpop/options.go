@@ -0,0 +1,139 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmpop
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Runtime options that can be set with SetOption.
+// They only change how this node operates, so they can be set on each node
+// independently. They are saved so that they survive restarts.
+const (
+	// OptionLogLevel sets the log level ("debug", "info", "warning",
+	// "error").
+	OptionLogLevel = "log_level"
+
+	// OptionMetrics enables or disables metrics and traces ("true" or
+	// "false").
+	OptionMetrics = "metrics"
+
+	// OptionEvidencePaused pauses or resumes the generation of Tendermint
+	// evidence ("true" or "false"). Blocks committed while evidence is
+	// paused are back-filled when it is resumed.
+	OptionEvidencePaused = "evidence_paused"
+)
+
+// runtimeOptions lists the runtime options in the order they are applied
+// at start-up.
+var runtimeOptions = []string{
+	OptionLogLevel,
+	OptionMetrics,
+	OptionEvidencePaused,
+}
+
+// consensusOptions are options that would make nodes disagree on the app
+// hash if they were set on a single node. They can only be changed with an
+// UpdateGovernance transaction, which is applied by every node at the same
+// height.
+var consensusOptions = map[string]bool{
+	"pki":              true,
+	"types":            true,
+	"validation_rules": true,
+	"rules_path":       true,
+	"chain_id":         true,
+}
+
+func getOptionKey(key string) []byte {
+	return []byte(fmt.Sprintf("tmpop:option:%s", key))
+}
+
+// setOption applies a runtime option and saves it.
+func (t *TMPop) setOption(ctx context.Context, key, value string) *ABCIError {
+	if consensusOptions[key] {
+		return &ABCIError{
+			Code: CodeTypeValidation,
+			Log:  fmt.Sprintf("Option %s affects consensus and can only be changed with an %s transaction", key, UpdateGovernance),
+		}
+	}
+
+	if res := t.applyOption(ctx, key, value); !res.IsOK() {
+		return res
+	}
+
+	if err := t.kvDB.SetValue(ctx, getOptionKey(key), []byte(value)); err != nil {
+		return &ABCIError{Code: CodeTypeInternalError, Log: err.Error()}
+	}
+
+	return nil
+}
+
+// applyOption applies a runtime option without saving it.
+func (t *TMPop) applyOption(ctx context.Context, key, value string) *ABCIError {
+	switch key {
+	case OptionLogLevel:
+		level, err := log.ParseLevel(value)
+		if err != nil {
+			return &ABCIError{Code: CodeTypeValidation, Log: err.Error()}
+		}
+		log.SetLevel(level)
+
+	case OptionMetrics:
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return &ABCIError{Code: CodeTypeValidation, Log: err.Error()}
+		}
+		if err := enableMetrics(enabled, t.config.Monitoring); err != nil {
+			return &ABCIError{Code: CodeTypeInternalError, Log: err.Error()}
+		}
+
+	case OptionEvidencePaused:
+		paused, err := strconv.ParseBool(value)
+		if err != nil {
+			return &ABCIError{Code: CodeTypeValidation, Log: err.Error()}
+		}
+		t.evidenceMutex.Lock()
+		t.evidencePaused = paused
+		t.evidenceMutex.Unlock()
+
+	default:
+		return &ABCIError{Code: CodeTypeNotImplemented, Log: fmt.Sprintf("Unknown option %s", key)}
+	}
+
+	return nil
+}
+
+// loadOptions applies the runtime options saved by previous runs.
+func (t *TMPop) loadOptions(ctx context.Context) error {
+	for _, key := range runtimeOptions {
+		value, err := t.kvDB.GetValue(ctx, getOptionKey(key))
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			continue
+		}
+
+		if res := t.applyOption(ctx, key, string(value)); !res.IsOK() {
+			log.Warnf("Could not apply saved option %s: %s", key, res.Log)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmpop
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/tmpop/evidences"
+	"github.com/stratumn/go-indigocore/types"
+)
+
+// proofCacheSize is the number of blocks for which inclusion proofs are kept
+// in memory.
+const proofCacheSize = 256
+
+// ErrProofUnavailable is returned when the inclusion proof of a segment
+// cannot be built yet, usually because the blocks signing it are not
+// committed.
+var ErrProofUnavailable = errors.New("inclusion proof is not available yet")
+
+// getSegmentProof returns the Tendermint proof that a segment was included
+// in a block. It is the proof of the segment's Tendermint evidence if it has
+// one, otherwise it is built from the blocks if they are committed.
+func (t *TMPop) getSegmentProof(ctx context.Context, segment *cs.Segment) (*evidences.TendermintProof, error) {
+	for _, e := range segment.Meta.Evidences {
+		if p, ok := e.Proof.(*evidences.TendermintProof); ok && e.Backend == Name {
+			return p, nil
+		}
+	}
+
+	linkHash := segment.GetLinkHash()
+	height, err := t.getLinkHeight(ctx, linkHash)
+	if err != nil {
+		return nil, err
+	}
+
+	// The votes signing a block are only known once the two following
+	// blocks are committed.
+	if height == 0 || height+2 > t.lastBlock.Height {
+		return nil, ErrProofUnavailable
+	}
+
+	proofs, err := t.blockProofs(ctx, height)
+	if err != nil {
+		return nil, err
+	}
+
+	proof, ok := proofs[*linkHash]
+	if !ok {
+		return nil, ErrProofUnavailable
+	}
+
+	return proof, nil
+}
+
+// marshalSegmentProof returns the JSON encoded inclusion proof of a segment
+// to be set in a query response, or nil if it is not available.
+func (t *TMPop) marshalSegmentProof(ctx context.Context, segment *cs.Segment) []byte {
+	proof, err := t.getSegmentProof(ctx, segment)
+	if err != nil {
+		if err != ErrProofUnavailable {
+			log.Warnf("Could not build inclusion proof of %s: %s", segment.GetLinkHashString(), err)
+		}
+		return nil
+	}
+
+	proofBytes, err := json.Marshal(proof)
+	if err != nil {
+		return nil
+	}
+
+	return proofBytes
+}
+
+// blockProofs returns the inclusion proofs of the links of a block. Blocks
+// are immutable once signed so their proofs are built once and cached.
+func (t *TMPop) blockProofs(ctx context.Context, height int64) (map[types.Bytes32]*evidences.TendermintProof, error) {
+	if proofs, ok := t.proofCache.get(height); ok {
+		return proofs, nil
+	}
+
+	proofs, err := t.tendermintProofs(ctx, height)
+	if err != nil {
+		return nil, err
+	}
+
+	t.proofCache.add(height, proofs)
+
+	return proofs, nil
+}
+
+// proofCache is a fixed-size least-recently-used cache of the inclusion
+// proofs of blocks. It is safe for concurrent use.
+type proofCache struct {
+	mu     sync.Mutex
+	size   int
+	ll     *list.List
+	blocks map[int64]*list.Element
+}
+
+type proofCacheEntry struct {
+	height int64
+	proofs map[types.Bytes32]*evidences.TendermintProof
+}
+
+func newProofCache(size int) *proofCache {
+	return &proofCache{
+		size:   size,
+		ll:     list.New(),
+		blocks: make(map[int64]*list.Element),
+	}
+}
+
+// get returns the proofs of the block at the given height.
+func (c *proofCache) get(height int64) (map[types.Bytes32]*evidences.TendermintProof, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.blocks[height]; ok {
+		c.ll.MoveToFront(e)
+		return e.Value.(*proofCacheEntry).proofs, true
+	}
+
+	return nil, false
+}
+
+// add stores the proofs of a block, evicting the least recently used block
+// if the cache is full.
+func (c *proofCache) add(height int64, proofs map[types.Bytes32]*evidences.TendermintProof) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.blocks[height]; ok {
+		c.ll.MoveToFront(e)
+		e.Value.(*proofCacheEntry).proofs = proofs
+		return
+	}
+
+	c.blocks[height] = c.ll.PushFront(&proofCacheEntry{height: height, proofs: proofs})
+
+	if c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.blocks, oldest.Value.(*proofCacheEntry).height)
+	}
+}
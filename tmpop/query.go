@@ -18,12 +18,19 @@ import "encoding/json"
 
 // Query types.
 const (
-	AddEvidence   = "AddEvidence"
-	FindSegments  = "FindSegments"
-	GetEvidences  = "GetEvidences"
-	GetInfo       = "GetInfo"
-	GetMapIDs     = "GetMapIDs"
-	GetSegment    = "GetSegment"
+	AddEvidence       = "AddEvidence"
+	FindSegments      = "FindSegments"
+	GetEvidences      = "GetEvidences"
+	GetInfo           = "GetInfo"
+	GetMapIDs         = "GetMapIDs"
+	GetSegment        = "GetSegment"
+	GetEvents         = "GetEvents"
+	GetEvidenceStatus = "GetEvidenceStatus"
+	GetLinkHeight     = "GetLinkHeight"
+
+	// PendingEvents returns the events of the blocks committed since the
+	// previous PendingEvents query.
+	// Deprecated: events are delivered to a single client, use GetEvents.
 	PendingEvents = "PendingEvents"
 )
 
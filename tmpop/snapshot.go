@@ -0,0 +1,751 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmpop
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/monitoring"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/tmpop/evidences"
+	"github.com/stratumn/go-indigocore/types"
+	"github.com/stratumn/go-indigocore/validator"
+	"github.com/stratumn/merkle"
+	abci "github.com/tendermint/abci/types"
+	tmtypes "github.com/tendermint/tendermint/types"
+
+	"go.opencensus.io/trace"
+)
+
+// Errors returned when restoring a snapshot.
+var (
+	ErrAlreadyInitialized   = errors.New("cannot restore a snapshot on a node that already has blocks")
+	ErrMissingTrustedHeader = errors.New("a trusted header is required to restore a snapshot")
+	ErrUntrustedSnapshot    = errors.New("snapshot doesn't match the trusted header")
+	ErrInconsistentSnapshot = errors.New("snapshot is inconsistent")
+	ErrHeightMismatch       = errors.New("snapshot height doesn't match the Tendermint block store height")
+)
+
+// SnapshotConfig contains configuration options for snapshots.
+type SnapshotConfig struct {
+	// Interval is the number of blocks between two snapshots.
+	// Snapshots are disabled when it is zero.
+	Interval int64
+
+	// Directory is the directory where snapshots are written.
+	Directory string
+
+	// RestorePath is the path of a snapshot to bootstrap the node from.
+	// The Tendermint block store must already be at the snapshot height.
+	RestorePath string
+
+	// TrustedHeaderPath is the path of a JSON encoded Tendermint header
+	// at the height following the snapshot, obtained from a trusted source.
+	TrustedHeaderPath string
+}
+
+// Snapshot is a consistent export of the state of TMPoP at a given height.
+// Governance contains the validation rules that were saved by the node
+// without a transaction (loaded from a rules file), which the validator
+// hash of the blocks depends on.
+type Snapshot struct {
+	ChainID        string           `json:"chainId"`
+	Height         int64            `json:"height"`
+	AppHash        *types.Bytes32   `json:"appHash"`
+	LastHeader     *abci.Header     `json:"lastHeader"`
+	EvidenceHeight int64            `json:"evidenceHeight"`
+	Blocks         []*SnapshotBlock `json:"blocks"`
+	Segments       cs.SegmentSlice  `json:"segments"`
+	Governance     cs.SegmentSlice  `json:"governance,omitempty"`
+}
+
+// SnapshotBlock contains what TMPoP saved about a committed block.
+// AppHash is nil for the blocks committed before app hashes were recorded.
+type SnapshotBlock struct {
+	Height         int64               `json:"height"`
+	AppHash        *types.Bytes32      `json:"appHash,omitempty"`
+	ValidatorHash  *types.Bytes32      `json:"validatorHash,omitempty"`
+	LinkHashes     []types.Bytes32     `json:"linkHashes,omitempty"`
+	EvidenceHashes []types.Bytes32     `json:"evidenceHashes,omitempty"`
+	Evidences      []*SnapshotEvidence `json:"evidences,omitempty"`
+}
+
+// SnapshotEvidence identifies the evidence added to a link by an evidence
+// transaction.
+type SnapshotEvidence struct {
+	LinkHash *types.Bytes32 `json:"linkHash"`
+	Provider string         `json:"provider"`
+}
+
+// snapshotView is the state of the last committed block captured when a
+// snapshot starts. Everything TMPoP saves for a block is written once when
+// the block is committed, so the data of the blocks up to the view's height
+// can be exported while the next blocks are processed.
+type snapshotView struct {
+	lastBlock      LastBlock
+	evidenceHeight int64
+}
+
+// snapshotView captures the state of the last committed block.
+// It must be called from the consensus connection.
+func (t *TMPop) snapshotView(ctx context.Context) (*snapshotView, error) {
+	evidenceHeight, err := t.getLastEvidenceHeight(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &snapshotView{
+		lastBlock:      *t.lastBlock,
+		evidenceHeight: evidenceHeight,
+	}, nil
+}
+
+// Snapshot exports the state of TMPoP at the last committed block.
+// It must not be called concurrently with block processing.
+func (t *TMPop) Snapshot(ctx context.Context) (*Snapshot, error) {
+	view, err := t.snapshotView(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.exportSnapshot(ctx, view)
+}
+
+// exportSnapshot exports the state of TMPoP at the height of a view.
+// It only reads data that doesn't change once a block is committed, so it
+// can run concurrently with block processing. Evidence added to segments
+// after the view was captured is only kept if it wasn't committed by
+// Tendermint or by an evidence transaction.
+func (t *TMPop) exportSnapshot(ctx context.Context, view *snapshotView) (_ *Snapshot, err error) {
+	ctx, span := trace.StartSpan(ctx, "tmpop/Snapshot")
+	span.AddAttributes(trace.Int64Attribute("Height", view.lastBlock.Height))
+	defer monitoring.SetSpanStatusAndEnd(span, err)
+
+	snapshot := &Snapshot{
+		Height:         view.lastBlock.Height,
+		AppHash:        view.lastBlock.AppHash,
+		LastHeader:     view.lastBlock.LastHeader,
+		EvidenceHeight: view.evidenceHeight,
+		Segments:       cs.SegmentSlice{},
+	}
+	if view.lastBlock.LastHeader != nil {
+		snapshot.ChainID = view.lastBlock.LastHeader.ChainID
+	}
+
+	committed := make(map[types.Bytes32]struct{})
+	for height := int64(1); height <= view.lastBlock.Height; height++ {
+		block := &SnapshotBlock{Height: height}
+
+		if block.AppHash, err = t.snapshotAppHash(ctx, view, height); err != nil {
+			return nil, err
+		}
+
+		if block.ValidatorHash, err = t.getValidatorHash(ctx, height); err != nil {
+			return nil, err
+		}
+
+		if block.LinkHashes, err = t.getCommitLinkHashes(ctx, height); err != nil {
+			return nil, err
+		}
+
+		if block.EvidenceHashes, err = t.getCommitEvidenceHashes(ctx, height); err != nil {
+			return nil, err
+		}
+
+		for _, lh := range block.LinkHashes {
+			linkHash := lh
+			segment, err := t.adapter.GetSegment(ctx, &linkHash)
+			if err != nil {
+				return nil, err
+			}
+			if segment == nil {
+				return nil, errors.Errorf("segment %x committed in block %d is missing", lh, height)
+			}
+			snapshot.Segments = append(snapshot.Segments, evidenceAt(segment, view.evidenceHeight))
+			committed[lh] = struct{}{}
+		}
+
+		snapshot.Blocks = append(snapshot.Blocks, block)
+	}
+
+	if snapshot.Governance, err = t.uncommittedGovernance(ctx, committed); err != nil {
+		return nil, err
+	}
+
+	if err := t.exportLinkEvidences(ctx, snapshot); err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// exportLinkEvidences adds to the blocks of a snapshot the evidence that
+// their evidence transactions committed, and removes from the segments the
+// evidence committed after the snapshot's height.
+func (t *TMPop) exportLinkEvidences(ctx context.Context, snapshot *Snapshot) error {
+	for _, segments := range []cs.SegmentSlice{snapshot.Segments, snapshot.Governance} {
+		for _, segment := range segments {
+			linkHash, err := segment.Link.Hash()
+			if err != nil {
+				return err
+			}
+
+			kept := cs.Evidences{}
+			for _, e := range segment.Meta.Evidences {
+				height, err := getLinkEvidenceHeight(ctx, t.kvDB, linkHash, e.Provider)
+				if err != nil {
+					return err
+				}
+				if height > snapshot.Height {
+					continue
+				}
+				if height > 0 {
+					block := snapshot.Blocks[height-1]
+					block.Evidences = append(block.Evidences, &SnapshotEvidence{LinkHash: linkHash, Provider: e.Provider})
+				}
+				kept = append(kept, e)
+			}
+			segment.Meta.Evidences = kept
+		}
+	}
+
+	return nil
+}
+
+// snapshotAppHash returns the app hash of a block, or nil if it was
+// committed before app hashes were recorded. The app hashes of the last
+// block of a view and of the block before it are always known.
+func (t *TMPop) snapshotAppHash(ctx context.Context, view *snapshotView, height int64) (*types.Bytes32, error) {
+	if height == view.lastBlock.Height {
+		return view.lastBlock.AppHash, nil
+	}
+
+	value, err := t.kvDB.GetValue(ctx, getAppHashKey(height))
+	if err != nil {
+		return nil, err
+	}
+	if value != nil {
+		return types.NewBytes32FromBytes(value), nil
+	}
+
+	// The header of a block contains the app hash of the previous block.
+	if header := view.lastBlock.LastHeader; header != nil && header.Height == height+1 && len(header.AppHash) > 0 {
+		return types.NewBytes32FromBytes(header.AppHash), nil
+	}
+
+	return nil, nil
+}
+
+// uncommittedGovernance returns the governance segments that were not
+// committed in a block.
+func (t *TMPop) uncommittedGovernance(ctx context.Context, committed map[types.Bytes32]struct{}) (cs.SegmentSlice, error) {
+	governance := cs.SegmentSlice{}
+	for offset := 0; ; offset += store.MaxLimit {
+		segments, err := t.adapter.FindSegments(ctx, &store.SegmentFilter{
+			Pagination: store.Pagination{Offset: offset, Limit: store.MaxLimit},
+			Process:    validator.GovernanceProcessName,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, segment := range segments {
+			linkHash, err := segment.Link.Hash()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := committed[*linkHash]; !ok {
+				governance = append(governance, segment)
+			}
+		}
+
+		if len(segments) < store.MaxLimit {
+			return governance, nil
+		}
+	}
+}
+
+// evidenceAt removes the Tendermint evidence of the blocks after the given
+// evidence height.
+func evidenceAt(segment *cs.Segment, evidenceHeight int64) *cs.Segment {
+	kept := cs.Evidences{}
+	for _, e := range segment.Meta.Evidences {
+		if p, ok := e.Proof.(*evidences.TendermintProof); ok && p.BlockHeight > evidenceHeight {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	segment.Meta.Evidences = kept
+
+	return segment
+}
+
+// saveSnapshot starts writing a snapshot of the last committed block to the
+// snapshot directory. The snapshot is exported in the background so that
+// block processing doesn't wait for it. It is skipped if the previous
+// snapshot is still being written.
+func (t *TMPop) saveSnapshot(ctx context.Context) {
+	if !atomic.CompareAndSwapInt32(&t.snapshotting, 0, 1) {
+		log.Warnf("Snapshot at height %d skipped: the previous snapshot is still being written", t.lastBlock.Height)
+		return
+	}
+
+	view, err := t.snapshotView(ctx)
+	if err != nil {
+		log.Errorf("Could not create snapshot at height %d: %s", t.lastBlock.Height, err)
+		atomic.StoreInt32(&t.snapshotting, 0)
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&t.snapshotting, 0)
+
+		ctx, span := trace.StartSpan(context.Background(), "tmpop/saveSnapshot")
+		defer span.End()
+
+		snapshot, err := t.exportSnapshot(ctx, view)
+		if err != nil {
+			log.Errorf("Could not create snapshot at height %d: %s", view.lastBlock.Height, err)
+			span.SetStatus(trace.Status{Code: monitoring.Internal, Message: err.Error()})
+			return
+		}
+
+		path := filepath.Join(t.config.Snapshot.Directory, fmt.Sprintf("snapshot-%d.json", snapshot.Height))
+		if err := WriteSnapshot(snapshot, path); err != nil {
+			log.Errorf("Could not write snapshot at height %d: %s", snapshot.Height, err)
+			span.SetStatus(trace.Status{Code: monitoring.Internal, Message: err.Error()})
+			return
+		}
+
+		log.Infof("Snapshot at height %d written to %s", snapshot.Height, path)
+	}()
+}
+
+// WriteSnapshot writes a snapshot to a file.
+// The file is replaced atomically so that readers never see a partial
+// snapshot.
+func WriteSnapshot(snapshot *Snapshot, path string) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// ReadSnapshot reads a snapshot from a file.
+func ReadSnapshot(path string) (*Snapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
+
+// snapshotSegment is a segment whose evidences are decoded separately, so
+// that evidence of a backend that isn't registered doesn't prevent reading a
+// snapshot.
+type snapshotSegment struct {
+	Link cs.Link `json:"link"`
+	Meta struct {
+		Evidences []json.RawMessage `json:"evidences"`
+		LinkHash  string            `json:"linkHash"`
+	} `json:"meta"`
+}
+
+// UnmarshalJSON decodes a snapshot. The proofs of evidences whose backend
+// isn't registered in cs.DeserializeMethods are kept encoded.
+func (s *Snapshot) UnmarshalJSON(data []byte) error {
+	type snapshot Snapshot
+	decoded := struct {
+		*snapshot
+		Segments   []*snapshotSegment `json:"segments"`
+		Governance []*snapshotSegment `json:"governance"`
+	}{snapshot: (*snapshot)(s)}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	var err error
+	if s.Segments, err = decodeSnapshotSegments(decoded.Segments); err != nil {
+		return err
+	}
+	if s.Governance, err = decodeSnapshotSegments(decoded.Governance); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func decodeSnapshotSegments(encoded []*snapshotSegment) (cs.SegmentSlice, error) {
+	if encoded == nil {
+		return nil, nil
+	}
+
+	segments := make(cs.SegmentSlice, 0, len(encoded))
+	for _, e := range encoded {
+		segment := &cs.Segment{
+			Link: e.Link,
+			Meta: cs.SegmentMeta{LinkHash: e.Meta.LinkHash, Evidences: cs.Evidences{}},
+		}
+		for _, data := range e.Meta.Evidences {
+			evidence, err := decodeSnapshotEvidence(data)
+			if err != nil {
+				return nil, err
+			}
+			segment.Meta.Evidences = append(segment.Meta.Evidences, evidence)
+		}
+		segments = append(segments, segment)
+	}
+
+	return segments, nil
+}
+
+func decodeSnapshotEvidence(data json.RawMessage) (*cs.Evidence, error) {
+	encoded := struct {
+		Backend  string          `json:"backend"`
+		Provider string          `json:"provider"`
+		Proof    json.RawMessage `json:"proof"`
+	}{}
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, err
+	}
+
+	if _, ok := cs.DeserializeMethods[encoded.Backend]; !ok {
+		return &cs.Evidence{
+			Backend:  encoded.Backend,
+			Provider: encoded.Provider,
+			Proof:    encodedProof(encoded.Proof),
+		}, nil
+	}
+
+	var evidence cs.Evidence
+	if err := json.Unmarshal(data, &evidence); err != nil {
+		return nil, err
+	}
+
+	return &evidence, nil
+}
+
+// ReadTrustedHeader reads a JSON encoded Tendermint header from a file.
+func ReadTrustedHeader(path string) (*tmtypes.Header, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var header tmtypes.Header
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+
+	return &header, nil
+}
+
+// VerifySnapshot verifies that a snapshot is consistent and matches the
+// header of the block following it.
+// The app hash of every block is recomputed from the committed link hashes
+// and chained back from the trusted header, so the snapshot's segments are
+// bound to it. The chain stops at the blocks committed before app hashes
+// were recorded: their links are only checked against the block lists.
+// Evidence that has no proof or whose backend isn't registered can't be
+// verified and is accepted as is.
+func VerifySnapshot(snapshot *Snapshot, trusted *tmtypes.Header) error {
+	if trusted == nil {
+		return ErrMissingTrustedHeader
+	}
+
+	if trusted.ChainID != snapshot.ChainID {
+		return errors.Wrapf(ErrUntrustedSnapshot, "chain ID %s doesn't match %s", snapshot.ChainID, trusted.ChainID)
+	}
+	if trusted.Height != snapshot.Height+1 {
+		return errors.Wrapf(ErrUntrustedSnapshot, "trusted header height should be %d, got %d", snapshot.Height+1, trusted.Height)
+	}
+	if snapshot.AppHash == nil || !bytes.Equal(trusted.AppHash, snapshot.AppHash[:]) {
+		return errors.Wrap(ErrUntrustedSnapshot, "app hash doesn't match the trusted header's")
+	}
+
+	if int64(len(snapshot.Blocks)) != snapshot.Height {
+		return errors.Wrapf(ErrInconsistentSnapshot, "expected %d blocks, got %d", snapshot.Height, len(snapshot.Blocks))
+	}
+
+	linkHashes := make(map[types.Bytes32]struct{})
+	for i, block := range snapshot.Blocks {
+		if block.Height != int64(i+1) {
+			return errors.Wrapf(ErrInconsistentSnapshot, "unexpected block %d at position %d", block.Height, i)
+		}
+		if len(block.Evidences) != len(block.EvidenceHashes) {
+			return errors.Wrapf(ErrInconsistentSnapshot, "expected %d evidences in block %d, got %d", len(block.EvidenceHashes), block.Height, len(block.Evidences))
+		}
+		for _, lh := range block.LinkHashes {
+			linkHashes[lh] = struct{}{}
+		}
+	}
+
+	verifiedHeight, err := verifyAppHashes(snapshot)
+	if err != nil {
+		return err
+	}
+	if verifiedHeight > 1 {
+		log.Warnf("Snapshot links committed before block %d are not bound to the trusted header: app hash history is missing", verifiedHeight)
+	}
+
+	if len(snapshot.Segments) != len(linkHashes) {
+		return errors.Wrapf(ErrInconsistentSnapshot, "expected %d segments, got %d", len(linkHashes), len(snapshot.Segments))
+	}
+
+	for _, segment := range snapshot.Segments {
+		linkHash, err := segment.Link.Hash()
+		if err != nil {
+			return err
+		}
+		if _, ok := linkHashes[*linkHash]; !ok {
+			return errors.Wrapf(ErrInconsistentSnapshot, "segment %x wasn't committed", *linkHash)
+		}
+		delete(linkHashes, *linkHash)
+
+		for _, evidence := range segment.Meta.Evidences {
+			if _, ok := evidence.Proof.(encodedProof); ok || evidence.Proof == nil {
+				continue
+			}
+			if !evidence.Proof.Verify(linkHash) {
+				return errors.Wrapf(ErrInconsistentSnapshot, "invalid %s evidence for segment %x", evidence.Backend, *linkHash)
+			}
+		}
+	}
+
+	for _, segment := range snapshot.Governance {
+		if segment.Link.Meta.Process != validator.GovernanceProcessName {
+			return errors.Wrapf(ErrInconsistentSnapshot, "governance segment of process %s", segment.Link.Meta.Process)
+		}
+		linkHash, err := segment.Link.Hash()
+		if err != nil {
+			return err
+		}
+		if _, ok := linkHashes[*linkHash]; ok {
+			return errors.Wrapf(ErrInconsistentSnapshot, "governance segment %x was committed", *linkHash)
+		}
+	}
+
+	return nil
+}
+
+// verifyAppHashes recomputes the app hashes of a snapshot's blocks from the
+// last one, which is bound to the trusted header, back to the first block
+// whose previous app hash is known. It returns the height of that block.
+func verifyAppHashes(snapshot *Snapshot) (int64, error) {
+	expected := snapshot.AppHash
+	verifiedHeight := snapshot.Height + 1
+	for i := len(snapshot.Blocks) - 1; i >= 0; i-- {
+		block := snapshot.Blocks[i]
+		if block.AppHash != nil && *block.AppHash != *expected {
+			return 0, errors.Wrapf(ErrInconsistentSnapshot, "invalid app hash for block %d", block.Height)
+		}
+
+		previous := &types.Bytes32{}
+		if i > 0 {
+			if previous = snapshot.Blocks[i-1].AppHash; previous == nil {
+				break
+			}
+		}
+
+		var root *types.Bytes32
+		if len(block.LinkHashes) > 0 || len(block.EvidenceHashes) > 0 {
+			var leaves [][]byte
+			for _, lh := range block.LinkHashes {
+				leaves = append(leaves, append([]byte(nil), lh[:]...))
+			}
+			for _, eh := range block.EvidenceHashes {
+				leaves = append(leaves, append([]byte(nil), eh[:]...))
+			}
+
+			tree, err := merkle.NewStaticTree(leaves)
+			if err != nil {
+				return 0, err
+			}
+			root = types.NewBytes32FromBytes(tree.Root())
+		}
+
+		appHash, err := ComputeAppHash(previous, block.ValidatorHash, root)
+		if err != nil {
+			return 0, err
+		}
+		if *appHash != *expected {
+			return 0, errors.Wrapf(ErrInconsistentSnapshot, "invalid app hash for block %d", block.Height)
+		}
+
+		expected = previous
+		verifiedHeight = block.Height
+	}
+
+	if verifiedHeight == snapshot.Height+1 && snapshot.Height > 0 {
+		return 0, errors.Wrapf(ErrInconsistentSnapshot, "app hash of block %d is missing", snapshot.Height-1)
+	}
+
+	return verifiedHeight, nil
+}
+
+// RestoreSnapshot verifies a snapshot against a trusted header and imports
+// it in an empty store and key-value store.
+// A TMPoP created on the restored stores resumes block processing from the
+// height following the snapshot. Tendermint Core must already have the
+// blocks up to that height, otherwise the app would be ahead of it and the
+// ABCI handshake would fail, so the snapshot is refused unless
+// tendermintHeight (the height of the Tendermint block store) matches it.
+func RestoreSnapshot(ctx context.Context, a store.Adapter, kv store.KeyValueStore, snapshot *Snapshot, trusted *tmtypes.Header, tendermintHeight int64) (err error) {
+	ctx, span := trace.StartSpan(ctx, "tmpop/RestoreSnapshot")
+	span.AddAttributes(trace.Int64Attribute("Height", snapshot.Height))
+	defer monitoring.SetSpanStatusAndEnd(span, err)
+
+	if tendermintHeight != snapshot.Height {
+		return errors.Wrapf(ErrHeightMismatch, "snapshot is at height %d, Tendermint Core at height %d", snapshot.Height, tendermintHeight)
+	}
+
+	initialized, err := kv.GetValue(ctx, tmpopLastBlockKey)
+	if err != nil {
+		return err
+	}
+	if initialized != nil {
+		lastBlock, err := ReadLastBlock(ctx, kv)
+		if err != nil {
+			return err
+		}
+		if lastBlock.Height > 0 {
+			return ErrAlreadyInitialized
+		}
+	}
+
+	if err = VerifySnapshot(snapshot, trusted); err != nil {
+		return err
+	}
+
+	segments := make(map[types.Bytes32]*cs.Segment, len(snapshot.Segments))
+	for _, segment := range snapshot.Segments {
+		linkHash, _ := segment.Link.Hash()
+		segments[*linkHash] = segment
+	}
+
+	for _, block := range snapshot.Blocks {
+		for _, lh := range block.LinkHashes {
+			segment := segments[lh]
+			linkHash, err := a.CreateLink(ctx, &segment.Link)
+			if err != nil {
+				return err
+			}
+
+			for _, evidence := range segment.Meta.Evidences {
+				if err := a.AddEvidence(ctx, linkHash, evidence); err != nil {
+					return err
+				}
+			}
+
+			if err := kv.SetValue(ctx, getLinkHeightKey(linkHash), []byte(strconv.FormatInt(block.Height, 10))); err != nil {
+				return err
+			}
+		}
+
+		if len(block.LinkHashes) > 0 {
+			value, err := json.Marshal(block.LinkHashes)
+			if err != nil {
+				return err
+			}
+			if err := kv.SetValue(ctx, getCommitLinkHashesKey(block.Height), value); err != nil {
+				return err
+			}
+		}
+
+		if len(block.EvidenceHashes) > 0 {
+			value, err := json.Marshal(block.EvidenceHashes)
+			if err != nil {
+				return err
+			}
+			if err := kv.SetValue(ctx, getCommitEvidenceHashesKey(block.Height), value); err != nil {
+				return err
+			}
+		}
+
+		for _, e := range block.Evidences {
+			if err := saveLinkEvidenceHeight(ctx, kv, e.LinkHash, e.Provider, block.Height); err != nil {
+				return err
+			}
+		}
+
+		if block.ValidatorHash != nil {
+			if err := kv.SetValue(ctx, getValidatorHashKey(block.Height), block.ValidatorHash[:]); err != nil {
+				return err
+			}
+		}
+
+		if block.AppHash != nil {
+			if err := kv.SetValue(ctx, getAppHashKey(block.Height), block.AppHash[:]); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, segment := range snapshot.Governance {
+		if _, err := a.CreateLink(ctx, &segment.Link); err != nil {
+			return err
+		}
+	}
+
+	evidenceHeight := []byte(strconv.FormatInt(snapshot.EvidenceHeight, 10))
+	if err := kv.SetValue(ctx, tmpopLastEvidenceHeightKey, evidenceHeight); err != nil {
+		return err
+	}
+
+	if err := kv.SetValue(ctx, linkHeightsMigratedKey, []byte{1}); err != nil {
+		return err
+	}
+	if err := kv.SetValue(ctx, linkEvidencesMigratedKey, []byte{1}); err != nil {
+		return err
+	}
+
+	// The last block is saved last: a node interrupted while restoring
+	// a snapshot is still considered empty.
+	saveLastBlock(ctx, kv, LastBlock{
+		AppHash:    snapshot.AppHash,
+		Height:     snapshot.Height,
+		LastHeader: snapshot.LastHeader,
+	})
+
+	return nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package evidences defines the proofs produced by the solo sequencer.
+// It is needed by a store to know how to deserialize a segment containing
+// a solo proof.
+package evidences
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+
+	cj "github.com/gibson042/canonicaljson-go"
+	"github.com/stratumn/go-crypto/signatures"
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/types"
+	mktypes "github.com/stratumn/merkle/types"
+)
+
+const (
+	// Name is the name used as the solo sequencer backend.
+	Name = "solo"
+)
+
+// Header is the header of a block produced by the solo sequencer.
+// Blocks are chained by their app hash, computed the same way as TMPoP's.
+type Header struct {
+	ChainID string `json:"chain_id"`
+	Height  int64  `json:"height"`
+	Time    int64  `json:"time"`
+
+	PreviousAppHash *types.Bytes32 `json:"previous_app_hash"`
+	ValidationsHash *types.Bytes32 `json:"validations_hash"`
+	MerkleRoot      *types.Bytes32 `json:"merkle_root"`
+	AppHash         *types.Bytes32 `json:"app_hash"`
+}
+
+// Hash returns the hash of the canonical JSON encoding of the header.
+// It is the message signed by the sequencer.
+func (h *Header) Hash() (*types.Bytes32, error) {
+	js, err := cj.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(js)
+	return types.NewBytes32FromBytes(hash[:]), nil
+}
+
+// ComputeAppHash returns the app hash the header should have given its
+// previous app hash, validations hash and merkle root.
+func (h *Header) ComputeAppHash() *types.Bytes32 {
+	hash := sha256.New()
+	for _, part := range []*types.Bytes32{h.PreviousAppHash, h.ValidationsHash, h.MerkleRoot} {
+		if part == nil {
+			part = &types.Bytes32{}
+		}
+		hash.Write(part[:])
+	}
+
+	return types.NewBytes32FromBytes(hash.Sum(nil))
+}
+
+// Signature is the signature of a block header by the sequencer's key.
+type Signature struct {
+	Type      string `json:"type"`
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature"`
+}
+
+// SoloProof implements the Proof interface.
+type SoloProof struct {
+	Header    *Header      `json:"header"`
+	Path      mktypes.Path `json:"merkle_path"`
+	Signature *Signature   `json:"signature"`
+}
+
+// Time returns the timestamp from the block header
+func (p *SoloProof) Time() uint64 {
+	return uint64(p.Header.Time)
+}
+
+// FullProof returns a JSON formatted proof
+func (p *SoloProof) FullProof() []byte {
+	bytes, err := json.MarshalIndent(p, "", "   ")
+	if err != nil {
+		return nil
+	}
+	return bytes
+}
+
+// Verify returns true if the proof of a given linkHash is correct.
+// It doesn't check that the block was signed by a trusted key: callers
+// should compare Signature.PublicKey with the sequencer's public key.
+func (p *SoloProof) Verify(linkHash interface{}) bool {
+	lh, ok := linkHash.(*types.Bytes32)
+	if !ok || p.Header == nil || p.Header.AppHash == nil || p.Header.MerkleRoot == nil || p.Signature == nil {
+		return false
+	}
+
+	// We first verify that the app hash chains the merkle root.
+	if *p.Header.ComputeAppHash() != *p.Header.AppHash {
+		return false
+	}
+
+	// Then we validate the merkle path.
+	if len(p.Path) == 0 {
+		// If the tree contains a single element,
+		// it's valid only if it's the root.
+		if !lh.Equals(p.Header.MerkleRoot) {
+			return false
+		}
+	} else {
+		if err := p.Path.Validate(); err != nil {
+			return false
+		}
+		if !lh.EqualsBytes(p.Path[0].Left) && !lh.EqualsBytes(p.Path[0].Right) {
+			return false
+		}
+		if !bytes.Equal(p.Path[len(p.Path)-1].Parent, p.Header.MerkleRoot[:]) {
+			return false
+		}
+	}
+
+	// Finally we validate that the sequencer signed the header.
+	headerHash, err := p.Header.Hash()
+	if err != nil {
+		return false
+	}
+
+	err = signatures.Verify(&signatures.Signature{
+		AI:        p.Signature.Type,
+		PublicKey: []byte(p.Signature.PublicKey),
+		Message:   headerHash[:],
+		Signature: []byte(p.Signature.Signature),
+	})
+
+	return err == nil
+}
+
+// init needs to define a way to deserialize a SoloProof
+func init() {
+	cs.DeserializeMethods[Name] = func(rawProof json.RawMessage) (cs.Proof, error) {
+		p := SoloProof{}
+		if err := json.Unmarshal(rawProof, &p); err != nil {
+			return nil, err
+		}
+		return &p, nil
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evidences_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stratumn/go-crypto/keys"
+	"github.com/stratumn/go-crypto/signatures"
+	"github.com/stratumn/go-indigocore/testutil"
+	"github.com/stratumn/go-indigocore/tmpop/solo/evidences"
+	"github.com/stratumn/go-indigocore/types"
+	"github.com/stratumn/merkle"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createProof(t *testing.T, linkHashes []*types.Bytes32, index int) *evidences.SoloProof {
+	var leaves [][]byte
+	for _, lh := range linkHashes {
+		leaves = append(leaves, lh[:])
+	}
+	tree, err := merkle.NewStaticTree(leaves)
+	require.NoError(t, err)
+
+	header := &evidences.Header{
+		ChainID:         "test",
+		Height:          12,
+		Time:            time.Now().Unix(),
+		PreviousAppHash: testutil.RandomHash(),
+		MerkleRoot:      types.NewBytes32FromBytes(tree.Root()),
+	}
+	header.AppHash = header.ComputeAppHash()
+
+	_, priv, err := keys.GenerateKey(keys.ED25519)
+	require.NoError(t, err)
+	headerHash, err := header.Hash()
+	require.NoError(t, err)
+	sig, err := signatures.Sign(priv, headerHash[:])
+	require.NoError(t, err)
+
+	return &evidences.SoloProof{
+		Header: header,
+		Path:   tree.Path(index),
+		Signature: &evidences.Signature{
+			Type:      sig.AI,
+			PublicKey: string(sig.PublicKey),
+			Signature: string(sig.Signature),
+		},
+	}
+}
+
+func TestSoloProof(t *testing.T) {
+	linkHashes := []*types.Bytes32{testutil.RandomHash(), testutil.RandomHash(), testutil.RandomHash()}
+
+	t.Run("Valid proof", func(t *testing.T) {
+		proof := createProof(t, linkHashes, 1)
+		assert.True(t, proof.Verify(linkHashes[1]))
+		assert.Equal(t, uint64(proof.Header.Time), proof.Time())
+	})
+
+	t.Run("Single link", func(t *testing.T) {
+		proof := createProof(t, linkHashes[:1], 0)
+		assert.True(t, proof.Verify(linkHashes[0]))
+		assert.False(t, proof.Verify(linkHashes[1]))
+	})
+
+	t.Run("Other link", func(t *testing.T) {
+		proof := createProof(t, linkHashes, 1)
+		assert.False(t, proof.Verify(testutil.RandomHash()))
+	})
+
+	t.Run("Tampered header", func(t *testing.T) {
+		proof := createProof(t, linkHashes, 1)
+		proof.Header.Height++
+		assert.False(t, proof.Verify(linkHashes[1]), "Signature should not match")
+	})
+
+	t.Run("Tampered app hash", func(t *testing.T) {
+		proof := createProof(t, linkHashes, 1)
+		proof.Header.PreviousAppHash = testutil.RandomHash()
+		assert.False(t, proof.Verify(linkHashes[1]), "App hash should not match")
+	})
+
+	t.Run("Missing signature", func(t *testing.T) {
+		proof := createProof(t, linkHashes, 1)
+		proof.Signature = nil
+		assert.False(t, proof.Verify(linkHashes[1]))
+	})
+}
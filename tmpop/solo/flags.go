@@ -0,0 +1,58 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solo
+
+import (
+	"flag"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	chainID        string
+	blockInterval  time.Duration
+	maxTxs         int
+	privateKeyPath string
+)
+
+// RegisterFlags registers the command-line solo sequencer flags.
+func RegisterFlags() {
+	flag.StringVar(&chainID, "solo_chain_id", DefaultChainID, "Chain ID set in block headers")
+	flag.DurationVar(&blockInterval, "solo_block_interval", DefaultBlockInterval, "Maximum duration between the first transaction of a block and its commit")
+	flag.IntVar(&maxTxs, "solo_max_txs", DefaultMaxTxs, "Number of transactions after which a block is committed")
+	flag.StringVar(&privateKeyPath, "solo_key", "", "Path to the PEM encoded private key used to sign blocks (can be generated with strat)")
+}
+
+// ConfigurationFromFlags builds configuration from user-provided
+// command-line flags.
+func ConfigurationFromFlags() (*Config, error) {
+	if privateKeyPath == "" {
+		return nil, ErrMissingPrivateKey
+	}
+
+	privateKey, err := ioutil.ReadFile(privateKeyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read private key")
+	}
+
+	return &Config{
+		ChainID:       chainID,
+		BlockInterval: blockInterval,
+		MaxTxs:        maxTxs,
+		PrivateKey:    privateKey,
+	}, nil
+}
@@ -0,0 +1,804 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package solo implements a sequencer that orders transactions and commits
+// blocks with TMPoP's state without running Tendermint Core.
+//
+// It is meant for single-node deployments: links are validated and chained
+// in blocks exactly like TMPoP does, and each block is signed with a local
+// key. The sequencer implements store.Adapter so it can be served by
+// storehttp.
+package solo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/stratumn/go-crypto/signatures"
+	"github.com/stratumn/go-indigocore/bufferedbatch"
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/jsonhttp"
+	"github.com/stratumn/go-indigocore/monitoring"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/tmpop"
+	"github.com/stratumn/go-indigocore/tmpop/solo/evidences"
+	"github.com/stratumn/go-indigocore/types"
+	"github.com/stratumn/go-indigocore/validator"
+	"github.com/stratumn/merkle"
+
+	"go.opencensus.io/trace"
+)
+
+const (
+	// Name is the name set in the store's information.
+	Name = "solo"
+
+	// Description is the description set in the store's information.
+	Description = "Indigo's Solo Sequencer"
+
+	// DefaultChainID is the default chain ID set in block headers.
+	DefaultChainID = "solo"
+
+	// DefaultBlockInterval is the default maximum duration between the
+	// first transaction of a block and its commit.
+	DefaultBlockInterval = time.Second
+
+	// DefaultMaxTxs is the default number of transactions after which a
+	// block is committed.
+	DefaultMaxTxs = 1000
+)
+
+var (
+	// ErrMissingPrivateKey is returned when no key is configured to sign
+	// blocks.
+	ErrMissingPrivateKey = errors.New("a private key is required to sign blocks")
+
+	// ErrStopped is returned when a transaction is submitted while the
+	// sequencer isn't running.
+	ErrStopped = errors.New("sequencer is stopped")
+)
+
+var (
+	soloLastHeaderKey   = []byte("solo:lastheader")
+	soloPendingBlockKey = []byte("solo:pendingblock")
+)
+
+// Config contains configuration options for the sequencer.
+type Config struct {
+	// A version string that will be set in the store's information.
+	Version string
+
+	// A git commit hash that will be set in the store's information.
+	Commit string
+
+	// The chain ID set in block headers.
+	ChainID string
+
+	// The maximum duration between the first transaction of a block and
+	// its commit.
+	BlockInterval time.Duration
+
+	// The number of transactions after which a block is committed.
+	MaxTxs int
+
+	// The PEM encoded private key used to sign blocks.
+	PrivateKey []byte
+
+	// Validation configuration, as in TMPoP.
+	Validation *validator.Config
+}
+
+// Info is the info returned by GetInfo.
+type Info struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Version     string      `json:"version"`
+	Commit      string      `json:"commit"`
+	ChainID     string      `json:"chainId"`
+	Height      int64       `json:"height"`
+	AdapterInfo interface{} `json:"adapterInfo"`
+}
+
+// Sequencer orders transactions in blocks and commits them to a store.
+// It implements github.com/stratumn/go-indigocore/store.Adapter: reads are
+// served by the underlying adapter and writes go through the sequencer.
+type Sequencer struct {
+	store.Adapter
+
+	kv     store.KeyValueStore
+	config *Config
+	state  *tmpop.State
+
+	requests chan *request
+	running  chan struct{}
+	done     chan struct{}
+
+	// Only accessed by the sequencing goroutine.
+	pending []*request
+	timer   *time.Timer
+
+	mutex      sync.RWMutex
+	lastHeader *evidences.Header
+	eventChans []chan *store.Event
+}
+
+// request is a transaction waiting to be delivered then committed.
+type request struct {
+	tx        *tmpop.Tx
+	delivered chan *tmpop.ABCIError
+	committed chan *commitResult
+}
+
+type commitResult struct {
+	height int64
+	err    error
+}
+
+// pendingBlock is a signed block saved before its links are committed.
+type pendingBlock struct {
+	Header     *evidences.Header     `json:"header"`
+	Signature  *evidences.Signature  `json:"signature"`
+	LinkHashes []*types.Bytes32      `json:"linkHashes"`
+	Evidences  []*tmpop.LinkEvidence `json:"evidences"`
+}
+
+// tree returns the merkle tree of the block's links and evidence.
+func (b *pendingBlock) tree() (*merkle.StaticTree, error) {
+	var leaves [][]byte
+	for _, linkHash := range b.LinkHashes {
+		leaves = append(leaves, linkHash[:])
+	}
+	for _, e := range b.Evidences {
+		evidenceHash, err := e.Hash()
+		if err != nil {
+			return nil, err
+		}
+		leaves = append(leaves, evidenceHash[:])
+	}
+
+	return merkle.NewStaticTree(leaves)
+}
+
+// New creates a sequencer writing to the given adapter. Block headers are
+// saved in the key-value store.
+// The sequencer doesn't accept transactions until Run is called.
+func New(ctx context.Context, a store.Adapter, kv store.KeyValueStore, config *Config) (*Sequencer, error) {
+	if len(config.PrivateKey) == 0 {
+		return nil, ErrMissingPrivateKey
+	}
+	if config.ChainID == "" {
+		config.ChainID = DefaultChainID
+	}
+	if config.BlockInterval <= 0 {
+		config.BlockInterval = DefaultBlockInterval
+	}
+	if config.MaxTxs <= 0 {
+		config.MaxTxs = DefaultMaxTxs
+	}
+
+	state, err := tmpop.NewState(ctx, a, kv, &tmpop.Config{Validation: config.Validation})
+	if err != nil {
+		return nil, err
+	}
+
+	lastHeader, err := readLastHeader(ctx, kv)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read the last block")
+	}
+
+	s := &Sequencer{
+		Adapter:    a,
+		kv:         kv,
+		config:     config,
+		state:      state,
+		requests:   make(chan *request),
+		running:    make(chan struct{}),
+		done:       make(chan struct{}),
+		lastHeader: lastHeader,
+	}
+
+	if err := s.recoverBlock(ctx); err != nil {
+		return nil, errors.Wrap(err, "cannot recover the last block")
+	}
+
+	return s, nil
+}
+
+// Run sequences transactions until the context is cancelled.
+// Transactions delivered but not committed yet are committed before it
+// returns. It must be called only once.
+func (s *Sequencer) Run(ctx context.Context) error {
+	close(s.running)
+	defer close(s.done)
+
+	s.timer = time.NewTimer(s.config.BlockInterval)
+	s.timer.Stop()
+
+	for {
+		select {
+		case req := <-s.requests:
+			s.deliver(ctx, req)
+			if len(s.pending) >= s.config.MaxTxs {
+				s.commit(ctx)
+			}
+
+		case <-s.timer.C:
+			s.commit(ctx)
+
+		case <-ctx.Done():
+			s.commit(context.Background())
+			return ctx.Err()
+		}
+	}
+}
+
+// deliver validates a transaction and adds it to the current block.
+func (s *Sequencer) deliver(ctx context.Context, req *request) {
+	if len(s.pending) == 0 {
+		s.state.BeginBlock(ctx, s.LastHeader().Height+1, s.LastHeader().AppHash)
+	}
+
+	res := s.state.Deliver(ctx, req.tx)
+	req.delivered <- res
+	if !res.IsOK() {
+		return
+	}
+
+	if len(s.pending) == 0 {
+		s.timer.Reset(s.config.BlockInterval)
+	}
+	s.pending = append(s.pending, req)
+}
+
+// commit commits the current block, signs its header and adds evidence to
+// its links.
+func (s *Sequencer) commit(ctx context.Context) {
+	if len(s.pending) == 0 {
+		return
+	}
+
+	ctx, span := trace.StartSpan(ctx, "solo/commit")
+	span.AddAttributes(trace.Int64Attribute("TxCount", int64(len(s.pending))))
+	defer span.End()
+
+	if !s.timer.Stop() {
+		select {
+		case <-s.timer.C:
+		default:
+		}
+	}
+
+	pending := s.pending
+	s.pending = nil
+
+	height, err := s.commitBlock(ctx)
+	if err != nil {
+		log.Errorf("Error while committing block: %s", err)
+		span.SetStatus(trace.Status{Code: monitoring.Internal, Message: err.Error()})
+
+		// The block's transactions are discarded, unless the block was
+		// saved and can be completed by recoverBlock.
+		if err := s.state.Reset(ctx); err != nil {
+			log.Errorf("Error while resetting state: %s", err)
+		}
+		if err := s.recoverBlock(ctx); err != nil {
+			log.Errorf("Error while recovering block: %s", err)
+		}
+	}
+
+	for _, req := range pending {
+		req.committed <- &commitResult{height: height, err: err}
+	}
+}
+
+// commitBlock commits the current block.
+// The signed block is saved before the state is committed so that a block
+// interrupted between the two can be completed by recoverBlock.
+func (s *Sequencer) commitBlock(ctx context.Context) (int64, error) {
+	last := s.LastHeader()
+
+	validationsHash, err := s.state.ValidatorHash()
+	if err != nil {
+		return 0, err
+	}
+
+	appHash, links, linkEvidences, err := s.state.Pending()
+	if err != nil {
+		return 0, err
+	}
+
+	block := &pendingBlock{Evidences: linkEvidences}
+	for _, link := range links {
+		linkHash, err := link.Hash()
+		if err != nil {
+			return 0, err
+		}
+		block.LinkHashes = append(block.LinkHashes, linkHash)
+	}
+
+	tree, err := block.tree()
+	if err != nil {
+		return 0, err
+	}
+
+	block.Header = &evidences.Header{
+		ChainID:         s.config.ChainID,
+		Height:          last.Height + 1,
+		Time:            time.Now().Unix(),
+		PreviousAppHash: last.AppHash,
+		ValidationsHash: validationsHash,
+		MerkleRoot:      types.NewBytes32FromBytes(tree.Root()),
+		AppHash:         appHash,
+	}
+	if *block.Header.ComputeAppHash() != *appHash {
+		return 0, errors.New("block app hash doesn't match the committed state")
+	}
+
+	if block.Signature, err = s.sign(block.Header); err != nil {
+		return 0, err
+	}
+
+	if err := s.savePendingBlock(ctx, block); err != nil {
+		return 0, err
+	}
+
+	if _, _, _, err := s.state.Commit(ctx); err != nil {
+		return 0, err
+	}
+
+	savedEvidences, err := s.finishBlock(ctx, block, tree)
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range linkEvidences {
+		savedEvidences.AddSavedEvidence(e.LinkHash, e.Evidence)
+	}
+
+	if len(links) > 0 {
+		s.notify(store.NewSavedLinks(links...))
+	}
+	s.notify(savedEvidences)
+
+	return block.Header.Height, nil
+}
+
+// finishBlock saves the header of a committed block and adds evidence to
+// its links.
+func (s *Sequencer) finishBlock(ctx context.Context, block *pendingBlock, tree *merkle.StaticTree) (*store.Event, error) {
+	if err := s.saveHeader(ctx, block.Header, block.LinkHashes); err != nil {
+		return nil, err
+	}
+
+	savedEvidences := store.NewSavedEvidences()
+	for i, linkHash := range block.LinkHashes {
+		evidence := &cs.Evidence{
+			Backend:  evidences.Name,
+			Provider: s.config.ChainID,
+			Proof: &evidences.SoloProof{
+				Header:    block.Header,
+				Path:      tree.Path(i),
+				Signature: block.Signature,
+			},
+		}
+		if err := s.Adapter.AddEvidence(ctx, linkHash, evidence); err != nil {
+			log.Warnf("Evidence could not be added to local store: %v", err)
+			continue
+		}
+		savedEvidences.AddSavedEvidence(linkHash, evidence)
+	}
+
+	if _, err := s.kv.DeleteValue(ctx, soloPendingBlockKey); err != nil {
+		return nil, err
+	}
+
+	return savedEvidences, nil
+}
+
+// recoverBlock completes or discards a block whose commit was interrupted.
+// The block is completed if its links were written, and discarded if none
+// of them were.
+func (s *Sequencer) recoverBlock(ctx context.Context) error {
+	block, err := readPendingBlock(ctx, s.kv)
+	if err != nil || block == nil {
+		return err
+	}
+
+	last := s.LastHeader()
+	if block.Header.Height > last.Height+1 {
+		return errors.Errorf("pending block %d doesn't follow the last block %d", block.Header.Height, last.Height)
+	}
+	if block.Header.Height < last.Height {
+		_, err := s.kv.DeleteValue(ctx, soloPendingBlockKey)
+		return err
+	}
+
+	if block.Header.Height == last.Height+1 {
+		var written int
+		for _, linkHash := range block.LinkHashes {
+			segment, err := s.Adapter.GetSegment(ctx, linkHash)
+			if err != nil {
+				return err
+			}
+			if segment != nil {
+				written++
+			}
+		}
+
+		switch {
+		case written == 0 && len(block.LinkHashes) > 0:
+			log.Warnf("Discarding block %d which was not committed", block.Header.Height)
+			_, err := s.kv.DeleteValue(ctx, soloPendingBlockKey)
+			return err
+		case written < len(block.LinkHashes):
+			return errors.Errorf("block %d was partially committed: %d of %d links were written", block.Header.Height, written, len(block.LinkHashes))
+		}
+
+		for _, e := range block.Evidences {
+			if err := s.Adapter.AddEvidence(ctx, e.LinkHash, e.Evidence); err != nil {
+				log.Warnf("Evidence could not be added to local store: %v", err)
+			}
+		}
+	}
+
+	tree, err := block.tree()
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Completing block %d whose commit was interrupted", block.Header.Height)
+	_, err = s.finishBlock(ctx, block, tree)
+	return err
+}
+
+// sign signs the hash of a block header with the sequencer's key.
+func (s *Sequencer) sign(header *evidences.Header) (*evidences.Signature, error) {
+	headerHash, err := header.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := signatures.Sign(s.config.PrivateKey, headerHash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &evidences.Signature{
+		Type:      signature.AI,
+		PublicKey: string(signature.PublicKey),
+		Signature: string(signature.Signature),
+	}, nil
+}
+
+// submit sends a transaction to the sequencing goroutine.
+func (s *Sequencer) submit(ctx context.Context, tx *tmpop.Tx) (*request, error) {
+	select {
+	case <-s.running:
+	default:
+		return nil, ErrStopped
+	}
+
+	req := &request{
+		tx:        tx,
+		delivered: make(chan *tmpop.ABCIError, 1),
+		committed: make(chan *commitResult, 1),
+	}
+
+	select {
+	case s.requests <- req:
+		return req, nil
+	case <-s.done:
+		return nil, ErrStopped
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// wait waits until a transaction is processed according to the write mode.
+// It returns the height of the block if the transaction is committed.
+func (s *Sequencer) wait(ctx context.Context, req *request, mode store.WriteMode) (int64, *tmpop.ABCIError, error) {
+	if mode == store.WriteModeAsync {
+		return 0, nil, nil
+	}
+
+	select {
+	case res := <-req.delivered:
+		if !res.IsOK() || mode == store.WriteModeSync {
+			return 0, res, nil
+		}
+	case <-ctx.Done():
+		return 0, nil, ctx.Err()
+	}
+
+	select {
+	case res := <-req.committed:
+		return res.height, nil, res.err
+	case <-ctx.Done():
+		return 0, nil, ctx.Err()
+	}
+}
+
+// GetInfo implements github.com/stratumn/go-indigocore/store.Adapter.GetInfo.
+func (s *Sequencer) GetInfo(ctx context.Context) (interface{}, error) {
+	adapterInfo, err := s.Adapter.GetInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Info{
+		Name:        Name,
+		Description: Description,
+		Version:     s.config.Version,
+		Commit:      s.config.Commit,
+		ChainID:     s.config.ChainID,
+		Height:      s.LastHeader().Height,
+		AdapterInfo: adapterInfo,
+	}, nil
+}
+
+// AddStoreEventChannel implements github.com/stratumn/go-indigocore/store.Adapter.AddStoreEventChannel.
+// Events are sent when blocks are committed.
+func (s *Sequencer) AddStoreEventChannel(eventChan chan *store.Event) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.eventChans = append(s.eventChans, eventChan)
+}
+
+func (s *Sequencer) notify(event *store.Event) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, c := range s.eventChans {
+		c <- event
+	}
+}
+
+// CreateLink implements github.com/stratumn/go-indigocore/store.LinkWriter.CreateLink.
+// It returns once the link is committed.
+func (s *Sequencer) CreateLink(ctx context.Context, link *cs.Link) (*types.Bytes32, error) {
+	linkHash, err := link.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := s.submit(ctx, &tmpop.Tx{TxType: tmpop.CreateLink, Link: link, LinkHash: linkHash})
+	if err != nil {
+		return nil, err
+	}
+
+	_, res, err := s.wait(ctx, req, store.WriteModeCommit)
+	if err != nil {
+		return nil, err
+	}
+	if !res.IsOK() {
+		return nil, jsonhttp.NewErrBadRequest(res.Log)
+	}
+
+	return linkHash, nil
+}
+
+// CreateLinks implements github.com/stratumn/go-indigocore/store.LinksWriter.CreateLinks.
+// Each link is sent in its own transaction so that invalid links don't
+// prevent the others from being committed.
+func (s *Sequencer) CreateLinks(ctx context.Context, links []*cs.Link, mode store.WriteMode) ([]*store.LinkResult, error) {
+	results := make([]*store.LinkResult, len(links))
+	reqs := make([]*request, len(links))
+	for i, link := range links {
+		linkHash, err := link.Hash()
+		if err != nil {
+			results[i] = &store.LinkResult{Status: store.LinkRejected, Error: err.Error()}
+			continue
+		}
+
+		results[i] = &store.LinkResult{LinkHash: linkHash, Status: store.LinkPending}
+		if reqs[i], err = s.submit(ctx, &tmpop.Tx{TxType: tmpop.CreateLink, Link: link, LinkHash: linkHash}); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, req := range reqs {
+		if req == nil {
+			continue
+		}
+
+		height, res, err := s.wait(ctx, req, mode)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case !res.IsOK():
+			results[i].Status = store.LinkRejected
+			results[i].Error = res.Log
+		case mode == store.WriteModeCommit:
+			results[i].Status = store.LinkCommitted
+			results[i].Height = height
+		}
+	}
+
+	return results, nil
+}
+
+// AddEvidence implements github.com/stratumn/go-indigocore/store.EvidenceWriter.AddEvidence.
+// Like TMPoP's AddEvidence query, external evidence is added directly to
+// the store.
+func (s *Sequencer) AddEvidence(ctx context.Context, linkHash *types.Bytes32, evidence *cs.Evidence) error {
+	if err := s.Adapter.AddEvidence(ctx, linkHash, evidence); err != nil {
+		return err
+	}
+
+	evidenceEvent := store.NewSavedEvidences()
+	evidenceEvent.AddSavedEvidence(linkHash, evidence)
+	s.notify(evidenceEvent)
+
+	return nil
+}
+
+// NewBatch implements github.com/stratumn/go-indigocore/store.Adapter.NewBatch.
+func (s *Sequencer) NewBatch(ctx context.Context) (store.Batch, error) {
+	return &Batch{
+		Batch:     bufferedbatch.NewBatch(ctx, s),
+		sequencer: s,
+	}, nil
+}
+
+// Batch is the type that implements github.com/stratumn/go-indigocore/store.Batch.
+type Batch struct {
+	*bufferedbatch.Batch
+
+	sequencer *Sequencer
+}
+
+// Write implements github.com/stratumn/go-indigocore/store.Batch.Write.
+// The links of the batch are sent in a single CreateLinks transaction, so
+// they are all committed in the same block or none is.
+func (b *Batch) Write(ctx context.Context) (err error) {
+	ctx, span := trace.StartSpan(ctx, "solo/batch/Write")
+	defer monitoring.SetSpanStatusAndEnd(span, err)
+
+	if len(b.Links) == 0 {
+		return nil
+	}
+
+	req, err := b.sequencer.submit(ctx, &tmpop.Tx{TxType: tmpop.CreateLinks, Links: b.Links})
+	if err != nil {
+		return err
+	}
+
+	_, res, err := b.sequencer.wait(ctx, req, store.WriteModeCommit)
+	if err != nil {
+		return err
+	}
+	if !res.IsOK() {
+		return jsonhttp.NewErrBadRequest(res.Log)
+	}
+
+	return nil
+}
+
+// LastHeader returns the header of the last committed block.
+// Its height is zero if no block was committed yet.
+func (s *Sequencer) LastHeader() *evidences.Header {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.lastHeader
+}
+
+// GetLinkHeight implements github.com/stratumn/go-indigocore/store.LinkHeightReader.GetLinkHeight.
+func (s *Sequencer) GetLinkHeight(ctx context.Context, linkHash *types.Bytes32) (int64, error) {
+	value, err := s.kv.GetValue(ctx, getLinkHeightKey(linkHash))
+	if err != nil || value == nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(string(value), 10, 64)
+}
+
+func getLinkHeightKey(linkHash *types.Bytes32) []byte {
+	key := fmt.Sprintf("solo:linkheight:%x", *linkHash)
+	return []byte(key)
+}
+
+func getHeaderKey(height int64) []byte {
+	key := fmt.Sprintf("solo:header:%d", height)
+	return []byte(key)
+}
+
+// saveHeader saves a committed block header and the heights of its links.
+func (s *Sequencer) saveHeader(ctx context.Context, header *evidences.Header, linkHashes []*types.Bytes32) error {
+	value, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	if err := s.kv.SetValue(ctx, getHeaderKey(header.Height), value); err != nil {
+		return err
+	}
+
+	for _, linkHash := range linkHashes {
+		height := []byte(strconv.FormatInt(header.Height, 10))
+		if err := s.kv.SetValue(ctx, getLinkHeightKey(linkHash), height); err != nil {
+			return err
+		}
+	}
+
+	if err := s.kv.SetValue(ctx, soloLastHeaderKey, value); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastHeader = header
+
+	return nil
+}
+
+func (s *Sequencer) savePendingBlock(ctx context.Context, block *pendingBlock) error {
+	value, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+
+	return s.kv.SetValue(ctx, soloPendingBlockKey, value)
+}
+
+func readPendingBlock(ctx context.Context, kv store.KeyValueReader) (*pendingBlock, error) {
+	value, err := kv.GetValue(ctx, soloPendingBlockKey)
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	var block pendingBlock
+	if err := json.Unmarshal(value, &block); err != nil {
+		return nil, err
+	}
+
+	return &block, nil
+}
+
+// GetHeader returns the header of the block at the given height, or nil if
+// it doesn't exist.
+func (s *Sequencer) GetHeader(ctx context.Context, height int64) (*evidences.Header, error) {
+	return readHeader(ctx, s.kv, getHeaderKey(height))
+}
+
+func readLastHeader(ctx context.Context, kv store.KeyValueReader) (*evidences.Header, error) {
+	header, err := readHeader(ctx, kv, soloLastHeaderKey)
+	if err != nil || header != nil {
+		return header, err
+	}
+
+	return &evidences.Header{AppHash: &types.Bytes32{}}, nil
+}
+
+func readHeader(ctx context.Context, kv store.KeyValueReader, key []byte) (*evidences.Header, error) {
+	value, err := kv.GetValue(ctx, key)
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	var header evidences.Header
+	if err := json.Unmarshal(value, &header); err != nil {
+		return nil, err
+	}
+
+	return &header, nil
+}
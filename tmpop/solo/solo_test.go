@@ -0,0 +1,349 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solo_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stratumn/go-crypto/keys"
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/cs/cstesting"
+	"github.com/stratumn/go-indigocore/dummystore"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/tmpop/solo"
+	"github.com/stratumn/go-indigocore/tmpop/solo/evidences"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSequencer(t *testing.T, a *dummystore.DummyStore, config *solo.Config) (*solo.Sequencer, func()) {
+	if config.PrivateKey == nil {
+		_, priv, err := keys.GenerateKey(keys.ED25519)
+		require.NoError(t, err)
+		config.PrivateKey = priv
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s, err := solo.New(ctx, a, a, config)
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	return s, func() {
+		cancel()
+		<-done
+	}
+}
+
+// headerFailingKV fails to save block headers, as if the sequencer crashed
+// after committing a block's links.
+type headerFailingKV struct {
+	*dummystore.DummyStore
+}
+
+func (kv headerFailingKV) SetValue(ctx context.Context, key []byte, value []byte) error {
+	if strings.HasPrefix(string(key), "solo:header:") {
+		return errors.New("crash")
+	}
+
+	return kv.DummyStore.SetValue(ctx, key, value)
+}
+
+// pendingBlockFailingKV fails to save the first pending block.
+type pendingBlockFailingKV struct {
+	*dummystore.DummyStore
+	failed *bool
+}
+
+func (kv pendingBlockFailingKV) SetValue(ctx context.Context, key []byte, value []byte) error {
+	if string(key) == "solo:pendingblock" && !*kv.failed {
+		*kv.failed = true
+		return errors.New("crash")
+	}
+
+	return kv.DummyStore.SetValue(ctx, key, value)
+}
+
+func verifySoloEvidence(t *testing.T, a store.Adapter, link *cs.Link, height int64) *evidences.SoloProof {
+	linkHash, _ := link.Hash()
+	segment, err := a.GetSegment(context.Background(), linkHash)
+	require.NoError(t, err)
+	require.NotNil(t, segment, "Link should be stored")
+
+	evidence := segment.Meta.GetEvidence(solo.DefaultChainID)
+	require.NotNil(t, evidence, "Link should have solo evidence")
+	assert.Equal(t, evidences.Name, evidence.Backend)
+
+	proof := evidence.Proof.(*evidences.SoloProof)
+	assert.Equal(t, height, proof.Header.Height)
+	assert.True(t, proof.Verify(linkHash), "proof.Verify()")
+
+	return proof
+}
+
+func TestSequencer(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Commits links in signed blocks", func(t *testing.T) {
+		a := dummystore.New(&dummystore.Config{})
+		s, stop := newSequencer(t, a, &solo.Config{MaxTxs: 2, BlockInterval: time.Minute})
+		defer stop()
+
+		link1, link2 := cstesting.RandomLink(), cstesting.RandomLink()
+		results, err := s.CreateLinks(ctx, []*cs.Link{link1, link2}, store.WriteModeCommit)
+		require.NoError(t, err)
+		for _, res := range results {
+			assert.Equal(t, store.LinkCommitted, res.Status)
+			assert.Equal(t, int64(1), res.Height)
+		}
+
+		proof1 := verifySoloEvidence(t, a, link1, 1)
+		proof2 := verifySoloEvidence(t, a, link2, 1)
+		assert.Equal(t, proof1.Header, proof2.Header, "Links should be in the same block")
+		assert.Equal(t, s.LastHeader(), proof1.Header)
+
+		height, err := s.GetLinkHeight(ctx, results[0].LinkHash)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), height)
+	})
+
+	t.Run("Commits blocks after the block interval", func(t *testing.T) {
+		a := dummystore.New(&dummystore.Config{})
+		s, stop := newSequencer(t, a, &solo.Config{BlockInterval: 10 * time.Millisecond})
+		defer stop()
+
+		link := cstesting.RandomLink()
+		_, err := s.CreateLink(ctx, link)
+		require.NoError(t, err)
+
+		verifySoloEvidence(t, a, link, 1)
+	})
+
+	t.Run("Chains blocks", func(t *testing.T) {
+		a := dummystore.New(&dummystore.Config{})
+		s, stop := newSequencer(t, a, &solo.Config{MaxTxs: 1})
+
+		_, err := s.CreateLink(ctx, cstesting.RandomLink())
+		require.NoError(t, err)
+		first := s.LastHeader()
+
+		_, err = s.CreateLink(ctx, cstesting.RandomLink())
+		require.NoError(t, err)
+		second := s.LastHeader()
+
+		assert.Equal(t, int64(2), second.Height)
+		assert.Equal(t, first.AppHash, second.PreviousAppHash)
+		stop()
+
+		s, stop = newSequencer(t, a, &solo.Config{MaxTxs: 1})
+		defer stop()
+		assert.Equal(t, second, s.LastHeader(), "Last block should be loaded on restart")
+
+		link := cstesting.RandomLink()
+		_, err = s.CreateLink(ctx, link)
+		require.NoError(t, err)
+		proof := verifySoloEvidence(t, a, link, 3)
+		assert.Equal(t, second.AppHash, proof.Header.PreviousAppHash)
+	})
+
+	t.Run("Completes interrupted blocks on restart", func(t *testing.T) {
+		_, priv, err := keys.GenerateKey(keys.ED25519)
+		require.NoError(t, err)
+
+		a := dummystore.New(&dummystore.Config{})
+		s, err := solo.New(ctx, a, headerFailingKV{a}, &solo.Config{PrivateKey: priv, MaxTxs: 1})
+		require.NoError(t, err)
+
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			s.Run(runCtx)
+			close(done)
+		}()
+
+		link := cstesting.RandomLink()
+		_, err = s.CreateLink(ctx, link)
+		assert.Error(t, err)
+		cancel()
+		<-done
+
+		linkHash, _ := link.Hash()
+		segment, err := a.GetSegment(ctx, linkHash)
+		require.NoError(t, err)
+		require.NotNil(t, segment, "Link should be stored")
+		assert.Nil(t, segment.Meta.GetEvidence(solo.DefaultChainID), "Block should be interrupted")
+
+		s, stop := newSequencer(t, a, &solo.Config{PrivateKey: priv, MaxTxs: 1})
+		defer stop()
+
+		proof := verifySoloEvidence(t, a, link, 1)
+		assert.Equal(t, proof.Header, s.LastHeader())
+
+		next := cstesting.RandomLink()
+		_, err = s.CreateLink(ctx, next)
+		require.NoError(t, err)
+		verifySoloEvidence(t, a, next, 2)
+	})
+
+	t.Run("Discards blocks that could not be committed", func(t *testing.T) {
+		_, priv, err := keys.GenerateKey(keys.ED25519)
+		require.NoError(t, err)
+
+		a := dummystore.New(&dummystore.Config{})
+		s, err := solo.New(ctx, a, pendingBlockFailingKV{a, new(bool)}, &solo.Config{PrivateKey: priv, MaxTxs: 1})
+		require.NoError(t, err)
+
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			s.Run(runCtx)
+			close(done)
+		}()
+		defer func() {
+			cancel()
+			<-done
+		}()
+
+		discarded := cstesting.RandomLink()
+		_, err = s.CreateLink(ctx, discarded)
+		assert.Error(t, err)
+
+		link := cstesting.RandomLink()
+		_, err = s.CreateLink(ctx, link)
+		require.NoError(t, err)
+		verifySoloEvidence(t, a, link, 1)
+
+		discardedHash, _ := discarded.Hash()
+		segment, err := a.GetSegment(ctx, discardedHash)
+		assert.NoError(t, err)
+		assert.Nil(t, segment, "Discarded link should not be stored")
+	})
+
+	t.Run("Writes batches in a single block", func(t *testing.T) {
+		a := dummystore.New(&dummystore.Config{})
+		s, stop := newSequencer(t, a, &solo.Config{MaxTxs: 1})
+		defer stop()
+
+		b, err := s.NewBatch(ctx)
+		require.NoError(t, err)
+
+		link1, link2 := cstesting.RandomLink(), cstesting.RandomLink()
+		_, err = b.CreateLink(ctx, link1)
+		require.NoError(t, err)
+		_, err = b.CreateLink(ctx, link2)
+		require.NoError(t, err)
+		require.NoError(t, b.Write(ctx))
+
+		proof1 := verifySoloEvidence(t, a, link1, 1)
+		proof2 := verifySoloEvidence(t, a, link2, 1)
+		assert.Equal(t, proof1.Header, proof2.Header, "Links should be in the same block")
+
+		b, err = s.NewBatch(ctx)
+		require.NoError(t, err)
+
+		valid, invalid := cstesting.RandomLink(), cstesting.NewLinkBuilder().Invalid().Build()
+		_, err = b.CreateLink(ctx, valid)
+		require.NoError(t, err)
+		_, err = b.CreateLink(ctx, invalid)
+		require.NoError(t, err)
+		assert.Error(t, b.Write(ctx))
+
+		validHash, _ := valid.Hash()
+		segment, err := a.GetSegment(ctx, validHash)
+		assert.NoError(t, err)
+		assert.Nil(t, segment, "No link of a rejected batch should be stored")
+	})
+
+	t.Run("Rejects invalid links", func(t *testing.T) {
+		a := dummystore.New(&dummystore.Config{})
+		s, stop := newSequencer(t, a, &solo.Config{BlockInterval: 10 * time.Millisecond})
+		defer stop()
+
+		invalid := cstesting.NewLinkBuilder().Invalid().Build()
+		_, err := s.CreateLink(ctx, invalid)
+		assert.Error(t, err)
+
+		valid := cstesting.RandomLink()
+		results, err := s.CreateLinks(ctx, []*cs.Link{invalid, valid}, store.WriteModeCommit)
+		require.NoError(t, err)
+		assert.Equal(t, store.LinkRejected, results[0].Status)
+		assert.NotEmpty(t, results[0].Error)
+		assert.Equal(t, store.LinkCommitted, results[1].Status)
+
+		invalidHash, _ := invalid.Hash()
+		segment, err := a.GetSegment(ctx, invalidHash)
+		assert.NoError(t, err)
+		assert.Nil(t, segment, "Invalid link should not be stored")
+	})
+
+	t.Run("Sync mode returns before commit", func(t *testing.T) {
+		a := dummystore.New(&dummystore.Config{})
+		s, stop := newSequencer(t, a, &solo.Config{BlockInterval: time.Minute})
+
+		link := cstesting.RandomLink()
+		results, err := s.CreateLinks(ctx, []*cs.Link{link}, store.WriteModeSync)
+		require.NoError(t, err)
+		assert.Equal(t, store.LinkPending, results[0].Status)
+
+		// Stopping the sequencer commits the pending block.
+		stop()
+		verifySoloEvidence(t, a, link, 1)
+	})
+
+	t.Run("Sends events on commit", func(t *testing.T) {
+		a := dummystore.New(&dummystore.Config{})
+		s, stop := newSequencer(t, a, &solo.Config{MaxTxs: 1})
+		defer stop()
+
+		events := make(chan *store.Event, 10)
+		s.AddStoreEventChannel(events)
+
+		link := cstesting.RandomLink()
+		_, err := s.CreateLink(ctx, link)
+		require.NoError(t, err)
+
+		linksEvent := <-events
+		assert.Equal(t, store.SavedLinks, linksEvent.EventType)
+		assert.Equal(t, []*cs.Link{link}, linksEvent.Data)
+
+		evidenceEvent := <-events
+		assert.Equal(t, store.SavedEvidences, evidenceEvent.EventType)
+		assert.Len(t, evidenceEvent.Data, 1)
+	})
+
+	t.Run("Refuses links when stopped", func(t *testing.T) {
+		_, priv, _ := keys.GenerateKey(keys.ED25519)
+		a := dummystore.New(&dummystore.Config{})
+		s, err := solo.New(ctx, a, a, &solo.Config{PrivateKey: priv})
+		require.NoError(t, err)
+
+		_, err = s.CreateLink(ctx, cstesting.RandomLink())
+		assert.Equal(t, solo.ErrStopped, err)
+	})
+
+	t.Run("Requires a private key", func(t *testing.T) {
+		a := dummystore.New(&dummystore.Config{})
+		_, err := solo.New(ctx, a, a, &solo.Config{})
+		assert.Equal(t, solo.ErrMissingPrivateKey, err)
+	})
+}
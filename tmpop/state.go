@@ -19,6 +19,7 @@ import (
 	"crypto/sha256"
 	"fmt"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/stratumn/go-indigocore/bufferedbatch"
 	"github.com/stratumn/go-indigocore/cs"
 	"github.com/stratumn/go-indigocore/store"
@@ -30,6 +31,7 @@ import (
 // State represents the app states, separating the committed state (for queries)
 // from the working state (for CheckTx and DeliverTx).
 type State struct {
+	height          int64
 	previousAppHash *types.Bytes32
 	// The same validator is used for a whole commit
 	// When beginning a new block, the validator can
@@ -37,15 +39,25 @@ type State struct {
 	validator validator.Validator
 
 	adapter            store.Adapter
+	kv                 store.KeyValueStore
 	deliveredLinks     store.Batch
 	deliveredLinksList []*cs.Link
 	checkedLinks       store.Batch
 
+	deliveredEvidences []*LinkEvidence
+	checkedEvidences   []*LinkEvidence
+
+	// Processes whose validation rules are updated by the delivered
+	// transactions.
+	governanceUpdates []string
+
 	governance *validator.GovernanceManager
 }
 
 // NewState creates a new State.
-func NewState(ctx context.Context, a store.Adapter, config *Config) (*State, error) {
+// The key-value store keeps the consensus state that isn't in the store,
+// like which providers added evidence to a link with a transaction.
+func NewState(ctx context.Context, a store.Adapter, kv store.KeyValueStore, config *Config) (*State, error) {
 	deliveredLinks, err := a.NewBatch(ctx)
 	if err != nil {
 		return nil, err
@@ -57,6 +69,7 @@ func NewState(ctx context.Context, a store.Adapter, config *Config) (*State, err
 
 	state := &State{
 		adapter:        a,
+		kv:             kv,
 		deliveredLinks: deliveredLinks,
 		checkedLinks:   checkedLinks,
 	}
@@ -74,93 +87,369 @@ func (s *State) UpdateValidators(ctx context.Context) {
 	s.governance.UpdateValidators(ctx, &s.validator)
 }
 
-// Check checks if creating this link is a valid operation
-func (s *State) Check(ctx context.Context, link *cs.Link) *ABCIError {
-	return s.checkLinkAndAddToBatch(ctx, link, s.checkedLinks)
+// BeginBlock prepares the state for a new block at the given height chained
+// to the given app hash. Validators are updated if a new version is
+// available.
+func (s *State) BeginBlock(ctx context.Context, height int64, previousAppHash *types.Bytes32) {
+	s.UpdateValidators(ctx)
+	s.height = height
+	s.previousAppHash = previousAppHash
+}
+
+// ValidatorHash returns the hash of the validator used for the current
+// block, or nil if there is no validator.
+func (s *State) ValidatorHash() (*types.Bytes32, error) {
+	if s.validator == nil {
+		return nil, nil
+	}
+
+	return s.validator.Hash()
+}
+
+// Check checks if a transaction is a valid operation
+func (s *State) Check(ctx context.Context, tx *Tx) *ABCIError {
+	_, res := s.applyTx(ctx, tx, s.checkedLinks, &s.checkedEvidences)
+	return res
 }
 
-// Deliver adds a link to the list of links to be committed
-func (s *State) Deliver(ctx context.Context, link *cs.Link) *ABCIError {
-	res := s.checkLinkAndAddToBatch(ctx, link, s.deliveredLinks)
+// Deliver adds the links and evidence of a transaction to the list of
+// those to be committed
+func (s *State) Deliver(ctx context.Context, tx *Tx) *ABCIError {
+	links, res := s.applyTx(ctx, tx, s.deliveredLinks, &s.deliveredEvidences)
 	if res.IsOK() {
-		s.deliveredLinksList = append(s.deliveredLinksList, link)
+		s.deliveredLinksList = append(s.deliveredLinksList, links...)
+		if tx.TxType == UpdateGovernance {
+			s.governanceUpdates = append(s.governanceUpdates, tx.Governance.Process)
+		}
 	}
 	return res
 }
 
+// applyTx validates a transaction and adds its links to the batch and its
+// evidence to the given list. It returns the links created.
+func (s *State) applyTx(ctx context.Context, tx *Tx, batch store.Batch, evidences *[]*LinkEvidence) ([]*cs.Link, *ABCIError) {
+	switch tx.TxType {
+	case CreateLink:
+		if tx.Link == nil {
+			return nil, &ABCIError{Code: CodeTypeValidation, Log: "Link is missing"}
+		}
+		if res := s.checkLinkAndAddToBatch(ctx, tx.Link, batch); !res.IsOK() {
+			return nil, res
+		}
+		return []*cs.Link{tx.Link}, nil
+
+	case CreateLinks:
+		if res := s.checkLinksAndAddToBatch(ctx, tx.Links, batch); !res.IsOK() {
+			return nil, res
+		}
+		return tx.Links, nil
+
+	case AddLinkEvidence:
+		evidence := &LinkEvidence{LinkHash: tx.LinkHash, Evidence: tx.Evidence}
+		if res := s.checkEvidence(ctx, evidence, batch, *evidences); !res.IsOK() {
+			return nil, res
+		}
+		*evidences = append(*evidences, evidence)
+		return nil, nil
+
+	case UpdateGovernance:
+		link, res := s.governanceLink(ctx, tx.Governance, batch)
+		if !res.IsOK() {
+			return nil, res
+		}
+		if res := s.checkLinkAndAddToBatch(ctx, link, batch); !res.IsOK() {
+			return nil, res
+		}
+		return []*cs.Link{link}, nil
+
+	default:
+		return nil, &ABCIError{
+			Code: CodeTypeNotImplemented,
+			Log:  fmt.Sprintf("Unexpected Tx type byte %X", byte(tx.TxType)),
+		}
+	}
+}
+
 // checkLinkAndAddToBatch validates the link's format and runs the validations (signatures, schema)
 func (s *State) checkLinkAndAddToBatch(ctx context.Context, link *cs.Link, batch store.Batch) *ABCIError {
-	err := link.Validate(ctx, batch.GetSegment)
+	if res := s.checkLink(ctx, link, batch); !res.IsOK() {
+		return res
+	}
+
+	if _, err := batch.CreateLink(ctx, link); err != nil {
+		return &ABCIError{
+			Code: CodeTypeInternalError,
+			Log:  err.Error(),
+		}
+	}
+
+	return nil
+}
+
+// checkLinksAndAddToBatch validates all the links before adding them to
+// the batch, so that no link is added if one of them is invalid.
+// Links can reference links that appear before them in the list.
+// Every link is validated so that all the rejected links are reported at
+// once.
+func (s *State) checkLinksAndAddToBatch(ctx context.Context, links []*cs.Link, batch store.Batch) *ABCIError {
+	if len(links) == 0 {
+		return &ABCIError{Code: CodeTypeValidation, Log: "Links are missing"}
+	}
+
+	pending := &pendingLinks{
+		SegmentReader: batch,
+		links:         make(map[types.Bytes32]*cs.Link, len(links)),
+	}
+	var rejected []RejectedLink
+	for i, link := range links {
+		if link == nil {
+			rejected = append(rejected, RejectedLink{Index: i, Error: "link is missing"})
+			continue
+		}
+
+		if res := s.checkLink(ctx, link, pending); !res.IsOK() {
+			rejected = append(rejected, RejectedLink{Index: i, Error: res.Log})
+			continue
+		}
+
+		linkHash, err := link.Hash()
+		if err != nil {
+			rejected = append(rejected, RejectedLink{Index: i, Error: err.Error()})
+			continue
+		}
+		if _, ok := pending.links[*linkHash]; ok {
+			rejected = append(rejected, RejectedLink{Index: i, Error: "duplicate link"})
+			continue
+		}
+		pending.links[*linkHash] = link
+	}
+
+	if len(rejected) > 0 {
+		return rejectedLinksError(CodeTypeValidation, rejected)
+	}
+
+	for _, link := range links {
+		if _, err := batch.CreateLink(ctx, link); err != nil {
+			return &ABCIError{
+				Code: CodeTypeInternalError,
+				Log:  err.Error(),
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkLink validates the link's format and runs the validations (signatures, schema)
+func (s *State) checkLink(ctx context.Context, link *cs.Link, reader store.SegmentReader) *ABCIError {
+	err := link.Validate(ctx, reader.GetSegment)
 	if err != nil {
 		return &ABCIError{
-			CodeTypeValidation,
-			fmt.Sprintf("Link validation failed %v: %v", link, err),
+			Code: CodeTypeValidation,
+			Log:  fmt.Sprintf("Link validation failed %v: %v", link, err),
 		}
 	}
 
 	if s.validator != nil {
-		err = s.validator.Validate(ctx, batch, link)
+		err = s.validator.Validate(ctx, reader, link)
 		if err != nil {
 			return &ABCIError{
-				CodeTypeValidation,
-				fmt.Sprintf("Link validation rules failed: %v", err),
+				Code: CodeTypeValidation,
+				Log:  fmt.Sprintf("Link validation rules failed: %v", err),
 			}
 		}
 	}
 
-	if _, err := batch.CreateLink(ctx, link); err != nil {
-		return &ABCIError{
-			CodeTypeInternalError,
-			err.Error(),
+	return nil
+}
+
+// checkEvidence validates external evidence: the link must exist and no
+// evidence from the same provider must have been committed for it.
+// Evidence added to the store outside of transactions and the proof types
+// registered on the node may differ between nodes, so they are not used:
+// the proof is not verified.
+func (s *State) checkEvidence(ctx context.Context, e *LinkEvidence, reader store.SegmentReader, pending []*LinkEvidence) *ABCIError {
+	if e.LinkHash == nil {
+		return &ABCIError{Code: CodeTypeValidation, Log: "Link hash is missing"}
+	}
+
+	if e.Evidence == nil || e.Evidence.Proof == nil {
+		return &ABCIError{Code: CodeTypeValidation, Log: "Evidence is missing"}
+	}
+
+	if e.Evidence.Backend == "" || e.Evidence.Provider == "" {
+		return &ABCIError{Code: CodeTypeValidation, Log: "Evidence backend and provider are required"}
+	}
+
+	if e.Evidence.Backend == Name {
+		return &ABCIError{Code: CodeTypeValidation, Log: "TMPoP evidence is generated by the nodes and cannot be added"}
+	}
+
+	segment, err := reader.GetSegment(ctx, e.LinkHash)
+	if err != nil {
+		return &ABCIError{Code: CodeTypeInternalError, Log: err.Error()}
+	}
+	if segment == nil {
+		return &ABCIError{Code: CodeTypeValidation, Log: fmt.Sprintf("Link %x not found", *e.LinkHash)}
+	}
+
+	committedHeight, err := getLinkEvidenceHeight(ctx, s.kv, e.LinkHash, e.Evidence.Provider)
+	if err != nil {
+		return &ABCIError{Code: CodeTypeInternalError, Log: err.Error()}
+	}
+	if committedHeight > 0 {
+		return &ABCIError{Code: CodeTypeValidation, Log: fmt.Sprintf("Link %x already has evidence from %s", *e.LinkHash, e.Evidence.Provider)}
+	}
+	for _, p := range pending {
+		if *p.LinkHash == *e.LinkHash && p.Evidence.Provider == e.Evidence.Provider {
+			return &ABCIError{Code: CodeTypeValidation, Log: fmt.Sprintf("Link %x already has evidence from %s", *e.LinkHash, e.Evidence.Provider)}
 		}
 	}
 
 	return nil
 }
 
-// Commit commits the delivered links,
+// governanceLink builds the link that updates validation rules.
+func (s *State) governanceLink(ctx context.Context, update *GovernanceUpdate, reader store.SegmentReader) (*cs.Link, *ABCIError) {
+	if update == nil {
+		return nil, &ABCIError{Code: CodeTypeValidation, Log: "Governance update is missing"}
+	}
+
+	link, err := s.governance.GovernanceLink(ctx, reader, update.Process, update.PKI, update.Types)
+	if err != nil {
+		return nil, &ABCIError{Code: CodeTypeValidation, Log: err.Error()}
+	}
+	link.Signatures = append(link.Signatures, update.Signatures...)
+
+	return link, nil
+}
+
+// Pending returns the app hash, links and evidence of the delivered
+// transactions without committing them.
+func (s *State) Pending() (*types.Bytes32, []*cs.Link, []*LinkEvidence, error) {
+	appHash, err := s.computeAppHash()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	links := make([]*cs.Link, len(s.deliveredLinksList))
+	copy(links, s.deliveredLinksList)
+
+	linkEvidences := make([]*LinkEvidence, len(s.deliveredEvidences))
+	copy(linkEvidences, s.deliveredEvidences)
+
+	return appHash, links, linkEvidences, nil
+}
+
+// Commit commits the delivered links and evidence,
 // resets delivered and checked state,
-// and returns the hash for the commit
-// and the list of committed links.
-func (s *State) Commit(ctx context.Context) (*types.Bytes32, []*cs.Link, error) {
+// and returns the hash for the commit,
+// the list of committed links and the list of committed evidence.
+// The state is also reset if the commit fails, so that the block's
+// transactions can be delivered again.
+func (s *State) Commit(ctx context.Context) (_ *types.Bytes32, _ []*cs.Link, _ []*LinkEvidence, err error) {
+	defer func() {
+		if err != nil {
+			if resetErr := s.Reset(ctx); resetErr != nil {
+				log.Errorf("Could not reset state after a failed commit: %s", resetErr)
+			}
+		}
+	}()
+
 	appHash, err := s.computeAppHash()
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	if err := s.deliveredLinks.Write(ctx); err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
-	if s.deliveredLinks, err = s.adapter.NewBatch(ctx); err != nil {
-		return nil, nil, err
+	for _, e := range s.deliveredEvidences {
+		if err := s.addEvidence(ctx, e); err != nil {
+			return nil, nil, nil, err
+		}
+		if err := saveLinkEvidenceHeight(ctx, s.kv, e.LinkHash, e.Evidence.Provider, s.height); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	for _, process := range s.governanceUpdates {
+		s.governance.ReloadProcess(ctx, process)
+	}
+
+	committedLinks := s.deliveredLinksList
+	committedEvidences := s.deliveredEvidences
+
+	if err := s.Reset(ctx); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return appHash, committedLinks, committedEvidences, nil
+}
+
+// addEvidence saves committed evidence in the store.
+// Evidence from the same provider can already be in the store if it was
+// added outside of transactions, or by a previous attempt to commit the
+// block. It is kept. Evidence whose proof type isn't registered on the node
+// is not saved since it couldn't be read back.
+func (s *State) addEvidence(ctx context.Context, e *LinkEvidence) error {
+	evidence := decodeProof(e.Evidence)
+	if _, ok := evidence.Proof.(encodedProof); ok {
+		log.Warnf("Link %x has evidence from %s with an unknown %s proof, committed evidence not saved", *e.LinkHash, evidence.Provider, evidence.Backend)
+		return nil
+	}
+
+	evidences, err := s.adapter.GetEvidences(ctx, e.LinkHash)
+	if err != nil {
+		return err
+	}
+	if evidences != nil && evidences.GetEvidence(e.Evidence.Provider) != nil {
+		log.Warnf("Link %x already has evidence from %s in the store, committed evidence not saved", *e.LinkHash, e.Evidence.Provider)
+		return nil
+	}
+
+	return s.adapter.AddEvidence(ctx, e.LinkHash, evidence)
+}
+
+// Reset discards the delivered and checked transactions that were not
+// committed.
+func (s *State) Reset(ctx context.Context) error {
+	deliveredLinks, err := s.adapter.NewBatch(ctx)
+	if err != nil {
+		return err
 	}
-	s.checkedLinks = bufferedbatch.NewBatch(ctx, s.adapter)
 
-	committedLinks := make([]*cs.Link, len(s.deliveredLinksList))
-	copy(committedLinks, s.deliveredLinksList)
+	s.deliveredLinks = deliveredLinks
 	s.deliveredLinksList = nil
+	s.deliveredEvidences = nil
+	s.checkedLinks = bufferedbatch.NewBatch(ctx, s.adapter)
+	s.checkedEvidences = nil
+	s.governanceUpdates = nil
 
-	return appHash, committedLinks, nil
+	return nil
 }
 
 func (s *State) computeAppHash() (*types.Bytes32, error) {
-	var validatorHash *types.Bytes32
-	if s.validator != nil {
-		h, err := s.validator.Hash()
-		if err != nil {
-			return nil, err
-		}
-		validatorHash = h
+	validatorHash, err := s.ValidatorHash()
+	if err != nil {
+		return nil, err
 	}
 
 	var merkleRoot *types.Bytes32
-	if len(s.deliveredLinksList) > 0 {
+	if len(s.deliveredLinksList) > 0 || len(s.deliveredEvidences) > 0 {
 		var treeLeaves [][]byte
 		for _, link := range s.deliveredLinksList {
 			linkHash, _ := link.Hash()
 			treeLeaves = append(treeLeaves, linkHash[:])
 		}
+		for _, e := range s.deliveredEvidences {
+			evidenceHash, err := e.Hash()
+			if err != nil {
+				return nil, err
+			}
+			treeLeaves = append(treeLeaves, evidenceHash[:])
+		}
 
 		merkle, err := merkle.NewStaticTree(treeLeaves)
 		if err != nil {
@@ -173,6 +462,22 @@ func (s *State) computeAppHash() (*types.Bytes32, error) {
 	return ComputeAppHash(s.previousAppHash, validatorHash, merkleRoot)
 }
 
+// pendingLinks is a store.SegmentReader that also returns links that are
+// not added to the underlying reader yet.
+type pendingLinks struct {
+	store.SegmentReader
+	links map[types.Bytes32]*cs.Link
+}
+
+// GetSegment implements github.com/stratumn/go-indigocore/store.SegmentReader.GetSegment.
+func (p *pendingLinks) GetSegment(ctx context.Context, linkHash *types.Bytes32) (*cs.Segment, error) {
+	if link, ok := p.links[*linkHash]; ok {
+		return link.Segmentify(), nil
+	}
+
+	return p.SegmentReader.GetSegment(ctx, linkHash)
+}
+
 // ComputeAppHash computes the app hash from its required parts
 // If one of the parts is nil or empty, we'll pad with 0s so that
 // we always hash a 96-bytes array
@@ -18,6 +18,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -67,6 +68,17 @@ type Config struct {
 
 	// Monitoring configuration
 	Monitoring *monitoring.Config
+
+	// The maximum number of blocks processed at the beginning of a block
+	// to back-fill missing evidence.
+	EvidenceBackfillLimit int
+
+	// Snapshot configuration
+	Snapshot *SnapshotConfig
+
+	// The number of blocks whose events are kept for GetEvents queries.
+	// Zero means DefaultEventsRetention.
+	EventsRetention int64
 }
 
 // TMPop is the type of the application that implements github.com/tendermint/abci/types.Application,
@@ -80,8 +92,26 @@ type TMPop struct {
 	lastBlock     *LastBlock
 	config        *Config
 	currentHeader *abci.Header
-	tmClient      TendermintClient
 	eventsManager eventsManager
+	proofCache    *proofCache
+	snapshotting  int32
+
+	// The evidence worker state is shared with the ABCI connections and
+	// guarded by evidenceMutex.
+	evidenceMutex        sync.Mutex
+	tmClient             TendermintClient
+	evidenceWake         chan struct{}
+	evidenceTargetHeight int64
+	evidenceChainID      string
+	evidencePaused       bool
+	evidenceRunning      bool
+
+	// Evidence built by the worker is queued and only written to the
+	// store by Commit, so that the store is never written concurrently
+	// with a block. evidenceQueuedHeight is the height of the last queued
+	// block, zero if the queue is empty.
+	evidenceQueue        []*blockEvidence
+	evidenceQueuedHeight int64
 }
 
 const (
@@ -113,24 +143,52 @@ func New(ctx context.Context, a store.Adapter, kv store.KeyValueStore, config *C
 		return nil, errors.Wrap(err, "cannot read the last block")
 	}
 
-	s, err := NewState(ctx, a, config)
+	s, err := NewState(ctx, a, kv, config)
 	if err != nil {
 		return nil, err
 	}
 
-	return &TMPop{
+	t := &TMPop{
 		state:         s,
 		adapter:       a,
 		kvDB:          kv,
 		lastBlock:     lastBlock,
 		config:        config,
 		currentHeader: lastBlock.LastHeader,
-	}, nil
+		proofCache:    newProofCache(proofCacheSize),
+	}
+	t.eventsManager.pendingHeight = lastBlock.Height
+
+	if err := t.loadOptions(ctx); err != nil {
+		return nil, errors.Wrap(err, "cannot load options")
+	}
+
+	if err := t.initLastEvidenceHeight(ctx); err != nil {
+		return nil, errors.Wrap(err, "cannot initialize evidence height")
+	}
+
+	if err := t.migrateLinkHeights(ctx); err != nil {
+		return nil, errors.Wrap(err, "cannot index link heights")
+	}
+
+	if err := t.migrateEvidenceHeights(ctx); err != nil {
+		return nil, errors.Wrap(err, "cannot index evidence heights")
+	}
+
+	return t, nil
 }
 
-// ConnectTendermint connects TMPoP to a Tendermint node
+// ConnectTendermint connects TMPoP to a Tendermint node and starts the
+// worker generating Tendermint evidence.
 func (t *TMPop) ConnectTendermint(tmClient TendermintClient) {
+	t.evidenceMutex.Lock()
 	t.tmClient = tmClient
+	if t.evidenceWake == nil {
+		t.evidenceWake = make(chan struct{}, 1)
+		go t.runEvidenceWorker(t.evidenceWake)
+	}
+	t.evidenceMutex.Unlock()
+
 	log.Info("TMPoP connected to Tendermint Core")
 }
 
@@ -156,11 +214,23 @@ func (t *TMPop) Info(req abci.RequestInfo) abci.ResponseInfo {
 }
 
 // SetOption implements github.com/tendermint/abci/types.Application.SetOption.
+// Only runtime options are supported (see OptionLogLevel and the following).
+// Options affecting consensus are rejected.
 func (t *TMPop) SetOption(req abci.RequestSetOption) abci.ResponseSetOption {
-	return abci.ResponseSetOption{
-		Code: CodeTypeNotImplemented,
-		Log:  "No options are supported yet",
+	ctx, span := trace.StartSpan(context.Background(), "tmpop/SetOption")
+	span.AddAttributes(trace.StringAttribute("Key", req.Key))
+	defer span.End()
+
+	if res := t.setOption(ctx, req.Key, req.Value); !res.IsOK() {
+		span.SetStatus(trace.Status{Code: monitoring.InvalidArgument, Message: res.Log})
+		return abci.ResponseSetOption{
+			Code: res.Code,
+			Log:  res.Log,
+		}
 	}
+
+	log.Infof("Option %s set to %s", req.Key, req.Value)
+	return abci.ResponseSetOption{}
 }
 
 // BeginBlock implements github.com/tendermint/abci/types.Application.BeginBlock.
@@ -182,7 +252,7 @@ func (t *TMPop) BeginBlock(req abci.RequestBeginBlock) abci.ResponseBeginBlock {
 	// This AppHash will never be denied in a future block so we can add
 	// evidence to the links that were added in the previous blocks.
 	if t.lastBlock.AppHash.EqualsBytes(t.currentHeader.AppHash) {
-		t.addTendermintEvidence(ctx, &req.Header)
+		t.scheduleTendermintEvidence(&req.Header)
 	} else {
 		errorMessage := fmt.Sprintf(
 			"Unexpected AppHash in BeginBlock, got %x, expected %x",
@@ -193,9 +263,7 @@ func (t *TMPop) BeginBlock(req abci.RequestBeginBlock) abci.ResponseBeginBlock {
 		span.Annotate(nil, errorMessage)
 	}
 
-	t.state.UpdateValidators(ctx)
-
-	t.state.previousAppHash = types.NewBytes32FromBytes(t.currentHeader.AppHash)
+	t.state.BeginBlock(ctx, t.currentHeader.Height, types.NewBytes32FromBytes(t.currentHeader.AppHash))
 
 	return abci.ResponseBeginBlock{}
 }
@@ -212,6 +280,7 @@ func (t *TMPop) DeliverTx(tx []byte) abci.ResponseDeliverTx {
 		span.SetStatus(trace.Status{Code: monitoring.InvalidArgument, Message: err.Log})
 		return abci.ResponseDeliverTx{
 			Code: err.Code,
+			Data: err.Data,
 			Log:  err.Log,
 		}
 	}
@@ -231,6 +300,7 @@ func (t *TMPop) CheckTx(tx []byte) abci.ResponseCheckTx {
 		span.SetStatus(trace.Status{Code: monitoring.InvalidArgument, Message: err.Log})
 		return abci.ResponseCheckTx{
 			Code: err.Code,
+			Data: err.Data,
 			Log:  err.Log,
 		}
 	}
@@ -244,7 +314,7 @@ func (t *TMPop) Commit() abci.ResponseCommit {
 	ctx, span := trace.StartSpan(context.Background(), "tmpop/Commit")
 	defer span.End()
 
-	appHash, links, err := t.state.Commit(ctx)
+	appHash, links, linkEvidences, err := t.state.Commit(ctx)
 	if err != nil {
 		log.Errorf("Error while committing: %s", err)
 		span.SetStatus(trace.Status{Code: monitoring.Internal, Message: err.Error()})
@@ -263,29 +333,65 @@ func (t *TMPop) Commit() abci.ResponseCommit {
 		return abci.ResponseCommit{}
 	}
 
+	if err := t.saveCommitEvidenceHashes(ctx, linkEvidences); err != nil {
+		log.Errorf("Error while saving committed evidence hashes: %s", err)
+		span.SetStatus(trace.Status{Code: monitoring.Internal, Message: err.Error()})
+		return abci.ResponseCommit{}
+	}
+
+	if err := t.saveAppHash(ctx, appHash); err != nil {
+		log.Errorf("Error while saving app hash: %s", err)
+		span.SetStatus(trace.Status{Code: monitoring.Internal, Message: err.Error()})
+		return abci.ResponseCommit{}
+	}
+
+	t.applyTendermintEvidence(ctx)
+
 	t.eventsManager.AddSavedLinks(links)
+	if len(linkEvidences) > 0 {
+		savedEvidences := make(map[*types.Bytes32]*cs.Evidence, len(linkEvidences))
+		for _, e := range linkEvidences {
+			savedEvidences[e.LinkHash] = e.Evidence
+		}
+		t.eventsManager.AddSavedEvidences(savedEvidences)
+	}
+
+	if err := t.saveBlockEvents(ctx, t.eventsManager.FlushBlockEvents()); err != nil {
+		log.Errorf("Error while saving block events: %s", err)
+		span.SetStatus(trace.Status{Code: monitoring.Internal, Message: err.Error()})
+		return abci.ResponseCommit{}
+	}
+
+	if err := t.pruneBlockEvents(ctx); err != nil {
+		log.Warnf("Could not prune block events: %s", err)
+	}
 
 	t.lastBlock.AppHash = appHash
 	t.lastBlock.Height = t.currentHeader.Height
 	t.lastBlock.LastHeader = t.currentHeader
 	saveLastBlock(ctx, t.kvDB, *t.lastBlock)
 
+	if c := t.config.Snapshot; c != nil && c.Interval > 0 && t.lastBlock.Height%c.Interval == 0 {
+		t.saveSnapshot(ctx)
+	}
+
 	return abci.ResponseCommit{
 		Data: appHash[:],
 	}
 }
 
 // Query implements github.com/tendermint/abci/types.Application.Query.
+// Queries with a non-zero height are answered on the state of the store at
+// that height (see queryAt).
+// GetSegment responses carry the JSON encoded evidences.TendermintProof of
+// the segment in their Proof field once the blocks signing it are committed.
 func (t *TMPop) Query(reqQuery abci.RequestQuery) (resQuery abci.ResponseQuery) {
 	ctx, span := trace.StartSpan(context.Background(), "tmpop/Query")
 	span.AddAttributes(trace.StringAttribute("Path", reqQuery.Path))
 	defer span.End()
 
 	if reqQuery.Height != 0 {
-		resQuery.Code = CodeTypeInternalError
-		resQuery.Log = "tmpop only supports queries on latest commit"
-		span.SetStatus(trace.Status{Code: monitoring.InvalidArgument, Message: resQuery.Log})
-		return
+		return t.queryAt(ctx, reqQuery)
 	}
 
 	resQuery.Height = t.lastBlock.Height
@@ -307,7 +413,15 @@ func (t *TMPop) Query(reqQuery abci.RequestQuery) (resQuery abci.ResponseQuery)
 			break
 		}
 
-		result, err = t.adapter.GetSegment(ctx, linkHash)
+		var segment *cs.Segment
+		if segment, err = t.adapter.GetSegment(ctx, linkHash); err != nil {
+			break
+		}
+
+		result = segment
+		if segment != nil {
+			resQuery.Proof = t.marshalSegmentProof(ctx, segment)
+		}
 
 	case GetEvidences:
 		linkHash := &types.Bytes32{}
@@ -348,8 +462,27 @@ func (t *TMPop) Query(reqQuery abci.RequestQuery) (resQuery abci.ResponseQuery)
 
 		result, err = t.adapter.GetMapIDs(ctx, filter)
 
+	case GetEvents:
+		filter := &EventsFilter{}
+		if err = json.Unmarshal(reqQuery.Data, filter); err != nil {
+			break
+		}
+
+		result, err = t.getEvents(ctx, filter)
+
 	case PendingEvents:
-		result = t.eventsManager.GetPendingEvents()
+		result, err = t.getPendingEvents(ctx)
+
+	case GetEvidenceStatus:
+		result, err = t.getEvidenceStatus(ctx)
+
+	case GetLinkHeight:
+		linkHash := &types.Bytes32{}
+		if err = linkHash.UnmarshalJSON(reqQuery.Data); err != nil {
+			break
+		}
+
+		result, err = t.getLinkHeight(ctx, linkHash)
 
 	default:
 		resQuery.Code = CodeTypeNotImplemented
@@ -377,11 +510,11 @@ func (t *TMPop) Query(reqQuery abci.RequestQuery) (resQuery abci.ResponseQuery)
 	return
 }
 
-func (t *TMPop) doTx(ctx context.Context, createLink func(context.Context, *cs.Link) *ABCIError, txBytes []byte) *ABCIError {
+func (t *TMPop) doTx(ctx context.Context, apply func(context.Context, *Tx) *ABCIError, txBytes []byte) *ABCIError {
 	if len(txBytes) == 0 {
 		return &ABCIError{
-			CodeTypeValidation,
-			"Tx length cannot be zero",
+			Code: CodeTypeValidation,
+			Log:  "Tx length cannot be zero",
 		}
 	}
 
@@ -390,155 +523,124 @@ func (t *TMPop) doTx(ctx context.Context, createLink func(context.Context, *cs.L
 		return err
 	}
 
-	switch tx.TxType {
-	case CreateLink:
-		return createLink(ctx, tx.Link)
-	default:
-		return &ABCIError{
-			CodeTypeNotImplemented,
-			fmt.Sprintf("Unexpected Tx type byte %X", tx.TxType),
-		}
-	}
+	return apply(ctx, tx)
 }
 
-// addTendermintEvidence computes and stores new evidence
-func (t *TMPop) addTendermintEvidence(ctx context.Context, header *abci.Header) {
-	ctx, span := trace.StartSpan(ctx, "tmpop/addTendermintEvidence")
-	span.AddAttributes(trace.Int64Attribute("Height", header.Height))
+// buildBlockEvidence computes the evidence of the links committed in the
+// block at the given height.
+func (t *TMPop) buildBlockEvidence(ctx context.Context, height int64, chainID string) (map[*types.Bytes32]*cs.Evidence, error) {
+	ctx, span := trace.StartSpan(ctx, "tmpop/buildBlockEvidence")
+	span.AddAttributes(trace.Int64Attribute("Height", height))
 	defer span.End()
 
-	if t.tmClient == nil {
-		log.Warn("TMPoP not connected to Tendermint Core. Evidence will not be generated.")
-		span.SetStatus(trace.Status{Code: monitoring.Unavailable, Message: "TMPoP not connected to Tendermint Core."})
-		return
+	proofs, err := t.tendermintProofs(ctx, height)
+	if err != nil {
+		span.SetStatus(trace.Status{Code: monitoring.Unavailable, Message: err.Error()})
+		return nil, err
 	}
 
-	// Evidence for block N can only be generated at the beginning of block N+3.
-	// That is because we need signatures for both block N and block N+1
-	// (since the data is always reflected in the next block's AppHash)
-	// so we need block N+1 to be committed.
-	// The signatures for block N+1 will only be included in block N+2 so
-	// we need block N+2 to be committed.
-	evidenceHeight := header.Height - 3
-	if evidenceHeight <= 0 {
-		span.SetStatus(trace.Status{Code: monitoring.FailedPrecondition})
-		return
+	span.AddAttributes(trace.Int64Attribute("LinksCount", int64(len(proofs))))
+
+	newEvidences := make(map[*types.Bytes32]*cs.Evidence, len(proofs))
+	for lh, proof := range proofs {
+		newEvidences[types.NewBytes32FromBytes(lh[:])] = &cs.Evidence{
+			Backend:  Name,
+			Provider: chainID,
+			Proof:    proof,
+		}
 	}
 
-	linkHashes, err := t.getCommitLinkHashes(ctx, evidenceHeight)
+	return newEvidences, nil
+}
+
+// tendermintProofs builds the Tendermint proofs of the valid links committed
+// in the block at the given height. It requires the two following blocks to
+// be committed since their votes sign the block and its app hash.
+func (t *TMPop) tendermintProofs(ctx context.Context, height int64) (map[types.Bytes32]*evidences.TendermintProof, error) {
+	ctx, span := trace.StartSpan(ctx, "tmpop/tendermintProofs")
+	span.AddAttributes(trace.Int64Attribute("Height", height))
+	defer span.End()
+
+	tmClient := t.tendermintClient()
+	if tmClient == nil {
+		return nil, errors.New("TMPoP not connected to Tendermint Core")
+	}
+
+	linkHashes, err := t.getCommitLinkHashes(ctx, height)
 	if err != nil {
-		log.Warnf("Could not get link hashes for block %d. Evidence will not be generated.", header.Height)
-		span.SetStatus(trace.Status{Code: monitoring.Unavailable, Message: "Could not get link hashes"})
-		return
+		return nil, errors.Wrap(err, "could not get link hashes")
 	}
 
 	if len(linkHashes) == 0 {
-		return
+		return nil, nil
 	}
 
-	span.AddAttributes(trace.Int64Attribute("LinksCount", int64(len(linkHashes))))
-
-	validatorHash, err := t.getValidatorHash(ctx, evidenceHeight)
+	validatorHash, err := t.getValidatorHash(ctx, height)
 	if err != nil {
-		log.Warnf("Could not get validator hash for block %d. Evidence will not be generated.", header.Height)
-		span.SetStatus(trace.Status{Code: monitoring.Internal, Message: "Could not get validator hash"})
-		return
+		return nil, errors.Wrap(err, "could not get validator hash")
 	}
 
-	evidenceBlock, err := t.tmClient.Block(ctx, evidenceHeight)
+	block, err := tmClient.Block(ctx, height)
 	if err != nil {
-		log.Warnf("Could not get block %d header: %v", header.Height, err)
-		span.SetStatus(trace.Status{Code: monitoring.Unavailable, Message: "Could not get block"})
-		return
+		return nil, errors.Wrap(err, "could not get block")
 	}
 
-	evidenceNextBlock, err := t.tmClient.Block(ctx, evidenceHeight+1)
+	nextBlock, err := tmClient.Block(ctx, height+1)
 	if err != nil {
-		log.Warnf("Could not get next block %d header: %v", header.Height, err)
-		span.SetStatus(trace.Status{Code: monitoring.Unavailable, Message: "Could not get next block"})
-		return
+		return nil, errors.Wrap(err, "could not get next block")
 	}
 
-	evidenceLastBlock, err := t.tmClient.Block(ctx, evidenceHeight+2)
+	lastBlock, err := tmClient.Block(ctx, height+2)
 	if err != nil {
-		log.Warnf("Could not get last block %d header: %v", header.Height, err)
-		span.SetStatus(trace.Status{Code: monitoring.Unavailable, Message: "Could not get last block"})
-		return
+		return nil, errors.Wrap(err, "could not get last block")
 	}
 
-	if len(evidenceNextBlock.Votes) == 0 || len(evidenceLastBlock.Votes) == 0 {
-		log.Warnf("Block %d isn't signed by validator nodes. Evidence will not be generated.", header.Height)
-		span.SetStatus(trace.Status{Code: monitoring.FailedPrecondition, Message: "Votes are missing"})
-		return
+	if len(nextBlock.Votes) == 0 || len(lastBlock.Votes) == 0 {
+		return nil, errors.Wrap(errInvalidBlock, "block isn't signed by validator nodes")
 	}
 
-	evidenceBlockAppHash := types.NewBytes32FromBytes(evidenceBlock.Header.AppHash)
-	leaves := make([][]byte, len(linkHashes), len(linkHashes))
-	for i, lh := range linkHashes {
-		leaves[i] = make([]byte, len(lh), len(lh))
-		copy(leaves[i], lh[:])
+	evidenceHashes, err := t.getCommitEvidenceHashes(ctx, height)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get evidence hashes")
 	}
-	merkle, err := merkle.NewStaticTree(leaves)
+
+	// Evidence hashes follow link hashes in the block's merkle tree.
+	leaves := make([][]byte, 0, len(linkHashes)+len(evidenceHashes))
+	for _, lh := range append(linkHashes, evidenceHashes...) {
+		leaf := make([]byte, len(lh), len(lh))
+		copy(leaf, lh[:])
+		leaves = append(leaves, leaf)
+	}
+	tree, err := merkle.NewStaticTree(leaves)
 	if err != nil {
-		log.Warnf("Could not create merkle tree for block %d. Evidence will not be generated.", header.Height)
-		span.SetStatus(trace.Status{Code: monitoring.Internal, Message: "Could not create merkle tree"})
-		return
+		return nil, errors.Wrapf(errInvalidBlock, "could not create merkle tree: %s", err)
 	}
 
-	merkleRoot := types.NewBytes32FromBytes(merkle.Root())
+	merkleRoot := types.NewBytes32FromBytes(tree.Root())
 
-	appHash, err := ComputeAppHash(evidenceBlockAppHash, validatorHash, merkleRoot)
+	appHash, err := ComputeAppHash(types.NewBytes32FromBytes(block.Header.AppHash), validatorHash, merkleRoot)
 	if err != nil {
-		log.Warnf("Could not compute app hash for block %d. Evidence will not be generated.", header.Height)
-		span.SetStatus(trace.Status{Code: monitoring.Internal, Message: "Could not compute app hash"})
-		return
+		return nil, errors.Wrapf(errInvalidBlock, "could not compute app hash: %s", err)
 	}
-	if !appHash.EqualsBytes(evidenceNextBlock.Header.AppHash) {
-		log.Warnf("App hash %x of block %d doesn't match the header's: %x. Evidence will not be generated.",
-			*appHash,
-			header.Height,
-			header.AppHash)
-		span.SetStatus(trace.Status{Code: monitoring.FailedPrecondition, Message: "AppHash mismatch"})
-		return
+	if !appHash.EqualsBytes(nextBlock.Header.AppHash) {
+		return nil, errors.Wrapf(errInvalidBlock, "app hash %x doesn't match the next header's: %x", *appHash, nextBlock.Header.AppHash)
 	}
 
-	linksPositions := make(map[types.Bytes32]int)
+	proofs := make(map[types.Bytes32]*evidences.TendermintProof, len(linkHashes))
 	for i, lh := range linkHashes {
-		linksPositions[lh] = i
-	}
-
-	newEvidences := make(map[*types.Bytes32]*cs.Evidence)
-	for _, tx := range evidenceBlock.Txs {
-		// We only create evidence for valid transactions
-		linkHash, _ := tx.Link.Hash()
-		position, valid := linksPositions[*linkHash]
-
-		if valid {
-			evidence := &cs.Evidence{
-				Backend:  Name,
-				Provider: header.ChainID,
-				Proof: &evidences.TendermintProof{
-					BlockHeight:            evidenceHeight,
-					Root:                   merkleRoot,
-					Path:                   merkle.Path(position),
-					ValidationsHash:        validatorHash,
-					Header:                 evidenceBlock.Header,
-					HeaderVotes:            evidenceNextBlock.Votes,
-					HeaderValidatorSet:     evidenceNextBlock.Validators,
-					NextHeader:             evidenceNextBlock.Header,
-					NextHeaderVotes:        evidenceLastBlock.Votes,
-					NextHeaderValidatorSet: evidenceLastBlock.Validators,
-				},
-			}
-
-			if err := t.adapter.AddEvidence(ctx, linkHash, evidence); err != nil {
-				log.Warnf("Evidence could not be added to local store: %v", err)
-				span.Annotatef(nil, "Evidence for %x could not be added: %s", *linkHash, err.Error())
-			}
-
-			newEvidences[linkHash] = evidence
+		proofs[lh] = &evidences.TendermintProof{
+			BlockHeight:            height,
+			Root:                   merkleRoot,
+			Path:                   tree.Path(i),
+			ValidationsHash:        validatorHash,
+			Header:                 block.Header,
+			HeaderVotes:            nextBlock.Votes,
+			HeaderValidatorSet:     nextBlock.Validators,
+			NextHeader:             nextBlock.Header,
+			NextHeaderVotes:        lastBlock.Votes,
+			NextHeaderValidatorSet: lastBlock.Validators,
 		}
 	}
 
-	t.eventsManager.AddSavedEvidences(newEvidences)
+	return proofs, nil
 }
@@ -0,0 +1,148 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmpoptestcases
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/tmpop"
+	"github.com/stratumn/go-indigocore/tmpop/evidences"
+	"github.com/stratumn/go-indigocore/tmpop/tmpoptestcases/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tmtypes "github.com/tendermint/tendermint/types"
+)
+
+// TestEvidenceBackfill tests that evidence missed while Tendermint Core was
+// unavailable is generated once it becomes available.
+func (f Factory) TestEvidenceBackfill(t *testing.T) {
+	h, req := f.newTMPop(t, &tmpop.Config{EvidenceBackfillLimit: 2})
+	defer f.free()
+
+	validatorSet := &tmtypes.ValidatorSet{Validators: []*tmtypes.Validator{validator}}
+	validatorsHash := validatorSet.Hash()
+
+	appHashes := make([][]byte, 7)
+	appHashes[0] = req.Header.AppHash
+
+	// Blocks 1 to 6 are committed while TMPoP isn't connected to
+	// Tendermint Core.
+	links := make([]*cs.Link, 7)
+	for height := 1; height <= 6; height++ {
+		links[height], req = commitRandomLink(t, h, req)
+		appHashes[height] = req.Header.AppHash
+	}
+
+	t.Run("Does not add evidence without Tendermint Core", func(t *testing.T) {
+		status := &tmpop.EvidenceStatus{}
+		err := makeQuery(h, tmpop.GetEvidenceStatus, nil, status)
+		assert.NoError(t, err)
+		assert.Equal(t, &tmpop.EvidenceStatus{LastHeight: 0, TargetHeight: 3, Behind: 3}, status)
+
+		for height := 1; height <= 6; height++ {
+			verifyNoEvidence(t, h, links[height])
+		}
+	})
+
+	ctrl := gomock.NewController(t)
+	tmClientMock := tmpoptestcasesmocks.NewMockTendermintClient(ctrl)
+
+	blocks := make([]*tmpop.Block, 7)
+	for height := int64(1); height <= 6; height++ {
+		blocks[height] = &tmpop.Block{
+			Header: &tmtypes.Header{
+				AppHash:        appHashes[height-1],
+				ChainID:        req.Header.ChainID,
+				Height:         height,
+				Time:           time.Unix(42, 42),
+				ValidatorsHash: validatorsHash,
+			},
+			Txs:        []*tmpop.Tx{&tmpop.Tx{TxType: tmpop.CreateLink, Link: links[height]}},
+			Validators: validatorSet,
+		}
+		if height > 1 {
+			blocks[height].Votes = vote(blocks[height-1].Header)
+		}
+		tmClientMock.EXPECT().Block(gomock.Any(), height).Return(blocks[height], nil).AnyTimes()
+	}
+
+	h.ConnectTendermint(tmClientMock)
+
+	t.Run("Back-fills evidence once Tendermint Core is available", func(t *testing.T) {
+		_, req = commitRandomLink(t, h, req)
+
+		status := &tmpop.EvidenceStatus{}
+		err := makeQuery(h, tmpop.GetEvidenceStatus, nil, status)
+		assert.NoError(t, err)
+		assert.Equal(t, &tmpop.EvidenceStatus{LastHeight: 2, TargetHeight: 4, Behind: 2}, status)
+
+		verifyEvidence(t, h, links[1], 1)
+		verifyEvidence(t, h, links[2], 2)
+		verifyNoEvidence(t, h, links[3])
+	})
+
+	t.Run("Catches up over the next blocks", func(t *testing.T) {
+		_, req = commitRandomLink(t, h, req)
+
+		status := &tmpop.EvidenceStatus{}
+		err := makeQuery(h, tmpop.GetEvidenceStatus, nil, status)
+		assert.NoError(t, err)
+		assert.Equal(t, &tmpop.EvidenceStatus{LastHeight: 4, TargetHeight: 5, Behind: 1}, status)
+
+		verifyEvidence(t, h, links[3], 3)
+		verifyEvidence(t, h, links[4], 4)
+		verifyNoEvidence(t, h, links[5])
+	})
+
+	t.Run("Starts from the current height on existing chains", func(t *testing.T) {
+		ctx := context.Background()
+		_, err := f.kv.DeleteValue(ctx, []byte("tmpop:evidence:lastheight"))
+		require.NoError(t, err)
+
+		h2, err := tmpop.New(ctx, f.adapter, f.kv, &tmpop.Config{})
+		require.NoError(t, err)
+
+		status := &tmpop.EvidenceStatus{}
+		err = makeQuery(h2, tmpop.GetEvidenceStatus, nil, status)
+		assert.NoError(t, err)
+		assert.Equal(t, &tmpop.EvidenceStatus{LastHeight: 5, TargetHeight: 6, Behind: 1}, status)
+	})
+}
+
+func verifyEvidence(t *testing.T, h *tmpop.TMPop, link *cs.Link, height int64) {
+	linkHash, _ := link.Hash()
+	got := &cs.Segment{}
+	err := makeQuery(h, tmpop.GetSegment, linkHash, got)
+	require.NoError(t, err)
+
+	evidence := got.Meta.GetEvidence(h.GetCurrentHeader().GetChainID())
+	require.NotNil(t, evidence, "Evidence is missing for block %d", height)
+	assert.True(t, evidence.Proof.Verify(linkHash), "Proof should verify")
+
+	proof := evidence.Proof.(*evidences.TendermintProof)
+	assert.Equal(t, height, proof.BlockHeight, "Invalid block height in proof")
+}
+
+func verifyNoEvidence(t *testing.T, h *tmpop.TMPop, link *cs.Link) {
+	linkHash, _ := link.Hash()
+	got := &cs.Segment{}
+	err := makeQuery(h, tmpop.GetSegment, linkHash, got)
+	require.NoError(t, err)
+	assert.Empty(t, got.Meta.Evidences, "Link should not have evidence")
+}
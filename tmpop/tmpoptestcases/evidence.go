@@ -15,6 +15,7 @@
 package tmpoptestcases
 
 import (
+	"encoding/json"
 	"errors"
 	"testing"
 	"time"
@@ -31,6 +32,7 @@ import (
 	"github.com/stratumn/merkle"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	abci "github.com/tendermint/abci/types"
 	crypto "github.com/tendermint/go-crypto"
 	tmtypes "github.com/tendermint/tendermint/types"
 )
@@ -277,6 +279,38 @@ func (f Factory) TestTendermintEvidence(t *testing.T) {
 		assert.True(t, proof.Verify(linkHash2), "Proof should verify")
 	})
 
+	t.Run("Returns inclusion proofs with segments", func(t *testing.T) {
+		// linkHash2 has evidence and linkHash9 has none yet but the
+		// blocks signing it are committed.
+		for _, linkHash := range []*types.Bytes32{linkHash2, linkHash9} {
+			data, _ := linkHash.MarshalJSON()
+			q := h.Query(abci.RequestQuery{Path: tmpop.GetSegment, Data: data})
+			require.NotEmpty(t, q.Proof, "Proof is missing")
+
+			proof := &evidences.TendermintProof{}
+			require.NoError(t, json.Unmarshal(q.Proof, proof))
+			assert.True(t, proof.Verify(linkHash), "Proof should verify")
+		}
+
+		linkHash11, _ := link11.Hash()
+		data, _ := linkHash11.MarshalJSON()
+		q := h.Query(abci.RequestQuery{Path: tmpop.GetSegment, Data: data})
+		assert.Empty(t, q.Proof, "Proof should not be available before the next blocks are signed")
+	})
+
+	t.Run("Caches inclusion proofs", func(t *testing.T) {
+		// The proof of linkHash9 was built by the previous query so
+		// Tendermint Core must not be called again.
+		noCallsCtrl := gomock.NewController(t)
+		defer noCallsCtrl.Finish()
+		h.ConnectTendermint(tmpoptestcasesmocks.NewMockTendermintClient(noCallsCtrl))
+		defer h.ConnectTendermint(tmClientMock)
+
+		data, _ := linkHash9.MarshalJSON()
+		q := h.Query(abci.RequestQuery{Path: tmpop.GetSegment, Data: data})
+		require.NotEmpty(t, q.Proof, "Proof is missing")
+	})
+
 	t.Run("Creates evidence events to notify store", func(t *testing.T) {
 		var events []*store.Event
 		err := makeQuery(h, tmpop.PendingEvents, nil, &events)
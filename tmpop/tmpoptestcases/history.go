@@ -0,0 +1,180 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmpoptestcases
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/cs/cstesting"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/tmpop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	abci "github.com/tendermint/abci/types"
+)
+
+func makeQueryAt(t *testing.T, h *tmpop.TMPop, name string, height int64, args interface{}, res interface{}) abci.ResponseQuery {
+	bytes, err := tmpop.BuildQueryBinary(args)
+	require.NoError(t, err)
+
+	q := h.Query(abci.RequestQuery{
+		Data:   bytes,
+		Path:   name,
+		Height: height,
+	})
+	require.EqualValues(t, abci.CodeTypeOK, q.Code, q.Log)
+	require.NoError(t, json.Unmarshal(q.Value, res))
+
+	return q
+}
+
+// TestHistoricalQuery tests queries on the state of the store at a past height.
+func (f Factory) TestHistoricalQuery(t *testing.T) {
+	h, req := f.newTMPop(t, nil)
+	defer f.free()
+
+	link1 := cstesting.RandomLink()
+	req = commitLink(t, h, link1, req)
+	appHash1 := req.Header.AppHash
+
+	link2 := cstesting.NewLinkBuilder().Branch(link1).Build()
+	linkHash2, _ := link2.Hash()
+	req = commitLink(t, h, link2, req)
+
+	link3 := cstesting.NewLinkBuilder().WithProcess(link1.Meta.Process).Build()
+	req = commitLink(t, h, link3, req)
+
+	evidence := &cs.Evidence{Backend: "generic", Provider: "external", Proof: &cs.GenericProof{}}
+	linkHash1, _ := link1.Hash()
+	commitTx(t, h, req, makeTx(t, &tmpop.Tx{TxType: tmpop.AddLinkEvidence, LinkHash: linkHash1, Evidence: evidence}))
+
+	t.Run("GetSegment() excludes later segments", func(t *testing.T) {
+		var got *cs.Segment
+		q := makeQueryAt(t, h, tmpop.GetSegment, 1, linkHash2, &got)
+		assert.Nil(t, got)
+		assert.Equal(t, int64(1), q.Height)
+		assert.Equal(t, hex.EncodeToString(appHash1), q.Info)
+
+		makeQueryAt(t, h, tmpop.GetSegment, 2, linkHash2, &got)
+		require.NotNil(t, got)
+		assert.EqualValues(t, link2, &got.Link)
+	})
+
+	t.Run("FindSegments() excludes later segments", func(t *testing.T) {
+		filter := &store.SegmentFilter{
+			Pagination: store.Pagination{Limit: store.DefaultLimit},
+			MapIDs:     []string{link1.Meta.MapID},
+		}
+
+		var got cs.SegmentSlice
+		makeQueryAt(t, h, tmpop.FindSegments, 1, filter, &got)
+		assert.Len(t, got, 1)
+
+		makeQueryAt(t, h, tmpop.FindSegments, 2, filter, &got)
+		assert.Len(t, got, 2)
+
+		filter.Offset = 1
+		makeQueryAt(t, h, tmpop.FindSegments, 1, filter, &got)
+		assert.Len(t, got, 0)
+	})
+
+	t.Run("GetMapIDs() excludes later maps", func(t *testing.T) {
+		filter := &store.MapFilter{
+			Pagination: store.Pagination{Limit: store.DefaultLimit},
+			Process:    link1.Meta.Process,
+		}
+
+		var got []string
+		makeQueryAt(t, h, tmpop.GetMapIDs, 2, filter, &got)
+		assert.Equal(t, []string{link1.Meta.MapID}, got)
+
+		makeQueryAt(t, h, tmpop.GetMapIDs, 3, filter, &got)
+		assert.Len(t, got, 2)
+	})
+
+	t.Run("Excludes evidence committed later", func(t *testing.T) {
+		linkHash1, _ := link1.Hash()
+
+		var got *cs.Segment
+		makeQueryAt(t, h, tmpop.GetSegment, 3, linkHash1, &got)
+		require.NotNil(t, got)
+		assert.Nil(t, got.Meta.GetEvidence("external"))
+
+		makeQueryAt(t, h, tmpop.GetSegment, 4, linkHash1, &got)
+		require.NotNil(t, got)
+		assert.NotNil(t, got.Meta.GetEvidence("external"))
+	})
+
+	t.Run("Searches links committed up to old heights", func(t *testing.T) {
+		// With four blocks, height 1 is answered from the links committed
+		// before it and height 3 by excluding the links committed after it.
+		filter := &store.SegmentFilter{
+			Pagination: store.Pagination{Limit: store.DefaultLimit},
+			Process:    link1.Meta.Process,
+		}
+
+		var got cs.SegmentSlice
+		makeQueryAt(t, h, tmpop.FindSegments, 1, filter, &got)
+		assert.Len(t, got, 1)
+
+		makeQueryAt(t, h, tmpop.FindSegments, 3, filter, &got)
+		assert.Len(t, got, 3)
+
+		mapFilter := &store.MapFilter{
+			Pagination: store.Pagination{Limit: store.DefaultLimit},
+			Process:    link1.Meta.Process,
+		}
+		var mapIDs []string
+		makeQueryAt(t, h, tmpop.GetMapIDs, 1, mapFilter, &mapIDs)
+		assert.Equal(t, []string{link1.Meta.MapID}, mapIDs)
+	})
+
+	t.Run("Indexes links committed before link heights", func(t *testing.T) {
+		ctx := context.Background()
+		key := []byte(fmt.Sprintf("tmpop:linkheight:%x", linkHash2[:]))
+		_, err := f.kv.DeleteValue(ctx, key)
+		require.NoError(t, err)
+		_, err = f.kv.DeleteValue(ctx, []byte("tmpop:linkheight:migrated"))
+		require.NoError(t, err)
+
+		var got *cs.Segment
+		makeQueryAt(t, h, tmpop.GetSegment, 2, linkHash2, &got)
+		assert.Nil(t, got, "queries must not index links")
+		value, err := f.kv.GetValue(ctx, key)
+		require.NoError(t, err)
+		assert.Nil(t, value, "queries must not index links")
+
+		h2, err := tmpop.New(ctx, f.adapter, f.kv, &tmpop.Config{})
+		require.NoError(t, err)
+
+		makeQueryAt(t, h2, tmpop.GetSegment, 2, linkHash2, &got)
+		require.NotNil(t, got)
+		assert.EqualValues(t, link2, &got.Link)
+	})
+
+	t.Run("Unsupported historical query", func(t *testing.T) {
+		q := h.Query(abci.RequestQuery{
+			Path:   tmpop.PendingEvents,
+			Height: 1,
+		})
+		assert.EqualValues(t, tmpop.CodeTypeNotImplemented, q.Code)
+	})
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmpoptestcases
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stratumn/go-indigocore/cs/cstesting"
+	"github.com/stratumn/go-indigocore/tmpop"
+	"github.com/stratumn/go-indigocore/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	abci "github.com/tendermint/abci/types"
+)
+
+// TestSetOption tests runtime options set with the ABCI method SetOption().
+func (f Factory) TestSetOption(t *testing.T) {
+	h, req := f.newTMPop(t, nil)
+	defer f.free()
+
+	setOption := func(key, value string) abci.ResponseSetOption {
+		return h.SetOption(abci.RequestSetOption{Key: key, Value: value})
+	}
+
+	t.Run("Rejects options affecting consensus", func(t *testing.T) {
+		res := setOption("pki", "{}")
+		assert.Equal(t, tmpop.CodeTypeValidation, res.Code)
+		assert.Contains(t, res.Log, tmpop.UpdateGovernance.String())
+	})
+
+	t.Run("Rejects unknown options", func(t *testing.T) {
+		res := setOption("unknown", "value")
+		assert.Equal(t, tmpop.CodeTypeNotImplemented, res.Code)
+	})
+
+	t.Run("Rejects invalid values", func(t *testing.T) {
+		assert.Equal(t, tmpop.CodeTypeValidation, setOption(tmpop.OptionLogLevel, "verbose").Code)
+		assert.Equal(t, tmpop.CodeTypeValidation, setOption(tmpop.OptionMetrics, "maybe").Code)
+		assert.Equal(t, tmpop.CodeTypeValidation, setOption(tmpop.OptionEvidencePaused, "maybe").Code)
+	})
+
+	t.Run("Sets the log level", func(t *testing.T) {
+		defer log.SetLevel(log.GetLevel())
+
+		res := setOption(tmpop.OptionLogLevel, "debug")
+		assert.Equal(t, abci.CodeTypeOK, res.Code, res.Log)
+		assert.Equal(t, log.DebugLevel, log.GetLevel())
+	})
+
+	t.Run("Toggles metrics", func(t *testing.T) {
+		assert.Equal(t, abci.CodeTypeOK, setOption(tmpop.OptionMetrics, "false").Code)
+		assert.Equal(t, abci.CodeTypeOK, setOption(tmpop.OptionMetrics, "true").Code)
+	})
+
+	t.Run("Rejects validation rules files", func(t *testing.T) {
+		link := cstesting.NewLinkBuilder().
+			WithProcess("testProcess").
+			WithType("notfound").
+			WithPrevLinkHash("").
+			Build()
+
+		testFilename := utils.CreateTempFile(t, testValidationConfig)
+		defer os.Remove(testFilename)
+
+		res := setOption("rules_path", testFilename)
+		assert.Equal(t, tmpop.CodeTypeValidation, res.Code)
+		assert.Contains(t, res.Log, tmpop.UpdateGovernance.String())
+
+		h.BeginBlock(req)
+		res2 := h.DeliverTx(makeCreateLinkTx(t, link))
+		assert.Equal(t, abci.CodeTypeOK, res2.Code, "Rules should not have changed")
+		commitResult := h.Commit()
+		req = makeBeginBlock(commitResult.Data, req.Header.Height+1)
+	})
+
+	t.Run("Pauses evidence generation", func(t *testing.T) {
+		res := setOption(tmpop.OptionEvidencePaused, "true")
+		require.Equal(t, abci.CodeTypeOK, res.Code, res.Log)
+
+		status := &tmpop.EvidenceStatus{}
+		err := makeQuery(h, tmpop.GetEvidenceStatus, nil, status)
+		assert.NoError(t, err)
+		assert.True(t, status.Paused, "status.Paused")
+	})
+
+	t.Run("Options survive restarts", func(t *testing.T) {
+		defer log.SetLevel(log.GetLevel())
+
+		h2, err := tmpop.New(context.Background(), f.adapter, f.kv, &tmpop.Config{})
+		require.NoError(t, err)
+
+		status := &tmpop.EvidenceStatus{}
+		err = makeQuery(h2, tmpop.GetEvidenceStatus, nil, status)
+		assert.NoError(t, err)
+		assert.True(t, status.Paused, "status.Paused")
+
+		res := h2.SetOption(abci.RequestSetOption{Key: tmpop.OptionEvidencePaused, Value: "false"})
+		require.Equal(t, abci.CodeTypeOK, res.Code, res.Log)
+
+		err = makeQuery(h2, tmpop.GetEvidenceStatus, nil, status)
+		assert.NoError(t, err)
+		assert.False(t, status.Paused, "status.Paused")
+	})
+}
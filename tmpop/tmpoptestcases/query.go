@@ -15,6 +15,8 @@
 package tmpoptestcases
 
 import (
+	"context"
+	"fmt"
 	"testing"
 
 	abci "github.com/tendermint/abci/types"
@@ -143,6 +145,31 @@ func (f Factory) TestQuery(t *testing.T) {
 		}
 	})
 
+	t.Run("GetLinkHeight()", func(t *testing.T) {
+		var height int64
+		err := makeQuery(h, tmpop.GetLinkHeight, linkHash2, &height)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), height)
+
+		err = makeQuery(h, tmpop.GetLinkHeight, invalidLinkHash, &height)
+		assert.NoError(t, err)
+		assert.Zero(t, height, "Invalid links are not included in blocks")
+	})
+
+	t.Run("GetEvents() returns the events of each block", func(t *testing.T) {
+		var blocks []*tmpop.BlockEvents
+		err := makeQuery(h, tmpop.GetEvents, &tmpop.EventsFilter{FromHeight: 1}, &blocks)
+		assert.NoError(t, err)
+		require.Len(t, blocks, 3, "Every committed block should be returned")
+
+		for i, block := range blocks {
+			assert.Equal(t, int64(i+1), block.Height)
+		}
+		assert.Len(t, blocks[0].Events, 1, "First block should have a saved links event")
+		assert.Len(t, blocks[1].Events, 0, "Invalid links should not produce events")
+		assert.Len(t, blocks[2].Events, 1, "Last block should have a saved links event")
+	})
+
 	t.Run("Pending events are delivered only once", func(t *testing.T) {
 		var events []*store.Event
 		err := makeQuery(h, tmpop.PendingEvents, nil, &events)
@@ -154,6 +181,20 @@ func (f Factory) TestQuery(t *testing.T) {
 		assert.Len(t, events, 0, "Events should not be delivered twice")
 	})
 
+	t.Run("GetEvents() can be read by several clients", func(t *testing.T) {
+		events := getEvents(t, h, 1)
+		assert.Len(t, events, 2, "We should have two saved links events (no evidence since Tendermint Core is not connected)")
+
+		events = getEvents(t, h, 1)
+		assert.Len(t, events, 2, "Events should be delivered to every client")
+
+		events = getEvents(t, h, 3)
+		assert.Len(t, events, 1, "Events of previous blocks should be skipped")
+
+		events = getEvents(t, h, 4)
+		assert.Len(t, events, 0, "Events of future blocks should be empty")
+	})
+
 	t.Run("Unsupported Query", func(t *testing.T) {
 		q := h.Query(abci.RequestQuery{
 			Path: "Unsupported",
@@ -167,3 +208,27 @@ func (f Factory) TestQuery(t *testing.T) {
 		assert.EqualValues(t, tmpop.CodeTypeInternalError, q.GetCode())
 	})
 }
+
+// TestEventsRetention tests that the events of old blocks are pruned.
+func (f Factory) TestEventsRetention(t *testing.T) {
+	h, req := f.newTMPop(t, &tmpop.Config{EventsRetention: 2})
+	defer f.free()
+
+	for i := 0; i < 4; i++ {
+		_, req = commitRandomLink(t, h, req)
+	}
+
+	var blocks []*tmpop.BlockEvents
+	err := makeQuery(h, tmpop.GetEvents, &tmpop.EventsFilter{FromHeight: 1}, &blocks)
+	assert.NoError(t, err)
+	require.Len(t, blocks, 2, "Only the retained blocks should be returned")
+	assert.Equal(t, int64(3), blocks[0].Height)
+	assert.Len(t, blocks[0].Events, 1)
+	assert.Equal(t, int64(4), blocks[1].Height)
+
+	for height := 1; height <= 2; height++ {
+		value, err := f.kv.GetValue(context.Background(), []byte(fmt.Sprintf("tmpop:events:%d", height)))
+		assert.NoError(t, err)
+		assert.Nil(t, value, "Events of block %d should be pruned", height)
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmpoptestcases
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/cs/cstesting"
+	"github.com/stratumn/go-indigocore/testutil"
+	"github.com/stratumn/go-indigocore/tmpop"
+	"github.com/stratumn/go-indigocore/utils"
+	validation "github.com/stratumn/go-indigocore/validator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	abci "github.com/tendermint/abci/types"
+	tmtypes "github.com/tendermint/tendermint/types"
+)
+
+// TestSnapshot tests that a snapshot can be created and restored on a new
+// node.
+func (f Factory) TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	h, req := f.newTMPop(t, nil)
+
+	link1, link2 := cstesting.RandomLink(), cstesting.RandomLink()
+	req = commitTxs(t, h, req, [][]byte{makeCreateLinkTx(t, link1), makeCreateLinkTx(t, link2)})
+	req = commitTxs(t, h, req, nil)
+	link3, req := commitRandomLink(t, h, req)
+
+	snapshot, err := h.Snapshot(ctx)
+	require.NoError(t, err)
+
+	trusted := &tmtypes.Header{
+		ChainID: req.Header.ChainID,
+		Height:  req.Header.Height,
+		AppHash: req.Header.AppHash,
+	}
+
+	t.Run("Snapshot contains committed blocks and segments", func(t *testing.T) {
+		assert.Equal(t, int64(3), snapshot.Height)
+		assert.EqualValues(t, req.Header.AppHash, snapshot.AppHash[:])
+		assert.Len(t, snapshot.Blocks, 3)
+		assert.Len(t, snapshot.Segments, 3)
+		assert.NoError(t, tmpop.VerifySnapshot(snapshot, trusted))
+	})
+
+	t.Run("Rejects untrusted header", func(t *testing.T) {
+		untrusted := *trusted
+		untrusted.AppHash = testutil.RandomHash()[:]
+		err := tmpop.VerifySnapshot(snapshot, &untrusted)
+		assert.EqualError(t, errors.Cause(err), tmpop.ErrUntrustedSnapshot.Error())
+
+		untrusted = *trusted
+		untrusted.Height = 3
+		err = tmpop.VerifySnapshot(snapshot, &untrusted)
+		assert.EqualError(t, errors.Cause(err), tmpop.ErrUntrustedSnapshot.Error())
+
+		err = tmpop.VerifySnapshot(snapshot, nil)
+		assert.EqualError(t, err, tmpop.ErrMissingTrustedHeader.Error())
+	})
+
+	t.Run("Rejects tampered snapshot", func(t *testing.T) {
+		tampered := copySnapshot(t, snapshot)
+		tampered.Segments[0].Link.State["tampered"] = true
+		err := tmpop.VerifySnapshot(tampered, trusted)
+		assert.EqualError(t, errors.Cause(err), tmpop.ErrInconsistentSnapshot.Error())
+
+		tampered = copySnapshot(t, snapshot)
+		tampered.Blocks[1].ValidatorHash = testutil.RandomHash()
+		err = tmpop.VerifySnapshot(tampered, trusted)
+		assert.EqualError(t, errors.Cause(err), tmpop.ErrInconsistentSnapshot.Error())
+
+		tampered = copySnapshot(t, snapshot)
+		tampered.Segments = tampered.Segments[1:]
+		err = tmpop.VerifySnapshot(tampered, trusted)
+		assert.EqualError(t, errors.Cause(err), tmpop.ErrInconsistentSnapshot.Error())
+	})
+
+	t.Run("Accepts evidence that can't be verified", func(t *testing.T) {
+		withoutProof := copySnapshot(t, snapshot)
+		withoutProof.Segments[0].Meta.Evidences = append(withoutProof.Segments[0].Meta.Evidences,
+			&cs.Evidence{Backend: "generic", Provider: "test"},
+		)
+		assert.NoError(t, tmpop.VerifySnapshot(withoutProof, trusted))
+
+		unregistered := copySnapshot(t, snapshot)
+		unregistered.Segments[0].Meta.Evidences = append(unregistered.Segments[0].Meta.Evidences,
+			&cs.Evidence{Backend: "unregistered", Provider: "test", Proof: &unregisteredProof{Data: "proof"}},
+		)
+		copied := copySnapshot(t, unregistered)
+		require.Len(t, copied.Segments[0].Meta.Evidences, 1)
+		assert.Equal(t, "unregistered", copied.Segments[0].Meta.Evidences[0].Backend)
+		assert.JSONEq(t, `{"data":"proof"}`, string(copied.Segments[0].Meta.Evidences[0].Proof.FullProof()))
+		assert.NoError(t, tmpop.VerifySnapshot(copied, trusted))
+	})
+
+	t.Run("Rejects restoring on a node with blocks", func(t *testing.T) {
+		err := tmpop.RestoreSnapshot(ctx, f.adapter, f.kv, snapshot, trusted, snapshot.Height)
+		assert.EqualError(t, err, tmpop.ErrAlreadyInitialized.Error())
+	})
+
+	f.free()
+
+	// Bootstrap a new node from the snapshot.
+	_, _ = f.newTMPop(t, nil)
+	defer f.free()
+
+	t.Run("Rejects restoring when Tendermint Core is at another height", func(t *testing.T) {
+		err := tmpop.RestoreSnapshot(ctx, f.adapter, f.kv, snapshot, trusted, 0)
+		assert.EqualError(t, errors.Cause(err), tmpop.ErrHeightMismatch.Error())
+
+		empty, err := tmpop.New(ctx, f.adapter, f.kv, &tmpop.Config{})
+		require.NoError(t, err)
+		info := empty.Info(abci.RequestInfo{})
+		assert.Equal(t, int64(0), info.LastBlockHeight, "Nothing should be restored")
+	})
+
+	err = tmpop.RestoreSnapshot(ctx, f.adapter, f.kv, snapshot, trusted, snapshot.Height)
+	require.NoError(t, err)
+
+	h2, err := tmpop.New(ctx, f.adapter, f.kv, &tmpop.Config{})
+	require.NoError(t, err)
+
+	t.Run("Restored node resumes from the snapshot height", func(t *testing.T) {
+		info := h2.Info(abci.RequestInfo{})
+		assert.Equal(t, int64(3), info.LastBlockHeight)
+		assert.EqualValues(t, req.Header.AppHash, info.LastBlockAppHash)
+
+		verifyLinkStored(t, h2, link1)
+		verifyLinkStored(t, h2, link2)
+		verifyLinkStored(t, h2, link3)
+
+		link4, _ := commitRandomLink(t, h2, req)
+		verifyLinkStored(t, h2, link4)
+	})
+}
+
+// TestSnapshotWithoutAppHashHistory tests that a node that didn't record the
+// app hashes of its first blocks can create a snapshot.
+func (f Factory) TestSnapshotWithoutAppHashHistory(t *testing.T) {
+	ctx := context.Background()
+	h, req := f.newTMPop(t, nil)
+
+	link1, req := commitRandomLink(t, h, req)
+	link2, req := commitRandomLink(t, h, req)
+	link3, req := commitRandomLink(t, h, req)
+
+	for _, height := range []string{"1", "2"} {
+		_, err := f.kv.DeleteValue(ctx, []byte("tmpop:apphash:"+height))
+		require.NoError(t, err)
+	}
+
+	snapshot, err := h.Snapshot(ctx)
+	require.NoError(t, err)
+	f.free()
+
+	trusted := &tmtypes.Header{
+		ChainID: req.Header.ChainID,
+		Height:  req.Header.Height,
+		AppHash: req.Header.AppHash,
+	}
+
+	t.Run("Snapshot starts from the last block's app hash", func(t *testing.T) {
+		require.Len(t, snapshot.Blocks, 3)
+		assert.Nil(t, snapshot.Blocks[0].AppHash)
+		assert.NotNil(t, snapshot.Blocks[1].AppHash)
+		assert.NoError(t, tmpop.VerifySnapshot(snapshot, trusted))
+	})
+
+	t.Run("Rejects tampered last block", func(t *testing.T) {
+		tampered := copySnapshot(t, snapshot)
+		tampered.Blocks[2].ValidatorHash = testutil.RandomHash()
+		err := tmpop.VerifySnapshot(tampered, trusted)
+		assert.EqualError(t, errors.Cause(err), tmpop.ErrInconsistentSnapshot.Error())
+
+		tampered = copySnapshot(t, snapshot)
+		tampered.Blocks[1].AppHash = nil
+		err = tmpop.VerifySnapshot(tampered, trusted)
+		assert.EqualError(t, errors.Cause(err), tmpop.ErrInconsistentSnapshot.Error())
+	})
+
+	_, _ = f.newTMPop(t, nil)
+	defer f.free()
+
+	err = tmpop.RestoreSnapshot(ctx, f.adapter, f.kv, snapshot, trusted, snapshot.Height)
+	require.NoError(t, err)
+
+	h2, err := tmpop.New(ctx, f.adapter, f.kv, &tmpop.Config{})
+	require.NoError(t, err)
+
+	verifyLinkStored(t, h2, link1)
+	verifyLinkStored(t, h2, link2)
+	verifyLinkStored(t, h2, link3)
+}
+
+// TestSnapshotGovernance tests that the validation rules loaded from a file
+// are restored from a snapshot.
+func (f Factory) TestSnapshotGovernance(t *testing.T) {
+	ctx := context.Background()
+	testFilename := utils.CreateTempFile(t, testValidationConfig)
+	defer os.Remove(testFilename)
+
+	h, req := f.newTMPop(t, &tmpop.Config{Validation: &validation.Config{RulesPath: testFilename}})
+	req = commitTxs(t, h, req, nil)
+
+	snapshot, err := h.Snapshot(ctx)
+	require.NoError(t, err)
+	f.free()
+
+	assert.NotEmpty(t, snapshot.Governance)
+
+	trusted := &tmtypes.Header{
+		ChainID: req.Header.ChainID,
+		Height:  req.Header.Height,
+		AppHash: req.Header.AppHash,
+	}
+
+	_, _ = f.newTMPop(t, nil)
+	defer f.free()
+
+	err = tmpop.RestoreSnapshot(ctx, f.adapter, f.kv, snapshot, trusted, snapshot.Height)
+	require.NoError(t, err)
+
+	h2, err := tmpop.New(ctx, f.adapter, f.kv, &tmpop.Config{})
+	require.NoError(t, err)
+
+	h2.BeginBlock(req)
+	l := cstesting.NewLinkBuilder().
+		WithProcess("testProcess").
+		WithType("notfound").
+		WithPrevLinkHash("").
+		Build()
+	res := h2.DeliverTx(makeCreateLinkTx(t, l))
+	assert.Equal(t, tmpop.CodeTypeValidation, res.Code, "Restored rules should be applied")
+}
+
+// TestSnapshotInterval tests that snapshots are written in the background
+// at the configured interval.
+func (f Factory) TestSnapshotInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tmpop-snapshots")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	h, req := f.newTMPop(t, &tmpop.Config{
+		Snapshot: &tmpop.SnapshotConfig{Interval: 2, Directory: dir},
+	})
+	defer f.free()
+
+	_, req = commitRandomLink(t, h, req)
+	_, req = commitRandomLink(t, h, req)
+
+	path := filepath.Join(dir, "snapshot-2.json")
+	var snapshot *tmpop.Snapshot
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		if snapshot, err = tmpop.ReadSnapshot(path); err == nil {
+			break
+		}
+	}
+	require.NoError(t, err, "Snapshot was not written")
+
+	trusted := &tmtypes.Header{
+		ChainID: req.Header.ChainID,
+		Height:  req.Header.Height,
+		AppHash: req.Header.AppHash,
+	}
+	assert.Equal(t, int64(2), snapshot.Height)
+	assert.NoError(t, tmpop.VerifySnapshot(snapshot, trusted))
+}
+
+func copySnapshot(t *testing.T, snapshot *tmpop.Snapshot) *tmpop.Snapshot {
+	data, err := json.Marshal(snapshot)
+	require.NoError(t, err)
+
+	var copied tmpop.Snapshot
+	require.NoError(t, json.Unmarshal(data, &copied))
+
+	return &copied
+}
+
+// unregisteredProof is a proof whose backend isn't registered in
+// cs.DeserializeMethods.
+type unregisteredProof struct {
+	Data string `json:"data"`
+}
+
+func (p *unregisteredProof) Time() uint64 {
+	return 0
+}
+
+func (p *unregisteredProof) FullProof() []byte {
+	data, _ := json.Marshal(p)
+	return data
+}
+
+func (p *unregisteredProof) Verify(interface{}) bool {
+	return false
+}
@@ -19,11 +19,13 @@ import (
 	"encoding/json"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stratumn/go-indigocore/cs"
 	"github.com/stratumn/go-indigocore/cs/cstesting"
 	"github.com/stratumn/go-indigocore/store"
 	"github.com/stratumn/go-indigocore/tmpop"
+	"github.com/stretchr/testify/require"
 
 	abci "github.com/tendermint/abci/types"
 )
@@ -50,10 +52,22 @@ func (f Factory) RunTests(t *testing.T) {
 	t.Run("TestLastBlock", f.TestLastBlock)
 	t.Run("TestTendermintEvidence", f.TestTendermintEvidence)
 	t.Run("TestQuery", f.TestQuery)
+	t.Run("TestEventsRetention", f.TestEventsRetention)
+	t.Run("TestEvidenceBackfill", f.TestEvidenceBackfill)
+	t.Run("TestSnapshot", f.TestSnapshot)
+	t.Run("TestSnapshotWithoutAppHashHistory", f.TestSnapshotWithoutAppHashHistory)
+	t.Run("TestSnapshotGovernance", f.TestSnapshotGovernance)
+	t.Run("TestSnapshotInterval", f.TestSnapshotInterval)
+	t.Run("TestHistoricalQuery", f.TestHistoricalQuery)
 	t.Run("TestCheckTx", f.TestCheckTx)
 	t.Run("TestDeliverTx", f.TestDeliverTx)
 	t.Run("TestCommitTx", f.TestCommitTx)
+	t.Run("TestCreateLinksTx", f.TestCreateLinksTx)
+	t.Run("TestAddLinkEvidenceTx", f.TestAddLinkEvidenceTx)
+	t.Run("TestStateReset", f.TestStateReset)
+	t.Run("TestUpdateGovernanceTx", f.TestUpdateGovernanceTx)
 	t.Run("TestValidation", f.TestValidation)
+	t.Run("TestSetOption", f.TestSetOption)
 }
 
 func (f Factory) free() {
@@ -100,6 +114,21 @@ func makeQuery(h *tmpop.TMPop, name string, args interface{}, res interface{}) e
 	return json.Unmarshal(q.Value, &res)
 }
 
+// getEvents returns the events of the committed blocks starting at the given
+// height.
+func getEvents(t *testing.T, h *tmpop.TMPop, fromHeight int64) []*store.Event {
+	var blocks []*tmpop.BlockEvents
+	err := makeQuery(h, tmpop.GetEvents, &tmpop.EventsFilter{FromHeight: fromHeight}, &blocks)
+	require.NoError(t, err)
+
+	var events []*store.Event
+	for _, block := range blocks {
+		events = append(events, block.Events...)
+	}
+
+	return events
+}
+
 func makeCreateRandomLinkTx(t *testing.T) (*cs.Link, []byte) {
 	l := cstesting.RandomLink()
 	return l, makeCreateLinkTx(t, l)
@@ -146,6 +175,7 @@ func commitTx(t *testing.T, h *tmpop.TMPop, requestBeginBlock abci.RequestBeginB
 
 func commitTxs(t *testing.T, h *tmpop.TMPop, requestBeginBlock abci.RequestBeginBlock, txs [][]byte) abci.RequestBeginBlock {
 	h.BeginBlock(requestBeginBlock)
+	waitEvidence(t, h)
 
 	for _, tx := range txs {
 		h.DeliverTx(tx)
@@ -159,6 +189,22 @@ func commitTxs(t *testing.T, h *tmpop.TMPop, requestBeginBlock abci.RequestBegin
 	return makeBeginBlock(commitResult.Data, requestBeginBlock.Header.Height+1)
 }
 
+// waitEvidence waits until the evidence worker has processed the blocks it
+// was woken up for.
+func waitEvidence(t *testing.T, h *tmpop.TMPop) {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		status := &tmpop.EvidenceStatus{}
+		if err := makeQuery(h, tmpop.GetEvidenceStatus, nil, status); err != nil {
+			t.Fatalf("makeQuery(GetEvidenceStatus): err: %s", err)
+		}
+		if !status.Running {
+			return
+		}
+	}
+
+	t.Fatal("evidence worker did not finish in time")
+}
+
 func verifyLinkStored(t *testing.T, h *tmpop.TMPop, link *cs.Link) {
 	linkHash, _ := link.Hash()
 
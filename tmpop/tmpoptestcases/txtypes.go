@@ -0,0 +1,264 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmpoptestcases
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/cs/cstesting"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/store/storetesting"
+	"github.com/stratumn/go-indigocore/tmpop"
+	"github.com/stratumn/go-indigocore/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testGovernancePKI = `{
+	"alice.vandenbudenmayer@stratumn.com": {
+		"keys": ["-----BEGIN ED25519 PUBLIC KEY-----\nMCowBQYDK2VwAyEAO0U2B1DjM7k+AWLUBl9oK+ZhX/gpwrx5Z7RxCUgccDo=\n-----END ED25519 PUBLIC KEY-----\n"],
+		"roles": ["employee"]
+	}
+}`
+
+const testGovernanceTypes = `{
+	"init": {
+		"schema": {
+			"type": "object",
+			"properties": {
+				"string": {
+					"type": "string"
+				}
+			}
+		},
+		"transitions": [""]
+	}
+}`
+
+func makeTx(t *testing.T, tx *tmpop.Tx) []byte {
+	res, err := json.Marshal(tx)
+	require.NoError(t, err)
+	return res
+}
+
+// TestCreateLinksTx tests transactions creating several links at once.
+func (f Factory) TestCreateLinksTx(t *testing.T) {
+	h, req := f.newTMPop(t, nil)
+	defer f.free()
+
+	t.Run("Creates all the links of a valid batch", func(t *testing.T) {
+		link1 := cstesting.RandomLink()
+		linkHash1, _ := link1.Hash()
+		link2 := cstesting.NewLinkBuilder().WithProcess(link1.Meta.Process).Build()
+		link2.Meta.Refs = []cs.SegmentReference{cs.SegmentReference{
+			Process:  link1.Meta.Process,
+			LinkHash: linkHash1.String(),
+		}}
+
+		tx := makeTx(t, &tmpop.Tx{TxType: tmpop.CreateLinks, Links: []*cs.Link{link1, link2}})
+		assert.True(t, h.CheckTx(tx).IsOK(), "Batch referencing its own links should be valid")
+
+		req = commitTx(t, h, req, tx)
+		verifyLinkStored(t, h, link1)
+		verifyLinkStored(t, h, link2)
+	})
+
+	t.Run("Rejects the whole batch if a link is invalid", func(t *testing.T) {
+		link1 := cstesting.RandomLink()
+		link2 := cstesting.RandomLink()
+		link2.Meta.Refs = []cs.SegmentReference{cs.SegmentReference{
+			Process:  link2.Meta.Process,
+			LinkHash: "invalidLinkHash",
+		}}
+		tx := makeTx(t, &tmpop.Tx{TxType: tmpop.CreateLinks, Links: []*cs.Link{link1, link2, link2}})
+
+		h.BeginBlock(req)
+		res := h.DeliverTx(tx)
+		assert.EqualValues(t, tmpop.CodeTypeValidation, res.Code)
+		rejected, ok := tmpop.RejectedLinks(res.Data)
+		require.True(t, ok, "tmpop.RejectedLinks()")
+		require.Len(t, rejected, 2, "All invalid links should be rejected")
+		assert.Equal(t, 1, rejected[0].Index, "tmpop.RejectedLinks()")
+		assert.Equal(t, 2, rejected[1].Index, "tmpop.RejectedLinks()")
+		assert.NotEmpty(t, rejected[0].Error, "tmpop.RejectedLinks()")
+		commitResult := h.Commit()
+		req = makeBeginBlock(commitResult.Data, req.Header.Height+1)
+
+		linkHash1, _ := link1.Hash()
+		got := &cs.Segment{}
+		err := makeQuery(h, tmpop.GetSegment, linkHash1, got)
+		assert.NoError(t, err)
+		assert.Zero(t, got.Link, "Link should not be created")
+	})
+
+	t.Run("Rejects empty batches", func(t *testing.T) {
+		tx := makeTx(t, &tmpop.Tx{TxType: tmpop.CreateLinks})
+		assert.EqualValues(t, tmpop.CodeTypeValidation, h.CheckTx(tx).Code)
+	})
+}
+
+// TestAddLinkEvidenceTx tests transactions adding external evidence.
+func (f Factory) TestAddLinkEvidenceTx(t *testing.T) {
+	h, req := f.newTMPop(t, nil)
+	defer f.free()
+
+	link, req := commitRandomLink(t, h, req)
+	linkHash, _ := link.Hash()
+
+	evidence := &cs.Evidence{Backend: "generic", Provider: "external", Proof: &cs.GenericProof{}}
+	tx := makeTx(t, &tmpop.Tx{TxType: tmpop.AddLinkEvidence, LinkHash: linkHash, Evidence: evidence})
+
+	t.Run("Adds evidence when the block is committed", func(t *testing.T) {
+		assert.True(t, h.CheckTx(tx).IsOK(), "Evidence should be valid")
+
+		emptyBlockAppHash, _ := tmpop.ComputeAppHash(types.NewBytes32FromBytes(req.Header.AppHash), nil, nil)
+		req = commitTx(t, h, req, tx)
+		assert.NotEqual(t, emptyBlockAppHash[:], req.Header.AppHash, "Evidence should be reflected in the app hash")
+
+		got := &cs.Segment{}
+		err := makeQuery(h, tmpop.GetSegment, linkHash, got)
+		assert.NoError(t, err)
+		require.NotNil(t, got.Meta.GetEvidence("external"), "Evidence is missing")
+
+		var events []*store.Event
+		err = makeQuery(h, tmpop.PendingEvents, nil, &events)
+		assert.NoError(t, err)
+		var evidenceEvents int
+		for _, event := range events {
+			if event.EventType == store.SavedEvidences {
+				evidenceEvents++
+			}
+		}
+		assert.Equal(t, 1, evidenceEvents, "Evidence event is missing")
+	})
+
+	t.Run("Rejects duplicate evidence", func(t *testing.T) {
+		assert.EqualValues(t, tmpop.CodeTypeValidation, h.CheckTx(tx).Code)
+	})
+
+	t.Run("Rejects evidence of unknown links", func(t *testing.T) {
+		unknownLinkHash, _ := cstesting.RandomLink().Hash()
+		tx := makeTx(t, &tmpop.Tx{TxType: tmpop.AddLinkEvidence, LinkHash: unknownLinkHash, Evidence: evidence})
+		assert.EqualValues(t, tmpop.CodeTypeValidation, h.CheckTx(tx).Code)
+	})
+
+	t.Run("Rejects TMPoP evidence", func(t *testing.T) {
+		tmpopEvidence := &cs.Evidence{Backend: tmpop.Name, Provider: "other", Proof: &cs.GenericProof{}}
+		tx := makeTx(t, &tmpop.Tx{TxType: tmpop.AddLinkEvidence, LinkHash: linkHash, Evidence: tmpopEvidence})
+		assert.EqualValues(t, tmpop.CodeTypeValidation, h.CheckTx(tx).Code)
+	})
+
+	t.Run("Rejects evidence without proof", func(t *testing.T) {
+		noProof := &cs.Evidence{Backend: "generic", Provider: "other"}
+		tx := makeTx(t, &tmpop.Tx{TxType: tmpop.AddLinkEvidence, LinkHash: linkHash, Evidence: noProof})
+		assert.EqualValues(t, tmpop.CodeTypeValidation, h.CheckTx(tx).Code)
+	})
+
+	t.Run("Ignores evidence added outside of transactions", func(t *testing.T) {
+		local := &cs.Evidence{Backend: "generic", Provider: "local", Proof: &cs.GenericProof{}}
+		require.NoError(t, f.adapter.AddEvidence(context.Background(), linkHash, local))
+
+		tx := makeTx(t, &tmpop.Tx{TxType: tmpop.AddLinkEvidence, LinkHash: linkHash, Evidence: local})
+		assert.True(t, h.CheckTx(tx).IsOK(), "Evidence should be valid")
+		req = commitTx(t, h, req, tx)
+		assert.EqualValues(t, tmpop.CodeTypeValidation, h.CheckTx(tx).Code)
+	})
+
+	t.Run("Accepts evidence of unregistered backends", func(t *testing.T) {
+		unregistered := &cs.Evidence{Backend: "unregistered", Provider: "unregistered", Proof: &unregisteredProof{Data: "proof"}}
+		tx := makeTx(t, &tmpop.Tx{TxType: tmpop.AddLinkEvidence, LinkHash: linkHash, Evidence: unregistered})
+		assert.True(t, h.CheckTx(tx).IsOK(), "Evidence should be valid")
+
+		previousAppHash := req.Header.AppHash
+		req = commitTx(t, h, req, tx)
+		assert.NotEqual(t, previousAppHash, req.Header.AppHash, "Evidence should be reflected in the app hash")
+		assert.EqualValues(t, tmpop.CodeTypeValidation, h.CheckTx(tx).Code)
+
+		got := &cs.Segment{}
+		require.NoError(t, makeQuery(h, tmpop.GetSegment, linkHash, got))
+		assert.Nil(t, got.Meta.GetEvidence("unregistered"), "Evidence that can't be decoded should not be saved")
+	})
+}
+
+// TestStateReset tests that the delivered transactions are discarded when a
+// commit fails.
+func (f Factory) TestStateReset(t *testing.T) {
+	ctx := context.Background()
+	_, _ = f.newTMPop(t, nil)
+	defer f.free()
+
+	a := &storetesting.MockAdapter{}
+	failing := &storetesting.MockBatch{}
+	failing.MockWrite.Fn = func() error { return errors.New("write failed") }
+	a.MockNewBatch.Fn = func() store.Batch { return failing }
+
+	state, err := tmpop.NewState(ctx, a, f.kv, &tmpop.Config{})
+	require.NoError(t, err)
+	state.BeginBlock(ctx, 1, &types.Bytes32{})
+
+	link := cstesting.NewLinkBuilder().WithPrevLinkHash("").Build()
+	require.True(t, state.Deliver(ctx, &tmpop.Tx{TxType: tmpop.CreateLink, Link: link}).IsOK())
+
+	a.MockNewBatch.Fn = nil
+	_, _, _, err = state.Commit(ctx)
+	assert.EqualError(t, err, "write failed")
+
+	_, links, linkEvidences, err := state.Pending()
+	require.NoError(t, err)
+	assert.Empty(t, links, "Delivered links should be discarded")
+	assert.Empty(t, linkEvidences, "Delivered evidence should be discarded")
+	assert.Equal(t, 2, a.MockNewBatch.CalledCount, "A new batch should be created")
+}
+
+// TestUpdateGovernanceTx tests transactions updating validation rules.
+func (f Factory) TestUpdateGovernanceTx(t *testing.T) {
+	h, req := f.newTMPop(t, nil)
+	defer f.free()
+
+	badLink := cstesting.NewLinkBuilder().
+		WithProcess("testProcess").
+		WithType("init").
+		WithPrevLinkHash("").
+		WithState(map[string]interface{}{"string": 42}).
+		Build()
+
+	t.Run("Rejects invalid rules", func(t *testing.T) {
+		tx := makeTx(t, &tmpop.Tx{TxType: tmpop.UpdateGovernance, Governance: &tmpop.GovernanceUpdate{
+			Process: "testProcess",
+			PKI:     json.RawMessage(`"invalid"`),
+			Types:   json.RawMessage(testGovernanceTypes),
+		}})
+		assert.EqualValues(t, tmpop.CodeTypeValidation, h.CheckTx(tx).Code)
+	})
+
+	t.Run("Applies rules from the next block", func(t *testing.T) {
+		assert.True(t, h.CheckTx(makeCreateLinkTx(t, badLink)).IsOK(), "Link should be valid without rules")
+
+		tx := makeTx(t, &tmpop.Tx{TxType: tmpop.UpdateGovernance, Governance: &tmpop.GovernanceUpdate{
+			Process: "testProcess",
+			PKI:     json.RawMessage(testGovernancePKI),
+			Types:   json.RawMessage(testGovernanceTypes),
+		}})
+		req = commitTx(t, h, req, tx)
+
+		h.BeginBlock(req)
+		res := h.DeliverTx(makeCreateLinkTx(t, badLink))
+		assert.EqualValues(t, tmpop.CodeTypeValidation, res.Code, "Link should be rejected by the new rules")
+	})
+}
@@ -15,7 +15,9 @@
 package tmpop
 
 import (
+	"crypto/sha256"
 	"encoding/json"
+	"fmt"
 
 	"github.com/stratumn/go-indigocore/cs"
 	"github.com/stratumn/go-indigocore/types"
@@ -27,20 +29,224 @@ type TxType byte
 const (
 	// CreateLink characterizes a transaction that creates a new link
 	CreateLink TxType = iota
+
+	// AddLinkEvidence characterizes a transaction that adds external
+	// evidence (for instance a blockchain anchor) to an existing link
+	AddLinkEvidence
+
+	// UpdateGovernance characterizes a transaction that updates the
+	// validation rules of a process
+	UpdateGovernance
+
+	// CreateLinks characterizes a transaction that creates several links
+	// at once: either all of them are valid and created, or none is
+	CreateLinks
 )
 
+// String returns the name of a transaction type.
+func (t TxType) String() string {
+	switch t {
+	case CreateLink:
+		return "CreateLink"
+	case AddLinkEvidence:
+		return "AddLinkEvidence"
+	case UpdateGovernance:
+		return "UpdateGovernance"
+	case CreateLinks:
+		return "CreateLinks"
+	default:
+		return fmt.Sprintf("TxType(%d)", byte(t))
+	}
+}
+
 // Tx represents a TMPoP transaction
 type Tx struct {
-	TxType   TxType         `json:"type"`
-	Link     *cs.Link       `json:"link"`
-	LinkHash *types.Bytes32 `json:"linkhash"`
+	TxType     TxType            `json:"type"`
+	Link       *cs.Link          `json:"link"`
+	LinkHash   *types.Bytes32    `json:"linkhash"`
+	Links      []*cs.Link        `json:"links,omitempty"`
+	Evidence   *cs.Evidence      `json:"evidence,omitempty"`
+	Governance *GovernanceUpdate `json:"governance,omitempty"`
+}
+
+// UnmarshalJSON decodes a transaction. The proof of an evidence is kept
+// encoded: evidence transactions must be validated and hashed the same way
+// by every node, whatever proof types are registered on it.
+func (tx *Tx) UnmarshalJSON(data []byte) error {
+	type txAlias Tx
+	decoded := struct {
+		*txAlias
+		Evidence json.RawMessage `json:"evidence,omitempty"`
+	}{txAlias: (*txAlias)(tx)}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	var err error
+	tx.Evidence, err = unmarshalEncodedEvidence(decoded.Evidence)
+	return err
+}
+
+// unmarshalEncodedEvidence decodes an evidence without decoding its proof.
+func unmarshalEncodedEvidence(data json.RawMessage) (*cs.Evidence, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+
+	encoded := struct {
+		Backend  string          `json:"backend"`
+		Provider string          `json:"provider"`
+		Proof    json.RawMessage `json:"proof"`
+	}{}
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, err
+	}
+
+	evidence := &cs.Evidence{Backend: encoded.Backend, Provider: encoded.Provider}
+	if len(encoded.Proof) > 0 && string(encoded.Proof) != "null" {
+		evidence.Proof = encodedProof(encoded.Proof)
+	}
+
+	return evidence, nil
+}
+
+// encodedProof is a proof kept in its JSON encoding. It can't be verified.
+type encodedProof json.RawMessage
+
+// Time implements github.com/stratumn/go-indigocore/cs.Proof.Time.
+func (p encodedProof) Time() uint64 {
+	return 0
+}
+
+// FullProof implements github.com/stratumn/go-indigocore/cs.Proof.FullProof.
+func (p encodedProof) FullProof() []byte {
+	return p
+}
+
+// Verify implements github.com/stratumn/go-indigocore/cs.Proof.Verify.
+func (p encodedProof) Verify(interface{}) bool {
+	return false
+}
+
+// MarshalJSON returns the encoded proof.
+func (p encodedProof) MarshalJSON() ([]byte, error) {
+	if len(p) == 0 {
+		return []byte("null"), nil
+	}
+	return p, nil
+}
+
+// decodeProof decodes an encoded proof with the proof type registered for
+// the evidence's backend. The evidence is returned as is if the backend
+// isn't registered or the proof can't be decoded.
+func decodeProof(e *cs.Evidence) *cs.Evidence {
+	p, ok := e.Proof.(encodedProof)
+	if !ok {
+		return e
+	}
+
+	deserialize, ok := cs.DeserializeMethods[e.Backend]
+	if !ok {
+		return e
+	}
+
+	proof, err := deserialize(json.RawMessage(p))
+	if err != nil {
+		return e
+	}
+
+	return &cs.Evidence{Backend: e.Backend, Provider: e.Provider, Proof: proof}
+}
+
+// GovernanceUpdate contains the new validation rules of a process.
+// The governance link created from it is validated like any other link,
+// so the signatures it needs depend on the rules of the governance process.
+type GovernanceUpdate struct {
+	Process    string          `json:"process"`
+	PKI        json.RawMessage `json:"pki"`
+	Types      json.RawMessage `json:"types"`
+	Signatures cs.Signatures   `json:"signatures,omitempty"`
+}
+
+// LinkEvidence is an external evidence added to a link by a transaction.
+type LinkEvidence struct {
+	LinkHash *types.Bytes32
+	Evidence *cs.Evidence
+}
+
+// UnmarshalJSON decodes a link evidence without decoding its proof, so that
+// its hash doesn't depend on the proof types registered on the node.
+func (e *LinkEvidence) UnmarshalJSON(data []byte) error {
+	decoded := struct {
+		LinkHash *types.Bytes32
+		Evidence json.RawMessage
+	}{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	evidence, err := unmarshalEncodedEvidence(decoded.Evidence)
+	if err != nil {
+		return err
+	}
+
+	*e = LinkEvidence{LinkHash: decoded.LinkHash, Evidence: evidence}
+	return nil
+}
+
+// Hash returns the hash of the evidence that is included in the block's
+// merkle tree, so that evidence transactions are reflected in the app hash.
+func (e *LinkEvidence) Hash() (*types.Bytes32, error) {
+	evidenceBytes, err := json.Marshal(e.Evidence)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	if _, err := hash.Write(e.LinkHash[:]); err != nil {
+		return nil, err
+	}
+	if _, err := hash.Write(evidenceBytes); err != nil {
+		return nil, err
+	}
+
+	return types.NewBytes32FromBytes(hash.Sum(nil)), nil
+}
+
+// RejectedLink describes a link that caused a CreateLinks transaction to be
+// rejected.
+type RejectedLink struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// rejectedLinksError builds the error of a CreateLinks transaction rejected
+// because of some of its links. The rejected links are returned in the Data
+// field of the response so that clients don't have to parse the log.
+func rejectedLinksError(code uint32, rejected []RejectedLink) *ABCIError {
+	data, _ := json.Marshal(rejected)
+	return &ABCIError{
+		Code: code,
+		Log:  fmt.Sprintf("%d links rejected, first is link %d: %s", len(rejected), rejected[0].Index, rejected[0].Error),
+		Data: data,
+	}
+}
+
+// RejectedLinks returns the links that caused a CreateLinks transaction to
+// be rejected, given the data of the transaction's response.
+func RejectedLinks(data []byte) ([]RejectedLink, bool) {
+	var rejected []RejectedLink
+	if err := json.Unmarshal(data, &rejected); err != nil || len(rejected) == 0 {
+		return nil, false
+	}
+	return rejected, true
 }
 
 func unmarshallTx(txBytes []byte) (*Tx, *ABCIError) {
 	tx := &Tx{}
 
 	if err := json.Unmarshal(txBytes, tx); err != nil {
-		return nil, &ABCIError{CodeTypeValidation, err.Error()}
+		return nil, &ABCIError{Code: CodeTypeValidation, Log: err.Error()}
 	}
 
 	return tx, nil
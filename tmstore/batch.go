@@ -0,0 +1,226 @@
+// Copyright 2017 Stratumn SAS. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/stratumn/go-indigocore/bufferedbatch"
+	"github.com/stratumn/go-indigocore/cs"
+	"github.com/stratumn/go-indigocore/monitoring"
+	"github.com/stratumn/go-indigocore/store"
+	"github.com/stratumn/go-indigocore/tmpop"
+	"github.com/stratumn/go-indigocore/types"
+
+	abci "github.com/tendermint/abci/types"
+
+	"go.opencensus.io/trace"
+)
+
+// MaxLinksPerTx is the maximum number of links sent in a single TMPoP
+// transaction by CreateLinks. Larger batches are split.
+const MaxLinksPerTx = 500
+
+// CreateLinks implements github.com/stratumn/go-indigocore/store.LinksWriter.CreateLinks.
+// Links are sent in TMPoP CreateLinks transactions. When TMPoP rejects a
+// transaction because of some of its links, they are reported as rejected
+// and the transaction is sent again without them.
+// In async mode links aren't validated so they are all reported as pending.
+func (t *TMStore) CreateLinks(ctx context.Context, links []*cs.Link, mode store.WriteMode) (_ []*store.LinkResult, err error) {
+	ctx, span := trace.StartSpan(ctx, "tmstore/CreateLinks")
+	span.AddAttributes(
+		trace.Int64Attribute("LinksCount", int64(len(links))),
+		trace.StringAttribute("Mode", string(mode)),
+	)
+	defer monitoring.SetSpanStatusAndEnd(span, err)
+
+	results := make([]*store.LinkResult, len(links))
+	var indexes []int
+	for i, link := range links {
+		linkHash, err := link.Hash()
+		if err != nil {
+			results[i] = &store.LinkResult{Status: store.LinkRejected, Error: err.Error()}
+			continue
+		}
+
+		results[i] = &store.LinkResult{LinkHash: linkHash, Status: store.LinkPending}
+		indexes = append(indexes, i)
+	}
+
+	for start := 0; start < len(indexes); start += MaxLinksPerTx {
+		end := start + MaxLinksPerTx
+		if end > len(indexes) {
+			end = len(indexes)
+		}
+
+		chunk := make([]int, end-start)
+		copy(chunk, indexes[start:end])
+
+		for len(chunk) > 0 {
+			chunkLinks := make([]*cs.Link, len(chunk))
+			for j, i := range chunk {
+				chunkLinks[j] = links[i]
+			}
+
+			height, res, err := t.broadcastLinks(ctx, chunkLinks, mode)
+			if err != nil {
+				return nil, err
+			}
+
+			if res.IsOK() {
+				for _, i := range chunk {
+					if mode == store.WriteModeCommit {
+						results[i].Status = store.LinkCommitted
+						results[i].Height = height
+					}
+				}
+				break
+			}
+
+			isRejected := make(map[int]bool)
+			if rejected, ok := tmpop.RejectedLinks(res.Data); ok && res.Code == tmpop.CodeTypeValidation {
+				for _, r := range rejected {
+					if r.Index >= 0 && r.Index < len(chunk) {
+						isRejected[r.Index] = true
+						results[chunk[r.Index]].Status = store.LinkRejected
+						results[chunk[r.Index]].Error = r.Error
+					}
+				}
+			}
+
+			if len(isRejected) == 0 {
+				// The transaction wasn't rejected because of specific
+				// links so all of them are rejected.
+				for _, i := range chunk {
+					results[i].Status = store.LinkRejected
+					results[i].Error = res.Log
+				}
+				break
+			}
+
+			var remaining []int
+			for j, i := range chunk {
+				if !isRejected[j] {
+					remaining = append(remaining, i)
+				}
+			}
+			chunk = remaining
+		}
+	}
+
+	return results, nil
+}
+
+// broadcastLinks sends a CreateLinks transaction using the given write mode.
+// It returns the height of the block including the transaction in commit
+// mode, and the first error returned by TMPoP when checking or delivering
+// the transaction.
+func (t *TMStore) broadcastLinks(ctx context.Context, links []*cs.Link, mode store.WriteMode) (_ int64, _ *tmpop.ABCIError, err error) {
+	ctx, span := trace.StartSpan(ctx, "tmstore/broadcastLinks")
+	defer monitoring.SetSpanStatusAndEnd(span, err)
+
+	txBytes, err := json.Marshal(&tmpop.Tx{TxType: tmpop.CreateLinks, Links: links})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	switch mode {
+	case store.WriteModeAsync:
+		if _, err := t.tmClient.BroadcastTxAsync(txBytes); err != nil {
+			return 0, nil, err
+		}
+		return 0, nil, nil
+
+	case store.WriteModeSync:
+		result, err := t.tmClient.BroadcastTxSync(txBytes)
+		if err != nil {
+			return 0, nil, err
+		}
+		if result.Code != abci.CodeTypeOK {
+			return 0, &tmpop.ABCIError{Code: result.Code, Log: result.Log, Data: result.Data}, nil
+		}
+		return 0, nil, nil
+
+	default:
+		result, err := t.tmClient.BroadcastTxCommit(txBytes)
+		if err != nil {
+			return 0, nil, err
+		}
+		if result.CheckTx.IsErr() {
+			return 0, &tmpop.ABCIError{Code: result.CheckTx.Code, Log: result.CheckTx.Log, Data: result.CheckTx.Data}, nil
+		}
+		if result.DeliverTx.IsErr() {
+			return 0, &tmpop.ABCIError{Code: result.DeliverTx.Code, Log: result.DeliverTx.Log, Data: result.DeliverTx.Data}, nil
+		}
+		return result.Height, nil, nil
+	}
+}
+
+// Batch is the type that implements github.com/stratumn/go-indigocore/store.Batch.
+type Batch struct {
+	*bufferedbatch.Batch
+
+	originalTMStore *TMStore
+}
+
+// NewBatch creates a new Batch.
+func NewBatch(ctx context.Context, t *TMStore) *Batch {
+	return &Batch{
+		Batch:           bufferedbatch.NewBatch(ctx, t),
+		originalTMStore: t,
+	}
+}
+
+// Write implements github.com/stratumn/go-indigocore/store.Batch.Write.
+// The links of the batch are sent in a single TMPoP transaction, which
+// TMPoP commits or rejects as a whole.
+func (b *Batch) Write(ctx context.Context) (err error) {
+	ctx, span := trace.StartSpan(ctx, "tmstore/batch/Write")
+	defer monitoring.SetSpanStatusAndEnd(span, err)
+
+	if len(b.Links) == 0 {
+		return nil
+	}
+	if len(b.Links) > MaxLinksPerTx {
+		return fmt.Errorf("a batch can't contain more than %d links", MaxLinksPerTx)
+	}
+
+	_, abciErr, err := b.originalTMStore.broadcastLinks(ctx, b.Links, store.WriteModeCommit)
+	if err != nil {
+		return err
+	}
+	if abciErr != nil {
+		return abciErr
+	}
+
+	return nil
+}
+
+// GetLinkHeight implements github.com/stratumn/go-indigocore/store.LinkHeightReader.GetLinkHeight.
+func (t *TMStore) GetLinkHeight(ctx context.Context, linkHash *types.Bytes32) (int64, error) {
+	response, err := t.sendQuery(ctx, tmpop.GetLinkHeight, linkHash)
+	if err != nil {
+		return 0, err
+	}
+
+	var height int64
+	if err := json.Unmarshal(response.Value, &height); err != nil {
+		return 0, err
+	}
+
+	return height, nil
+}
@@ -19,14 +19,17 @@ package tmstore
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/stratumn/go-indigocore/bufferedbatch"
 	"github.com/stratumn/go-indigocore/cs"
 	"github.com/stratumn/go-indigocore/monitoring"
 	"github.com/stratumn/go-indigocore/store"
 	"github.com/stratumn/go-indigocore/tmpop"
+	"github.com/stratumn/go-indigocore/tmpop/evidences"
 	"github.com/stratumn/go-indigocore/types"
 	"github.com/stratumn/go-indigocore/utils"
 
@@ -59,12 +62,43 @@ const (
 	ErrAlreadySubscribed = "already subscribed"
 )
 
+var (
+	// ErrInvalidProof is returned when the inclusion proof of a segment
+	// returned by TMPoP is invalid.
+	ErrInvalidProof = errors.New("invalid segment inclusion proof")
+
+	// ErrUnverifiedSegment is returned when a segment returned by TMPoP
+	// has no inclusion proof and RequireProofs is set.
+	ErrUnverifiedSegment = errors.New("segment has no inclusion proof")
+
+	// ErrNoVerifier is returned when inclusion proofs must be verified but
+	// no trusted validators are configured.
+	ErrNoVerifier = errors.New("inclusion proofs cannot be verified without a verifier")
+)
+
+// subscriberCount is used to give each TMStore its own Tendermint
+// subscriber, so that all of them receive new block events when they share
+// a client.
+var subscriberCount uint64
+
 // TMStore is the type that implements github.com/stratumn/go-indigocore/store.Adapter.
 type TMStore struct {
 	config          *Config
 	tmEventChan     chan interface{}
 	storeEventChans []chan *store.Event
 	tmClient        client.Client
+	subscriber      string
+
+	// eventsMutex serializes event delivery. lastEventHeight is the height
+	// of the last block whose events were delivered, so that blocks missed
+	// while disconnected from Tendermint can be replayed.
+	eventsMutex     sync.Mutex
+	eventsStarted   bool
+	lastEventHeight int64
+
+	// deliveryMutex keeps events ordered once they are sent to the
+	// subscribers outside of eventsMutex.
+	deliveryMutex sync.Mutex
 }
 
 // Config contains configuration options for the store.
@@ -74,6 +108,26 @@ type Config struct {
 
 	// A git commit hash that will be set in the store's information.
 	Commit string
+
+	// If set, the inclusion proofs of the segments returned by TMPoP are
+	// verified and segments with an invalid proof are rejected.
+	// It requires a Verifier.
+	VerifyProofs bool
+
+	// If set, segments returned by TMPoP without an inclusion proof are
+	// rejected. Recent segments have no proof until the blocks signing
+	// them are committed. It implies VerifyProofs.
+	RequireProofs bool
+
+	// The verifier checking that inclusion proofs are signed by trusted
+	// validators. The validator sets embedded in the proofs are ignored
+	// since anyone can forge them.
+	Verifier *evidences.Verifier
+
+	// If set, evidence is added through a TMPoP transaction, so that it is
+	// validated and replicated by all the nodes of the network. Otherwise
+	// it is only stored by the node TMStore is connected to.
+	ConsensusEvidence bool
 }
 
 // Info is the info returned by GetInfo.
@@ -88,8 +142,9 @@ type Info struct {
 // New creates a new instance of a TMStore.
 func New(config *Config, tmClient client.Client) *TMStore {
 	return &TMStore{
-		config:   config,
-		tmClient: tmClient,
+		config:     config,
+		tmClient:   tmClient,
+		subscriber: fmt.Sprintf("%s-%d", Name, atomic.AddUint64(&subscriberCount, 1)),
 	}
 }
 
@@ -104,6 +159,10 @@ func (t *TMStore) StartWebsocket(ctx context.Context) (err error) {
 		}
 	}
 
+	if err = t.startEvents(ctx); err != nil {
+		return
+	}
+
 	// TMPoP notifies us of store events that we forward to clients
 	t.tmEventChan = make(chan interface{}, 10)
 	go func() {
@@ -113,18 +172,44 @@ func (t *TMStore) StartWebsocket(ctx context.Context) (err error) {
 				break
 			}
 
-			go t.notifyStoreChans(context.Background())
+			t.notifyStoreChans(context.Background())
 		}
 	}()
 
-	if err = t.tmClient.Subscribe(ctx, Name, tmtypes.EventQueryNewBlock, t.tmEventChan); err != nil && err.Error() != ErrAlreadySubscribed {
+	if err = t.tmClient.Subscribe(ctx, t.subscriber, tmtypes.EventQueryNewBlock, t.tmEventChan); err != nil && err.Error() != ErrAlreadySubscribed {
 		return
 	}
 
+	// Deliver the events of the blocks committed while we were
+	// disconnected.
+	go t.notifyStoreChans(context.Background())
+
 	log.Info("Connected to TMPoP")
 	return nil
 }
 
+// startEvents sets the height from which events are delivered the first
+// time the websocket is started. Events of blocks committed before that
+// are not delivered.
+func (t *TMStore) startEvents(ctx context.Context) error {
+	t.eventsMutex.Lock()
+	defer t.eventsMutex.Unlock()
+
+	if t.eventsStarted {
+		return nil
+	}
+
+	info, err := t.tmClient.ABCIInfo()
+	if err != nil {
+		return err
+	}
+
+	t.lastEventHeight = info.Response.LastBlockHeight
+	t.eventsStarted = true
+
+	return nil
+}
+
 // RetryStartWebsocket starts the websocket client and retries on errors.
 func (t *TMStore) RetryStartWebsocket(ctx context.Context, interval time.Duration) error {
 	return utils.Retry(func(attempt int) (retry bool, err error) {
@@ -146,7 +231,7 @@ func (t *TMStore) StopWebsocket(ctx context.Context) (err error) {
 	defer monitoring.SetSpanStatusAndEnd(span, err)
 
 	// Note: no need to close t.tmEventChan, unsubscribing handles it
-	if err = t.tmClient.UnsubscribeAll(ctx, Name); err != nil {
+	if err = t.tmClient.UnsubscribeAll(ctx, t.subscriber); err != nil {
 		log.Warnf("Error unsubscribing to Tendermint events: %s", err.Error())
 		return
 	}
@@ -161,35 +246,84 @@ func (t *TMStore) StopWebsocket(ctx context.Context) (err error) {
 	return nil
 }
 
+// notifyStoreChans delivers the events of the blocks committed since the
+// last delivered block. If TMPoP can't be queried, the events are delivered
+// on the next attempt.
 func (t *TMStore) notifyStoreChans(ctx context.Context) {
 	ctx, span := trace.StartSpan(ctx, "tmstore/notifyStoreChans")
 	defer span.End()
 
-	var pendingEvents []*store.Event
-	response, err := t.sendQuery(ctx, tmpop.PendingEvents, nil)
-	if err != nil || response.Value == nil {
-		span.Annotate(nil, "No pending events in TMPoP")
-		log.Warn("Could not get pending events from TMPoP.")
+	var eventCount int64
+	defer func() {
+		span.AddAttributes(trace.Int64Attribute("EventCount", eventCount))
+	}()
+
+	for {
+		blocks, chans, err := t.nextEvents(ctx)
+		if err != nil {
+			span.SetStatus(trace.Status{Code: monitoring.Unavailable, Message: err.Error()})
+			log.Warnf("Could not get events from TMPoP: %s", err.Error())
+			return
+		}
+
+		// Events are sent without holding eventsMutex so that a slow
+		// subscriber doesn't block other deliveries from being fetched.
+		// deliveryMutex keeps them in order.
+		for _, block := range blocks {
+			for _, event := range block.Events {
+				for _, c := range chans {
+					c <- event
+				}
+			}
+
+			eventCount += int64(len(block.Events))
+		}
+		t.deliveryMutex.Unlock()
+
+		if len(blocks) < tmpop.MaxEventsBlocks {
+			return
+		}
 	}
+}
+
+// nextEvents fetches the next page of block events and marks them as
+// delivered. It returns a copy of the subscribers and, on success, leaves
+// deliveryMutex locked for the caller to release once events are sent.
+func (t *TMStore) nextEvents(ctx context.Context) ([]*tmpop.BlockEvents, []chan *store.Event, error) {
+	t.eventsMutex.Lock()
+	defer t.eventsMutex.Unlock()
 
-	err = json.Unmarshal(response.Value, &pendingEvents)
+	fromHeight := t.lastEventHeight + 1
+	response, err := t.sendQuery(ctx, tmpop.GetEvents, &tmpop.EventsFilter{FromHeight: fromHeight})
 	if err != nil {
-		span.Annotatef(nil, "TMPoP pending events could not be unmarshalled: %s", err.Error())
-		span.SetStatus(trace.Status{Code: monitoring.Internal})
-		log.Warn("TMPoP pending events could not be unmarshalled.")
+		return nil, nil, err
 	}
 
-	span.AddAttributes(trace.Int64Attribute("EventCount", int64(len(pendingEvents))))
+	var blocks []*tmpop.BlockEvents
+	if err := json.Unmarshal(response.Value, &blocks); err != nil {
+		return nil, nil, fmt.Errorf("TMPoP events could not be unmarshalled: %s", err.Error())
+	}
 
-	for _, event := range pendingEvents {
-		for _, c := range t.storeEventChans {
-			c <- event
+	if len(blocks) > 0 {
+		if blocks[0].Height > fromHeight {
+			log.Warnf("Events of blocks %d to %d were pruned by TMPoP and will not be delivered", fromHeight, blocks[0].Height-1)
 		}
+		t.lastEventHeight = blocks[len(blocks)-1].Height
 	}
+
+	chans := make([]chan *store.Event, len(t.storeEventChans))
+	copy(chans, t.storeEventChans)
+
+	t.deliveryMutex.Lock()
+
+	return blocks, chans, nil
 }
 
 // AddStoreEventChannel implements github.com/stratumn/go-indigocore/store.Adapter.AddStoreEventChannel.
 func (t *TMStore) AddStoreEventChannel(storeChan chan *store.Event) {
+	t.eventsMutex.Lock()
+	defer t.eventsMutex.Unlock()
+
 	t.storeEventChans = append(t.storeEventChans, storeChan)
 }
 
@@ -234,6 +368,18 @@ func (t *TMStore) CreateLink(ctx context.Context, link *cs.Link) (*types.Bytes32
 
 // AddEvidence implements github.com/stratumn/go-indigocore/store.EvidenceWriter.AddEvidence.
 func (t *TMStore) AddEvidence(ctx context.Context, linkHash *types.Bytes32, evidence *cs.Evidence) error {
+	if t.config.ConsensusEvidence {
+		// The saved evidence event is sent by TMPoP once the block
+		// containing the transaction is committed.
+		tx := &tmpop.Tx{
+			TxType:   tmpop.AddLinkEvidence,
+			LinkHash: linkHash,
+			Evidence: evidence,
+		}
+		_, err := t.broadcastTx(ctx, tx)
+		return err
+	}
+
 	// Adding an external evidence does not require consensus
 	// So it will not go through a blockchain transaction, but will rather
 	// be stored in TMPoP's store directly
@@ -255,7 +401,12 @@ func (t *TMStore) AddEvidence(ctx context.Context, linkHash *types.Bytes32, evid
 	evidenceEvent := store.NewSavedEvidences()
 	evidenceEvent.AddSavedEvidence(linkHash, evidence)
 
-	for _, c := range t.storeEventChans {
+	t.eventsMutex.Lock()
+	chans := make([]chan *store.Event, len(t.storeEventChans))
+	copy(chans, t.storeEventChans)
+	t.eventsMutex.Unlock()
+
+	for _, c := range chans {
 		c <- evidenceEvent
 	}
 
@@ -300,10 +451,55 @@ func (t *TMStore) GetSegment(ctx context.Context, linkHash *types.Bytes32) (segm
 	// Return nil when no segment has been found (and not an empty segment)
 	if segment.IsEmpty() {
 		segment = nil
+		return
 	}
+
+	if t.config.VerifyProofs || t.config.RequireProofs {
+		if err = t.verifySegment(linkHash, segment, response.Proof); err != nil {
+			segment = nil
+		}
+	}
+
 	return
 }
 
+// verifySegment checks that a segment matches the requested link hash and
+// that its inclusion proof is valid.
+func (t *TMStore) verifySegment(linkHash *types.Bytes32, segment *cs.Segment, proofBytes []byte) error {
+	got, err := segment.Link.Hash()
+	if err != nil {
+		return err
+	}
+	if *got != *linkHash {
+		return ErrInvalidProof
+	}
+
+	if len(proofBytes) == 0 {
+		if t.config.RequireProofs {
+			return ErrUnverifiedSegment
+		}
+		return nil
+	}
+
+	if t.config.Verifier == nil {
+		return ErrNoVerifier
+	}
+
+	proof := &evidences.TendermintProof{}
+	if err := json.Unmarshal(proofBytes, proof); err != nil {
+		return ErrInvalidProof
+	}
+	if err := t.config.Verifier.Verify(proof, linkHash); err != nil {
+		log.WithFields(log.Fields{
+			"linkHash": linkHash.String(),
+			"error":    err,
+		}).Warn("Invalid segment inclusion proof")
+		return ErrInvalidProof
+	}
+
+	return nil
+}
+
 // FindSegments implements github.com/stratumn/go-indigocore/store.SegmentReader.FindSegments.
 func (t *TMStore) FindSegments(ctx context.Context, filter *store.SegmentFilter) (segmentSlice cs.SegmentSlice, err error) {
 	response, err := t.sendQuery(ctx, tmpop.FindSegments, filter)
@@ -332,7 +528,7 @@ func (t *TMStore) GetMapIDs(ctx context.Context, filter *store.MapFilter) (ids [
 
 // NewBatch implements github.com/stratumn/go-indigocore/store.Adapter.NewBatch.
 func (t *TMStore) NewBatch(ctx context.Context) (store.Batch, error) {
-	return bufferedbatch.NewBatch(ctx, t), nil
+	return NewBatch(ctx, t), nil
 }
 
 func (t *TMStore) broadcastTx(ctx context.Context, tx *tmpop.Tx) (*ctypes.ResultBroadcastTxCommit, error) {
@@ -24,15 +24,18 @@ import (
 	"time"
 
 	"github.com/stratumn/go-crypto/keys"
+	"github.com/stratumn/go-indigocore/cs"
 	"github.com/stratumn/go-indigocore/cs/cstesting"
 	"github.com/stratumn/go-indigocore/jsonhttp"
 	"github.com/stratumn/go-indigocore/store"
 	"github.com/stratumn/go-indigocore/store/storetestcases"
 	"github.com/stratumn/go-indigocore/tmpop"
+	"github.com/stratumn/go-indigocore/tmpop/evidences"
 	"github.com/stratumn/go-indigocore/validator"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	abci "github.com/tendermint/abci/types"
+	tmtypes "github.com/tendermint/tendermint/types"
 )
 
 const itPrivKey = `-----BEGIN ED25519 PRIVATE KEY-----
@@ -149,6 +152,30 @@ func TestTMStore(t *testing.T) {
 			assert.Equal(t, http.StatusBadRequest, errHTTP.Status())
 		})
 
+		t.Run("CreateLinks reports every rejected link", func(t *testing.T) {
+			ITPrivateKey, _, _ := keys.ParseSecretKey([]byte(itPrivKey))
+			newLink := func(state map[string]interface{}) *cs.Link {
+				return cstesting.NewLinkBuilder().
+					WithProcess("testProcess").
+					WithPrevLinkHash("").
+					WithType("init").
+					WithState(state).
+					SignWithKey(ITPrivateKey).
+					Build()
+			}
+			badState := map[string]interface{}{"string": 42}
+			links := []*cs.Link{newLink(badState), newLink(state), newLink(badState)}
+
+			results, err := tmstore.CreateLinks(context.Background(), links, store.WriteModeCommit)
+			require.NoError(t, err, "CreateLinks()")
+			require.Len(t, results, 3)
+			assert.Equal(t, store.LinkRejected, results[0].Status)
+			assert.NotEmpty(t, results[0].Error)
+			assert.Equal(t, store.LinkCommitted, results[1].Status)
+			assert.Equal(t, store.LinkRejected, results[2].Status)
+			assert.NotEmpty(t, results[2].Error)
+		})
+
 		t.Run("Validation rules update succeeds", func(t *testing.T) {
 			prevLink := cstesting.NewLinkBuilder().
 				WithProcess("testProcess").
@@ -184,6 +211,64 @@ func TestTMStore(t *testing.T) {
 		})
 	})
 
+	t.Run("Events", func(t *testing.T) {
+		ctx := context.Background()
+
+		newClient := func() (*TMStore, chan *store.Event) {
+			c := NewTestClient()
+			events := make(chan *store.Event, 10)
+			c.AddStoreEventChannel(events)
+			require.NoError(t, c.StartWebsocket(ctx))
+			return c, events
+		}
+
+		waitSavedLink := func(t *testing.T, events chan *store.Event, linkHash string) {
+			for {
+				select {
+				case event := <-events:
+					if event.EventType != store.SavedLinks {
+						continue
+					}
+					for _, link := range event.Data.([]*cs.Link) {
+						if lh, _ := link.HashString(); lh == linkHash {
+							return
+						}
+					}
+				case <-time.After(10 * time.Second):
+					t.Fatalf("Saved link event for %s not received", linkHash)
+				}
+			}
+		}
+
+		client1, events1 := newClient()
+		defer client1.tmClient.UnsubscribeAll(ctx, client1.subscriber)
+		client2, events2 := newClient()
+		defer client2.tmClient.UnsubscribeAll(ctx, client2.subscriber)
+
+		t.Run("Every client receives events", func(t *testing.T) {
+			link := cstesting.RandomLink()
+			linkHash, err := client1.CreateLink(ctx, link)
+			require.NoError(t, err)
+
+			waitSavedLink(t, events1, linkHash.String())
+			waitSavedLink(t, events2, linkHash.String())
+		})
+
+		t.Run("Events missed while disconnected are replayed", func(t *testing.T) {
+			// Stopping the websocket would stop the test node's event
+			// bus, so we only unsubscribe.
+			require.NoError(t, client2.tmClient.UnsubscribeAll(ctx, client2.subscriber))
+
+			link := cstesting.RandomLink()
+			linkHash, err := client1.CreateLink(ctx, link)
+			require.NoError(t, err)
+			waitSavedLink(t, events1, linkHash.String())
+
+			require.NoError(t, client2.StartWebsocket(ctx))
+			waitSavedLink(t, events2, linkHash.String())
+		})
+	})
+
 	// TestWebSocket tests how the web socket with Tendermint behaves
 	t.Run("Websocket", func(t *testing.T) {
 		t.Run("Start and stop websocket", func(t *testing.T) {
@@ -211,3 +296,38 @@ func TestTMStore(t *testing.T) {
 		})
 	})
 }
+
+func TestVerifySegment(t *testing.T) {
+	link := cstesting.RandomLink()
+	linkHash, _ := link.Hash()
+	segment := link.Segmentify()
+
+	t.Run("Accepts segments without proof", func(t *testing.T) {
+		s := New(&Config{VerifyProofs: true}, nil)
+		assert.NoError(t, s.verifySegment(linkHash, segment, nil))
+	})
+
+	t.Run("Refuses segments without proof", func(t *testing.T) {
+		s := New(&Config{RequireProofs: true}, nil)
+		assert.Equal(t, ErrUnverifiedSegment, s.verifySegment(linkHash, segment, nil))
+	})
+
+	t.Run("Refuses segments with another link hash", func(t *testing.T) {
+		s := New(&Config{VerifyProofs: true}, nil)
+		otherHash, _ := cstesting.RandomLink().Hash()
+		assert.Equal(t, ErrInvalidProof, s.verifySegment(otherHash, segment, nil))
+	})
+
+	verifier := evidences.NewVerifier("testchain", 1, &tmtypes.ValidatorSet{})
+
+	t.Run("Refuses invalid proofs", func(t *testing.T) {
+		s := New(&Config{VerifyProofs: true, Verifier: verifier}, nil)
+		assert.Equal(t, ErrInvalidProof, s.verifySegment(linkHash, segment, []byte(`{"block_height":3}`)))
+		assert.Equal(t, ErrInvalidProof, s.verifySegment(linkHash, segment, []byte("zou")))
+	})
+
+	t.Run("Refuses proofs without a verifier", func(t *testing.T) {
+		s := New(&Config{VerifyProofs: true}, nil)
+		assert.Equal(t, ErrNoVerifier, s.verifySegment(linkHash, segment, []byte(`{"block_height":3}`)))
+	})
+}
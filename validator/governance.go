@@ -31,7 +31,7 @@ import (
 
 const (
 	// GovernanceProcessName is the process name used for governance information storage
-	governanceProcessName = "_governance"
+	GovernanceProcessName = "_governance"
 
 	// ValidatorTag is the tag used to find validators in storage
 	validatorTag = "validators"
@@ -104,6 +104,12 @@ func (m *GovernanceManager) sendValidators() {
 	for _, v := range m.validators {
 		v4ch = append(v4ch, v...)
 	}
+	// Replace a pending update that hasn't been consumed yet: only the
+	// latest validators matter.
+	select {
+	case <-m.validatorChan:
+	default:
+	}
 	m.validatorChan <- NewMultiValidator(v4ch)
 }
 
@@ -112,7 +118,7 @@ func (m *GovernanceManager) getAllProcesses(ctx context.Context) []string {
 	for offset := 0; offset >= 0; {
 		segments, err := m.adapter.FindSegments(ctx, &store.SegmentFilter{
 			Pagination: store.Pagination{Offset: offset, Limit: store.MaxLimit},
-			Process:    governanceProcessName,
+			Process:    GovernanceProcessName,
 			Tags:       []string{validatorTag},
 		})
 		if err != nil {
@@ -142,7 +148,7 @@ func (m *GovernanceManager) getAllProcesses(ctx context.Context) []string {
 func (m *GovernanceManager) getValidators(ctx context.Context, process string) []Validator {
 	segments, err := m.adapter.FindSegments(ctx, &store.SegmentFilter{
 		Pagination: defaultPagination,
-		Process:    governanceProcessName,
+		Process:    GovernanceProcessName,
 		Tags:       []string{process, validatorTag},
 	})
 	if err != nil || len(segments) == 0 {
@@ -154,27 +160,39 @@ func (m *GovernanceManager) getValidators(ctx context.Context, process string) [
 	if !ok || !ok2 {
 		return nil
 	}
-	rawPKI, ok := pki.(json.RawMessage)
-	rawTypes, ok2 := types.(json.RawMessage)
-	if !ok || !ok2 {
+	// Depending on the store, the rules are either raw JSON or decoded JSON.
+	rawPKI, err := json.Marshal(pki)
+	if err != nil {
+		return nil
+	}
+	rawTypes, err := json.Marshal(types)
+	if err != nil {
 		return nil
 	}
 	v, err := LoadProcessRules(processesRules{
-		"process": rulesSchema{
+		process: rulesSchema{
 			PKI:   rawPKI,
 			Types: rawTypes,
 		},
-	}, m.validationCfg.PluginsPath, nil)
+	}, m.pluginsPath(), nil)
 	if err != nil {
-		return v
+		log.Warnf("Cannot load validation rules of process %s from store: %s", process, err)
+		return nil
 	}
-	return nil
+	return v
+}
+
+func (m *GovernanceManager) pluginsPath() string {
+	if m.validationCfg == nil {
+		return ""
+	}
+	return m.validationCfg.PluginsPath
 }
 
 func (m *GovernanceManager) updateValidatorInStore(ctx context.Context, process string, schema rulesSchema, validators []Validator) []Validator {
 	segments, err := m.adapter.FindSegments(ctx, &store.SegmentFilter{
 		Pagination: defaultPagination,
-		Process:    governanceProcessName,
+		Process:    GovernanceProcessName,
 		Tags:       []string{process, validatorTag},
 	})
 	if err != nil {
@@ -247,7 +265,7 @@ func (m *GovernanceManager) uploadValidator(ctx context.Context, process string,
 		"types": schema.Types,
 	}
 	linkMeta := cs.LinkMeta{
-		Process:      governanceProcessName,
+		Process:      GovernanceProcessName,
 		MapID:        mapID,
 		PrevLinkHash: prevLinkHash,
 		Priority:     priority,
@@ -268,6 +286,67 @@ func (m *GovernanceManager) uploadValidator(ctx context.Context, process string,
 	return nil
 }
 
+// GovernanceLink builds the link that updates the validation rules of a
+// process to the given rules, after checking that they can be loaded.
+// The link only depends on the rules and on the previous governance link of
+// the process, so that it can be built the same way by every node of a
+// network reaching consensus on rules updates.
+func (m *GovernanceManager) GovernanceLink(ctx context.Context, reader store.SegmentReader, process string, pki, types json.RawMessage) (*cs.Link, error) {
+	if process == "" {
+		return nil, errors.New("process is missing")
+	}
+
+	_, err := LoadProcessRules(processesRules{
+		process: rulesSchema{
+			PKI:   pki,
+			Types: types,
+		},
+	}, m.pluginsPath(), nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid validation rules for process %s", process)
+	}
+
+	segments, err := reader.FindSegments(ctx, &store.SegmentFilter{
+		Pagination: defaultPagination,
+		Process:    GovernanceProcessName,
+		Tags:       []string{process, validatorTag},
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot retrieve governance segments of process %s", process)
+	}
+
+	linkMeta := cs.LinkMeta{
+		Process: GovernanceProcessName,
+		MapID:   process,
+		Tags:    []string{process, validatorTag},
+	}
+	if len(segments) > 0 {
+		prevLink := segments[0].Link
+		linkMeta.MapID = prevLink.Meta.MapID
+		linkMeta.Priority = prevLink.Meta.Priority + 1.
+		if linkMeta.PrevLinkHash, err = prevLink.HashString(); err != nil {
+			return nil, errors.Wrapf(err, "cannot get previous hash for process governance %s", process)
+		}
+	}
+
+	return &cs.Link{
+		State: map[string]interface{}{
+			"pki":   pki,
+			"types": types,
+		},
+		Meta:       linkMeta,
+		Signatures: cs.Signatures{},
+	}, nil
+}
+
+// ReloadProcess reloads the validation rules of a process from the store.
+// It should be called once a governance link created by GovernanceLink is
+// stored.
+func (m *GovernanceManager) ReloadProcess(ctx context.Context, process string) {
+	m.validators[process] = m.getValidators(ctx, process)
+	m.sendValidators()
+}
+
 // UpdateValidators will replace validator if a new one is available
 func (m *GovernanceManager) UpdateValidators(ctx context.Context, v *Validator) bool {
 	if m.validatorWatcher != nil {
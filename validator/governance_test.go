@@ -114,7 +114,7 @@ func TestGovernanceCreation(t *testing.T) {
 func checkLastValidatorPriority(t *testing.T, a store.Adapter, process string, expected float64) {
 	segs, err := a.FindSegments(context.Background(), &store.SegmentFilter{
 		Pagination: defaultPagination,
-		Process:    governanceProcessName,
+		Process:    GovernanceProcessName,
 		Tags:       []string{process, validatorTag},
 	})
 	assert.NoError(t, err, "FindSegment(governance) should sucess")
@@ -177,6 +177,49 @@ func TestGovernanceUpdate(t *testing.T) {
 	})
 }
 
+func TestGetValidators(t *testing.T) {
+	t.Run("Decoded rules", func(t *testing.T) {
+		a := dummystore.New(nil)
+		populateStoreWithValidData(t, a)
+		gov, err := NewGovernanceManager(context.Background(), a, nil)
+		require.NoError(t, err, "Gouvernance is initialized by store")
+
+		validators := gov.getValidators(context.Background(), "chat")
+		assert.NotEmpty(t, validators, "Validators loaded from store")
+	})
+
+	t.Run("Raw rules", func(t *testing.T) {
+		a := dummystore.New(nil)
+		link := cstesting.NewLinkBuilder().
+			WithProcess(GovernanceProcessName).
+			WithTags("chat", validatorTag).
+			WithState(map[string]interface{}{
+				"pki":   json.RawMessage(ValidChatJSONPKIConfig),
+				"types": json.RawMessage(ValidChatJSONTypesConfig),
+			}).
+			Build()
+		_, err := a.CreateLink(context.Background(), link)
+		require.NoError(t, err)
+		gov, err := NewGovernanceManager(context.Background(), a, &Config{})
+		require.NoError(t, err, "Gouvernance is initialized by store")
+
+		validators := gov.getValidators(context.Background(), "chat")
+		assert.NotEmpty(t, validators, "Validators loaded from store")
+	})
+
+	t.Run("Invalid rules", func(t *testing.T) {
+		a := dummystore.New(nil)
+		link := createGovernanceLink("chat", json.RawMessage(`{"Bob":{"keys":["invalid"]}}`), json.RawMessage(ValidChatJSONTypesConfig))
+		_, err := a.CreateLink(context.Background(), link)
+		require.NoError(t, err)
+		gov, err := NewGovernanceManager(context.Background(), a, &Config{})
+		require.NoError(t, err, "Gouvernance is initialized by store")
+
+		validators := gov.getValidators(context.Background(), "chat")
+		assert.Nil(t, validators, "Invalid rules are not loaded")
+	})
+}
+
 func TestGetAllProcesses(t *testing.T) {
 	t.Run("No process", func(t *testing.T) {
 		a := new(storetesting.MockAdapter)
@@ -199,7 +242,7 @@ func TestGetAllProcesses(t *testing.T) {
 		a := dummystore.New(nil)
 		for i := 0; i < store.MaxLimit+42; i++ {
 			link := cstesting.NewLinkBuilder().
-				WithProcess(governanceProcessName).
+				WithProcess(GovernanceProcessName).
 				WithTags(fmt.Sprintf("p%d", i), validatorTag).
 				Build()
 			_, err := a.CreateLink(context.Background(), link)
@@ -244,7 +287,7 @@ func createGovernanceLink(process string, pki, types json.RawMessage) *cs.Link {
 	state["types"] = unmarshalledData
 
 	link := cstesting.NewLinkBuilder().
-		WithProcess(governanceProcessName).
+		WithProcess(GovernanceProcessName).
 		WithTags(process, validatorTag).
 		WithState(state).
 		Build()